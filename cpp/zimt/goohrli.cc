@@ -30,6 +30,14 @@
 #include "zimt/visqol.h"
 #include "zimt/zimtohrli.h"
 
+#ifndef ZIMTOHRLI_COMMIT
+#define ZIMTOHRLI_COMMIT "unknown"
+#endif
+
+#ifndef ZIMTOHRLI_BUILD_FLAGS
+#define ZIMTOHRLI_BUILD_FLAGS "unknown"
+#endif
+
 int NumLoudnessAFParams() {
   CHECK_EQ(NUM_LOUDNESS_A_F_PARAMS, zimtohrli::Loudness{}.a_f_params.size());
   return NUM_LOUDNESS_A_F_PARAMS;
@@ -104,6 +112,41 @@ Analysis Analyze(Zimtohrli zimtohrli, float* data, int size) {
 
 void FreeAnalysis(Analysis a) { delete static_cast<zimtohrli::Analysis*>(a); }
 
+int AnalysisNumSteps(Analysis a) {
+  return static_cast<zimtohrli::Analysis*>(a)->spectrogram.shape()[0];
+}
+
+int AnalysisNumChannels(Analysis a) {
+  return static_cast<zimtohrli::Analysis*>(a)->spectrogram.shape()[1];
+}
+
+void GetAnalysisSpectrogram(Analysis a, float* data) {
+  const hwy::AlignedNDArray<float, 2>& spectrogram =
+      static_cast<zimtohrli::Analysis*>(a)->spectrogram;
+  const size_t num_channels = spectrogram.shape()[1];
+  for (size_t step_index = 0; step_index < spectrogram.shape()[0];
+       ++step_index) {
+    hwy::CopyBytes(spectrogram[{step_index}].data(),
+                   data + step_index * num_channels,
+                   num_channels * sizeof(float));
+  }
+}
+
+Analysis CreateAnalysis(const float* data, int num_steps, int num_channels) {
+  hwy::AlignedNDArray<float, 2> spectrogram(
+      {static_cast<size_t>(num_steps), static_cast<size_t>(num_channels)});
+  for (size_t step_index = 0; step_index < spectrogram.shape()[0];
+       ++step_index) {
+    hwy::CopyBytes(data + step_index * num_channels,
+                   spectrogram[{step_index}].data(),
+                   num_channels * sizeof(float));
+  }
+  return new zimtohrli::Analysis{
+      .energy_channels_db = hwy::AlignedNDArray<float, 2>({0, 0}),
+      .partial_energy_channels_db = hwy::AlignedNDArray<float, 2>({0, 0}),
+      .spectrogram = std::move(spectrogram)};
+}
+
 float AnalysisDistance(Zimtohrli zimtohrli, Analysis a, Analysis b) {
   zimtohrli::Zimtohrli* z = static_cast<zimtohrli::Zimtohrli*>(zimtohrli);
   zimtohrli::Analysis* analysis_a = static_cast<zimtohrli::Analysis*>(a);
@@ -189,4 +232,7 @@ MOSResult MOS(const ViSQOL v, float sample_rate, const float* reference,
     return MOSResult{.MOS = 0.0,
                      .Status = static_cast<int>(result.status().code())};
   }
-}
\ No newline at end of file
+}
+const char* ZimtohrliCommit() { return ZIMTOHRLI_COMMIT; }
+
+const char* ZimtohrliBuildFlags() { return ZIMTOHRLI_BUILD_FLAGS; }
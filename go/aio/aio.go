@@ -19,10 +19,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/zimtohrli/go/audio"
 )
@@ -35,27 +36,83 @@ func Fetch(path string, dir string) (string, error) {
 	return Copy(path, dir)
 }
 
-// Load loads audio from an ffmpeg-decodable file from a path (which may be a URL).
+// Load loads audio from an ffmpeg-decodable file from a path (which may be a http(s)://, gs://, or s3:// URL, or "-" for stdin).
 func Load(path string) (*audio.Audio, error) {
 	return LoadAtRate(path, 48000)
 }
 
-// LoadAtRate loads audio from an ffmpeg-decodable file from a path (which may be a URL) and returns it at the given sample rate.
+// LoadAtRate loads audio from an ffmpeg-decodable file from a path (which may be a http(s)://, gs://, or s3:// URL, or "-" for stdin) and returns it at the given sample rate.
 func LoadAtRate(path string, rate int) (*audio.Audio, error) {
-	cmd := exec.Command("ffmpeg", "-i", path, "-vn", "-acodec", "pcm_s16le", "-f", "wav", "-ar", fmt.Sprint(rate), "-")
-	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = stdout, stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("while executing %v: %v\n%v", cmd, err, stderr.String())
+	return decode(nil, 0, path, rate)
+}
+
+// RawFormat describes the layout of headerless PCM audio.
+type RawFormat struct {
+	// SampleFormat is the ffmpeg name of the sample format, e.g. s16le or f32le.
+	SampleFormat string
+	// Rate is the sample rate of the audio.
+	Rate int
+	// Channels is the number of interleaved channels in the audio.
+	Channels int
+}
+
+func (r RawFormat) args() []string {
+	return []string{"-f", r.SampleFormat, "-ar", fmt.Sprint(r.Rate), "-ac", fmt.Sprint(r.Channels)}
+}
+
+// LoadRawAtRate loads headerless PCM audio in the given format from a path (which may be a http(s)://, gs://, or s3:// URL, or "-" for stdin) and returns it at the given sample rate.
+func LoadRawAtRate(path string, format RawFormat, rate int) (*audio.Audio, error) {
+	if format.SampleFormat == "" || format.Rate <= 0 || format.Channels <= 0 {
+		return nil, fmt.Errorf("incomplete raw format %+v", format)
+	}
+	return decode(format.args(), format.Rate, path, rate)
+}
+
+// decode decodes the audio at path, with the given sample rate, or 0 if unknown, to audio at rate.
+func decode(inputArgs []string, sourceRate int, path string, rate int) (*audio.Audio, error) {
+	start := time.Now()
+	path, err := Localize(path)
+	if err != nil {
+		return nil, err
+	}
+	timing := DecodeTiming{Path: path, Fetch: time.Since(start)}
+	start = time.Now()
+	if timing.Resampling, err = checkResampling(path, sourceRate, rate); err != nil {
+		return nil, err
+	}
+	timing.Probe = time.Since(start)
+	start = time.Now()
+	args := append(append([]string{}, inputArgs...), "-i", path, "-vn", "-acodec", "pcm_s16le", "-f", "wav")
+	args = append(append(args, resamplerArgs()...), "-ar", fmt.Sprint(rate), "-")
+	var stdin io.Reader
+	if path == "-" {
+		stdin = os.Stdin
+	}
+	stdout := &bytes.Buffer{}
+	if err := runFFmpeg(stdin, stdout, args...); err != nil {
+		if stdin == nil && len(inputArgs) == 0 {
+			if probe, probeErr := Probe(path); probeErr == nil {
+				return nil, fmt.Errorf("while decoding %q (%v): %w", path, probe, err)
+			}
+		}
+		return nil, err
 	}
 	w, err := audio.ReadWAV(stdout)
+	if err != nil {
+		return nil, fmt.Errorf("while reading WAV decoded from %q: %v", path, err)
+	}
+	result, err := w.Audio()
 	if err != nil {
 		return nil, err
 	}
-	return w.Audio()
+	timing.Decode = time.Since(start)
+	if OnDecode != nil {
+		OnDecode(timing)
+	}
+	return result, nil
 }
 
-// Copy copies any file from a path (which may be a URL) and returns a path inside dir containing the file.
+// Copy copies any file from a path (which may be a http(s)://, gs://, or s3:// URL) and returns a path inside dir containing the file.
 func Copy(path string, dir string) (string, error) {
 	// This function uses ffmpeg since it both verifies that the file is a proper media file, and handles
 	// URLs and paths exactly like the other functions in this package.
@@ -64,25 +121,47 @@ func Copy(path string, dir string) (string, error) {
 		return "", err
 	}
 	outFile.Close()
-	cmd := exec.Command("ffmpeg", "-y", "-i", path, "-vn", "-acodec", "copy", outFile.Name())
-	ffmpegResult, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("trying to execute %v: %v\n%s", cmd, err, ffmpegResult)
+	if path, err = Localize(path); err != nil {
+		return "", err
+	}
+	if err := runFFmpeg(nil, nil, "-y", "-i", path, "-vn", "-acodec", "copy", outFile.Name()); err != nil {
+		return "", err
 	}
 	return filepath.Rel(dir, outFile.Name())
 }
 
-// Recode copies an ffmpeg-decodable file from path (which may be a URL) and returns a path inside dir containing a FLAC encoded version of it.
+// Recode copies an ffmpeg-decodable file from path (which may be a http(s)://, gs://, or s3:// URL) and returns a path inside dir containing a FLAC encoded version of it.
 func Recode(path string, dir string) (string, error) {
 	flacFile, err := os.CreateTemp(dir, "zimtohrli.go.aio.Recode.*.flac")
 	if err != nil {
 		return "", err
 	}
 	flacFile.Close()
-	cmd := exec.Command("ffmpeg", "-y", "-i", path, "-vn", "-acodec", "flac", "-f", "flac", flacFile.Name())
-	ffmpegResult, err := cmd.CombinedOutput()
+	if path, err = Localize(path); err != nil {
+		return "", err
+	}
+	if err := runFFmpeg(nil, nil, "-y", "-i", path, "-vn", "-acodec", "flac", "-f", "flac", flacFile.Name()); err != nil {
+		return "", err
+	}
+	return filepath.Rel(dir, flacFile.Name())
+}
+
+// RecodeRaw copies a headerless PCM file in the given format from path (which may be a http(s)://, gs://, or s3:// URL) and returns a path inside dir containing a FLAC encoded version of it.
+func RecodeRaw(path string, format RawFormat, dir string) (string, error) {
+	if format.SampleFormat == "" || format.Rate <= 0 || format.Channels <= 0 {
+		return "", fmt.Errorf("incomplete raw format %+v", format)
+	}
+	flacFile, err := os.CreateTemp(dir, "zimtohrli.go.aio.RecodeRaw.*.flac")
 	if err != nil {
-		return "", fmt.Errorf("trying to execute %v: %v\n%s", cmd, err, ffmpegResult)
+		return "", err
+	}
+	flacFile.Close()
+	if path, err = Localize(path); err != nil {
+		return "", err
+	}
+	args := append(format.args(), "-y", "-i", path, "-vn", "-acodec", "flac", "-f", "flac", flacFile.Name())
+	if err := runFFmpeg(nil, nil, args...); err != nil {
+		return "", err
 	}
 	return filepath.Rel(dir, flacFile.Name())
 }
@@ -97,21 +176,64 @@ func DumpWAV(audio *audio.Audio) (string, error) {
 	return wavFile.Name(), Save(audio, wavFile.Name())
 }
 
-// Save stores the audio in the ffmpeg-encodable path.
-func Save(audio *audio.Audio, path string) error {
+// interleave returns the samples of the audio as interleaved little endian float32 frames.
+func interleave(a *audio.Audio) (*bytes.Buffer, error) {
 	buf := &bytes.Buffer{}
-	for sampleIndex := range audio.Samples[0] {
-		for channelIndex := range audio.Samples {
-			if err := binary.Write(buf, binary.LittleEndian, audio.Samples[channelIndex][sampleIndex]); err != nil {
-				return err
+	for sampleIndex := range a.Samples[0] {
+		for channelIndex := range a.Samples {
+			if err := binary.Write(buf, binary.LittleEndian, a.Samples[channelIndex][sampleIndex]); err != nil {
+				return nil, err
 			}
 		}
 	}
-	cmd := exec.Command("ffmpeg", "-y", "-ac", fmt.Sprint(len(audio.Samples)), "-f", "f32le", "-ar", fmt.Sprint(int(audio.Rate)), "-i", "-", path)
-	cmd.Stdin = buf
-	ffmpegResult, err := cmd.CombinedOutput()
+	return buf, nil
+}
+
+// Save stores the audio in a path.
+//
+// Paths ending with .wav get a 16 bit PCM WAV file, all other paths are encoded by ffmpeg
+// according to their extension, e.g. .flac or .ogg.
+func Save(audio *audio.Audio, path string) error {
+	if strings.ToLower(filepath.Ext(path)) == ".wav" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := audio.WAV().Write(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	buf, err := interleave(audio)
 	if err != nil {
-		return fmt.Errorf("trying to execute %v: %v\n%s", cmd, err, ffmpegResult)
+		return err
+	}
+	return runFFmpeg(buf, nil, "-y", "-ac", fmt.Sprint(len(audio.Samples)), "-f", "f32le", "-ar", fmt.Sprint(int(audio.Rate)), "-i", "-", path)
+}
+
+// RoundTrip returns the audio after encoding it with ffmpeg and decoding the result at the original sample rate.
+//
+// The first of the encoderArgs is the ffmpeg audio encoder, and the rest are ffmpeg output options, e.g.
+// []string{"libopus", "-b:a", "24k"}.
+func RoundTrip(a *audio.Audio, encoderArgs []string) (*audio.Audio, error) {
+	if len(encoderArgs) == 0 {
+		return nil, fmt.Errorf("no encoder provided")
+	}
+	encodedFile, err := os.CreateTemp(os.TempDir(), "zimtohrli.go.aio.RoundTrip.*.mka")
+	if err != nil {
+		return nil, err
+	}
+	encodedFile.Close()
+	defer os.Remove(encodedFile.Name())
+	buf, err := interleave(a)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-y", "-ac", fmt.Sprint(len(a.Samples)), "-f", "f32le", "-ar", fmt.Sprint(int(a.Rate)), "-i", "-", "-vn", "-c:a", encoderArgs[0]}
+	args = append(append(args, encoderArgs[1:]...), "-f", "matroska", encodedFile.Name())
+	if err := runFFmpeg(buf, nil, args...); err != nil {
+		return nil, err
 	}
-	return nil
+	return LoadAtRate(encodedFile.Name(), int(a.Rate))
 }
@@ -0,0 +1,127 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// MaxCacheBytes, if positive, is the max total size of the files in CacheDir.
+//
+// Before downloading a file, Localize removes the least recently used files until the cache is below the limit.
+// Files used within the last minCacheAge may still be decoded, so while only such files remain Localize pauses
+// until they can be removed.
+var MaxCacheBytes int64
+
+// minCacheAge is the min time since a cached file was used before it can be removed.
+const minCacheAge = time.Minute
+
+// cacheLock serializes pruning of CacheDir.
+var cacheLock sync.Mutex
+
+// cachedFile is a file in CacheDir.
+type cachedFile struct {
+	path string
+	size int64
+	used time.Time
+	// partial is whether the file is a download in progress, which is never removed.
+	partial bool
+}
+
+// cacheContents returns the files in CacheDir and their total size.
+func cacheContents() ([]cachedFile, int64, error) {
+	entries, err := os.ReadDir(CacheDir)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	files := []cachedFile{}
+	total := int64(0)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, cachedFile{
+			path:    filepath.Join(CacheDir, entry.Name()),
+			size:    info.Size(),
+			used:    info.ModTime(),
+			partial: strings.HasSuffix(entry.Name(), ".tmp"),
+		})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].used.Before(files[j].used)
+	})
+	return files, total, nil
+}
+
+// pruneCache removes the least recently used files in CacheDir until their total size is below MaxCacheBytes.
+func pruneCache() error {
+	if MaxCacheBytes <= 0 {
+		return nil
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	warned := false
+	for {
+		files, total, err := cacheContents()
+		if err != nil {
+			return err
+		}
+		wait := time.Duration(0)
+		for _, file := range files {
+			if total < MaxCacheBytes {
+				break
+			}
+			if file.partial {
+				continue
+			}
+			if age := time.Since(file.used); age < minCacheAge {
+				wait = minCacheAge - age
+				break
+			}
+			if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			total -= file.size
+		}
+		if total < MaxCacheBytes {
+			return nil
+		}
+		if wait == 0 {
+			// Only downloads in progress remain.
+			wait = time.Second
+		}
+		if !warned {
+			logging.Warningf("%v bytes of downloaded audio in %q exceed the max cache size of %v bytes, pausing downloads until files can be removed. Increase the max cache size, or set $TMPDIR to a larger disk, to avoid this.", total, CacheDir, MaxCacheBytes)
+			warned = true
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// FFmpeg is the ffmpeg binary used by this package.
+//
+// Defaults to $ZIMTOHRLI_FFMPEG, or ffmpeg in $PATH if that isn't set.
+var FFmpeg = ffmpegFromEnv()
+
+// FFmpegArgs are extra arguments given to ffmpeg before the arguments added by this package.
+//
+// Defaults to the whitespace separated words in $ZIMTOHRLI_FFMPEG_ARGS.
+var FFmpegArgs = strings.Fields(os.Getenv("ZIMTOHRLI_FFMPEG_ARGS"))
+
+// ffmpegLimiter limits the number of concurrent ffmpeg processes.
+var ffmpegLimiter = worker.NewLimiterFromEnv("ZIMTOHRLI_MAX_FFMPEG")
+
+// MaxConcurrentFFmpeg returns the max number of ffmpeg processes this package runs concurrently, or 0 if unlimited.
+func MaxConcurrentFFmpeg() int {
+	return ffmpegLimiter.Max()
+}
+
+// SetMaxConcurrentFFmpeg limits the number of ffmpeg processes this package runs concurrently,
+// independently of how many goroutines use the package. Zero or less means unlimited.
+//
+// Defaults to $ZIMTOHRLI_MAX_FFMPEG, or unlimited if that isn't set.
+func SetMaxConcurrentFFmpeg(max int) {
+	ffmpegLimiter.SetMax(max)
+}
+
+func ffmpegFromEnv() string {
+	if ffmpeg := os.Getenv("ZIMTOHRLI_FFMPEG"); ffmpeg != "" {
+		return ffmpeg
+	}
+	return "ffmpeg"
+}
+
+// FFmpegError is returned when an ffmpeg or ffprobe execution fails.
+type FFmpegError struct {
+	// Binary is the ffmpeg or ffprobe binary that was executed.
+	Binary string
+	// Args are the arguments ffmpeg was executed with.
+	Args []string
+	// Err is the error returned when executing ffmpeg.
+	Err error
+	// Stderr is what ffmpeg wrote to stderr.
+	Stderr string
+}
+
+func (f *FFmpegError) Error() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "executing %s %s: %v", f.Binary, strings.Join(f.Args, " "), f.Err)
+	if errors.Is(f.Err, exec.ErrNotFound) {
+		fmt.Fprint(buf, " (set $ZIMTOHRLI_FFMPEG or -ffmpeg to the path of an ffmpeg binary, and $ZIMTOHRLI_FFPROBE to the path of an ffprobe binary)")
+	}
+	if stderr := strings.TrimSpace(f.Stderr); stderr != "" {
+		fmt.Fprintf(buf, "\nstderr:\n%s", stderr)
+	}
+	return buf.String()
+}
+
+func (f *FFmpegError) Unwrap() error {
+	return f.Err
+}
+
+// runFFmpeg executes FFmpeg with FFmpegArgs and args, and returns an *FFmpegError if it fails.
+func runFFmpeg(stdin io.Reader, stdout io.Writer, args ...string) error {
+	return run(FFmpeg, stdin, stdout, append(append([]string{}, FFmpegArgs...), args...)...)
+}
+
+// run executes binary, which must be ffmpeg or ffprobe, with args, and returns an *FFmpegError if it fails.
+func run(binary string, stdin io.Reader, stdout io.Writer, args ...string) error {
+	fullArgs := append([]string{"-hide_banner", "-loglevel", "error"}, args...)
+	cmd := exec.Command(binary, fullArgs...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	ffmpegLimiter.Acquire()
+	defer ffmpegLimiter.Release()
+	if err := cmd.Run(); err != nil {
+		return &FFmpegError{
+			Binary: binary,
+			Args:   fullArgs,
+			Err:    err,
+			Stderr: stderr.String(),
+		}
+	}
+	return nil
+}
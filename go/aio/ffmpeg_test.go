@@ -0,0 +1,43 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFFmpegError(t *testing.T) {
+	fakeFFmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(fakeFFmpeg, []byte("#!/bin/sh\necho 'Invalid data found when processing input' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(ffmpeg string) { FFmpeg = ffmpeg }(FFmpeg)
+	FFmpeg = fakeFFmpeg
+	_, err := Load("broken.wav")
+	ffmpegErr := &FFmpegError{}
+	if !errors.As(err, &ffmpegErr) {
+		t.Fatalf("got error %v, want an *FFmpegError", err)
+	}
+	if !strings.Contains(ffmpegErr.Error(), "Invalid data found when processing input") {
+		t.Errorf("error %q doesn't contain ffmpeg stderr", ffmpegErr.Error())
+	}
+	if !strings.Contains(ffmpegErr.Error(), "broken.wav") {
+		t.Errorf("error %q doesn't contain the input path", ffmpegErr.Error())
+	}
+}
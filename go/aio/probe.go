@@ -0,0 +1,96 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// FFprobe is the ffprobe binary used by Probe.
+//
+// Defaults to $ZIMTOHRLI_FFPROBE, or ffprobe in $PATH if that isn't set.
+var FFprobe = ffprobeFromEnv()
+
+func ffprobeFromEnv() string {
+	if ffprobe := os.Getenv("ZIMTOHRLI_FFPROBE"); ffprobe != "" {
+		return ffprobe
+	}
+	return "ffprobe"
+}
+
+// ProbeResult contains the properties of the first audio stream of a file.
+type ProbeResult struct {
+	Duration time.Duration
+	Rate     int
+	Channels int
+	Codec    string
+	Format   string
+}
+
+func (p ProbeResult) String() string {
+	return fmt.Sprintf("%s in %s, %v Hz, %v channels, %s", p.Codec, p.Format, p.Rate, p.Channels, p.Duration)
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+	} `json:"format"`
+}
+
+// Probe returns the properties of the first audio stream in an ffprobe-readable file from a path (which may be a http(s)://, gs://, or s3:// URL) without decoding it.
+func Probe(path string) (*ProbeResult, error) {
+	path, err := Localize(path)
+	if err != nil {
+		return nil, err
+	}
+	stdout := &bytes.Buffer{}
+	if err := run(FFprobe, nil, stdout, "-select_streams", "a:0", "-show_entries", "stream=codec_name,sample_rate,channels:format=format_name,duration", "-of", "json", path); err != nil {
+		return nil, err
+	}
+	output := &ffprobeOutput{}
+	if err := json.Unmarshal(stdout.Bytes(), output); err != nil {
+		return nil, fmt.Errorf("while parsing ffprobe output for %q: %v", path, err)
+	}
+	if len(output.Streams) == 0 {
+		return nil, fmt.Errorf("%q has no audio streams", path)
+	}
+	result := &ProbeResult{
+		Channels: output.Streams[0].Channels,
+		Codec:    output.Streams[0].CodecName,
+		Format:   output.Format.FormatName,
+	}
+	if result.Rate, err = strconv.Atoi(output.Streams[0].SampleRate); err != nil {
+		return nil, fmt.Errorf("while parsing sample rate %q of %q: %v", output.Streams[0].SampleRate, path, err)
+	}
+	if output.Format.Duration != "" {
+		seconds, err := strconv.ParseFloat(output.Format.Duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing duration %q of %q: %v", output.Format.Duration, path, err)
+		}
+		result.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return result, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	fakeFFprobe := filepath.Join(t.TempDir(), "ffprobe")
+	if err := os.WriteFile(fakeFFprobe, []byte(`#!/bin/sh
+cat <<END
+{
+    "programs": [],
+    "streams": [{"codec_name": "flac", "sample_rate": "44100", "channels": 2}],
+    "format": {"format_name": "flac", "duration": "2.500000"}
+}
+END
+`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(ffprobe string) { FFprobe = ffprobe }(FFprobe)
+	FFprobe = fakeFFprobe
+	result, err := Probe("audio.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProbeResult{
+		Duration: 2500 * time.Millisecond,
+		Rate:     44100,
+		Channels: 2,
+		Codec:    "flac",
+		Format:   "flac",
+	}
+	if *result != want {
+		t.Errorf("Probe(...) = %+v, want %+v", *result, want)
+	}
+
+	// Probing with a limited number of concurrent processes runs ffprobe once, instead of waiting for itself.
+	defer SetMaxConcurrentFFmpeg(MaxConcurrentFFmpeg())
+	SetMaxConcurrentFFmpeg(1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := Probe("audio.flac")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Probe(...) with at most 1 concurrent process returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Probe(...) with at most 1 concurrent process didn't finish")
+	}
+}
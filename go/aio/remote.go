@@ -0,0 +1,125 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// CacheDir is the directory where Localize stores downloaded files.
+var CacheDir = filepath.Join(os.TempDir(), "zimtohrli.go.aio.cache")
+
+// IsRemote returns whether the path is a http(s)://, gs://, or s3:// URL.
+func IsRemote(p string) bool {
+	u, err := url.Parse(p)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "gs", "s3":
+		return true
+	}
+	return false
+}
+
+// Localize returns a local path containing the file at p.
+//
+// Local paths are returned unchanged. http(s)://, gs://, and s3:// URLs are downloaded to CacheDir,
+// unless they already have been, in which case the cached file is returned. gs:// URLs are downloaded
+// using gsutil, and s3:// URLs using the aws CLI. MaxCacheBytes limits the size of CacheDir.
+func Localize(p string) (string, error) {
+	if !IsRemote(p) {
+		return p, nil
+	}
+	u, err := url.Parse(p)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(p))
+	cachePath := filepath.Join(CacheDir, hex.EncodeToString(hash[:])+path.Ext(u.Path))
+	if _, err := os.Stat(cachePath); err == nil {
+		// The modification time marks when the file was last used, for pruneCache.
+		now := time.Now()
+		if err := os.Chtimes(cachePath, now, now); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return cachePath, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := pruneCache(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(CacheDir, 0755); err != nil {
+		return "", err
+	}
+	tmpFile, err := os.CreateTemp(CacheDir, "zimtohrli.go.aio.Localize.*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	switch u.Scheme {
+	case "http", "https":
+		err = download(p, tmpFile)
+	case "gs":
+		err = downloadWith(tmpFile, "gsutil", "-q", "cp", p, tmpFile.Name())
+	case "s3":
+		err = downloadWith(tmpFile, "aws", "s3", "cp", "--quiet", p, tmpFile.Name())
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpFile.Name(), cachePath); err != nil {
+		return "", err
+	}
+	return cachePath, nil
+}
+
+func download(u string, f *os.File) error {
+	res, err := http.Get(u)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		f.Close()
+		return fmt.Errorf("fetching %q: status code error: %d %s", u, res.StatusCode, res.Status)
+	}
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("fetching %q: %v", u, err)
+	}
+	return f.Close()
+}
+
+func downloadWith(f *os.File, name string, args ...string) error {
+	f.Close()
+	cmd := exec.Command(name, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("trying to execute %v: %v\n%s", cmd, err, output)
+	}
+	return nil
+}
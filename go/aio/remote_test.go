@@ -0,0 +1,87 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalize(t *testing.T) {
+	CacheDir = t.TempDir()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, "audio")
+	}))
+	defer server.Close()
+
+	if path, err := Localize("local/file.wav"); err != nil || path != "local/file.wav" {
+		t.Errorf("Localize(local/file.wav) = %q, %v, want local/file.wav, nil", path, err)
+	}
+	for i := 0; i < 2; i++ {
+		path, err := Localize(server.URL + "/file.wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "audio" {
+			t.Errorf("got content %q, want %q", content, "audio")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %v requests, want 1", requests)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	CacheDir = t.TempDir()
+	MaxCacheBytes = 10
+	defer func() { MaxCacheBytes = 0 }()
+	old := time.Now().Add(-2 * minCacheAge)
+	for index, name := range []string{"a.wav", "b.wav", "c.wav"} {
+		path := filepath.Join(CacheDir, name)
+		if err := os.WriteFile(path, []byte("1234"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := old.Add(time.Duration(index) * time.Second)
+		if err := os.Chtimes(path, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneCache(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		wantFound bool
+	}{
+		{"a.wav", false},
+		{"b.wav", true},
+		{"c.wav", true},
+	} {
+		if _, err := os.Stat(filepath.Join(CacheDir, tc.name)); (err == nil) != tc.wantFound {
+			t.Errorf("%v found: %v, want %v", tc.name, err == nil, tc.wantFound)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Resampler contains the options of the ffmpeg aresample filter used when audio is decoded at another sample rate
+// than its own, e.g. "resampler=soxr:precision=28". Empty means the default ffmpeg resampler and settings.
+//
+// Defaults to $ZIMTOHRLI_RESAMPLER.
+var Resampler = os.Getenv("ZIMTOHRLI_RESAMPLER")
+
+// ForbidResampling makes loading audio at another sample rate than its own fail with ErrResamplingRequired.
+var ForbidResampling bool
+
+// OnResampling, if set, is called for each sample rate conversion when loading audio. It may be called concurrently.
+var OnResampling func(Resampling)
+
+// OnDecode, if set, is called after each successful decoding of audio with the time spent. It may be called
+// concurrently. Setting it makes decoding probe the sample rate of the audio to report any resampling.
+var OnDecode func(DecodeTiming)
+
+// DecodeTiming is the time spent loading audio.
+type DecodeTiming struct {
+	Path string
+	// Fetch is the time spent downloading remote audio, or finding it in the cache.
+	Fetch time.Duration
+	// Probe is the time spent finding the sample rate of the audio.
+	Probe time.Duration
+	// Decode is the time spent in ffmpeg, which also resamples the audio if Resampling is set.
+	Decode time.Duration
+	// Resampling, if set, is the sample rate conversion ffmpeg performed while decoding.
+	Resampling *Resampling
+}
+
+func (d DecodeTiming) String() string {
+	result := fmt.Sprintf("%q: fetch %v, probe %v, decode %v", d.Path, d.Fetch, d.Probe, d.Decode)
+	if d.Resampling != nil {
+		result += fmt.Sprintf(" including resampling from %v Hz to %v Hz", d.Resampling.FromRate, d.Resampling.ToRate)
+	}
+	return result
+}
+
+// ErrResamplingRequired is returned when loading audio requires resampling and ForbidResampling is set.
+var ErrResamplingRequired = errors.New("resampling required")
+
+// Resampling describes a sample rate conversion when loading audio.
+type Resampling struct {
+	Path      string
+	FromRate  int
+	ToRate    int
+	Resampler string
+}
+
+func (r Resampling) String() string {
+	return fmt.Sprintf("%q resampled from %v Hz to %v Hz using %s", r.Path, r.FromRate, r.ToRate, r.Resampler)
+}
+
+// resamplerName returns a description of the resampler used by ffmpeg.
+func resamplerName() string {
+	if Resampler == "" {
+		return "the default ffmpeg resampler"
+	}
+	return fmt.Sprintf("aresample=%s", Resampler)
+}
+
+// resamplerArgs returns the ffmpeg output arguments selecting the resampler.
+func resamplerArgs() []string {
+	if Resampler == "" {
+		return nil
+	}
+	return []string{"-af", fmt.Sprintf("aresample=%s", Resampler)}
+}
+
+// checkResampling returns ErrResamplingRequired if ForbidResampling is set and decoding the audio at path, with the
+// given source sample rate, at rate requires resampling, and otherwise reports any resampling to OnResampling and
+// returns it.
+//
+// A sourceRate of 0 means the source rate is probed, and audio from stdin is never checked. Audio that can't be
+// probed is only an error if ForbidResampling is set.
+func checkResampling(path string, sourceRate int, rate int) (*Resampling, error) {
+	if (!ForbidResampling && OnResampling == nil && OnDecode == nil) || path == "-" {
+		return nil, nil
+	}
+	if sourceRate == 0 {
+		probe, err := Probe(path)
+		if err != nil {
+			if ForbidResampling {
+				return nil, fmt.Errorf("unable to check if %q requires resampling: %v", path, err)
+			}
+			return nil, nil
+		}
+		sourceRate = probe.Rate
+	}
+	if sourceRate == rate {
+		return nil, nil
+	}
+	resampling := Resampling{Path: path, FromRate: sourceRate, ToRate: rate, Resampler: resamplerName()}
+	if ForbidResampling {
+		return nil, fmt.Errorf("%w: %v", ErrResamplingRequired, resampling)
+	}
+	if OnResampling != nil {
+		OnResampling(resampling)
+	}
+	return &resampling, nil
+}
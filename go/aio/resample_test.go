@@ -0,0 +1,99 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+func TestResampling(t *testing.T) {
+	fakeFFmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(fakeFFmpeg, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(ffmpeg, resampler string) {
+		FFmpeg, Resampler, ForbidResampling, OnResampling = ffmpeg, resampler, false, nil
+	}(FFmpeg, Resampler)
+	FFmpeg = fakeFFmpeg
+	format := RawFormat{SampleFormat: "s16le", Rate: 16000, Channels: 1}
+
+	ForbidResampling = true
+	if _, err := LoadRawAtRate("speech.raw", format, 48000); !errors.Is(err, ErrResamplingRequired) {
+		t.Errorf("got error %v, want %v", err, ErrResamplingRequired)
+	}
+	ffmpegErr := &FFmpegError{}
+	if _, err := LoadRawAtRate("speech.raw", format, 16000); !errors.As(err, &ffmpegErr) {
+		t.Errorf("got error %v without resampling, want an *FFmpegError", err)
+	}
+
+	ForbidResampling = false
+	Resampler = "resampler=soxr"
+	resamplings := []Resampling{}
+	OnResampling = func(r Resampling) {
+		resamplings = append(resamplings, r)
+	}
+	if _, err := LoadRawAtRate("speech.raw", format, 48000); !errors.As(err, &ffmpegErr) {
+		t.Fatalf("got error %v, want an *FFmpegError", err)
+	}
+	if want := []Resampling{{Path: "speech.raw", FromRate: 16000, ToRate: 48000, Resampler: "aresample=resampler=soxr"}}; !reflect.DeepEqual(resamplings, want) {
+		t.Errorf("got resamplings %+v, want %+v", resamplings, want)
+	}
+	if args := strings.Join(ffmpegErr.Args, " "); !strings.Contains(args, "-af aresample=resampler=soxr") {
+		t.Errorf("ffmpeg args %q don't select the resampler", args)
+	}
+}
+
+func TestDecodeTiming(t *testing.T) {
+	dir := t.TempDir()
+	fakeFFmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(fakeFFmpeg, []byte("#!/bin/sh\nwhile [ $# -gt 0 ]; do if [ \"$1\" = \"-i\" ]; then cat \"$2\"; exit 0; fi; shift; done\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(ffmpeg string) {
+		FFmpeg, OnDecode = ffmpeg, nil
+	}(FFmpeg)
+	FFmpeg = fakeFFmpeg
+	path := filepath.Join(dir, "speech.wav")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&audio.Audio{Samples: [][]float32{make([]float32, 16)}, Rate: 48000}).WAV().Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	timings := []DecodeTiming{}
+	OnDecode = func(timing DecodeTiming) {
+		timings = append(timings, timing)
+	}
+	for _, rate := range []int{48000, 16000} {
+		if _, err := LoadRawAtRate(path, RawFormat{SampleFormat: "s16le", Rate: rate, Channels: 1}, 48000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(timings) != 2 || timings[0].Path != path || timings[0].Decode <= 0 || timings[0].Resampling != nil {
+		t.Fatalf("got timings %+v, want 2 timings of %q, the first without resampling", timings, path)
+	}
+	if want := (&Resampling{Path: path, FromRate: 16000, ToRate: 48000, Resampler: resamplerName()}); !reflect.DeepEqual(timings[1].Resampling, want) {
+		t.Errorf("got resampling %+v, want %+v", timings[1].Resampling, want)
+	}
+}
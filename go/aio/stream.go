@@ -0,0 +1,113 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+)
+
+// Stream is audio continuously decoded by ffmpeg, e.g. from a live capture device, a pipe, or a growing file.
+//
+// Streams run for a long time, and don't count towards the max number of concurrent ffmpeg processes.
+type Stream struct {
+	// Rate is the sample rate of the decoded audio.
+	Rate int
+	// Channels is the number of channels of the decoded audio.
+	Channels int
+
+	cmd    *exec.Cmd
+	args   []string
+	stdout io.ReadCloser
+	reader *bufio.Reader
+	stderr *bytes.Buffer
+	ended  bool
+}
+
+// OpenStream starts decoding the input at path, which is anything ffmpeg accepts as input, to audio with the
+// given sample rate and number of channels.
+//
+// The inputArgs are given to ffmpeg before the input, e.g. []string{"-f", "pulse"} to capture from a PulseAudio
+// device, or []string{"-follow", "1"} to keep reading a growing file.
+func OpenStream(inputArgs []string, path string, rate int, channels int) (*Stream, error) {
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, FFmpegArgs...)
+	args = append(append(args, inputArgs...), "-i", path, "-vn", "-f", "f32le", "-acodec", "pcm_f32le")
+	args = append(append(args, resamplerArgs()...), "-ar", fmt.Sprint(rate), "-ac", fmt.Sprint(channels), "-")
+	s := &Stream{
+		Rate:     rate,
+		Channels: channels,
+		cmd:      exec.Command(FFmpeg, args...),
+		args:     args,
+		stderr:   &bytes.Buffer{},
+	}
+	s.cmd.Stderr = s.stderr
+	var err error
+	if s.stdout, err = s.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	s.reader = bufio.NewReader(s.stdout)
+	if err := s.cmd.Start(); err != nil {
+		return nil, &FFmpegError{Binary: FFmpeg, Args: args, Err: err}
+	}
+	return s, nil
+}
+
+// Read returns the next numFrames frames of the stream, as one slice of samples per channel, blocking until they
+// are decoded.
+//
+// Returns io.EOF if the stream ended before any frame was read, and io.ErrUnexpectedEOF if it ended before all
+// frames were read.
+func (s *Stream) Read(numFrames int) ([][]float32, error) {
+	if s.ended {
+		return nil, io.EOF
+	}
+	buf := make([]byte, numFrames*s.Channels*4)
+	if _, err := io.ReadFull(s.reader, buf); err != nil {
+		s.ended = true
+		if waitErr := s.cmd.Wait(); waitErr != nil {
+			return nil, &FFmpegError{Binary: FFmpeg, Args: s.args, Err: waitErr, Stderr: s.stderr.String()}
+		}
+		return nil, err
+	}
+	result := make([][]float32, s.Channels)
+	for channelIndex := range result {
+		result[channelIndex] = make([]float32, numFrames)
+	}
+	for frameIndex := 0; frameIndex < numFrames; frameIndex++ {
+		for channelIndex := range result {
+			offset := (frameIndex*s.Channels + channelIndex) * 4
+			result[channelIndex][frameIndex] = math.Float32frombits(binary.LittleEndian.Uint32(buf[offset:]))
+		}
+	}
+	return result, nil
+}
+
+// Close stops decoding the stream.
+func (s *Stream) Close() error {
+	if s.ended {
+		return nil
+	}
+	s.ended = true
+	if err := s.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	s.cmd.Wait()
+	return nil
+}
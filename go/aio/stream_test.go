@@ -0,0 +1,63 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	dir := t.TempDir()
+	fakeFFmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(fakeFFmpeg, []byte("#!/bin/sh\nwhile [ $# -gt 0 ]; do if [ \"$1\" = \"-i\" ]; then cat \"$2\"; exit 0; fi; shift; done\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(ffmpeg string) { FFmpeg = ffmpeg }(FFmpeg)
+	FFmpeg = fakeFFmpeg
+	samples := []float32{0.1, -0.1, 0.2, -0.2, 0.3, -0.3}
+	raw := make([]byte, len(samples)*4)
+	for index, sample := range samples {
+		binary.LittleEndian.PutUint32(raw[index*4:], math.Float32bits(sample))
+	}
+	input := filepath.Join(dir, "input.raw")
+	if err := os.WriteFile(input, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := OpenStream(nil, input, 48000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	frames, err := stream.Read(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float32{{0.1, 0.2}, {-0.1, -0.2}}; !reflect.DeepEqual(frames, want) {
+		t.Errorf("Read(2) = %v, want %v", frames, want)
+	}
+	if _, err := stream.Read(2); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Read(2) at the end returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := stream.Read(1); !errors.Is(err, io.EOF) {
+		t.Errorf("Read(1) after the end returned %v, want %v", err, io.EOF)
+	}
+}
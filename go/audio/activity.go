@@ -0,0 +1,149 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// VoiceActivity detects the parts of speech recordings with voice activity by the energy of short frames, so that
+// long silences in e.g. conversational speech don't dilute the measured distances.
+type VoiceActivity struct {
+	// ThresholdDB is the energy, relative to the loudest frame, below which frames are considered inactive.
+	ThresholdDB float64
+	// FrameDuration is the duration in seconds of the frames whose energy is measured.
+	FrameDuration float64
+	// Padding is the duration in seconds added before and after active frames, so that weak onsets and word
+	// endings are kept, and short pauses within utterances don't split them.
+	Padding float64
+}
+
+// DefaultVoiceActivity is the default voice activity detection.
+var DefaultVoiceActivity = VoiceActivity{
+	ThresholdDB:   -40,
+	FrameDuration: 0.02,
+	Padding:       0.2,
+}
+
+// Segments returns the segments of the audio with voice activity, in order and without overlaps.
+//
+// Silent audio has no segments.
+func (v VoiceActivity) Segments(a *Audio) Segments {
+	result := Segments{}
+	if len(a.Samples) == 0 || a.Rate <= 0 {
+		return result
+	}
+	numFrames := len(a.Samples[0])
+	frameLength := max(1, int(math.Round(v.FrameDuration*a.Rate)))
+	energies := make([]float64, (numFrames+frameLength-1)/frameLength)
+	maxEnergy := 0.0
+	for frameIndex := range energies {
+		start, end := frameIndex*frameLength, min(numFrames, (frameIndex+1)*frameLength)
+		for _, channel := range a.Samples {
+			for _, sample := range channel[start:end] {
+				energies[frameIndex] += float64(sample) * float64(sample)
+			}
+		}
+		energies[frameIndex] /= float64((end - start) * len(a.Samples))
+		maxEnergy = math.Max(maxEnergy, energies[frameIndex])
+	}
+	if maxEnergy == 0 {
+		return result
+	}
+	threshold := maxEnergy * math.Pow(10, v.ThresholdDB/10)
+	duration := float64(numFrames) / a.Rate
+	for frameIndex, energy := range energies {
+		if energy < threshold {
+			continue
+		}
+		segment := Segment{
+			Start: math.Max(0, float64(frameIndex*frameLength)/a.Rate-v.Padding),
+			End:   math.Min(duration, float64(min(numFrames, (frameIndex+1)*frameLength))/a.Rate+v.Padding),
+		}
+		if len(result) > 0 && segment.Start <= result[len(result)-1].End {
+			result[len(result)-1].End = segment.End
+		} else {
+			result = append(result, segment)
+		}
+	}
+	return result
+}
+
+// Measure returns the mean of the measure of the segments of the reference and the distortion with voice activity
+// in the reference, weighted by duration, see Segments.Measure.
+//
+// If the reference is silent, the whole signals are measured.
+func (v VoiceActivity) Measure(reference, distortion *Audio, measure func(reference, distortion *Audio) (float64, error)) (float64, error) {
+	segments := v.Segments(reference)
+	if len(segments) == 0 {
+		return measure(reference, distortion)
+	}
+	return segments.Measure(reference, distortion, measure)
+}
+
+// ReadSegments reads segments from lines with whitespace separated start and end times in seconds, optionally
+// followed by a label, like the label tracks exported by Audacity or the output of many voice activity detectors.
+//
+// Empty lines and lines starting with # are ignored.
+func ReadSegments(r io.Reader) (Segments, error) {
+	result := Segments{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %v: %q doesn't have a start and end time", lineNumber, line)
+		}
+		segment := Segment{}
+		var err error
+		if segment.Start, err = strconv.ParseFloat(fields[0], 64); err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNumber, err)
+		}
+		if segment.End, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNumber, err)
+		}
+		result = append(result, segment)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no segments")
+	}
+	return result, result.Validate()
+}
+
+// LoadSegments returns the segments in a file read by ReadSegments.
+func LoadSegments(path string) (Segments, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result, err := ReadSegments(f)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", path, err)
+	}
+	return result, nil
+}
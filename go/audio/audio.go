@@ -139,6 +139,70 @@ func (w *WAV) Audio() (*Audio, error) {
 	return result, nil
 }
 
+// WAV returns a 16 bit PCM WAV file containing the audio.
+//
+// Samples outside [-1, 1] will be clipped.
+func (a *Audio) WAV() *WAV {
+	numChannels := len(a.Samples)
+	numFrames := 0
+	if numChannels > 0 {
+		numFrames = len(a.Samples[0])
+	}
+	pcmSamples := make([]int16, numChannels*numFrames)
+	for channelIndex, channel := range a.Samples {
+		for sampleIndex, sample := range channel {
+			scaledSample := math.Round(float64(sample) * (1 << 15))
+			if scaledSample > math.MaxInt16 {
+				scaledSample = math.MaxInt16
+			} else if scaledSample < math.MinInt16 {
+				scaledSample = math.MinInt16
+			}
+			pcmSamples[sampleIndex*numChannels+channelIndex] = int16(scaledSample)
+		}
+	}
+	data := make([]byte, 2*len(pcmSamples))
+	for sampleIndex, sample := range pcmSamples {
+		binary.LittleEndian.PutUint16(data[2*sampleIndex:], uint16(sample))
+	}
+	formatChunk := &FormatChunk{
+		AudioFormat:   1,
+		NumChannels:   int16(numChannels),
+		SampleRate:    int32(a.Rate),
+		ByteRate:      int32(a.Rate) * int32(numChannels) * 2,
+		BlockAlign:    int16(numChannels) * 2,
+		BitsPerSample: 16,
+	}
+	return &WAV{
+		RIFFHeader: &RIFFHeader{
+			ChunkID:   FixString{'R', 'I', 'F', 'F'},
+			ChunkSize: int32(4 + 8 + binary.Size(formatChunk) + 8 + len(data)),
+			Format:    FixString{'W', 'A', 'V', 'E'},
+		},
+		FormatChunk: formatChunk,
+		Data:        data,
+	}
+}
+
+// Write writes the WAV file to a writer.
+func (w *WAV) Write(wr io.Writer) error {
+	if err := binary.Write(wr, binary.LittleEndian, w.RIFFHeader); err != nil {
+		return fmt.Errorf("while writing RIFF header: %v", err)
+	}
+	if err := binary.Write(wr, binary.LittleEndian, &ChunkHeader{SubChunkID: FixString{'f', 'm', 't', ' '}, SubChunkSize: int32(binary.Size(w.FormatChunk))}); err != nil {
+		return fmt.Errorf("while writing format chunk header: %v", err)
+	}
+	if err := binary.Write(wr, binary.LittleEndian, w.FormatChunk); err != nil {
+		return fmt.Errorf("while writing format chunk: %v", err)
+	}
+	if err := binary.Write(wr, binary.LittleEndian, &ChunkHeader{SubChunkID: FixString{'d', 'a', 't', 'a'}, SubChunkSize: int32(len(w.Data))}); err != nil {
+		return fmt.Errorf("while writing data chunk header: %v", err)
+	}
+	if _, err := wr.Write(w.Data); err != nil {
+		return fmt.Errorf("while writing data chunk: %v", err)
+	}
+	return nil
+}
+
 // ReadWAV reads a WAV file from a reader.
 func ReadWAV(r io.Reader) (*WAV, error) {
 	result := &WAV{}
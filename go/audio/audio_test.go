@@ -17,6 +17,10 @@ package audio
 import (
 	"bytes"
 	"math"
+	"math/cmplx"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -117,3 +121,295 @@ func TestReadWAV(t *testing.T) {
 		t.Run(w.name, readWAVTest(w.data, w.channels))
 	}
 }
+
+func TestWriteWAV(t *testing.T) {
+	for _, w := range wavs {
+		t.Run(w.name, func(t *testing.T) {
+			wav, err := ReadWAV(bytes.NewBuffer(w.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			audio, err := wav.Audio()
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := &bytes.Buffer{}
+			if err := audio.WAV().Write(buf); err != nil {
+				t.Fatal(err)
+			}
+			rewritten, err := ReadWAV(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rewritten.Data, wav.Data) {
+				t.Errorf("rewritten data differs from original data")
+			}
+			if *rewritten.FormatChunk != *wav.FormatChunk {
+				t.Errorf("got format %+v, want %+v", *rewritten.FormatChunk, *wav.FormatChunk)
+			}
+		})
+	}
+}
+
+func TestPreprocessing(t *testing.T) {
+	original := &Audio{
+		Samples: [][]float32{
+			{0.1, 0.1, 0.6, 0.1, -0.4, 0.1},
+			{0.1, 0.1, 0.1, 0.1, 0.1, 0.1},
+		},
+		Rate: 48000,
+	}
+	preprocessed := Preprocessing{RemoveDCOffset: true, TrimSilence: true, SilenceThresholdDBFS: -20}.Apply(original)
+	want := [][]float32{{0.5, 0, -0.5}, {0, 0, 0}}
+	for channelIndex, channel := range preprocessed.Samples {
+		if len(channel) != len(want[channelIndex]) {
+			t.Fatalf("channel %v = %v, want %v", channelIndex, channel, want[channelIndex])
+		}
+		for sampleIndex, sample := range channel {
+			if math.Abs(float64(sample-want[channelIndex][sampleIndex])) > 1e-6 {
+				t.Errorf("channel %v = %v, want %v", channelIndex, channel, want[channelIndex])
+				break
+			}
+		}
+	}
+	if math.Abs(float64(preprocessed.MaxAbsAmplitude-0.5)) > 1e-6 {
+		t.Errorf("MaxAbsAmplitude = %v, want 0.5", preprocessed.MaxAbsAmplitude)
+	}
+	if !reflect.DeepEqual(original.Samples[0], []float32{0.1, 0.1, 0.6, 0.1, -0.4, 0.1}) {
+		t.Errorf("Apply modified the original audio: %v", original.Samples)
+	}
+	silent := &Audio{Samples: [][]float32{{0, 0, 0}}, Rate: 48000}
+	silent.TrimSilence(-60)
+	if len(silent.Samples[0]) != 3 {
+		t.Errorf("TrimSilence trimmed silent audio to %v", silent.Samples[0])
+	}
+}
+
+func TestMono(t *testing.T) {
+	stereo := &Audio{Samples: [][]float32{{0.5, -0.5, 1}, {0.5, 0.5, 0}}, Rate: 16000}
+	mono := stereo.Mono()
+	if want := [][]float32{{0.5, 0, 0.5}}; !reflect.DeepEqual(mono.Samples, want) || mono.Rate != 16000 || mono.MaxAbsAmplitude != 0.5 {
+		t.Errorf("Mono() = %+v, want samples %v at 16000 Hz with max amplitude 0.5", mono, want)
+	}
+}
+
+func TestLevelCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		samples   []float32
+		wantKinds []WarningKind
+	}{
+		{name: "normal", samples: []float32{0.5, -0.5, 1, 0.5, -0.5, 0.5}},
+		{name: "clipped", samples: []float32{0.5, 1, 1, -1, 0.5, 0.5}, wantKinds: []WarningKind{Clipping}},
+		{name: "quiet", samples: []float32{1e-4, -1e-4, 1e-4, -1e-4}, wantKinds: []WarningKind{LowLevel}},
+		{name: "silent", samples: []float32{0, 0, 0, 0}, wantKinds: []WarningKind{LowLevel}},
+	} {
+		warnings := DefaultLevelCheck.Check(&Audio{Samples: [][]float32{tc.samples}, Rate: 48000})
+		kinds := []WarningKind{}
+		for _, warning := range warnings {
+			kinds = append(kinds, warning.Kind)
+		}
+		if len(kinds) != len(tc.wantKinds) || (len(kinds) > 0 && !reflect.DeepEqual(kinds, tc.wantKinds)) {
+			t.Errorf("%v: got warnings %v, want kinds %v", tc.name, warnings, tc.wantKinds)
+		}
+	}
+}
+
+func TestHearingLoss(t *testing.T) {
+	audiogram := Audiogram{{Frequency: 1000, LossDB: 0}, {Frequency: 4000, LossDB: 40}}
+	for _, tc := range []struct {
+		frequency float64
+		want      float64
+	}{
+		{frequency: 500, want: 0},
+		{frequency: 1000, want: 0},
+		{frequency: 2000, want: 20},
+		{frequency: 4000, want: 40},
+		{frequency: 8000, want: 40},
+	} {
+		if got := audiogram.LossDB(tc.frequency); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("LossDB(%v) = %v, want %v", tc.frequency, got, tc.want)
+		}
+	}
+	sineLevel := func(freq float64) float64 {
+		a := &Audio{Samples: [][]float32{make([]float32, 4800)}, Rate: 48000}
+		for index := range a.Samples[0] {
+			a.Samples[0][index] = float32(math.Sin(2 * math.Pi * freq * float64(index) / 48000))
+		}
+		a = Preprocessing{HearingLoss: audiogram}.Apply(a)
+		// Ignore the edges, where the filter sees zeros.
+		sumOfSquares := 0.0
+		for _, sample := range a.Samples[0][1000:3800] {
+			sumOfSquares += float64(sample) * float64(sample)
+		}
+		return 10 * math.Log10(sumOfSquares/2800/0.5)
+	}
+	if level := sineLevel(750); math.Abs(level) > 1 {
+		t.Errorf("750Hz sine level after hearing loss = %vdB, want 0dB", level)
+	}
+	if level := sineLevel(6000); math.Abs(level+40) > 1 {
+		t.Errorf("6kHz sine level after hearing loss = %vdB, want -40dB", level)
+	}
+	if _, err := ParseAudiogram("N2"); err != nil {
+		t.Error(err)
+	}
+	if parsed, err := ParseAudiogram(`[{"Frequency": 1000, "LossDB": 0}, {"Frequency": 4000, "LossDB": 40}]`); err != nil || !reflect.DeepEqual(parsed, audiogram) {
+		t.Errorf("ParseAudiogram(...) = %v, %v, want %v", parsed, err, audiogram)
+	}
+	if _, err := ParseAudiogram("bogus"); err == nil {
+		t.Errorf("ParseAudiogram(\"bogus\") returned no error")
+	}
+}
+
+func TestSegments(t *testing.T) {
+	segments, err := ParseSegments("0.1-0.3, 0.5-0.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Segments{{Start: 0.1, End: 0.3}, {Start: 0.5, End: 0.6}}); !reflect.DeepEqual(segments, want) {
+		t.Errorf("ParseSegments = %v, want %v", segments, want)
+	}
+	for _, invalid := range []string{"", "1", "2-1", "-1-2", "a-b"} {
+		if _, err := ParseSegments(invalid); err == nil {
+			t.Errorf("ParseSegments(%q) returned no error", invalid)
+		}
+	}
+	signal := &Audio{Samples: [][]float32{make([]float32, 10)}, Rate: 10}
+	for index := range signal.Samples[0] {
+		signal.Samples[0][index] = float32(index)
+	}
+	if got := (Segment{Start: 0.2, End: 0.5}).Extract(signal).Samples[0]; !reflect.DeepEqual(got, []float32{2, 3, 4}) {
+		t.Errorf("Extract = %v, want [2 3 4]", got)
+	}
+	if got := (Segment{Start: 0.8, End: 2}).Extract(signal).Samples[0]; !reflect.DeepEqual(got, []float32{8, 9}) {
+		t.Errorf("Extract past the end = %v, want [8 9]", got)
+	}
+	firstSample := func(reference, distortion *Audio) (float64, error) {
+		return float64(reference.Samples[0][0]), nil
+	}
+	// The segment starting at sample 1 has 1 frame and the one starting at sample 4 has 3 frames.
+	mean, err := Segments{{Start: 0.1, End: 0.2}, {Start: 0.4, End: 0.7}, {Start: 2, End: 3}}.Measure(signal, signal, firstSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (1.0*1 + 4.0*3) / 4; math.Abs(mean-want) > 1e-9 {
+		t.Errorf("Measure = %v, want %v", mean, want)
+	}
+	if _, err := (Segments{{Start: 2, End: 3}}).Measure(signal, signal, firstSample); err == nil {
+		t.Errorf("Measure of segments outside the audio returned no error")
+	}
+}
+
+func TestMeasureUntil(t *testing.T) {
+	if got, want := Windows(2.5, 1), (Segments{{Start: 0, End: 1}, {Start: 1, End: 2}, {Start: 2, End: 2.5}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Windows(2.5, 1) = %v, want %v", got, want)
+	}
+	// The windows of 2 frames have the values 1, 3, 5, 7, 9, with mean 5.
+	signal := &Audio{Samples: [][]float32{make([]float32, 10)}, Rate: 10}
+	for index := range signal.Samples[0] {
+		signal.Samples[0][index] = float32(index)
+	}
+	windows := Windows(1, 0.2)
+	for _, tc := range []struct {
+		limit        float64
+		want         float64
+		wantExceeded bool
+		wantMeasured int
+	}{
+		{limit: 5, want: 5, wantMeasured: 5},
+		// After measuring 1, 3, and 5, the mean of the 5 windows is at least (1+3+5)/5.
+		{limit: 1.5, want: 1.8, wantExceeded: true, wantMeasured: 3},
+		{limit: 0, want: 0.2, wantExceeded: true, wantMeasured: 1},
+	} {
+		measured := 0
+		got, exceeded, err := windows.MeasureUntil(signal, signal, tc.limit, func(reference, distortion *Audio) (float64, error) {
+			measured++
+			return float64(distortion.Samples[0][1]), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-tc.want) > 1e-9 || exceeded != tc.wantExceeded || measured != tc.wantMeasured {
+			t.Errorf("MeasureUntil(%v) = %v, %v after %v measurements, want %v, %v after %v measurements", tc.limit, got, exceeded, measured, tc.want, tc.wantExceeded, tc.wantMeasured)
+		}
+	}
+}
+
+func TestVoiceActivity(t *testing.T) {
+	// 1 second of silence, 1 second of speech-like noise, 2 seconds of near silence, and 0.5 seconds of noise.
+	rng := rand.New(rand.NewSource(1))
+	signal := &Audio{Samples: [][]float32{make([]float32, 4500)}, Rate: 1000}
+	for index := range signal.Samples[0] {
+		switch {
+		case index >= 1000 && index < 2000, index >= 4000:
+			signal.Samples[0][index] = float32(rng.NormFloat64() * 0.1)
+		case index >= 2000:
+			signal.Samples[0][index] = float32(rng.NormFloat64() * 0.0001)
+		}
+	}
+	activity := VoiceActivity{ThresholdDB: -40, FrameDuration: 0.02, Padding: 0.1}
+	want := Segments{{Start: 0.9, End: 2.1}, {Start: 3.9, End: 4.5}}
+	got := activity.Segments(signal)
+	if len(got) != len(want) {
+		t.Fatalf("Segments = %v, want %v", got, want)
+	}
+	for index := range want {
+		if math.Abs(got[index].Start-want[index].Start) > 1e-9 || math.Abs(got[index].End-want[index].End) > 1e-9 {
+			t.Errorf("Segments = %v, want %v", got, want)
+		}
+	}
+	// The padding joins activity separated by short pauses.
+	if joined := (VoiceActivity{ThresholdDB: -40, FrameDuration: 0.02, Padding: 1}).Segments(signal); len(joined) != 1 {
+		t.Errorf("Segments with 1 second padding = %v, want a single segment", joined)
+	}
+
+	frames := func(reference, distortion *Audio) (float64, error) {
+		return float64(len(reference.Samples[0])), nil
+	}
+	// The mean is weighted by the 1200 and 600 frames of the segments.
+	if mean, err := activity.Measure(signal, signal, frames); err != nil || math.Abs(mean-(1200.0*1200+600*600)/1800) > 1e-9 {
+		t.Errorf("Measure = %v, %v, want %v", mean, err, (1200.0*1200+600*600)/1800)
+	}
+	silence := &Audio{Samples: [][]float32{make([]float32, 100)}, Rate: 1000}
+	if segments := activity.Segments(silence); len(segments) != 0 {
+		t.Errorf("Segments of silence = %v, want none", segments)
+	}
+	if whole, err := activity.Measure(silence, silence, frames); err != nil || whole != 100 {
+		t.Errorf("Measure of silence = %v, %v, want the 100 frames of the whole signal", whole, err)
+	}
+}
+
+func TestReadSegments(t *testing.T) {
+	segments, err := ReadSegments(strings.NewReader("# Audacity labels\n0.5\t1.25\tspeech\n\n2 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Segments{{Start: 0.5, End: 1.25}, {Start: 2, End: 3}}); !reflect.DeepEqual(segments, want) {
+		t.Errorf("ReadSegments = %v, want %v", segments, want)
+	}
+	for _, invalid := range []string{"", "# only a comment\n", "1\n", "a b\n", "2 1\n"} {
+		if _, err := ReadSegments(strings.NewReader(invalid)); err == nil {
+			t.Errorf("ReadSegments(%q) returned no error", invalid)
+		}
+	}
+}
+
+func TestFFT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]complex128, 64)
+	for index := range values {
+		values[index] = complex(rng.NormFloat64(), rng.NormFloat64())
+	}
+	want := make([]complex128, len(values))
+	for freq := range want {
+		for index, value := range values {
+			want[freq] += value * cmplx.Exp(complex(0, -2*math.Pi*float64(freq*index)/float64(len(values))))
+		}
+	}
+	FFT(values)
+	for freq := range want {
+		if cmplx.Abs(values[freq]-want[freq]) > 1e-9 {
+			t.Errorf("fft bin %v = %v, want %v", freq, values[freq], want[freq])
+		}
+	}
+}
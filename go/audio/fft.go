@@ -0,0 +1,46 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// FFT replaces the values, whose length must be a power of 2, with their discrete Fourier transform.
+func FFT(values []complex128) {
+	n := len(values)
+	for index, reversed := 1, 0; index < n; index++ {
+		bit := n >> 1
+		for ; reversed&bit != 0; bit >>= 1 {
+			reversed ^= bit
+		}
+		reversed ^= bit
+		if index < reversed {
+			values[index], values[reversed] = values[reversed], values[index]
+		}
+	}
+	for length := 2; length <= n; length <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(length)))
+		for start := 0; start < n; start += length {
+			twiddle := complex(1, 0)
+			for index := 0; index < length/2; index++ {
+				even, odd := values[start+index], values[start+index+length/2]*twiddle
+				values[start+index], values[start+index+length/2] = even+odd, even-odd
+				twiddle *= step
+			}
+		}
+	}
+}
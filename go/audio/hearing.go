@@ -0,0 +1,147 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// AudiogramPoint is the hearing threshold shift of a listener at a frequency.
+type AudiogramPoint struct {
+	Frequency float64
+	LossDB    float64
+}
+
+// Audiogram describes the hearing loss of a listener as hearing threshold shifts at a set of frequencies.
+type Audiogram []AudiogramPoint
+
+// bisgaardFrequencies are the frequencies of the standard audiograms in HearingLossProfiles.
+var bisgaardFrequencies = []float64{250, 500, 750, 1000, 1500, 2000, 3000, 4000, 6000}
+
+func bisgaard(losses ...float64) Audiogram {
+	result := Audiogram{}
+	for index, loss := range losses {
+		result = append(result, AudiogramPoint{Frequency: bisgaardFrequencies[index], LossDB: loss})
+	}
+	return result
+}
+
+// HearingLossProfiles contains standard audiograms from N. Bisgaard, M. S. M. G. Vlaming, and M. Dahlquist,
+// "Standard audiograms for the IEC 60118-15 measurement procedure", Trends in Amplification, 2010.
+//
+// N1 to N4 are flat and moderately sloping losses from very mild to moderate/severe, and S1 to S3 are steeply
+// sloping losses from very mild to moderate.
+var HearingLossProfiles = map[string]Audiogram{
+	"N1": bisgaard(10, 10, 10, 10, 10, 15, 20, 30, 40),
+	"N2": bisgaard(20, 20, 22.5, 25, 30, 35, 40, 45, 50),
+	"N3": bisgaard(35, 35, 35, 35, 40, 45, 50, 55, 60),
+	"N4": bisgaard(55, 55, 55, 55, 55, 60, 65, 70, 75),
+	"S1": bisgaard(10, 10, 10, 10, 10, 15, 30, 55, 70),
+	"S2": bisgaard(20, 20, 22.5, 25, 35, 55, 75, 95, 95),
+	"S3": bisgaard(30, 30, 35, 47.5, 60, 70, 75, 80, 80),
+}
+
+// ParseAudiogram returns the profile in HearingLossProfiles with the given name, or the audiogram in the given
+// JSON array, e.g. [{"Frequency": 1000, "LossDB": 20}, {"Frequency": 4000, "LossDB": 45}].
+func ParseAudiogram(s string) (Audiogram, error) {
+	if profile, found := HearingLossProfiles[s]; found {
+		return profile, nil
+	}
+	result := Audiogram{}
+	if err := json.Unmarshal([]byte(s), &result); err != nil {
+		names := []string{}
+		for name := range HearingLossProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%q is neither one of the profiles %v nor a JSON audiogram: %v", s, names, err)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("empty audiogram %q", s)
+	}
+	return result, nil
+}
+
+// LossDB returns the hearing threshold shift at the frequency, interpolated linearly on a logarithmic frequency
+// scale between the points of the audiogram, and constant beyond its lowest and highest frequencies.
+func (a Audiogram) LossDB(frequency float64) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+	sorted := append(Audiogram{}, a...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Frequency < sorted[j].Frequency
+	})
+	if frequency <= sorted[0].Frequency {
+		return sorted[0].LossDB
+	}
+	for index := 1; index < len(sorted); index++ {
+		if frequency <= sorted[index].Frequency {
+			low, high := sorted[index-1], sorted[index]
+			weight := math.Log(frequency/low.Frequency) / math.Log(high.Frequency/low.Frequency)
+			return low.LossDB + weight*(high.LossDB-low.LossDB)
+		}
+	}
+	return sorted[len(sorted)-1].LossDB
+}
+
+// hearingLossTaps is the length of the hearing loss filter, which at 48kHz resolves about 94Hz.
+const hearingLossTaps = 511
+
+// hearingLossFilter returns a linear phase FIR filter attenuating each frequency by the hearing loss, designed
+// using the frequency sampling method.
+func (a Audiogram) hearingLossFilter(rate float64) []float64 {
+	n := hearingLossTaps
+	middle := (n - 1) / 2
+	gains := make([]float64, middle+1)
+	for k := range gains {
+		// The DC gain uses the gain of the lowest audiogram frequency.
+		gains[k] = math.Pow(10, -a.LossDB(max(1, float64(k)*rate/float64(n)))/20)
+	}
+	result := make([]float64, n)
+	for tap := range result {
+		sum := gains[0]
+		for k := 1; k <= middle; k++ {
+			sum += 2 * gains[k] * math.Cos(2*math.Pi*float64(k)*float64(tap-middle)/float64(n))
+		}
+		result[tap] = sum / float64(n)
+	}
+	return result
+}
+
+// SimulateHearingLoss filters each channel to attenuate each frequency by the hearing threshold shift of the audiogram.
+//
+// The filter has linear phase, and its delay is compensated, so the audio stays aligned with unfiltered audio.
+func (a *Audio) SimulateHearingLoss(audiogram Audiogram) {
+	filter := audiogram.hearingLossFilter(a.Rate)
+	middle := len(filter) / 2
+	for channelIndex, channel := range a.Samples {
+		filtered := make([]float32, len(channel))
+		for sampleIndex := range filtered {
+			sum := 0.0
+			for tap, coefficient := range filter {
+				if inputIndex := sampleIndex + middle - tap; inputIndex >= 0 && inputIndex < len(channel) {
+					sum += coefficient * float64(channel[inputIndex])
+				}
+			}
+			filtered[sampleIndex] = float32(sum)
+		}
+		a.Samples[channelIndex] = filtered
+	}
+	a.updateMaxAbsAmplitude()
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import (
+	"fmt"
+	"math"
+)
+
+// EnergyDBFS returns the mean energy of all samples in dB FS, or -Inf for empty or silent audio.
+func (a *Audio) EnergyDBFS() float64 {
+	energy, numSamples := 0.0, 0
+	for _, channel := range a.Samples {
+		for _, sample := range channel {
+			energy += float64(sample) * float64(sample)
+		}
+		numSamples += len(channel)
+	}
+	if energy == 0 {
+		return math.Inf(-1)
+	}
+	return 10 * math.Log10(energy/float64(numSamples))
+}
+
+// NumSamples returns the number of samples in all channels.
+func (a *Audio) NumSamples() int {
+	result := 0
+	for _, channel := range a.Samples {
+		result += len(channel)
+	}
+	return result
+}
+
+// ClippedSamples returns the number of samples with an absolute amplitude of at least amplitude
+// that are part of runs of at least minRun such consecutive samples in the same channel.
+func (a *Audio) ClippedSamples(amplitude float64, minRun int) int {
+	result := 0
+	for _, channel := range a.Samples {
+		run := 0
+		for sampleIndex := 0; sampleIndex <= len(channel); sampleIndex++ {
+			if sampleIndex < len(channel) && math.Abs(float64(channel[sampleIndex])) >= amplitude {
+				run++
+				continue
+			}
+			if run >= minRun {
+				result += run
+			}
+			run = 0
+		}
+	}
+	return result
+}
+
+// WarningKind is a kind of problem with the level of audio.
+type WarningKind string
+
+const (
+	// Clipping means the audio contains runs of samples at full scale, typical of a saturated capture chain.
+	Clipping WarningKind = "clipping"
+	// LowLevel means the audio is so quiet that it is probably silence or noise floor.
+	LowLevel WarningKind = "low_level"
+)
+
+// Warning is a problem with the level of audio.
+type Warning struct {
+	Kind WarningKind
+	// Value is the clipped fraction of the samples for Clipping, and the energy in dB FS for LowLevel.
+	Value float64
+}
+
+func (w Warning) String() string {
+	switch w.Kind {
+	case Clipping:
+		return fmt.Sprintf("%s: %.3f%% of the samples are clipped", w.Kind, 100*w.Value)
+	case LowLevel:
+		return fmt.Sprintf("%s: energy is %.1f dB FS", w.Kind, w.Value)
+	}
+	return fmt.Sprintf("%s: %v", w.Kind, w.Value)
+}
+
+// LevelCheck defines when audio levels are considered problematic.
+type LevelCheck struct {
+	// ClippingAmplitude is the absolute amplitude at or above which a sample is considered clipped.
+	ClippingAmplitude float64
+	// MinClippedRun is the min number of consecutive samples at ClippingAmplitude considered clipping,
+	// since single peaks at full scale are common in properly normalized audio.
+	MinClippedRun int
+	// MaxClippedFraction is the fraction of clipped samples above which a Clipping warning is produced.
+	MaxClippedFraction float64
+	// MinEnergyDBFS is the energy below which a LowLevel warning is produced.
+	MinEnergyDBFS float64
+}
+
+// DefaultLevelCheck is a LevelCheck suitable for most audio.
+var DefaultLevelCheck = LevelCheck{
+	ClippingAmplitude:  0.999,
+	MinClippedRun:      3,
+	MaxClippedFraction: 0,
+	MinEnergyDBFS:      -60,
+}
+
+// Check returns the warnings the check produces for the audio.
+func (l LevelCheck) Check(a *Audio) []Warning {
+	result := []Warning{}
+	numSamples := a.NumSamples()
+	if numSamples == 0 {
+		return result
+	}
+	if clippedFraction := float64(a.ClippedSamples(l.ClippingAmplitude, l.MinClippedRun)) / float64(numSamples); clippedFraction > l.MaxClippedFraction {
+		result = append(result, Warning{Kind: Clipping, Value: clippedFraction})
+	}
+	if energyDBFS := a.EnergyDBFS(); energyDBFS < l.MinEnergyDBFS {
+		result = append(result, Warning{Kind: LowLevel, Value: energyDBFS})
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import "math"
+
+// Clone returns a deep copy of the audio.
+func (a *Audio) Clone() *Audio {
+	result := &Audio{
+		Samples:         make([][]float32, len(a.Samples)),
+		Rate:            a.Rate,
+		MaxAbsAmplitude: a.MaxAbsAmplitude,
+	}
+	for channelIndex, channel := range a.Samples {
+		result.Samples[channelIndex] = append([]float32{}, channel...)
+	}
+	return result
+}
+
+// Mono returns a copy of the audio with the mean of its channels as its only channel.
+func (a *Audio) Mono() *Audio {
+	result := &Audio{
+		Samples: [][]float32{nil},
+		Rate:    a.Rate,
+	}
+	if len(a.Samples) > 0 {
+		result.Samples[0] = make([]float32, len(a.Samples[0]))
+	}
+	for _, channel := range a.Samples {
+		for sampleIndex, sample := range channel {
+			result.Samples[0][sampleIndex] += sample / float32(len(a.Samples))
+		}
+	}
+	result.updateMaxAbsAmplitude()
+	return result
+}
+
+func (a *Audio) updateMaxAbsAmplitude() {
+	a.MaxAbsAmplitude = 0
+	for _, channel := range a.Samples {
+		for _, sample := range channel {
+			if absSample := float32(math.Abs(float64(sample))); absSample > a.MaxAbsAmplitude {
+				a.MaxAbsAmplitude = absSample
+			}
+		}
+	}
+}
+
+// RemoveDCOffset subtracts the mean of each channel from its samples.
+func (a *Audio) RemoveDCOffset() {
+	for _, channel := range a.Samples {
+		if len(channel) == 0 {
+			continue
+		}
+		sum := 0.0
+		for _, sample := range channel {
+			sum += float64(sample)
+		}
+		mean := float32(sum / float64(len(channel)))
+		for sampleIndex := range channel {
+			channel[sampleIndex] -= mean
+		}
+	}
+	a.updateMaxAbsAmplitude()
+}
+
+// TrimSilence removes the leading and trailing frames where all channels are quieter than thresholdDBFS.
+//
+// Audio that is silent throughout is left unchanged.
+func (a *Audio) TrimSilence(thresholdDBFS float64) {
+	if len(a.Samples) == 0 {
+		return
+	}
+	threshold := float32(math.Pow(10, thresholdDBFS/20))
+	loud := func(frameIndex int) bool {
+		for _, channel := range a.Samples {
+			if float32(math.Abs(float64(channel[frameIndex]))) >= threshold {
+				return true
+			}
+		}
+		return false
+	}
+	start, end := 0, len(a.Samples[0])
+	for start < end && !loud(start) {
+		start++
+	}
+	if start == end {
+		return
+	}
+	for !loud(end - 1) {
+		end--
+	}
+	for channelIndex, channel := range a.Samples {
+		a.Samples[channelIndex] = channel[start:end]
+	}
+}
+
+// Preprocessing defines optional processing of audio before it is compared.
+type Preprocessing struct {
+	// RemoveDCOffset makes Apply remove the DC offset of each channel.
+	RemoveDCOffset bool
+	// TrimSilence makes Apply remove leading and trailing frames quieter than SilenceThresholdDBFS.
+	TrimSilence bool
+	// SilenceThresholdDBFS is the level below which frames are considered silent.
+	SilenceThresholdDBFS float64
+	// HearingLoss, if set, makes Apply simulate how the audio is heard by a listener with the hearing loss.
+	HearingLoss Audiogram
+}
+
+// Enabled returns whether the preprocessing does anything.
+func (p Preprocessing) Enabled() bool {
+	return p.RemoveDCOffset || p.TrimSilence || len(p.HearingLoss) > 0
+}
+
+// Apply returns a preprocessed copy of the audio, or the audio itself if the preprocessing does nothing.
+//
+// The DC offset is removed before silence is trimmed, so that an offset isn't mistaken for sound, and the hearing
+// loss is simulated last, so that silence is trimmed at the original levels.
+func (p Preprocessing) Apply(a *Audio) *Audio {
+	if !p.Enabled() {
+		return a
+	}
+	result := a.Clone()
+	if p.RemoveDCOffset {
+		result.RemoveDCOffset()
+	}
+	if p.TrimSilence {
+		result.TrimSilence(p.SilenceThresholdDBFS)
+	}
+	if len(p.HearingLoss) > 0 {
+		result.SimulateHearingLoss(p.HearingLoss)
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Segment is a time interval of audio, e.g. a phrase rated by listeners.
+type Segment struct {
+	// Start is the start of the segment in seconds.
+	Start float64
+	// End is the end of the segment in seconds.
+	End float64
+}
+
+func (s Segment) String() string {
+	return fmt.Sprintf("%v-%v", s.Start, s.End)
+}
+
+// Extract returns a copy of the part of the audio within the segment, truncated to the end of the audio.
+func (s Segment) Extract(a *Audio) *Audio {
+	result := &Audio{
+		Samples: make([][]float32, len(a.Samples)),
+		Rate:    a.Rate,
+	}
+	for channelIndex, channel := range a.Samples {
+		start, end := s.bounds(a.Rate, len(channel))
+		result.Samples[channelIndex] = append([]float32{}, channel[start:end]...)
+	}
+	result.updateMaxAbsAmplitude()
+	return result
+}
+
+// bounds returns the sample indices of the segment in a channel with the rate and length.
+func (s Segment) bounds(rate float64, length int) (start, end int) {
+	start = min(length, max(0, int(math.Round(s.Start*rate))))
+	end = min(length, max(start, int(math.Round(s.End*rate))))
+	return start, end
+}
+
+// frames returns the number of frames of the audio within the segment.
+func (s Segment) frames(a *Audio) int {
+	if len(a.Samples) == 0 {
+		return 0
+	}
+	start, end := s.bounds(a.Rate, len(a.Samples[0]))
+	return end - start
+}
+
+// Segments are the parts of audio that are measured.
+type Segments []Segment
+
+// ParseSegments returns the segments in a comma separated list of start-end pairs in seconds, like "0.5-2,3.1-4.7".
+func ParseSegments(s string) (Segments, error) {
+	result := Segments{}
+	for _, pair := range strings.Split(s, ",") {
+		start, end, found := strings.Cut(strings.TrimSpace(pair), "-")
+		if !found {
+			return nil, fmt.Errorf("segment %q isn't a start-end pair", pair)
+		}
+		segment := Segment{}
+		var err error
+		if segment.Start, err = strconv.ParseFloat(start, 64); err != nil {
+			return nil, fmt.Errorf("segment %q: %v", pair, err)
+		}
+		if segment.End, err = strconv.ParseFloat(end, 64); err != nil {
+			return nil, fmt.Errorf("segment %q: %v", pair, err)
+		}
+		result = append(result, segment)
+	}
+	return result, result.Validate()
+}
+
+// Validate returns an error if any segment doesn't start before it ends, or starts before 0.
+func (s Segments) Validate() error {
+	for _, segment := range s {
+		if segment.Start < 0 || segment.End <= segment.Start {
+			return fmt.Errorf("segment %v doesn't start at or after 0 and before its end", segment)
+		}
+	}
+	return nil
+}
+
+// Windows returns consecutive segments of the length in seconds covering the duration in seconds, where the last
+// one may be shorter.
+func Windows(duration, length float64) Segments {
+	result := Segments{}
+	for start := 0.0; start < duration; start += length {
+		result = append(result, Segment{Start: start, End: math.Min(duration, start+length)})
+	}
+	return result
+}
+
+// Measure returns the mean of the measure of the segments of the reference and the distortion, weighted by the
+// duration of the segments, with the same segments of both signals measured against each other.
+//
+// Segments outside the signals are ignored, and if no segment overlaps the signals it returns an error.
+func (s Segments) Measure(reference, distortion *Audio, measure func(reference, distortion *Audio) (float64, error)) (float64, error) {
+	result, _, err := s.MeasureUntil(reference, distortion, math.Inf(1), measure)
+	return result, err
+}
+
+// MeasureUntil is like Measure, but stops measuring segments as soon as the mean is guaranteed to exceed limit,
+// which requires that measure never returns negative values.
+//
+// It returns whether the limit was exceeded, and if it was, the lower bound of the mean given by the segments
+// measured so far instead of the mean.
+func (s Segments) MeasureUntil(reference, distortion *Audio, limit float64, measure func(reference, distortion *Audio) (float64, error)) (float64, bool, error) {
+	frames := make([]int, len(s))
+	totalFrames := 0
+	for index, segment := range s {
+		frames[index] = min(segment.frames(reference), segment.frames(distortion))
+		totalFrames += frames[index]
+	}
+	if totalFrames == 0 {
+		return 0, false, fmt.Errorf("none of the segments %v overlap the audio", s)
+	}
+	sum := 0.0
+	for index, segment := range s {
+		if frames[index] == 0 {
+			continue
+		}
+		value, err := measure(segment.Extract(reference), segment.Extract(distortion))
+		if err != nil {
+			return 0, false, fmt.Errorf("segment %v: %v", segment, err)
+		}
+		sum += value * float64(frames[index])
+		if bound := sum / float64(totalFrames); bound > limit {
+			return bound, true, nil
+		}
+	}
+	return sum / float64(totalFrames), false, nil
+}
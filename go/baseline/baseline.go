@@ -0,0 +1,162 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package baseline implements naive signal level metrics, to show how much perceptual metrics improve over them.
+//
+// All metrics compare the channels of the reference and the distortion pairwise, and truncate the longer signal to
+// the length of the shorter.
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+const (
+	// frameLen is the length of the frames of SpectralDistance.
+	frameLen = 1024
+	// powerFloor is added to the powers of SpectralDistance, about -100 dB FS for full scale frames, so that silence
+	// in one of the signals doesn't dominate the distance.
+	powerFloor = 1e-4
+)
+
+// eps avoids divisions by zero and logarithms of zero for identical or silent signals.
+var eps = math.Nextafter(1, 2) - 1
+
+// channelPairs returns the channels of the reference and the distortion, truncated to the same length.
+func channelPairs(reference, distortion *audio.Audio) ([][2][]float32, error) {
+	if reference.Rate != distortion.Rate {
+		return nil, fmt.Errorf("reference rate %v != distortion rate %v", reference.Rate, distortion.Rate)
+	}
+	if len(reference.Samples) != len(distortion.Samples) {
+		return nil, fmt.Errorf("reference has %v channels, distortion has %v", len(reference.Samples), len(distortion.Samples))
+	}
+	result := make([][2][]float32, len(reference.Samples))
+	for channel := range result {
+		ref, dist := reference.Samples[channel], distortion.Samples[channel]
+		length := min(len(ref), len(dist))
+		if length == 0 {
+			return nil, fmt.Errorf("channel %v is empty", channel)
+		}
+		result[channel] = [2][]float32{ref[:length], dist[:length]}
+	}
+	return result, nil
+}
+
+// SNR returns the signal-to-noise ratio in dB of the distortion, with the difference to the reference as noise.
+// Higher is better.
+func SNR(reference, distortion *audio.Audio) (float64, error) {
+	pairs, err := channelPairs(reference, distortion)
+	if err != nil {
+		return 0, err
+	}
+	signal, noise := 0.0, 0.0
+	for _, pair := range pairs {
+		for index, ref := range pair[0] {
+			diff := float64(pair[1][index]) - float64(ref)
+			signal += float64(ref) * float64(ref)
+			noise += diff * diff
+		}
+	}
+	return 10 * math.Log10((signal+eps)/(noise+eps)), nil
+}
+
+// SISDR returns the scale-invariant signal-to-distortion ratio in dB by Le Roux et al., "SDR – half-baked or well
+// done?", averaged over the channels. Higher is better.
+//
+// Unlike SNR, it doesn't penalize distortions that only differ from the reference in gain.
+func SISDR(reference, distortion *audio.Audio) (float64, error) {
+	pairs, err := channelPairs(reference, distortion)
+	if err != nil {
+		return 0, err
+	}
+	sum := 0.0
+	for _, pair := range pairs {
+		ref, dist := zeroMean(pair[0]), zeroMean(pair[1])
+		refEnergy, product := 0.0, 0.0
+		for index := range ref {
+			refEnergy += ref[index] * ref[index]
+			product += ref[index] * dist[index]
+		}
+		scale := product / (refEnergy + eps)
+		target, noise := 0.0, 0.0
+		for index := range ref {
+			projected := scale * ref[index]
+			target += projected * projected
+			noise += (dist[index] - projected) * (dist[index] - projected)
+		}
+		sum += 10 * math.Log10((target+eps)/(noise+eps))
+	}
+	return sum / float64(len(pairs)), nil
+}
+
+// zeroMean returns the samples minus their mean.
+func zeroMean(samples []float32) []float64 {
+	mean := 0.0
+	for _, sample := range samples {
+		mean += float64(sample)
+	}
+	mean /= float64(len(samples))
+	result := make([]float64, len(samples))
+	for index, sample := range samples {
+		result[index] = float64(sample) - mean
+	}
+	return result
+}
+
+// SpectralDistance returns the log-spectral distance in dB between the reference and the distortion, i.e. the root
+// mean square difference of their power spectra in dB, averaged over half overlapping Hann windowed frames of 1024
+// samples and the channels. Lower is better.
+func SpectralDistance(reference, distortion *audio.Audio) (float64, error) {
+	pairs, err := channelPairs(reference, distortion)
+	if err != nil {
+		return 0, err
+	}
+	window := make([]float64, frameLen)
+	for index := range window {
+		window[index] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(index)/frameLen)
+	}
+	refSpectrum, distSpectrum := make([]complex128, frameLen), make([]complex128, frameLen)
+	// powerDB returns the power in dB of the FFT bin.
+	powerDB := func(value complex128) float64 {
+		magnitude := cmplx.Abs(value)
+		return 10 * math.Log10(magnitude*magnitude+powerFloor)
+	}
+	sum, frames := 0.0, 0
+	for _, pair := range pairs {
+		// Signals shorter than a frame are zero padded to one frame.
+		for start := 0; start == 0 || start+frameLen <= len(pair[0]); start += frameLen / 2 {
+			for index, weight := range window {
+				refSpectrum[index], distSpectrum[index] = 0, 0
+				if start+index < len(pair[0]) {
+					refSpectrum[index] = complex(weight*float64(pair[0][start+index]), 0)
+					distSpectrum[index] = complex(weight*float64(pair[1][start+index]), 0)
+				}
+			}
+			audio.FFT(refSpectrum)
+			audio.FFT(distSpectrum)
+			squares := 0.0
+			for bin := 0; bin <= frameLen/2; bin++ {
+				diff := powerDB(refSpectrum[bin]) - powerDB(distSpectrum[bin])
+				squares += diff * diff
+			}
+			sum += math.Sqrt(squares / (frameLen/2 + 1))
+			frames++
+		}
+	}
+	return sum / float64(frames), nil
+}
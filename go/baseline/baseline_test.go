@@ -0,0 +1,123 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseline
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// sine returns a second of a sine at the frequency and amplitude, sampled at 48 kHz.
+func sine(freq, amplitude float64) *audio.Audio {
+	samples := make([]float32, 48000)
+	for index := range samples {
+		samples[index] = float32(amplitude * math.Sin(2*math.Pi*freq*float64(index)/48000))
+	}
+	return &audio.Audio{Samples: [][]float32{samples}, Rate: 48000}
+}
+
+// sum returns the sample wise sum of the audio.
+func sum(a, b *audio.Audio) *audio.Audio {
+	samples := make([]float32, len(a.Samples[0]))
+	for index := range samples {
+		samples[index] = a.Samples[0][index] + b.Samples[0][index]
+	}
+	return &audio.Audio{Samples: [][]float32{samples}, Rate: a.Rate}
+}
+
+func TestMetrics(t *testing.T) {
+	reference := sine(440, 0.5)
+	for _, tc := range []struct {
+		name       string
+		distortion *audio.Audio
+		snr        float64
+		sisdr      float64
+		// minSpectralDistance and maxSpectralDistance are the expected range of the spectral distance.
+		minSpectralDistance float64
+		maxSpectralDistance float64
+	}{
+		{
+			name:                "identical",
+			distortion:          reference,
+			snr:                 math.Inf(1),
+			sisdr:               math.Inf(1),
+			minSpectralDistance: 0,
+			maxSpectralDistance: 1e-9,
+		},
+		{
+			name:                "added tone at -20 dB",
+			distortion:          sum(reference, sine(3000, 0.05)),
+			snr:                 20,
+			sisdr:               20,
+			minSpectralDistance: 1,
+			maxSpectralDistance: math.Inf(1),
+		},
+		{
+			name:                "half gain",
+			distortion:          sine(440, 0.25),
+			snr:                 20 * math.Log10(2),
+			sisdr:               math.Inf(1),
+			minSpectralDistance: 1,
+			maxSpectralDistance: math.Inf(1),
+		},
+	} {
+		snr, err := SNR(reference, tc.distortion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sisdr, err := SISDR(reference, tc.distortion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spectralDistance, err := SpectralDistance(reference, tc.distortion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Ratios of identical signals are only limited by rounding.
+		for name, got := range map[string][2]float64{"SNR": {snr, tc.snr}, "SISDR": {sisdr, tc.sisdr}} {
+			if math.IsInf(got[1], 1) {
+				if got[0] < 100 {
+					t.Errorf("%s: %s = %v, want at least 100", tc.name, name, got[0])
+				}
+			} else if math.Abs(got[0]-got[1]) > 0.1 {
+				t.Errorf("%s: %s = %v, want %v", tc.name, name, got[0], got[1])
+			}
+		}
+		if spectralDistance < tc.minSpectralDistance || spectralDistance > tc.maxSpectralDistance {
+			t.Errorf("%s: SpectralDistance = %v, want in [%v, %v]", tc.name, spectralDistance, tc.minSpectralDistance, tc.maxSpectralDistance)
+		}
+	}
+}
+
+func TestChannelPairs(t *testing.T) {
+	reference := &audio.Audio{Samples: [][]float32{{1, 2, 3}, {4, 5, 6}}, Rate: 10}
+	pairs, err := channelPairs(reference, &audio.Audio{Samples: [][]float32{{1, 2}, {3, 4}}, Rate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range pairs {
+		if len(pair[0]) != 2 || len(pair[1]) != 2 {
+			t.Errorf("channelPairs returned channels of lengths %v and %v, want 2", len(pair[0]), len(pair[1]))
+		}
+	}
+	if _, err := channelPairs(reference, &audio.Audio{Samples: [][]float32{{1, 2, 3}}, Rate: 10}); err == nil {
+		t.Errorf("channelPairs with different numbers of channels returned no error")
+	}
+	if _, err := channelPairs(reference, &audio.Audio{Samples: [][]float32{{1, 2, 3}, {4, 5, 6}}, Rate: 20}); err == nil {
+		t.Errorf("channelPairs with different rates returned no error")
+	}
+}
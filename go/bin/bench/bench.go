@@ -0,0 +1,156 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// bench measures the throughput of Zimtohrli analyses and comparisons via goohrli.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/profile"
+)
+
+// result is the JSON output for one benchmark configuration.
+type result struct {
+	SignalDuration       goohrli.Duration
+	SampleRate           float64
+	Threads              int
+	Analyses             int
+	AnalysesPerSecond    float64
+	Comparisons          int
+	ComparisonsPerSecond float64
+	// RealtimeFactor is the number of seconds of signal pairs compared per second.
+	RealtimeFactor float64
+	// MaxRSSBytes is the max resident set size of the process so far, or 0 where it isn't available.
+	MaxRSSBytes int64
+}
+
+// repeat runs f in threads goroutines, each with its own Goohrli, until minTime has passed, and returns
+// the number of calls and the operations per second.
+func repeat(params goohrli.Parameters, threads int, minTime time.Duration, f func(g *goohrli.Goohrli)) (int, float64) {
+	calls := int64(0)
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for thread := 0; thread < threads; thread++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g := goohrli.New(params)
+			for time.Since(start) < minTime {
+				f(g)
+				atomic.AddInt64(&calls, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(calls), float64(calls) / time.Since(start).Seconds()
+}
+
+func bench(signalDuration time.Duration, sampleRate float64, threads int, minTime time.Duration, rng *rand.Rand) result {
+	params := goohrli.DefaultParameters(sampleRate)
+	reference := make([]float32, int(signalDuration.Seconds()*sampleRate))
+	distortion := make([]float32, len(reference))
+	for index := range reference {
+		reference[index] = rng.Float32()*0.5 - 0.25
+		distortion[index] = reference[index] + rng.Float32()*0.02 - 0.01
+	}
+	res := result{
+		SignalDuration: goohrli.Duration{Duration: signalDuration},
+		SampleRate:     sampleRate,
+		Threads:        threads,
+	}
+	res.Analyses, res.AnalysesPerSecond = repeat(params, threads, minTime, func(g *goohrli.Goohrli) {
+		g.Analyze(reference)
+	})
+	res.Comparisons, res.ComparisonsPerSecond = repeat(params, threads, minTime, func(g *goohrli.Goohrli) {
+		g.Distance(reference, distortion)
+	})
+	res.RealtimeFactor = res.ComparisonsPerSecond * signalDuration.Seconds()
+	res.MaxRSSBytes = maxRSSBytes()
+	return res
+}
+
+func main() {
+	durations := flag.String("durations", "1s,10s", "Comma separated durations of the benchmarked signals.")
+	sampleRates := flag.String("sample_rates", "48000", "Comma separated sample rates of the benchmarked signals.")
+	threads := flag.String("threads", fmt.Sprintf("1,%v", runtime.NumCPU()), "Comma separated numbers of concurrent goroutines, each using its own Goohrli instance.")
+	minTime := flag.Duration("min_time", 5*time.Second, "Min time to spend on analyses, and on comparisons, for each configuration.")
+	seed := flag.Int64("seed", 0, "Seed for the random signals.")
+	prof := profile.Flags()
+	flag.Parse()
+	stopProfile, err := prof.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := stopProfile(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	parsedDurations := []time.Duration{}
+	for _, field := range strings.Split(*durations, ",") {
+		d, err := time.ParseDuration(field)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid -durations value %q", field)
+		}
+		parsedDurations = append(parsedDurations, d)
+	}
+	parsedSampleRates := []float64{}
+	for _, field := range strings.Split(*sampleRates, ",") {
+		rate, err := strconv.ParseFloat(field, 64)
+		if err != nil || rate <= 0 {
+			log.Fatalf("invalid -sample_rates value %q", field)
+		}
+		parsedSampleRates = append(parsedSampleRates, rate)
+	}
+	parsedThreads := []int{}
+	for _, field := range strings.Split(*threads, ",") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid -threads value %q", field)
+		}
+		parsedThreads = append(parsedThreads, n)
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	results := []result{}
+	for _, sampleRate := range parsedSampleRates {
+		for _, signalDuration := range parsedDurations {
+			for _, n := range parsedThreads {
+				log.Printf("Benchmarking %v signals at %vHz with %v threads", signalDuration, sampleRate, n)
+				results = append(results, bench(signalDuration, sampleRate, n, *minTime, rng))
+			}
+		}
+	}
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, err := os.Stdout.Write(append(b, '\n')); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// maxRSSBytes returns the peak resident memory of the process in bytes, or 0 if it can't be read.
+func maxRSSBytes() int64 {
+	usage := &syscall.Rusage{}
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, usage); err != nil {
+		return 0
+	}
+	// Maxrss is in bytes on darwin, and in kilobytes elsewhere.
+	if runtime.GOOS == "darwin" {
+		return usage.Maxrss
+	}
+	return usage.Maxrss * 1024
+}
@@ -22,48 +22,413 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
 	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/logging"
 	"github.com/google/zimtohrli/go/pipe"
+	"github.com/google/zimtohrli/go/profile"
 )
 
+// paths is a flag.Value collecting the values of a repeated flag.
+type paths []string
+
+func (p *paths) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *paths) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+// comparison is the JSON output for a signal B.
+type comparison struct {
+	PathB       string
+	Metrics     map[string]float64
+	Reliability goohrli.Reliability
+	// Permutation contains, for each channel of signal A, the channel of signal B assigned to it, if
+	// -permutation_invariant is set.
+	Permutation []int `json:",omitempty"`
+	// Delay is the delay of signal B relative to signal A in seconds, if -output_delay is set.
+	Delay *float64 `json:",omitempty"`
+	// ExceedsMaxDistance is whether the comparison stopped early since the Zimtohrli distance exceeds
+	// -max_distance, in which case the Zimtohrli metric is missing.
+	ExceedsMaxDistance bool `json:",omitempty"`
+}
+
 func main() {
-	pathA := flag.String("path_a", "", "Path to ffmpeg-decodable file with signal A.")
-	pathB := flag.String("path_b", "", "Path to ffmpeg-decodable file with signal B.")
+	pathA := flag.String("path_a", "", "Path to ffmpeg-decodable file with signal A, or - to read it from stdin.")
+	var pathB paths
+	flag.Var(&pathB, "path_b", "Path to ffmpeg-decodable file with signal B, or - to read it from stdin. Can be repeated to compare signal A to multiple signals, which will then be ranked by Zimtohrli distance.")
+	var encodeB paths
+	flag.Var(&encodeB, "encode_b", "ffmpeg audio encoder and output options, e.g. 'libopus -b:a 24k', to encode and decode signal A with and compare the result to signal A, as an additional signal B. Can be repeated to compare multiple encodings.")
+	rawFormat := flag.String("raw_format", "", "If set, the signals are headerless PCM with this ffmpeg sample format, e.g. s16le or f32le.")
+	rawRate := flag.Int("raw_rate", 48000, "Sample rate of headerless PCM signals.")
+	rawChannels := flag.Int("raw_channels", 1, "Number of interleaved channels in headerless PCM signals.")
+	rawSignals := flag.String("raw_signals", "ab", "Which signals -raw_format applies to: a, b, or ab.")
 	visqol := flag.Bool("visqol", false, "Whether to measure using ViSQOL.")
 	pipeMetric := flag.String("pipe_metric", "", "Path to a binary that serves metrics via stdin/stdout pipe. Install some of the via 'install_python_metrics.py'.")
 	zimtohrli := flag.Bool("zimtohrli", true, "Whether to measure using Zimtohrli.")
 	outputZimtohrliDistance := flag.Bool("output_zimtohrli_distance", false, "Whether to output the raw Zimtohrli distance instead of a mapped mean opinion score.")
+	mosMappingJSON, err := json.Marshal(goohrli.DefaultMOSMapping)
+	if err != nil {
+		log.Panic(err)
+	}
+	mosMappingFlag := flag.String("mos_mapping", string(mosMappingJSON), "JSON array with the coefficients [a, b, c] of the sigmoid a / (b + exp(c * distance)) mapping Zimtohrli distances to mean opinion scores.")
 	zimtohrliParameters := goohrli.DefaultParameters(48000)
 	b, err := json.Marshal(zimtohrliParameters)
 	if err != nil {
 		log.Panic(err)
 	}
 	zimtohrliParametersJSON := flag.String("zimtohrli_parameters", string(b), "Zimtohrli model parameters.")
+	fullScaleSineDB := flag.Float64("full_scale_sine_db", zimtohrliParameters.FullScaleSineDB, "Assumed playback level, in dB SPL, of a sine wave with amplitude 1. Masking and audibility depend on the absolute level, so this should match the calibration of the intended playback. Overrides -zimtohrli_parameters.")
+	ffmpeg := flag.String("ffmpeg", aio.FFmpeg, "Path to the ffmpeg binary used to decode and encode audio. Defaults to $ZIMTOHRLI_FFMPEG, or ffmpeg in $PATH.")
+	ffmpegArgs := flag.String("ffmpeg_args", strings.Join(aio.FFmpegArgs, " "), "Extra whitespace separated arguments to ffmpeg. Defaults to $ZIMTOHRLI_FFMPEG_ARGS.")
+	resampler := flag.String("resampler", aio.Resampler, "Options of the ffmpeg aresample filter used when audio is decoded at another sample rate than its own, e.g. 'resampler=soxr:precision=28'. Defaults to $ZIMTOHRLI_RESAMPLER, or the default ffmpeg resampler.")
+	forbidResampling := flag.Bool("forbid_resampling", false, "Whether to fail instead of resampling audio that doesn't have the sample rate it's compared at.")
+	reportResampling := flag.Bool("report_resampling", true, "Whether to probe the sample rate of the signals, and log when they are resampled, and with which resampler.")
+	removeDCOffset := flag.Bool("remove_dc_offset", false, "Whether to remove the DC offset of the signals before comparing them.")
+	trimSilence := flag.Bool("trim_silence", false, "Whether to remove leading and trailing silence from the signals before comparing them.")
+	hearingLoss := flag.String("hearing_loss", "", "If set, a hearing loss simulated before measuring, so that the scores are as heard by a listener with the loss. Either one of the standard audiograms N1-N4 and S1-S3 by Bisgaard et al., or a JSON array like '[{\"Frequency\": 1000, \"LossDB\": 20}, {\"Frequency\": 4000, \"LossDB\": 45}]' with hearing threshold shifts.")
+	silenceThreshold := flag.Float64("silence_threshold", -60, "Level in dB FS below which -trim_silence considers audio silent.")
+	checkLevels := flag.Bool("check_levels", false, "Whether to log warnings about clipped or near silent signals.")
+	failOnWarnings := flag.Bool("fail_on_warnings", false, "Whether -check_levels warnings should make the comparison fail.")
+	channelPolicy := flag.String("channel_policy", string(goohrli.ChannelsPerChannel), fmt.Sprintf("How to compare signals with multiple channels, one of %v.", goohrli.ChannelPolicies))
+	symmetry := flag.String("symmetry", string(goohrli.SymmetryForward), fmt.Sprintf("In which directions the Zimtohrli distance is computed, one of %v. The distance is not symmetric, and forward treats energy added and removed by signal B differently, while max and mean combine the distances from signal A to signal B and from signal B to signal A.", goohrli.Symmetries))
+	lengthPolicy := flag.String("length_policy", string(goohrli.LengthWarp), fmt.Sprintf("How to compare signals of different lengths, one of %v.", goohrli.LengthPolicies))
+	outputJSON := flag.Bool("output_json", false, "Whether to output a JSON array with the metrics and reliability of each signal B, instead of one line per metric.")
+	outputDelay := flag.Bool("output_delay", false, "Whether to output the delay of each signal B relative to signal A in seconds, estimated by cross correlation, e.g. to debug the delay of a codec pipeline. Use -length_policy align to compensate it before measuring.")
+	outputConfidence := flag.Bool("output_confidence", false, "Whether to output the confidence in each comparison, between 0 and 1, based on the duration, energy, and saturation of the signals.")
+	monitorInterval := flag.Duration("monitor_interval", 0, "If positive, -path_a and a single -path_b are decoded continuously, e.g. live captures, pipes, or growing files, and the Zimtohrli metric of the last -monitor_window is output every -monitor_interval until one of them ends.")
+	monitorWindow := flag.Duration("monitor_window", 3*time.Second, "Duration of the audio compared every -monitor_interval.")
+	monitorChannels := flag.Int("monitor_channels", 1, "Number of channels the signals are decoded to when -monitor_interval is set.")
+	monitorInputArgs := flag.String("monitor_input_args", "", "Whitespace separated ffmpeg arguments placed before each input when -monitor_interval is set, e.g. '-f pulse' to capture from PulseAudio devices, or '-follow 1' to keep reading growing files.")
+	selfTest := flag.Bool("self_test", false, "Whether to only verify that Zimtohrli, with the given parameters, satisfies basic invariants on synthetic signals, and exit with a non-zero status if it doesn't. Useful to detect broken builds and bad flags.")
+	segmentsFlag := flag.String("segments", "", "Comma separated start-end pairs in seconds, like '0.5-2,3.1-4.7', of the parts of the signals to compare, e.g. the phrases rated by listeners. The metrics are then the means of the metrics of the segments, weighted by duration.")
+	voiceActivity := flag.Bool("voice_activity", false, "Whether to only compare the parts of the signals where signal A has voice activity, detected by the energy of 20 ms frames, so that long silences in e.g. conversational speech don't dilute the metrics. The metrics are then the means of the metrics of the active parts, weighted by duration.")
+	voiceActivityThreshold := flag.Float64("voice_activity_threshold", audio.DefaultVoiceActivity.ThresholdDB, "Energy in dB, relative to the loudest frame of signal A, below which -voice_activity considers frames inactive.")
+	voiceActivityFile := flag.String("voice_activity_file", "", "Path to a file with the parts of the signals with voice activity to compare, with one whitespace separated start and end time in seconds, optionally followed by a label, per line, e.g. an Audacity label track or the output of an external voice activity detector.")
+	version := flag.Bool("version", false, "Whether to print the version and build flags of Zimtohrli and exit.")
+	precision := flag.Int("precision", -1, "If not negative, the number of significant digits metrics are rounded to before they are output, so that the output is identical across platforms with slightly different floating point results. Negative values output the shortest representation of the exact metrics.")
+	golden := flag.String("golden", "", "Path to a JSON file in the format of -output_json with the expected metrics. If set, the comparison fails when the metrics differ from those in the file by more than -golden_tolerance. A missing file is an error unless -update_golden is set.")
+	goldenTolerance := flag.Float64("golden_tolerance", 1e-4, "The max difference, relative to the expected value if its magnitude is above 1, between the output and the -golden metrics.")
+	updateGolden := flag.Bool("update_golden", false, "Whether to create or replace the -golden file with the output instead of checking it.")
+	maxDistance := flag.Float64("max_distance", 0, "If positive, the signals are compared in consecutive -max_distance_window windows, or the -segments, and the Zimtohrli distance is the mean of their distances weighted by duration. The comparison of a signal B stops early, and reports that the distance already exceeds the threshold, as soon as the distances of the compared windows guarantee that the mean exceeds this threshold, and the process then exits with a non-zero status.")
+	maxDistanceWindow := flag.Duration("max_distance_window", 5*time.Second, "Duration of the windows compared when -max_distance is set.")
+	verbose := flag.Bool("verbose", false, "Whether to log the time spent decoding each signal, including resampling which ffmpeg performs while decoding, and the time spent measuring each signal B, split into analyzing and comparing for Zimtohrli.")
+	resultCache := flag.String("result_cache", "", "Directory to store metrics in, keyed by the hashes of the compared signals and the metric parameters, to avoid measuring unchanged pairs again. -per_channel metrics aren't cached, and pipe metrics are keyed by path, so the cache must be cleared when they change.")
 	perChannel := flag.Bool("per_channel", false, "Whether to output the produced metric per channel instead of a single value for all channels.")
+	permutationInvariant := flag.Bool("permutation_invariant", false, fmt.Sprintf("Whether signal A contains the reference sources of a source separation, one per channel, and each signal B the separated sources in unknown order. The channels of signal B are then assigned to the channels of signal A so that the total Zimtohrli distance is minimal, and the Zimtohrli metric of each source is output as Zimtohrli#<channel of signal A>, along with the mean distance as Zimtohrli, and the assigned channels of signal B as Permutation. At most %v sources are supported, and the metrics aren't cached by -result_cache.", goohrli.MaxPermutedSources))
+	prof := profile.Flags()
+	logs := logging.Flags()
 	flag.Parse()
+	logs.Apply()
+	if *version {
+		fmt.Println(goohrli.Version())
+		return
+	}
+	aio.FFmpeg = *ffmpeg
+	aio.FFmpegArgs = strings.Fields(*ffmpegArgs)
+	aio.Resampler = *resampler
+	aio.ForbidResampling = *forbidResampling
+	if *verbose {
+		aio.OnDecode = func(timing aio.DecodeTiming) {
+			log.Printf("Decoded %v", timing)
+		}
+	}
+	if *reportResampling {
+		aio.OnResampling = func(r aio.Resampling) {
+			log.Print(r)
+		}
+	}
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	if setFlags["zimtohrli_parameters"] {
+		if err := zimtohrliParameters.Update([]byte(*zimtohrliParametersJSON)); err != nil {
+			log.Panic(err)
+		}
+	}
+	if setFlags["full_scale_sine_db"] {
+		zimtohrliParameters.FullScaleSineDB = *fullScaleSineDB
+	}
+	mosMapping := goohrli.MOSMapping{}
+	if err := json.Unmarshal([]byte(*mosMappingFlag), &mosMapping); err != nil {
+		log.Panic(err)
+	}
 
-	if *pathA == "" || *pathB == "" {
+	if *selfTest {
+		g := goohrli.New(zimtohrliParameters)
+		results := g.SelfCheck()
+		if *outputJSON {
+			b, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				log.Panic(err)
+			}
+			fmt.Println(string(b))
+		} else {
+			for _, result := range results {
+				fmt.Println(result)
+			}
+		}
+		if !results.Passed() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *pathA == "" || len(pathB)+len(encodeB) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
+	var segments audio.Segments
+	numSegmentFlags := 0
+	for _, set := range []bool{*segmentsFlag != "", *voiceActivity, *voiceActivityFile != ""} {
+		if set {
+			numSegmentFlags++
+		}
+	}
+	if numSegmentFlags > 1 {
+		log.Fatal("only one of -segments, -voice_activity, and -voice_activity_file can be set")
+	}
+	if *maxDistance > 0 && (*perChannel || *monitorInterval > 0 || !*zimtohrli) {
+		log.Fatal("-max_distance requires -zimtohrli, and can't be combined with -per_channel or -monitor_interval")
+	}
+	if numSegmentFlags > 0 && (*perChannel || *monitorInterval > 0) {
+		log.Fatal("-segments, -voice_activity, and -voice_activity_file can't be combined with -per_channel or -monitor_interval")
+	}
+	if *permutationInvariant && (!*zimtohrli || *perChannel || *maxDistance > 0 || *monitorInterval > 0 || numSegmentFlags > 0 || goohrli.ChannelPolicy(*channelPolicy) != goohrli.ChannelsPerChannel) {
+		log.Fatal("-permutation_invariant requires -zimtohrli and -channel_policy per_channel, and can't be combined with -per_channel, -max_distance, -monitor_interval, -segments, -voice_activity, or -voice_activity_file")
+	}
+	if *segmentsFlag != "" {
+		if segments, err = audio.ParseSegments(*segmentsFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *voiceActivityFile != "" {
+		if segments, err = audio.LoadSegments(*voiceActivityFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var cache *goohrli.ResultCache
+	if *resultCache != "" {
+		cache = &goohrli.ResultCache{Dir: *resultCache}
+	}
+	// measure returns the metric of the signals, or the mean of the metric of their -segments if set. The metric
+	// is described by key in -result_cache.
+	measure := func(signalA, signalB *audio.Audio, key string, metric func(signalA, signalB *audio.Audio) (float64, error)) (float64, error) {
+		if cache != nil {
+			metric = cache.Measurement(key, metric)
+		}
+		if len(segments) > 0 {
+			return segments.Measure(signalA, signalB, metric)
+		}
+		return metric(signalA, signalB)
+	}
 
-	signalA, err := aio.LoadAtRate(*pathA, int(zimtohrliParameters.SampleRate))
+	stopProfile, err := prof.Start()
 	if err != nil {
 		log.Panic(err)
 	}
-	signalB, err := aio.LoadAtRate(*pathB, int(zimtohrliParameters.SampleRate))
+	defer func() {
+		if err := stopProfile(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if *monitorInterval > 0 {
+		if len(pathB) != 1 {
+			log.Fatal("-monitor_interval requires exactly one -path_b")
+		}
+		if *golden != "" {
+			log.Fatal("-golden can't be combined with -monitor_interval")
+		}
+		rate := int(zimtohrliParameters.SampleRate)
+		streamA, err := aio.OpenStream(strings.Fields(*monitorInputArgs), *pathA, rate, *monitorChannels)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer streamA.Close()
+		streamB, err := aio.OpenStream(strings.Fields(*monitorInputArgs), pathB[0], rate, *monitorChannels)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer streamB.Close()
+		g := goohrli.New(zimtohrliParameters)
+		g.LengthPolicy = goohrli.LengthPolicy(*lengthPolicy)
+		g.ChannelPolicy = goohrli.ChannelPolicy(*channelPolicy)
+		g.Symmetry = goohrli.Symmetry(*symmetry)
+		m := &monitor{
+			g:        g,
+			interval: *monitorInterval,
+			window:   *monitorWindow,
+			metric: func(distance float64) float64 {
+				if *outputZimtohrliDistance {
+					return roundMetric(distance, *precision)
+				}
+				return roundMetric(mosMapping.MOS(distance), *precision)
+			},
+			outputJSON: *outputJSON,
+		}
+		if err := m.run(streamA, streamB, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	stdinUsers := 0
+	for _, path := range append([]string{*pathA}, pathB...) {
+		if path == "-" {
+			stdinUsers++
+		}
+	}
+	if stdinUsers > 1 {
+		log.Fatal("only one signal can be read from stdin")
+	}
+
+	preprocessing := audio.Preprocessing{
+		RemoveDCOffset:       *removeDCOffset,
+		TrimSilence:          *trimSilence,
+		SilenceThresholdDBFS: *silenceThreshold,
+	}
+	if *hearingLoss != "" {
+		if preprocessing.HearingLoss, err = audio.ParseAudiogram(*hearingLoss); err != nil {
+			log.Fatal(err)
+		}
+	}
+	load := func(path string, signal string) (*audio.Audio, error) {
+		var result *audio.Audio
+		var err error
+		if *rawFormat != "" && strings.Contains(*rawSignals, signal) {
+			result, err = aio.LoadRawAtRate(path, aio.RawFormat{SampleFormat: *rawFormat, Rate: *rawRate, Channels: *rawChannels}, int(zimtohrliParameters.SampleRate))
+		} else {
+			result, err = aio.LoadAtRate(path, int(zimtohrliParameters.SampleRate))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if result, err = goohrli.ChannelPolicy(*channelPolicy).Apply(result); err != nil {
+			return nil, fmt.Errorf("%q: %v", path, err)
+		}
+		result = preprocessing.Apply(result)
+		if len(result.Samples) == 0 || len(result.Samples[0]) == 0 {
+			return nil, fmt.Errorf("%q contains no audio", path)
+		}
+		return result, nil
+	}
+
+	signalA, err := load(*pathA, "a")
 	if err != nil {
 		log.Panic(err)
 	}
+	if *voiceActivity {
+		activity := audio.DefaultVoiceActivity
+		activity.ThresholdDB = *voiceActivityThreshold
+		if segments = activity.Segments(signalA); len(segments) == 0 {
+			logging.Warningf("%q has no voice activity, comparing the whole signals", *pathA)
+		}
+	}
+	signalsB := make([]*audio.Audio, len(pathB))
+	for index, path := range pathB {
+		signalB, err := load(path, "b")
+		if err != nil {
+			log.Panic(err)
+		}
+		if signalA.Rate != signalB.Rate {
+			log.Panic(fmt.Errorf("sample rate of %q is %v, and sample rate of %q is %v", *pathA, signalA.Rate, path, signalB.Rate))
+		}
+		if len(signalA.Samples) != len(signalB.Samples) {
+			log.Panic(fmt.Errorf("%q has %v channels, and %q has %v channels", *pathA, len(signalA.Samples), path, len(signalB.Samples)))
+		}
+		signalsB[index] = signalB
+	}
+	for _, encoding := range encodeB {
+		signalB, err := aio.RoundTrip(signalA, strings.Fields(encoding))
+		if err != nil {
+			log.Fatalf("encoding %q with %q: %v", *pathA, encoding, err)
+		}
+		pathB = append(pathB, fmt.Sprintf("%s encoded with %s", *pathA, encoding))
+		signalsB = append(signalsB, preprocessing.Apply(signalB))
+	}
+
+	if *checkLevels {
+		numWarnings := 0
+		for index, signal := range append([]*audio.Audio{signalA}, signalsB...) {
+			path := *pathA
+			if index > 0 {
+				path = pathB[index-1]
+			}
+			for _, warning := range audio.DefaultLevelCheck.Check(signal) {
+				logging.Warningf("%s: %v", path, warning)
+				numWarnings++
+			}
+		}
+		if *failOnWarnings && numWarnings > 0 {
+			log.Fatalf("%v level warnings", numWarnings)
+		}
+	}
+
+	// referencesA and distortionsB contain the signal pairs adjusted according to -length_policy. This is the only
+	// place the policy is applied, all metrics compare these pairs as they are.
+	referencesA := make([]*audio.Audio, len(signalsB))
+	distortionsB := make([]*audio.Audio, len(signalsB))
+	for index, signalB := range signalsB {
+		if referencesA[index], distortionsB[index], err = goohrli.LengthPolicy(*lengthPolicy).Apply(signalA, signalB); err != nil {
+			log.Panic(fmt.Errorf("comparing %q and %q: %v", *pathA, pathB[index], err))
+		}
+	}
 
-	if signalA.Rate != signalB.Rate {
-		log.Panic(fmt.Errorf("sample rate of %q is %v, and sample rate of %q is %v", *pathA, signalA.Rate, *pathB, signalB.Rate))
+	// prefix identifies signal B in the output when there is more than one.
+	prefix := func(index int) string {
+		if len(pathB) == 1 {
+			return ""
+		}
+		return fmt.Sprintf("%s: ", pathB[index])
 	}
 
-	if len(signalA.Samples) != len(signalB.Samples) {
-		log.Panic(fmt.Errorf("%q has %v channels, and %q has %v channels", *pathA, len(signalA.Samples), *pathB, len(signalB.Samples)))
+	// The reliability is computed before any amplitude normalization.
+	results := make([]comparison, len(pathB))
+	for index := range results {
+		results[index] = comparison{
+			PathB:       pathB[index],
+			Metrics:     map[string]float64{},
+			Reliability: goohrli.ComparisonReliability(referencesA[index], distortionsB[index]),
+		}
+	}
+	output := func(index int, metric string, value float64) {
+		value = roundMetric(value, *precision)
+		results[index].Metrics[metric] = value
+		if !*outputJSON {
+			fmt.Printf("%s%s=%v\n", prefix(index), metric, value)
+		}
+	}
+	if *outputDelay {
+		for index, signalB := range signalsB {
+			delay := roundMetric(goohrli.EstimateDelay(signalA, signalB), *precision)
+			results[index].Delay = &delay
+			if !*outputJSON {
+				fmt.Printf("%sDelay=%v\n", prefix(index), delay)
+			}
+			if delay != 0 && goohrli.LengthPolicy(*lengthPolicy) != goohrli.LengthAlign {
+				logging.Warningf("%ssignal B is delayed %v seconds, use -length_policy %v to compensate", prefix(index), delay, goohrli.LengthAlign)
+			}
+		}
+	}
+	if *outputConfidence && !*outputJSON {
+		for index, result := range results {
+			output(index, "Confidence", result.Reliability.Confidence)
+			for _, reason := range result.Reliability.Reasons {
+				logging.Warningf("%slow confidence: %s", prefix(index), reason)
+			}
+		}
 	}
 
 	if *pipeMetric != "" {
@@ -76,60 +441,186 @@ func main() {
 		if err != nil {
 			log.Panic(err)
 		}
-		score, err := metric.Measure(signalA, signalB)
-		if err != nil {
-			log.Panic(err)
+		for index, signalB := range distortionsB {
+			start := time.Now()
+			score, err := measure(referencesA[index], signalB, fmt.Sprintf("%s %s", *pipeMetric, scoreType), metric.Measure)
+			if err != nil {
+				log.Panic(err)
+			}
+			if *verbose {
+				log.Printf("%s%s took %v", prefix(index), scoreType, time.Since(start))
+			}
+			output(index, string(scoreType), score)
 		}
-		fmt.Printf("%v=%v\n", scoreType, score)
 	}
 
 	if *visqol {
 		v := goohrli.NewViSQOL()
-		if *perChannel {
-			for channelIndex := range signalA.Samples {
-				mos, err := v.MOS(signalA.Rate, signalA.Samples[channelIndex], signalB.Samples[channelIndex])
+		for index, signalB := range distortionsB {
+			signalA := referencesA[index]
+			if *perChannel {
+				for channelIndex := range signalA.Samples {
+					mos, err := v.MOS(signalA.Rate, signalA.Samples[channelIndex], signalB.Samples[channelIndex])
+					if err != nil {
+						log.Panic(err)
+					}
+					output(index, fmt.Sprintf("ViSQOL#%v", channelIndex), mos)
+				}
+			} else {
+				start := time.Now()
+				mos, err := measure(signalA, signalB, "ViSQOL "+goohrli.Version().String(), v.AudioMOS)
 				if err != nil {
 					log.Panic(err)
 				}
-				fmt.Printf("ViSQOL#%v=%v\n", channelIndex, mos)
-			}
-		} else {
-			mos, err := v.AudioMOS(signalA, signalB)
-			if err != nil {
-				log.Panic(err)
+				if *verbose {
+					log.Printf("%sViSQOL took %v", prefix(index), time.Since(start))
+				}
+				output(index, "ViSQOL", mos)
 			}
-			fmt.Printf("ViSQOL=%v\n", mos)
 		}
 	}
 
+	exceedingMaxDistance := 0
 	if *zimtohrli {
 		getMetric := func(f float64) float64 {
 			if *outputZimtohrliDistance {
 				return f
 			}
-			return goohrli.MOSFromZimtohrli(f)
+			return mosMapping.MOS(f)
 		}
 
-		if err := zimtohrliParameters.Update([]byte(*zimtohrliParametersJSON)); err != nil {
-			log.Panic(err)
-		}
 		if !reflect.DeepEqual(zimtohrliParameters, goohrli.DefaultParameters(zimtohrliParameters.SampleRate)) {
-			log.Printf("Using %+v", zimtohrliParameters)
+			logging.Infof("Using %+v", zimtohrliParameters)
 		}
 		zimtohrliParameters.SampleRate = signalA.Rate
 		g := goohrli.New(zimtohrliParameters)
+		g.Symmetry = goohrli.Symmetry(*symmetry)
+		// timing is the time spent comparing the current signal B, when -verbose compares them one at a time.
+		timing := goohrli.Timing{}
+		if *verbose {
+			g.OnTiming = timing.Add
+		}
 		if *perChannel {
-			for channelIndex := range signalA.Samples {
-				measurement := goohrli.Measure(signalA.Samples[channelIndex])
-				goohrli.NormalizeAmplitude(measurement.MaxAbsAmplitude, signalB.Samples[channelIndex])
-				fmt.Printf("Zimtohrli#%v=%v\n", channelIndex, getMetric(g.Distance(signalA.Samples[channelIndex], signalB.Samples[channelIndex])))
+			for index, signalB := range distortionsB {
+				signalA := referencesA[index]
+				for channelIndex := range signalA.Samples {
+					measurement := goohrli.Measure(signalA.Samples[channelIndex])
+					goohrli.NormalizeAmplitude(measurement.MaxAbsAmplitude, signalB.Samples[channelIndex])
+					distance := g.Distance(signalA.Samples[channelIndex], signalB.Samples[channelIndex])
+					if g.Symmetry.Symmetric() {
+						distance = g.Symmetry.Combine(distance, g.Distance(signalB.Samples[channelIndex], signalA.Samples[channelIndex]))
+					}
+					output(index, fmt.Sprintf("Zimtohrli#%v", channelIndex), getMetric(distance))
+				}
+			}
+		} else if *permutationInvariant {
+			for index, signalB := range distortionsB {
+				timing = goohrli.Timing{}
+				assignment, err := g.AssignSources(referencesA[index], signalB)
+				if err != nil {
+					log.Fatalf("comparing %q and %q: %v", *pathA, pathB[index], err)
+				}
+				if *verbose {
+					log.Printf("%sZimtohrli spent %v", prefix(index), timing)
+				}
+				results[index].Permutation = assignment.Permutation
+				if !*outputJSON {
+					fmt.Printf("%sPermutation=%v\n", prefix(index), assignment.Permutation)
+				}
+				for channelIndex, distance := range assignment.Distances {
+					output(index, fmt.Sprintf("Zimtohrli#%v", channelIndex), getMetric(distance))
+				}
+				output(index, "Zimtohrli", getMetric(assignment.MeanDistance()))
 			}
 		} else {
-			dist, err := g.NormalizedAudioDistance(signalA, signalB)
+			key, err := json.Marshal(struct {
+				Metric       string
+				Parameters   goohrli.Parameters
+				Version      string
+				LengthPolicy goohrli.LengthPolicy
+				Symmetry     goohrli.Symmetry
+			}{"Zimtohrli", zimtohrliParameters, goohrli.Version().String(), goohrli.LengthPolicy(*lengthPolicy), g.Symmetry})
 			if err != nil {
 				log.Panic(err)
 			}
-			fmt.Printf("Zimtohrli=%v\n", getMetric(dist))
+			compareOne := func(signalA, signalB *audio.Audio) (float64, error) {
+				dists, err := g.CompareMany(signalA, []*audio.Audio{signalB})
+				if err != nil {
+					return 0, err
+				}
+				return dists[0], nil
+			}
+			var dists []float64
+			// Comparing all signals B at once reuses the analysis of signal A, but segments, cached results,
+			// -max_distance, -verbose, and signals A adjusted differently for each signal B by the length policy
+			// need separate comparisons.
+			if *maxDistance > 0 {
+				dists = make([]float64, len(distortionsB))
+				metric := compareOne
+				if cache != nil {
+					metric = cache.Measurement(string(key), compareOne)
+				}
+				for index, signalB := range distortionsB {
+					signalA := referencesA[index]
+					windows := segments
+					if len(windows) == 0 {
+						windows = audio.Windows(float64(len(signalA.Samples[0]))/signalA.Rate, maxDistanceWindow.Seconds())
+					}
+					timing = goohrli.Timing{}
+					if dists[index], results[index].ExceedsMaxDistance, err = windows.MeasureUntil(signalA, signalB, *maxDistance, metric); err != nil {
+						log.Panic(err)
+					}
+					if *verbose {
+						log.Printf("%sZimtohrli spent %v", prefix(index), timing)
+					}
+				}
+			} else if len(segments) > 0 || cache != nil || *verbose || slices.ContainsFunc(referencesA, func(reference *audio.Audio) bool { return reference != signalA }) {
+				dists = make([]float64, len(distortionsB))
+				for index, signalB := range distortionsB {
+					timing = goohrli.Timing{}
+					if dists[index], err = measure(referencesA[index], signalB, string(key), compareOne); err != nil {
+						log.Panic(err)
+					}
+					if *verbose {
+						log.Printf("%sZimtohrli spent %v", prefix(index), timing)
+					}
+				}
+			} else if dists, err = g.CompareMany(signalA, distortionsB); err != nil {
+				log.Panic(err)
+			}
+			ranking := make([]int, len(dists))
+			for index := range ranking {
+				ranking[index] = index
+			}
+			sort.SliceStable(ranking, func(i, j int) bool {
+				return dists[ranking[i]] < dists[ranking[j]]
+			})
+			for _, index := range ranking {
+				if results[index].ExceedsMaxDistance {
+					exceedingMaxDistance++
+					if !*outputJSON {
+						fmt.Printf("%sZimtohrli distance already exceeds threshold %v\n", prefix(index), *maxDistance)
+					}
+				} else {
+					output(index, "Zimtohrli", getMetric(dists[index]))
+				}
+			}
 		}
 	}
+
+	if *outputJSON {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(string(b))
+	}
+	if *golden != "" {
+		if err := checkGolden(*golden, *updateGolden, *goldenTolerance, results); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if exceedingMaxDistance > 0 {
+		log.Fatalf("the Zimtohrli distance of %v signals exceeds -max_distance %v", exceedingMaxDistance, *maxDistance)
+	}
 }
@@ -0,0 +1,139 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// roundMetric returns the value rounded to precision significant digits, or the value itself if precision is
+// negative.
+//
+// Rounded values are formatted identically on all platforms, even if the computed values differ in their last bits.
+func roundMetric(value float64, precision int) float64 {
+	if precision < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'g', max(precision, 1), 64), 64)
+	if err != nil {
+		log.Panic(err)
+	}
+	return rounded
+}
+
+// withinTolerance returns whether got differs from want by at most tolerance, relative to the magnitude of want
+// if it's above 1.
+func withinTolerance(want, got, tolerance float64) bool {
+	if math.IsNaN(want) || math.IsNaN(got) {
+		return math.IsNaN(want) && math.IsNaN(got)
+	}
+	if want == got {
+		return true
+	}
+	return math.Abs(got-want) <= tolerance*math.Max(1, math.Abs(want))
+}
+
+// goldenMismatches returns descriptions of the differences between the metrics and delays of the golden and the
+// compared comparisons, ignoring differences within tolerance.
+func goldenMismatches(golden, compared []comparison, tolerance float64) []string {
+	result := []string{}
+	goldenByPath := map[string]comparison{}
+	for _, want := range golden {
+		goldenByPath[want.PathB] = want
+	}
+	comparedPaths := map[string]bool{}
+	for _, got := range compared {
+		comparedPaths[got.PathB] = true
+		want, found := goldenByPath[got.PathB]
+		if !found {
+			result = append(result, fmt.Sprintf("%s: not in the golden file", got.PathB))
+			continue
+		}
+		metrics := []string{}
+		for metric := range want.Metrics {
+			metrics = append(metrics, metric)
+		}
+		for metric := range got.Metrics {
+			if _, found := want.Metrics[metric]; !found {
+				metrics = append(metrics, metric)
+			}
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics {
+			wantValue, wantFound := want.Metrics[metric]
+			gotValue, gotFound := got.Metrics[metric]
+			switch {
+			case !gotFound:
+				result = append(result, fmt.Sprintf("%s: %s missing, want %v", got.PathB, metric, wantValue))
+			case !wantFound:
+				result = append(result, fmt.Sprintf("%s: %s=%v not in the golden file", got.PathB, metric, gotValue))
+			case !withinTolerance(wantValue, gotValue, tolerance):
+				result = append(result, fmt.Sprintf("%s: %s=%v, want %v", got.PathB, metric, gotValue, wantValue))
+			}
+		}
+		if want.Delay != nil && (got.Delay == nil || !withinTolerance(*want.Delay, *got.Delay, tolerance)) {
+			result = append(result, fmt.Sprintf("%s: Delay=%v, want %v", got.PathB, got.Delay, *want.Delay))
+		}
+	}
+	for _, want := range golden {
+		if !comparedPaths[want.PathB] {
+			result = append(result, fmt.Sprintf("%s: in the golden file but not compared", want.PathB))
+		}
+	}
+	return result
+}
+
+// checkGolden compares the comparisons to those stored in the golden file at path, and returns an error listing
+// the differences larger than tolerance.
+//
+// If update is set, the comparisons are stored in the golden file instead. A missing golden file is an error
+// unless update is set, so that a mistyped path doesn't silently pass.
+func checkGolden(path string, update bool, tolerance float64, compared []comparison) error {
+	if update {
+		b, err := json.MarshalIndent(compared, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+		logging.Infof("Wrote golden file %q", path)
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("golden file %q doesn't exist, use -update_golden to create it", path)
+	}
+	if err != nil {
+		return err
+	}
+	golden := []comparison{}
+	if err := json.Unmarshal(b, &golden); err != nil {
+		return fmt.Errorf("trying to parse golden file %q: %v", path, err)
+	}
+	if mismatches := goldenMismatches(golden, compared, tolerance); len(mismatches) > 0 {
+		return fmt.Errorf("%v differences from golden file %q:\n%s", len(mismatches), path, strings.Join(mismatches, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRoundMetric(t *testing.T) {
+	tenth := 0.1
+	for _, tc := range []struct {
+		value     float64
+		precision int
+		want      string
+	}{
+		{tenth + 0.2, -1, "0.30000000000000004"},
+		{tenth + 0.2, 6, "0.3"},
+		{4.123456789, 3, "4.12"},
+		{0.000123456, 2, "0.00012"},
+		{123456, 2, "120000"},
+		{4.6, 0, "5"},
+		{math.NaN(), 3, "NaN"},
+	} {
+		if got := fmt.Sprint(roundMetric(tc.value, tc.precision)); got != tc.want {
+			t.Errorf("roundMetric(%v, %v) = %v, want %v", tc.value, tc.precision, got, tc.want)
+		}
+	}
+}
+
+func TestGoldenMismatches(t *testing.T) {
+	delay := 0.01
+	golden := []comparison{
+		{PathB: "a.wav", Metrics: map[string]float64{"Zimtohrli": 4.5, "ViSQOL": 0.5}, Delay: &delay},
+		{PathB: "b.wav", Metrics: map[string]float64{"Zimtohrli": 3}},
+	}
+	for _, tc := range []struct {
+		name     string
+		compared []comparison
+		want     []string
+	}{
+		{
+			name: "within tolerance",
+			compared: []comparison{
+				{PathB: "b.wav", Metrics: map[string]float64{"Zimtohrli": 3.0002}},
+				{PathB: "a.wav", Metrics: map[string]float64{"Zimtohrli": 4.5004, "ViSQOL": 0.5001}, Delay: &delay},
+			},
+			want: []string{},
+		},
+		{
+			name: "outside tolerance",
+			compared: []comparison{
+				{PathB: "a.wav", Metrics: map[string]float64{"Zimtohrli": 4.5, "ViSQOL": 0.5002}, Delay: &delay},
+				{PathB: "b.wav", Metrics: map[string]float64{"Zimtohrli": 3.01}},
+			},
+			want: []string{"a.wav: ViSQOL=0.5002, want 0.5", "b.wav: Zimtohrli=3.01, want 3"},
+		},
+		{
+			name: "different signals and metrics",
+			compared: []comparison{
+				{PathB: "a.wav", Metrics: map[string]float64{"Zimtohrli": 4.5, "PESQ": 2}},
+				{PathB: "c.wav", Metrics: map[string]float64{"Zimtohrli": 3}},
+			},
+			want: []string{
+				"a.wav: PESQ=2 not in the golden file",
+				"a.wav: ViSQOL missing, want 0.5",
+				"a.wav: Delay=<nil>, want 0.01",
+				"c.wav: not in the golden file",
+				"b.wav: in the golden file but not compared",
+			},
+		},
+	} {
+		if got := goldenMismatches(golden, tc.compared, 1e-4); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: goldenMismatches = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden.json")
+	compared := []comparison{{PathB: "a.wav", Metrics: map[string]float64{"Zimtohrli": 4.5}}}
+	if err := checkGolden(path, false, 1e-4, compared); err == nil {
+		t.Errorf("checkGolden without a golden file returned no error")
+	}
+	if err := checkGolden(path, true, 1e-4, compared); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGolden(path, false, 1e-4, compared); err != nil {
+		t.Errorf("checkGolden after creating the golden file: %v", err)
+	}
+	changed := []comparison{{PathB: "a.wav", Metrics: map[string]float64{"Zimtohrli": 4}}}
+	if err := checkGolden(path, false, 1e-4, changed); err == nil {
+		t.Errorf("checkGolden with a changed metric returned no error")
+	}
+	if err := checkGolden(path, true, 1e-4, changed); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGolden(path, false, 1e-4, changed); err != nil {
+		t.Errorf("checkGolden after updating the golden file: %v", err)
+	}
+}
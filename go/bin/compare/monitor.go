@@ -0,0 +1,108 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/goohrli"
+)
+
+// monitor continuously compares two streams, and outputs the metric of the last window every interval.
+type monitor struct {
+	g        *goohrli.Goohrli
+	interval time.Duration
+	window   time.Duration
+	// metric maps the Zimtohrli distance to the output value.
+	metric     func(float64) float64
+	outputJSON bool
+}
+
+// monitorEvent is the JSON output for an interval.
+type monitorEvent struct {
+	// Time is the end of the compared window, from the start of the streams.
+	Time      goohrli.Duration
+	Zimtohrli float64
+}
+
+// run compares the streams until one of them ends, and writes the results to w.
+func (m *monitor) run(streamA, streamB *aio.Stream, w io.Writer) error {
+	intervalFrames := int(m.interval.Seconds() * float64(streamA.Rate))
+	windowFrames := int(m.window.Seconds() * float64(streamA.Rate))
+	if intervalFrames < 1 || windowFrames < intervalFrames {
+		return fmt.Errorf("interval %v must be positive and not longer than window %v", m.interval, m.window)
+	}
+	windowA := &audio.Audio{Samples: make([][]float32, streamA.Channels), Rate: float64(streamA.Rate)}
+	windowB := &audio.Audio{Samples: make([][]float32, streamB.Channels), Rate: float64(streamB.Rate)}
+	appendFrames := func(window *audio.Audio, frames [][]float32) {
+		for channelIndex, channel := range frames {
+			window.Samples[channelIndex] = append(window.Samples[channelIndex], channel...)
+			if excess := len(window.Samples[channelIndex]) - windowFrames; excess > 0 {
+				window.Samples[channelIndex] = window.Samples[channelIndex][excess:]
+			}
+		}
+	}
+	framesRead := 0
+	for {
+		// Both streams are read concurrently, since reading a live input blocks until it has produced the frames.
+		var framesB [][]float32
+		errB := make(chan error, 1)
+		go func() {
+			var err error
+			framesB, err = streamB.Read(intervalFrames)
+			errB <- err
+		}()
+		framesA, errA := streamA.Read(intervalFrames)
+		if err := errors.Join(errA, <-errB); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+		framesRead += intervalFrames
+		appendFrames(windowA, framesA)
+		appendFrames(windowB, framesB)
+		// NormalizedAudioDistance normalizes the amplitude of the distortion in place, so it gets a copy.
+		distortion := &audio.Audio{Samples: make([][]float32, len(windowB.Samples)), Rate: windowB.Rate}
+		for channelIndex, channel := range windowB.Samples {
+			distortion.Samples[channelIndex] = append([]float32{}, channel...)
+		}
+		distance, err := m.g.NormalizedAudioDistance(windowA, distortion)
+		if err != nil {
+			return err
+		}
+		event := monitorEvent{
+			Time:      goohrli.Duration{Duration: time.Duration(float64(framesRead) / float64(streamA.Rate) * float64(time.Second))},
+			Zimtohrli: m.metric(distance),
+		}
+		if m.outputJSON {
+			b, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w, string(b)); err != nil {
+				return err
+			}
+		} else if _, err := fmt.Fprintf(w, "%v Zimtohrli=%v\n", event.Time.Duration, event.Zimtohrli); err != nil {
+			return err
+		}
+	}
+}
@@ -37,6 +37,9 @@ func populate(dest string, workers int) error {
 		return err
 	}
 	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
 
 	rootURL, err := url.Parse("https://listening-test.coresv.net/results.htm")
 	if err != nil {
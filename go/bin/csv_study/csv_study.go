@@ -0,0 +1,316 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// csv_study creates a study from a CSV file and a directory of audio files, using a mapping
+// that defines which columns contain what.
+//
+// An example mapping for a CSV file with one line per listener rating:
+//
+//	{
+//	  "Reference": "reference_file",
+//	  "Distortion": "processed_file",
+//	  "Scores": {"MOS": "rating"},
+//	  "Metadata": ["codec", "bitrate"]
+//	}
+//
+// An example mapping for a CSV file in long format, with the score type in a column:
+//
+//	{
+//	  "Reference": "ref",
+//	  "Distortion": "deg",
+//	  "ScoreType": "metric",
+//	  "Score": "value"
+//	}
+//
+// Multiple scores of the same type for the same reference and distortion are averaged.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/data"
+	"github.com/google/zimtohrli/go/progress"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// mapping defines which CSV columns contain what.
+type mapping struct {
+	// Reference is the column with the path to the reference audio.
+	Reference string
+	// Distortion is the column with the path to the distortion audio.
+	Distortion string
+	// Scores maps score types to the columns containing scores of that type.
+	Scores map[data.ScoreType]string
+	// ScoreType, if set, is the column with the score type of the score in the Score column.
+	ScoreType string
+	// Score is the column with the score when ScoreType is set.
+	Score string
+	// Metadata are columns whose values are included in the distortion names, and stored as generation parameters of the distortions.
+	Metadata []string
+	// AlternativeReferences are columns with paths to other acceptable references of the distortion, e.g. other
+	// masterings of the same recording. Empty values are ignored.
+	AlternativeReferences []string
+	// Delimiter is the field delimiter of the CSV file, a comma if empty.
+	Delimiter string
+}
+
+// columnIndices returns the index of each named column in the header.
+func columnIndices(header []string, names ...string) (map[string]int, error) {
+	indices := map[string]int{}
+	for index, name := range header {
+		indices[strings.TrimSpace(name)] = index
+	}
+	result := map[string]int{}
+	for _, name := range names {
+		index, found := indices[name]
+		if !found {
+			return nil, fmt.Errorf("header %+v has no %q column", header, name)
+		}
+		result[name] = index
+	}
+	return result, nil
+}
+
+// distortionKey identifies a distortion.
+type distortionKey struct {
+	reference  string
+	distortion string
+	// name includes the metadata, to keep distortions of the same file with different metadata apart.
+	name string
+}
+
+// scoreSum is the sum and count of the scores of a type.
+type scoreSum struct {
+	sum   float64
+	count int
+}
+
+func populate(csvPath, source, dest string, m mapping, workers int, failFast bool) error {
+	if m.Reference == "" || m.Distortion == "" {
+		return fmt.Errorf("mapping %+v doesn't define Reference and Distortion columns", m)
+	}
+	if (m.ScoreType == "") != (m.Score == "") {
+		return fmt.Errorf("mapping %+v must define both or neither of ScoreType and Score columns", m)
+	}
+	if len(m.Scores) == 0 && m.ScoreType == "" {
+		return fmt.Errorf("mapping %+v doesn't define any score columns", m)
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	csvReader := csv.NewReader(f)
+	if m.Delimiter != "" {
+		csvReader.Comma = []rune(m.Delimiter)[0]
+	}
+	header, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+	names := append(append([]string{m.Reference, m.Distortion}, m.Metadata...), m.AlternativeReferences...)
+	for _, column := range m.Scores {
+		names = append(names, column)
+	}
+	if m.ScoreType != "" {
+		names = append(names, m.ScoreType, m.Score)
+	}
+	cols, err := columnIndices(header, names...)
+	if err != nil {
+		return fmt.Errorf("%q: %v", csvPath, err)
+	}
+
+	scores := map[distortionKey]map[data.ScoreType]*scoreSum{}
+	generations := map[distortionKey]*data.Generation{}
+	alternativeReferences := map[distortionKey][]string{}
+	addScore := func(k distortionKey, scoreType data.ScoreType, value string) error {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil
+		}
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %v score %q: %v", scoreType, value, err)
+		}
+		if scores[k] == nil {
+			scores[k] = map[data.ScoreType]*scoreSum{}
+		}
+		if scores[k][scoreType] == nil {
+			scores[k][scoreType] = &scoreSum{}
+		}
+		scores[k][scoreType].sum += score
+		scores[k][scoreType].count++
+		return nil
+	}
+	for {
+		line, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		k := distortionKey{
+			reference:  strings.TrimSpace(line[cols[m.Reference]]),
+			distortion: strings.TrimSpace(line[cols[m.Distortion]]),
+		}
+		k.name = k.distortion
+		if len(m.Metadata) > 0 {
+			generation := &data.Generation{Parameters: map[string]string{}}
+			for _, column := range m.Metadata {
+				generation.Parameters[column] = strings.TrimSpace(line[cols[column]])
+			}
+			k.name = fmt.Sprintf("%s [%s]", k.distortion, generation.Condition())
+			generations[k] = generation
+		}
+		if _, found := alternativeReferences[k]; !found {
+			alternativeReferences[k] = []string{}
+			for _, column := range m.AlternativeReferences {
+				if path := strings.TrimSpace(line[cols[column]]); path != "" {
+					alternativeReferences[k] = append(alternativeReferences[k], path)
+				}
+			}
+		}
+		for scoreType, column := range m.Scores {
+			if err := addScore(k, scoreType, line[cols[column]]); err != nil {
+				return fmt.Errorf("line %+v: %v", line, err)
+			}
+		}
+		if m.ScoreType != "" {
+			if err := addScore(k, data.ScoreType(strings.TrimSpace(line[cols[m.ScoreType]])), line[cols[m.Score]]); err != nil {
+				return fmt.Errorf("line %+v: %v", line, err)
+			}
+		}
+	}
+
+	distortions := map[string][]distortionKey{}
+	for k := range scores {
+		distortions[k.reference] = append(distortions[k.reference], k)
+	}
+	references := []string{}
+	for reference := range distortions {
+		references = append(references, reference)
+		sort.Slice(distortions[reference], func(i, j int) bool {
+			return distortions[reference][i].name < distortions[reference][j].name
+		})
+	}
+	sort.Strings(references)
+
+	study, err := data.OpenStudy(dest)
+	if err != nil {
+		return err
+	}
+	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
+
+	bar := progress.New("Transcoding")
+	pool := worker.Pool[*data.Reference]{
+		Workers:  workers,
+		OnChange: bar.Update,
+		FailFast: failFast,
+	}
+	for _, loopReference := range references {
+		reference := loopReference
+		pool.Submit(func(f func(*data.Reference)) error {
+			ref := &data.Reference{
+				Name: reference,
+			}
+			var err error
+			path := filepath.Join(source, reference)
+			if ref.Path, err = aio.Recode(path, dest); err != nil {
+				return fmt.Errorf("unable to fetch %q: %v", path, err)
+			}
+			for _, k := range distortions[reference] {
+				dist := &data.Distortion{
+					Name:       k.name,
+					Scores:     map[data.ScoreType]float64{},
+					Generation: generations[k],
+				}
+				for scoreType, sum := range scores[k] {
+					dist.Scores[scoreType] = sum.sum / float64(sum.count)
+				}
+				path := filepath.Join(source, k.distortion)
+				if dist.Path, err = aio.Recode(path, dest); err != nil {
+					return fmt.Errorf("unable to fetch %q: %v", path, err)
+				}
+				for _, alternativeReference := range alternativeReferences[k] {
+					path := filepath.Join(source, alternativeReference)
+					recoded, err := aio.Recode(path, dest)
+					if err != nil {
+						return fmt.Errorf("unable to fetch %q: %v", path, err)
+					}
+					dist.AlternativeReferences = append(dist.AlternativeReferences, recoded)
+				}
+				ref.Distortions = append(ref.Distortions, dist)
+			}
+			f(ref)
+			return nil
+		})
+	}
+	if err := pool.Error(); err != nil {
+		log.Println(err.Error())
+	}
+	bar.Finish()
+	refs := []*data.Reference{}
+	for ref := range pool.Results() {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return study.Put(refs)
+}
+
+func main() {
+	csvPath := flag.String("csv", "", "CSV file with one score, or one line of scores, per line.")
+	mappingPath := flag.String("mapping", "", "JSON file defining which columns of the CSV file contain what, see the package documentation for the format.")
+	source := flag.String("source", "", "Directory the audio paths in the CSV file are relative to. Defaults to the directory of the CSV file.")
+	destination := flag.String("dest", "", "Destination directory.")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of workers transcoding sounds.")
+	failFast := flag.Bool("fail_fast", false, "Whether to exit immediately at the first error.")
+	flag.Parse()
+	if *csvPath == "" || *mappingPath == "" || *destination == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *source == "" {
+		*source = filepath.Dir(*csvPath)
+	}
+
+	b, err := os.ReadFile(*mappingPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m := mapping{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		log.Fatalf("invalid mapping %q: %v", *mappingPath, err)
+	}
+	if err := populate(*csvPath, *source, *destination, m, *workers, *failFast); err != nil {
+		log.Fatal(err)
+	}
+}
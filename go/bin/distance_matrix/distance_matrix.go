@@ -0,0 +1,114 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// distance_matrix outputs the Zimtohrli distances between all pairs of a set of audio files, e.g. to cluster
+// recordings or pick representative references for listening tests.
+//
+// Usage:
+//
+//	distance_matrix [flags] file1.wav file2.wav ...
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/goohrli"
+)
+
+// matrix is the JSON output.
+type matrix struct {
+	Paths []string
+	// Distances[i][j] is the distance from Paths[i], as reference, to Paths[j].
+	Distances [][]float64
+}
+
+func main() {
+	format := flag.String("format", "csv", "Output format, csv or json.")
+	zimtohrliParametersJSON := flag.String("zimtohrli_parameters", "", "Zimtohrli model parameters.")
+	analysisCache := flag.String("analysis_cache", "", "Directory to store Zimtohrli analyses in, to avoid recomputing them for the same audio and parameters.")
+	symmetry := flag.String("symmetry", string(goohrli.SymmetryForward), fmt.Sprintf("In which directions distances are computed, one of %v. With max or mean the matrix is symmetric.", goohrli.Symmetries))
+	lengthPolicy := flag.String("length_policy", string(goohrli.LengthWarp), fmt.Sprintf("How to compare signals of different lengths, one of %v.", goohrli.LengthPolicies))
+	sampleRate := flag.Int("sample_rate", 48000, "Sample rate the audio files are resampled to before comparing them.")
+	ffmpeg := flag.String("ffmpeg", aio.FFmpeg, "Path to the ffmpeg binary used to decode and encode audio. Defaults to $ZIMTOHRLI_FFMPEG, or ffmpeg in $PATH.")
+	ffmpegArgs := flag.String("ffmpeg_args", strings.Join(aio.FFmpegArgs, " "), "Extra whitespace separated arguments to ffmpeg. Defaults to $ZIMTOHRLI_FFMPEG_ARGS.")
+	flag.Parse()
+	aio.FFmpeg = *ffmpeg
+	aio.FFmpegArgs = strings.Fields(*ffmpegArgs)
+	paths := flag.Args()
+	if len(paths) < 2 || (*format != "csv" && *format != "json") {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	params := goohrli.DefaultParameters(float64(*sampleRate))
+	if *zimtohrliParametersJSON != "" {
+		if err := params.Update([]byte(*zimtohrliParametersJSON)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	params.SampleRate = float64(*sampleRate)
+	g := goohrli.New(params)
+	g.LengthPolicy = goohrli.LengthPolicy(*lengthPolicy)
+	g.Symmetry = goohrli.Symmetry(*symmetry)
+	if *analysisCache != "" {
+		g.AnalysisCache = &goohrli.AnalysisCache{Dir: *analysisCache}
+	}
+
+	signals := make([]*audio.Audio, len(paths))
+	for index, path := range paths {
+		var err error
+		if signals[index], err = aio.LoadAtRate(path, *sampleRate); err != nil {
+			log.Fatalf("unable to load %q: %v", path, err)
+		}
+	}
+	distances, err := g.DistanceMatrix(signals)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *format == "json" {
+		b, err := json.MarshalIndent(matrix{Paths: paths, Distances: distances}, "", "  ")
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(append([]string{"path"}, paths...)); err != nil {
+		log.Fatal(err)
+	}
+	for index, row := range distances {
+		fields := []string{paths[index]}
+		for _, distance := range row {
+			fields = append(fields, strconv.FormatFloat(distance, 'g', -1, 64))
+		}
+		if err := w.Write(fields); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
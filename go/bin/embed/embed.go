@@ -0,0 +1,148 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// embed exports Zimtohrli embeddings, the time pooled perceptual spectrograms, of audio files as JSON lines,
+// for downstream ML models.
+//
+// Usage:
+//
+//	embed [flags] file1.wav file2.wav ...
+//	embed [flags] -studies 'studies/*'
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/data"
+	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/progress"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// embedding is the JSON output for one file.
+type embedding struct {
+	Path string
+	// Name is the name of the reference or distortion in a study, if the file was found in a study.
+	Name      string `json:",omitempty"`
+	Embedding []float32
+}
+
+// studyFiles returns the references and distortions in the studies in the directories matching the glob.
+func studyFiles(glob string) ([]embedding, error) {
+	bundles, err := data.OpenBundles(glob)
+	if err != nil {
+		return nil, err
+	}
+	result := []embedding{}
+	for _, bundle := range bundles {
+		for _, ref := range bundle.References {
+			result = append(result, embedding{Path: data.Resolve(bundle.Dir, ref.Path), Name: ref.Name})
+			for _, dist := range ref.Distortions {
+				result = append(result, embedding{Path: data.Resolve(bundle.Dir, dist.Path), Name: dist.Name})
+			}
+		}
+	}
+	return result, nil
+}
+
+func main() {
+	studies := flag.String("studies", "", "Glob to directories with databases whose references and distortions to export embeddings for, instead of the files in the arguments.")
+	outputFile := flag.String("output", "", "File to write the JSON lines to. Defaults to stdout.")
+	zimtohrliParametersJSON := flag.String("zimtohrli_parameters", "", "Zimtohrli model parameters.")
+	analysisCache := flag.String("analysis_cache", "", "Directory to store Zimtohrli analyses in, to avoid recomputing them for the same audio and parameters.")
+	sampleRate := flag.Int("sample_rate", 48000, "Sample rate the audio files are resampled to before analyzing them.")
+	ffmpeg := flag.String("ffmpeg", aio.FFmpeg, "Path to the ffmpeg binary used to decode and encode audio. Defaults to $ZIMTOHRLI_FFMPEG, or ffmpeg in $PATH.")
+	ffmpegArgs := flag.String("ffmpeg_args", strings.Join(aio.FFmpegArgs, " "), "Extra whitespace separated arguments to ffmpeg. Defaults to $ZIMTOHRLI_FFMPEG_ARGS.")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of concurrent workers loading and analyzing files.")
+	flag.Parse()
+	aio.FFmpeg = *ffmpeg
+	aio.FFmpegArgs = strings.Fields(*ffmpegArgs)
+	if (*studies == "") == (flag.NArg() == 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	params := goohrli.DefaultParameters(float64(*sampleRate))
+	if *zimtohrliParametersJSON != "" {
+		if err := params.Update([]byte(*zimtohrliParametersJSON)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	params.SampleRate = float64(*sampleRate)
+	g := goohrli.New(params)
+	if *analysisCache != "" {
+		g.AnalysisCache = &goohrli.AnalysisCache{Dir: *analysisCache}
+	}
+
+	files := []embedding{}
+	if *studies != "" {
+		var err error
+		if files, err = studyFiles(*studies); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, path := range flag.Args() {
+			files = append(files, embedding{Path: path})
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *outputFile != "" {
+		f, err := os.Create(*outputFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bar := progress.New("Embedding")
+	pool := worker.Pool[embedding]{
+		Workers:  *workers,
+		OnChange: bar.Update,
+	}
+	for loopIndex := range files {
+		index := loopIndex
+		pool.SubmitResult(func() (embedding, error) {
+			e := files[index]
+			signal, err := aio.LoadAtRate(e.Path, *sampleRate)
+			if err != nil {
+				return e, fmt.Errorf("unable to load %q: %v", e.Path, err)
+			}
+			if e.Embedding, err = g.Embedding(signal); err != nil {
+				return e, fmt.Errorf("unable to embed %q: %v", e.Path, err)
+			}
+			return e, nil
+		})
+	}
+	results, err := pool.Collect(worker.SubmissionOrder)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	bar.Finish()
+	encoder := json.NewEncoder(w)
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
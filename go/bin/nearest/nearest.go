@@ -0,0 +1,194 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// nearest finds the audio files in a directory or in studies that are perceptually closest to a query clip,
+// e.g. to find duplicate takes and near matches in large audio libraries.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/data"
+	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/progress"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// candidate is a file that may be close to the query.
+type candidate struct {
+	Path string
+	// Name is the name of the reference or distortion in a study, if the candidate was found in a study.
+	Name string `json:",omitempty"`
+	// Distance is the Zimtohrli distance from the query to the candidate.
+	Distance float64
+}
+
+// directoryCandidates returns the files in dir and its subdirectories with one of the extensions.
+func directoryCandidates(dir string, extensions map[string]bool) ([]candidate, error) {
+	result := []candidate{}
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && extensions[strings.ToLower(filepath.Ext(path))] {
+			result = append(result, candidate{Path: path})
+		}
+		return nil
+	})
+	return result, err
+}
+
+// studyCandidates returns the references and distortions in the studies in the directories matching the glob.
+func studyCandidates(glob string) ([]candidate, error) {
+	bundles, err := data.OpenBundles(glob)
+	if err != nil {
+		return nil, err
+	}
+	result := []candidate{}
+	for _, bundle := range bundles {
+		for _, ref := range bundle.References {
+			result = append(result, candidate{Path: data.Resolve(bundle.Dir, ref.Path), Name: ref.Name})
+			for _, dist := range ref.Distortions {
+				result = append(result, candidate{Path: data.Resolve(bundle.Dir, dist.Path), Name: dist.Name})
+			}
+		}
+	}
+	return result, nil
+}
+
+func main() {
+	query := flag.String("query", "", "Path to ffmpeg-decodable file with the query clip.")
+	dir := flag.String("dir", "", "Directory to search for files close to the query in, including subdirectories.")
+	extensions := flag.String("extensions", ".wav,.flac,.mp3,.ogg,.opus,.m4a", "Comma separated extensions of the files searched in -dir.")
+	studies := flag.String("studies", "", "Glob to directories with databases whose references and distortions are searched for files close to the query.")
+	k := flag.Int("k", 10, "Number of closest files to output.")
+	format := flag.String("format", "text", "Output format, text or json.")
+	zimtohrliParametersJSON := flag.String("zimtohrli_parameters", "", "Zimtohrli model parameters.")
+	analysisCache := flag.String("analysis_cache", "", "Directory to store Zimtohrli analyses in, to avoid recomputing the analysis of the query for each file, and across searches.")
+	lengthPolicy := flag.String("length_policy", string(goohrli.LengthWarp), fmt.Sprintf("How to compare the query to files of different lengths, one of %v.", goohrli.LengthPolicies))
+	sampleRate := flag.Int("sample_rate", 48000, "Sample rate the audio files are resampled to before comparing them.")
+	ffmpeg := flag.String("ffmpeg", aio.FFmpeg, "Path to the ffmpeg binary used to decode and encode audio. Defaults to $ZIMTOHRLI_FFMPEG, or ffmpeg in $PATH.")
+	ffmpegArgs := flag.String("ffmpeg_args", strings.Join(aio.FFmpegArgs, " "), "Extra whitespace separated arguments to ffmpeg. Defaults to $ZIMTOHRLI_FFMPEG_ARGS.")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of concurrent workers loading and comparing files.")
+	flag.Parse()
+	aio.FFmpeg = *ffmpeg
+	aio.FFmpegArgs = strings.Fields(*ffmpegArgs)
+	if *query == "" || (*dir == "") == (*studies == "") || *k < 1 || (*format != "text" && *format != "json") {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	params := goohrli.DefaultParameters(float64(*sampleRate))
+	if *zimtohrliParametersJSON != "" {
+		if err := params.Update([]byte(*zimtohrliParametersJSON)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	params.SampleRate = float64(*sampleRate)
+	g := goohrli.New(params)
+	g.LengthPolicy = goohrli.LengthPolicy(*lengthPolicy)
+	if *analysisCache != "" {
+		g.AnalysisCache = &goohrli.AnalysisCache{Dir: *analysisCache}
+	}
+
+	querySignal, err := aio.LoadAtRate(*query, *sampleRate)
+	if err != nil {
+		log.Fatalf("unable to load %q: %v", *query, err)
+	}
+	var candidates []candidate
+	if *dir != "" {
+		extensionSet := map[string]bool{}
+		for _, ext := range strings.Split(*extensions, ",") {
+			extensionSet[strings.ToLower(strings.TrimSpace(ext))] = true
+		}
+		candidates, err = directoryCandidates(*dir, extensionSet)
+	} else {
+		candidates, err = studyCandidates(*studies)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	absQuery, err := filepath.Abs(*query)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bar := progress.New("Comparing")
+	pool := worker.Pool[candidate]{
+		Workers:  *workers,
+		OnChange: bar.Update,
+	}
+	for _, loopCandidate := range candidates {
+		c := loopCandidate
+		if absPath, err := filepath.Abs(c.Path); err == nil && absPath == absQuery {
+			continue
+		}
+		pool.Submit(func(f func(candidate)) error {
+			signal, err := aio.LoadAtRate(c.Path, *sampleRate)
+			if err != nil {
+				return fmt.Errorf("unable to load %q: %v", c.Path, err)
+			}
+			distances, err := g.CompareMany(querySignal, []*audio.Audio{signal})
+			if err != nil {
+				return fmt.Errorf("unable to compare %q to %q: %v", *query, c.Path, err)
+			}
+			c.Distance = distances[0]
+			f(c)
+			return nil
+		})
+	}
+	if err := pool.Error(); err != nil {
+		log.Println(err.Error())
+	}
+	bar.Finish()
+	results := []candidate{}
+	for c := range pool.Results() {
+		results = append(results, c)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return results[i].Path < results[j].Path
+	})
+	if len(results) > *k {
+		results = results[:*k]
+	}
+
+	if *format == "json" {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	for _, c := range results {
+		if c.Name != "" {
+			fmt.Printf("%v\t%s\t%s\n", c.Distance, c.Path, c.Name)
+		} else {
+			fmt.Printf("%v\t%s\n", c.Distance, c.Path)
+		}
+	}
+}
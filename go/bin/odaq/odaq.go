@@ -0,0 +1,217 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// odaq creates a study from the Open Dataset of Audio Quality (ODAQ), https://github.com/Fraunhofer-IIS/ODAQ.
+//
+// Download and unpack the dataset, and use the directory containing the listening test results CSV files
+// as -source when running this binary.
+//
+// The results CSV files have one MUSHRA rating per line, with (case insensitive) "method", "item",
+// "condition", and "score" columns. The ratings of all listeners are averaged per method, item, and condition,
+// and stored as MOS scores on the MUSHRA scale between 0 and 100, like sebass_db does.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/data"
+	"github.com/google/zimtohrli/go/progress"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// key identifies a rated stimulus.
+type key struct {
+	method    string
+	item      string
+	condition string
+}
+
+// rating is the sum and count of the ratings of a stimulus.
+type rating struct {
+	sum   float64
+	count int
+}
+
+// expand replaces {method}, {item}, and {condition} in template with the values of the key.
+func expand(template string, k key) string {
+	return strings.NewReplacer("{method}", k.method, "{item}", k.item, "{condition}", k.condition).Replace(template)
+}
+
+func readRatings(csvPath string, ratings map[key]*rating) error {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	csvReader := csv.NewReader(f)
+	header, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+	cols := map[string]int{}
+	for index, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+	for _, name := range []string{"method", "item", "condition", "score"} {
+		if _, found := cols[name]; !found {
+			return fmt.Errorf("header %+v of %q doesn't match expected ODAQ header, it has no %q column", header, csvPath, name)
+		}
+	}
+	for {
+		line, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		score, err := strconv.ParseFloat(strings.TrimSpace(line[cols["score"]]), 64)
+		if err != nil {
+			return fmt.Errorf("line %+v of %q has invalid score: %v", line, csvPath, err)
+		}
+		k := key{
+			method:    strings.TrimSpace(line[cols["method"]]),
+			item:      strings.TrimSpace(line[cols["item"]]),
+			condition: strings.TrimSpace(line[cols["condition"]]),
+		}
+		r, found := ratings[k]
+		if !found {
+			r = &rating{}
+			ratings[k] = r
+		}
+		r.sum += score
+		r.count++
+	}
+}
+
+func populate(source, dest, results, referencePath, distortionPath string, workers int, failFast bool) error {
+	csvFiles, err := filepath.Glob(filepath.Join(source, results))
+	if err != nil {
+		return err
+	}
+	if len(csvFiles) == 0 {
+		return fmt.Errorf("no results files matching %q in %q", results, source)
+	}
+	ratings := map[key]*rating{}
+	for _, csvFile := range csvFiles {
+		if err := readRatings(csvFile, ratings); err != nil {
+			return err
+		}
+	}
+	// Group the stimuli by method and item, which share a reference.
+	conditions := map[key][]key{}
+	for k := range ratings {
+		refKey := key{method: k.method, item: k.item}
+		conditions[refKey] = append(conditions[refKey], k)
+	}
+	refKeys := []key{}
+	for refKey := range conditions {
+		refKeys = append(refKeys, refKey)
+		sort.Slice(conditions[refKey], func(i, j int) bool {
+			return conditions[refKey][i].condition < conditions[refKey][j].condition
+		})
+	}
+	sort.Slice(refKeys, func(i, j int) bool {
+		if refKeys[i].method != refKeys[j].method {
+			return refKeys[i].method < refKeys[j].method
+		}
+		return refKeys[i].item < refKeys[j].item
+	})
+
+	study, err := data.OpenStudy(dest)
+	if err != nil {
+		return err
+	}
+	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
+
+	bar := progress.New("Transcoding")
+	pool := worker.Pool[*data.Reference]{
+		Workers:  workers,
+		OnChange: bar.Update,
+		FailFast: failFast,
+	}
+	for _, loopRefKey := range refKeys {
+		refKey := loopRefKey
+		pool.Submit(func(f func(*data.Reference)) error {
+			ref := &data.Reference{
+				Name: fmt.Sprintf("%s/%s", refKey.method, refKey.item),
+			}
+			var err error
+			path := filepath.Join(source, expand(referencePath, refKey))
+			if ref.Path, err = aio.Recode(path, dest); err != nil {
+				return fmt.Errorf("unable to fetch %q: %v", path, err)
+			}
+			for _, k := range conditions[refKey] {
+				dist := &data.Distortion{
+					Name: fmt.Sprintf("%s/%s/%s", k.method, k.item, k.condition),
+					Scores: map[data.ScoreType]float64{
+						data.MOS: ratings[k].sum / float64(ratings[k].count),
+					},
+				}
+				path := filepath.Join(source, expand(distortionPath, k))
+				if dist.Path, err = aio.Recode(path, dest); err != nil {
+					return fmt.Errorf("unable to fetch %q: %v", path, err)
+				}
+				ref.Distortions = append(ref.Distortions, dist)
+			}
+			f(ref)
+			return nil
+		})
+	}
+	if err := pool.Error(); err != nil {
+		log.Println(err.Error())
+	}
+	bar.Finish()
+	refs := []*data.Reference{}
+	for ref := range pool.Results() {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return study.Put(refs)
+}
+
+func main() {
+	source := flag.String("source", "", "Directory containing the unpacked ODAQ listening test results and audio.")
+	destination := flag.String("dest", "", "Destination directory.")
+	results := flag.String("results", "*.csv", "Glob matching the results CSV files inside -source.")
+	referencePath := flag.String("reference_path", "{method}/{item}/{item}_ref.wav", "Path inside -source to the reference of a method and item, where {method} and {item} are replaced with their values.")
+	distortionPath := flag.String("distortion_path", "{method}/{item}/{item}_{condition}.wav", "Path inside -source to the audio of a rated condition, where {method}, {item}, and {condition} are replaced with their values.")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of workers transcoding sounds.")
+	failFast := flag.Bool("fail_fast", false, "Whether to exit immediately at the first error.")
+	flag.Parse()
+	if *source == "" || *destination == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := populate(*source, *destination, *results, *referencePath, *distortionPath, *workers, *failFast); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -43,6 +43,9 @@ func populate(source string, dest string, workers int) error {
 		return err
 	}
 	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
 
 	csvURL, err := url.Parse("https://raw.githubusercontent.com/pranaymanocha/PerceptualAudio/master/dataset/dataset_combined.txt")
 	if err != nil {
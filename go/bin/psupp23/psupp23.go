@@ -0,0 +1,262 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// psupp23 creates studies from the ITU-T P.Supplement 23 speech quality database.
+//
+// The database is distributed as one directory per experiment, each containing the source and processed
+// speech files, and a spreadsheet with the per file results. It requires the user to export the results
+// spreadsheet of each experiment to a CSV file in the experiment directory, with a header containing
+// (case insensitive) the columns "File" with the name of the processed file, "Source" with the name of
+// the source file it was processed from, and "MOS". An optional "Condition" column is included in the
+// distortion names.
+//
+// One study is created per experiment, in a subdirectory of the destination named like the experiment
+// directory, since scores from different experiments and labs are not on comparable scales.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/data"
+	"github.com/google/zimtohrli/go/progress"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// importer imports P.Supp23 experiments.
+type importer struct {
+	rawFormat     aio.RawFormat
+	rawExtensions map[string]bool
+	workers       int
+	failFast      bool
+}
+
+func (i *importer) recode(path, dest string) (string, error) {
+	if i.rawExtensions[strings.ToLower(filepath.Ext(path))] {
+		return aio.RecodeRaw(path, i.rawFormat, dest)
+	}
+	return aio.Recode(path, dest)
+}
+
+// indexFiles returns the paths of all files in dir and its subdirectories, keyed by lower case file name.
+func indexFiles(dir string) (map[string]string, error) {
+	result := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			result[strings.ToLower(entry.Name())] = path
+		}
+		return nil
+	})
+	return result, err
+}
+
+// columns returns the index of each wanted column in the header, or an error if a required column is missing.
+func columns(header []string, required []string, optional []string) (map[string]int, error) {
+	result := map[string]int{}
+	for index, name := range header {
+		result[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+	for _, name := range required {
+		if _, found := result[strings.ToLower(name)]; !found {
+			return nil, fmt.Errorf("header %+v has no %q column", header, name)
+		}
+	}
+	for _, name := range optional {
+		if _, found := result[strings.ToLower(name)]; !found {
+			result[strings.ToLower(name)] = -1
+		}
+	}
+	return result, nil
+}
+
+// row is a processed file in an experiment.
+type row struct {
+	file      string
+	condition string
+	mos       float64
+}
+
+func (i *importer) populate(experimentDir, dest string) error {
+	experiment := filepath.Base(experimentDir)
+	csvFiles, err := filepath.Glob(filepath.Join(experimentDir, "*.csv"))
+	if err != nil {
+		return err
+	}
+	if len(csvFiles) != 1 {
+		return fmt.Errorf("not exactly one .csv file in %q", experimentDir)
+	}
+	files, err := indexFiles(experimentDir)
+	if err != nil {
+		return err
+	}
+	csvFile, err := os.Open(csvFiles[0])
+	if err != nil {
+		return err
+	}
+	defer csvFile.Close()
+	csvReader := csv.NewReader(csvFile)
+	header, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+	cols, err := columns(header, []string{"file", "source", "mos"}, []string{"condition"})
+	if err != nil {
+		return fmt.Errorf("%q: %v", csvFiles[0], err)
+	}
+	rowsBySource := map[string][]row{}
+	for {
+		line, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		mos, err := strconv.ParseFloat(strings.TrimSpace(line[cols["mos"]]), 64)
+		if err != nil {
+			return fmt.Errorf("line %+v has invalid MOS: %v", line, err)
+		}
+		r := row{file: strings.TrimSpace(line[cols["file"]]), mos: mos}
+		if cols["condition"] >= 0 {
+			r.condition = strings.TrimSpace(line[cols["condition"]])
+		}
+		source := strings.TrimSpace(line[cols["source"]])
+		rowsBySource[source] = append(rowsBySource[source], r)
+	}
+	sources := []string{}
+	for source := range rowsBySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	study, err := data.OpenStudy(dest)
+	if err != nil {
+		return err
+	}
+	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
+
+	bar := progress.New(fmt.Sprintf("Transcoding %s", experiment))
+	pool := worker.Pool[*data.Reference]{
+		Workers:  i.workers,
+		OnChange: bar.Update,
+		FailFast: i.failFast,
+	}
+	for _, loopSource := range sources {
+		source := loopSource
+		pool.Submit(func(f func(*data.Reference)) error {
+			refPath, found := files[strings.ToLower(source)]
+			if !found {
+				return fmt.Errorf("source file %q not found in %q", source, experimentDir)
+			}
+			ref := &data.Reference{
+				Name: fmt.Sprintf("%s/%s", experiment, source),
+			}
+			var err error
+			if ref.Path, err = i.recode(refPath, dest); err != nil {
+				return fmt.Errorf("unable to fetch %q: %v", refPath, err)
+			}
+			for _, r := range rowsBySource[source] {
+				distPath, found := files[strings.ToLower(r.file)]
+				if !found {
+					return fmt.Errorf("processed file %q not found in %q", r.file, experimentDir)
+				}
+				dist := &data.Distortion{
+					Name: fmt.Sprintf("%s/%s", experiment, r.file),
+					Scores: map[data.ScoreType]float64{
+						data.MOS: r.mos,
+					},
+				}
+				if r.condition != "" {
+					dist.Name = fmt.Sprintf("%s/%s/%s", experiment, r.condition, r.file)
+				}
+				if dist.Path, err = i.recode(distPath, dest); err != nil {
+					return fmt.Errorf("unable to fetch %q: %v", distPath, err)
+				}
+				ref.Distortions = append(ref.Distortions, dist)
+			}
+			f(ref)
+			return nil
+		})
+	}
+	if err := pool.Error(); err != nil {
+		log.Println(err.Error())
+	}
+	bar.Finish()
+	refs := []*data.Reference{}
+	for ref := range pool.Results() {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(a, b int) bool {
+		return refs[a].Name < refs[b].Name
+	})
+	return study.Put(refs)
+}
+
+func main() {
+	source := flag.String("source", "", "Directory containing one directory per P.Supp23 experiment, each with a CSV export of its results spreadsheet.")
+	experiments := flag.String("experiments", "*", "Glob matching the experiment directories inside -source to import.")
+	destination := flag.String("dest", "", "Destination directory, where one study per experiment will be created.")
+	rawFormat := flag.String("raw_format", "s16le", "ffmpeg sample format of the headerless speech files.")
+	rawRate := flag.Int("raw_rate", 8000, "Sample rate of the headerless speech files.")
+	rawExtensions := flag.String("raw_extensions", ".dat,.raw,.pcm,.src", "Comma separated extensions of headerless speech files. Other files are decoded by ffmpeg as is.")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of workers transcoding sounds.")
+	failFast := flag.Bool("fail_fast", false, "Whether to exit immediately at the first error.")
+	flag.Parse()
+	if *source == "" || *destination == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	i := &importer{
+		rawFormat:     aio.RawFormat{SampleFormat: *rawFormat, Rate: *rawRate, Channels: 1},
+		rawExtensions: map[string]bool{},
+		workers:       *workers,
+		failFast:      *failFast,
+	}
+	for _, ext := range strings.Split(*rawExtensions, ",") {
+		i.rawExtensions[strings.ToLower(strings.TrimSpace(ext))] = true
+	}
+	experimentDirs, err := filepath.Glob(filepath.Join(*source, *experiments))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(experimentDirs)
+	for _, experimentDir := range experimentDirs {
+		if info, err := os.Stat(experimentDir); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := i.populate(experimentDir, filepath.Join(*destination, filepath.Base(experimentDir))); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
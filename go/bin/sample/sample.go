@@ -0,0 +1,124 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sample creates a smaller study from a balanced subset of the distortions of a large study, for quick
+// iteration, e.g. when tuning parameters.
+//
+// The distortions are grouped by the -by attributes, which can be generation parameters like codec or language,
+// score types like MOS, whose scores are binned into ranges, or reference, and at most -per_stratum distortions
+// are sampled from each group.
+//
+// The new study refers to the audio of the source study, and doesn't copy it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/data"
+)
+
+// relocate returns the path, relative to the source directory, relative to the destination directory instead.
+func relocate(source, destination, path string) (string, error) {
+	if aio.IsRemote(path) || filepath.IsAbs(path) {
+		return path, nil
+	}
+	absPath, err := filepath.Abs(filepath.Join(source, path))
+	if err != nil {
+		return "", err
+	}
+	absDestination, err := filepath.Abs(destination)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(absDestination, absPath); err == nil {
+		return rel, nil
+	}
+	return absPath, nil
+}
+
+func sample(source, destination string, attributes []string, perStratum, bins int, seed int64, format data.Format) error {
+	sourceStudy, err := data.OpenStudy(source)
+	if err != nil {
+		return err
+	}
+	defer sourceStudy.Close()
+	bundle, err := sourceStudy.ToBundle()
+	if err != nil {
+		return err
+	}
+	sampled, strata, err := bundle.StratifiedSample(attributes, perStratum, bins, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		return err
+	}
+	refs := []*data.Reference{}
+	for _, ref := range sampled.References {
+		newRef := &data.Reference{Name: ref.Name}
+		if newRef.Path, err = relocate(source, destination, ref.Path); err != nil {
+			return err
+		}
+		for _, dist := range ref.Distortions {
+			newDist := *dist
+			if newDist.Path, err = relocate(source, destination, dist.Path); err != nil {
+				return err
+			}
+			newDist.AlternativeReferences = make([]string, len(dist.AlternativeReferences))
+			for index, path := range dist.AlternativeReferences {
+				if newDist.AlternativeReferences[index], err = relocate(source, destination, path); err != nil {
+					return err
+				}
+			}
+			newRef.Distortions = append(newRef.Distortions, &newDist)
+		}
+		refs = append(refs, newRef)
+	}
+	destinationStudy, err := data.OpenStudy(destination)
+	if err != nil {
+		return err
+	}
+	defer destinationStudy.Close()
+	if err := destinationStudy.Lock(); err != nil {
+		return err
+	}
+	if err := destinationStudy.Put(refs); err != nil {
+		return err
+	}
+	fmt.Print(strata.Render(format))
+	return nil
+}
+
+func main() {
+	source := flag.String("source", "", "Directory of the study to sample.")
+	destination := flag.String("dest", "", "Destination directory.")
+	by := flag.String("by", "", "Comma separated attributes to balance the sample by: generation parameters like codec or language, score types like MOS, or reference.")
+	perStratum := flag.Int("per_stratum", 10, "Max number of distortions sampled with each combination of values of the -by attributes.")
+	bins := flag.Int("bins", 5, "Number of equally wide ranges the scores of -by score types are binned into.")
+	seed := flag.Int64("seed", 0, "Seed of the random sampling.")
+	format := flag.String("format", string(data.Text), fmt.Sprintf("Format of the summary of the strata, one of %v.", data.Targets))
+	flag.Parse()
+	if *source == "" || *destination == "" || *by == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := sample(*source, *destination, strings.Split(*by, ","), *perStratum, *bins, *seed, data.Format{Target: data.Target(*format)}); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// command is a subcommand of score, like "score calculate 'studies/*'", which sets the flag of the same name to its
+// argument and accepts only the flags relevant to it.
+type command struct {
+	name string
+	// argument describes the argument of the command, or is empty if the command has no argument and its flag is
+	// a bool.
+	argument    string
+	description string
+	// flags are the flags the command accepts in addition to commonFlags.
+	flags []string
+}
+
+var (
+	// commonFlags are accepted by all commands.
+	commonFlags = []string{"config", "format", "align", "columns", "sort_by", "descending", "highlight", "score_formats", "ffmpeg", "ffmpeg_args", "resampler", "forbid_resampling", "report_resampling", "max_ffmpeg", "zimtohrli_threads", "max_cache_mb", "workers", "fail_fast", "quiet", "log_level", "cpuprofile", "memprofile", "trace"}
+	// zimtohrliFlags configure the Zimtohrli model.
+	zimtohrliFlags = []string{"zimtohrli_parameters", "full_scale_sine_db", "analysis_cache"}
+	// calculationFlags configure the calculation of scores.
+	calculationFlags = append([]string{"force", "calculate_zimtohrli", "zimtohrli_score_type", "calculate_visqol", "calculate_stoi", "calculate_estoi", "calculate_snr", "calculate_si_sdr", "calculate_spectral_distance", "calculate_confidence", "calculate_pipe", "remove_dc_offset", "trim_silence", "silence_threshold", "hearing_loss", "voice_activity", "voice_activity_threshold", "cue_file", "check_levels", "transcript_file", "fail_on_warnings", "channel_policy", "symmetry", "length_policy", "max_memory_mb", "max_distortions_per_reference", "multi_reference", "metric_workers", "stall_timeout", "log_file", "keep_history", "run", "snapshot", "result_cache", "verbose"}, zimtohrliFlags...)
+	// analysisFlags configure the analyses of scores.
+	analysisFlags = []string{"score_types", "correlation_group", "correlation_aggregation", "mos_normalization", "mos_normalization_group", "ensemble_inputs", "ensemble_combiner", "system_attribute", "report_cache", "report_run", "seed"}
+
+	commands = []command{
+		{name: "fetch", argument: "dataset", description: "Downloads, verifies, unpacks, and imports a public dataset as a study.", flags: []string{"fetch_dir"}},
+		{name: "summary", argument: "dir", description: "Prints a summary of the state of a study.", flags: nil},
+		{name: "details", argument: "glob", description: "Prints the contents of studies as JSON.", flags: nil},
+		{name: "calculate", argument: "glob", description: "Calculates metrics for the distortions of studies.", flags: calculationFlags},
+		{name: "evaluate", argument: "glob", description: "Validates studies, calculates missing Zimtohrli scores, and prints a report.", flags: append(append([]string{"analyses"}, calculationFlags...), analysisFlags...)},
+		{name: "report", argument: "glob", description: "Prints a report with analyses of studies.", flags: append([]string{"analyses"}, analysisFlags...)},
+		{name: "analyze", argument: "glob", description: "Prints analyses of studies.", flags: append([]string{"analyses"}, analysisFlags...)},
+		{name: "correlate", argument: "glob", description: "Prints the correlations between the scores of studies.", flags: analysisFlags},
+		{name: "accuracy", argument: "glob", description: "Prints the JND accuracy of the metrics in studies.", flags: analysisFlags},
+		{name: "leaderboard", argument: "glob", description: "Prints the metrics ranked by their errors across studies.", flags: analysisFlags},
+		{name: "fit_ensemble", argument: "glob", description: "Fits an ensemble of metrics predicting MOS, and stores its predictions.", flags: []string{"ensemble_inputs", "ensemble_combiner", "ensemble_score_type"}},
+		{name: "optimize", argument: "glob", description: "Optimizes the Zimtohrli parameters for studies.", flags: []string{"optimize_logfile", "optimize_start_step", "optimize_num_steps", "seed"}},
+		{name: "dedup", argument: "glob", description: "Finds, and optionally merges, duplicated references and distortions in studies.", flags: append([]string{"dedup_threshold", "dedup_merge", "snapshot"}, zimtohrliFlags...)},
+		{name: "quarantined", argument: "glob", description: "Lists the non-finite scores quarantined by calculations.", flags: nil},
+		{name: "clone", argument: "dir", description: "Copies a study to a new study with all audio transcoded to a uniform format.", flags: []string{"clone_dir", "clone_rate", "clone_mono", "clone_extension"}},
+		{name: "relativize", argument: "dir", description: "Makes the absolute audio paths inside the directory of a study relative to it.", flags: nil},
+		{name: "orphans", argument: "dir", description: "Lists, and optionally deletes or archives, the audio files of a study no reference or distortion refers to.", flags: []string{"orphans_delete", "orphans_archive"}},
+		{name: "export", argument: "glob", description: "Exports the scores of studies as JSON lines.", flags: []string{"export_file"}},
+		{name: "dump", argument: "dir", description: "Dumps the entire content of a study as JSON lines.", flags: []string{"dump_file"}},
+		{name: "restore", argument: "dir", description: "Replaces the content of a study with a dump.", flags: []string{"restore_file"}},
+		{name: "snapshot", argument: "name", description: "Stores a named snapshot of studies.", flags: []string{"snapshot_studies"}},
+		{name: "rollback", argument: "name", description: "Restores studies to a named snapshot.", flags: []string{"snapshot_studies"}},
+		{name: "version", description: "Prints the version and build flags of Zimtohrli."},
+	}
+)
+
+// usage prints the commands, and the deprecated flags that run them.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: score <command> [flags] <argument>\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-18s %s\n", strings.TrimSpace(cmd.name+" "+cmd.argument), cmd.description)
+	}
+	fmt.Fprintf(out, "\nRun 'score <command> -h' for the flags of a command.\n\nDeprecated: the commands can also be run with the flags of the same names, which will be removed in the next release:\n")
+	flag.PrintDefaults()
+}
+
+// parseCommandLine parses the command and its flags, or the flags of the flag.CommandLine if the first argument is
+// a flag, sets the flag of the command to its argument, and applies the -config file.
+func parseCommandLine() {
+	flag.Usage = usage
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		flag.Parse()
+		for _, cmd := range commands {
+			if f := flag.Lookup(cmd.name); f != nil && f.Value.String() != f.DefValue {
+				logging.Warningf("-%s is deprecated, use 'score %s' instead", cmd.name, cmd.name)
+			}
+		}
+		applyConfigFlag()
+		return
+	}
+	name := os.Args[1]
+	if name == "help" {
+		usage()
+		os.Exit(0)
+	}
+	var cmd *command
+	for index := range commands {
+		if commands[index].name == name {
+			cmd = &commands[index]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("score "+name, flag.ExitOnError)
+	for _, flagName := range append(append([]string{}, cmd.flags...), commonFlags...) {
+		f := flag.Lookup(flagName)
+		if f == nil {
+			log.Panicf("command %q has unknown flag %q", name, flagName)
+		}
+		flags.Var(f.Value, f.Name, f.Usage)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: score %s [flags] %s\n\n%s\n\nFlags:\n", name, cmd.argument, cmd.description)
+		flags.PrintDefaults()
+	}
+	// Flags may follow the argument.
+	args := []string{}
+	for rest := os.Args[2:]; ; rest = flags.Args()[1:] {
+		if err := flags.Parse(rest); err != nil {
+			log.Fatal(err)
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		args = append(args, flags.Arg(0))
+	}
+	wantArgs := 1
+	if cmd.argument == "" {
+		wantArgs = 0
+	}
+	if len(args) != wantArgs {
+		fmt.Fprintf(flags.Output(), "'score %s' takes %v arguments, got %q, quote globs to keep the shell from expanding them\n\n", name, wantArgs, args)
+		flags.Usage()
+		os.Exit(2)
+	}
+	value := "true"
+	if wantArgs > 0 {
+		value = args[0]
+	}
+	if err := flag.Set(name, value); err != nil {
+		log.Fatal(err)
+	}
+	// Setting the flags again marks them as set in flag.CommandLine, for flag.Visit.
+	flags.Visit(func(f *flag.Flag) {
+		if err := flag.Set(f.Name, f.Value.String()); err != nil {
+			log.Fatal(err)
+		}
+	})
+	applyConfigFlag()
+}
+
+// applyConfigFlag applies the config in the file of the -config flag, if any.
+func applyConfigFlag() {
+	if path := flag.Lookup("config").Value.String(); path != "" {
+		if err := applyConfig(flag.CommandLine, path); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
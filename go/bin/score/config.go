@@ -0,0 +1,181 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// config describes a run of score, to make recurring runs reproducible and reviewable in version control.
+type config struct {
+	// Command is the command to run, like "evaluate".
+	Command string `json:"command"`
+	// Argument is the argument of the command, like the glob to the studies.
+	Argument string `json:"argument"`
+	// Metrics are the metrics to calculate, like "zimtohrli" for -calculate_zimtohrli.
+	Metrics []string `json:"metrics"`
+	// Flags maps flag names to values. Lists are joined with commas, and objects, like zimtohrli_parameters, are
+	// passed as JSON.
+	Flags map[string]any `json:"flags"`
+}
+
+// loadConfig returns the config in the JSON file.
+func loadConfig(path string) (*config, error) {
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		return nil, fmt.Errorf("%q looks like YAML, but configs must be JSON", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	result := &config{}
+	if err := decoder.Decode(result); err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", path, err)
+	}
+	return result, nil
+}
+
+// flagValue returns the value as a flag value.
+func flagValue(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case json.Number:
+		return value.String(), nil
+	case []any:
+		values := make([]string, len(value))
+		for index, element := range value {
+			s, err := flagValue(element)
+			if err != nil {
+				return "", err
+			}
+			values[index] = s
+		}
+		return strings.Join(values, ","), nil
+	case map[string]any:
+		b, err := json.Marshal(value)
+		return string(b), err
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// applyConfig sets the flags of the config that weren't set on the command line in flags, and runs the command of the
+// config unless the command line selected one.
+func applyConfig(flags *flag.FlagSet, path string) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	var cmd *command
+	for index := range commands {
+		if setFlags[commands[index].name] {
+			cmd = &commands[index]
+		}
+	}
+	if cmd != nil {
+		if cfg.Command != "" && cfg.Command != cmd.name {
+			logging.Warningf("Running 'score %s' from the command line instead of %q from %q", cmd.name, cfg.Command, path)
+		}
+	} else if cfg.Command != "" {
+		for index := range commands {
+			if commands[index].name == cfg.Command {
+				cmd = &commands[index]
+			}
+		}
+		if cmd == nil {
+			return fmt.Errorf("unknown command %q in %q", cfg.Command, path)
+		}
+		value := "true"
+		if cmd.argument != "" {
+			if cfg.Argument == "" {
+				return fmt.Errorf("command %q in %q needs a %s argument", cfg.Command, path, cmd.argument)
+			}
+			value = cfg.Argument
+		}
+		if err := flags.Set(cmd.name, value); err != nil {
+			return err
+		}
+	}
+	// When the command is known, the config may only use its flags, to catch flags that wouldn't have any effect.
+	allowed := func(name string) bool { return true }
+	if cmd != nil {
+		allowed = func(name string) bool {
+			for _, flagName := range cmd.flags {
+				if flagName == name {
+					return true
+				}
+			}
+			for _, flagName := range commonFlags {
+				if flagName == name {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	values := map[string]string{}
+	for _, metric := range cfg.Metrics {
+		values["calculate_"+metric] = "true"
+	}
+	for name, value := range cfg.Flags {
+		if _, found := values[name]; found {
+			return fmt.Errorf("flag %q in %q is also set by its metrics", name, path)
+		}
+		if values[name], err = flagValue(value); err != nil {
+			return fmt.Errorf("flag %q in %q: %v", name, path, err)
+		}
+	}
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q in %q", name, path)
+		}
+		if !allowed(name) {
+			return fmt.Errorf("flag %q in %q doesn't apply to 'score %s'", name, path, cmd.name)
+		}
+		if setFlags[name] {
+			continue
+		}
+		if err := flags.Set(name, values[name]); err != nil {
+			return fmt.Errorf("flag %q in %q: %v", name, path, err)
+		}
+	}
+	return nil
+}
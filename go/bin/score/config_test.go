@@ -0,0 +1,127 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// configFlags returns a flag set with the flags of some commands, and the flags set on the command line.
+func configFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	flags := flag.NewFlagSet("score", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.String("calculate", "", "")
+	flags.String("report", "", "")
+	flags.Bool("calculate_zimtohrli", false, "")
+	flags.Bool("calculate_stoi", false, "")
+	flags.Bool("force", false, "")
+	flags.Int("workers", 1, "")
+	flags.String("format", "text", "")
+	flags.String("analyses", "", "")
+	flags.String("zimtohrli_parameters", "", "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+// writeConfig writes the config to a file with the extension in a new directory, and returns its path.
+func writeConfig(t *testing.T, ext, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config"+ext)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfig(t *testing.T) {
+	calculateConfig := writeConfig(t, ".json", `{
+  "command": "calculate",
+  "argument": "studies/*",
+  "metrics": ["zimtohrli", "stoi"],
+  "flags": {"workers": 16, "force": true, "zimtohrli_parameters": {"NSIMStepWindow": 8}}
+}`)
+	for _, tc := range []struct {
+		name string
+		args []string
+		want map[string]string
+	}{
+		{
+			name: "config only",
+			want: map[string]string{"calculate": "studies/*", "calculate_zimtohrli": "true", "calculate_stoi": "true", "workers": "16", "force": "true", "zimtohrli_parameters": `{"NSIMStepWindow":8}`},
+		},
+		{
+			name: "flags override the config",
+			args: []string{"-workers", "2", "-force=false", "-calculate_stoi=false"},
+			want: map[string]string{"calculate": "studies/*", "calculate_zimtohrli": "true", "calculate_stoi": "false", "workers": "2", "force": "false"},
+		},
+		{
+			name: "command line command overrides the config",
+			args: []string{"-calculate", "other/*"},
+			want: map[string]string{"calculate": "other/*", "workers": "16"},
+		},
+	} {
+		flags := configFlags(t, tc.args...)
+		if err := applyConfig(flags, calculateConfig); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		for name, want := range tc.want {
+			if got := flags.Lookup(name).Value.String(); got != want {
+				t.Errorf("%s: -%s = %q, want %q", tc.name, name, got, want)
+			}
+		}
+	}
+
+	jsonConfig := writeConfig(t, ".json", `{"command": "report", "argument": "studies/*", "flags": {"analyses": ["correlation", "accuracy"], "format": "markdown"}}`)
+	flags := configFlags(t, "-format", "html")
+	if err := applyConfig(flags, jsonConfig); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"report": "studies/*", "analyses": "correlation,accuracy", "format": "html", "calculate": ""} {
+		if got := flags.Lookup(name).Value.String(); got != want {
+			t.Errorf("JSON config: -%s = %q, want %q", name, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		content string
+	}{
+		{"metric conflicting with a flag", nil, `{"command": "calculate", "argument": "x", "metrics": ["zimtohrli"], "flags": {"calculate_zimtohrli": false}}`},
+		{"flag not applying to the command", nil, `{"command": "report", "argument": "x", "flags": {"force": true}}`},
+		{"flag not applying to the command line command", []string{"-report", "x"}, `{"metrics": ["zimtohrli"]}`},
+		{"unknown flag", nil, `{"flags": {"unknown": 1}}`},
+		{"unknown command", nil, `{"command": "unknown"}`},
+		{"missing argument", nil, `{"command": "calculate"}`},
+		{"unknown field", nil, `{"commands": "calculate"}`},
+		{"invalid flag value", nil, `{"flags": {"workers": "many"}}`},
+		{"invalid JSON", nil, `{"command": "calculate",`},
+	} {
+		if err := applyConfig(configFlags(t, tc.args...), writeConfig(t, ".json", tc.content)); err == nil {
+			t.Errorf("%s: applyConfig returned no error", tc.name)
+		}
+	}
+	if err := applyConfig(configFlags(t), writeConfig(t, ".yaml", "command: report\n")); err == nil {
+		t.Errorf("applyConfig with a YAML config returned no error")
+	}
+}
@@ -17,210 +17,580 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"reflect"
 	"runtime"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
 	"github.com/google/zimtohrli/go/data"
 	"github.com/google/zimtohrli/go/goohrli"
-	"github.com/google/zimtohrli/go/pipe"
-	"github.com/google/zimtohrli/go/progress"
-	"github.com/google/zimtohrli/go/worker"
-)
-
-const (
-	sampleRate = 48000
+	"github.com/google/zimtohrli/go/logging"
+	"github.com/google/zimtohrli/go/profile"
+	"github.com/google/zimtohrli/go/score"
 )
 
 func main() {
+	fetch := flag.String("fetch", "", fmt.Sprintf("Name of a public dataset to download, verify, unpack, and import as a study, one of %v. The importer binary of the dataset has to be installed.", score.DatasetNames()))
+	fetchDir := flag.String("fetch_dir", ".", "Directory where -fetch creates a directory for the dataset, containing the downloads, the unpacked files, and the study.")
+	export := flag.String("export", "", "Glob to directories with databases to export all reference and distortion pairs and their scores from, as JSON lines with standardized columns.")
+	exportFile := flag.String("export_file", "", "File to write -export output to. Defaults to stdout.")
+	dump := flag.String("dump", "", "Directory with a database to dump the entire content of, as JSON lines with stable ordering, for archival and version control independent of the database format.")
+	dumpFile := flag.String("dump_file", "", "File to write -dump output to. Defaults to stdout.")
+	restore := flag.String("restore", "", "Directory with a database, created if necessary, to replace the content of with a -dump read from -restore_file, before any other operation.")
+	restoreFile := flag.String("restore_file", "", "File to read the -restore dump from. Defaults to stdin.")
+	snapshot := flag.String("snapshot", "", "Name of a snapshot to store of the -snapshot_studies before any other operation, to allow undoing e.g. -force recalculation using -rollback.")
+	rollback := flag.String("rollback", "", "Name of a snapshot to restore the -snapshot_studies to before any other operation.")
+	snapshotStudies := flag.String("snapshot_studies", "", "Glob to directories with databases to -snapshot or -rollback. Defaults to the -calculate glob, or the -dedup glob if -dedup_merge is set.")
+	summary := flag.String("summary", "", "Directory with a database to print a summary of, with the number of references and distortions, the coverage of each score type, the audio files, the metadata, and when it was last modified, before any other operation.")
 	details := flag.String("details", "", "Glob to directories with databases to show the details of.")
 	calculate := flag.String("calculate", "", "Glob to directories with databases to calculate metrics for.")
+	evaluate := flag.String("evaluate", "", "Glob to directories with databases to evaluate in one run: checks that the studies have distortions and that their audio files exist, calculates missing Zimtohrli scores with -check_levels, and prints a report with -analyses. Other -calculate flags, like -workers and -calculate_visqol, apply too.")
 	force := flag.Bool("force", false, "Whether to recalculate scores that already exist.")
 	calculateZimtohrli := flag.Bool("calculate_zimtohrli", false, "Whether to calculate Zimtohrli scores.")
 	zimtohrliScoreType := flag.String("zimtohrli_score_type", string(data.Zimtohrli), "Score type name to use when storing Zimtohrli scores in a dataset.")
 	calculateViSQOL := flag.Bool("calculate_visqol", false, "Whether to calculate ViSQOL scores.")
+	calculateSTOI := flag.Bool("calculate_stoi", false, fmt.Sprintf("Whether to calculate %q scores with the built-in short-time objective intelligibility measure, for speech.", data.STOI))
+	calculateESTOI := flag.Bool("calculate_estoi", false, fmt.Sprintf("Whether to calculate %q scores with the built-in extended short-time objective intelligibility measure, for speech with modulated maskers.", data.ESTOI))
+	calculateSNR := flag.Bool("calculate_snr", false, fmt.Sprintf("Whether to calculate %q scores with the built-in signal-to-noise ratio baseline.", data.SNR))
+	calculateSISDR := flag.Bool("calculate_si_sdr", false, fmt.Sprintf("Whether to calculate %q scores with the built-in scale-invariant signal-to-distortion ratio baseline.", data.SISDR))
+	calculateSpectralDistance := flag.Bool("calculate_spectral_distance", false, fmt.Sprintf("Whether to calculate %q scores with the built-in log-spectral distance baseline.", data.SpectralDistance))
+	calculateConfidence := flag.Bool("calculate_confidence", false, fmt.Sprintf("Whether to store the confidence in each comparison, based on the duration, energy, and saturation of the audio, as %q scores.", data.Confidence))
 	calculatePipeMetric := flag.String("calculate_pipe", "", "Path to a binary that serves metrics via stdin/stdout pipe. Install some of the via 'install_python_metrics.py'.")
-	zimtohrliParameters := goohrli.DefaultParameters(48000)
+	zimtohrliParameters := goohrli.DefaultParameters(score.SampleRate)
 	b, err := json.Marshal(zimtohrliParameters)
 	if err != nil {
 		log.Panic(err)
 	}
+	calculateZimtohrliMOS := flag.Bool("calculate_zimtohrli_mos", false, fmt.Sprintf("Whether to calculate Zimtohrli distances mapped to mean opinion scores by -mos_mapping, stored as %q scores.", data.ZimtohrliMOS))
+	mosMappingJSON, err := json.Marshal(goohrli.DefaultMOSMapping)
+	if err != nil {
+		log.Panic(err)
+	}
+	mosMappingFlag := flag.String("mos_mapping", string(mosMappingJSON), "JSON array with the coefficients [a, b, c] of the sigmoid a / (b + exp(c * distance)) mapping Zimtohrli distances to the mean opinion scores of -calculate_zimtohrli_mos.")
 	zimtohrliParametersJSON := flag.String("zimtohrli_parameters", string(b), "Zimtohrli model parameters. Sample rate will be set to the sample rate of the measured audio files.")
+	analysisCache := flag.String("analysis_cache", "", "Directory to store Zimtohrli analyses in, to avoid recomputing them for the same audio and parameters.")
+	resultCache := flag.String("result_cache", "", "Directory to store calculated scores in, keyed by the hashes of the measured audio and the metric parameters, to avoid recalculating them for unchanged pairs, e.g. after -force or in other studies. Pipe metrics are keyed by path, so the cache must be cleared when they change.")
 	correlate := flag.String("correlate", "", "Glob to directories with databases to correlate scores for.")
 	leaderboard := flag.String("leaderboard", "", "Glob to directories with databases to compute leaderboard for.")
-	report := flag.String("report", "", "Glob to directories with databases to generate a report for.")
+	report := flag.String("report", "", "Glob to directories with databases to generate a report with -analyses for.")
+	analyzeGlob := flag.String("analyze", "", "Glob to directories with databases to run -analyses for.")
+	analysesFlag := flag.String("analyses", strings.Join(data.DefaultAnalyses, ","), fmt.Sprintf("Comma separated analyses to run for -analyze and -report. Available analyses:\n%s", data.AnalysisHelp()))
 	accuracy := flag.String("accuracy", "", "Glob to directories with databases to provide JND accuracy for.")
+	dedup := flag.String("dedup", "", "Glob to directories with databases to find duplicated references and distortions in.")
+	dedupThreshold := flag.Float64("dedup_threshold", 0, "If positive, distortions of the same reference with a Zimtohrli distance at most this are considered duplicates by -dedup, in addition to identical files.")
+	dedupMerge := flag.Bool("dedup_merge", false, "Whether -dedup should merge duplicated distortions of the same reference into one, with the mean of their scores.")
+	clone := flag.String("clone", "", "Directory with a database to copy to a new study in -clone_dir, with all audio transcoded to a uniform format, e.g. for metrics that only accept some sample rates.")
+	cloneDir := flag.String("clone_dir", "", "Directory to create the study cloned by -clone in.")
+	cloneRate := flag.Int("clone_rate", 48000, "Sample rate of the audio in the study cloned by -clone.")
+	cloneMono := flag.Bool("clone_mono", false, "Whether the audio in the study cloned by -clone is downmixed to the mean of its channels.")
+	cloneExtension := flag.String("clone_extension", ".wav", "Extension of the audio files in the study cloned by -clone, which defines their encoding, e.g. .wav for 16 bit PCM WAV files, or .flac.")
+	relativize := flag.String("relativize", "", "Directory with a database to rewrite the absolute audio paths inside the directory of to be relative to it, after verifying that all audio files exist, so that the study can be copied to other machines.")
+	orphans := flag.String("orphans", "", "Directory with a database to list the audio files in, including subdirectories except the snapshots, that no reference, distortion, or alternative reference refers to, e.g. left behind by repeated imports.")
+	orphansDelete := flag.Bool("orphans_delete", false, "Whether -orphans deletes the listed files.")
+	orphansArchive := flag.String("orphans_archive", "", "Directory -orphans moves the listed files to, keeping their paths relative to the study directory. Must not be inside the study directory.")
+	quarantined := flag.String("quarantined", "", "Glob to directories with databases to list the NaN and infinite scores of, that -calculate quarantined instead of storing. Entries are removed when a later -calculate stores a finite score.")
 	optimize := flag.String("optimize", "", "Glob to directories with databases to optimize for.")
 	optimizeLogfile := flag.String("optimize_logfile", "", "File to write optimization events to.")
 	optimizeStartStep := flag.Float64("optimize_start_step", 1, "Start step for the simulated annealing.")
 	optimizeNumSteps := flag.Float64("optimize_num_steps", 1000, "Number of steps for the simulated annealing.")
+	fullScaleSineDB := flag.Float64("full_scale_sine_db", zimtohrliParameters.FullScaleSineDB, "Assumed playback level, in dB SPL, of a sine wave with amplitude 1. Masking and audibility depend on the absolute level, so this should match the calibration of the intended playback. Overrides -zimtohrli_parameters.")
+	ffmpeg := flag.String("ffmpeg", aio.FFmpeg, "Path to the ffmpeg binary used to decode and encode audio. Defaults to $ZIMTOHRLI_FFMPEG, or ffmpeg in $PATH.")
+	ffmpegArgs := flag.String("ffmpeg_args", strings.Join(aio.FFmpegArgs, " "), "Extra whitespace separated arguments to ffmpeg. Defaults to $ZIMTOHRLI_FFMPEG_ARGS.")
+	resampler := flag.String("resampler", aio.Resampler, "Options of the ffmpeg aresample filter used when audio is decoded at another sample rate than its own, e.g. 'resampler=soxr:precision=28'. Defaults to $ZIMTOHRLI_RESAMPLER, or the default ffmpeg resampler.")
+	forbidResampling := flag.Bool("forbid_resampling", false, "Whether to fail instead of resampling audio that doesn't have the sample rate it's compared at.")
+	verbose := flag.Bool("verbose", false, "Whether -calculate should log the time spent decoding each audio file, including resampling which ffmpeg performs while decoding, the time spent in each measurement of each reference and distortion pair, and the time Zimtohrli measurements spent analyzing and comparing in each study.")
+	reportResampling := flag.Bool("report_resampling", false, "Whether to probe the sample rate of each loaded audio file, and log how many files were resampled from which rates, and with which resampler.")
+	maxFFmpeg := flag.Int("max_ffmpeg", aio.MaxConcurrentFFmpeg(), "Max number of concurrent ffmpeg processes, independent of -workers. Zero means unlimited. Defaults to $ZIMTOHRLI_MAX_FFMPEG.")
+	zimtohrliThreads := flag.Int("zimtohrli_threads", goohrli.MaxThreads(), "Max number of concurrent Zimtohrli and ViSQOL computations in the C++ library, which runs each on the thread of the calling worker, independent of -workers and -metric_workers. Set it to the number of cores available to the run to avoid oversubscribing shared machines while -workers load audio. Zero means unlimited, i.e. one per worker. Defaults to $ZIMTOHRLI_THREADS.")
+	removeDCOffset := flag.Bool("remove_dc_offset", false, "Whether to remove the DC offset of references and distortions before measuring them.")
+	trimSilence := flag.Bool("trim_silence", false, "Whether to remove leading and trailing silence from references and distortions before measuring them.")
+	hearingLoss := flag.String("hearing_loss", "", "If set, a hearing loss simulated before measuring, so that the scores are as heard by a listener with the loss. Either one of the standard audiograms N1-N4 and S1-S3 by Bisgaard et al., or a JSON array like '[{\"Frequency\": 1000, \"LossDB\": 20}, {\"Frequency\": 4000, \"LossDB\": 45}]' with hearing threshold shifts.")
+	silenceThreshold := flag.Float64("silence_threshold", -60, "Level in dB FS below which -trim_silence considers audio silent.")
+	voiceActivity := flag.Bool("voice_activity", false, "Whether -calculate should only measure the parts of references and distortions where the reference has voice activity, detected by the energy of 20 ms frames, and store the means of the scores of the parts weighted by duration, so that long silences in e.g. conversational speech don't dilute the scores. Use -cue_file to measure the parts found by an external voice activity detector instead.")
+	voiceActivityThreshold := flag.Float64("voice_activity_threshold", audio.DefaultVoiceActivity.ThresholdDB, "Energy in dB, relative to the loudest frame of the reference, below which -voice_activity considers frames inactive.")
+	cueFile := flag.String("cue_file", "", "JSON file with an object mapping distortion paths or names to arrays of segments, like '{\"dist.wav\": [{\"Start\": 0.5, \"End\": 2}]}', for datasets where listeners only rated some phrases. -calculate then only measures those segments of the distortions and their references, stores the segments in the studies, and recalculates scores of distortions whose segments changed.")
+	checkLevels := flag.Bool("check_levels", false, "Whether to log warnings about clipped or near silent references and distortions when loading them for calculating scores.")
+	transcriptFile := flag.String("transcript_file", "", "JSON file with an object mapping reference names to transcripts, like '{\"ref1\": \"the birch canoe slid on the smooth planks\"}', stored in the studies by -calculate for metrics needing transcripts, like the ASR adapter go/pipe/asr_wer.py.")
+	failOnWarnings := flag.Bool("fail_on_warnings", false, "Whether -check_levels warnings should make -calculate fail for the study, without measuring the audio with warnings.")
+	channelPolicy := flag.String("channel_policy", string(goohrli.ChannelsPerChannel), fmt.Sprintf("How to measure references and distortions with multiple channels, one of %v.", goohrli.ChannelPolicies))
+	symmetry := flag.String("symmetry", string(goohrli.SymmetryForward), fmt.Sprintf("In which directions Zimtohrli distances are computed, one of %v. The distance is not symmetric, and forward treats energy added and removed by the distortion differently, while max and mean combine the distances from the reference to the distortion and from the distortion to the reference.", goohrli.Symmetries))
+	lengthPolicy := flag.String("length_policy", string(goohrli.LengthWarp), fmt.Sprintf("How to compare references and distortions of different lengths, one of %v.", goohrli.LengthPolicies))
+	format := flag.String("format", string(data.Text), fmt.Sprintf("Output format of -correlate, -accuracy, -report, -analyze, and -leaderboard, one of %v.", data.Targets))
+	align := flag.String("align", string(data.AlignAuto), fmt.Sprintf("Alignment of table columns, one of %v. %s aligns the first column and columns of text left, and columns of numbers right.", data.Alignments, data.AlignAuto))
+	columns := flag.String("columns", "", "Comma separated column headings, e.g. MOS,Zimtohrli, to restrict tables with any of them to their first column and those columns. Useful to make wide correlation tables readable.")
+	sortBy := flag.String("sort_by", "", "Column heading to sort the rows of tables with that column by, numerically where the cells start with numbers.")
+	descending := flag.Bool("descending", false, "Whether -sort_by sorts in descending order.")
+	highlight := flag.Bool("highlight", false, "Whether to mark the max of each column of numbers in bold, and the min in italics.")
+	scoreFormats := flag.String("score_formats", "", "Comma separated ScoreType=format pairs, e.g. Zimtohrli=%.5g,PESQ=%.2f, with the fmt formats reports render scores of those types with. Defaults to formats suiting the scales of the known score types, and 4 significant digits for others.")
+	reportCache := flag.String("report_cache", "", "Directory to cache per study analysis results in, to avoid recomputing them for unchanged studies.")
+	seed := flag.Int64("seed", 0, "Seed for randomized analyses and optimization. Runs with the same seed on the same data produce identical output.")
 	workers := flag.Int("workers", runtime.NumCPU(), "Number of concurrent workers for tasks.")
+	maxMemoryMB := flag.Uint64("max_memory_mb", 0, "If positive, the max resident memory in MiB of -calculate. New measurements wait while it's exceeded, instead of the process getting killed for running out of memory. If it's exceeded with no measurements running, -calculate fails, and this needs to be increased.")
+	maxDistortionsPerReference := flag.Int("max_distortions_per_reference", 0, "If positive, the max number of distortions of the same reference loaded or measured concurrently by -calculate, to limit memory use for references with many distortions. Other references are processed meanwhile.")
+	multiReference := flag.String("multi_reference", string(data.MultiReferenceBest), fmt.Sprintf("How -calculate combines the scores of distortions with AlternativeReferences against each of their references, one of %v. %s uses the max of scores where higher is better, and the min of distances.", data.MultiReferencePolicies, data.MultiReferenceBest))
+	maxCacheMB := flag.Int64("max_cache_mb", 0, "If positive, the max disk space in MiB used by downloaded remote audio. The least recently used files are removed to stay below it, and downloads pause while only files in use remain.")
+	stallTimeout := flag.Duration("stall_timeout", 0, "If positive, -calculate logs the loads and measurements that have been running for longer than this, and again for each further -stall_timeout, with the reference and distortion they process, e.g. to find the audio pairs that hang a metric.")
+	metricWorkers := flag.String("metric_workers", "", "Comma separated ScoreType=N pairs with the number of concurrent workers for measurements of the score type in -calculate, e.g. Zimtohrli=32,PESQ=2. Other measurements use -workers.")
+	logFile := flag.String("log_file", "", "File to append one JSON line per completed or failed measurement of -calculate to, with reference, distortion, score type, duration, and error.")
+	keepHistory := flag.Bool("keep_history", false, "Whether -calculate should append each calculated score, with time, -run, and parameters, to the history of its distortion.")
+	run := flag.String("run", "", "Name of the -calculate run stored with the scores in the histories of the distortions when -keep_history is set.")
+	scoreTypes := flag.String("score_types", "", "Comma separated score types, e.g. Zimtohrli,PESQ, to restrict -correlate, -accuracy, -report, -analyze, and -leaderboard to, along with MOS, JND, and Preference. Use the coverage analysis to see which score types all studies have.")
+	correlationGroup := flag.String("correlation_group", "", "If set, MOS correlations in -correlate, -report, -analyze, and -leaderboard are computed within each group of distortions with the same value of this attribute, reference or a generation parameter like session, and then aggregated with -correlation_aggregation, instead of pooling all distortions of a study. This avoids conflating differences in how listeners used the MOS scale in different sessions with differences in quality.")
+	correlationAggregation := flag.String("correlation_aggregation", string(data.AggregationMean), fmt.Sprintf("How the correlations of the -correlation_group groups are aggregated, one of %v.", data.Aggregations))
+	mosNormalization := flag.String("mos_normalization", string(data.NormalizationNone), fmt.Sprintf("How MOS scores are normalized before -correlate, -report, -analyze, and -leaderboard, to reduce differences in how listeners in different labs or sessions used the MOS scale, one of %v. %s uses distortions with the same path as their reference, or with the generation parameter %s=true.", data.Normalizations, data.NormalizationHiddenReference, data.HiddenReferenceParameter))
+	mosNormalizationGroup := flag.String("mos_normalization_group", "", "If set, MOS scores are normalized within each group of distortions with the same value of this attribute, reference or a generation parameter like session, instead of within each study.")
+	fitEnsemble := flag.String("fit_ensemble", "", "Glob to directories with databases to fit an ensemble of -ensemble_inputs predicting MOS to, storing its predictions as -ensemble_score_type in the distortions with all inputs. The stored scores are in-sample predictions, use the ensemble analysis to cross validate ensembles.")
+	ensembleInputs := flag.String("ensemble_inputs", "", "Comma separated score types, e.g. Zimtohrli,ViSQOL, combined by -fit_ensemble and the ensemble analysis. The ensemble analysis defaults to all score types not from listeners.")
+	systemAttribute := flag.String("system_attribute", data.SystemCondition, fmt.Sprintf("How the systems analysis groups the distortions of MOS studies into systems, e.g. codec settings, whose mean scores are correlated with their mean MOS. Either %s, for all generation parameters of the distortions, or a generation parameter like codec.", data.SystemCondition))
+	ensembleCombiner := flag.String("ensemble_combiner", string(data.CombinerLinear), fmt.Sprintf("How -fit_ensemble and the ensemble analysis combine -ensemble_inputs, one of %v.", data.Combiners))
+	ensembleScoreType := flag.String("ensemble_score_type", "Ensemble", "Score type -fit_ensemble stores its predictions as.")
+	reportRun := flag.String("report_run", "", "Name of a -run whose scores in the histories of the distortions -report and -analyze should use instead of the latest scores.")
+	version := flag.Bool("version", false, "Whether to print the version and build flags of Zimtohrli and exit.")
+	flag.String("config", "", "JSON file describing a run, like '{\"command\": \"evaluate\", \"argument\": \"studies/*\", \"metrics\": [\"zimtohrli\", \"stoi\"], \"flags\": {\"format\": \"markdown\"}}', where metrics set the -calculate_ flags of the same names. Flags and commands on the command line override the config.")
 	failFast := flag.Bool("fail_fast", false, "Whether to panic immediately on any error.")
-	flag.Parse()
+	prof := profile.Flags()
+	logs := logging.Flags()
+	parseCommandLine()
+	logs.Apply()
+	if *version {
+		fmt.Println(goohrli.Version())
+		return
+	}
+	aio.FFmpeg = *ffmpeg
+	aio.FFmpegArgs = strings.Fields(*ffmpegArgs)
+	aio.Resampler = *resampler
+	aio.ForbidResampling = *forbidResampling
+	if *reportResampling {
+		resamplingLock := sync.Mutex{}
+		resamplings := map[string]int{}
+		aio.OnResampling = func(r aio.Resampling) {
+			resamplingLock.Lock()
+			defer resamplingLock.Unlock()
+			resamplings[fmt.Sprintf("from %v Hz to %v Hz using %s", r.FromRate, r.ToRate, r.Resampler)]++
+		}
+		defer func() {
+			resamplingLock.Lock()
+			defer resamplingLock.Unlock()
+			descriptions := []string{}
+			for description := range resamplings {
+				descriptions = append(descriptions, description)
+			}
+			sort.Strings(descriptions)
+			for _, description := range descriptions {
+				log.Printf("Resampled %v files %s", resamplings[description], description)
+			}
+		}()
+	}
+	if *verbose {
+		aio.OnDecode = func(timing aio.DecodeTiming) {
+			log.Printf("Decoded %v", timing)
+		}
+	}
+	aio.SetMaxConcurrentFFmpeg(*maxFFmpeg)
+	goohrli.SetMaxThreads(*zimtohrliThreads)
+	aio.MaxCacheBytes = *maxCacheMB << 20
+	stopProfile, err := prof.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := stopProfile(); err != nil {
+			log.Print(err)
+		}
+	}()
 
-	if *details == "" && *calculate == "" && *correlate == "" && *accuracy == "" && *leaderboard == "" && *report == "" && *optimize == "" {
+	if *fetch == "" && *summary == "" && *details == "" && *export == "" && *dump == "" && *restore == "" && *snapshot == "" && *rollback == "" && *calculate == "" && *evaluate == "" && *correlate == "" && *accuracy == "" && *leaderboard == "" && *report == "" && *analyzeGlob == "" && *dedup == "" && *quarantined == "" && *orphans == "" && *relativize == "" && *clone == "" && *optimize == "" && *fitEnsemble == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if err := zimtohrliParameters.Update([]byte(*zimtohrliParametersJSON)); err != nil {
-		log.Panic(err)
+	outputFormat := data.Format{
+		Target:     data.Target(*format),
+		Align:      data.Alignment(*align),
+		SortBy:     *sortBy,
+		Descending: *descending,
+		Highlight:  *highlight,
+	}
+	if *columns != "" {
+		outputFormat.Columns = strings.Split(*columns, ",")
+	}
+	if !slices.Contains(data.Targets, outputFormat.Target) {
+		log.Fatalf("unknown -format %q, want one of %v", *format, data.Targets)
+	}
+	if !slices.Contains(data.Alignments, outputFormat.Align) {
+		log.Fatalf("unknown -align %q, want one of %v", *align, data.Alignments)
+	}
+	if *scoreFormats != "" {
+		for _, pair := range strings.Split(*scoreFormats, ",") {
+			scoreType, scoreFormat, found := strings.Cut(pair, "=")
+			if !found {
+				log.Fatalf("invalid -score_formats pair %q, want ScoreType=format", pair)
+			}
+			if err := data.RegisterScoreFormat(data.ScoreType(scoreType), scoreFormat); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if !slices.Contains(data.Aggregations, data.Aggregation(*correlationAggregation)) {
+		log.Fatalf("unknown -correlation_aggregation %q, want one of %v", *correlationAggregation, data.Aggregations)
+	}
+	if !slices.Contains(data.Normalizations, data.Normalization(*mosNormalization)) {
+		log.Fatalf("unknown -mos_normalization %q, want one of %v", *mosNormalization, data.Normalizations)
+	}
+	if !slices.Contains(data.MultiReferencePolicies, data.MultiReferencePolicy(*multiReference)) {
+		log.Fatalf("unknown -multi_reference %q, want one of %v", *multiReference, data.MultiReferencePolicies)
+	}
+	if !slices.Contains(data.Combiners, data.Combiner(*ensembleCombiner)) {
+		log.Fatalf("unknown -ensemble_combiner %q, want one of %v", *ensembleCombiner, data.Combiners)
+	}
+	ensembleScoreTypes := data.ScoreTypes{}
+	if *ensembleInputs != "" {
+		for _, scoreType := range strings.Split(*ensembleInputs, ",") {
+			ensembleScoreTypes = append(ensembleScoreTypes, data.ScoreType(scoreType))
+		}
 	}
 
-	if *optimize != "" {
-		bundles, err := data.OpenBundles(*optimize)
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	if setFlags["zimtohrli_parameters"] {
+		if err := zimtohrliParameters.Update([]byte(*zimtohrliParametersJSON)); err != nil {
+			log.Panic(err)
+		}
+	}
+	if setFlags["full_scale_sine_db"] {
+		zimtohrliParameters.FullScaleSineDB = *fullScaleSineDB
+	}
+	mosMapping := goohrli.MOSMapping{}
+	if err := json.Unmarshal([]byte(*mosMappingFlag), &mosMapping); err != nil {
+		log.Fatalf("-mos_mapping: %v", err)
+	}
+
+	if *fetch != "" {
+		studyDir, err := score.Fetch(*fetch, *fetchDir)
 		if err != nil {
 			log.Fatal(err)
 		}
-		optimizeLog := func(ev data.OptimizationEvent) {}
-		if *optimizeLogfile != "" {
-			f, err := os.OpenFile(*optimizeLogfile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-			if err != nil {
+		logging.Infof("Fetched %v into %q", *fetch, studyDir)
+	}
+
+	if *snapshotStudies == "" {
+		if *calculate != "" {
+			*snapshotStudies = *calculate
+		} else if *dedup != "" && *dedupMerge {
+			*snapshotStudies = *dedup
+		}
+	}
+	if (*snapshot != "" || *rollback != "") && *snapshotStudies == "" {
+		log.Fatal("-snapshot and -rollback need -snapshot_studies, -calculate, or -dedup with -dedup_merge")
+	}
+
+	if *summary != "" {
+		studySummary, err := score.Summary(*summary)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(outputFormat.Heading(2, studySummary.Dir))
+		fmt.Print(studySummary.Render(outputFormat))
+	}
+
+	if *restore != "" {
+		in := os.Stdin
+		if *restoreFile != "" {
+			if in, err = os.Open(*restoreFile); err != nil {
 				log.Fatal(err)
 			}
-			optimizeLog = func(ev data.OptimizationEvent) {
-				b, _ := json.Marshal(ev)
-				f.WriteString(string(b) + "\n")
-				f.Sync()
-			}
 		}
-		err = bundles.Optimize(*optimizeStartStep, *optimizeNumSteps, optimizeLog)
-		if err != nil {
+		if err := score.Restore(*restore, in); err != nil {
+			log.Fatal(err)
+		}
+		in.Close()
+	}
+	if *rollback != "" {
+		if err := score.Rollback(*snapshotStudies, *rollback); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *snapshot != "" {
+		if err := score.Snapshot(*snapshotStudies, *snapshot); err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	if *calculate != "" {
-		studies, err := data.OpenStudies(*calculate)
+	if *optimize != "" {
+		if err := score.Optimize(*optimize, *seed, *optimizeStartStep, *optimizeNumSteps, *optimizeLogfile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var measurementLog io.Writer
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer studies.Close()
-		for _, study := range studies {
-			measurements := map[data.ScoreType]data.Measurement{}
-			if *calculateZimtohrli {
-				if !reflect.DeepEqual(zimtohrliParameters, goohrli.DefaultParameters(zimtohrliParameters.SampleRate)) {
-					log.Printf("Using %+v", zimtohrliParameters)
-				}
-				zimtohrliParameters.SampleRate = sampleRate
-				z := goohrli.New(zimtohrliParameters)
-				measurements[data.ScoreType(*zimtohrliScoreType)] = z.NormalizedAudioDistance
-			}
-			if *calculateViSQOL {
-				v := goohrli.NewViSQOL()
-				measurements[data.ViSQOL] = v.AudioMOS
-			}
-			if *calculatePipeMetric != "" {
-				pool, err := pipe.NewMeterPool(*calculatePipeMetric)
-				if err != nil {
-					log.Fatal(err)
-				}
-				defer pool.Close()
-				measurements[pool.ScoreType] = pool.Measure
-			}
-			if len(measurements) == 0 {
-				log.Print("No metrics to calculate, provide one of the -calculate_XXX flags!")
-				os.Exit(2)
-			}
-			sortedTypes := sort.StringSlice{}
-			for scoreType := range measurements {
-				sortedTypes = append(sortedTypes, string(scoreType))
-			}
-			sort.Sort(sortedTypes)
-			bundle, err := study.ToBundle()
-			if err != nil {
+		defer f.Close()
+		measurementLog = f
+	}
+	// newCalculator returns a calculator configured by the flags, shared by -calculate and -evaluate.
+	newCalculator := func() *score.Calculator {
+		calculator := &score.Calculator{
+			Zimtohrli:           *calculateZimtohrli,
+			ZimtohrliScoreType:  data.ScoreType(*zimtohrliScoreType),
+			ZimtohrliParameters: zimtohrliParameters,
+			ZimtohrliMOS:        *calculateZimtohrliMOS,
+			MOSMapping:          mosMapping,
+			AnalysisCache:       *analysisCache,
+			ResultCache:         *resultCache,
+			Verbose:             *verbose,
+			ViSQOL:              *calculateViSQOL,
+			PipeMetric:          *calculatePipeMetric,
+			STOI:                *calculateSTOI,
+			ESTOI:               *calculateESTOI,
+			SNR:                 *calculateSNR,
+			SISDR:               *calculateSISDR,
+			SpectralDistance:    *calculateSpectralDistance,
+			Confidence:          *calculateConfidence,
+			Preprocessing: audio.Preprocessing{
+				RemoveDCOffset:       *removeDCOffset,
+				TrimSilence:          *trimSilence,
+				SilenceThresholdDBFS: *silenceThreshold,
+			},
+			LengthPolicy:               goohrli.LengthPolicy(*lengthPolicy),
+			ChannelPolicy:              goohrli.ChannelPolicy(*channelPolicy),
+			Symmetry:                   goohrli.Symmetry(*symmetry),
+			FailOnWarnings:             *failOnWarnings,
+			Force:                      *force,
+			KeepHistory:                *keepHistory,
+			Run:                        *run,
+			Workers:                    *workers,
+			MaxMemory:                  *maxMemoryMB << 20,
+			MaxDistortionsPerReference: *maxDistortionsPerReference,
+			StallTimeout:               *stallTimeout,
+			MultiReferencePolicy:       data.MultiReferencePolicy(*multiReference),
+			FailFast:                   *failFast,
+			Progress:                   true,
+		}
+		if *hearingLoss != "" {
+			if calculator.Preprocessing.HearingLoss, err = audio.ParseAudiogram(*hearingLoss); err != nil {
 				log.Fatal(err)
 			}
-			log.Printf("*** Calculating %+v (force=%v) for %v", sortedTypes, *force, bundle.Dir)
-			bar := progress.New("Calculating")
-			pool := &worker.Pool[any]{
-				Workers:  *workers,
-				OnChange: bar.Update,
-				FailFast: *failFast,
-			}
-			if err := bundle.Calculate(measurements, pool, *force); err != nil {
-				log.Printf("%#v", err)
+		}
+		if *voiceActivity {
+			activity := audio.DefaultVoiceActivity
+			activity.ThresholdDB = *voiceActivityThreshold
+			calculator.VoiceActivity = &activity
+		}
+		if *cueFile != "" {
+			if calculator.Cues, err = score.LoadCues(*cueFile); err != nil {
 				log.Fatal(err)
 			}
-			if err := study.Put(bundle.References); err != nil {
+		}
+		if *transcriptFile != "" {
+			if calculator.Transcripts, err = score.LoadTranscripts(*transcriptFile); err != nil {
 				log.Fatal(err)
 			}
-			bar.Finish()
 		}
+		if *metricWorkers != "" {
+			calculator.MetricWorkers = map[data.ScoreType]int{}
+			for _, pair := range strings.Split(*metricWorkers, ",") {
+				scoreType, workers, found := strings.Cut(pair, "=")
+				n, err := strconv.Atoi(workers)
+				if !found || err != nil || n < 1 {
+					log.Fatalf("invalid -metric_workers pair %q, want ScoreType=N with N > 0", pair)
+				}
+				calculator.MetricWorkers[data.ScoreType(scoreType)] = n
+			}
+		}
+		if *checkLevels {
+			calculator.LevelCheck = &audio.DefaultLevelCheck
+		}
+		calculator.Log = measurementLog
+		return calculator
 	}
 
-	if *correlate != "" {
-		bundles, err := data.OpenBundles(*correlate)
+	if *calculate != "" {
+		if err := newCalculator().Calculate(*calculate); errors.Is(err, score.ErrNoMeasurements) {
+			log.Print("No metrics to calculate, provide one of the -calculate_XXX flags!")
+			os.Exit(2)
+		} else if err != nil {
+			log.Printf("%#v", err)
+			log.Fatal(err)
+		}
+	}
+
+	if *fitEnsemble != "" {
+		if len(ensembleScoreTypes) == 0 {
+			log.Fatal("-fit_ensemble needs -ensemble_inputs")
+		}
+		ensemble, err := score.FitEnsemble(*fitEnsemble, data.Combiner(*ensembleCombiner), ensembleScoreTypes, data.ScoreType(*ensembleScoreType))
 		if err != nil {
 			log.Fatal(err)
 		}
-		for _, bundle := range bundles {
-			if bundle.IsJND() {
-				fmt.Printf("Not computing correlation for JND dataset %q\n\n", bundle.Dir)
-			} else {
-				corrTable, err := bundle.Correlate()
-				if err != nil {
-					log.Fatal(err)
-				}
-				fmt.Printf("## %v\n\n", bundle.Dir)
-				fmt.Println(corrTable)
+		logging.Infof("Stored %v = %v", *ensembleScoreType, ensemble)
+	}
+
+	// analysisOptions returns the analysis options configured by the flags.
+	analysisOptions := func(decimals int) data.AnalysisOptions {
+		opts := data.AnalysisOptions{Format: outputFormat, Decimals: decimals, Workers: *workers, Seed: *seed, CacheDir: *reportCache, Run: *reportRun, CorrelationGroup: *correlationGroup, CorrelationAggregation: data.Aggregation(*correlationAggregation), Normalization: data.Normalization(*mosNormalization), NormalizationGroup: *mosNormalizationGroup, EnsembleInputs: ensembleScoreTypes, EnsembleCombiner: data.Combiner(*ensembleCombiner), SystemAttribute: *systemAttribute}
+		if *scoreTypes != "" {
+			for _, scoreType := range strings.Split(*scoreTypes, ",") {
+				opts.ScoreTypes = append(opts.ScoreTypes, data.ScoreType(scoreType))
 			}
 		}
+		return opts
 	}
 
-	if *accuracy != "" {
-		bundles, err := data.OpenBundles(*accuracy)
+	// analyze prints the named analyses of the studies in glob, as a report if asReport is set.
+	analyze := func(glob string, names []string, decimals int, asReport bool) {
+		opts := analysisOptions(decimals)
+		var output string
+		var err error
+		if asReport {
+			output, err = score.Report(glob, names, opts)
+		} else {
+			output, err = score.Analyze(glob, names, opts)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
-		for _, bundle := range bundles {
-			if bundle.IsJND() {
-				accuracy, err := bundle.JNDAccuracy()
-				if err != nil {
-					log.Fatal(err)
-				}
-				fmt.Printf("## %v\n", bundle.Dir)
-				fmt.Println(accuracy)
-			} else {
-				fmt.Printf("Not computing accuracy for non-JND dataset %q\n\n", bundle.Dir)
-			}
+		fmt.Println(output)
+	}
+	if *evaluate != "" {
+		output, err := score.Evaluate(*evaluate, newCalculator(), strings.Split(*analysesFlag, ","), analysisOptions(2))
+		if err != nil {
+			log.Fatal(err)
 		}
+		fmt.Println(output)
+	}
+	if *correlate != "" {
+		analyze(*correlate, []string{"correlation"}, 2, false)
+	}
+	if *accuracy != "" {
+		analyze(*accuracy, []string{"accuracy"}, 2, false)
 	}
-
 	if *report != "" {
-		bundles, err := data.OpenBundles(*report)
+		analyze(*report, strings.Split(*analysesFlag, ","), 2, true)
+	}
+	if *analyzeGlob != "" {
+		analyze(*analyzeGlob, strings.Split(*analysesFlag, ","), 2, false)
+	}
+	if *leaderboard != "" {
+		analyze(*leaderboard, []string{"leaderboard"}, 15, false)
+	}
+
+	if *dedup != "" {
+		studies, err := score.Dedup(*dedup, zimtohrliParameters, *dedupThreshold, *dedupMerge, *workers)
 		if err != nil {
 			log.Fatal(err)
 		}
-		report, err := bundles.Report()
+		for _, study := range studies {
+			fmt.Print(outputFormat.Heading(2, study.Dir))
+			fmt.Println(study.Duplicates.Render(outputFormat))
+			if *dedupMerge {
+				fmt.Print(outputFormat.Paragraph(fmt.Sprintf("Merged %v distortions", study.Merged)))
+			}
+		}
+	}
+
+	if *quarantined != "" {
+		studies, err := score.Quarantined(*quarantined)
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println(report)
+		for _, study := range studies {
+			fmt.Print(outputFormat.Heading(2, study.Dir))
+			fmt.Println(study.Scores.Render(outputFormat))
+		}
 	}
 
-	if *leaderboard != "" {
-		bundles, err := data.OpenBundles(*leaderboard)
-		if err != nil {
+	if *clone != "" {
+		if *cloneDir == "" {
+			log.Fatal("-clone needs -clone_dir")
+		}
+		format := data.TranscodeFormat{Rate: *cloneRate, Mono: *cloneMono, Extension: *cloneExtension}
+		if err := score.Clone(*clone, *cloneDir, format, *workers); err != nil {
 			log.Fatal(err)
 		}
-		board, err := bundles.Leaderboard(15)
+	}
+
+	if *relativize != "" {
+		paths, err := score.MakePathsRelative(*relativize)
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println(board)
+		logging.Infof("Made %v paths in %q relative", paths.Rewritten, *relativize)
+		for _, path := range paths.Outside {
+			logging.Warningf("%q is outside %q, and was kept absolute", path, *relativize)
+		}
 	}
 
-	if *details != "" {
-		bundles, err := data.OpenBundles(*details)
+	if *orphans != "" {
+		files, err := score.Orphans(*orphans, *orphansDelete, *orphansArchive)
 		if err != nil {
 			log.Fatal(err)
 		}
-		b, err := json.MarshalIndent(bundles, "", "  ")
+		fmt.Print(outputFormat.Heading(2, *orphans))
+		fmt.Println(files.Render(outputFormat))
+		switch {
+		case *orphansDelete:
+			logging.Infof("Deleted %v orphaned audio files", len(files))
+		case *orphansArchive != "":
+			logging.Infof("Moved %v orphaned audio files to %q", len(files), *orphansArchive)
+		}
+	}
+
+	if *export != "" {
+		if *exportFile == "" {
+			if err := score.Export(*export, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			f, err := os.Create(*exportFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := score.Export(*export, f); err != nil {
+				log.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if *dump != "" {
+		if *dumpFile == "" {
+			if err := score.Dump(*dump, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			f, err := os.Create(*dumpFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := score.Dump(*dump, f); err != nil {
+				log.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if *details != "" {
+		b, err := score.Details(*details)
 		if err != nil {
 			log.Fatal(err)
 		}
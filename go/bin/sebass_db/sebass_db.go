@@ -45,6 +45,9 @@ func populate(source string, dest string, workers int, failFast bool) error {
 		return err
 	}
 	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
 
 	csvFiles, err := filepath.Glob(filepath.Join(source, "*.csv"))
 	if err != nil {
@@ -87,7 +90,8 @@ func populate(source string, dest string, workers int, failFast bool) error {
 			if len(line) == 0 {
 				continue
 			}
-			if line[3] == "anchor" {
+			anchor := line[3] == "anchor"
+			if anchor {
 				line[3] = "anker_mix"
 			}
 			if line[3] == "hidden_ref" {
@@ -120,6 +124,9 @@ func populate(source string, dest string, workers int, failFast bool) error {
 						data.MOS: mos,
 					},
 				}
+				if anchor {
+					dist.Generation = &data.Generation{Parameters: map[string]string{data.AnchorParameter: "true"}}
+				}
 				path = filepath.Join(source, signals, line[3], fmt.Sprintf("%s.wav", line[2]))
 				dist.Path, err = aio.Recode(path, dest)
 				if err != nil {
@@ -45,6 +45,9 @@ func populate(source string, dest string, workers int, failFast bool) error {
 		return err
 	}
 	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
 
 	csvFiles, err := filepath.Glob(filepath.Join(source, "*.csv"))
 	if err != nil {
@@ -0,0 +1,122 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build js && wasm && zimtohrli_purego
+
+// wasm exposes Zimtohrli to JavaScript, e.g. to show approximate scores in a listening test web UI without
+// uploading the audio.
+//
+// It must be built with the pure-Go reference implementation:
+//
+//	GOOS=js GOARCH=wasm go build -tags zimtohrli_purego -o zimtohrli.wasm ./go/bin/wasm
+//
+// Running it defines globalThis.zimtohrli with the functions:
+//
+//	// compare returns the Zimtohrli distances and approximate mean opinion scores of the distortions compared to
+//	// the reference. The reference is an array of Float32Array channels, e.g. from AudioBuffer.getChannelData,
+//	// and distortions is an array of such references.
+//	zimtohrli.compare(reference, distortions, sampleRate) // {distances: [...], mos: [...]} or {error: "..."}
+//	// version returns the version of Zimtohrli.
+//	zimtohrli.version()
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"syscall/js"
+
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/goohrli"
+)
+
+// float32sFromJS returns a copy of the samples of the Float32Array.
+func float32sFromJS(value js.Value) ([]float32, error) {
+	if !value.InstanceOf(js.Global().Get("Float32Array")) {
+		return nil, fmt.Errorf("%v isn't a Float32Array", value.Type())
+	}
+	bytes := make([]byte, value.Get("byteLength").Int())
+	js.CopyBytesToGo(bytes, js.Global().Get("Uint8Array").New(value.Get("buffer"), value.Get("byteOffset"), value.Get("byteLength")))
+	result := make([]float32, len(bytes)/4)
+	for index := range result {
+		result[index] = math.Float32frombits(binary.LittleEndian.Uint32(bytes[index*4:]))
+	}
+	return result, nil
+}
+
+// audioFromJS returns the audio in the array of Float32Array channels.
+func audioFromJS(value js.Value, sampleRate float64) (*audio.Audio, error) {
+	if !value.InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("%v isn't an array of channels", value.Type())
+	}
+	result := &audio.Audio{Samples: make([][]float32, value.Length()), Rate: sampleRate}
+	for channelIndex := range result.Samples {
+		channel, err := float32sFromJS(value.Index(channelIndex))
+		if err != nil {
+			return nil, fmt.Errorf("channel %v: %v", channelIndex, err)
+		}
+		result.Samples[channelIndex] = channel
+		result.MaxAbsAmplitude = max(result.MaxAbsAmplitude, goohrli.Measure(channel).MaxAbsAmplitude)
+	}
+	return result, nil
+}
+
+func compare(g *goohrli.Goohrli, args []js.Value) (map[string]any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("compare takes a reference, an array of distortions, and a sample rate, got %v arguments", len(args))
+	}
+	sampleRate := args[2].Float()
+	reference, err := audioFromJS(args[0], sampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("the reference: %v", err)
+	}
+	if !args[1].InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("the distortions aren't an array")
+	}
+	distortions := make([]*audio.Audio, args[1].Length())
+	for distortionIndex := range distortions {
+		if distortions[distortionIndex], err = audioFromJS(args[1].Index(distortionIndex), sampleRate); err != nil {
+			return nil, fmt.Errorf("distortion %v: %v", distortionIndex, err)
+		}
+	}
+	distances, err := g.CompareMany(reference, distortions)
+	if err != nil {
+		return nil, err
+	}
+	jsDistances := make([]any, len(distances))
+	jsMOS := make([]any, len(distances))
+	for distortionIndex, distance := range distances {
+		jsDistances[distortionIndex] = distance
+		jsMOS[distortionIndex] = goohrli.MOSFromZimtohrli(distance)
+	}
+	return map[string]any{"distances": jsDistances, "mos": jsMOS}, nil
+}
+
+func main() {
+	g := goohrli.New(goohrli.DefaultParameters(48000))
+	js.Global().Set("zimtohrli", js.ValueOf(map[string]any{
+		"compare": js.FuncOf(func(this js.Value, args []js.Value) any {
+			result, err := compare(g, args)
+			if err != nil {
+				return map[string]any{"error": err.Error()}
+			}
+			return result
+		}),
+		"version": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return goohrli.Version().String()
+		}),
+	}))
+	// Keeps the functions available to JavaScript.
+	select {}
+}
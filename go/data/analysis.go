@@ -0,0 +1,536 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/dgryski/go-onlinestats"
+	"github.com/google/zimtohrli/go/logging"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// AnalysisOptions controls how analyses are rendered.
+type AnalysisOptions struct {
+	Format   Format
+	Decimals int
+	// Workers is the number of studies and global analyses rendered concurrently, runtime.NumCPU() if zero.
+	Workers int
+	// Seed is the seed of all randomness in analyses, so that analyses with the same seed produce identical output.
+	Seed int64
+	// CacheDir, if set, is a directory where per study results are cached, keyed by the content of the study and these options.
+	CacheDir string
+	// Run, if set, makes the analyses use the scores from that run in the history of the distortions instead of the latest scores.
+	Run string
+	// ScoreTypes, if set, restricts the analyses to these score types, along with MOS, JND, and Preference.
+	ScoreTypes []ScoreType
+	// CorrelationGroup, if set, makes MOS correlations computed within each group of distortions with the same
+	// value of this attribute, "reference" or a generation parameter like "session", and then aggregated.
+	CorrelationGroup string
+	// CorrelationAggregation is how the correlations of the groups are aggregated, AggregationMean if empty.
+	CorrelationAggregation Aggregation
+	// Normalization, if set, is how the MOS scores of the studies are normalized before the analyses.
+	Normalization Normalization
+	// NormalizationGroup, if set, makes the MOS scores normalized within each group of distortions with the same
+	// value of this attribute, "reference" or a generation parameter like "session", instead of within each study.
+	NormalizationGroup string
+	// EnsembleInputs, if set, are the score types the ensemble analysis combines, instead of all score types not
+	// from listeners.
+	EnsembleInputs []ScoreType
+	// EnsembleCombiner is how the ensemble analysis combines the score types, CombinerLinear if empty.
+	EnsembleCombiner Combiner
+	// SystemAttribute is how the systems analysis groups distortions into systems, SystemCondition if empty, or a
+	// generation parameter like "codec".
+	SystemAttribute string
+}
+
+// analysisCacheVersion is part of all cache keys, and must be increased when the output of any analysis changes.
+const analysisCacheVersion = 7
+
+// Hash returns a hash of the references and scores in the bundle.
+func (r *ReferenceBundle) Hash() (string, error) {
+	b, err := json.Marshal(r.References)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// cached returns the cached result for the key parts if opts.CacheDir contains one, otherwise it computes and caches the result.
+func cached(opts AnalysisOptions, compute func() ([]byte, error), keyParts ...any) ([]byte, error) {
+	if opts.CacheDir == "" {
+		return compute()
+	}
+	hash := sha256.New()
+	for _, part := range append([]any{analysisCacheVersion}, keyParts...) {
+		fmt.Fprintf(hash, "%v\x00", part)
+	}
+	path := filepath.Join(opts.CacheDir, hex.EncodeToString(hash.Sum(nil))+".cache")
+	if b, err := os.ReadFile(path); err == nil {
+		return b, nil
+	} else if !os.IsNotExist(err) {
+		logging.Warningf("Ignoring unreadable cached analysis %q: %v", path, err)
+	}
+	b, err := compute()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+		return nil, err
+	}
+	tmpFile, err := os.CreateTemp(opts.CacheDir, "zimtohrli.go.data.Analysis.*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmpFile.Write(b); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return nil, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// bundleHash returns the hash of the bundle if opts.CacheDir is set.
+func bundleHash(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+	if opts.CacheDir == "" {
+		return "", nil
+	}
+	return bundle.Hash()
+}
+
+// Analysis is a named component of a report.
+//
+// An analysis renders a section for each study with Study, and/or a section for all studies with Global.
+type Analysis struct {
+	Name        string
+	Description string
+	// Study returns the section for a single study, or an empty string if the analysis doesn't apply to the study.
+	Study func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error)
+	// GlobalTitle is the heading of the section returned by Global.
+	GlobalTitle string
+	// Global returns the section for all studies.
+	Global func(bundles ReferenceBundles, opts AnalysisOptions) (string, error)
+}
+
+var registeredAnalyses = map[string]*Analysis{}
+
+// RegisterAnalysis makes an analysis available to Analyses and GetAnalyses.
+func RegisterAnalysis(analysis *Analysis) {
+	if _, found := registeredAnalyses[analysis.Name]; found {
+		panic(fmt.Errorf("analysis %q registered twice", analysis.Name))
+	}
+	registeredAnalyses[analysis.Name] = analysis
+}
+
+// Analyses returns all registered analyses, ordered by name.
+func Analyses() []*Analysis {
+	result := []*Analysis{}
+	for _, analysis := range registeredAnalyses {
+		result = append(result, analysis)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// AnalysisNames returns the names of all registered analyses, ordered by name.
+func AnalysisNames() []string {
+	result := []string{}
+	for _, analysis := range Analyses() {
+		result = append(result, analysis.Name)
+	}
+	return result
+}
+
+// GetAnalyses returns the registered analyses with the given names, in the given order.
+func GetAnalyses(names ...string) ([]*Analysis, error) {
+	result := []*Analysis{}
+	for _, name := range names {
+		analysis, found := registeredAnalyses[name]
+		if !found {
+			return nil, fmt.Errorf("unknown analysis %q, want one of %v", name, AnalysisNames())
+		}
+		result = append(result, analysis)
+	}
+	return result, nil
+}
+
+// DefaultAnalyses are the names of the analyses included in a report by default.
+var DefaultAnalyses = []string{"correlation", "systems", "accuracy", "preference", "leaderboard"}
+
+// Analyze returns the per study sections of the analyses for each bundle, followed by the global sections of the analyses.
+//
+// The studies and global sections are rendered concurrently, but the output is always in the order of the bundles and analyses.
+//
+// If opts.Run is set the scores of the bundles are replaced using UseRun, if opts.ScoreTypes is set the
+// analyses use copies of the bundles from WithScoreTypes, and if opts.Normalization is set they use copies of the
+// bundles from NormalizeMOS.
+func (r ReferenceBundles) Analyze(analyses []*Analysis, opts AnalysisOptions) (string, error) {
+	if opts.Run != "" {
+		replaced := 0
+		for _, bundle := range r {
+			replaced += bundle.UseRun(opts.Run)
+		}
+		if replaced == 0 {
+			return "", fmt.Errorf("no scores from run %q found", opts.Run)
+		}
+	}
+	if len(opts.ScoreTypes) > 0 {
+		filtered := make(ReferenceBundles, len(r))
+		for index, bundle := range r {
+			filtered[index] = bundle.WithScoreTypes(opts.ScoreTypes)
+		}
+		r = filtered
+	}
+	if opts.Normalization != "" {
+		normalized := make(ReferenceBundles, len(r))
+		for index, bundle := range r {
+			var err error
+			if normalized[index], err = bundle.NormalizeMOS(opts.Normalization, opts.NormalizationGroup); err != nil {
+				return "", err
+			}
+		}
+		r = normalized
+	}
+	workers := opts.Workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+	pool := &worker.Pool[any]{Workers: workers}
+	studySections := make([][]string, len(r))
+	for loopBundleIndex := range r {
+		bundleIndex := loopBundleIndex
+		bundle := r[bundleIndex]
+		pool.Submit(func(func(any)) error {
+			hash, err := bundleHash(bundle, opts)
+			if err != nil {
+				return err
+			}
+			for _, analysis := range analyses {
+				if analysis.Study == nil {
+					continue
+				}
+				section, err := cached(opts, func() ([]byte, error) {
+					section, err := analysis.Study(bundle, opts)
+					return []byte(section), err
+				}, hash, "section", analysis.Name, opts.Format, opts.Decimals, registeredScoreFormats, registeredMetricProfiles(), opts.Seed, opts.CorrelationGroup, opts.CorrelationAggregation, opts.EnsembleInputs, opts.EnsembleCombiner, opts.SystemAttribute)
+				if err != nil {
+					return fmt.Errorf("while running %q for %q: %v", analysis.Name, bundle.Dir, err)
+				}
+				if len(section) > 0 {
+					studySections[bundleIndex] = append(studySections[bundleIndex], string(section))
+				}
+			}
+			return nil
+		})
+	}
+	globalSections := make([]string, len(analyses))
+	for loopAnalysisIndex := range analyses {
+		analysisIndex := loopAnalysisIndex
+		analysis := analyses[analysisIndex]
+		if analysis.Global == nil {
+			continue
+		}
+		pool.Submit(func(func(any)) error {
+			section, err := analysis.Global(r, opts)
+			if err != nil {
+				return fmt.Errorf("while running %q: %v", analysis.Name, err)
+			}
+			globalSections[analysisIndex] = opts.Format.Heading(2, analysis.GlobalTitle) + section
+			return nil
+		})
+	}
+	if err := pool.Error(); err != nil {
+		return "", err
+	}
+	res := &bytes.Buffer{}
+	for bundleIndex, sections := range studySections {
+		if len(sections) > 0 {
+			fmt.Fprint(res, opts.Format.Heading(2, filepath.Base(r[bundleIndex].Dir)))
+			for _, section := range sections {
+				fmt.Fprintln(res, section)
+			}
+		}
+	}
+	for _, section := range globalSections {
+		fmt.Fprint(res, section)
+	}
+	return res.String(), nil
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "correlation",
+		Description: "Spearman correlation between all score types in MOS studies.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			if bundle.Kind() != KindUnlabeled {
+				if bundle = bundle.ofKind(KindMOS); bundle == nil {
+					return "", nil
+				}
+			}
+			corrTable, err := bundle.correlate(opts)
+			if err != nil {
+				return "", err
+			}
+			return bundle.subsetNote(opts.Format) + corrTable.Render(opts.Format), nil
+		},
+	})
+	RegisterAnalysis(&Analysis{
+		Name:        "accuracy",
+		Description: "Audibility classification accuracy of all score types in JND studies.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			if bundle = bundle.ofKind(KindJND); bundle == nil {
+				return "", nil
+			}
+			accuracy, err := bundle.JNDAccuracy()
+			if err != nil {
+				return "", err
+			}
+			return bundle.subsetNote(opts.Format) + accuracy.Render(opts.Format), nil
+		},
+	})
+	RegisterAnalysis(&Analysis{
+		Name:        "leaderboard",
+		Description: "Score types ranked by mean square error across all studies.",
+		GlobalTitle: "Global leaderboard across all studies",
+		Global: func(bundles ReferenceBundles, opts AnalysisOptions) (string, error) {
+			board, err := bundles.leaderboard(opts.Decimals, func(bundle *ReferenceBundle) (map[ScoreType]float64, error) {
+				hash, err := bundleHash(bundle, opts)
+				if err != nil {
+					return nil, err
+				}
+				b, err := cached(opts, func() ([]byte, error) {
+					scores, err := bundle.qualityScores(opts)
+					if err != nil {
+						return nil, err
+					}
+					return json.Marshal(scores)
+				}, hash, "quality", registeredMetricProfiles(), opts.CorrelationGroup, opts.CorrelationAggregation)
+				if err != nil {
+					return nil, err
+				}
+				scores := map[ScoreType]float64{}
+				return scores, json.Unmarshal(b, &scores)
+			})
+			if err != nil {
+				return "", err
+			}
+			return board.Render(opts.Format) + bundles.Coverage().partialCoverageNote(opts.Format), nil
+		},
+	})
+	RegisterAnalysis(&Analysis{
+		Name:        "stats",
+		Description: "Number of references and distortions, and the range of each score type, per study.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			return bundle.Stats().Render(opts.Format), nil
+		},
+	})
+	RegisterAnalysis(&Analysis{
+		Name:        "outliers",
+		Description: "Distortions where each score type disagrees the most with MOS, per MOS study.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			if _, found := bundle.ScoreTypes[MOS]; !found {
+				return "", nil
+			}
+			outliers, err := bundle.Outliers(numOutliers)
+			if err != nil {
+				return "", err
+			}
+			return outliers.Render(opts.Format, opts.Decimals), nil
+		},
+	})
+}
+
+// ScoreStats contains statistics about the scores of a score type.
+type ScoreStats struct {
+	ScoreType ScoreType
+	Count     int
+	Min       float64
+	Max       float64
+	Mean      float64
+}
+
+// BundleStats contains statistics about a bundle.
+type BundleStats struct {
+	References  int
+	Distortions int
+	Scores      []ScoreStats
+}
+
+// Stats returns statistics about the bundle.
+func (r *ReferenceBundle) Stats() *BundleStats {
+	result := &BundleStats{
+		References: len(r.References),
+	}
+	for _, scoreType := range r.analyzedTypes() {
+		stats := ScoreStats{
+			ScoreType: scoreType,
+			Min:       math.Inf(1),
+			Max:       math.Inf(-1),
+		}
+		sum := 0.0
+		for _, ref := range r.References {
+			for _, dist := range ref.Distortions {
+				if score, found := dist.Scores[scoreType]; found {
+					stats.Count++
+					stats.Min = math.Min(stats.Min, score)
+					stats.Max = math.Max(stats.Max, score)
+					sum += score
+				}
+			}
+		}
+		if stats.Count > 0 {
+			stats.Mean = sum / float64(stats.Count)
+		}
+		result.Scores = append(result.Scores, stats)
+	}
+	for _, ref := range r.References {
+		result.Distortions += len(ref.Distortions)
+	}
+	return result
+}
+
+// Render returns a representation of the stats in the format.
+func (b *BundleStats) Render(format Format) string {
+	table := Table{Row{"Score type", "Count", "Min", "Max", "Mean"}, nil}
+	for _, stats := range b.Scores {
+		table = append(table, Row{string(stats.ScoreType), fmt.Sprint(stats.Count), stats.ScoreType.FormatScore(stats.Min), stats.ScoreType.FormatScore(stats.Max), stats.ScoreType.FormatScore(stats.Mean)})
+	}
+	return fmt.Sprintf("%s%s%s", format.Heading(3, "Statistics"), format.Paragraph(fmt.Sprintf("%v references, %v distortions", b.References, b.Distortions)), table.Render(format))
+}
+
+// numOutliers is the number of outliers per score type shown by the outliers analysis.
+const numOutliers = 5
+
+// Outlier is a distortion where a score type disagrees with MOS.
+type Outlier struct {
+	ScoreType  ScoreType
+	Reference  string
+	Distortion string
+	MOS        float64
+	Score      float64
+	// RankDifference is the difference between the normalized ranks, between 0 and 1, of the MOS and the score.
+	RankDifference float64
+}
+
+// Outliers contains outliers for multiple score types.
+type Outliers []Outlier
+
+// normalizedRanks returns the ranks of the values, normalized to be between 0 and 1.
+func normalizedRanks(values []float64) []float64 {
+	indices := make([]int, len(values))
+	for index := range indices {
+		indices[index] = index
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return values[indices[i]] < values[indices[j]]
+	})
+	result := make([]float64, len(values))
+	for rank, index := range indices {
+		if len(values) > 1 {
+			result[index] = float64(rank) / float64(len(values)-1)
+		}
+	}
+	return result
+}
+
+// Outliers returns, for each score type except MOS, the n distortions where the rank of the score differs the most from the rank of the MOS.
+//
+// Score types negatively correlated with MOS have their ranks inverted.
+func (r *ReferenceBundle) Outliers(n int) (Outliers, error) {
+	result := Outliers{}
+	for _, scoreType := range r.analyzedTypes() {
+		if scoreType == MOS {
+			continue
+		}
+		candidates := Outliers{}
+		mosScores := []float64{}
+		scores := []float64{}
+		for _, ref := range r.References {
+			for _, dist := range ref.Distortions {
+				mos, mosFound := dist.Scores[MOS]
+				score, scoreFound := dist.Scores[scoreType]
+				if !mosFound || !scoreFound {
+					continue
+				}
+				candidates = append(candidates, Outlier{
+					ScoreType:  scoreType,
+					Reference:  ref.Name,
+					Distortion: dist.Name,
+					MOS:        mos,
+					Score:      score,
+				})
+				mosScores = append(mosScores, mos)
+				scores = append(scores, score)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		mosRanks := normalizedRanks(mosScores)
+		scoreRanks := normalizedRanks(scores)
+		if correlation, _ := onlinestats.Spearman(mosScores, scores); correlation < 0 {
+			for index := range scoreRanks {
+				scoreRanks[index] = 1 - scoreRanks[index]
+			}
+		}
+		for index := range candidates {
+			candidates[index].RankDifference = math.Abs(mosRanks[index] - scoreRanks[index])
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].RankDifference > candidates[j].RankDifference
+		})
+		result = append(result, candidates[:min(n, len(candidates))]...)
+	}
+	return result, nil
+}
+
+// Render returns a representation of the outliers in the format.
+func (o Outliers) Render(format Format, decimals int) string {
+	precisionString := fmt.Sprintf("%%.%df", decimals)
+	table := Table{Row{"Score type", "Reference", "Distortion", "MOS", "Score", "Rank difference"}, nil}
+	for _, outlier := range o {
+		table = append(table, Row{string(outlier.ScoreType), outlier.Reference, outlier.Distortion, MOS.FormatScore(outlier.MOS), outlier.ScoreType.FormatScore(outlier.Score), fmt.Sprintf(precisionString, outlier.RankDifference)})
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, "Distortions where the score types disagree the most with MOS"), table.Render(format))
+}
+
+// AnalysisHelp returns a description of all registered analyses, suitable for flag help texts.
+func AnalysisHelp() string {
+	lines := []string{}
+	for _, analysis := range Analyses() {
+		lines = append(lines, fmt.Sprintf("%s: %s", analysis.Name, analysis.Description))
+	}
+	return strings.Join(lines, "\n")
+}
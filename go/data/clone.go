@@ -0,0 +1,173 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/progress"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// TranscodeStage is the stage of the progress of CloneTranscoded counting the transcoded audio files.
+const TranscodeStage = "transcode"
+
+// externalDir is the directory inside cloned studies where audio from outside the source study directory is stored.
+const externalDir = "external"
+
+// TranscodeFormat is the uniform format CloneTranscoded stores audio in.
+type TranscodeFormat struct {
+	// Rate is the sample rate of the stored audio.
+	Rate int
+	// Mono makes the stored audio the mean of the channels.
+	Mono bool
+	// Extension is the extension of the stored files, which defines their encoding, see aio.Save, e.g. .wav for
+	// 16 bit PCM WAV files, or .flac.
+	Extension string
+}
+
+// clonePaths returns the paths in a cloned study of the audio paths of a source study, keeping paths inside the
+// source study directory, and storing other audio in externalDir, with the extension of the format.
+func clonePaths(paths []string, format TranscodeFormat) map[string]string {
+	result := map[string]string{}
+	used := map[string]bool{}
+	for _, source := range paths {
+		if _, found := result[source]; found {
+			continue
+		}
+		local := filepath.ToSlash(source)
+		if aio.IsRemote(source) || filepath.IsAbs(source) || local == ".." || strings.HasPrefix(local, "../") {
+			base := path.Base(local)
+			if aio.IsRemote(source) {
+				base = path.Base(strings.SplitN(strings.SplitN(source, "?", 2)[0], "#", 2)[0])
+			}
+			local = path.Join(externalDir, base)
+		}
+		local = strings.TrimSuffix(path.Clean(local), path.Ext(local))
+		destination := local + format.Extension
+		for index := 2; used[destination]; index++ {
+			destination = fmt.Sprintf("%s_%d%s", local, index, format.Extension)
+		}
+		used[destination] = true
+		result[source] = filepath.FromSlash(destination)
+	}
+	return result
+}
+
+// CloneTranscoded replaces the entire content of the destination study with the content of the study, like Dump
+// and Restore, with all audio transcoded to the format and stored in the destination study directory.
+//
+// Audio inside the study directory keeps its path relative to it, except the extension, while other audio is stored
+// in the external directory of the destination. Audio files used more than once, e.g. hidden references, are
+// transcoded once and keep referring to the same file.
+//
+// Only the scores of listeners, i.e. MOS, JND, and Preference, are copied, since metric scores, their history, and
+// their compute times were measured on the original audio and must be recalculated on the transcoded audio.
+//
+// The audio is transcoded in pool. Stages, if set, gets the progress of the TranscodeStage, ParseStage, and
+// PersistStage stages.
+func (s *Study) CloneTranscoded(destination *Study, format TranscodeFormat, pool *worker.Pool[any], stages *progress.Stages) error {
+	if format.Rate <= 0 || !strings.HasPrefix(format.Extension, ".") {
+		return fmt.Errorf("invalid transcode format %+v", format)
+	}
+	dump := &bytes.Buffer{}
+	if err := s.Dump(dump, nil); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(dump)
+	header := DumpHeader{}
+	if err := decoder.Decode(&header); err != nil {
+		return err
+	}
+	refs := []*Reference{}
+	sources := []string{}
+	for decoder.More() {
+		ref := &Reference{}
+		if err := decoder.Decode(ref); err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+		sources = append(sources, ref.Path)
+		for _, dist := range ref.Distortions {
+			sources = append(sources, dist.Path)
+			sources = append(sources, dist.AlternativeReferences...)
+		}
+	}
+	paths := clonePaths(sources, format)
+
+	pool.OnChange = func(submitted, completed, errors int) {
+		stages.Update(TranscodeStage, submitted, completed, errors)
+	}
+	for loopSource, loopDestination := range paths {
+		source, destinationPath := loopSource, filepath.Join(destination.Dir(), loopDestination)
+		pool.SubmitDescribed(fmt.Sprintf("transcoding %q to %q", source, destinationPath), func(func(any)) error {
+			a, err := aio.LoadAtRate(Resolve(s.dir, source), format.Rate)
+			if err != nil {
+				return err
+			}
+			if format.Mono {
+				a = a.Mono()
+			}
+			if err := os.MkdirAll(filepath.Dir(destinationPath), 0755); err != nil {
+				return err
+			}
+			return aio.Save(a, destinationPath)
+		})
+	}
+	if err := pool.Error(); err != nil {
+		return err
+	}
+
+	cloned := &bytes.Buffer{}
+	encoder := json.NewEncoder(cloned)
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		ref.Path = paths[ref.Path]
+		for _, dist := range ref.Distortions {
+			dist.Path = paths[dist.Path]
+			for scoreType := range dist.Scores {
+				if !isHumanScoreType(scoreType) {
+					delete(dist.Scores, scoreType)
+				}
+			}
+			for scoreType := range dist.History {
+				if !isHumanScoreType(scoreType) {
+					delete(dist.History, scoreType)
+				}
+			}
+			for scoreType := range dist.ComputeTimes {
+				if !isHumanScoreType(scoreType) {
+					delete(dist.ComputeTimes, scoreType)
+				}
+			}
+			for index, alternative := range dist.AlternativeReferences {
+				dist.AlternativeReferences[index] = paths[alternative]
+			}
+		}
+		if err := encoder.Encode(ref); err != nil {
+			return err
+		}
+	}
+	return destination.Restore(cloned, stages)
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+func TestClonePaths(t *testing.T) {
+	paths := clonePaths([]string{"ref.flac", "sub/dist.mp3", "ref.flac", "ref.wav", "/elsewhere/dist.ogg", "../sibling/dist.ogg", "https://example.com/audio/remote.mp3?raw=1"}, TranscodeFormat{Extension: ".wav"})
+	want := map[string]string{
+		"ref.flac":            "ref.wav",
+		"sub/dist.mp3":        filepath.Join("sub", "dist.wav"),
+		"ref.wav":             "ref_2.wav",
+		"/elsewhere/dist.ogg": filepath.Join("external", "dist.wav"),
+		"../sibling/dist.ogg": filepath.Join("external", "dist_2.wav"),
+		"https://example.com/audio/remote.mp3?raw=1": filepath.Join("external", "remote.wav"),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("clonePaths() = %v, want %v", paths, want)
+	}
+}
+
+func TestCloneTranscoded(t *testing.T) {
+	bundle, restore := levelBundle(t, 2)
+	defer restore()
+	ref := bundle.References[0]
+	hidden := &Distortion{Name: "hidden", Path: ref.Path, Scores: map[ScoreType]float64{MOS: 5}, AlternativeReferences: []string{"dist1.wav"}}
+	ref.Distortions = append(ref.Distortions, hidden)
+	metric := ref.Distortions[0]
+	metric.Scores[MOS], metric.Scores[Zimtohrli] = 3, 0.1
+	metric.ComputeTimes = map[ScoreType]goohrli.Duration{Zimtohrli: {Duration: time.Second}}
+	source, err := OpenStudy(bundle.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	if err := source.Put([]*Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	destination, err := OpenStudy(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destination.Close()
+	if err := source.CloneTranscoded(destination, TranscodeFormat{Rate: 48000, Mono: true, Extension: ".wav"}, &worker.Pool[any]{Workers: 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	cloned := references(t, destination)
+	// The metric scores were measured on the original audio, so only the listener scores are copied.
+	delete(metric.Scores, Zimtohrli)
+	metric.ComputeTimes = nil
+	if len(cloned) != 1 || !reflect.DeepEqual(cloned[0], ref) {
+		t.Errorf("cloned references = %+v, want %+v", cloned, ref)
+	}
+	for _, path := range []string{"ref.wav", "dist0.wav", "dist1.wav"} {
+		a, err := aio.Load(filepath.Join(destination.Dir(), path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(a.Samples) != 1 || a.Rate != 48000 {
+			t.Errorf("%v has %v channels at %v Hz, want 1 channel at 48000 Hz", path, len(a.Samples), a.Rate)
+		}
+	}
+}
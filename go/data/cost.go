@@ -0,0 +1,136 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ComputeCost contains statistics about the compute times of the measurements of a score type.
+type ComputeCost struct {
+	ScoreType ScoreType
+	Count     int
+	Total     time.Duration
+	Mean      time.Duration
+	Median    time.Duration
+	Max       time.Duration
+	// Slowest identifies the distortion with the max compute time.
+	Slowest string
+}
+
+// ComputeCosts contains the compute costs of multiple score types.
+type ComputeCosts []ComputeCost
+
+// computeCosts returns the compute costs of the score types with compute times in the bundles, ordered by score type.
+//
+// If withDir is set the slowest distortions are identified by study as well as by reference and distortion.
+func computeCosts(bundles ReferenceBundles, withDir bool) ComputeCosts {
+	times := map[ScoreType][]time.Duration{}
+	result := map[ScoreType]*ComputeCost{}
+	for _, bundle := range bundles {
+		for _, ref := range bundle.References {
+			for _, dist := range ref.Distortions {
+				for scoreType, computeTime := range dist.ComputeTimes {
+					cost, found := result[scoreType]
+					if !found {
+						cost = &ComputeCost{ScoreType: scoreType}
+						result[scoreType] = cost
+					}
+					times[scoreType] = append(times[scoreType], computeTime.Duration)
+					cost.Count++
+					cost.Total += computeTime.Duration
+					if computeTime.Duration >= cost.Max {
+						cost.Max = computeTime.Duration
+						cost.Slowest = fmt.Sprintf("%s/%s", ref.Name, dist.Name)
+						if withDir {
+							cost.Slowest = fmt.Sprintf("%s: %s", filepath.Base(bundle.Dir), cost.Slowest)
+						}
+					}
+				}
+			}
+		}
+	}
+	costs := ComputeCosts{}
+	for _, scoreType := range sortedScoreTypes(result) {
+		cost := result[scoreType]
+		cost.Mean = cost.Total / time.Duration(cost.Count)
+		sorted := times[scoreType]
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+		cost.Median = sorted[len(sorted)/2]
+		costs = append(costs, *cost)
+	}
+	return costs
+}
+
+// ComputeCosts returns the compute costs of the score types with compute times in the bundle.
+func (r *ReferenceBundle) ComputeCosts() ComputeCosts {
+	return computeCosts(ReferenceBundles{r}, false)
+}
+
+// ComputeCosts returns the compute costs of the score types with compute times in all bundles.
+func (r ReferenceBundles) ComputeCosts() ComputeCosts {
+	return computeCosts(r, true)
+}
+
+// Total returns the sum of the compute times of all score types.
+func (c ComputeCosts) Total() time.Duration {
+	result := time.Duration(0)
+	for _, cost := range c {
+		result += cost.Total
+	}
+	return result
+}
+
+// Render returns a representation of the compute costs in the format.
+//
+// The max compute time is also shown relative to the median, to make pathological inputs stand out.
+func (c ComputeCosts) Render(format Format) string {
+	table := Table{Row{"Score type", "Count", "Total", "Mean", "Median", "Max", "Max/median", "Slowest"}, nil}
+	for _, cost := range c {
+		ratio := "-"
+		if cost.Median > 0 {
+			ratio = fmt.Sprintf("%.1f", float64(cost.Max)/float64(cost.Median))
+		}
+		table = append(table, Row{string(cost.ScoreType), fmt.Sprint(cost.Count), cost.Total.Round(time.Millisecond).String(), cost.Mean.Round(time.Millisecond).String(), cost.Median.Round(time.Millisecond).String(), cost.Max.Round(time.Millisecond).String(), ratio, cost.Slowest})
+	}
+	return fmt.Sprintf("%s%s%s", format.Heading(3, "Compute cost"), format.Paragraph(fmt.Sprintf("%v total compute time", c.Total().Round(time.Millisecond))), table.Render(format))
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "cost",
+		Description: "Total, mean, median, and max compute time of each score type, per study and across all studies.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			costs := bundle.ComputeCosts()
+			if len(costs) == 0 {
+				return "", nil
+			}
+			return costs.Render(opts.Format), nil
+		},
+		GlobalTitle: "Compute cost across all studies",
+		Global: func(bundles ReferenceBundles, opts AnalysisOptions) (string, error) {
+			costs := bundles.ComputeCosts()
+			if len(costs) == 0 {
+				return opts.Format.Paragraph("No compute times recorded."), nil
+			}
+			return costs.Render(opts.Format), nil
+		},
+	})
+}
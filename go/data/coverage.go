@@ -0,0 +1,145 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isHumanScoreType returns whether the score type contains evaluations by listeners rather than a metric.
+func isHumanScoreType(scoreType ScoreType) bool {
+	return scoreType == MOS || scoreType == JND || scoreType == Preference
+}
+
+// WithScoreTypes returns a copy of the bundle where the distortions only have scores of the given score types, and
+// of the score types with evaluations by listeners, i.e. MOS, JND, and Preference.
+func (r *ReferenceBundle) WithScoreTypes(scoreTypes []ScoreType) *ReferenceBundle {
+	keep := map[ScoreType]bool{}
+	for _, scoreType := range scoreTypes {
+		keep[scoreType] = true
+	}
+	result := &ReferenceBundle{
+		Dir:        r.Dir,
+		ScoreTypes: map[ScoreType]int{},
+	}
+	for _, ref := range r.References {
+		newRef := &Reference{Name: ref.Name, Path: ref.Path}
+		for _, dist := range ref.Distortions {
+			newDist := *dist
+			newDist.Scores = map[ScoreType]float64{}
+			for scoreType, score := range dist.Scores {
+				if keep[scoreType] || isHumanScoreType(scoreType) {
+					newDist.Scores[scoreType] = score
+				}
+			}
+			newRef.Distortions = append(newRef.Distortions, &newDist)
+		}
+		result.Add(newRef)
+	}
+	return result
+}
+
+// Coverage is the number of distortions with scores of a score type.
+type Coverage struct {
+	ScoreType ScoreType
+	// Studies is the number of studies with any scores of the score type.
+	Studies     int
+	Distortions int
+}
+
+// Coverages contains the coverage of all score types in a set of studies.
+type Coverages struct {
+	Studies     int
+	Distortions int
+	ScoreTypes  []Coverage
+}
+
+// Coverage returns the coverage of each score type in the bundles, ordered by score type.
+func (r ReferenceBundles) Coverage() *Coverages {
+	result := &Coverages{Studies: len(r)}
+	coverages := map[ScoreType]*Coverage{}
+	for _, bundle := range r {
+		for _, ref := range bundle.References {
+			result.Distortions += len(ref.Distortions)
+		}
+		for scoreType, count := range bundle.ScoreTypes {
+			coverage, found := coverages[scoreType]
+			if !found {
+				coverage = &Coverage{ScoreType: scoreType}
+				coverages[scoreType] = coverage
+			}
+			coverage.Studies++
+			coverage.Distortions += count
+		}
+	}
+	for _, scoreType := range sortedScoreTypes(coverages) {
+		result.ScoreTypes = append(result.ScoreTypes, *coverages[scoreType])
+	}
+	return result
+}
+
+// Partial returns the score types that are missing from some of the studies or distortions.
+func (c *Coverages) Partial() []Coverage {
+	result := []Coverage{}
+	for _, coverage := range c.ScoreTypes {
+		if coverage.Studies < c.Studies || coverage.Distortions < c.Distortions {
+			result = append(result, coverage)
+		}
+	}
+	return result
+}
+
+// Render returns a representation of the coverages in the format.
+func (c *Coverages) Render(format Format) string {
+	table := Table{Row{"Score type", "Studies", "Distortions", "Coverage"}, nil}
+	for _, coverage := range c.ScoreTypes {
+		percent := 0.0
+		if c.Distortions > 0 {
+			percent = 100 * float64(coverage.Distortions) / float64(c.Distortions)
+		}
+		table = append(table, Row{string(coverage.ScoreType), fmt.Sprintf("%v/%v", coverage.Studies, c.Studies), fmt.Sprintf("%v/%v", coverage.Distortions, c.Distortions), fmt.Sprintf("%.1f%%", percent)})
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, "Number of studies and distortions with scores of each score type"), table.Render(format))
+}
+
+// partialCoverageNote returns a paragraph listing the metric score types the leaderboard excludes because they
+// aren't in all studies, or an empty string if there are none.
+func (c *Coverages) partialCoverageNote(format Format) string {
+	excluded := []string{}
+	for _, coverage := range c.Partial() {
+		if coverage.Studies < c.Studies && !isHumanScoreType(coverage.ScoreType) {
+			excluded = append(excluded, fmt.Sprintf("%s (%v of %v studies)", coverage.ScoreType, coverage.Studies, c.Studies))
+		}
+	}
+	if len(excluded) == 0 {
+		return ""
+	}
+	return format.Paragraph(fmt.Sprintf("Excluded score types missing from some studies: %s", strings.Join(excluded, ", ")))
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "coverage",
+		Description: "Number of studies and distortions with scores of each score type, per study and across all studies.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			return ReferenceBundles{bundle}.Coverage().Render(opts.Format), nil
+		},
+		GlobalTitle: "Coverage across all studies",
+		Global: func(bundles ReferenceBundles, opts AnalysisOptions) (string, error) {
+			return bundles.Coverage().Render(opts.Format), nil
+		},
+	})
+}
@@ -0,0 +1,231 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// Duplicate is a pair of identical or near identical references, or distortions of the same reference.
+type Duplicate struct {
+	// Reference is the name of the reference A and B are distortions of, or empty if A and B are references.
+	Reference string
+	A         string
+	B         string
+	// Distance is the Zimtohrli distance between A and B, or 0 if the files are identical.
+	Distance float64
+}
+
+// Duplicates is a slice of duplicates.
+type Duplicates []Duplicate
+
+// Render returns a representation of the duplicates in the format.
+func (d Duplicates) Render(format Format) string {
+	table := Table{Row{"Reference", "A", "B", "Distance"}, nil}
+	for _, dup := range d {
+		table = append(table, Row{dup.Reference, dup.A, dup.B, Zimtohrli.FormatScore(dup.Distance)})
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, "Duplicated references and distortions"), table.Render(format))
+}
+
+func fileHash(path string) (string, error) {
+	path, err := aio.Localize(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// FindDuplicates returns the references with identical files, and the distortions of each reference with identical files,
+// using the pool to hash and compare files concurrently.
+//
+// If z is not nil, distortions of the same reference with a Zimtohrli distance at most threshold are also returned.
+func (r *ReferenceBundle) FindDuplicates(z *goohrli.Goohrli, threshold float64, pool *worker.Pool[any]) (Duplicates, error) {
+	lock := sync.Mutex{}
+	result := Duplicates{}
+	add := func(dup Duplicate) {
+		lock.Lock()
+		defer lock.Unlock()
+		result = append(result, dup)
+	}
+
+	refHashes := make([]string, len(r.References))
+	for loopRefIndex := range r.References {
+		refIndex := loopRefIndex
+		pool.Submit(func(func(any)) error {
+			var err error
+			refHashes[refIndex], err = fileHash(Resolve(r.Dir, r.References[refIndex].Path))
+			return err
+		})
+	}
+	for _, loopRef := range r.References {
+		ref := loopRef
+		pool.Submit(func(func(any)) error {
+			distHashes := make([]string, len(ref.Distortions))
+			for distIndex, dist := range ref.Distortions {
+				var err error
+				if distHashes[distIndex], err = fileHash(Resolve(r.Dir, dist.Path)); err != nil {
+					return err
+				}
+			}
+			distAudios := make([]*audio.Audio, len(ref.Distortions))
+			for indexA, distA := range ref.Distortions {
+				for indexB := indexA + 1; indexB < len(ref.Distortions); indexB++ {
+					distB := ref.Distortions[indexB]
+					if distHashes[indexA] == distHashes[indexB] {
+						add(Duplicate{Reference: ref.Name, A: distA.Name, B: distB.Name})
+						continue
+					}
+					if z == nil {
+						continue
+					}
+					for _, index := range []int{indexA, indexB} {
+						if distAudios[index] == nil {
+							var err error
+							if distAudios[index], err = ref.Distortions[index].Load(r.Dir); err != nil {
+								return err
+							}
+						}
+					}
+					// NormalizedAudioDistance normalizes the amplitude of B, so it gets a copy.
+					distance, err := z.NormalizedAudioDistance(distAudios[indexA], distAudios[indexB].Clone())
+					if err != nil {
+						return err
+					}
+					if distance <= threshold {
+						add(Duplicate{Reference: ref.Name, A: distA.Name, B: distB.Name, Distance: distance})
+					}
+				}
+			}
+			return nil
+		})
+	}
+	if err := pool.Error(); err != nil {
+		return nil, err
+	}
+	for indexA, refA := range r.References {
+		for indexB := indexA + 1; indexB < len(r.References); indexB++ {
+			if refHashes[indexA] == refHashes[indexB] {
+				result = append(result, Duplicate{A: refA.Name, B: r.References[indexB].Name})
+			}
+		}
+	}
+	// Sort by reference order, with duplicated references last, to make the output deterministic.
+	refIndices := map[string]int{"": len(r.References)}
+	for refIndex, ref := range r.References {
+		refIndices[ref.Name] = refIndex
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if refIndices[result[i].Reference] != refIndices[result[j].Reference] {
+			return refIndices[result[i].Reference] < refIndices[result[j].Reference]
+		}
+		if result[i].A != result[j].A {
+			return result[i].A < result[j].A
+		}
+		return result[i].B < result[j].B
+	})
+	return result, nil
+}
+
+// MergeDuplicates replaces each group of duplicated distortions of the same reference with the first distortion
+// of the group, with each score replaced by the mean score of the group, and returns the number of removed distortions.
+//
+// Duplicated references are not merged, since their distortions are usually different.
+func (r *ReferenceBundle) MergeDuplicates(dups Duplicates) int {
+	removed := 0
+	for _, ref := range r.References {
+		// parents is a union-find structure over the distortion names of this reference.
+		parents := map[string]string{}
+		var find func(string) string
+		find = func(name string) string {
+			parent, found := parents[name]
+			if !found || parent == name {
+				return name
+			}
+			root := find(parent)
+			parents[name] = root
+			return root
+		}
+		order := map[string]int{}
+		for distIndex, dist := range ref.Distortions {
+			order[dist.Name] = distIndex
+		}
+		for _, dup := range dups {
+			if dup.Reference != ref.Name {
+				continue
+			}
+			rootA, rootB := find(dup.A), find(dup.B)
+			if rootA == rootB {
+				continue
+			}
+			if order[rootB] < order[rootA] {
+				rootA, rootB = rootB, rootA
+			}
+			parents[rootB] = rootA
+		}
+		groups := map[string][]*Distortion{}
+		for _, dist := range ref.Distortions {
+			root := find(dist.Name)
+			groups[root] = append(groups[root], dist)
+		}
+		kept := []*Distortion{}
+		for _, dist := range ref.Distortions {
+			group := groups[dist.Name]
+			if find(dist.Name) != dist.Name {
+				removed++
+				continue
+			}
+			if len(group) > 1 {
+				if dist.Scores == nil {
+					dist.Scores = map[ScoreType]float64{}
+				}
+				sums := map[ScoreType]float64{}
+				counts := map[ScoreType]int{}
+				for _, member := range group {
+					for scoreType, score := range member.Scores {
+						sums[scoreType] += score
+						counts[scoreType]++
+					}
+				}
+				for scoreType, sum := range sums {
+					dist.Scores[scoreType] = sum / float64(counts[scoreType])
+				}
+			}
+			kept = append(kept, dist)
+		}
+		ref.Distortions = kept
+	}
+	return removed
+}
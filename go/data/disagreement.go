@@ -0,0 +1,140 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/dgryski/go-onlinestats"
+)
+
+// numDisagreements is the number of disagreements per pair of score types shown by the disagreements analysis.
+const numDisagreements = 5
+
+// Disagreement is a distortion where two score types disagree.
+type Disagreement struct {
+	ScoreTypeA     ScoreType
+	ScoreTypeB     ScoreType
+	Reference      string
+	Distortion     string
+	ReferencePath  string
+	DistortionPath string
+	ScoreA         float64
+	ScoreB         float64
+	// RankDifference is the difference between the normalized ranks, between 0 and 1, of the scores.
+	RankDifference float64
+}
+
+// Disagreements contains disagreements for multiple pairs of score types.
+type Disagreements []Disagreement
+
+// Disagreements returns the n distortions where the ranks of the scores of the two score types differ the most.
+//
+// Score types negatively correlated with each other have the ranks of scoreTypeB inverted. The paths are
+// resolved relative to the directory of the bundle, to make it easy to listen to the distortions.
+func (r *ReferenceBundle) Disagreements(scoreTypeA, scoreTypeB ScoreType, n int) (Disagreements, error) {
+	candidates := Disagreements{}
+	scoresA := []float64{}
+	scoresB := []float64{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			scoreA, foundA := dist.Scores[scoreTypeA]
+			scoreB, foundB := dist.Scores[scoreTypeB]
+			if !foundA || !foundB {
+				continue
+			}
+			candidates = append(candidates, Disagreement{
+				ScoreTypeA:     scoreTypeA,
+				ScoreTypeB:     scoreTypeB,
+				Reference:      ref.Name,
+				Distortion:     dist.Name,
+				ReferencePath:  Resolve(r.Dir, ref.Path),
+				DistortionPath: Resolve(r.Dir, dist.Path),
+				ScoreA:         scoreA,
+				ScoreB:         scoreB,
+			})
+			scoresA = append(scoresA, scoreA)
+			scoresB = append(scoresB, scoreB)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	ranksA := normalizedRanks(scoresA)
+	ranksB := normalizedRanks(scoresB)
+	if correlation, _ := onlinestats.Spearman(scoresA, scoresB); correlation < 0 {
+		for index := range ranksB {
+			ranksB[index] = 1 - ranksB[index]
+		}
+	}
+	for index := range candidates {
+		candidates[index].RankDifference = math.Abs(ranksA[index] - ranksB[index])
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].RankDifference > candidates[j].RankDifference
+	})
+	return candidates[:min(n, len(candidates))], nil
+}
+
+// MetricDisagreements returns the n distortions where the ranks differ the most for each pair of metric score
+// types, i.e. score types other than MOS, JND, and the auxiliary score types, ordered by score types.
+func (r *ReferenceBundle) MetricDisagreements(n int) (Disagreements, error) {
+	metrics := ScoreTypes{}
+	for _, scoreType := range r.analyzedTypes() {
+		if scoreType != MOS && scoreType != JND {
+			metrics = append(metrics, scoreType)
+		}
+	}
+	result := Disagreements{}
+	for indexA, scoreTypeA := range metrics {
+		for _, scoreTypeB := range metrics[indexA+1:] {
+			disagreements, err := r.Disagreements(scoreTypeA, scoreTypeB, n)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, disagreements...)
+		}
+	}
+	return result, nil
+}
+
+// Render returns a representation of the disagreements in the format.
+func (d Disagreements) Render(format Format, decimals int) string {
+	precisionString := fmt.Sprintf("%%.%df", decimals)
+	table := Table{Row{"Score type A", "Score type B", "Reference", "Distortion", "Score A", "Score B", "Rank difference", "Reference path", "Distortion path"}, nil}
+	for _, disagreement := range d {
+		table = append(table, Row{string(disagreement.ScoreTypeA), string(disagreement.ScoreTypeB), disagreement.Reference, disagreement.Distortion, disagreement.ScoreTypeA.FormatScore(disagreement.ScoreA), disagreement.ScoreTypeB.FormatScore(disagreement.ScoreB), fmt.Sprintf(precisionString, disagreement.RankDifference), disagreement.ReferencePath, disagreement.DistortionPath})
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, "Distortions where the metrics disagree the most with each other"), table.Render(format))
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "disagreements",
+		Description: "Distortions where each pair of metrics, e.g. Zimtohrli and ViSQOL, disagree the most in rank, with paths for listening, per study.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			disagreements, err := bundle.MetricDisagreements(numDisagreements)
+			if err != nil {
+				return "", err
+			}
+			if len(disagreements) == 0 {
+				return "", nil
+			}
+			return disagreements.Render(opts.Format, opts.Decimals), nil
+		},
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/zimtohrli/go/progress"
+)
+
+// dumpFormat identifies study dumps.
+const dumpFormat = "zimtohrli-study"
+
+const (
+	// DumpStage is the stage of the progress of Dump counting the dumped references.
+	DumpStage = "dump"
+	// ParseStage is the stage of the progress of Restore counting the parsed references.
+	ParseStage = "parse"
+)
+
+// DumpHeader is the first line of a study dump.
+type DumpHeader struct {
+	Format string
+	// Version is the schema version of the dumped study.
+	Version int
+	// Metadata is the content of the METADATA table, except the schema version and the last writer, which would
+	// make dumps of the same content differ.
+	Metadata map[string]string
+}
+
+// Dump writes the entire content of the study as JSON lines to w: a DumpHeader, followed by one Reference per
+// line ordered by name.
+//
+// Map keys are sorted, so dumps of studies with the same content are identical, and dumps of similar studies
+// differ only in the lines of the changed references.
+//
+// Stages, if set, gets the progress of the DumpStage stage.
+func (s *Study) Dump(w io.Writer, stages *progress.Stages) error {
+	header := DumpHeader{
+		Format:   dumpFormat,
+		Version:  schemaVersion,
+		Metadata: map[string]string{},
+	}
+	rows, err := s.db.Query("SELECT KEY, VALUE FROM METADATA WHERE KEY NOT IN ('schema_version', 'last_writer')")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		header.Metadata[key] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if stages != nil {
+		var numReferences int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM REFERENCE").Scan(&numReferences); err != nil {
+			return err
+		}
+		stages.Add(DumpStage, numReferences, 0, 0)
+	}
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+	return s.ViewEachReference(func(ref *Reference) error {
+		if err := encoder.Encode(ref); err != nil {
+			stages.Add(DumpStage, 0, 0, 1)
+			return err
+		}
+		stages.Add(DumpStage, 0, 1, 0)
+		return nil
+	})
+}
+
+// Restore replaces the entire content of the study with a dump written by Dump.
+//
+// Stages, if set, gets the progress of the ParseStage and PersistStage stages.
+func (s *Study) Restore(r io.Reader, stages *progress.Stages) error {
+	decoder := json.NewDecoder(r)
+	header := DumpHeader{}
+	if err := decoder.Decode(&header); err != nil {
+		return fmt.Errorf("trying to parse dump header: %v", err)
+	}
+	if header.Format != dumpFormat {
+		return fmt.Errorf("dump format %q isn't %q", header.Format, dumpFormat)
+	}
+	if header.Version > schemaVersion {
+		return fmt.Errorf("dump is of schema version %v, newer than the supported version %v", header.Version, schemaVersion)
+	}
+	refs := []*Reference{}
+	for {
+		ref := &Reference{}
+		if err := decoder.Decode(ref); err == io.EOF {
+			break
+		} else if err != nil {
+			stages.Add(ParseStage, 1, 0, 1)
+			return fmt.Errorf("trying to parse reference %v of dump: %v", len(refs)+1, err)
+		}
+		stages.Add(ParseStage, 1, 1, 0)
+		refs = append(refs, ref)
+	}
+	stages.Add(PersistStage, len(refs), 0, 0)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := func() error {
+		for _, table := range dataTables {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM METADATA WHERE KEY != 'schema_version'"); err != nil {
+			return err
+		}
+		for key, value := range header.Metadata {
+			if _, err := tx.Exec("INSERT INTO METADATA (KEY, VALUE) VALUES (?, ?)", key, value); err != nil {
+				return err
+			}
+		}
+		if err := putReferences(tx, refs); err != nil {
+			return err
+		}
+		return recordWriter(tx)
+	}(); err != nil {
+		stages.Add(PersistStage, 0, 0, len(refs))
+		if rerr := tx.Rollback(); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		stages.Add(PersistStage, 0, 0, len(refs))
+		return err
+	}
+	stages.Add(PersistStage, 0, len(refs), 0)
+	return nil
+}
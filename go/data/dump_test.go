@@ -0,0 +1,91 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDumpAndRestore(t *testing.T) {
+	source, err := OpenStudy(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	refs := []*Reference{fullReference(), {Name: "another", Path: "another.wav", Distortions: []*Distortion{{Name: "d", Path: "d.wav", Scores: map[ScoreType]float64{JND: 0}}}}}
+	if err := source.Put(refs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.PutTranscripts(map[string]string{"ref": "the birch canoe"}); err != nil {
+		t.Fatal(err)
+	}
+	dump := &bytes.Buffer{}
+	if err := source.Dump(dump, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], dumpFormat) || !strings.Contains(lines[1], `"another"`) {
+		t.Errorf("dump = %q, want a header followed by the references ordered by name", lines)
+	}
+	// Dumps of the same content are identical.
+	again := &bytes.Buffer{}
+	if err := source.Dump(again, nil); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != dump.String() {
+		t.Errorf("second dump = %q, want %q", again, dump)
+	}
+
+	destination, err := OpenStudy(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destination.Close()
+	if err := destination.Put([]*Reference{{Name: "replaced", Path: "replaced.wav"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := destination.Restore(bytes.NewReader(dump.Bytes()), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := references(t, destination), references(t, source); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored references = %+v, want %+v", got, want)
+	}
+	if transcripts, err := destination.Transcripts(); err != nil || transcripts["ref"] != "the birch canoe" {
+		t.Errorf("restored transcripts = %v, %v, want the dumped transcripts", transcripts, err)
+	}
+	restoredDump := &bytes.Buffer{}
+	if err := destination.Dump(restoredDump, nil); err != nil {
+		t.Fatal(err)
+	}
+	if restoredDump.String() != dump.String() {
+		t.Errorf("dump of the restored study = %q, want %q", restoredDump, dump)
+	}
+
+	for _, invalid := range []string{
+		`{"Format": "something else", "Version": 1}`,
+		`{"Format": "zimtohrli-study", "Version": 1000}`,
+		`{"Format": "zimtohrli-study", "Version": 4}` + "\n{not json",
+	} {
+		if err := destination.Restore(strings.NewReader(invalid), nil); err == nil {
+			t.Errorf("restoring %q returned no error", invalid)
+		}
+	}
+	if got, want := references(t, destination), references(t, source); !reflect.DeepEqual(got, want) {
+		t.Errorf("failed restores modified the study to %+v", got)
+	}
+}
@@ -0,0 +1,519 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dgryski/go-onlinestats"
+)
+
+// Combiner defines how an ensemble combines the scores of multiple metrics into a predicted MOS.
+type Combiner string
+
+const (
+	// CombinerLinear predicts MOS with a least squares fit of a linear combination of the metrics.
+	CombinerLinear Combiner = "linear"
+	// CombinerIsotonic maps the linear combination of CombinerLinear to MOS with an isotonic, i.e. monotonically
+	// non-decreasing, least squares fit, to handle metrics that aren't linearly related to MOS.
+	CombinerIsotonic Combiner = "isotonic"
+)
+
+// Combiners are the valid combiners.
+var Combiners = []Combiner{CombinerLinear, CombinerIsotonic}
+
+// ensembleFolds is the number of folds, of whole references, the ensemble analysis cross validates with.
+const ensembleFolds = 5
+
+// ensembleRidge is the ridge regularization of the standardized weights of linear fits, to keep fits of
+// collinear metrics well defined.
+const ensembleRidge = 1e-6
+
+// errTooFewReferences is returned when there are too few references to cross validate ensembles.
+var errTooFewReferences = errors.New("too few references to cross validate")
+
+// Ensemble predicts MOS from the scores of multiple metrics.
+type Ensemble struct {
+	Combiner Combiner
+	// Inputs are the score types of the metrics, in the order of Weights.
+	Inputs    ScoreTypes
+	Intercept float64
+	Weights   []float64
+	// CalibrationX and CalibrationY, for CombinerIsotonic, are the knots of the piecewise linear, non-decreasing,
+	// map from the linear combination to MOS.
+	CalibrationX []float64 `json:",omitempty"`
+	CalibrationY []float64 `json:",omitempty"`
+}
+
+// String returns the formula of the linear combination of the ensemble.
+func (e *Ensemble) String() string {
+	terms := []string{fmt.Sprintf("%.4g", e.Intercept)}
+	for index, scoreType := range e.Inputs {
+		terms = append(terms, fmt.Sprintf("%.4g * %s", e.Weights[index], scoreType))
+	}
+	formula := strings.Join(terms, " + ")
+	if e.Combiner == CombinerIsotonic {
+		return fmt.Sprintf("isotonic(%s)", formula)
+	}
+	return formula
+}
+
+// scoresOf returns the scores of the types of the distortion, and whether it has all of them.
+func scoresOf(dist *Distortion, scoreTypes ScoreTypes) ([]float64, bool) {
+	result := make([]float64, len(scoreTypes))
+	for index, scoreType := range scoreTypes {
+		score, found := dist.Scores[scoreType]
+		if !found {
+			return nil, false
+		}
+		result[index] = score
+	}
+	return result, true
+}
+
+// linear returns the linear combination of the features.
+func (e *Ensemble) linear(features []float64) float64 {
+	result := e.Intercept
+	for index, feature := range features {
+		result += e.Weights[index] * feature
+	}
+	return result
+}
+
+// predict returns the predicted MOS for the features.
+func (e *Ensemble) predict(features []float64) float64 {
+	x := e.linear(features)
+	if e.Combiner != CombinerIsotonic {
+		return x
+	}
+	knots := len(e.CalibrationX)
+	index := sort.SearchFloat64s(e.CalibrationX, x)
+	switch {
+	case index == 0:
+		return e.CalibrationY[0]
+	case index == knots:
+		return e.CalibrationY[knots-1]
+	}
+	x0, x1 := e.CalibrationX[index-1], e.CalibrationX[index]
+	y0, y1 := e.CalibrationY[index-1], e.CalibrationY[index]
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
+
+// Predict returns the predicted MOS of the distortion, and false if the distortion lacks some of the inputs.
+func (e *Ensemble) Predict(dist *Distortion) (float64, bool) {
+	features, found := scoresOf(dist, e.Inputs)
+	if !found {
+		return 0, false
+	}
+	return e.predict(features), true
+}
+
+// solve returns the solution x to a * x = b using Gaussian elimination with partial pivoting.
+func solve(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if a[pivot][col] == 0 {
+			return nil, fmt.Errorf("singular system")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < n; row++ {
+			factor := a[row][col] / a[col][col]
+			for k := col; k < n; k++ {
+				a[row][k] -= factor * a[col][k]
+			}
+			b[row] -= factor * b[col]
+		}
+	}
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, nil
+}
+
+// FitEnsemble returns an ensemble of the inputs fitted to predict the targets from the features, which contain
+// the scores of the inputs of each sample.
+func FitEnsemble(combiner Combiner, inputs ScoreTypes, features [][]float64, targets []float64) (*Ensemble, error) {
+	if !slices.Contains(Combiners, combiner) {
+		return nil, fmt.Errorf("unknown combiner %q, want one of %v", combiner, Combiners)
+	}
+	if len(features) <= len(inputs)+1 {
+		return nil, fmt.Errorf("%v samples are too few to fit an ensemble of %v", len(features), inputs)
+	}
+	// The inputs are standardized, so that the regularization treats them equally, and the system is well
+	// conditioned regardless of the scales of the metrics.
+	k := len(inputs)
+	means, deviations := make([]float64, k), make([]float64, k)
+	for input := range inputs {
+		for _, sample := range features {
+			means[input] += sample[input]
+		}
+		means[input] /= float64(len(features))
+		for _, sample := range features {
+			deviations[input] += (sample[input] - means[input]) * (sample[input] - means[input])
+		}
+		deviations[input] = math.Sqrt(deviations[input] / float64(len(features)))
+		if deviations[input] == 0 {
+			deviations[input] = 1
+		}
+	}
+	// a and b are the normal equations of the standardized inputs, with the intercept last.
+	a := make([][]float64, k+1)
+	for row := range a {
+		a[row] = make([]float64, k+1)
+	}
+	b := make([]float64, k+1)
+	row := make([]float64, k+1)
+	for sampleIndex, sample := range features {
+		for input := range inputs {
+			row[input] = (sample[input] - means[input]) / deviations[input]
+		}
+		row[k] = 1
+		for i := range row {
+			for j := range row {
+				a[i][j] += row[i] * row[j]
+			}
+			b[i] += row[i] * targets[sampleIndex]
+		}
+	}
+	for input := range inputs {
+		a[input][input] += ensembleRidge * float64(len(features))
+	}
+	solution, err := solve(a, b)
+	if err != nil {
+		return nil, fmt.Errorf("trying to fit ensemble of %v: %v", inputs, err)
+	}
+	result := &Ensemble{
+		Combiner:  combiner,
+		Inputs:    append(ScoreTypes{}, inputs...),
+		Intercept: solution[k],
+		Weights:   make([]float64, k),
+	}
+	for input := range inputs {
+		result.Weights[input] = solution[input] / deviations[input]
+		result.Intercept -= result.Weights[input] * means[input]
+	}
+	if combiner == CombinerIsotonic {
+		result.fitCalibration(features, targets)
+	}
+	return result, nil
+}
+
+// fitCalibration fits the isotonic map from the linear combination of the features to the targets using the pool
+// adjacent violators algorithm.
+func (e *Ensemble) fitCalibration(features [][]float64, targets []float64) {
+	type block struct {
+		sumX, sumY float64
+		count      int
+	}
+	indices := make([]int, len(features))
+	linear := make([]float64, len(features))
+	for index, sample := range features {
+		indices[index] = index
+		linear[index] = e.linear(sample)
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return linear[indices[i]] < linear[indices[j]]
+	})
+	blocks := []block{}
+	for _, index := range indices {
+		blocks = append(blocks, block{sumX: linear[index], sumY: targets[index], count: 1})
+		for len(blocks) > 1 {
+			last, previous := blocks[len(blocks)-1], blocks[len(blocks)-2]
+			// Blocks with the same linear combination are merged as well, to keep the knots strictly increasing.
+			if previous.sumY/float64(previous.count) < last.sumY/float64(last.count) && previous.sumX/float64(previous.count) < last.sumX/float64(last.count) {
+				break
+			}
+			blocks = append(blocks[:len(blocks)-2], block{sumX: previous.sumX + last.sumX, sumY: previous.sumY + last.sumY, count: previous.count + last.count})
+		}
+	}
+	e.CalibrationX, e.CalibrationY = make([]float64, len(blocks)), make([]float64, len(blocks))
+	for index, block := range blocks {
+		e.CalibrationX[index] = block.sumX / float64(block.count)
+		e.CalibrationY[index] = block.sumY / float64(block.count)
+	}
+}
+
+// ensembleSample is a distortion with MOS and all inputs of an ensemble.
+type ensembleSample struct {
+	// group identifies the reference of the distortion, to keep distortions of the same reference in the same
+	// cross validation fold.
+	group    string
+	features []float64
+	mos      float64
+}
+
+// ensembleSamples returns the distortions of the bundles with MOS and all the inputs.
+func (r ReferenceBundles) ensembleSamples(inputs ScoreTypes) []ensembleSample {
+	result := []ensembleSample{}
+	for _, bundle := range r {
+		for _, ref := range bundle.References {
+			for _, dist := range ref.Distortions {
+				mos, found := dist.Scores[MOS]
+				if !found {
+					continue
+				}
+				features, found := scoresOf(dist, inputs)
+				if !found {
+					continue
+				}
+				result = append(result, ensembleSample{group: bundle.Dir + "\x00" + ref.Name, features: features, mos: mos})
+			}
+		}
+	}
+	return result
+}
+
+// fitSamples returns an ensemble fitted to the samples.
+func fitSamples(combiner Combiner, inputs ScoreTypes, samples []ensembleSample) (*Ensemble, error) {
+	features, targets := make([][]float64, len(samples)), make([]float64, len(samples))
+	for index, sample := range samples {
+		features[index], targets[index] = sample.features, sample.mos
+	}
+	return FitEnsemble(combiner, inputs, features, targets)
+}
+
+// FitEnsemble returns an ensemble of the inputs fitted to the MOS scores of all distortions of the bundles with
+// MOS and all the inputs.
+func (r ReferenceBundles) FitEnsemble(combiner Combiner, inputs ScoreTypes) (*Ensemble, error) {
+	return fitSamples(combiner, inputs, r.ensembleSamples(inputs))
+}
+
+// StoreEnsemble sets the score of the type to the prediction of the ensemble for each distortion of the bundle
+// with all the inputs of the ensemble, and returns the number of distortions.
+func (r *ReferenceBundle) StoreEnsemble(ensemble *Ensemble, scoreType ScoreType) int {
+	result := 0
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			if prediction, found := ensemble.Predict(dist); found {
+				if _, found := dist.Scores[scoreType]; !found {
+					r.ScoreTypes[scoreType]++
+				}
+				dist.Scores[scoreType] = prediction
+				result++
+			}
+		}
+	}
+	return result
+}
+
+// ensembleMetadataKey returns the METADATA key of the ensemble stored as the score type.
+func ensembleMetadataKey(scoreType ScoreType) string {
+	return "ensemble/" + string(scoreType)
+}
+
+// PutEnsemble records the ensemble whose predictions are stored as the score type in the study, so that it's
+// known how the derived scores were produced.
+func (s *Study) PutEnsemble(scoreType ScoreType, ensemble *Ensemble) error {
+	b, err := json.Marshal(ensemble)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO METADATA (KEY, VALUE) VALUES (?, ?) ON CONFLICT (KEY) DO UPDATE SET VALUE = excluded.VALUE", ensembleMetadataKey(scoreType), string(b))
+	return err
+}
+
+// Ensemble returns the ensemble recorded for the score type, or nil if the score type isn't from an ensemble.
+func (s *Study) Ensemble(scoreType ScoreType) (*Ensemble, error) {
+	var value string
+	if err := s.db.QueryRow("SELECT VALUE FROM METADATA WHERE KEY = ?", ensembleMetadataKey(scoreType)).Scan(&value); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	result := &Ensemble{}
+	if err := json.Unmarshal([]byte(value), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// EnsembleEvaluation contains how well an ensemble predicts MOS in cross validation.
+type EnsembleEvaluation struct {
+	Inputs ScoreTypes
+	// N is the number of distortions with MOS and all the inputs.
+	N int
+	// Spearman and Pearson are the correlations between the predictions for held out references and the MOS.
+	Spearman float64
+	Pearson  float64
+	// RMSE is the root mean square error of the predictions for held out references.
+	RMSE float64
+}
+
+// EnsembleEvaluations contains the evaluations of multiple ensembles.
+type EnsembleEvaluations struct {
+	Combiner    Combiner
+	Folds       int
+	Evaluations []EnsembleEvaluation
+}
+
+// Render returns a representation of the evaluations in the format.
+func (e *EnsembleEvaluations) Render(format Format, decimals int) string {
+	precisionString := fmt.Sprintf("%%.%df", decimals)
+	table := Table{Row{"Inputs", "N", "Spearman", "Pearson", "RMSE"}, nil}
+	for _, evaluation := range e.Evaluations {
+		inputs := []string{}
+		for _, input := range evaluation.Inputs {
+			inputs = append(inputs, string(input))
+		}
+		table = append(table, Row{strings.Join(inputs, " + "), fmt.Sprint(evaluation.N), fmt.Sprintf(precisionString, evaluation.Spearman), fmt.Sprintf(precisionString, evaluation.Pearson), fmt.Sprintf(precisionString, evaluation.RMSE)})
+	}
+	return fmt.Sprintf("%s%s%s", format.Heading(3, fmt.Sprintf("Cross validated %s ensembles predicting MOS", e.Combiner)), format.Paragraph(fmt.Sprintf("%v folds of whole references. All rows use the distortions with MOS and all inputs, so the improvement of the last row over each single metric is what the other metrics add on top of it.", e.Folds)), table.Render(format))
+}
+
+// crossValidate returns the evaluation of an ensemble of the inputs, fitted to all folds except the one of each
+// sample and evaluated on the held out samples.
+func crossValidate(combiner Combiner, inputs ScoreTypes, samples []ensembleSample, folds map[string]int, numFolds int) (EnsembleEvaluation, error) {
+	result := EnsembleEvaluation{Inputs: inputs, N: len(samples)}
+	predictions, targets := make([]float64, len(samples)), make([]float64, len(samples))
+	for fold := 0; fold < numFolds; fold++ {
+		training := []ensembleSample{}
+		for _, sample := range samples {
+			if folds[sample.group] != fold {
+				training = append(training, sample)
+			}
+		}
+		ensemble, err := fitSamples(combiner, inputs, training)
+		if err != nil {
+			return result, err
+		}
+		for index, sample := range samples {
+			if folds[sample.group] == fold {
+				predictions[index] = ensemble.predict(sample.features)
+			}
+		}
+	}
+	sumOfSquares := 0.0
+	for index, sample := range samples {
+		targets[index] = sample.mos
+		sumOfSquares += (predictions[index] - sample.mos) * (predictions[index] - sample.mos)
+	}
+	result.Spearman, _ = onlinestats.Spearman(targets, predictions)
+	result.Pearson = onlinestats.Pearson(targets, predictions)
+	result.RMSE = math.Sqrt(sumOfSquares / float64(len(samples)))
+	return result, nil
+}
+
+// EvaluateEnsembles returns the cross validated evaluations of ensembles of each input alone and of all inputs,
+// predicting the MOS of the distortions with MOS and all inputs.
+//
+// The references are randomly assigned to folds using the seed, so that distortions of the same reference are
+// never used both to fit and evaluate an ensemble.
+func (r ReferenceBundles) EvaluateEnsembles(combiner Combiner, inputs ScoreTypes, seed int64) (*EnsembleEvaluations, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no ensemble inputs")
+	}
+	samples := r.ensembleSamples(inputs)
+	groups := []string{}
+	folds := map[string]int{}
+	for _, sample := range samples {
+		if _, found := folds[sample.group]; !found {
+			folds[sample.group] = 0
+			groups = append(groups, sample.group)
+		}
+	}
+	numFolds := min(ensembleFolds, len(groups))
+	if numFolds < 2 {
+		return nil, fmt.Errorf("%v references with MOS and all of %v: %w", len(groups), inputs, errTooFewReferences)
+	}
+	for position, index := range rand.New(rand.NewSource(seed)).Perm(len(groups)) {
+		folds[groups[index]] = position % numFolds
+	}
+	result := &EnsembleEvaluations{Combiner: combiner, Folds: numFolds}
+	candidates := []ScoreTypes{}
+	for _, input := range inputs {
+		candidates = append(candidates, ScoreTypes{input})
+	}
+	if len(inputs) > 1 {
+		candidates = append(candidates, inputs)
+	}
+	for _, candidate := range candidates {
+		candidateSamples := make([]ensembleSample, len(samples))
+		for index, sample := range samples {
+			candidateSamples[index] = sample
+			if len(candidate) < len(inputs) {
+				candidateSamples[index].features = []float64{sample.features[slices.Index(inputs, candidate[0])]}
+			}
+		}
+		evaluation, err := crossValidate(combiner, candidate, candidateSamples, folds, numFolds)
+		if err != nil {
+			return nil, err
+		}
+		result.Evaluations = append(result.Evaluations, evaluation)
+	}
+	return result, nil
+}
+
+// defaultEnsembleInputs returns the score types of the bundle that aren't from listeners or auxiliary.
+func (r *ReferenceBundle) defaultEnsembleInputs() ScoreTypes {
+	result := ScoreTypes{}
+	for _, scoreType := range r.analyzedTypes() {
+		switch scoreType {
+		case MOS, JND, Preference:
+		default:
+			result = append(result, scoreType)
+		}
+	}
+	return result
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "ensemble",
+		Description: "Cross validated MOS prediction of ensembles of each metric alone and of all metrics, per MOS study, showing what each metric adds on top of the others.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			if _, found := bundle.ScoreTypes[MOS]; !found {
+				return "", nil
+			}
+			inputs := ScoreTypes(opts.EnsembleInputs)
+			if len(inputs) == 0 {
+				inputs = bundle.defaultEnsembleInputs()
+			}
+			if len(inputs) == 0 {
+				return "", nil
+			}
+			combiner := opts.EnsembleCombiner
+			if combiner == "" {
+				combiner = CombinerLinear
+			}
+			evaluations, err := ReferenceBundles{bundle}.EvaluateEnsembles(combiner, inputs, opts.Seed)
+			if errors.Is(err, errTooFewReferences) {
+				return "", nil
+			} else if err != nil {
+				return "", err
+			}
+			return evaluations.Render(opts.Format, opts.Decimals), nil
+		},
+	})
+}
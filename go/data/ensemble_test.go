@@ -0,0 +1,152 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// ensembleBundle returns a bundle with numReferences references of 4 distortions, where the MOS is exactly
+// 1 + 2 * A - 0.5 * B and the metric Noise is unrelated to the MOS.
+func ensembleBundle(numReferences int) *ReferenceBundle {
+	refs := []*Reference{}
+	for refIndex := 0; refIndex < numReferences; refIndex++ {
+		ref := &Reference{Name: fmt.Sprintf("ref%v", refIndex), Path: fmt.Sprintf("ref%v.wav", refIndex)}
+		for distIndex := 0; distIndex < 4; distIndex++ {
+			a := float64((refIndex*7+distIndex*3)%11) / 10
+			b := float64((refIndex*5+distIndex*2)%13) / 12
+			noise := float64((refIndex*3+distIndex*5)%7) / 6
+			ref.Distortions = append(ref.Distortions, &Distortion{
+				Name:   fmt.Sprintf("%s_dist%v", ref.Name, distIndex),
+				Path:   fmt.Sprintf("%s_dist%v.wav", ref.Name, distIndex),
+				Scores: map[ScoreType]float64{MOS: 1 + 2*a - 0.5*b, "A": a, "B": b, "Noise": noise},
+			})
+		}
+		refs = append(refs, ref)
+	}
+	return bundleOf(refs...)
+}
+
+func TestFitEnsemble(t *testing.T) {
+	bundle := ensembleBundle(6)
+	ensemble, err := ReferenceBundles{bundle}.FitEnsemble(CombinerLinear, ScoreTypes{"A", "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(ensemble.Intercept-1) > 1e-3 || math.Abs(ensemble.Weights[0]-2) > 1e-3 || math.Abs(ensemble.Weights[1]+0.5) > 1e-3 {
+		t.Errorf("linear ensemble = %v, want 1 + 2 * A + -0.5 * B", ensemble)
+	}
+
+	// The isotonic calibration maps the linear combination monotonically to a non-linear MOS.
+	features, targets := [][]float64{}, []float64{}
+	for index := 0; index < 20; index++ {
+		x := float64(index) / 19
+		features = append(features, []float64{x})
+		targets = append(targets, 1+4*x*x)
+	}
+	isotonic, err := FitEnsemble(CombinerIsotonic, ScoreTypes{"A"}, features, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := 1; index < len(isotonic.CalibrationX); index++ {
+		if isotonic.CalibrationX[index] <= isotonic.CalibrationX[index-1] || isotonic.CalibrationY[index] < isotonic.CalibrationY[index-1] {
+			t.Errorf("calibration knots %v -> %v aren't increasing", isotonic.CalibrationX, isotonic.CalibrationY)
+			break
+		}
+	}
+	for index, sample := range features {
+		if got := isotonic.predict(sample); math.Abs(got-targets[index]) > 1e-6 {
+			t.Errorf("isotonic prediction for %v = %v, want %v", sample, got, targets[index])
+		}
+	}
+
+	if _, err := FitEnsemble("unknown", ScoreTypes{"A"}, features, targets); err == nil {
+		t.Errorf("fitting an unknown combiner returned no error")
+	}
+	if _, err := FitEnsemble(CombinerLinear, ScoreTypes{"A", "B"}, features[:3], targets[:3]); err == nil {
+		t.Errorf("fitting 3 samples with 2 inputs returned no error")
+	}
+}
+
+func TestEvaluateEnsembles(t *testing.T) {
+	bundle := ensembleBundle(10)
+	evaluations, err := ReferenceBundles{bundle}.EvaluateEnsembles(CombinerLinear, ScoreTypes{"A", "Noise", "B"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evaluations.Folds != ensembleFolds {
+		t.Errorf("folds = %v, want %v", evaluations.Folds, ensembleFolds)
+	}
+	wantInputs := []ScoreTypes{{"A"}, {"Noise"}, {"B"}, {"A", "Noise", "B"}}
+	if len(evaluations.Evaluations) != len(wantInputs) {
+		t.Fatalf("got %v evaluations, want %v", len(evaluations.Evaluations), len(wantInputs))
+	}
+	for index, evaluation := range evaluations.Evaluations {
+		if !reflect.DeepEqual(evaluation.Inputs, wantInputs[index]) || evaluation.N != 40 {
+			t.Errorf("evaluation %v has inputs %v and N %v, want %v and 40", index, evaluation.Inputs, evaluation.N, wantInputs[index])
+		}
+	}
+	all := evaluations.Evaluations[3]
+	if all.RMSE > 1e-3 || all.Pearson < 0.999 {
+		t.Errorf("evaluation of all inputs = %+v, want a perfect prediction of held out references", all)
+	}
+	for _, single := range evaluations.Evaluations[:3] {
+		if single.RMSE <= all.RMSE {
+			t.Errorf("evaluation of %v = %+v, want a worse prediction than all inputs", single.Inputs, single)
+		}
+	}
+
+	if _, err := (ReferenceBundles{ensembleBundle(1)}).EvaluateEnsembles(CombinerLinear, ScoreTypes{"A"}, 1); !errors.Is(err, errTooFewReferences) {
+		t.Errorf("evaluating a single reference returned %v, want %v", err, errTooFewReferences)
+	}
+}
+
+func TestStoreEnsemble(t *testing.T) {
+	bundle := ensembleBundle(6)
+	ensemble, err := ReferenceBundles{bundle}.FitEnsemble(CombinerLinear, ScoreTypes{"A", "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(bundle.References[0].Distortions[0].Scores, "B")
+	if stored := bundle.StoreEnsemble(ensemble, "Ensemble"); stored != 23 {
+		t.Errorf("stored %v ensemble scores, want 23", stored)
+	}
+	if _, found := bundle.References[0].Distortions[0].Scores["Ensemble"]; found {
+		t.Errorf("ensemble score stored for a distortion without all inputs")
+	}
+	dist := bundle.References[1].Distortions[2]
+	if got, want := dist.Scores["Ensemble"], dist.Scores[MOS]; math.Abs(got-want) > 1e-3 {
+		t.Errorf("ensemble score = %v, want %v", got, want)
+	}
+
+	study, err := OpenStudy(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	if got, err := study.Ensemble("Ensemble"); err != nil || got != nil {
+		t.Errorf("unrecorded ensemble = %v, %v, want nil", got, err)
+	}
+	if err := study.PutEnsemble("Ensemble", ensemble); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := study.Ensemble("Ensemble"); err != nil || !reflect.DeepEqual(got, ensemble) {
+		t.Errorf("recorded ensemble = %+v, %v, want %+v", got, err, ensemble)
+	}
+}
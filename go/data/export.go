@@ -0,0 +1,124 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/google/zimtohrli/go/progress"
+)
+
+// ExportStage is the stage of the progress of ExportJSONL counting the exported distortions.
+const ExportStage = "export"
+
+// ExportColumn returns the standardized column name used by ExportJSONL for scores of the type,
+// e.g. "score_mos" for MOS and "score_visqol" for ViSQOL.
+func ExportColumn(scoreType ScoreType) string {
+	result := &strings.Builder{}
+	result.WriteString("score_")
+	for _, r := range string(scoreType) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			result.WriteRune(unicode.ToLower(r))
+		} else {
+			result.WriteRune('_')
+		}
+	}
+	return result.String()
+}
+
+// ExportJSONL writes one JSON object per line for each distortion in the bundles, with the columns
+// "study", "reference", "reference_path", "distortion", "distortion_path", and one ExportColumn
+// column per score type in the bundles, which is null for distortions without a finite score of that type.
+// Distortions with recorded generation parameters also get a "generation" column with the parameters and command.
+//
+// Local paths are absolute, to make the output loadable from anywhere, e.g. by
+// `datasets.load_dataset("json", data_files=...)` in the Hugging Face datasets library.
+//
+// Stages, if set, gets the progress of the ExportStage stage.
+func (r ReferenceBundles) ExportJSONL(w io.Writer, stages *progress.Stages) error {
+	scoreTypes := map[ScoreType]int{}
+	for _, bundle := range r {
+		for scoreType, count := range bundle.ScoreTypes {
+			scoreTypes[scoreType] += count
+		}
+	}
+	sortedTypes := sortedScoreTypes(scoreTypes)
+	columns := map[ScoreType]string{}
+	for _, scoreType := range sortedTypes {
+		column := ExportColumn(scoreType)
+		for otherType, otherColumn := range columns {
+			if otherColumn == column {
+				return fmt.Errorf("score types %q and %q both export as %q", otherType, scoreType, column)
+			}
+		}
+		columns[scoreType] = column
+	}
+	absolute := func(dir, path string) (string, error) {
+		path = Resolve(dir, path)
+		if filepath.IsAbs(path) || strings.Contains(path, "://") {
+			return path, nil
+		}
+		return filepath.Abs(path)
+	}
+	for _, bundle := range r {
+		for _, ref := range bundle.References {
+			stages.Add(ExportStage, len(ref.Distortions), 0, 0)
+		}
+	}
+	encoder := json.NewEncoder(w)
+	for _, bundle := range r {
+		for _, ref := range bundle.References {
+			refPath, err := absolute(bundle.Dir, ref.Path)
+			if err != nil {
+				return err
+			}
+			for _, dist := range ref.Distortions {
+				distPath, err := absolute(bundle.Dir, dist.Path)
+				if err != nil {
+					return err
+				}
+				row := map[string]any{
+					"study":           filepath.Base(bundle.Dir),
+					"reference":       ref.Name,
+					"reference_path":  refPath,
+					"distortion":      dist.Name,
+					"distortion_path": distPath,
+				}
+				if dist.Generation != nil {
+					row["generation"] = dist.Generation
+				}
+				for _, scoreType := range sortedTypes {
+					// JSON can't represent NaN and infinite scores, which are exported like missing scores.
+					if score, found := dist.Scores[scoreType]; found && isFinite(score) {
+						row[columns[scoreType]] = score
+					} else {
+						row[columns[scoreType]] = nil
+					}
+				}
+				if err := encoder.Encode(row); err != nil {
+					stages.Add(ExportStage, 0, 0, 1)
+					return err
+				}
+				stages.Add(ExportStage, 0, 1, 0)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExportJSONL(t *testing.T) {
+	bundle := bundleOf(scoredReference("a",
+		map[ScoreType]float64{MOS: 4, Zimtohrli: 0.1},
+		map[ScoreType]float64{MOS: 3, Zimtohrli: math.NaN()},
+		map[ScoreType]float64{Zimtohrli: math.Inf(1)},
+	))
+	buf := &bytes.Buffer{}
+	if err := (ReferenceBundles{bundle}).ExportJSONL(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("exported %v lines, want 3: %q", len(lines), buf.String())
+	}
+	for index, want := range []map[string]any{
+		{"score_mos": 4.0, "score_zimtohrli": 0.1},
+		{"score_mos": 3.0, "score_zimtohrli": nil},
+		{"score_mos": nil, "score_zimtohrli": nil},
+	} {
+		row := map[string]any{}
+		if err := json.Unmarshal([]byte(lines[index]), &row); err != nil {
+			t.Fatal(err)
+		}
+		for column, value := range want {
+			if got, found := row[column]; !found || got != value {
+				t.Errorf("line %v has %v = %v, want %v", index, column, got, value)
+			}
+		}
+	}
+}
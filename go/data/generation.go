@@ -0,0 +1,144 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Generation describes how a distortion was generated from its reference, to make studies reproducible.
+type Generation struct {
+	// Command is the command line that generated the distortion, if any.
+	Command []string `json:",omitempty"`
+	// Parameters are the generation parameters, e.g. {"codec": "opus", "bitrate": "32k"} or {"noise_snr": "10"}.
+	Parameters map[string]string `json:",omitempty"`
+}
+
+// Condition returns a label identifying the generation parameters, like "bitrate=32k,codec=opus".
+func (g *Generation) Condition() string {
+	if g == nil {
+		return ""
+	}
+	names := make([]string, 0, len(g.Parameters))
+	for name := range g.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for index, name := range names {
+		pairs[index] = fmt.Sprintf("%s=%s", name, g.Parameters[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ConditionMean contains the mean scores of the distortions generated with a parameter value.
+type ConditionMean struct {
+	Parameter string
+	Value     string
+	Count     int
+	Means     map[ScoreType]float64
+}
+
+// ConditionMeans contains the mean scores for all generation parameter values of a bundle.
+type ConditionMeans struct {
+	ScoreTypes ScoreTypes
+	Means      []ConditionMean
+}
+
+// ConditionMeans returns the mean scores of the distortions generated with each value of each generation parameter,
+// ordered by parameter and value.
+func (r *ReferenceBundle) ConditionMeans() *ConditionMeans {
+	type key struct {
+		parameter string
+		value     string
+	}
+	counts := map[key]int{}
+	sums := map[key]map[ScoreType]float64{}
+	scoreCounts := map[key]map[ScoreType]int{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			if dist.Generation == nil {
+				continue
+			}
+			for parameter, value := range dist.Generation.Parameters {
+				k := key{parameter: parameter, value: value}
+				counts[k]++
+				if sums[k] == nil {
+					sums[k] = map[ScoreType]float64{}
+					scoreCounts[k] = map[ScoreType]int{}
+				}
+				for scoreType, score := range dist.Scores {
+					sums[k][scoreType] += score
+					scoreCounts[k][scoreType]++
+				}
+			}
+		}
+	}
+	keys := make([]key, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].parameter != keys[j].parameter {
+			return keys[i].parameter < keys[j].parameter
+		}
+		return keys[i].value < keys[j].value
+	})
+	result := &ConditionMeans{ScoreTypes: r.analyzedTypes()}
+	for _, k := range keys {
+		mean := ConditionMean{Parameter: k.parameter, Value: k.value, Count: counts[k], Means: map[ScoreType]float64{}}
+		for scoreType, sum := range sums[k] {
+			mean.Means[scoreType] = sum / float64(scoreCounts[k][scoreType])
+		}
+		result.Means = append(result.Means, mean)
+	}
+	return result
+}
+
+// Render returns a representation of the condition means in the format, or an empty string if there are none.
+func (c *ConditionMeans) Render(format Format) string {
+	if len(c.Means) == 0 {
+		return ""
+	}
+	header := Row{"Parameter", "Value", "Count"}
+	for _, scoreType := range c.ScoreTypes {
+		header = append(header, string(scoreType))
+	}
+	table := Table{header, nil}
+	for _, mean := range c.Means {
+		row := Row{mean.Parameter, mean.Value, fmt.Sprint(mean.Count)}
+		for _, scoreType := range c.ScoreTypes {
+			if score, found := mean.Means[scoreType]; found {
+				row = append(row, scoreType.FormatScore(score))
+			} else {
+				row = append(row, "")
+			}
+		}
+		table = append(table, row)
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, "Mean scores per generation parameter value"), table.Render(format))
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "conditions",
+		Description: "Mean scores per generation parameter value, for studies with recorded distortion generation parameters.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			return bundle.ConditionMeans().Render(opts.Format), nil
+		},
+	})
+}
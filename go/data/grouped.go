@@ -0,0 +1,152 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/dgryski/go-onlinestats"
+)
+
+// Aggregation defines how the correlations of groups of distortions are combined into one correlation.
+type Aggregation string
+
+const (
+	// AggregationMean uses the mean of the correlations of the groups.
+	AggregationMean Aggregation = "mean"
+	// AggregationMedian uses the median of the correlations of the groups.
+	AggregationMedian Aggregation = "median"
+)
+
+// Aggregations are the valid aggregations.
+var Aggregations = []Aggregation{AggregationMean, AggregationMedian}
+
+// Apply returns the aggregation of the values, or an error if the aggregation is unknown.
+func (a Aggregation) Apply(values []float64) (float64, error) {
+	if len(values) == 0 {
+		return 0, nil
+	}
+	switch a {
+	case AggregationMean:
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum / float64(len(values)), nil
+	case AggregationMedian:
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return 0.5 * (sorted[middle-1] + sorted[middle]), nil
+		}
+		return sorted[middle], nil
+	}
+	return 0, fmt.Errorf("unknown aggregation %q, want one of %v", a, Aggregations)
+}
+
+// groupedCorrelation returns the absolute value of the aggregated Spearman correlations between score type A and B
+// within each group of distortions, the number of distortions with finite scores of both types in the groups used,
+// and the number of groups used.
+//
+// Groups with fewer than two such distortions, or where either score type is constant, have no defined correlation
+// and are skipped. The signed correlations are aggregated, so that groups disagreeing in sign cancel out.
+func (r *ReferenceBundle) groupedCorrelation(typeA, typeB ScoreType, group stratifier, aggregation Aggregation) (float64, int, int, error) {
+	scoresA := map[string][]float64{}
+	scoresB := map[string][]float64{}
+	keys := []string{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			scoreA, foundA := dist.Scores[typeA]
+			scoreB, foundB := dist.Scores[typeB]
+			if !foundA || !foundB || !isFinite(scoreA) || !isFinite(scoreB) {
+				continue
+			}
+			key := group(ref, dist)
+			if _, found := scoresA[key]; !found {
+				keys = append(keys, key)
+			}
+			scoresA[key] = append(scoresA[key], scoreA)
+			scoresB[key] = append(scoresB[key], scoreB)
+		}
+	}
+	correlations := []float64{}
+	n := 0
+	for _, key := range keys {
+		if len(scoresA[key]) < 2 {
+			continue
+		}
+		correlation, _ := onlinestats.Spearman(scoresA[key], scoresB[key])
+		if math.IsNaN(correlation) {
+			continue
+		}
+		correlations = append(correlations, correlation)
+		n += len(scoresA[key])
+	}
+	res, err := aggregation.Apply(correlations)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return math.Abs(res), n, len(correlations), nil
+}
+
+// CorrelateGrouped returns a table of all scores in the bundle Spearman correlated to each other within each group
+// of distortions, aggregated across the groups.
+//
+// The distortions are grouped by the attribute, which is "reference" or a generation parameter like "session" or
+// "panel". Correlating within groups avoids conflating differences in how listeners used the MOS scale in different
+// sessions with differences in quality.
+func (r *ReferenceBundle) CorrelateGrouped(attribute string, aggregation Aggregation) (CorrelationTable, error) {
+	if kind := r.Kind(); kind != KindMOS && kind != KindUnlabeled {
+		return nil, fmt.Errorf("cannot correlate %v references", kind)
+	}
+	group, err := r.stratifierFor(attribute, 1)
+	if err != nil {
+		return nil, err
+	}
+	result := CorrelationTable{}
+	for _, typeA := range r.analyzedTypes() {
+		row := []CorrelationScore{}
+		for _, typeB := range r.analyzedTypes() {
+			corr, n, groups, err := r.groupedCorrelation(typeA, typeB, group, aggregation)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, CorrelationScore{
+				ScoreTypeA: typeA,
+				ScoreTypeB: typeB,
+				Score:      corr,
+				N:          n,
+				Groups:     groups,
+			})
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// correlate returns CorrelateGrouped if opts.CorrelationGroup is set, otherwise Correlate.
+func (r *ReferenceBundle) correlate(opts AnalysisOptions) (CorrelationTable, error) {
+	if opts.CorrelationGroup == "" {
+		return r.Correlate()
+	}
+	aggregation := opts.CorrelationAggregation
+	if aggregation == "" {
+		aggregation = AggregationMean
+	}
+	return r.CorrelateGrouped(opts.CorrelationGroup, aggregation)
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// bundleOf returns a bundle with the references.
+func bundleOf(refs ...*Reference) *ReferenceBundle {
+	result := &ReferenceBundle{Dir: "test", ScoreTypes: map[ScoreType]int{}}
+	for _, ref := range refs {
+		result.Add(ref)
+	}
+	return result
+}
+
+// sessionDistortion returns a distortion generated in the session with the scores.
+func sessionDistortion(name, session string, scores map[ScoreType]float64) *Distortion {
+	return &Distortion{
+		Name:       name,
+		Path:       name + ".wav",
+		Scores:     scores,
+		Generation: &Generation{Parameters: map[string]string{"session": session}},
+	}
+}
+
+// sessionBundle returns a bundle where the metric orders the distortions perfectly within each session, while
+// the listeners of session b used a higher part of the MOS scale than those of session a.
+func sessionBundle() *ReferenceBundle {
+	ref := &Reference{Name: "ref", Path: "ref.wav"}
+	for index, scores := range []struct {
+		session string
+		mos     float64
+		metric  float64
+	}{
+		{"a", 1, 10}, {"a", 2, 20}, {"a", 3, 30},
+		{"b", 4, 1}, {"b", 5, 2}, {"b", 6, 3},
+	} {
+		ref.Distortions = append(ref.Distortions, sessionDistortion(fmt.Sprintf("dist%v", index), scores.session, map[ScoreType]float64{MOS: scores.mos, "Metric": scores.metric}))
+	}
+	return bundleOf(ref)
+}
+
+// correlationOf returns the correlation score between the score types in the table.
+func correlationOf(t *testing.T, table CorrelationTable, typeA, typeB ScoreType) CorrelationScore {
+	t.Helper()
+	for _, row := range table {
+		for _, score := range row {
+			if score.ScoreTypeA == typeA && score.ScoreTypeB == typeB {
+				return score
+			}
+		}
+	}
+	t.Fatalf("no correlation between %v and %v in %v", typeA, typeB, table)
+	return CorrelationScore{}
+}
+
+func TestAggregation(t *testing.T) {
+	for _, tc := range []struct {
+		aggregation Aggregation
+		values      []float64
+		want        float64
+	}{
+		{AggregationMean, nil, 0},
+		{AggregationMean, []float64{1, 2, 6}, 3},
+		{AggregationMedian, []float64{6, 1, 2}, 2},
+		{AggregationMedian, []float64{4, 1, 2, 8}, 3},
+	} {
+		got, err := tc.aggregation.Apply(tc.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%v.Apply(%v) = %v, want %v", tc.aggregation, tc.values, got, tc.want)
+		}
+	}
+	if _, err := Aggregation("mode").Apply([]float64{1}); err == nil {
+		t.Errorf("unknown aggregation returned no error")
+	}
+}
+
+func TestCorrelateGrouped(t *testing.T) {
+	bundle := sessionBundle()
+	pooled, err := bundle.Correlate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := correlationOf(t, pooled, MOS, "Metric"); score.Score > 0.6 {
+		t.Errorf("pooled correlation = %v, want the session effect to hide the correlation", score.Score)
+	}
+	for _, aggregation := range Aggregations {
+		grouped, err := bundle.CorrelateGrouped("session", aggregation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		score := correlationOf(t, grouped, MOS, "Metric")
+		if math.Abs(score.Score-1) > 1e-9 || score.N != 6 || score.Groups != 2 {
+			t.Errorf("%v correlation grouped by session = %+v, want 1 from 6 distortions in 2 groups", aggregation, score)
+		}
+	}
+	// All distortions have the same reference, so grouping by reference pools them.
+	byReference, err := bundle.CorrelateGrouped("reference", AggregationMean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := correlationOf(t, byReference, MOS, "Metric").Score, correlationOf(t, pooled, MOS, "Metric").Score; math.Abs(got-want) > 1e-9 {
+		t.Errorf("correlation grouped by reference = %v, want the pooled %v", got, want)
+	}
+	if _, err := bundle.CorrelateGrouped("panel", AggregationMean); err == nil {
+		t.Errorf("grouping by an unknown attribute returned no error")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import "time"
+
+// HistoricalScore is a score calculated in a run.
+type HistoricalScore struct {
+	Score float64
+	Time  time.Time
+	// Run is the name of the run that calculated the score, e.g. a version.
+	Run string `json:",omitempty"`
+	// Parameters are the parameters of the metric in the run, if known.
+	Parameters string `json:",omitempty"`
+	// Version is the version of the metric in the run, if known.
+	Version string `json:",omitempty"`
+}
+
+// UseRun replaces the scores of the bundle with the latest historical scores from the run, for all score types
+// with historical scores from the run. Distortions without historical scores from the run for such a score type
+// lose their score of that type, to avoid mixing runs.
+//
+// Returns the number of score types replaced.
+func (r *ReferenceBundle) UseRun(run string) int {
+	runTypes := map[ScoreType]bool{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			for scoreType, history := range dist.History {
+				for _, historical := range history {
+					if historical.Run == run {
+						runTypes[scoreType] = true
+					}
+				}
+			}
+		}
+	}
+	r.ScoreTypes = map[ScoreType]int{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			if dist.Scores == nil {
+				dist.Scores = map[ScoreType]float64{}
+			}
+			for scoreType := range runTypes {
+				delete(dist.Scores, scoreType)
+				for _, historical := range dist.History[scoreType] {
+					if historical.Run == run {
+						dist.Scores[scoreType] = historical.Score
+					}
+				}
+			}
+			for scoreType := range dist.Scores {
+				r.ScoreTypes[scoreType]++
+			}
+		}
+	}
+	return len(runTypes)
+}
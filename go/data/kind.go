@@ -0,0 +1,192 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Kind is the kind of listener evaluations in a study, which defines how metrics are evaluated against them.
+type Kind string
+
+const (
+	// KindMOS studies have mean opinion scores, and metrics are evaluated by their correlation with them.
+	KindMOS Kind = "MOS"
+	// KindJND studies have just-noticeable-difference evaluations, and metrics are evaluated by how accurately
+	// they classify distortions as audible.
+	KindJND Kind = "JND"
+	// KindPreference studies have pairwise preferences, and metrics are evaluated by how often they pick the same
+	// winner as the listeners.
+	KindPreference Kind = "Preference"
+	// KindMixed studies have more than one kind of listener evaluations.
+	KindMixed Kind = "mixed"
+	// KindUnlabeled studies have no listener evaluations, only metrics.
+	KindUnlabeled Kind = "unlabeled"
+)
+
+// kindScoreTypes contains the kind of study each score type of listener evaluations defines.
+var kindScoreTypes = map[ScoreType]Kind{
+	MOS:        KindMOS,
+	JND:        KindJND,
+	Preference: KindPreference,
+}
+
+// kindOf returns the kind of study with the numbers of scores of each score type.
+func kindOf(scoreTypes map[ScoreType]int) Kind {
+	result := KindUnlabeled
+	for scoreType, kind := range kindScoreTypes {
+		if scoreTypes[scoreType] == 0 {
+			continue
+		}
+		if result != KindUnlabeled {
+			return KindMixed
+		}
+		result = kind
+	}
+	return result
+}
+
+// Kind returns the kind of listener evaluations of the distortions in the bundle, considering all distortions.
+func (r *ReferenceBundle) Kind() Kind {
+	return kindOf(r.ScoreTypes)
+}
+
+// Kind returns the kind of listener evaluations of the distortions in the study, considering all distortions,
+// without loading the references.
+func (s *Study) Kind() (Kind, error) {
+	rows, err := s.db.Query("SELECT SCORE_TYPE, COUNT(*) FROM SCORE GROUP BY SCORE_TYPE")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	counts := map[ScoreType]int{}
+	for rows.Next() {
+		var scoreType string
+		var count int
+		if err := rows.Scan(&scoreType, &count); err != nil {
+			return "", err
+		}
+		counts[ScoreType(scoreType)] = count
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return kindOf(counts), nil
+}
+
+// ScoreType returns the score type of the listener evaluations of the kind, or an empty score type for mixed and
+// unlabeled studies.
+func (k Kind) ScoreType() ScoreType {
+	for scoreType, kind := range kindScoreTypes {
+		if kind == k {
+			return scoreType
+		}
+	}
+	return ""
+}
+
+// SubsetOfKind returns a bundle with the distortions of the bundle that have listener evaluations of the kind, without
+// their scores of other kinds of listener evaluations, and the references with any such distortions.
+//
+// The distortions are copies, so that the subsets of a mixed bundle can be evaluated independently.
+func (r *ReferenceBundle) SubsetOfKind(kind Kind) *ReferenceBundle {
+	result := &ReferenceBundle{
+		Dir:        r.Dir,
+		ScoreTypes: map[ScoreType]int{},
+		Subset:     kind,
+	}
+	scoreType := kind.ScoreType()
+	for _, ref := range r.References {
+		subsetRef := &Reference{Name: ref.Name, Path: ref.Path}
+		for _, dist := range ref.Distortions {
+			if _, found := dist.Scores[scoreType]; !found {
+				continue
+			}
+			subsetDist := *dist
+			subsetDist.Scores = map[ScoreType]float64{}
+			for distScoreType, score := range dist.Scores {
+				if otherKind, found := kindScoreTypes[distScoreType]; !found || otherKind == kind {
+					subsetDist.Scores[distScoreType] = score
+				}
+			}
+			subsetRef.Distortions = append(subsetRef.Distortions, &subsetDist)
+		}
+		if len(subsetRef.Distortions) > 0 {
+			result.Add(subsetRef)
+		}
+	}
+	return result
+}
+
+// ofKind returns the bundle if it's of the kind, its subset of the kind if it's mixed and has listener evaluations
+// of the kind, or nil otherwise.
+func (r *ReferenceBundle) ofKind(kind Kind) *ReferenceBundle {
+	switch r.Kind() {
+	case kind:
+		return r
+	case KindMixed:
+		if r.ScoreTypes[kind.ScoreType()] > 0 {
+			return r.SubsetOfKind(kind)
+		}
+	}
+	return nil
+}
+
+// SplitByKind returns the subsets of each kind of listener evaluations of a mixed bundle, ordered by kind, or the
+// bundle itself if it isn't mixed.
+func (r *ReferenceBundle) SplitByKind() ReferenceBundles {
+	if r.Kind() != KindMixed {
+		return ReferenceBundles{r}
+	}
+	result := ReferenceBundles{}
+	for _, kind := range []Kind{KindJND, KindMOS, KindPreference} {
+		if subset := r.ofKind(kind); subset != nil {
+			result = append(result, subset)
+		}
+	}
+	return result
+}
+
+// SplitByKind returns the bundles, with mixed bundles replaced by their subsets of each kind of listener evaluations.
+func (r ReferenceBundles) SplitByKind() ReferenceBundles {
+	result := ReferenceBundles{}
+	for _, bundle := range r {
+		result = append(result, bundle.SplitByKind()...)
+	}
+	return result
+}
+
+// Name returns the base name of the directory of the bundle, with the kind of subset if it's a subset of a mixed
+// study.
+func (r *ReferenceBundle) Name() string {
+	if r.Subset != "" {
+		return fmt.Sprintf("%s (%v subset)", filepath.Base(r.Dir), r.Subset)
+	}
+	return filepath.Base(r.Dir)
+}
+
+// subsetNote returns a paragraph describing the bundle if it's a subset of a mixed study, or an empty string
+// otherwise.
+func (r *ReferenceBundle) subsetNote(format Format) string {
+	if r.Subset == "" {
+		return ""
+	}
+	distortions := 0
+	for _, ref := range r.References {
+		distortions += len(ref.Distortions)
+	}
+	return format.Paragraph(fmt.Sprintf("%v distortions with %v evaluations of a study mixing kinds of listener evaluations", distortions, r.Subset))
+}
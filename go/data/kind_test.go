@@ -0,0 +1,138 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// scoredReference returns a reference with a distortion with each of the scores.
+func scoredReference(name string, scores ...map[ScoreType]float64) *Reference {
+	ref := &Reference{Name: name, Path: name + ".wav"}
+	for index, distScores := range scores {
+		distName := fmt.Sprintf("%s_dist%v", name, index)
+		ref.Distortions = append(ref.Distortions, &Distortion{Name: distName, Path: distName + ".wav", Scores: distScores})
+	}
+	return ref
+}
+
+func TestKind(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		refs []*Reference
+		want Kind
+	}{
+		{"empty", nil, KindUnlabeled},
+		{"metrics only", []*Reference{scoredReference("a", map[ScoreType]float64{Zimtohrli: 0.1})}, KindUnlabeled},
+		{"MOS", []*Reference{scoredReference("a", map[ScoreType]float64{MOS: 3, Zimtohrli: 0.1}, map[ScoreType]float64{Zimtohrli: 0.2})}, KindMOS},
+		{"JND", []*Reference{scoredReference("a", map[ScoreType]float64{JND: 1})}, KindJND},
+		{"preference", []*Reference{scoredReference("a", map[ScoreType]float64{Preference: 0.5})}, KindPreference},
+		{"MOS and JND references", []*Reference{scoredReference("a", map[ScoreType]float64{MOS: 3}), scoredReference("b", map[ScoreType]float64{JND: 0})}, KindMixed},
+		{"MOS and preference distortion", []*Reference{scoredReference("a", map[ScoreType]float64{MOS: 3, Preference: 1})}, KindMixed},
+	} {
+		if got := bundleOf(tc.refs...).Kind(); got != tc.want {
+			t.Errorf("%s: bundle kind = %v, want %v", tc.name, got, tc.want)
+		}
+		study, err := OpenStudy(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := study.Put(tc.refs); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := study.Kind(); err != nil || got != tc.want {
+			t.Errorf("%s: study kind = %v, %v, want %v", tc.name, got, err, tc.want)
+		}
+		study.Close()
+	}
+	for kind, want := range map[Kind]ScoreType{KindMOS: MOS, KindJND: JND, KindPreference: Preference, KindMixed: "", KindUnlabeled: ""} {
+		if got := kind.ScoreType(); got != want {
+			t.Errorf("%v.ScoreType() = %q, want %q", kind, got, want)
+		}
+	}
+}
+
+// mixedBundle returns a bundle with MOS, JND, and preference evaluations of different distortions, and a
+// distortion with both MOS and JND evaluations.
+func mixedBundle() *ReferenceBundle {
+	result := bundleOf(
+		scoredReference("a",
+			map[ScoreType]float64{MOS: 1, "Metric": 10},
+			map[ScoreType]float64{MOS: 2, "Metric": 20},
+			map[ScoreType]float64{MOS: 3, JND: 1, "Metric": 30},
+		),
+		scoredReference("b",
+			map[ScoreType]float64{JND: 0, "Metric": 1},
+			map[ScoreType]float64{JND: 1, "Metric": 50},
+		),
+		scoredReference("c", map[ScoreType]float64{"Metric": 5}),
+	)
+	result.Dir = "/studies/mixed"
+	return result
+}
+
+func TestSubsetOfKind(t *testing.T) {
+	bundle := mixedBundle()
+	mos := bundle.SubsetOfKind(KindMOS)
+	if mos.Subset != KindMOS || mos.Kind() != KindMOS || mos.Name() != "mixed (MOS subset)" {
+		t.Errorf("MOS subset has subset %v, kind %v, and name %q", mos.Subset, mos.Kind(), mos.Name())
+	}
+	if len(mos.References) != 1 || len(mos.References[0].Distortions) != 3 {
+		t.Fatalf("MOS subset = %+v, want the 3 distortions of a", mos.References)
+	}
+	if scores := mos.References[0].Distortions[2].Scores; len(scores) != 2 || scores[MOS] != 3 || scores["Metric"] != 30 {
+		t.Errorf("scores in the MOS subset = %v, want MOS and Metric", scores)
+	}
+	if _, found := bundle.References[0].Distortions[2].Scores[JND]; !found {
+		t.Errorf("taking the MOS subset removed the JND score from the bundle")
+	}
+	jnd := bundle.SubsetOfKind(KindJND)
+	if len(jnd.References) != 2 || jnd.References[0].Name != "a" || len(jnd.References[0].Distortions) != 1 || len(jnd.References[1].Distortions) != 2 {
+		t.Errorf("JND subset = %+v, want the last distortion of a and both distortions of b", jnd.References)
+	}
+	if preference := bundle.SubsetOfKind(KindPreference); len(preference.References) != 0 {
+		t.Errorf("preference subset = %+v, want no references", preference.References)
+	}
+	if name := bundle.Name(); name != "mixed" {
+		t.Errorf("name of the bundle = %q, want mixed", name)
+	}
+}
+
+func TestSplitByKind(t *testing.T) {
+	mos := sessionBundle()
+	split := ReferenceBundles{mixedBundle(), mos}.SplitByKind()
+	if len(split) != 3 || split[0].Subset != KindJND || split[1].Subset != KindMOS || split[2] != mos {
+		t.Errorf("split bundles = %+v, want the JND and MOS subsets of the mixed bundle and the MOS bundle", split)
+	}
+
+	analyses, err := GetAnalyses("correlation", "accuracy", "preference")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, analysis := range analyses {
+		section, err := analysis.Study(mixedBundle(), AnalysisOptions{Format: Format{Target: CSV}})
+		if err != nil {
+			t.Fatalf("%v: %v", analysis.Name, err)
+		}
+		for kind, want := range map[Kind]bool{KindMOS: analysis.Name == "correlation", KindJND: analysis.Name == "accuracy", KindPreference: false} {
+			note := fmt.Sprintf("with %v evaluations of a study mixing", kind)
+			if got := strings.Contains(section, note); got != want {
+				t.Errorf("%v of the mixed bundle contains %q = %v, want %v:\n%s", analysis.Name, note, got, want, section)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// LevelWarning is a problem with the level of a reference or distortion.
+type LevelWarning struct {
+	// Reference is the name of the reference.
+	Reference string
+	// Distortion is the name of the distortion, or empty if the warning is about the reference.
+	Distortion string
+	// Path is the path to the audio file.
+	Path    string
+	Warning audio.Warning
+}
+
+func (l LevelWarning) String() string {
+	return fmt.Sprintf("%s: %v", l.Path, l.Warning)
+}
+
+// LevelWarnings is a slice of level warnings.
+type LevelWarnings []LevelWarning
+
+// CheckLevels returns the warnings the check produces for the loaded audio of the reference, or of the distortion
+// if it isn't nil.
+func CheckLevels(check audio.LevelCheck, ref *Reference, dist *Distortion, a *audio.Audio) LevelWarnings {
+	result := LevelWarnings{}
+	warning := LevelWarning{Reference: ref.Name, Path: ref.Path}
+	if dist != nil {
+		warning.Distortion, warning.Path = dist.Name, dist.Path
+	}
+	for _, levelWarning := range check.Check(a) {
+		warning.Warning = levelWarning
+		result = append(result, warning)
+	}
+	return result
+}
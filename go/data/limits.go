@@ -0,0 +1,141 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// residentMemory returns the resident set size of the process in bytes.
+//
+// Where /proc isn't available it returns the memory the Go runtime got from the OS and hasn't released, which
+// misses memory allocated by C code.
+func residentMemory() uint64 {
+	if b, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := bytes.Fields(b); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(string(fields[1]), 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	stats := &runtime.MemStats{}
+	runtime.ReadMemStats(stats)
+	return stats.Sys - stats.HeapReleased
+}
+
+// memoryGate makes jobs wait while the resident memory of the process exceeds a limit.
+type memoryGate struct {
+	maxBytes uint64
+	cond     *sync.Cond
+	// running is the number of jobs that have acquired the gate and not released it.
+	running int
+	warned  bool
+}
+
+func newMemoryGate(maxBytes uint64) *memoryGate {
+	if maxBytes == 0 {
+		return nil
+	}
+	return &memoryGate{maxBytes: maxBytes, cond: sync.NewCond(&sync.Mutex{})}
+}
+
+// acquire waits until the resident memory is below the limit, or returns an error if it isn't even though no
+// other jobs are running, since then waiting wouldn't help.
+func (m *memoryGate) acquire() error {
+	if m == nil {
+		return nil
+	}
+	m.cond.L.Lock()
+	defer m.cond.L.Unlock()
+	for {
+		if residentMemory() < m.maxBytes {
+			m.running++
+			return nil
+		}
+		runtime.GC()
+		debug.FreeOSMemory()
+		rss := residentMemory()
+		if rss < m.maxBytes {
+			continue
+		}
+		if m.running == 0 {
+			return fmt.Errorf("resident memory is %v MiB with no measurements running, which exceeds the max memory of %v MiB; increase the max memory", rss>>20, m.maxBytes>>20)
+		}
+		if !m.warned {
+			logging.Warningf("Resident memory is %v MiB, which exceeds the max memory of %v MiB, pausing new measurements until running measurements finish. Decrease the number of workers, or increase the max memory, to avoid this.", rss>>20, m.maxBytes>>20)
+			m.warned = true
+		}
+		m.cond.Wait()
+	}
+}
+
+// release marks a job that acquired the gate as finished, and wakes up waiting jobs.
+func (m *memoryGate) release() {
+	if m == nil {
+		return
+	}
+	m.cond.L.Lock()
+	defer m.cond.L.Unlock()
+	m.running--
+	m.cond.Broadcast()
+}
+
+// sharedAudio is audio loaded once, by the first of a known number of users, and dropped when the last user is
+// done with it, so that it's kept in memory exactly as long as it's needed.
+type sharedAudio struct {
+	load  func() (*audio.Audio, error)
+	lock  sync.Mutex
+	users int
+	// loaded is whether load has been called.
+	loaded bool
+	audio  *audio.Audio
+	err    error
+}
+
+func newSharedAudio(users int, load func() (*audio.Audio, error)) *sharedAudio {
+	return &sharedAudio{load: load, users: users}
+}
+
+// get returns the audio, loading it if it isn't already loaded, and whether this call loaded it. Other users
+// wait while it's being loaded.
+func (s *sharedAudio) get() (*audio.Audio, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.loaded {
+		return s.audio, false, s.err
+	}
+	s.audio, s.err = s.load()
+	s.loaded = true
+	return s.audio, true, s.err
+}
+
+// release marks a user as done with the audio, and drops the audio if it was the last user.
+func (s *sharedAudio) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.users--
+	if s.users == 0 {
+		s.audio = nil
+	}
+}
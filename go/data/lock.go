@@ -0,0 +1,136 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockFile is the name of the file in the study directory that processes writing to the study lock.
+const lockFile = "lock"
+
+// errLocked is returned by lockExclusive when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// Owner identifies a process writing to a study.
+type Owner struct {
+	Host    string
+	PID     int
+	Command string
+	Time    time.Time
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("%q (pid %v on %v) at %v", o.Command, o.PID, o.Host, o.Time.Format(time.RFC3339))
+}
+
+// currentOwner returns the owner record of this process.
+func currentOwner() Owner {
+	host, _ := os.Hostname()
+	return Owner{
+		Host:    host,
+		PID:     os.Getpid(),
+		Command: strings.Join(os.Args, " "),
+		Time:    time.Now(),
+	}
+}
+
+// Lock acquires an exclusive advisory lock of the study, held until Unlock or Close, and returns an error
+// identifying the holder if another process holds it.
+//
+// Processes modifying the scores of a study, like calculation, lock it to avoid overwriting the results of
+// each other.
+func (s *Study) Lock() error {
+	if s.lock != nil {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockExclusive(f); err != nil {
+		defer f.Close()
+		if err != errLocked {
+			return fmt.Errorf("trying to lock %q: %v", s.dir, err)
+		}
+		holder := Owner{}
+		if b, err := io.ReadAll(f); err == nil && json.Unmarshal(b, &holder) == nil {
+			return fmt.Errorf("%q is locked by %v", s.dir, holder)
+		}
+		return fmt.Errorf("%q is locked by another process", s.dir)
+	}
+	b, err := json.Marshal(currentOwner())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteAt(b, 0); err != nil {
+		f.Close()
+		return err
+	}
+	s.lock = f
+	return nil
+}
+
+// Unlock releases the lock acquired by Lock.
+func (s *Study) Unlock() error {
+	if s.lock == nil {
+		return nil
+	}
+	f := s.lock
+	s.lock = nil
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LastWriter returns the process that last stored references in the study, or nil if it's unknown.
+func (s *Study) LastWriter() (*Owner, error) {
+	var value string
+	if err := s.db.QueryRow("SELECT VALUE FROM METADATA WHERE KEY = 'last_writer'").Scan(&value); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	result := &Owner{}
+	if err := json.Unmarshal([]byte(value), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// recordWriter stores this process as the last writer of the study.
+func recordWriter(tx *sql.Tx) error {
+	b, err := json.Marshal(currentOwner())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO METADATA (KEY, VALUE) VALUES ('last_writer', ?) ON CONFLICT (KEY) DO UPDATE SET VALUE = excluded.VALUE", string(b))
+	return err
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	first, err := OpenStudy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := OpenStudy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if err := first.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Lock(); err != nil {
+		t.Errorf("locking a study again returned %v", err)
+	}
+	err = second.Lock()
+	if err == nil {
+		t.Fatalf("locking a locked study returned no error")
+	}
+	if want := currentOwner().Command; !strings.Contains(err.Error(), want) {
+		t.Errorf("locking a locked study returned %q, want the holder %q", err, want)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Lock(); err != nil {
+		t.Errorf("locking an unlocked study returned %v", err)
+	}
+
+	if writer, err := second.LastWriter(); err != nil || writer != nil {
+		t.Errorf("LastWriter of a new study = %v, %v, want nil", writer, err)
+	}
+	if err := second.Put([]*Reference{{Name: "ref", Path: "ref.wav"}}); err != nil {
+		t.Fatal(err)
+	}
+	writer, err := first.LastWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer == nil || writer.PID != os.Getpid() {
+		t.Errorf("LastWriter = %v, want this process", writer)
+	}
+}
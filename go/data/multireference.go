@@ -0,0 +1,96 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// MultiReferencePolicy defines how the scores of a distortion against each of its acceptable references are
+// combined into a single score.
+type MultiReferencePolicy string
+
+const (
+	// MultiReferenceBest uses the best score, i.e. the max of score types where higher is better and the min of
+	// the others, which are assumed to be distances.
+	MultiReferenceBest MultiReferencePolicy = "best"
+	// MultiReferenceMean uses the mean score.
+	MultiReferenceMean MultiReferencePolicy = "mean"
+)
+
+// MultiReferencePolicies are the valid multi reference policies.
+var MultiReferencePolicies = []MultiReferencePolicy{MultiReferenceBest, MultiReferenceMean}
+
+// Apply returns the combined score of the given type from the scores against each reference.
+func (m MultiReferencePolicy) Apply(scoreType ScoreType, scores []float64) (float64, error) {
+	if len(scores) == 0 {
+		return 0, fmt.Errorf("no scores to combine")
+	}
+	switch m {
+	case "", MultiReferenceBest:
+		result := scores[0]
+		for _, score := range scores[1:] {
+			if scoreType.Better() > 0 {
+				result = max(result, score)
+			} else {
+				result = min(result, score)
+			}
+		}
+		return result, nil
+	case MultiReferenceMean:
+		sum := 0.0
+		for _, score := range scores {
+			sum += score
+		}
+		return sum / float64(len(scores)), nil
+	}
+	return 0, fmt.Errorf("unknown multi reference policy %q, want one of %v", m, MultiReferencePolicies)
+}
+
+// LoadAlternativeReferences returns the audio of the alternative references of this distortion.
+func (d *Distortion) LoadAlternativeReferences(dir string) ([]*audio.Audio, error) {
+	result := make([]*audio.Audio, len(d.AlternativeReferences))
+	for index, path := range d.AlternativeReferences {
+		var err error
+		if result[index], err = aio.Load(Resolve(dir, path)); err != nil {
+			return nil, fmt.Errorf("trying to load alternative reference %q of %q: %v", path, d.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// measure returns the score of the distortion against each of the references, restricted to its segments if it
+// has any, combined using the policy.
+func (d *Distortion) measure(measurement Measurement, references []*audio.Audio, distortion *audio.Audio, scoreType ScoreType, policy MultiReferencePolicy) (float64, error) {
+	scores := make([]float64, len(references))
+	for index, reference := range references {
+		var err error
+		if len(d.Segments) > 0 {
+			scores[index], err = d.Segments.Measure(reference, distortion, measurement)
+		} else {
+			scores[index], err = measurement(reference, distortion)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(scores) == 1 {
+		return scores[0], nil
+	}
+	return policy.Apply(scoreType, scores)
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+)
+
+// Normalization defines how MOS scores are normalized to reduce differences in how listeners in different labs
+// or sessions used the MOS scale.
+type Normalization string
+
+const (
+	// NormalizationNone uses the MOS scores as they are.
+	NormalizationNone Normalization = "none"
+	// NormalizationZScore subtracts the mean and divides by the standard deviation of the MOS scores of each group.
+	NormalizationZScore Normalization = "zscore"
+	// NormalizationHiddenReference subtracts the mean MOS score of the hidden references of each group, i.e.
+	// produces difference MOS scores relative to the hidden references.
+	NormalizationHiddenReference Normalization = "hidden_reference"
+)
+
+// Normalizations are the valid normalizations.
+var Normalizations = []Normalization{NormalizationNone, NormalizationZScore, NormalizationHiddenReference}
+
+// HiddenReferenceParameter is the generation parameter that marks a distortion as a hidden reference when "true".
+//
+// Distortions with the same path as their reference are hidden references as well.
+const HiddenReferenceParameter = "hidden_reference"
+
+// IsHiddenReference returns whether the distortion is the reference itself, presented to the listeners as one of
+// the distortions.
+func (r *Reference) IsHiddenReference(dist *Distortion) bool {
+	if dist.Path == r.Path {
+		return true
+	}
+	return dist.Generation != nil && dist.Generation.Parameters[HiddenReferenceParameter] == "true"
+}
+
+// NormalizeMOS returns a copy of the bundle with the MOS scores normalized within each group of distortions with
+// the same value of the attribute, which is "reference" or a generation parameter like "session", or within the
+// entire bundle if the attribute is empty.
+func (r *ReferenceBundle) NormalizeMOS(normalization Normalization, attribute string) (*ReferenceBundle, error) {
+	if _, found := r.ScoreTypes[MOS]; !found || normalization == NormalizationNone {
+		return r, nil
+	}
+	group := func(*Reference, *Distortion) string { return "" }
+	if attribute != "" {
+		var err error
+		if group, err = r.stratifierFor(attribute, 1); err != nil {
+			return nil, err
+		}
+	}
+	type stats struct {
+		count        int
+		sum          float64
+		sumOfSquares float64
+		hiddenCount  int
+		hiddenSum    float64
+	}
+	groupStats := map[string]*stats{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			mos, found := dist.Scores[MOS]
+			if !found {
+				continue
+			}
+			key := group(ref, dist)
+			s, found := groupStats[key]
+			if !found {
+				s = &stats{}
+				groupStats[key] = s
+			}
+			s.count++
+			s.sum += mos
+			s.sumOfSquares += mos * mos
+			if ref.IsHiddenReference(dist) {
+				s.hiddenCount++
+				s.hiddenSum += mos
+			}
+		}
+	}
+	normalize := map[string]func(float64) float64{}
+	for key, s := range groupStats {
+		switch normalization {
+		case NormalizationZScore:
+			mean := s.sum / float64(s.count)
+			stdDev := math.Sqrt(math.Max(0, s.sumOfSquares/float64(s.count)-mean*mean))
+			normalize[key] = func(mos float64) float64 {
+				if stdDev == 0 {
+					return 0
+				}
+				return (mos - mean) / stdDev
+			}
+		case NormalizationHiddenReference:
+			if s.hiddenCount == 0 {
+				return nil, fmt.Errorf("no hidden references with MOS scores in %q in group %q", r.Dir, key)
+			}
+			hiddenMean := s.hiddenSum / float64(s.hiddenCount)
+			normalize[key] = func(mos float64) float64 {
+				return mos - hiddenMean
+			}
+		default:
+			return nil, fmt.Errorf("unknown normalization %q, want one of %v", normalization, Normalizations)
+		}
+	}
+	result := &ReferenceBundle{
+		Dir:        r.Dir,
+		ScoreTypes: map[ScoreType]int{},
+	}
+	for _, ref := range r.References {
+		newRef := &Reference{Name: ref.Name, Path: ref.Path}
+		for _, dist := range ref.Distortions {
+			newDist := *dist
+			if mos, found := dist.Scores[MOS]; found {
+				newDist.Scores = map[ScoreType]float64{}
+				for scoreType, score := range dist.Scores {
+					newDist.Scores[scoreType] = score
+				}
+				newDist.Scores[MOS] = normalize[group(ref, dist)](mos)
+			}
+			newRef.Distortions = append(newRef.Distortions, &newDist)
+		}
+		result.Add(newRef)
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeMOS(t *testing.T) {
+	bundle := sessionBundle()
+	if same, err := bundle.NormalizeMOS(NormalizationNone, "session"); err != nil || same != bundle {
+		t.Errorf("NormalizeMOS(%v) = %v, %v, want the bundle itself", NormalizationNone, same, err)
+	}
+
+	zScored, err := bundle.NormalizeMOS(NormalizationZScore, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both sessions have MOS scores x-1, x, x+1, which z-score to the same values.
+	want := math.Sqrt(1.5)
+	for index, dist := range zScored.References[0].Distortions {
+		if got := dist.Scores[MOS]; math.Abs(got-float64(index%3-1)*want) > 1e-9 {
+			t.Errorf("z-scored MOS of %v = %v, want %v", dist.Name, got, float64(index%3-1)*want)
+		}
+		if got := dist.Scores["Metric"]; got != bundle.References[0].Distortions[index].Scores["Metric"] {
+			t.Errorf("z-scoring changed the metric of %v to %v", dist.Name, got)
+		}
+	}
+	if got := bundle.References[0].Distortions[0].Scores[MOS]; got != 1 {
+		t.Errorf("z-scoring modified the original bundle, MOS = %v", got)
+	}
+
+	if _, err := bundle.NormalizeMOS(NormalizationHiddenReference, "session"); err == nil {
+		t.Errorf("hidden reference normalization without hidden references returned no error")
+	}
+	ref := bundle.References[0]
+	hidden := sessionDistortion("hidden", "a", map[ScoreType]float64{MOS: 4.5, "Metric": 40})
+	hidden.Path = ref.Path
+	marked := sessionDistortion("marked", "b", map[ScoreType]float64{MOS: 5.5, "Metric": 4})
+	marked.Generation.Parameters[HiddenReferenceParameter] = "true"
+	if !ref.IsHiddenReference(hidden) || !ref.IsHiddenReference(marked) || ref.IsHiddenReference(ref.Distortions[0]) {
+		t.Errorf("IsHiddenReference doesn't detect hidden references by path and generation parameter")
+	}
+	ref.Distortions = append(ref.Distortions, hidden, marked)
+	withHidden := bundleOf(ref)
+	normalized, err := withHidden.NormalizeMOS(NormalizationHiddenReference, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, dist := range normalized.References[0].Distortions {
+		hiddenMOS := 4.5
+		if dist.Generation.Parameters["session"] == "b" {
+			hiddenMOS = 5.5
+		}
+		if got, want := dist.Scores[MOS], withHidden.References[0].Distortions[index].Scores[MOS]-hiddenMOS; math.Abs(got-want) > 1e-9 {
+			t.Errorf("MOS of %v relative to the hidden reference = %v, want %v", dist.Name, got, want)
+		}
+	}
+
+	if _, err := bundle.NormalizeMOS(Normalization("minmax"), ""); err == nil {
+		t.Errorf("unknown normalization returned no error")
+	}
+}
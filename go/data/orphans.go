@@ -0,0 +1,163 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+)
+
+// AudioExtensions are the extensions of the files OrphanedAudio considers audio files.
+var AudioExtensions = []string{".wav", ".flac", ".mp3", ".ogg", ".opus", ".m4a", ".aac", ".aiff"}
+
+// OrphanedFile is an audio file in a study directory that no reference, distortion, or alternative reference
+// refers to.
+type OrphanedFile struct {
+	// Path is the path of the file relative to the study directory.
+	Path string
+	// Size is the size of the file in bytes.
+	Size int64
+}
+
+// OrphanedFiles is a slice of orphaned files.
+type OrphanedFiles []OrphanedFile
+
+// Render returns a representation of the orphaned files in the format.
+func (o OrphanedFiles) Render(format Format) string {
+	table := Table{Row{"Path", "Size (bytes)"}, nil}
+	total := int64(0)
+	for _, file := range o {
+		table = append(table, Row{file.Path, fmt.Sprint(file.Size)})
+		total += file.Size
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, fmt.Sprintf("%v orphaned audio files with %v MiB", len(o), total>>20)), table.Render(format))
+}
+
+// OrphanedAudio returns the audio files in the study directory and its subdirectories, except the snapshots, that
+// no reference, distortion, or alternative reference refers to, ordered by path.
+func (s *Study) OrphanedAudio() (OrphanedFiles, error) {
+	referenced := map[string]bool{}
+	add := func(path string) {
+		if !aio.IsRemote(path) {
+			referenced[filepath.Clean(Resolve(s.dir, path))] = true
+		}
+	}
+	if err := s.ViewEachReference(func(ref *Reference) error {
+		add(ref.Path)
+		for _, dist := range ref.Distortions {
+			add(dist.Path)
+			for _, path := range dist.AlternativeReferences {
+				add(path)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	result := OrphanedFiles{}
+	if err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == filepath.Join(s.dir, snapshotDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !slices.Contains(AudioExtensions, strings.ToLower(filepath.Ext(path))) || referenced[filepath.Clean(path)] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		result = append(result, OrphanedFile{Path: rel, Size: info.Size()})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
+
+// RemoveAudio removes the files, with paths relative to the study directory, e.g. those returned by OrphanedAudio.
+func (s *Study) RemoveAudio(files OrphanedFiles) error {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(s.dir, file.Path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveAudio moves the files, with paths relative to the study directory, e.g. those returned by OrphanedAudio,
+// to the same paths relative to the archive directory.
+//
+// Files are copied and then removed if they can't be renamed, e.g. when the archive is on another file system.
+func (s *Study) ArchiveAudio(files OrphanedFiles, archive string) error {
+	for _, file := range files {
+		source, destination := filepath.Join(s.dir, file.Path), filepath.Join(archive, file.Path)
+		if _, err := os.Stat(destination); err == nil {
+			return fmt.Errorf("%q already exists, not archiving %q", destination, source)
+		}
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(source, destination); err == nil {
+			continue
+		}
+		if err := copyFile(source, destination); err != nil {
+			return fmt.Errorf("trying to archive %q to %q: %v", source, destination, err)
+		}
+		if err := os.Remove(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the content of the source file to a new destination file.
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(destination)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOrphanedAudio(t *testing.T) {
+	dir := t.TempDir()
+	study, err := OpenStudy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	if err := study.Put([]*Reference{fullReference()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"ref.wav", "dist.wav", "plain.wav", "ref_remaster.wav", "old.wav", "sub/old.FLAC", "notes.txt", "snapshots/kept.wav"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphans, err := study.OrphanedAudio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OrphanedFiles{{Path: "old.wav", Size: 7}, {Path: filepath.Join("sub", "old.FLAC"), Size: 12}}
+	if !reflect.DeepEqual(orphans, want) {
+		t.Errorf("OrphanedAudio() = %+v, want %+v", orphans, want)
+	}
+
+	archive := t.TempDir()
+	if err := study.ArchiveAudio(orphans[1:], archive); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(archive, "sub", "old.FLAC")); err != nil || string(b) != "sub/old.FLAC" {
+		t.Errorf("archived file contains %q, %v, want the orphaned file", b, err)
+	}
+	if err := study.RemoveAudio(orphans[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if orphans, err := study.OrphanedAudio(); err != nil || len(orphans) != 0 {
+		t.Errorf("OrphanedAudio() after archiving and removing = %+v, %v, want none", orphans, err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/zimtohrli/go/aio"
+)
+
+// RelativePaths describes the paths changed by MakePathsRelative.
+type RelativePaths struct {
+	// Rewritten is the number of absolute paths made relative to the study directory.
+	Rewritten int
+	// Outside contains the absolute paths outside the study directory, which were kept.
+	Outside []string
+}
+
+// relativePath returns the absolute path relative to the directory, or false if it's outside the directory.
+func relativePath(dir, path string) (string, bool, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false, err
+	}
+	rel, err := filepath.Rel(absDir, path)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel, true, nil
+	}
+	// The directory or the path may be reached through symbolic links, e.g. /tmp on macOS.
+	realDir, err := filepath.EvalSymlinks(absDir)
+	if err != nil {
+		return "", false, err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false, err
+	}
+	rel, err = filepath.Rel(realDir, realPath)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel, true, nil
+	}
+	return "", false, nil
+}
+
+// MakePathsRelative rewrites the absolute paths of the references, distortions, and alternative references in the
+// study that are inside the study directory to be relative to it, so that the study keeps working when the directory
+// is copied or moved.
+//
+// All local audio files must exist, otherwise an error listing the missing files is returned and nothing is changed.
+func (s *Study) MakePathsRelative() (*RelativePaths, error) {
+	result := &RelativePaths{}
+	missing := []string{}
+	changed := []*Reference{}
+	// relative returns the path relative to the study directory if it's an absolute path inside it.
+	relative := func(path string) (string, error) {
+		if aio.IsRemote(path) {
+			return path, nil
+		}
+		if _, err := os.Stat(Resolve(s.dir, path)); err != nil {
+			missing = append(missing, path)
+			return path, nil
+		}
+		if !filepath.IsAbs(path) {
+			return path, nil
+		}
+		rel, inside, err := relativePath(s.dir, path)
+		if err != nil {
+			return "", err
+		}
+		if !inside {
+			result.Outside = append(result.Outside, path)
+			return path, nil
+		}
+		result.Rewritten++
+		return rel, nil
+	}
+	if err := s.ViewEachReference(func(ref *Reference) error {
+		rewritten := result.Rewritten
+		var err error
+		if ref.Path, err = relative(ref.Path); err != nil {
+			return err
+		}
+		for _, dist := range ref.Distortions {
+			if dist.Path, err = relative(dist.Path); err != nil {
+				return err
+			}
+			for index := range dist.AlternativeReferences {
+				if dist.AlternativeReferences[index], err = relative(dist.AlternativeReferences[index]); err != nil {
+					return err
+				}
+			}
+		}
+		if result.Rewritten > rewritten {
+			changed = append(changed, ref)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%v audio files of %q are missing: %v", len(missing), s.dir, strings.Join(missing, ", "))
+	}
+	if len(changed) > 0 {
+		if err := s.Put(changed); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMakePathsRelative(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	for _, path := range []string{filepath.Join(dir, "ref.wav"), filepath.Join(dir, "sub", "dist.wav"), filepath.Join(dir, "alt.wav"), filepath.Join(outside, "plain.wav")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	study, err := OpenStudy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	ref := &Reference{
+		Name: "ref",
+		Path: filepath.Join(dir, "ref.wav"),
+		Distortions: []*Distortion{
+			{Name: "dist", Path: filepath.Join(dir, "sub", "dist.wav"), Scores: map[ScoreType]float64{}, AlternativeReferences: []string{"alt.wav"}},
+			{Name: "plain", Path: filepath.Join(outside, "plain.wav"), Scores: map[ScoreType]float64{}},
+		},
+	}
+	if err := study.Put([]*Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{ref.Path, ref.Distortions[0].AlternativeReferences[0]} {
+		if got := Resolve(dir, path); got != filepath.Join(dir, filepath.Base(path)) {
+			t.Errorf("Resolve(%q, %q) = %q, want the file in the study directory", dir, path, got)
+		}
+	}
+
+	paths, err := study.MakePathsRelative()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&RelativePaths{Rewritten: 2, Outside: []string{filepath.Join(outside, "plain.wav")}}); !reflect.DeepEqual(paths, want) {
+		t.Errorf("MakePathsRelative() = %+v, want %+v", paths, want)
+	}
+	got := references(t, study)[0]
+	if got.Path != "ref.wav" || got.Distortions[0].Path != filepath.Join("sub", "dist.wav") || got.Distortions[0].AlternativeReferences[0] != "alt.wav" || got.Distortions[1].Path != filepath.Join(outside, "plain.wav") {
+		t.Errorf("got paths %q, %q, %q, and %q, want the paths inside the study directory relative to it", got.Path, got.Distortions[0].Path, got.Distortions[0].AlternativeReferences[0], got.Distortions[1].Path)
+	}
+
+	// Nothing is changed while files are missing.
+	ref.Path = filepath.Join(dir, "missing.wav")
+	if err := study.Put([]*Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := study.MakePathsRelative(); err == nil {
+		t.Errorf("MakePathsRelative() with a missing file returned no error")
+	}
+	if got := references(t, study)[0]; got.Distortions[0].Path != filepath.Join(dir, "sub", "dist.wav") {
+		t.Errorf("MakePathsRelative() with a missing file changed %q", got.Distortions[0].Path)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dgryski/go-onlinestats"
+)
+
+// PreferenceAgreement contains how often a metric picks the same winner as the listeners in pairwise preference
+// evaluations.
+type PreferenceAgreement struct {
+	ScoreType ScoreType
+	// Wins is the number of pairs where the metric picks the distortion the listeners preferred.
+	Wins int
+	// Ties is the number of pairs where the listeners or the metric don't prefer either distortion.
+	Ties int
+	// Losses is the number of pairs where the metric picks the distortion the listeners didn't prefer.
+	Losses int
+	// Accuracy is Wins / (Wins + Losses).
+	Accuracy float64
+}
+
+// PreferenceAgreements contains the preference agreements of multiple score types.
+type PreferenceAgreements []PreferenceAgreement
+
+// Render returns a representation of the preference agreements in the format.
+func (p PreferenceAgreements) Render(format Format) string {
+	table := Table{Row{"Score type", "Accuracy", "Wins", "Ties", "Losses"}, nil}
+	for _, agreement := range p {
+		table = append(table, Row{string(agreement.ScoreType), fmt.Sprintf("%.2f", agreement.Accuracy), fmt.Sprint(agreement.Wins), fmt.Sprint(agreement.Ties), fmt.Sprint(agreement.Losses)})
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, "Agreement with the listeners' pairwise preferences per score type"), table.Render(format))
+}
+
+// preferencePairs returns the distortion pairs of the references in a pairwise preference bundle.
+func (r *ReferenceBundle) preferencePairs() ([][2]*Distortion, error) {
+	if kind := r.Kind(); kind != KindPreference {
+		return nil, fmt.Errorf("cannot compute preference agreement on %v references", kind)
+	}
+	result := [][2]*Distortion{}
+	for _, ref := range r.References {
+		pair := []*Distortion{}
+		for _, dist := range ref.Distortions {
+			if _, found := dist.Scores[Preference]; found {
+				pair = append(pair, dist)
+			}
+		}
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("reference %q has %v distortions with %v scores, want 2", ref.Name, len(pair), Preference)
+		}
+		result = append(result, [2]*Distortion{pair[0], pair[1]})
+	}
+	return result, nil
+}
+
+// PreferenceAgreement returns how often each score type picks the same winner as the listeners, ordered by
+// decreasing accuracy.
+//
+// Each reference must have two distortions with Preference scores. Score types without a defined direction, see
+// ScoreType.Better, are assumed to be better when higher if they correlate positively with the preferences.
+func (r *ReferenceBundle) PreferenceAgreement() (PreferenceAgreements, error) {
+	pairs, err := r.preferencePairs()
+	if err != nil {
+		return nil, err
+	}
+	result := PreferenceAgreements{}
+	for _, scoreType := range r.analyzedTypes() {
+		if scoreType == Preference {
+			continue
+		}
+		better := scoreType.Better()
+		if better == 0 {
+			preferences, scores := []float64{}, []float64{}
+			for _, pair := range pairs {
+				for _, dist := range pair {
+					if score, found := dist.Scores[scoreType]; found {
+						preferences = append(preferences, dist.Scores[Preference])
+						scores = append(scores, score)
+					}
+				}
+			}
+			better = 1
+			if correlation, _ := onlinestats.Spearman(preferences, scores); correlation < 0 {
+				better = -1
+			}
+		}
+		agreement := PreferenceAgreement{ScoreType: scoreType}
+		for _, pair := range pairs {
+			scoreA, foundA := pair[0].Scores[scoreType]
+			scoreB, foundB := pair[1].Scores[scoreType]
+			if !foundA || !foundB {
+				continue
+			}
+			humanChoice := compareFloats(pair[0].Scores[Preference], pair[1].Scores[Preference])
+			metricChoice := better * compareFloats(scoreA, scoreB)
+			switch {
+			case humanChoice == 0 || metricChoice == 0:
+				agreement.Ties++
+			case humanChoice == metricChoice:
+				agreement.Wins++
+			default:
+				agreement.Losses++
+			}
+		}
+		if decided := agreement.Wins + agreement.Losses; decided > 0 {
+			agreement.Accuracy = float64(agreement.Wins) / float64(decided)
+		}
+		result = append(result, agreement)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Accuracy > result[j].Accuracy
+	})
+	return result, nil
+}
+
+// compareFloats returns 1 if a > b, -1 if a < b, and 0 otherwise.
+func compareFloats(a, b float64) int {
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	}
+	return 0
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "preference",
+		Description: "How often each score type picks the same winner as the listeners in pairwise preference studies.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			if bundle = bundle.ofKind(KindPreference); bundle == nil {
+				return "", nil
+			}
+			agreements, err := bundle.PreferenceAgreement()
+			if err != nil {
+				return "", err
+			}
+			return bundle.subsetNote(opts.Format) + agreements.Render(opts.Format), nil
+		},
+	})
+}
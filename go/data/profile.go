@@ -0,0 +1,167 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// MetricProfile describes the scores of an external metric, so that they are validated when calculated and
+// evaluated in the right direction by the accuracy, preference, and leaderboard analyses.
+type MetricProfile struct {
+	ScoreType ScoreType
+	// Better is 1 if higher scores are better, and -1 if lower scores are better.
+	Better int
+	// Min and Max are the range of valid scores, or both zero if any finite score is valid.
+	Min float64
+	Max float64
+}
+
+func (m MetricProfile) String() string {
+	direction := "higher"
+	if m.Better < 0 {
+		direction = "lower"
+	}
+	if m.Min == 0 && m.Max == 0 {
+		return fmt.Sprintf("%v, %s is better", m.ScoreType, direction)
+	}
+	return fmt.Sprintf("%v in [%v, %v], %s is better", m.ScoreType, m.Min, m.Max, direction)
+}
+
+// Validate returns an error if the score is outside the valid range of the profile.
+func (m MetricProfile) Validate(score float64) error {
+	if (m.Min != 0 || m.Max != 0) && (score < m.Min || score > m.Max) {
+		return fmt.Errorf("%v score %v outside valid range [%v, %v]", m.ScoreType, score, m.Min, m.Max)
+	}
+	return nil
+}
+
+var (
+	// metricProfiles contains the profiles of external metrics by score type.
+	metricProfiles = map[ScoreType]MetricProfile{
+		// The MOS-LQO mappings of P.862.1 and P.862.2 approach 0.999 and 4.999, though raw PESQ scores in
+		// [-0.5, 4.5] map to at most 4.55 for narrowband and 4.64 for wideband.
+		PESQ: {ScoreType: PESQ, Better: 1, Min: 0.999, Max: 4.999},
+		// P.863 MOS-LQO is at most 4.5 in narrowband mode and 4.75 in super-wideband mode.
+		POLQA: {ScoreType: POLQA, Better: 1, Min: 1, Max: 4.75},
+	}
+	metricProfilesLock sync.RWMutex
+)
+
+// RegisterMetricProfile registers the profile of an external metric. Registering a profile that conflicts with
+// the already registered profile of the score type is an error, since it means one of them is wrong.
+func RegisterMetricProfile(profile MetricProfile) error {
+	if profile.ScoreType == "" {
+		return fmt.Errorf("metric profile %+v has no score type", profile)
+	}
+	if profile.Better != 1 && profile.Better != -1 {
+		return fmt.Errorf("metric profile %+v has Better %v, want 1 or -1", profile, profile.Better)
+	}
+	if profile.Min > profile.Max {
+		return fmt.Errorf("metric profile %+v has Min > Max", profile)
+	}
+	if builtIn := profile.ScoreType.builtInBetter(); builtIn != 0 {
+		return fmt.Errorf("%v is not an external metric", profile.ScoreType)
+	}
+	metricProfilesLock.Lock()
+	defer metricProfilesLock.Unlock()
+	if existing, found := metricProfiles[profile.ScoreType]; found && existing != profile {
+		return fmt.Errorf("metric profile %v conflicts with the registered profile %v", profile, existing)
+	}
+	metricProfiles[profile.ScoreType] = profile
+	return nil
+}
+
+// MetricProfile returns the registered profile of the score type, if any.
+func (s ScoreType) MetricProfile() (MetricProfile, bool) {
+	metricProfilesLock.RLock()
+	defer metricProfilesLock.RUnlock()
+	profile, found := metricProfiles[s]
+	return profile, found
+}
+
+// ValidateScore returns an error if the score type has a registered profile, and the score is outside its valid
+// range.
+func (s ScoreType) ValidateScore(score float64) error {
+	if profile, found := s.MetricProfile(); found {
+		return profile.Validate(score)
+	}
+	return nil
+}
+
+// registeredMetricProfiles returns a copy of the registered profiles, e.g. to make them part of cache keys.
+func registeredMetricProfiles() map[ScoreType]MetricProfile {
+	metricProfilesLock.RLock()
+	defer metricProfilesLock.RUnlock()
+	result := map[ScoreType]MetricProfile{}
+	for scoreType, profile := range metricProfiles {
+		result[scoreType] = profile
+	}
+	return result
+}
+
+// metricProfileMetadataKeyPrefix is the prefix of the METADATA keys of the metric profiles of a study.
+const metricProfileMetadataKeyPrefix = "metric_profile/"
+
+// putMetricProfiles records the registered profiles of the score types in the METADATA table, so that studies
+// with scores of external metrics keep evaluating them in the right direction without the metric.
+func putMetricProfiles(tx *sql.Tx, scoreTypes ScoreTypes) error {
+	for _, scoreType := range scoreTypes {
+		profile, found := scoreType.MetricProfile()
+		if !found {
+			continue
+		}
+		b, err := json.Marshal(profile)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO METADATA (KEY, VALUE) VALUES (?, ?) ON CONFLICT (KEY) DO UPDATE SET VALUE = excluded.VALUE", metricProfileMetadataKeyPrefix+string(scoreType), string(b)); err != nil {
+			return fmt.Errorf("trying to record metric profile %v: %v", profile, err)
+		}
+	}
+	return nil
+}
+
+// registerStudyMetricProfiles registers the metric profiles recorded in the METADATA table of the study in dir.
+//
+// Recorded profiles conflicting with the registered profiles are logged and ignored, so that a study measured with
+// an older version of a metric can still be opened.
+func registerStudyMetricProfiles(db *sql.DB, dir string) error {
+	rows, err := db.Query("SELECT KEY, VALUE FROM METADATA WHERE KEY LIKE ? ORDER BY KEY", metricProfileMetadataKeyPrefix+"%")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		profile := MetricProfile{}
+		if err := json.Unmarshal([]byte(value), &profile); err != nil {
+			return fmt.Errorf("trying to parse metric profile %q of %q: %v", strings.TrimPrefix(key, metricProfileMetadataKeyPrefix), dir, err)
+		}
+		if err := RegisterMetricProfile(profile); err != nil {
+			logging.Warningf("Ignoring the metric profile recorded in %q: %v", dir, err)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"testing"
+)
+
+func TestStudyMetricProfiles(t *testing.T) {
+	profile := MetricProfile{ScoreType: "ProfiledMetric", Better: -1, Min: 0, Max: 1}
+	if err := RegisterMetricProfile(profile); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	study, err := OpenStudy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := study.Put([]*Reference{{Name: "ref", Path: "ref.wav", Distortions: []*Distortion{{Name: "dist", Path: "dist.wav", Scores: map[ScoreType]float64{MOS: 3, profile.ScoreType: 0.5}}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := study.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the study registers the recorded profile, like in a process that never ran the metric.
+	metricProfilesLock.Lock()
+	delete(metricProfiles, profile.ScoreType)
+	metricProfilesLock.Unlock()
+	if study, err = OpenStudy(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	if got, found := profile.ScoreType.MetricProfile(); !found || got != profile {
+		t.Errorf("MetricProfile() = %v, %v, want %v, true", got, found, profile)
+	}
+	if got := profile.ScoreType.Better(); got != -1 {
+		t.Errorf("Better() = %v, want -1", got)
+	}
+}
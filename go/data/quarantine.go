@@ -0,0 +1,109 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// isFinite returns whether the score is neither NaN nor infinite.
+func isFinite(score float64) bool {
+	return !math.IsNaN(score) && !math.IsInf(score, 0)
+}
+
+// QuarantinedScore is a NaN or infinite measurement result that was not stored.
+type QuarantinedScore struct {
+	Reference  string
+	Distortion string
+	ScoreType  ScoreType
+	// Score is the NaN or infinite result.
+	Score float64
+	Time  time.Time
+}
+
+func (q QuarantinedScore) String() string {
+	return fmt.Sprintf("%v score %v of %q in %q at %v", q.ScoreType, q.Score, q.Distortion, q.Reference, q.Time.Format(time.RFC3339))
+}
+
+// QuarantinedScores is a slice of quarantined scores.
+type QuarantinedScores []QuarantinedScore
+
+// Render returns a representation of the quarantined scores in the format.
+func (q QuarantinedScores) Render(format Format) string {
+	table := Table{Row{"Reference", "Distortion", "Score type", "Score", "Time"}, nil}
+	for _, score := range q {
+		table = append(table, Row{score.Reference, score.Distortion, string(score.ScoreType), fmt.Sprint(score.Score), score.Time.Format(time.RFC3339)})
+	}
+	return fmt.Sprintf("%s%s", format.Heading(3, "Quarantined non-finite scores"), table.Render(format))
+}
+
+// Quarantine adds the scores to the quarantine list of the study, replacing earlier entries of the same
+// reference, distortion, and score type.
+//
+// Entries are removed when a finite score of their distortion and score type is stored.
+func (s *Study) Quarantine(scores QuarantinedScores) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := func() error {
+		statement, err := tx.Prepare("INSERT INTO QUARANTINE (REF, DISTORTION, SCORE_TYPE, SCORE, TIME) VALUES (?, ?, ?, ?, ?) ON CONFLICT (REF, DISTORTION, SCORE_TYPE) DO UPDATE SET SCORE = excluded.SCORE, TIME = excluded.TIME")
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+		for _, score := range scores {
+			// SQLite stores NaN as NULL, so the scores are stored as text.
+			if _, err := statement.Exec(score.Reference, score.Distortion, string(score.ScoreType), strconv.FormatFloat(score.Score, 'g', -1, 64), score.Time.UTC().Format(time.RFC3339Nano)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// Quarantined returns the quarantine list of the study, ordered by reference, distortion, and score type.
+func (s *Study) Quarantined() (QuarantinedScores, error) {
+	rows, err := s.db.Query("SELECT REF, DISTORTION, SCORE_TYPE, SCORE, TIME FROM QUARANTINE ORDER BY REF, DISTORTION, SCORE_TYPE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := QuarantinedScores{}
+	for rows.Next() {
+		var score, when string
+		quarantined := QuarantinedScore{}
+		if err := rows.Scan(&quarantined.Reference, &quarantined.Distortion, &quarantined.ScoreType, &score, &when); err != nil {
+			return nil, err
+		}
+		if quarantined.Score, err = strconv.ParseFloat(score, 64); err != nil {
+			return nil, fmt.Errorf("invalid quarantined score %q in %q: %v", score, s.dir, err)
+		}
+		if quarantined.Time, err = time.Parse(time.RFC3339Nano, when); err != nil {
+			return nil, fmt.Errorf("invalid quarantine time %q in %q: %v", when, s.dir, err)
+		}
+		result = append(result, quarantined)
+	}
+	return result, rows.Err()
+}
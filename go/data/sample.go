@@ -0,0 +1,165 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Stratum is a group of distortions with the same values for the attributes they are stratified by.
+type Stratum struct {
+	// Key identifies the stratum, like "codec=opus,MOS=[2.00,3.00)".
+	Key       string
+	Available int
+	Sampled   int
+}
+
+// Strata contains the strata of a stratified sample.
+type Strata []Stratum
+
+// Render returns a representation of the strata in the format.
+func (s Strata) Render(format Format) string {
+	table := Table{Row{"Stratum", "Available", "Sampled"}, nil}
+	available, sampled := 0, 0
+	for _, stratum := range s {
+		table = append(table, Row{stratum.Key, fmt.Sprint(stratum.Available), fmt.Sprint(stratum.Sampled)})
+		available += stratum.Available
+		sampled += stratum.Sampled
+	}
+	return fmt.Sprintf("%s%s%s", format.Heading(3, "Strata"), format.Paragraph(fmt.Sprintf("%v of %v distortions sampled from %v strata", sampled, available, len(s))), table.Render(format))
+}
+
+// stratifier returns the value of an attribute for a distortion.
+type stratifier func(ref *Reference, dist *Distortion) string
+
+// stratifierFor returns the stratifier for the attribute, which is "reference", a score type of the bundle, whose
+// scores are binned into bins equally wide bins, or a generation parameter.
+func (r *ReferenceBundle) stratifierFor(attribute string, bins int) (stratifier, error) {
+	if attribute == "reference" {
+		return func(ref *Reference, _ *Distortion) string {
+			return fmt.Sprintf("reference=%s", ref.Name)
+		}, nil
+	}
+	scoreType := ScoreType(attribute)
+	if _, found := r.ScoreTypes[scoreType]; found {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, ref := range r.References {
+			for _, dist := range ref.Distortions {
+				if score, found := dist.Scores[scoreType]; found {
+					low, high = math.Min(low, score), math.Max(high, score)
+				}
+			}
+		}
+		width := (high - low) / float64(bins)
+		return func(_ *Reference, dist *Distortion) string {
+			score, found := dist.Scores[scoreType]
+			if !found {
+				return fmt.Sprintf("%s=none", scoreType)
+			}
+			bin := 0
+			if width > 0 {
+				bin = min(bins-1, int((score-low)/width))
+			}
+			// The last bin includes the max score.
+			closing := ")"
+			if bin == bins-1 {
+				closing = "]"
+			}
+			return fmt.Sprintf("%s=[%s,%s%s", scoreType, scoreType.FormatScore(low+float64(bin)*width), scoreType.FormatScore(low+float64(bin+1)*width), closing)
+		}, nil
+	}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			if dist.Generation == nil {
+				continue
+			}
+			if _, found := dist.Generation.Parameters[attribute]; found {
+				return func(_ *Reference, dist *Distortion) string {
+					value := ""
+					if dist.Generation != nil {
+						value = dist.Generation.Parameters[attribute]
+					}
+					return fmt.Sprintf("%s=%s", attribute, value)
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%q is neither reference, a score type in %q, nor a generation parameter of any distortion in it", attribute, r.Dir)
+}
+
+// StratifiedSample returns a bundle with at most perStratum randomly chosen distortions from each group of
+// distortions with the same values for the attributes, along with the strata ordered by key.
+//
+// Attributes are "reference", score types of the bundle, whose scores are binned into bins equally wide bins, or
+// generation parameters, like "codec" or "language". Only references with sampled distortions are included, and
+// the distortions keep their order within their references.
+func (r *ReferenceBundle) StratifiedSample(attributes []string, perStratum int, bins int, rng *rand.Rand) (*ReferenceBundle, Strata, error) {
+	if perStratum < 1 || bins < 1 {
+		return nil, nil, fmt.Errorf("perStratum %v and bins %v must be positive", perStratum, bins)
+	}
+	stratifiers := make([]stratifier, len(attributes))
+	for index, attribute := range attributes {
+		var err error
+		if stratifiers[index], err = r.stratifierFor(attribute, bins); err != nil {
+			return nil, nil, err
+		}
+	}
+	members := map[string][]*Distortion{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			values := make([]string, len(stratifiers))
+			for index, stratifier := range stratifiers {
+				values[index] = stratifier(ref, dist)
+			}
+			key := strings.Join(values, ",")
+			members[key] = append(members[key], dist)
+		}
+	}
+	keys := []string{}
+	for key := range members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sampled := map[*Distortion]bool{}
+	strata := Strata{}
+	for _, key := range keys {
+		distortions := members[key]
+		n := min(perStratum, len(distortions))
+		for _, index := range rng.Perm(len(distortions))[:n] {
+			sampled[distortions[index]] = true
+		}
+		strata = append(strata, Stratum{Key: key, Available: len(distortions), Sampled: n})
+	}
+	result := &ReferenceBundle{
+		Dir:        r.Dir,
+		ScoreTypes: map[ScoreType]int{},
+	}
+	for _, ref := range r.References {
+		sampledRef := &Reference{Name: ref.Name, Path: ref.Path}
+		for _, dist := range ref.Distortions {
+			if sampled[dist] {
+				sampledRef.Distortions = append(sampledRef.Distortions, dist)
+			}
+		}
+		if len(sampledRef.Distortions) > 0 {
+			result.Add(sampledRef)
+		}
+	}
+	return result, strata, nil
+}
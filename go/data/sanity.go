@@ -0,0 +1,179 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AnchorParameter is the generation parameter that marks a distortion as an anchor, e.g. the low pass filtered
+// reference of a MUSHRA test, when "true".
+const AnchorParameter = "anchor"
+
+// IsAnchor returns whether the distortion is an anchor, presented to the listeners as a known bad distortion.
+func (d *Distortion) IsAnchor() bool {
+	return d.Generation != nil && d.Generation.Parameters[AnchorParameter] == "true"
+}
+
+// MinSanityPassRate is the fraction of the hidden references, and of the anchors, of a study that must pass the
+// sanity checks of a score type, so that a few noisy listener ratings don't fail MOS.
+const MinSanityPassRate = 0.9
+
+// SanityCheck contains how many hidden references and anchors pass the sanity checks of a score type.
+//
+// A hidden reference passes when no other distortion of its reference scores better, and an anchor passes when it
+// scores worse than the median of the other distortions of its reference, excluding hidden references and anchors.
+type SanityCheck struct {
+	ScoreType              ScoreType
+	HiddenReferences       int
+	HiddenReferencesPassed int
+	Anchors                int
+	AnchorsPassed          int
+}
+
+// Failed returns whether less than MinSanityPassRate of the hidden references or anchors pass the checks.
+func (s SanityCheck) Failed() bool {
+	return float64(s.HiddenReferencesPassed) < MinSanityPassRate*float64(s.HiddenReferences) || float64(s.AnchorsPassed) < MinSanityPassRate*float64(s.Anchors)
+}
+
+// SanityChecks contains the sanity checks of all score types of a bundle.
+type SanityChecks struct {
+	Checks []SanityCheck
+	// Undirected are the score types that aren't checked since it's unknown whether higher or lower is better.
+	Undirected ScoreTypes
+}
+
+// Failed returns the score types that failed the sanity checks.
+func (s *SanityChecks) Failed() ScoreTypes {
+	result := ScoreTypes{}
+	for _, check := range s.Checks {
+		if check.Failed() {
+			result = append(result, check.ScoreType)
+		}
+	}
+	return result
+}
+
+// SanityChecks returns the sanity checks of the hidden references and anchors, e.g. of a MUSHRA study, for each
+// score type of the bundle, ordered by score type.
+//
+// Listeners and metrics should rate the hidden references, which are the references themselves, as near perfect,
+// and the anchors as poor, so score types failing the checks suggest unreliable ratings or broken measurements.
+func (r *ReferenceBundle) SanityChecks() *SanityChecks {
+	result := &SanityChecks{}
+	for _, scoreType := range r.analyzedTypes() {
+		better := float64(scoreType.Better())
+		if better == 0 {
+			result.Undirected = append(result.Undirected, scoreType)
+			continue
+		}
+		check := SanityCheck{ScoreType: scoreType}
+		for _, ref := range r.References {
+			hidden, anchors, others := []float64{}, []float64{}, []float64{}
+			for _, dist := range ref.Distortions {
+				score, found := dist.Scores[scoreType]
+				if !found || !isFinite(score) {
+					continue
+				}
+				switch {
+				case ref.IsHiddenReference(dist):
+					hidden = append(hidden, score)
+				case dist.IsAnchor():
+					anchors = append(anchors, score)
+				default:
+					others = append(others, score)
+				}
+			}
+			if len(hidden) > 0 && len(anchors)+len(others) > 0 {
+				for _, hiddenScore := range hidden {
+					check.HiddenReferences++
+					passed := true
+					for _, score := range append(append([]float64{}, anchors...), others...) {
+						if better*(score-hiddenScore) > 0 {
+							passed = false
+						}
+					}
+					if passed {
+						check.HiddenReferencesPassed++
+					}
+				}
+			}
+			if len(anchors) > 0 && len(others) > 0 {
+				// The median aggregation never fails.
+				median, _ := AggregationMedian.Apply(others)
+				for _, anchorScore := range anchors {
+					check.Anchors++
+					if better*(median-anchorScore) > 0 {
+						check.AnchorsPassed++
+					}
+				}
+			}
+		}
+		if check.HiddenReferences+check.Anchors > 0 {
+			result.Checks = append(result.Checks, check)
+		}
+	}
+	return result
+}
+
+// Render returns a representation of the sanity checks in the format, or an empty string if no score type was
+// checked.
+func (s *SanityChecks) Render(format Format) string {
+	if len(s.Checks) == 0 {
+		return ""
+	}
+	ratio := func(passed, total int) string {
+		if total == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%v/%v", passed, total)
+	}
+	table := Table{Row{"Score type", "Hidden references passed", "Anchors passed", "Result"}, nil}
+	for _, check := range s.Checks {
+		result := "pass"
+		if check.Failed() {
+			result = "fail"
+		}
+		table = append(table, Row{string(check.ScoreType), ratio(check.HiddenReferencesPassed, check.HiddenReferences), ratio(check.AnchorsPassed, check.Anchors), result})
+	}
+	summary := "All score types pass the sanity checks."
+	if failed := s.Failed(); len(failed) > 0 {
+		names := make([]string, len(failed))
+		for index, scoreType := range failed {
+			names[index] = string(scoreType)
+		}
+		summary = fmt.Sprintf("Score types failing the sanity checks: %s.", strings.Join(names, ", "))
+	}
+	if len(s.Undirected) > 0 {
+		names := make([]string, len(s.Undirected))
+		for index, scoreType := range s.Undirected {
+			names[index] = string(scoreType)
+		}
+		summary += fmt.Sprintf(" Not checked since it's unknown whether higher or lower is better: %s.", strings.Join(names, ", "))
+	}
+	description := fmt.Sprintf("Hidden references pass when no other distortion of their reference scores better, and anchors pass when they score worse than the median of the other distortions of their reference, excluding hidden references and anchors. Score types fail when less than %v%% of the hidden references or anchors pass.", MinSanityPassRate*100)
+	return fmt.Sprintf("%s%s%s%s", format.Heading(3, "Hidden reference and anchor sanity checks"), format.Paragraph(description), table.Render(format), format.Paragraph(summary))
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "sanity",
+		Description: fmt.Sprintf("Whether each score type rates the hidden references as near perfect and the anchors, marked by the generation parameter %s=true, as poor, per study with hidden references or anchors, e.g. MUSHRA studies.", AnchorParameter),
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			return bundle.SanityChecks().Render(opts.Format), nil
+		},
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanityChecks(t *testing.T) {
+	ref := &Reference{Name: "ref", Path: "ref.wav"}
+	anchor := &Distortion{Name: "anchor", Path: "anchor.wav", Generation: &Generation{Parameters: map[string]string{AnchorParameter: "true"}}}
+	hidden := &Distortion{Name: "hidden", Path: ref.Path}
+	good := &Distortion{Name: "good", Path: "good.wav"}
+	bad := &Distortion{Name: "bad", Path: "bad.wav"}
+	if !anchor.IsAnchor() || hidden.IsAnchor() || good.IsAnchor() {
+		t.Errorf("IsAnchor doesn't detect anchors by generation parameter")
+	}
+	// MOS and Zimtohrli rate the hidden reference best and the anchor worst, SNR rates the anchor best, and the
+	// direction of Unknown is unknown.
+	for _, scores := range []struct {
+		dist   *Distortion
+		scores map[ScoreType]float64
+	}{
+		{hidden, map[ScoreType]float64{MOS: 100, Zimtohrli: 0, SNR: 20, "Unknown": 1}},
+		{good, map[ScoreType]float64{MOS: 80, Zimtohrli: 0.01, SNR: 15, "Unknown": 2}},
+		{bad, map[ScoreType]float64{MOS: 60, Zimtohrli: 0.02, SNR: 10, "Unknown": 3}},
+		{anchor, map[ScoreType]float64{MOS: 20, Zimtohrli: 0.05, SNR: 30, "Unknown": 4}},
+	} {
+		scores.dist.Scores = scores.scores
+		ref.Distortions = append(ref.Distortions, scores.dist)
+	}
+	checks := bundleOf(ref).SanityChecks()
+	if len(checks.Checks) != 3 {
+		t.Fatalf("got %v checks, want 3", len(checks.Checks))
+	}
+	for _, check := range checks.Checks {
+		if check.HiddenReferences != 1 || check.Anchors != 1 {
+			t.Errorf("%v checked %v hidden references and %v anchors, want 1 of each", check.ScoreType, check.HiddenReferences, check.Anchors)
+		}
+	}
+	if want := (ScoreTypes{SNR}); !reflect.DeepEqual(checks.Failed(), want) {
+		t.Errorf("Failed() = %v, want %v", checks.Failed(), want)
+	}
+	if snr := checks.Checks[1]; snr.ScoreType != SNR || snr.HiddenReferencesPassed != 0 || snr.AnchorsPassed != 0 {
+		t.Errorf("SNR check = %+v, want the hidden reference and the anchor failing", snr)
+	}
+	if want := (ScoreTypes{"Unknown"}); !reflect.DeepEqual(checks.Undirected, want) {
+		t.Errorf("Undirected = %v, want %v", checks.Undirected, want)
+	}
+	if rendered := checks.Render(Format{Target: Text}); !strings.Contains(rendered, "failing the sanity checks: SNR.") {
+		t.Errorf("Render() = %q, want SNR reported as failing", rendered)
+	}
+	if rendered := bundleOf(&Reference{Name: "plain", Path: "plain.wav", Distortions: []*Distortion{{Name: "dist", Path: "dist.wav", Scores: map[ScoreType]float64{MOS: 3}}}}).SanityChecks().Render(Format{Target: Text}); rendered != "" {
+		t.Errorf("Render() without hidden references or anchors = %q, want it empty", rendered)
+	}
+}
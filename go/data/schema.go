@@ -0,0 +1,367 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// schemaVersion is the version of the database schema, stored in the METADATA table.
+//
+// Version 1 was a single OBJ table with the JSON of each reference, keyed by reference name.
+const schemaVersion = 4
+
+// migrations contains the statements upgrading a database from the previous schema version to each version.
+var migrations = map[int][]string{
+	3: {"ALTER TABLE DISTORTION ADD COLUMN SEGMENTS BLOB"},
+	4: {"ALTER TABLE DISTORTION ADD COLUMN ALTERNATIVE_REFERENCES BLOB"},
+}
+
+// schema creates the tables of the database.
+//
+// The distortions of a reference are identified by their position, since their names aren't necessarily unique.
+// Generation, history, compute times, segments, and alternative references are stored as JSON, since they are only read along with the
+// distortion.
+//
+// The quarantine contains measurement results that were NaN or infinite, keyed by distortion name since the
+// positions of distortions change when they are added or merged.
+var schema = []string{
+	"CREATE TABLE IF NOT EXISTS METADATA (KEY TEXT PRIMARY KEY, VALUE TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS REFERENCE (NAME TEXT PRIMARY KEY, PATH TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS DISTORTION (REF TEXT NOT NULL, POS INTEGER NOT NULL, NAME TEXT NOT NULL, PATH TEXT NOT NULL, GENERATION BLOB, HISTORY BLOB, COMPUTE_TIMES BLOB, SEGMENTS BLOB, ALTERNATIVE_REFERENCES BLOB, PRIMARY KEY (REF, POS))",
+	"CREATE TABLE IF NOT EXISTS SCORE (REF TEXT NOT NULL, POS INTEGER NOT NULL, SCORE_TYPE TEXT NOT NULL, SCORE REAL NOT NULL, PRIMARY KEY (REF, POS, SCORE_TYPE))",
+	"CREATE INDEX IF NOT EXISTS SCORE_BY_TYPE ON SCORE (SCORE_TYPE, SCORE)",
+	"CREATE TABLE IF NOT EXISTS QUARANTINE (REF TEXT NOT NULL, DISTORTION TEXT NOT NULL, SCORE_TYPE TEXT NOT NULL, SCORE TEXT NOT NULL, TIME TEXT NOT NULL, PRIMARY KEY (REF, DISTORTION, SCORE_TYPE))",
+}
+
+// dataTables are the tables containing the references, distortions, and scores, in the order they must be copied.
+var dataTables = []string{"REFERENCE", "DISTORTION", "SCORE"}
+
+// execer is a *sql.Tx or *sql.DB.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// hasTable returns whether the database, "main" or an attached one, contains the table.
+func hasTable(db execer, database, table string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT 1 FROM %s.sqlite_master WHERE type = 'table' AND name = ?", database), table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// ensureSchema creates the tables of the database if necessary, and migrates studies stored in older schema
+// versions.
+func ensureSchema(db *sql.DB, dir string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := func() error {
+		version := 0
+		var value string
+		if hasMetadata, err := hasTable(tx, "main", "METADATA"); err != nil {
+			return err
+		} else if hasMetadata {
+			if err := tx.QueryRow("SELECT VALUE FROM METADATA WHERE KEY = 'schema_version'").Scan(&value); err == nil {
+				if version, err = strconv.Atoi(value); err != nil {
+					return fmt.Errorf("invalid schema version %q in %q: %v", value, dir, err)
+				}
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+		}
+		if version > schemaVersion {
+			return fmt.Errorf("%q has schema version %v, newer than the supported version %v", dir, version, schemaVersion)
+		}
+		// Tables that don't exist are created in the latest version, so only existing versioned tables are migrated.
+		for migrated := version + 1; version > 0 && migrated <= schemaVersion; migrated++ {
+			for _, statement := range migrations[migrated] {
+				if _, err := tx.Exec(statement); err != nil {
+					return fmt.Errorf("trying to migrate %q to schema version %v: %v", dir, migrated, err)
+				}
+			}
+		}
+		for _, statement := range schema {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("trying to ensure schema: %v", err)
+			}
+		}
+		oldFormat, err := hasTable(tx, "main", "OBJ")
+		if err != nil {
+			return err
+		}
+		if oldFormat {
+			migrated, err := migrateObjects(tx, "main")
+			if err != nil {
+				return fmt.Errorf("trying to migrate %q: %v", dir, err)
+			}
+			if _, err := tx.Exec("DROP TABLE OBJ"); err != nil {
+				return err
+			}
+			logging.Infof("Migrated %v references in %q to schema version %v", migrated, dir, schemaVersion)
+		}
+		_, err = tx.Exec("INSERT INTO METADATA (KEY, VALUE) VALUES ('schema_version', ?) ON CONFLICT (KEY) DO UPDATE SET VALUE = excluded.VALUE", strconv.Itoa(schemaVersion))
+		return err
+	}(); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateObjects stores the references in the OBJ table of the version 1 schema in the database, "main" or an
+// attached one, in the tables of the main database, and returns the number of references.
+func migrateObjects(tx *sql.Tx, database string) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT DATA FROM %s.OBJ ORDER BY ID", database))
+	if err != nil {
+		return 0, err
+	}
+	refs := []*Reference{}
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ref := &Reference{}
+		if err := json.Unmarshal(value, ref); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		refs = append(refs, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return len(refs), putReferences(tx, refs)
+}
+
+// marshalOptional returns nil if the value is empty, otherwise the JSON of the value.
+func marshalOptional[T any](value T, empty bool) ([]byte, error) {
+	if empty {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// putReferences replaces the distortions and scores of the references with the same names with those of refs.
+func putReferences(tx *sql.Tx, refs []*Reference) error {
+	statements := map[string]*sql.Stmt{}
+	for name, query := range map[string]string{
+		"deleteScores":      "DELETE FROM SCORE WHERE REF = ?",
+		"deleteDistortions": "DELETE FROM DISTORTION WHERE REF = ?",
+		"putReference":      "INSERT INTO REFERENCE (NAME, PATH) VALUES (?, ?) ON CONFLICT (NAME) DO UPDATE SET PATH = excluded.PATH",
+		"putDistortion":     "INSERT INTO DISTORTION (REF, POS, NAME, PATH, GENERATION, HISTORY, COMPUTE_TIMES, SEGMENTS, ALTERNATIVE_REFERENCES) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		"putScore":          "INSERT INTO SCORE (REF, POS, SCORE_TYPE, SCORE) VALUES (?, ?, ?, ?)",
+		"releaseQuarantine": "DELETE FROM QUARANTINE WHERE REF = ? AND EXISTS (SELECT 1 FROM DISTORTION D JOIN SCORE S ON S.REF = D.REF AND S.POS = D.POS WHERE D.REF = QUARANTINE.REF AND D.NAME = QUARANTINE.DISTORTION AND S.SCORE_TYPE = QUARANTINE.SCORE_TYPE)",
+	} {
+		statement, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+		statements[name] = statement
+	}
+	for _, ref := range refs {
+		for _, name := range []string{"deleteScores", "deleteDistortions"} {
+			if _, err := statements[name].Exec(ref.Name); err != nil {
+				return err
+			}
+		}
+		if _, err := statements["putReference"].Exec(ref.Name, ref.Path); err != nil {
+			return err
+		}
+		for pos, dist := range ref.Distortions {
+			generation, err := marshalOptional(dist.Generation, dist.Generation == nil)
+			if err != nil {
+				return err
+			}
+			history, err := marshalOptional(dist.History, len(dist.History) == 0)
+			if err != nil {
+				return err
+			}
+			computeTimes, err := marshalOptional(dist.ComputeTimes, len(dist.ComputeTimes) == 0)
+			if err != nil {
+				return err
+			}
+			segments, err := marshalOptional(dist.Segments, len(dist.Segments) == 0)
+			if err != nil {
+				return err
+			}
+			alternativeReferences, err := marshalOptional(dist.AlternativeReferences, len(dist.AlternativeReferences) == 0)
+			if err != nil {
+				return err
+			}
+			if _, err := statements["putDistortion"].Exec(ref.Name, pos, dist.Name, dist.Path, generation, history, computeTimes, segments, alternativeReferences); err != nil {
+				return err
+			}
+			for _, scoreType := range sortedScoreTypes(dist.Scores) {
+				if !isFinite(dist.Scores[scoreType]) {
+					return fmt.Errorf("trying to store non-finite %v score %v of %q in %q", scoreType, dist.Scores[scoreType], dist.Name, ref.Name)
+				}
+				if _, err := statements["putScore"].Exec(ref.Name, pos, string(scoreType), dist.Scores[scoreType]); err != nil {
+					return fmt.Errorf("trying to store %v score %v of %q in %q: %v", scoreType, dist.Scores[scoreType], dist.Name, ref.Name, err)
+				}
+			}
+		}
+		if _, err := statements["releaseQuarantine"].Exec(ref.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putScores stores the scores of the score types, and the history, compute times, and segments, of the
+// distortions of the references, which must already be stored.
+func putScores(tx *sql.Tx, refs []*Reference, scoreTypes ScoreTypes) error {
+	statements := map[string]*sql.Stmt{}
+	for name, query := range map[string]string{
+		"putDistortion":     "UPDATE DISTORTION SET HISTORY = ?, COMPUTE_TIMES = ?, SEGMENTS = ? WHERE REF = ? AND POS = ?",
+		"putScore":          "INSERT INTO SCORE (REF, POS, SCORE_TYPE, SCORE) VALUES (?, ?, ?, ?) ON CONFLICT (REF, POS, SCORE_TYPE) DO UPDATE SET SCORE = excluded.SCORE",
+		"deleteScore":       "DELETE FROM SCORE WHERE REF = ? AND POS = ? AND SCORE_TYPE = ?",
+		"releaseQuarantine": "DELETE FROM QUARANTINE WHERE REF = ? AND EXISTS (SELECT 1 FROM DISTORTION D JOIN SCORE S ON S.REF = D.REF AND S.POS = D.POS WHERE D.REF = QUARANTINE.REF AND D.NAME = QUARANTINE.DISTORTION AND S.SCORE_TYPE = QUARANTINE.SCORE_TYPE)",
+	} {
+		statement, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+		statements[name] = statement
+	}
+	for _, ref := range refs {
+		for pos, dist := range ref.Distortions {
+			history, err := marshalOptional(dist.History, len(dist.History) == 0)
+			if err != nil {
+				return err
+			}
+			computeTimes, err := marshalOptional(dist.ComputeTimes, len(dist.ComputeTimes) == 0)
+			if err != nil {
+				return err
+			}
+			segments, err := marshalOptional(dist.Segments, len(dist.Segments) == 0)
+			if err != nil {
+				return err
+			}
+			result, err := statements["putDistortion"].Exec(history, computeTimes, segments, ref.Name, pos)
+			if err != nil {
+				return err
+			}
+			if updated, err := result.RowsAffected(); err != nil {
+				return err
+			} else if updated == 0 {
+				return fmt.Errorf("trying to store scores of %q in %q, which isn't stored", dist.Name, ref.Name)
+			}
+			for _, scoreType := range scoreTypes {
+				score, found := dist.Scores[scoreType]
+				if !found {
+					if _, err := statements["deleteScore"].Exec(ref.Name, pos, string(scoreType)); err != nil {
+						return err
+					}
+					continue
+				}
+				if !isFinite(score) {
+					return fmt.Errorf("trying to store non-finite %v score %v of %q in %q", scoreType, score, dist.Name, ref.Name)
+				}
+				if _, err := statements["putScore"].Exec(ref.Name, pos, string(scoreType), score); err != nil {
+					return fmt.Errorf("trying to store %v score %v of %q in %q: %v", scoreType, score, dist.Name, ref.Name, err)
+				}
+			}
+		}
+		if _, err := statements["releaseQuarantine"].Exec(ref.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// viewReferences calls f with each reference in the database, ordered by name, until f returns io.EOF or an error.
+func viewReferences(tx *sql.Tx, f func(*Reference) error) error {
+	rows, err := tx.Query(`SELECT R.NAME, R.PATH, D.POS, D.NAME, D.PATH, D.GENERATION, D.HISTORY, D.COMPUTE_TIMES, D.SEGMENTS, D.ALTERNATIVE_REFERENCES, S.SCORE_TYPE, S.SCORE
+FROM REFERENCE R
+LEFT JOIN DISTORTION D ON D.REF = R.NAME
+LEFT JOIN SCORE S ON S.REF = D.REF AND S.POS = D.POS
+ORDER BY R.NAME, D.POS, S.SCORE_TYPE`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var ref *Reference
+	var dist *Distortion
+	lastPos := int64(-1)
+	for rows.Next() {
+		var refName, refPath string
+		var pos sql.NullInt64
+		var distName, distPath, scoreType sql.NullString
+		var generation, history, computeTimes, segments, alternativeReferences []byte
+		var score sql.NullFloat64
+		if err := rows.Scan(&refName, &refPath, &pos, &distName, &distPath, &generation, &history, &computeTimes, &segments, &alternativeReferences, &scoreType, &score); err != nil {
+			return err
+		}
+		if ref == nil || ref.Name != refName {
+			if ref != nil {
+				if err := f(ref); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+			ref = &Reference{Name: refName, Path: refPath}
+			lastPos = -1
+		}
+		if !pos.Valid {
+			continue
+		}
+		if pos.Int64 != lastPos {
+			dist = &Distortion{Name: distName.String, Path: distPath.String, Scores: map[ScoreType]float64{}}
+			for _, field := range []struct {
+				b     []byte
+				value any
+			}{{generation, &dist.Generation}, {history, &dist.History}, {computeTimes, &dist.ComputeTimes}, {segments, &dist.Segments}, {alternativeReferences, &dist.AlternativeReferences}} {
+				if field.b != nil {
+					if err := json.Unmarshal(field.b, field.value); err != nil {
+						return fmt.Errorf("trying to parse distortion %q of %q: %v", dist.Name, ref.Name, err)
+					}
+				}
+			}
+			ref.Distortions = append(ref.Distortions, dist)
+			lastPos = pos.Int64
+		}
+		if scoreType.Valid {
+			dist.Scores[ScoreType(scoreType.String)] = score.Float64
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if ref != nil {
+		if err := f(ref); err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
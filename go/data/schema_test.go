@@ -0,0 +1,321 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/goohrli"
+)
+
+// createDatabase creates a study database in a new directory by executing the statements, and returns the directory.
+func createDatabase(t *testing.T, statements ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%q: %v", statement, err)
+		}
+	}
+	return dir
+}
+
+// references returns the references stored in the study.
+func references(t *testing.T, study *Study) []*Reference {
+	t.Helper()
+	result := []*Reference{}
+	if err := study.ViewEachReference(func(ref *Reference) error {
+		result = append(result, ref)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// storedSchemaVersion returns the schema version in the metadata of the study.
+func storedSchemaVersion(t *testing.T, study *Study) string {
+	t.Helper()
+	var version string
+	if err := study.db.QueryRow("SELECT VALUE FROM METADATA WHERE KEY = 'schema_version'").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+// fullReference returns a reference with a distortion using all optional fields.
+func fullReference() *Reference {
+	return &Reference{
+		Name: "ref",
+		Path: "ref.wav",
+		Distortions: []*Distortion{
+			{
+				Name:   "dist",
+				Path:   "dist.wav",
+				Scores: map[ScoreType]float64{MOS: 3.5, Zimtohrli: 0.01},
+				Generation: &Generation{
+					Command:    []string{"ffmpeg", "-i", "ref.wav", "dist.wav"},
+					Parameters: map[string]string{"codec": "opus"},
+				},
+				History: map[ScoreType][]HistoricalScore{
+					Zimtohrli: {{Score: 0.01, Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Run: "v1", Parameters: "{}", Version: "abc"}},
+				},
+				ComputeTimes:          map[ScoreType]goohrli.Duration{Zimtohrli: {Duration: 1500 * time.Millisecond}},
+				Segments:              audio.Segments{{Start: 0.5, End: 2}},
+				AlternativeReferences: []string{"ref_remaster.wav"},
+			},
+			{
+				Name:   "plain",
+				Path:   "plain.wav",
+				Scores: map[ScoreType]float64{MOS: 2},
+			},
+		},
+	}
+}
+
+func TestPutAndView(t *testing.T) {
+	study, err := OpenStudy(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	want := fullReference()
+	empty := &Reference{Name: "empty", Path: "empty.wav"}
+	if err := study.Put([]*Reference{want, empty}); err != nil {
+		t.Fatal(err)
+	}
+	got := references(t, study)
+	if len(got) != 2 {
+		t.Fatalf("got %v references, want 2", len(got))
+	}
+	if !reflect.DeepEqual(got[1], want) {
+		t.Errorf("got %+v, want %+v", got[1], want)
+	}
+	if got[0].Name != "empty" || len(got[0].Distortions) != 0 {
+		t.Errorf("got %+v, want a reference without distortions", got[0])
+	}
+
+	// Putting a reference again replaces its distortions.
+	want.Distortions = want.Distortions[1:]
+	if err := study.Put([]*Reference{want}); err != nil {
+		t.Fatal(err)
+	}
+	if got := references(t, study); !reflect.DeepEqual(got[1], want) {
+		t.Errorf("after replacing, got %+v, want %+v", got[1], want)
+	}
+}
+
+func TestMigrateObjects(t *testing.T) {
+	legacy := fullReference()
+	b, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := json.Marshal(&Reference{Name: "other", Path: "other.wav", Distortions: []*Distortion{{Name: "d", Path: "d.wav", Scores: map[ScoreType]float64{JND: 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := createDatabase(t,
+		"CREATE TABLE OBJ (ID BLOB PRIMARY KEY, DATA BLOB)",
+		"INSERT INTO OBJ (ID, DATA) VALUES (CAST('ref' AS BLOB), CAST('"+string(b)+"' AS BLOB))",
+		"INSERT INTO OBJ (ID, DATA) VALUES (CAST('other' AS BLOB), CAST('"+string(other)+"' AS BLOB))",
+	)
+	study, err := OpenStudy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	got := references(t, study)
+	if len(got) != 2 || got[0].Name != "other" || !reflect.DeepEqual(got[1], legacy) {
+		t.Errorf("migrated references = %+v, want %+v and other", got, legacy)
+	}
+	if hasOBJ, err := hasTable(study.db, "main", "OBJ"); err != nil || hasOBJ {
+		t.Errorf("OBJ table exists = %v, %v after migration", hasOBJ, err)
+	}
+	if version := storedSchemaVersion(t, study); version != "4" {
+		t.Errorf("schema version = %v, want 4", version)
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	// The version 2 schema, before segments and alternative references were added.
+	version2 := []string{
+		"CREATE TABLE METADATA (KEY TEXT PRIMARY KEY, VALUE TEXT NOT NULL)",
+		"CREATE TABLE REFERENCE (NAME TEXT PRIMARY KEY, PATH TEXT NOT NULL)",
+		"CREATE TABLE DISTORTION (REF TEXT NOT NULL, POS INTEGER NOT NULL, NAME TEXT NOT NULL, PATH TEXT NOT NULL, GENERATION BLOB, HISTORY BLOB, COMPUTE_TIMES BLOB, PRIMARY KEY (REF, POS))",
+		"CREATE TABLE SCORE (REF TEXT NOT NULL, POS INTEGER NOT NULL, SCORE_TYPE TEXT NOT NULL, SCORE REAL NOT NULL, PRIMARY KEY (REF, POS, SCORE_TYPE))",
+		"INSERT INTO REFERENCE (NAME, PATH) VALUES ('ref', 'ref.wav')",
+		`INSERT INTO DISTORTION (REF, POS, NAME, PATH, GENERATION) VALUES ('ref', 0, 'dist', 'dist.wav', '{"Parameters":{"codec":"opus"}}')`,
+		"INSERT INTO SCORE (REF, POS, SCORE_TYPE, SCORE) VALUES ('ref', 0, 'MOS', 4)",
+	}
+	for _, tc := range []struct {
+		name       string
+		statements []string
+	}{
+		{
+			name:       "version 2",
+			statements: append(append([]string{}, version2...), "INSERT INTO METADATA (KEY, VALUE) VALUES ('schema_version', '2')"),
+		},
+		{
+			name:       "version 3",
+			statements: append(append([]string{}, version2...), "ALTER TABLE DISTORTION ADD COLUMN SEGMENTS BLOB", "INSERT INTO METADATA (KEY, VALUE) VALUES ('schema_version', '3')"),
+		},
+	} {
+		study, err := OpenStudy(createDatabase(t, tc.statements...))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if version := storedSchemaVersion(t, study); version != "4" {
+			t.Errorf("%s: schema version = %v, want 4", tc.name, version)
+		}
+		want := &Reference{
+			Name: "ref",
+			Path: "ref.wav",
+			Distortions: []*Distortion{{
+				Name:       "dist",
+				Path:       "dist.wav",
+				Scores:     map[ScoreType]float64{MOS: 4},
+				Generation: &Generation{Parameters: map[string]string{"codec": "opus"}},
+			}},
+		}
+		if got := references(t, study); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+			t.Errorf("%s: migrated references = %+v, want %+v", tc.name, got, want)
+		}
+		// The migrated columns store segments and alternative references.
+		full := fullReference()
+		if err := study.Put([]*Reference{full}); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := references(t, study); len(got) != 1 || !reflect.DeepEqual(got[0], full) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, full)
+		}
+		study.Close()
+	}
+
+	if _, err := OpenStudy(createDatabase(t, "CREATE TABLE METADATA (KEY TEXT PRIMARY KEY, VALUE TEXT NOT NULL)", "INSERT INTO METADATA (KEY, VALUE) VALUES ('schema_version', '5')")); err == nil {
+		t.Errorf("opening a study with a newer schema version returned no error")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	study, err := OpenStudy(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	ref := fullReference()
+	if err := study.Put([]*Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	quarantined := QuarantinedScores{{Reference: ref.Name, Distortion: ref.Distortions[0].Name, ScoreType: Zimtohrli, Score: math.NaN(), Time: time.Unix(1, 0).UTC()}}
+	if err := study.Quarantine(quarantined); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := study.PutTranscripts(map[string]string{ref.Name: "before"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := study.Snapshot("before"); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := fullReference()
+	changed.Name = "changed"
+	if err := study.Put([]*Reference{changed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := study.Quarantine(QuarantinedScores{{Reference: changed.Name, Distortion: changed.Distortions[0].Name, ScoreType: ViSQOL, Score: math.Inf(1), Time: time.Unix(2, 0).UTC()}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := study.PutTranscripts(map[string]string{ref.Name: "after"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := study.Rollback("before"); err != nil {
+		t.Fatal(err)
+	}
+	if refs := references(t, study); len(refs) != 1 || refs[0].Name != ref.Name {
+		t.Errorf("references after rollback = %v, want only %q", refs, ref.Name)
+	}
+	if got, err := study.Quarantined(); err != nil || len(got) != 1 || got[0].ScoreType != Zimtohrli {
+		t.Errorf("quarantine after rollback = %v, %v, want %v", got, err, quarantined)
+	}
+	if transcripts, err := study.Transcripts(); err != nil || transcripts[ref.Name] != "before" {
+		t.Errorf("transcripts after rollback = %v, %v, want the snapshotted transcript", transcripts, err)
+	}
+	if version := storedSchemaVersion(t, study); version != strconv.Itoa(schemaVersion) {
+		t.Errorf("schema version after rollback = %q, want %v", version, schemaVersion)
+	}
+}
+
+func TestPutScores(t *testing.T) {
+	study, err := OpenStudy(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	if err := study.Put([]*Reference{fullReference()}); err != nil {
+		t.Fatal(err)
+	}
+	// The stored MOS changes after the copy is read, and must not be overwritten by the stale copy.
+	ref := references(t, study)[0]
+	stored := fullReference()
+	stored.Distortions[1].Scores[MOS] = 4
+	if err := study.Put([]*Reference{stored}); err != nil {
+		t.Fatal(err)
+	}
+	ref.Distortions[0].Scores[Zimtohrli] = 0.02
+	ref.Distortions[0].ComputeTimes[Zimtohrli] = goohrli.Duration{Duration: time.Second}
+	ref.Distortions[1].Scores[Zimtohrli] = 0.03
+	ref.Distortions[1].Scores[MOS] = 1
+	if err := study.PutScores([]*Reference{ref}, ScoreTypes{Zimtohrli}); err != nil {
+		t.Fatal(err)
+	}
+	got := references(t, study)[0]
+	for index, want := range []map[ScoreType]float64{{MOS: 3.5, Zimtohrli: 0.02}, {MOS: 4, Zimtohrli: 0.03}} {
+		if !reflect.DeepEqual(got.Distortions[index].Scores, want) {
+			t.Errorf("scores of %v = %v, want %v", got.Distortions[index].Name, got.Distortions[index].Scores, want)
+		}
+	}
+	if got.Distortions[0].ComputeTimes[Zimtohrli].Duration != time.Second {
+		t.Errorf("compute times = %v, want the updated compute time", got.Distortions[0].ComputeTimes)
+	}
+
+	delete(ref.Distortions[0].Scores, Zimtohrli)
+	if err := study.PutScores([]*Reference{ref}, ScoreTypes{Zimtohrli}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := references(t, study)[0].Distortions[0].Scores[Zimtohrli]; found {
+		t.Errorf("removed Zimtohrli score is still stored")
+	}
+
+	unstored := fullReference()
+	unstored.Name = "unstored"
+	if err := study.PutScores([]*Reference{unstored}, ScoreTypes{Zimtohrli}); err == nil {
+		t.Errorf("storing scores of an unstored reference returned no error")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultScoreFormat is the format of scores of types without a registered format. Significant digits work for
+// both small distances and scores with larger scales.
+const defaultScoreFormat = "%.4g"
+
+// registeredScoreFormats contains the fmt formats of the scores of each score type in reports.
+var registeredScoreFormats = map[ScoreType]string{
+	MOS:        "%.2f",
+	JND:        "%.2f",
+	Confidence: "%.2f",
+	Preference: "%.3f",
+	ViSQOL:     "%.3f",
+	// Zimtohrli distances are small, and differences in the third significant digit matter.
+	Zimtohrli:        "%.4g",
+	ZimtohrliMOS:     "%.2f",
+	PESQ:             "%.3f",
+	POLQA:            "%.3f",
+	WER:              "%.3f",
+	STOI:             "%.3f",
+	ESTOI:            "%.3f",
+	SNR:              "%.2f",
+	SISDR:            "%.2f",
+	SpectralDistance: "%.2f",
+}
+
+// RegisterScoreFormat makes reports render scores of the type with the fmt format, which must contain a single
+// floating point verb, like "%.3f" or "%.4g".
+func RegisterScoreFormat(scoreType ScoreType, format string) error {
+	if formatted := fmt.Sprintf(format, 0.5); strings.Contains(formatted, "%!") || formatted == format {
+		return fmt.Errorf("invalid format %q for %v scores, want a single floating point verb like %q", format, scoreType, "%.3f")
+	}
+	registeredScoreFormats[scoreType] = format
+	return nil
+}
+
+// ScoreFormat returns the fmt format of scores of the type in reports.
+func (s ScoreType) ScoreFormat() string {
+	if format, found := registeredScoreFormats[s]; found {
+		return format
+	}
+	return defaultScoreFormat
+}
+
+// FormatScore returns the score formatted as defined for the score type.
+func (s ScoreType) FormatScore(score float64) string {
+	return fmt.Sprintf(s.ScoreFormat(), score)
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// snapshotDir is the directory inside a study directory where snapshots are stored.
+const snapshotDir = "snapshots"
+
+func (s *Study) snapshotPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return filepath.Join(s.dir, snapshotDir, fmt.Sprintf("%s.sqlite3", name)), nil
+}
+
+// Snapshot stores a copy of the study database with the name, which can later be restored using Rollback.
+//
+// The audio files are not copied, since they are never modified.
+func (s *Study) Snapshot(name string) error {
+	path, err := s.snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("snapshot %q of %q already exists", name, s.dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("trying to snapshot %q to %q: %v", s.dir, path, err)
+	}
+	return nil
+}
+
+// Rollback replaces the content of the study with the content of the named snapshot, i.e. the references,
+// distortions, scores, quarantine, and metadata, except the schema version and the last writer, which describe the
+// database rather than its content.
+func (s *Study) Rollback(name string) error {
+	path, err := s.snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		snapshots, _ := s.Snapshots()
+		return fmt.Errorf("no snapshot %q of %q, available snapshots are %v", name, s.dir, snapshots)
+	}
+	// ATTACH is per connection, so all statements must use the same one.
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS SNAPSHOT", path); err != nil {
+		return fmt.Errorf("trying to attach %q: %v", path, err)
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE SNAPSHOT")
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := func() error {
+		for _, table := range append(append([]string{}, dataTables...), "QUARANTINE") {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM METADATA WHERE KEY NOT IN ('schema_version', 'last_writer')"); err != nil {
+			return err
+		}
+		// Snapshots taken before the schema upgrade contain an OBJ table.
+		oldFormat, err := hasTable(tx, "SNAPSHOT", "OBJ")
+		if err != nil {
+			return err
+		}
+		if oldFormat {
+			if _, err := migrateObjects(tx, "SNAPSHOT"); err != nil {
+				return err
+			}
+		} else {
+			// Snapshots taken with older schema versions lack some columns, which then get their default values.
+			for _, table := range dataTables {
+				if err := copySnapshotTable(tx, table); err != nil {
+					return err
+				}
+			}
+		}
+		// Snapshots taken with older schema versions may lack the quarantine and the metadata.
+		if found, err := hasTable(tx, "SNAPSHOT", "QUARANTINE"); err != nil {
+			return err
+		} else if found {
+			if err := copySnapshotTable(tx, "QUARANTINE"); err != nil {
+				return err
+			}
+		}
+		if found, err := hasTable(tx, "SNAPSHOT", "METADATA"); err != nil {
+			return err
+		} else if found {
+			if _, err := tx.Exec("INSERT INTO METADATA (KEY, VALUE) SELECT KEY, VALUE FROM SNAPSHOT.METADATA WHERE KEY NOT IN ('schema_version', 'last_writer')"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// copySnapshotTable copies the rows of the table in the attached snapshot to the table in the study.
+func copySnapshotTable(tx *sql.Tx, table string) error {
+	columns, err := snapshotColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM SNAPSHOT.%s", table, columns, columns, table))
+	return err
+}
+
+// snapshotColumns returns the comma separated columns of the table in the attached snapshot.
+func snapshotColumns(tx *sql.Tx, table string) (string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?, 'SNAPSHOT')", table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns := []string{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return "", err
+		}
+		columns = append(columns, column)
+	}
+	return strings.Join(columns, ", "), rows.Err()
+}
+
+// Snapshots returns the names of the snapshots of the study, alphabetically ordered.
+func (s *Study) Snapshots() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, snapshotDir, "*.sqlite3"))
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, path := range paths {
+		result = append(result, strings.TrimSuffix(filepath.Base(path), ".sqlite3"))
+	}
+	sort.Strings(result)
+	return result, nil
+}
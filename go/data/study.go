@@ -18,9 +18,7 @@ package data
 import (
 	"bytes"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"math/rand"
@@ -30,12 +28,14 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgryski/go-onlinestats"
 	"github.com/google/zimtohrli/go/aio"
 	"github.com/google/zimtohrli/go/audio"
 	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/logging"
 	"github.com/google/zimtohrli/go/progress"
 	"github.com/google/zimtohrli/go/worker"
 
@@ -47,26 +47,81 @@ const (
 	MOS ScoreType = "MOS"
 	// Zimtohrli is the Zimtohrli distance.
 	Zimtohrli ScoreType = "Zimtohrli"
+	// ZimtohrliMOS is the Zimtohrli distance mapped to a mean opinion score by a goohrli.MOSMapping.
+	ZimtohrliMOS ScoreType = "ZimtohrliMOS"
 	// JND is 1 if the evaluator detected a difference and 0 if not.
 	JND ScoreType = "JND"
 	// ViSQOL is the ViSQOL MOS.
 	ViSQOL = "ViSQOL"
+	// Confidence is the confidence, between 0 and 1, in the comparison between the reference and the distortion.
+	Confidence ScoreType = "Confidence"
+	// Preference is the fraction of listeners preferring the distortion over the other distortion of its reference
+	// in a forced-choice pairwise comparison, where ties count as half a preference for each.
+	Preference ScoreType = "Preference"
+	// WER is the word error rate of speech recognized in the distortion against the transcript of the reference.
+	WER ScoreType = "WER"
+	// PESQ is the ITU-T P.862 MOS-LQO, from an external wrapper.
+	PESQ ScoreType = "PESQ"
+	// POLQA is the ITU-T P.863 MOS-LQO, from an external wrapper.
+	POLQA ScoreType = "POLQA"
+	// STOI is the short-time objective intelligibility measure.
+	STOI ScoreType = "STOI"
+	// ESTOI is the extended short-time objective intelligibility measure.
+	ESTOI ScoreType = "ESTOI"
+	// SNR is the signal-to-noise ratio in dB, with the difference between the reference and the distortion as noise.
+	SNR ScoreType = "SNR"
+	// SISDR is the scale-invariant signal-to-distortion ratio in dB.
+	SISDR ScoreType = "SI-SDR"
+	// SpectralDistance is the log-spectral distance in dB.
+	SpectralDistance ScoreType = "SpectralDistance"
 )
 
 // ScoreType represents a type of score, such as MOS or Zimtohrli.
 type ScoreType string
 
-// Better returns 1 if higher is better for the score type, or -1 if lower is better.
+// Better returns 1 if higher is better for the score type, or -1 if lower is better, or 0 if it's unknown.
+//
+// The direction of external metrics is defined by their registered profiles.
 func (s ScoreType) Better() int {
+	if better := s.builtInBetter(); better != 0 {
+		return better
+	}
+	if profile, found := s.MetricProfile(); found {
+		return profile.Better
+	}
+	return 0
+}
+
+// IsAuxiliary returns whether the score type describes the comparisons rather than the quality of the distortions,
+// like Confidence, so that analyses of metrics and listener evaluations leave it out.
+func (s ScoreType) IsAuxiliary() bool {
+	return s == Confidence
+}
+
+// builtInBetter returns Better for the score types of listener evaluations and built-in metrics, or 0 for other
+// score types.
+func (s ScoreType) builtInBetter() int {
 	switch s {
 	case MOS:
 		return 1
 	case Zimtohrli:
 		return -1
+	case ZimtohrliMOS:
+		return 1
 	case JND:
 		return -1
 	case ViSQOL:
 		return 1
+	case Preference:
+		return 1
+	case WER:
+		return -1
+	case STOI, ESTOI:
+		return 1
+	case SNR, SISDR:
+		return 1
+	case SpectralDistance:
+		return -1
 	default:
 		return 0
 	}
@@ -89,8 +144,9 @@ func (s ScoreTypes) Swap(i, j int) {
 
 // Study contains data from a study.
 type Study struct {
-	dir string
-	db  *sql.DB
+	dir  string
+	db   *sql.DB
+	lock *os.File
 }
 
 // ReferenceBundle is a plain data type containing a bunch of references, typicall the content of a study.
@@ -98,17 +154,14 @@ type ReferenceBundle struct {
 	Dir        string
 	References []*Reference
 	ScoreTypes map[ScoreType]int
+	// Subset, if set, is the kind of listener evaluations of the distortions of a mixed study in Dir this bundle
+	// contains.
+	Subset Kind `json:",omitempty"`
 }
 
 // ReferenceBundles is a slice of ReferenceBundle.
 type ReferenceBundles []*ReferenceBundle
 
-// IsJND returns if this bundle is one with just-noticeable-difference evaluations, and only those.
-func (r *ReferenceBundle) IsJND() bool {
-	_, res := r.ScoreTypes[JND]
-	return res
-}
-
 // SortedTypes returns the score types of a bundle, alphabetically ordered.
 func (r *ReferenceBundle) SortedTypes() ScoreTypes {
 	sorted := ScoreTypes{}
@@ -119,6 +172,17 @@ func (r *ReferenceBundle) SortedTypes() ScoreTypes {
 	return sorted
 }
 
+// analyzedTypes returns the score types of a bundle except the auxiliary ones, alphabetically ordered.
+func (r *ReferenceBundle) analyzedTypes() ScoreTypes {
+	result := ScoreTypes{}
+	for _, scoreType := range r.SortedTypes() {
+		if !scoreType.IsAuxiliary() {
+			result = append(result, scoreType)
+		}
+	}
+	return result
+}
+
 // Add adds a reference to a bundle.
 func (r *ReferenceBundle) Add(ref *Reference) {
 	for _, dist := range ref.Distortions {
@@ -161,7 +225,7 @@ func (s *Study) ToBundle() (*ReferenceBundle, error) {
 		return nil, fmt.Errorf("%q has no score types?", s.dir)
 	}
 	if (max - min) > max*0.01 {
-		log.Printf("%q has %v scores and %q has %v scores in %q, more than 5%% missing scores", *minType, min, *maxType, max, s.dir)
+		logging.Warningf("%q has %v scores and %q has %v scores in %q, more than 5%% missing scores", *minType, min, *maxType, max, s.dir)
 	}
 	return result, nil
 }
@@ -171,6 +235,10 @@ type CorrelationScore struct {
 	ScoreTypeA ScoreType
 	ScoreTypeB ScoreType
 	Score      float64
+	// N is the number of distortions with scores of both score types the correlation was computed from.
+	N int
+	// Groups, if positive, is the number of groups of distortions whose correlations were aggregated.
+	Groups int
 }
 
 // CorrelationRow is correlations between a single score type and all score types.
@@ -192,7 +260,12 @@ func (c CorrelationRow) Swap(i, j int) {
 type CorrelationTable []CorrelationRow
 
 func (c CorrelationTable) String() string {
-	listResult := Table{Row{"Score type", "Spearman correlation"}, nil}
+	return c.Render(Format{Target: Text})
+}
+
+// Render returns a representation of the correlation table in the format.
+func (c CorrelationTable) Render(format Format) string {
+	listResult := Table{Row{"Score type", "Spearman correlation", "N"}, nil}
 	tableResult := Table{}
 	header := Row{""}
 	for _, score := range c[0] {
@@ -200,58 +273,83 @@ func (c CorrelationTable) String() string {
 	}
 	tableResult = append(tableResult, header)
 	tableResult = append(tableResult, nil)
+	title := "Spearman correlation table for all score types (number of distortions with both scores)"
+	if c[0][0].Groups > 0 {
+		title = "Spearman correlation table for all score types aggregated across groups (number of distortions with both scores / number of groups)"
+	}
 	for _, scores := range c {
 		row := Row{string(scores[0].ScoreTypeA)}
 		for _, score := range scores {
-			row = append(row, fmt.Sprintf("%.2f", score.Score))
+			if score.Groups > 0 {
+				row = append(row, fmt.Sprintf("%.2f (%v/%v)", score.Score, score.N, score.Groups))
+			} else {
+				row = append(row, fmt.Sprintf("%.2f (%v)", score.Score, score.N))
+			}
 		}
 		tableResult = append(tableResult, row)
 		if scores[0].ScoreTypeA == MOS {
 			sort.Sort(scores)
 			for _, score := range scores {
 				if score.ScoreTypeB != MOS {
-					listResult = append(listResult, Row{string(score.ScoreTypeB), fmt.Sprintf("%.2f", score.Score)})
+					listResult = append(listResult, Row{string(score.ScoreTypeB), fmt.Sprintf("%.2f", score.Score), fmt.Sprint(score.N)})
 				}
 			}
 		}
 	}
-	return fmt.Sprintf("### Spearman correlation table for all score types\n\n%s\n### Score type MOS Spearman correlation in order\n\n%s", tableResult.String(), listResult.String())
+	return fmt.Sprintf("%s%s\n%s%s", format.Heading(3, title), tableResult.Render(format), format.Heading(3, "Score type MOS Spearman correlation in order"), listResult.Render(format))
 }
 
-// Correlation returns the Spearman correlation between score type A and B.
-func (r *ReferenceBundle) Correlation(typeA, typeB ScoreType) (float64, error) {
+// pairedCorrelation returns the absolute Spearman correlation between score type A and B, computed only from the
+// distortions that have finite scores of both types, and the number of such distortions. With fewer than two such
+// distortions the correlation is 0.
+//
+// Non-finite scores are never stored, but bundles can be populated from other sources, and a single NaN makes the
+// ranks, and so the correlation, meaningless.
+func (r *ReferenceBundle) pairedCorrelation(typeA, typeB ScoreType) (float64, int) {
 	scoresA := []float64{}
 	scoresB := []float64{}
 	for _, ref := range r.References {
 		for _, dist := range ref.Distortions {
-			scoresA = append(scoresA, dist.Scores[typeA])
-			scoresB = append(scoresB, dist.Scores[typeB])
+			scoreA, foundA := dist.Scores[typeA]
+			scoreB, foundB := dist.Scores[typeB]
+			if foundA && foundB && isFinite(scoreA) && isFinite(scoreB) {
+				scoresA = append(scoresA, scoreA)
+				scoresB = append(scoresB, scoreB)
+			}
 		}
 	}
-	if len(scoresA) != len(scoresB) {
-		return 0, fmt.Errorf("not the same number of %q and %q: %v vs %v", typeA, typeB, len(scoresA), len(scoresB))
+	if len(scoresA) < 2 {
+		return 0, len(scoresA)
 	}
 	res, _ := onlinestats.Spearman(scoresA, scoresB)
-	return math.Abs(res), nil
+	return math.Abs(res), len(scoresA)
+}
+
+// Correlation returns the Spearman correlation between score type A and B, computed only from the distortions
+// that have scores of both types.
+func (r *ReferenceBundle) Correlation(typeA, typeB ScoreType) (float64, error) {
+	res, n := r.pairedCorrelation(typeA, typeB)
+	if n < 2 {
+		return 0, fmt.Errorf("%v distortions have both %q and %q scores, at least 2 are needed", n, typeA, typeB)
+	}
+	return res, nil
 }
 
 // Correlate returns a table of all scores in the bundle Spearman correlated to each other.
 func (r *ReferenceBundle) Correlate() (CorrelationTable, error) {
-	if r.IsJND() {
-		return nil, fmt.Errorf("cannot correlate JND references")
+	if kind := r.Kind(); kind != KindMOS && kind != KindUnlabeled {
+		return nil, fmt.Errorf("cannot correlate %v references", kind)
 	}
 	result := CorrelationTable{}
-	for _, typeA := range r.SortedTypes() {
+	for _, typeA := range r.analyzedTypes() {
 		row := []CorrelationScore{}
-		for _, typeB := range r.SortedTypes() {
-			corr, err := r.Correlation(typeA, typeB)
-			if err != nil {
-				return nil, err
-			}
+		for _, typeB := range r.analyzedTypes() {
+			corr, n := r.pairedCorrelation(typeA, typeB)
 			row = append(row, CorrelationScore{
 				ScoreTypeA: typeA,
 				ScoreTypeB: typeB,
 				Score:      corr,
+				N:          n,
 			})
 		}
 		result = append(result, row)
@@ -270,12 +368,17 @@ type JNDAccuracyScore struct {
 type JNDAccuracyScores []JNDAccuracyScore
 
 func (a JNDAccuracyScores) String() string {
+	return a.Render(Format{Target: Text})
+}
+
+// Render returns a representation of the accuracy scores in the format.
+func (a JNDAccuracyScores) Render(format Format) string {
 	table := Table{Row{"Score type", "Accuracy", "Threshold"}}
 	table = append(table, nil)
 	for _, score := range a {
-		table = append(table, Row{string(score.ScoreType), fmt.Sprintf("%.2f", score.Accuracy), fmt.Sprintf("%.2v", score.Threshold)})
+		table = append(table, Row{string(score.ScoreType), fmt.Sprintf("%.2f", score.Accuracy), score.ScoreType.FormatScore(score.Threshold)})
 	}
-	return fmt.Sprintf("### Maximal audibility classification accuracy and threshold per score type\n\n%s", table.String())
+	return fmt.Sprintf("%s%s", format.Heading(3, "Maximal audibility classification accuracy and threshold per score type"), table.Render(format))
 }
 
 func (a JNDAccuracyScores) Len() int {
@@ -315,8 +418,8 @@ func ternarySearch(f func(int) float64, left, right int) int {
 // predicting the JND score (whether a human observer was able to detect the distortion), and the
 // accuracy it provided.
 func (r *ReferenceBundle) JNDAccuracyAndThreshold(scoreType ScoreType) (float64, float64, error) {
-	if !r.IsJND() {
-		return 0, 0, fmt.Errorf("cannot compute JND accuracy on non-JND references")
+	if kind := r.Kind(); kind != KindJND {
+		return 0, 0, fmt.Errorf("cannot compute JND accuracy on %v references", kind)
 	}
 	audible := sort.Float64Slice{}
 	inaudible := sort.Float64Slice{}
@@ -367,7 +470,7 @@ func (r *ReferenceBundle) JNDAccuracyAndThreshold(scoreType ScoreType) (float64,
 // JNDAccuracy returns the accuracy of each score type when used to predict audible differences.
 func (r *ReferenceBundle) JNDAccuracy() (JNDAccuracyScores, error) {
 	result := JNDAccuracyScores{}
-	for scoreType := range r.ScoreTypes {
+	for _, scoreType := range r.analyzedTypes() {
 		if scoreType != JND {
 			accuracy, threshold, err := r.JNDAccuracyAndThreshold(scoreType)
 			if err != nil {
@@ -380,32 +483,39 @@ func (r *ReferenceBundle) JNDAccuracy() (JNDAccuracyScores, error) {
 			})
 		}
 	}
-	sort.Sort(result)
+	sort.Stable(result)
 	return result, nil
 }
 
 // Studies is a slice of studies.
 type Studies []*Study
 
-// ToBundles returns reference bundles with the content of the studies.
+// ToBundles returns reference bundles with the content of the studies, reading the studies concurrently.
 func (s Studies) ToBundles() (ReferenceBundles, error) {
 	result := make(ReferenceBundles, len(s))
-	var err error
-	for index, study := range s {
-		if result[index], err = study.ToBundle(); err != nil {
-			return nil, err
-		}
+	pool := &worker.Pool[any]{Workers: runtime.NumCPU()}
+	for loopIndex := range s {
+		index := loopIndex
+		pool.Submit(func(func(any)) error {
+			var err error
+			result[index], err = s[index].ToBundle()
+			return err
+		})
+	}
+	if err := pool.Error(); err != nil {
+		return nil, err
 	}
 	return result, nil
 }
 
 // CalculateZimtohrliMSE returns the mean-squared-error for the Zimtohrli score
 // in the bundles. For JDN bundles this means 1 - accuracy, for the MOS bundles it means
-// 1 - Spearman correlation.
+// 1 - Spearman correlation. Mixed studies contribute each of their subsets.
 func (r ReferenceBundles) CalculateZimtohrliMSE(z *goohrli.Goohrli) (float64, error) {
 	sumOfSquares := 0.0
+	r = r.SplitByKind()
 	for _, bundle := range r {
-		bar := progress.New(fmt.Sprintf("Calculating for %v", filepath.Base(bundle.Dir)))
+		bar := progress.New(fmt.Sprintf("Calculating for %v", bundle.Name()))
 		pool := &worker.Pool[any]{
 			Workers:  runtime.NumCPU(),
 			OnChange: bar.Update,
@@ -413,21 +523,34 @@ func (r ReferenceBundles) CalculateZimtohrliMSE(z *goohrli.Goohrli) (float64, er
 		if err := bundle.Calculate(map[ScoreType]Measurement{Zimtohrli: z.NormalizedAudioDistance}, pool, true); err != nil {
 			return 0, err
 		}
-		if bundle.IsJND() {
+		switch kind := bundle.Kind(); kind {
+		case KindPreference:
+			agreements, err := bundle.PreferenceAgreement()
+			if err != nil {
+				return 0, err
+			}
+			for _, agreement := range agreements {
+				if agreement.ScoreType == Zimtohrli {
+					e := (1 - agreement.Accuracy)
+					sumOfSquares += e * e
+				}
+			}
+		case KindJND:
 			accuracy, _, err := bundle.JNDAccuracyAndThreshold(Zimtohrli)
 			if err != nil {
 				return 0, err
 			}
 			e := (1 - accuracy)
 			sumOfSquares += e * e
-
-		} else {
+		case KindMOS:
 			correlation, err := bundle.Correlation(Zimtohrli, MOS)
 			if err != nil {
 				return 0, err
 			}
 			e := (1 - correlation)
 			sumOfSquares += e * e
+		default:
+			return 0, fmt.Errorf("cannot optimize for %v study %q", kind, bundle.Dir)
 		}
 		bar.Finish()
 	}
@@ -526,38 +649,40 @@ type OptimizationEvent struct {
 
 // Optimize will use simulated annealing to optimize a Zimtohrli metric for predicting
 // these bundles.
-func (r ReferenceBundles) Optimize(startStep, numSteps float64, logger func(OptimizationEvent)) error {
+//
+// The random mutations are derived from the seed, so optimizations with the same seed are reproducible.
+func (r ReferenceBundles) Optimize(seed int64, startStep, numSteps float64, logger func(OptimizationEvent)) error {
 	z := goohrli.New(goohrli.DefaultParameters(sampleRate))
 	loss, err := r.CalculateZimtohrliMSE(z)
 	if err != nil {
 		return err
 	}
 	logger(OptimizationEvent{Parameters: z.Parameters(), Step: 0, Loss: loss, Temp: 1})
-	log.Printf("Created initial solution %v with loss %.2f", z, loss)
+	logging.Infof("Created initial solution %v with loss %.2f", z, loss)
 	for step := startStep; step < numSteps; step++ {
-		rng := rand.New(rand.NewSource(int64(step)))
+		rng := rand.New(rand.NewSource(seed + int64(step)))
 		temp := 1.0 - (step+1)/numSteps
 		newZ := mutate(z, rng, temp)
-		log.Printf("Created new solution %+v", newZ)
+		logging.Infof("Created new solution %+v", newZ)
 		newLoss, err := r.CalculateZimtohrliMSE(newZ)
 		if err != nil {
 			return err
 		}
-		log.Printf("Step %v, temp %v, old loss %.2f, new loss %.2f", step, temp, loss, newLoss)
+		logging.Infof("Step %v, temp %v, old loss %.2f, new loss %.2f", step, temp, loss, newLoss)
 		logger(OptimizationEvent{Parameters: newZ.Parameters(), Step: int(step), Loss: newLoss, Temp: temp})
 		if newLoss < loss {
 			z = newZ
 			loss = newLoss
-			log.Print("*** Accepting better solution")
+			logging.Infof("*** Accepting better solution")
 		} else {
 			acceptanceProb := math.Exp(-(newLoss - loss) / temp)
 			dice := rng.Float64()
 			if dice < acceptanceProb {
 				z = newZ
 				loss = newLoss
-				log.Printf("*** Accepting poorer solution due to acceptanceProb=%.2f > dice=%.2f", acceptanceProb, dice)
+				logging.Infof("*** Accepting poorer solution due to acceptanceProb=%.2f > dice=%.2f", acceptanceProb, dice)
 			} else {
-				log.Print("Discarding poorer solution")
+				logging.Infof("Discarding poorer solution")
 			}
 		}
 	}
@@ -584,45 +709,23 @@ func gitIdentity() (*string, error) {
 	return &result, nil
 }
 
-// Report returns a Markdown report based on the bundles.
-func (r ReferenceBundles) Report() (string, error) {
+// Report returns a report with the analyses of the bundles, in Markdown for the Text format.
+func (r ReferenceBundles) Report(analyses []*Analysis, opts AnalysisOptions) (string, error) {
 	res := &bytes.Buffer{}
-	fmt.Fprintf(res, `# Zimtohrli correlation report
-
-Created at %s
-	
-`, time.Now().Format(time.DateOnly))
+	fmt.Fprint(res, opts.Format.Heading(1, "Zimtohrli correlation report"))
+	fmt.Fprint(res, opts.Format.Paragraph(fmt.Sprintf("Created at %s", time.Now().Format(time.DateOnly))))
 	id, err := gitIdentity()
 	if err != nil {
 		log.Fatal(err)
 	}
 	if id != nil {
-		fmt.Fprintf(res, "%s\n\n", *id)
+		fmt.Fprint(res, opts.Format.Paragraph(*id))
 	}
-	for _, bundle := range r {
-		fmt.Fprintf(res, "## %s\n\n", filepath.Base(bundle.Dir))
-		if bundle.IsJND() {
-			accuracy, err := bundle.JNDAccuracy()
-			if err != nil {
-				return "", err
-			}
-			fmt.Fprintln(res, accuracy)
-		} else {
-			corrTable, err := bundle.Correlate()
-			if err != nil {
-				return "", err
-			}
-			fmt.Fprintln(res, corrTable)
-		}
-	}
-
-	fmt.Fprintf(res, "## Global leaderboard across all studies\n\n")
-
-	board, err := r.Leaderboard(2)
+	analysis, err := r.Analyze(analyses, opts)
 	if err != nil {
 		return "", err
 	}
-	fmt.Fprint(res, board)
+	fmt.Fprint(res, analysis)
 	return res.String(), nil
 }
 
@@ -634,18 +737,55 @@ type MSEScore struct {
 	MinScore  float64
 	MaxScore  float64
 	MeanScore float64
+	// Studies contains the names of the studies the score type was measured in.
+	Studies []string
+	// StudyScores contains the Spearman correlation or accuracy of the score type in each of the studies, or NaN
+	// for studies where it couldn't be computed.
+	StudyScores []float64
 }
 
 // MSEScores contains the MSE for multiple score types.
 type MSEScores []MSEScore
 
 func (m MSEScores) String() string {
-	table := Table{Row{"Score type", "MSE", "Min score", "Max score", "Mean score"}, nil}
+	return m.Render(Format{Target: Text})
+}
+
+// Render returns a representation of the MSE scores in the format.
+func (m MSEScores) Render(format Format) string {
+	header := Row{"Score type", "MSE", "Min score", "Max score", "Mean score"}
+	if len(m) > 0 {
+		header = append(header, m[0].Studies...)
+	}
+	table := Table{header, nil}
+	bestStudyScores := map[int]float64{}
+	for _, score := range m {
+		for studyIndex, studyScore := range score.StudyScores {
+			if math.IsNaN(studyScore) {
+				continue
+			}
+			if best, found := bestStudyScores[studyIndex]; !found || studyScore > best {
+				bestStudyScores[studyIndex] = studyScore
+			}
+		}
+	}
 	for _, score := range m {
 		precisionString := fmt.Sprintf("%%.%df", score.Decimals)
-		table = append(table, Row{string(score.ScoreType), fmt.Sprintf(precisionString, score.MSE), fmt.Sprintf(precisionString, score.MinScore), fmt.Sprintf(precisionString, score.MaxScore), fmt.Sprintf(precisionString, score.MeanScore)})
+		row := Row{string(score.ScoreType), fmt.Sprintf(precisionString, score.MSE), fmt.Sprintf(precisionString, score.MinScore), fmt.Sprintf(precisionString, score.MaxScore), fmt.Sprintf(precisionString, score.MeanScore)}
+		for studyIndex, studyScore := range score.StudyScores {
+			if math.IsNaN(studyScore) {
+				row = append(row, "–")
+				continue
+			}
+			cell := fmt.Sprintf(precisionString, studyScore)
+			if studyScore == bestStudyScores[studyIndex] {
+				cell += "*"
+			}
+			row = append(row, cell)
+		}
+		table = append(table, row)
 	}
-	return fmt.Sprintf("### Mean square error (1 - Spearman correlation, or 1 - accuracy) per score type\n\n%s", table.String())
+	return fmt.Sprintf("%s%s", format.Heading(3, "Mean square error (1 - Spearman correlation, or 1 - accuracy) per score type, and Spearman correlation or accuracy per study (* marks the best score type, – a score that couldn't be computed)"), table.Render(format))
 }
 
 func (m MSEScores) Len() int {
@@ -662,11 +802,63 @@ func (m MSEScores) Swap(i, j int) {
 
 // Leaderboard returns the sorted mean squared errors for each score type that is represented in all bundles.
 func (r ReferenceBundles) Leaderboard(decimals int) (MSEScores, error) {
+	return r.leaderboard(decimals, (*ReferenceBundle).QualityScores)
+}
+
+// QualityScores returns the Spearman correlation with MOS of each score type for MOS bundles,
+// the accuracy of each score type for JND bundles, or the preference agreement accuracy of each
+// score type for pairwise preference bundles.
+func (r *ReferenceBundle) QualityScores() (map[ScoreType]float64, error) {
+	return r.qualityScores(AnalysisOptions{})
+}
+
+// qualityScores returns QualityScores, with the correlations of MOS bundles grouped as defined by the options.
+func (r *ReferenceBundle) qualityScores(opts AnalysisOptions) (map[ScoreType]float64, error) {
+	result := map[ScoreType]float64{}
+	switch kind := r.Kind(); kind {
+	case KindPreference:
+		agreements, err := r.PreferenceAgreement()
+		if err != nil {
+			return nil, err
+		}
+		for _, agreement := range agreements {
+			result[agreement.ScoreType] = agreement.Accuracy
+		}
+		return result, nil
+	case KindJND:
+		accuracies, err := r.JNDAccuracy()
+		if err != nil {
+			return nil, err
+		}
+		for _, accuracy := range accuracies {
+			result[accuracy.ScoreType] = accuracy.Accuracy
+		}
+		return result, nil
+	case KindMixed:
+		return nil, fmt.Errorf("cannot compute quality scores of %v study %q", kind, r.Dir)
+	}
+	correlations, err := r.correlate(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range correlations {
+		if row[0].ScoreTypeA == MOS {
+			for _, correlation := range row {
+				result[correlation.ScoreTypeB] = correlation.Score
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r ReferenceBundles) leaderboard(decimals int, qualityScores func(*ReferenceBundle) (map[ScoreType]float64, error)) (MSEScores, error) {
+	// The subsets of mixed studies are evaluated, and ranked, as separate studies.
+	r = r.SplitByKind()
 	representedScoreTypes := map[ScoreType]int{}
 	for index, bundle := range r {
 		if index == 0 {
 			for scoreType, count := range bundle.ScoreTypes {
-				if scoreType != MOS && scoreType != JND {
+				if scoreType != MOS && scoreType != JND && scoreType != Preference && !scoreType.IsAuxiliary() {
 					representedScoreTypes[scoreType] = count
 				}
 			}
@@ -674,7 +866,7 @@ func (r ReferenceBundles) Leaderboard(decimals int) (MSEScores, error) {
 			for previouslyFoundScoreType := range representedScoreTypes {
 				if count, found := bundle.ScoreTypes[previouslyFoundScoreType]; !found {
 					delete(representedScoreTypes, previouslyFoundScoreType)
-				} else if previouslyFoundScoreType != MOS && previouslyFoundScoreType != JND {
+				} else if previouslyFoundScoreType != MOS && previouslyFoundScoreType != JND && previouslyFoundScoreType != Preference {
 					representedScoreTypes[previouslyFoundScoreType] += count
 				}
 			}
@@ -683,11 +875,24 @@ func (r ReferenceBundles) Leaderboard(decimals int) (MSEScores, error) {
 
 	sumOfSquares := map[ScoreType]float64{}
 	sums := map[ScoreType]float64{}
+	counts := map[ScoreType]int{}
 	mins := map[ScoreType]float64{}
 	maxs := map[ScoreType]float64{}
-
-	addScore := func(scoreType ScoreType, score float64) {
+	studies := make([]string, len(r))
+	studyScores := map[ScoreType][]float64{}
+
+	addScore := func(studyIndex int, scoreType ScoreType, score float64) {
+		if _, found := studyScores[scoreType]; !found {
+			// Studies where the score type has no quality score, e.g. since too few distortions have both scores,
+			// are left out of the ranking instead of counting as a score of 0.
+			studyScores[scoreType] = make([]float64, len(r))
+			for index := range studyScores[scoreType] {
+				studyScores[scoreType][index] = math.NaN()
+			}
+		}
+		studyScores[scoreType][studyIndex] = score
 		sums[scoreType] += score
+		counts[scoreType]++
 		if currentMin, found := mins[scoreType]; !found || (found && score < currentMin) {
 			mins[scoreType] = score
 		}
@@ -697,46 +902,40 @@ func (r ReferenceBundles) Leaderboard(decimals int) (MSEScores, error) {
 		loss := 1.0 - score
 		sumOfSquares[scoreType] += loss * loss
 	}
-	for _, bundle := range r {
-		if bundle.IsJND() {
-			accuracies, err := bundle.JNDAccuracy()
-			if err != nil {
-				return nil, err
-			}
-			for _, accuracy := range accuracies {
-				if _, found := representedScoreTypes[accuracy.ScoreType]; found {
-					addScore(accuracy.ScoreType, accuracy.Accuracy)
-				}
-			}
-		} else {
-			correlations, err := bundle.Correlate()
-			if err != nil {
-				return nil, err
-			}
-			for _, row := range correlations {
-				if row[0].ScoreTypeA == MOS {
-					for _, correlation := range row {
-						if _, found := representedScoreTypes[correlation.ScoreTypeB]; found {
-							addScore(correlation.ScoreTypeB, correlation.Score)
-						}
-					}
+	for studyIndex, bundle := range r {
+		studies[studyIndex] = bundle.Name()
+		scores, err := qualityScores(bundle)
+		if err != nil {
+			return nil, err
+		}
+		for _, scoreType := range bundle.SortedTypes() {
+			if score, found := scores[scoreType]; found && !math.IsNaN(score) {
+				if _, found := representedScoreTypes[scoreType]; found {
+					addScore(studyIndex, scoreType, score)
 				}
 			}
 		}
 	}
 	result := MSEScores{}
-	numStudiesRecpripcal := 1.0 / float64(len(r))
-	for scoreType, squareSum := range sumOfSquares {
+	sortedTypes := ScoreTypes{}
+	for scoreType := range sumOfSquares {
+		sortedTypes = append(sortedTypes, scoreType)
+	}
+	sort.Sort(sortedTypes)
+	for _, scoreType := range sortedTypes {
+		numStudies := float64(counts[scoreType])
 		result = append(result, MSEScore{
-			Decimals:  decimals,
-			ScoreType: scoreType,
-			MSE:       squareSum * numStudiesRecpripcal,
-			MeanScore: sums[scoreType] * numStudiesRecpripcal,
-			MinScore:  mins[scoreType],
-			MaxScore:  maxs[scoreType],
+			Decimals:    decimals,
+			ScoreType:   scoreType,
+			MSE:         sumOfSquares[scoreType] / numStudies,
+			MeanScore:   sums[scoreType] / numStudies,
+			MinScore:    mins[scoreType],
+			MaxScore:    maxs[scoreType],
+			Studies:     studies,
+			StudyScores: studyScores[scoreType],
 		})
 	}
-	sort.Sort(result)
+	sort.Stable(result)
 	return result, nil
 }
 
@@ -801,8 +1000,13 @@ func OpenStudy(dir string) (*Study, error) {
 	if err != nil {
 		return nil, fmt.Errorf("trying to open %q: %v", dbPath, err)
 	}
-	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS OBJ (ID BLOB PRIMARY KEY, DATA BLOB)"); err != nil {
-		return nil, fmt.Errorf("trying to ensure object table: %v", err)
+	if err := ensureSchema(db, dir); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := registerStudyMetricProfiles(db, dir); err != nil {
+		db.Close()
+		return nil, err
 	}
 	return &Study{
 		dir: dir,
@@ -810,121 +1014,389 @@ func OpenStudy(dir string) (*Study, error) {
 	}, nil
 }
 
-// Close closes the study.
+// Dir returns the directory of the study.
+func (s *Study) Dir() string {
+	return s.dir
+}
+
+// Close releases the lock of the study, if held, and closes it.
 func (s *Study) Close() error {
+	if err := s.Unlock(); err != nil {
+		s.db.Close()
+		return err
+	}
 	return s.db.Close()
 }
 
 // Measurement returns distance between sounds.
 type Measurement func(reference, distortion *audio.Audio) (float64, error)
 
+// MeasurementEvent describes a completed or failed measurement, or a failure to load audio.
+type MeasurementEvent struct {
+	Dir        string
+	Reference  string
+	Distortion string    `json:",omitempty"`
+	ScoreType  ScoreType `json:",omitempty"`
+	Score      float64
+	// Duration is the time spent measuring, or loading the audio if ScoreType is empty.
+	Duration goohrli.Duration
+	Error    string `json:",omitempty"`
+}
+
+const (
+	// DecodeStage is the stage of the progress of CalculateWithOptions counting the distortions whose audio, and
+	// that of their references, is loaded.
+	DecodeStage = "decode"
+	// MeasureStage is the stage of the progress of CalculateWithOptions counting the measurements.
+	MeasureStage = "measure"
+	// PersistStage is the stage of the progress of calculations and restores counting the references stored in
+	// studies.
+	PersistStage = "persist"
+)
+
+// CalculateOptions defines optional behavior of CalculateWithOptions.
+type CalculateOptions struct {
+	// Force makes the calculation replace existing scores.
+	Force bool
+	// Report, if set, is called concurrently after each measurement and after each failure to load audio.
+	Report func(MeasurementEvent)
+	// Pools, if set, contains pools that measurements of some score types run in instead of the main pool,
+	// to limit their concurrency independently of the other measurements.
+	Pools map[ScoreType]*worker.Pool[any]
+	// KeepHistory makes the calculation append each new score to the history of the distortion.
+	KeepHistory bool
+	// Run is the name of the run stored in the history.
+	Run string
+	// Parameters are the parameters of the metric of each score type stored in the history.
+	Parameters map[ScoreType]string
+	// Versions are the versions of the metric of each score type stored in the history.
+	Versions map[ScoreType]string
+	// MaxMemory, if positive, is the max resident memory of the process in bytes. While it's exceeded, loading
+	// audio and measuring waits for running measurements to finish, and fails if none are running.
+	MaxMemory uint64
+	// MaxDistortionsPerReference, if positive, is the max number of distortions of the same reference loaded or
+	// measured concurrently, to limit the memory used by references with many distortions.
+	MaxDistortionsPerReference int
+	// MultiReferencePolicy defines how the scores of distortions with alternative references are combined, and
+	// defaults to MultiReferenceBest.
+	MultiReferencePolicy MultiReferencePolicy
+	// Quarantine, if set, is called concurrently with each NaN or infinite measurement result, which is then
+	// reported as quarantined instead of failing the calculation. Such results are never stored.
+	Quarantine func(QuarantinedScore)
+	// TranscriptMeasurements contains measurements that need the transcript of the reference, which are made in
+	// addition to the measurements for distortions of references in Transcripts.
+	TranscriptMeasurements map[ScoreType]TranscriptMeasurement
+	// Transcripts contains the transcripts of the references by name.
+	Transcripts map[string]string
+	// Progress, if set, gets the progress of the DecodeStage and MeasureStage stages.
+	Progress *progress.Stages
+	// CheckAudio, if set, is called concurrently with the audio of each loaded reference, with a nil distortion,
+	// and of each loaded distortion, so that the audio can be checked without decoding it again. An error fails
+	// loading the audio, which then isn't measured.
+	CheckAudio func(ref *Reference, dist *Distortion, a *audio.Audio) error
+}
+
 // Calculate computes measurements and populates the scores of the distortions.
 func (r *ReferenceBundle) Calculate(measurements map[ScoreType]Measurement, pool *worker.Pool[any], force bool) error {
+	return r.CalculateWithOptions(measurements, pool, CalculateOptions{Force: force})
+}
+
+// CalculateWithOptions computes measurements and populates the scores of the distortions.
+//
+// Audio is loaded in pool, and measurements run in pool unless opts.Pools contains a pool for their score type.
+func (r *ReferenceBundle) CalculateWithOptions(measurements map[ScoreType]Measurement, pool *worker.Pool[any], opts CalculateOptions) error {
+	force, report := opts.Force, opts.Report
+	measurementPool := func(scoreType ScoreType) *worker.Pool[any] {
+		if scoreTypePool, found := opts.Pools[scoreType]; found {
+			return scoreTypePool
+		}
+		return pool
+	}
+	scoresLock := sync.Mutex{}
+	gate := newMemoryGate(opts.MaxMemory)
+	// done counts the event in its stage of the progress, reports it if it failed or is a measurement, and
+	// returns err.
+	done := func(event MeasurementEvent, start time.Time, err error) error {
+		stage := MeasureStage
+		if event.ScoreType == "" {
+			stage = DecodeStage
+		}
+		if err != nil {
+			opts.Progress.Add(stage, 0, 0, 1)
+		} else {
+			opts.Progress.Add(stage, 0, 1, 0)
+		}
+		if report == nil || (err == nil && event.ScoreType == "") {
+			return err
+		}
+		event.Dir = r.Dir
+		event.Duration = goohrli.Duration{Duration: time.Since(start)}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		report(event)
+		return err
+	}
+	// feeders submit the distortion jobs of each reference, waiting for previous distortions of the reference to
+	// finish when opts.MaxDistortionsPerReference is reached, without occupying workers.
+	feeders := sync.WaitGroup{}
+	type neededDistortion struct {
+		dist         *Distortion
+		measurements map[ScoreType]Measurement
+	}
 	for _, loopRef := range r.References {
-		refNeededMeasurements := map[ScoreType]Measurement{}
-		for _, dist := range loopRef.Distortions {
+		ref := loopRef
+		neededDistortions := []neededDistortion{}
+		for _, dist := range ref.Distortions {
+			distNeededMeasurements := map[ScoreType]Measurement{}
 			for scoreType, measurement := range measurements {
 				if _, found := dist.Scores[scoreType]; force || !found {
-					refNeededMeasurements[scoreType] = measurement
+					distNeededMeasurements[scoreType] = measurement
+				}
+			}
+			if transcript, found := opts.Transcripts[ref.Name]; found {
+				for scoreType, measurement := range opts.TranscriptMeasurements {
+					if _, found := dist.Scores[scoreType]; force || !found {
+						distNeededMeasurements[scoreType] = measurement.bind(transcript, dist)
+					}
 				}
 			}
+			if len(distNeededMeasurements) > 0 {
+				neededDistortions = append(neededDistortions, neededDistortion{dist: dist, measurements: distNeededMeasurements})
+			}
 		}
-		if len(refNeededMeasurements) == 0 {
+		if len(neededDistortions) == 0 {
 			continue
 		}
-		ref := loopRef
-		pool.Submit(func(func(any)) error {
+		// The reference audio is loaded by the first distortion job, and dropped when the last measurement of the
+		// last distortion is done, independently of the order the jobs are scheduled in.
+		sharedRefAudio := newSharedAudio(len(neededDistortions), func() (*audio.Audio, error) {
+			if err := gate.acquire(); err != nil {
+				return nil, err
+			}
+			defer gate.release()
 			refAudio, err := ref.Load(r.Dir)
-			if err != nil {
-				return err
+			if err == nil && opts.CheckAudio != nil {
+				err = opts.CheckAudio(ref, nil, refAudio)
 			}
-			for _, loopDist := range ref.Distortions {
-				distNeededMeasurements := map[ScoreType]Measurement{}
-				for scoreType, measurement := range refNeededMeasurements {
-					if _, found := loopDist.Scores[scoreType]; force || !found {
-						distNeededMeasurements[scoreType] = measurement
+			return refAudio, err
+		})
+		var slots chan struct{}
+		if opts.MaxDistortionsPerReference > 0 {
+			slots = make(chan struct{}, opts.MaxDistortionsPerReference)
+		}
+		// finished is called exactly once per distortion, when it failed or its last measurement is done.
+		finished := func() {
+			sharedRefAudio.release()
+			if slots != nil {
+				<-slots
+			}
+		}
+		submit := func(needed neededDistortion) {
+			dist, distNeededMeasurements := needed.dist, needed.measurements
+			opts.Progress.Add(DecodeStage, 1, 0, 0)
+			pool.SubmitDescribed(fmt.Sprintf("loading %q and %q in %v", ref.Name, dist.Name, r.Dir), func(func(any)) error {
+				start := time.Now()
+				refAudio, loaded, err := sharedRefAudio.get()
+				if err != nil {
+					finished()
+					if loaded {
+						return done(MeasurementEvent{Reference: ref.Name}, start, err)
 					}
+					// The failure was reported by the job that tried to load the reference.
+					opts.Progress.Add(DecodeStage, 0, 0, 1)
+					return nil
+				}
+				start = time.Now()
+				if err := gate.acquire(); err != nil {
+					finished()
+					return done(MeasurementEvent{Reference: ref.Name, Distortion: dist.Name}, start, err)
+				}
+				distAudio, err := dist.Load(r.Dir)
+				if err == nil && opts.CheckAudio != nil {
+					err = opts.CheckAudio(ref, dist, distAudio)
 				}
-				if len(distNeededMeasurements) == 0 {
-					continue
+				var altAudios []*audio.Audio
+				if err == nil {
+					altAudios, err = dist.LoadAlternativeReferences(r.Dir)
 				}
-				dist := loopDist
-				pool.Submit(func(func(any)) error {
-					distAudio, err := dist.Load(r.Dir)
-					if err != nil {
-						return err
+				gate.release()
+				if err != nil {
+					finished()
+					return done(MeasurementEvent{Reference: ref.Name, Distortion: dist.Name}, start, err)
+				}
+				done(MeasurementEvent{Reference: ref.Name, Distortion: dist.Name}, start, nil)
+				refAudios := append([]*audio.Audio{refAudio}, altAudios...)
+				// remaining is the number of measurements of the distortion not yet done, and the last one finishes
+				// the distortion.
+				remaining := len(distNeededMeasurements)
+				remainingLock := sync.Mutex{}
+				measured := func() {
+					remainingLock.Lock()
+					defer remainingLock.Unlock()
+					remaining--
+					if remaining == 0 {
+						finished()
 					}
-					for loopScoreType := range distNeededMeasurements {
-						scoreType := loopScoreType
-						pool.Submit(func(func(any)) error {
-							score, err := distNeededMeasurements[scoreType](refAudio, distAudio)
-							if err != nil {
-								return err
+				}
+				opts.Progress.Add(MeasureStage, len(distNeededMeasurements), 0, 0)
+				for loopScoreType := range distNeededMeasurements {
+					scoreType := loopScoreType
+					measurementPool(scoreType).SubmitDescribed(fmt.Sprintf("measuring %v of %q and %q in %v", scoreType, ref.Name, dist.Name, r.Dir), func(func(any)) error {
+						defer measured()
+						event := MeasurementEvent{Reference: ref.Name, Distortion: dist.Name, ScoreType: scoreType}
+						if err := gate.acquire(); err != nil {
+							return done(event, time.Now(), err)
+						}
+						start := time.Now()
+						// The gate is released even if the measurement panics, which the pool turns into an error.
+						score, err := func() (float64, error) {
+							defer gate.release()
+							return dist.measure(distNeededMeasurements[scoreType], refAudios, distAudio, scoreType, opts.MultiReferencePolicy)
+						}()
+						if err != nil {
+							return done(event, start, err)
+						}
+						if !isFinite(score) {
+							if opts.Quarantine == nil {
+								return done(event, start, fmt.Errorf("non-finite score %v not allowed", score))
 							}
-							if math.IsNaN(score) {
-								return fmt.Errorf("NaN scores not allowed")
+							opts.Quarantine(QuarantinedScore{
+								Reference:  ref.Name,
+								Distortion: dist.Name,
+								ScoreType:  scoreType,
+								Score:      score,
+								Time:       time.Now().UTC(),
+							})
+							event.Error = fmt.Sprintf("quarantined non-finite score %v", score)
+							return done(event, start, nil)
+						}
+						if err := scoreType.ValidateScore(score); err != nil {
+							return done(event, start, err)
+						}
+						event.Score = score
+						computeTime := goohrli.Duration{Duration: time.Since(start)}
+						scoresLock.Lock()
+						dist.Scores[scoreType] = score
+						if dist.ComputeTimes == nil {
+							dist.ComputeTimes = map[ScoreType]goohrli.Duration{}
+						}
+						dist.ComputeTimes[scoreType] = computeTime
+						if opts.KeepHistory {
+							if dist.History == nil {
+								dist.History = map[ScoreType][]HistoricalScore{}
 							}
-							dist.Scores[scoreType] = score
-							return nil
-						})
-					}
-					return nil
-				})
+							dist.History[scoreType] = append(dist.History[scoreType], HistoricalScore{
+								Score:      score,
+								Time:       time.Now().UTC(),
+								Run:        opts.Run,
+								Parameters: opts.Parameters[scoreType],
+								Version:    opts.Versions[scoreType],
+							})
+						}
+						scoresLock.Unlock()
+						return done(event, start, nil)
+					})
+				}
+				return nil
+			})
+		}
+		feeders.Add(1)
+		go func() {
+			defer feeders.Done()
+			for _, needed := range neededDistortions {
+				if slots != nil {
+					slots <- struct{}{}
+				}
+				submit(needed)
 			}
-			return nil
-		})
+		}()
+	}
+	feeders.Wait()
+	// All jobs submitted to the other pools are submitted by jobs in pool, so they are all submitted once pool is done.
+	errs := worker.Errors{}
+	if err := pool.Error(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, scoreType := range sortedScoreTypes(opts.Pools) {
+		if err := opts.Pools[scoreType].Error(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+func sortedScoreTypes[T any](m map[ScoreType]T) ScoreTypes {
+	result := ScoreTypes{}
+	for scoreType := range m {
+		result = append(result, scoreType)
 	}
-	return pool.Error()
+	sort.Sort(result)
+	return result
 }
 
-// ViewEachReference returns each reference in the study.
+// ViewEachReference returns each reference in the study, ordered by name.
 func (s *Study) ViewEachReference(f func(*Reference) error) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	rows, err := tx.Query("SELECT DATA FROM OBJ")
+	return viewReferences(tx, f)
+}
+
+// Put inserts some references into a study.
+func (s *Study) Put(refs []*Reference) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var value []byte
-		if err := rows.Scan(&value); err != nil {
-			return err
+	if err = putReferences(tx, refs); err == nil {
+		if err = putMetricProfiles(tx, referenceScoreTypes(refs)); err == nil {
+			err = recordWriter(tx)
 		}
-		ref := &Reference{}
-		if err := json.Unmarshal(value, ref); err != nil {
-			return err
-		}
-		if err := f(ref); err == io.EOF {
-			break
-		} else if err != nil {
-			return err
+	}
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return rerr
 		}
+		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-// Put inserts some references into a study.
-func (s *Study) Put(refs []*Reference) error {
+// referenceScoreTypes returns the score types of the distortions of the references, ordered by score type.
+func referenceScoreTypes(refs []*Reference) ScoreTypes {
+	bundle := &ReferenceBundle{ScoreTypes: map[ScoreType]int{}}
+	for _, ref := range refs {
+		bundle.Add(ref)
+	}
+	return bundle.SortedTypes()
+}
+
+// PutScores stores the scores of the score types of the references, which must already be stored with their
+// distortions in the same positions, e.g. references from the study with new scores.
+//
+// Each score is inserted, or replaces the stored score, and scores of the score types missing in the references
+// are removed, without rewriting the other scores. The history, compute times, and segments of the distortions
+// are stored too.
+func (s *Study) PutScores(refs []*Reference, scoreTypes ScoreTypes) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	if err := func() error {
-		for _, ref := range refs {
-			b, err := json.Marshal(ref)
-			if err != nil {
-				return err
-			}
-			if _, err = tx.Exec("INSERT INTO OBJ (ID, DATA) VALUES (?, ?) ON CONFLICT (ID) DO UPDATE SET DATA = ?", []byte(ref.Name), b, b); err != nil {
-				return err
-			}
+	if err = putScores(tx, refs, scoreTypes); err == nil {
+		if err = putMetricProfiles(tx, scoreTypes); err == nil {
+			err = recordWriter(tx)
 		}
-		return nil
-	}(); err != nil {
+	}
+	if err != nil {
 		if rerr := tx.Rollback(); rerr != nil {
 			return rerr
 		}
@@ -938,11 +1410,23 @@ type Distortion struct {
 	Name   string
 	Path   string
 	Scores map[ScoreType]float64
+	// Generation, if set, describes how the distortion was generated.
+	Generation *Generation `json:",omitempty"`
+	// History contains the scores of each type from all runs that kept history, oldest first.
+	History map[ScoreType][]HistoricalScore `json:",omitempty"`
+	// ComputeTimes contains how long the latest measurement of each score type took.
+	ComputeTimes map[ScoreType]goohrli.Duration `json:",omitempty"`
+	// Segments, if set, are the parts of the reference and distortion that are measured, e.g. the phrases rated
+	// by the listeners. The scores are then the means of the scores of the segments, weighted by duration.
+	Segments audio.Segments `json:",omitempty"`
+	// AlternativeReferences, if set, are the paths of other acceptable references of the distortion, e.g. other
+	// masterings of the same recording, that are measured along with the reference.
+	AlternativeReferences []string `json:",omitempty"`
 }
 
 // Load returns the audio for this distortion.
 func (d *Distortion) Load(dir string) (*audio.Audio, error) {
-	return aio.Load(filepath.Join(dir, d.Path))
+	return aio.Load(Resolve(dir, d.Path))
 }
 
 // Reference contains data for a reference.
@@ -954,5 +1438,14 @@ type Reference struct {
 
 // Load returns the audio for this reference.
 func (r *Reference) Load(dir string) (*audio.Audio, error) {
-	return aio.Load(filepath.Join(dir, r.Path))
+	return aio.Load(Resolve(dir, r.Path))
+}
+
+// Resolve returns the path of audio in the study in the directory: paths relative to the directory are joined
+// with it, while absolute paths and URLs are returned unchanged.
+func Resolve(dir string, path string) string {
+	if aio.IsRemote(path) || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
 }
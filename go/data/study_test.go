@@ -0,0 +1,310 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/zimtohrli/go/aio"
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// levelBundle returns a bundle with a reference and numDistortions distortions in a new directory, where the
+// samples of distortion i all have the level i/8. The audio is decoded by a fake ffmpeg that copies the WAV
+// files, which is used until restore is called.
+func levelBundle(t *testing.T, numDistortions int) (bundle *ReferenceBundle, restore func()) {
+	t.Helper()
+	dir := t.TempDir()
+	fakeFFmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(fakeFFmpeg, []byte("#!/bin/sh\nwhile [ $# -gt 0 ]; do if [ \"$1\" = \"-i\" ]; then cat \"$2\"; exit 0; fi; shift; done\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeLevel := func(path string, level float32) {
+		samples := make([]float32, 16)
+		for index := range samples {
+			samples[index] = level
+		}
+		f, err := os.Create(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := (&audio.Audio{Samples: [][]float32{samples}, Rate: 48000}).WAV().Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ref := &Reference{Name: "ref", Path: "ref.wav"}
+	writeLevel(ref.Path, 0.5)
+	for index := 0; index < numDistortions; index++ {
+		dist := &Distortion{Name: fmt.Sprintf("dist%v", index), Path: fmt.Sprintf("dist%v.wav", index), Scores: map[ScoreType]float64{}}
+		writeLevel(dist.Path, float32(index)/8)
+		ref.Distortions = append(ref.Distortions, dist)
+	}
+	bundle = bundleOf(ref)
+	bundle.Dir = dir
+	ffmpeg := aio.FFmpeg
+	aio.FFmpeg = fakeFFmpeg
+	return bundle, func() { aio.FFmpeg = ffmpeg }
+}
+
+// level returns the index of the distortion the audio was loaded from.
+func level(distortion *audio.Audio) int {
+	return int(math.Round(float64(distortion.Samples[0][0]) * 8))
+}
+
+func TestCalculateWithOptions(t *testing.T) {
+	bundle, restore := levelBundle(t, 4)
+	defer restore()
+	measurements := map[ScoreType]Measurement{
+		"Level": func(reference, distortion *audio.Audio) (float64, error) {
+			if level(distortion) == 3 {
+				return math.NaN(), nil
+			}
+			return float64(level(distortion)), nil
+		},
+		"Broken": func(reference, distortion *audio.Audio) (float64, error) {
+			if level(distortion) == 2 {
+				return 0, fmt.Errorf("broken measurement")
+			}
+			return 1, nil
+		},
+	}
+	quarantinedLock := sync.Mutex{}
+	quarantined := QuarantinedScores{}
+	opts := CalculateOptions{
+		KeepHistory: true,
+		Run:         "first",
+		Parameters:  map[ScoreType]string{"Level": "{}"},
+		Versions:    map[ScoreType]string{"Level": "v1"},
+		Quarantine: func(score QuarantinedScore) {
+			quarantinedLock.Lock()
+			defer quarantinedLock.Unlock()
+			quarantined = append(quarantined, score)
+		},
+	}
+	if err := bundle.CalculateWithOptions(measurements, &worker.Pool[any]{Workers: 4}, opts); err == nil {
+		t.Errorf("calculation with a failing measurement returned no error")
+	}
+	dists := bundle.References[0].Distortions
+	for index, dist := range dists {
+		level, found := dist.Scores["Level"]
+		if index == 3 {
+			if found {
+				t.Errorf("non-finite score %v of %v was stored", level, dist.Name)
+			}
+		} else if !found || level != float64(index) {
+			t.Errorf("Level score of %v = %v, %v, want %v", dist.Name, level, found, index)
+		}
+		if _, found := dist.Scores["Broken"]; found == (index == 2) {
+			t.Errorf("Broken score of %v found = %v, want %v", dist.Name, found, index != 2)
+		}
+	}
+	if len(quarantined) != 1 || quarantined[0].Distortion != "dist3" || quarantined[0].ScoreType != "Level" || !math.IsNaN(quarantined[0].Score) {
+		t.Errorf("quarantined = %v, want the NaN Level score of dist3", quarantined)
+	}
+
+	// The failed scores are calculated again, and forcing appends to the history.
+	opts.Run = "second"
+	opts.Force = true
+	quarantined = nil
+	if err := bundle.CalculateWithOptions(map[ScoreType]Measurement{"Level": measurements["Level"]}, &worker.Pool[any]{Workers: 4}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 {
+		t.Errorf("quarantined = %v, want the NaN Level score of dist3 again", quarantined)
+	}
+	for index, dist := range dists[:3] {
+		history := dist.History["Level"]
+		if len(history) != 2 || history[0].Run != "first" || history[1].Run != "second" || history[1].Version != "v1" || history[1].Parameters != "{}" {
+			t.Errorf("Level history of %v = %+v, want the first and second runs", dist.Name, history)
+		}
+		if wantBroken := 1 - index/2; len(dist.History["Broken"]) != wantBroken {
+			t.Errorf("Broken history of %v = %+v, want %v scores from the first run", dist.Name, dist.History["Broken"], wantBroken)
+		}
+		if _, found := dist.ComputeTimes["Level"]; !found {
+			t.Errorf("no compute time for the Level score of %v", dist.Name)
+		}
+	}
+	if history := dists[3].History["Level"]; len(history) != 0 {
+		t.Errorf("Level history of dist3 = %+v, want no quarantined scores", history)
+	}
+
+	// Without a quarantine, non-finite scores fail the calculation.
+	opts.Quarantine = nil
+	if err := bundle.CalculateWithOptions(map[ScoreType]Measurement{"Level": measurements["Level"]}, &worker.Pool[any]{Workers: 4}, opts); err == nil {
+		t.Errorf("calculation with a non-finite score and no quarantine returned no error")
+	}
+}
+
+func TestCheckAudio(t *testing.T) {
+	bundle, restore := levelBundle(t, 3)
+	defer restore()
+	lock := sync.Mutex{}
+	checked := []string{}
+	opts := CalculateOptions{
+		CheckAudio: func(ref *Reference, dist *Distortion, a *audio.Audio) error {
+			warnings := CheckLevels(audio.DefaultLevelCheck, ref, dist, a)
+			lock.Lock()
+			defer lock.Unlock()
+			name := ref.Name
+			if dist != nil {
+				name = dist.Name
+			}
+			checked = append(checked, name)
+			if len(warnings) > 0 {
+				return fmt.Errorf("%v level warnings", len(warnings))
+			}
+			return nil
+		},
+	}
+	measurement := func(reference, distortion *audio.Audio) (float64, error) {
+		return float64(level(distortion)), nil
+	}
+	if err := bundle.CalculateWithOptions(map[ScoreType]Measurement{"Level": measurement}, &worker.Pool[any]{Workers: 2}, opts); err == nil {
+		t.Errorf("calculation with a silent distortion returned no error")
+	}
+	sort.Strings(checked)
+	if want := []string{"dist0", "dist1", "dist2", "ref"}; !reflect.DeepEqual(checked, want) {
+		t.Errorf("checked %v, want each reference and distortion checked once: %v", checked, want)
+	}
+	for index, dist := range bundle.References[0].Distortions {
+		if _, found := dist.Scores["Level"]; found == (index == 0) {
+			t.Errorf("Level score of %v found = %v, want %v", dist.Name, found, index != 0)
+		}
+	}
+}
+
+func TestMaxDistortionsPerReference(t *testing.T) {
+	bundle, restore := levelBundle(t, 8)
+	defer restore()
+	running, maxRunning := int32(0), int32(0)
+	measurement := func(reference, distortion *audio.Audio) (float64, error) {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return 1, nil
+	}
+	if err := bundle.CalculateWithOptions(map[ScoreType]Measurement{"Slow": measurement}, &worker.Pool[any]{Workers: 8}, CalculateOptions{MaxDistortionsPerReference: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if maxRunning > 2 {
+		t.Errorf("%v distortions of the reference were measured concurrently, want at most 2", maxRunning)
+	}
+	for _, dist := range bundle.References[0].Distortions {
+		if _, found := dist.Scores["Slow"]; !found {
+			t.Errorf("no score for %v", dist.Name)
+		}
+	}
+}
+
+func TestPanickingMeasurement(t *testing.T) {
+	bundle, restore := levelBundle(t, 3)
+	defer restore()
+	measurement := func(reference, distortion *audio.Audio) (float64, error) {
+		if level(distortion) == 1 {
+			panic("unexpected level")
+		}
+		return float64(level(distortion)), nil
+	}
+	err := bundle.CalculateWithOptions(map[ScoreType]Measurement{"Level": measurement}, &worker.Pool[any]{Workers: 2}, CalculateOptions{MaxMemory: 1 << 40})
+	if err == nil || !strings.Contains(err.Error(), `measuring Level of "ref" and "dist1"`) || !strings.Contains(err.Error(), "unexpected level") {
+		t.Errorf("got error %v, want the panic of measuring dist1", err)
+	}
+	for index, dist := range bundle.References[0].Distortions {
+		if _, found := dist.Scores["Level"]; found == (index == 1) {
+			t.Errorf("Level score of %v found = %v, want %v", dist.Name, found, index != 1)
+		}
+	}
+}
+
+func TestLeaderboardMissingScores(t *testing.T) {
+	bundle := func(dir string) *ReferenceBundle {
+		result := bundleOf(scoredReference("ref", map[ScoreType]float64{MOS: 4, Zimtohrli: 0.1, ViSQOL: 4}, map[ScoreType]float64{MOS: 2, Zimtohrli: 0.3, ViSQOL: 3}))
+		result.Dir = dir
+		return result
+	}
+	qualityScores := map[string]map[ScoreType]float64{
+		"a": {Zimtohrli: 0.9, ViSQOL: 0.95},
+		"b": {Zimtohrli: 0.8},
+	}
+	board, err := ReferenceBundles{bundle("a"), bundle("b")}.leaderboard(2, func(bundle *ReferenceBundle) (map[ScoreType]float64, error) {
+		return qualityScores[bundle.Dir], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(board) != 2 || board[0].ScoreType != ViSQOL || board[1].ScoreType != Zimtohrli {
+		t.Fatalf("leaderboard = %+v, want ViSQOL ranked before Zimtohrli", board)
+	}
+	if visqol := board[0]; math.Abs(visqol.MSE-0.0025) > 1e-9 || math.Abs(visqol.MeanScore-0.95) > 1e-9 || !math.IsNaN(visqol.StudyScores[1]) {
+		t.Errorf("ViSQOL = %+v, want the MSE and mean of study a only, and a missing score for study b", visqol)
+	}
+	if zimtohrli := board[1]; math.Abs(zimtohrli.MSE-0.025) > 1e-9 {
+		t.Errorf("Zimtohrli MSE = %v, want 0.025", zimtohrli.MSE)
+	}
+	rendered := board.String()
+	for _, want := range []string{"0.95*", "–", "0.80*"} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("leaderboard %q doesn't contain %q", rendered, want)
+		}
+	}
+}
+
+func TestAuxiliaryScoreTypes(t *testing.T) {
+	bundle := bundleOf(scoredReference("a",
+		map[ScoreType]float64{MOS: 4, Zimtohrli: 0.1, Confidence: 1},
+		map[ScoreType]float64{MOS: 3, Zimtohrli: 0.2, Confidence: 0.5},
+		map[ScoreType]float64{MOS: 1, Zimtohrli: 0.3, Confidence: 0.8},
+	))
+	correlations, err := bundle.Correlate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range correlations {
+		for _, correlation := range row {
+			if correlation.ScoreTypeA.IsAuxiliary() || correlation.ScoreTypeB.IsAuxiliary() {
+				t.Errorf("correlation table contains %v and %v, want no auxiliary score types", correlation.ScoreTypeA, correlation.ScoreTypeB)
+			}
+		}
+	}
+	for _, stats := range bundle.Stats().Scores {
+		if stats.ScoreType.IsAuxiliary() {
+			t.Errorf("stats contain auxiliary score type %v", stats.ScoreType)
+		}
+	}
+	if inputs := bundle.defaultEnsembleInputs(); len(inputs) != 1 || inputs[0] != Zimtohrli {
+		t.Errorf("default ensemble inputs = %v, want [%v]", inputs, Zimtohrli)
+	}
+	if undirected := bundle.SanityChecks().Undirected; len(undirected) != 0 {
+		t.Errorf("sanity checks left out %v as undirected, want no auxiliary score types", undirected)
+	}
+}
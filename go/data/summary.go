@@ -0,0 +1,175 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/zimtohrli/go/aio"
+)
+
+// AudioSummary describes the audio files of a study, without decoding them.
+type AudioSummary struct {
+	// Files is the number of distinct audio files, including remote ones.
+	Files int
+	// Remote is the number of remote files, which aren't checked.
+	Remote int
+	// Missing contains the local files that don't exist.
+	Missing []string
+	// Bytes is the total size of the local files.
+	Bytes int64
+	// Extensions contains the number of files with each extension.
+	Extensions map[string]int
+}
+
+// Summary describes the state of a study.
+type Summary struct {
+	Dir         string
+	Kind        Kind
+	References  int
+	Distortions int
+	Coverage    *Coverages
+	Audio       AudioSummary
+	// MetadataKeys are the keys of the metadata of the study, except the schema version and the last writer.
+	MetadataKeys []string
+	Snapshots    []string
+	Quarantined  int
+	// Modified is when the database was last modified.
+	Modified time.Time
+	// LastWriter is the process that last stored references, or nil if it's unknown.
+	LastWriter *Owner
+}
+
+// Summary returns a summary of the study, checking that its audio files exist but not loading them.
+func (s *Study) Summary() (*Summary, error) {
+	bundle, err := s.ToBundle()
+	if err != nil {
+		return nil, err
+	}
+	result := &Summary{
+		Dir:        s.dir,
+		Kind:       bundle.Kind(),
+		References: len(bundle.References),
+		Coverage:   ReferenceBundles{bundle}.Coverage(),
+		Audio:      AudioSummary{Extensions: map[string]int{}},
+	}
+	seen := map[string]bool{}
+	addFile := func(path string) {
+		path = Resolve(s.dir, path)
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		result.Audio.Files++
+		result.Audio.Extensions[strings.ToLower(filepath.Ext(path))]++
+		if aio.IsRemote(path) {
+			result.Audio.Remote++
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			result.Audio.Missing = append(result.Audio.Missing, path)
+			return
+		}
+		result.Audio.Bytes += info.Size()
+	}
+	for _, ref := range bundle.References {
+		result.Distortions += len(ref.Distortions)
+		addFile(ref.Path)
+		for _, dist := range ref.Distortions {
+			addFile(dist.Path)
+			for _, path := range dist.AlternativeReferences {
+				addFile(path)
+			}
+		}
+	}
+	rows, err := s.db.Query("SELECT KEY FROM METADATA WHERE KEY NOT IN ('schema_version', 'last_writer') ORDER BY KEY")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		result.MetadataKeys = append(result.MetadataKeys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if result.Snapshots, err = s.Snapshots(); err != nil {
+		return nil, err
+	}
+	quarantined, err := s.Quarantined()
+	if err != nil {
+		return nil, err
+	}
+	result.Quarantined = len(quarantined)
+	info, err := os.Stat(filepath.Join(s.dir, "db.sqlite3"))
+	if err != nil {
+		return nil, err
+	}
+	result.Modified = info.ModTime()
+	if result.LastWriter, err = s.LastWriter(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Render returns a representation of the summary in the format.
+func (s *Summary) Render(format Format) string {
+	res := &bytes.Buffer{}
+	fmt.Fprint(res, format.Heading(3, "Contents"))
+	fmt.Fprint(res, format.Paragraph(fmt.Sprintf("%v study with %v references, %v distortions, %v quarantined scores", s.Kind, s.References, s.Distortions, s.Quarantined)))
+	fmt.Fprintln(res, s.Coverage.Render(format))
+	fmt.Fprint(res, format.Heading(3, "Audio files"))
+	fmt.Fprint(res, format.Paragraph(fmt.Sprintf("%v files, %.1f MiB, %v remote, %v missing", s.Audio.Files, float64(s.Audio.Bytes)/(1<<20), s.Audio.Remote, len(s.Audio.Missing))))
+	extensions := []string{}
+	for extension := range s.Audio.Extensions {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	table := Table{Row{"Extension", "Files"}, nil}
+	for _, extension := range extensions {
+		table = append(table, Row{extension, fmt.Sprint(s.Audio.Extensions[extension])})
+	}
+	fmt.Fprintln(res, table.Render(format))
+	// Listing thousands of missing files would push everything else off the screen.
+	const maxMissing = 5
+	for index, path := range s.Audio.Missing {
+		if index == maxMissing {
+			fmt.Fprint(res, format.Paragraph(fmt.Sprintf("and %v more missing files", len(s.Audio.Missing)-maxMissing)))
+			break
+		}
+		fmt.Fprint(res, format.Paragraph(fmt.Sprintf("Missing %q", path)))
+	}
+	fmt.Fprint(res, format.Heading(3, "Metadata"))
+	fmt.Fprint(res, format.Paragraph(fmt.Sprintf("Keys: %s", strings.Join(s.MetadataKeys, ", "))))
+	fmt.Fprint(res, format.Paragraph(fmt.Sprintf("Snapshots: %s", strings.Join(s.Snapshots, ", "))))
+	fmt.Fprint(res, format.Heading(3, "Last modified"))
+	lastModified := fmt.Sprintf("Database modified at %v", s.Modified.Format(time.RFC3339))
+	if s.LastWriter != nil {
+		lastModified = fmt.Sprintf("%s, references last stored by %v", lastModified, s.LastWriter)
+	}
+	fmt.Fprint(res, format.Paragraph(lastModified))
+	return res.String()
+}
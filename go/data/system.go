@@ -0,0 +1,221 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/dgryski/go-onlinestats"
+)
+
+// SystemCondition is the system attribute grouping distortions by all their generation parameters, like
+// "bitrate=32k,codec=opus".
+const SystemCondition = "condition"
+
+// System contains the mean scores of the distortions of a system, e.g. a codec setting.
+type System struct {
+	Name  string
+	Count int
+	Means map[ScoreType]float64
+}
+
+// SystemCorrelation contains the correlation between the mean scores of a score type and the mean MOS of the
+// systems.
+type SystemCorrelation struct {
+	ScoreType ScoreType
+	// Systems is the number of systems with distortions with both scores, and N the number of such distortions.
+	Systems int
+	N       int
+	// Spearman and Pearson are the absolute correlations between the mean scores and the mean MOS of the systems,
+	// both computed from the distortions with both scores.
+	Spearman float64
+	Pearson  float64
+	// ItemSpearman is the absolute Spearman correlation between the scores and the MOS of the same distortions, for
+	// comparison with the system level correlations.
+	ItemSpearman float64
+}
+
+// SystemLevel contains the mean scores of the systems of a bundle, and the correlations between their mean scores
+// and their mean MOS.
+type SystemLevel struct {
+	Attribute    string
+	ScoreTypes   ScoreTypes
+	Systems      []System
+	Correlations []SystemCorrelation
+}
+
+// systemOf returns the function returning the system of a distortion for the attribute, SystemCondition if empty,
+// or a generation parameter like "codec". Distortions without a system, i.e. without generation parameters or
+// without the generation parameter, get an empty system.
+func systemOf(attribute string) stratifier {
+	if attribute == "" || attribute == SystemCondition {
+		return func(_ *Reference, dist *Distortion) string {
+			return dist.Generation.Condition()
+		}
+	}
+	return func(_ *Reference, dist *Distortion) string {
+		if dist.Generation == nil {
+			return ""
+		}
+		value, found := dist.Generation.Parameters[attribute]
+		if !found {
+			return ""
+		}
+		return fmt.Sprintf("%s=%s", attribute, value)
+	}
+}
+
+// SystemLevel returns the mean scores of the distortions of each system, ordered by name, and the system level
+// correlations between each score type and MOS, ordered by decreasing Spearman correlation.
+//
+// The distortions are grouped into systems by the attribute, SystemCondition if empty, or a generation parameter
+// like "codec". Codec evaluations care mostly about ranking the systems correctly, which the mean scores of
+// systems with many distortions can do even when the scores of individual distortions are noisy.
+func (r *ReferenceBundle) SystemLevel(attribute string) *SystemLevel {
+	if attribute == "" {
+		attribute = SystemCondition
+	}
+	system := systemOf(attribute)
+	result := &SystemLevel{Attribute: attribute, ScoreTypes: r.analyzedTypes()}
+	indices := map[string]int{}
+	distortions := [][]*Distortion{}
+	for _, ref := range r.References {
+		for _, dist := range ref.Distortions {
+			name := system(ref, dist)
+			if name == "" {
+				continue
+			}
+			index, found := indices[name]
+			if !found {
+				index = len(distortions)
+				indices[name] = index
+				distortions = append(distortions, nil)
+				result.Systems = append(result.Systems, System{Name: name, Means: map[ScoreType]float64{}})
+			}
+			distortions[index] = append(distortions[index], dist)
+		}
+	}
+	for index := range result.Systems {
+		result.Systems[index].Count = len(distortions[index])
+		sums, counts := map[ScoreType]float64{}, map[ScoreType]int{}
+		for _, dist := range distortions[index] {
+			for scoreType, score := range dist.Scores {
+				if isFinite(score) {
+					sums[scoreType] += score
+					counts[scoreType]++
+				}
+			}
+		}
+		for scoreType, sum := range sums {
+			result.Systems[index].Means[scoreType] = sum / float64(counts[scoreType])
+		}
+	}
+	for _, scoreType := range result.ScoreTypes {
+		if scoreType == MOS {
+			continue
+		}
+		correlation := SystemCorrelation{ScoreType: scoreType}
+		systemScores, systemMOS := []float64{}, []float64{}
+		itemScores, itemMOS := []float64{}, []float64{}
+		for _, dists := range distortions {
+			sumScore, sumMOS, count := 0.0, 0.0, 0
+			for _, dist := range dists {
+				score, foundScore := dist.Scores[scoreType]
+				mos, foundMOS := dist.Scores[MOS]
+				if foundScore && foundMOS && isFinite(score) && isFinite(mos) {
+					sumScore += score
+					sumMOS += mos
+					count++
+					itemScores = append(itemScores, score)
+					itemMOS = append(itemMOS, mos)
+				}
+			}
+			if count > 0 {
+				systemScores = append(systemScores, sumScore/float64(count))
+				systemMOS = append(systemMOS, sumMOS/float64(count))
+			}
+		}
+		correlation.Systems, correlation.N = len(systemScores), len(itemScores)
+		if correlation.Systems < 2 {
+			continue
+		}
+		spearman, _ := onlinestats.Spearman(systemScores, systemMOS)
+		correlation.Spearman = math.Abs(spearman)
+		correlation.Pearson = math.Abs(onlinestats.Pearson(systemScores, systemMOS))
+		itemSpearman, _ := onlinestats.Spearman(itemScores, itemMOS)
+		correlation.ItemSpearman = math.Abs(itemSpearman)
+		result.Correlations = append(result.Correlations, correlation)
+	}
+	sort.Slice(result.Systems, func(i, j int) bool {
+		return result.Systems[i].Name < result.Systems[j].Name
+	})
+	sort.SliceStable(result.Correlations, func(i, j int) bool {
+		return result.Correlations[i].Spearman > result.Correlations[j].Spearman
+	})
+	return result
+}
+
+// Render returns a representation of the system means and correlations in the format, or an empty string if there
+// are fewer than two systems.
+func (s *SystemLevel) Render(format Format, decimals int) string {
+	if len(s.Systems) < 2 {
+		return ""
+	}
+	header := Row{"System", "Count"}
+	for _, scoreType := range s.ScoreTypes {
+		header = append(header, string(scoreType))
+	}
+	means := Table{header, nil}
+	for _, system := range s.Systems {
+		row := Row{system.Name, fmt.Sprint(system.Count)}
+		for _, scoreType := range s.ScoreTypes {
+			if score, found := system.Means[scoreType]; found {
+				row = append(row, scoreType.FormatScore(score))
+			} else {
+				row = append(row, "")
+			}
+		}
+		means = append(means, row)
+	}
+	result := fmt.Sprintf("%s%s", format.Heading(3, fmt.Sprintf("Mean scores per system (%s)", s.Attribute)), means.Render(format))
+	if len(s.Correlations) == 0 {
+		return result
+	}
+	precisionString := fmt.Sprintf("%%.%df", decimals)
+	correlations := Table{Row{"Score type", "Systems", "N", "System Spearman", "System Pearson", "Item Spearman"}, nil}
+	for _, correlation := range s.Correlations {
+		correlations = append(correlations, Row{string(correlation.ScoreType), fmt.Sprint(correlation.Systems), fmt.Sprint(correlation.N), fmt.Sprintf(precisionString, correlation.Spearman), fmt.Sprintf(precisionString, correlation.Pearson), fmt.Sprintf(precisionString, correlation.ItemSpearman)})
+	}
+	return fmt.Sprintf("%s%s%s%s", result, format.Heading(3, "System level MOS correlation in order"), format.Paragraph("Correlations between the mean scores and the mean MOS of the systems, and between the scores and the MOS of their distortions."), correlations.Render(format))
+}
+
+func init() {
+	RegisterAnalysis(&Analysis{
+		Name:        "systems",
+		Description: "Mean scores per system, e.g. codec setting, and the correlation between the mean scores and the mean MOS of the systems, per MOS study with recorded distortion generation parameters.",
+		Study: func(bundle *ReferenceBundle, opts AnalysisOptions) (string, error) {
+			if bundle = bundle.ofKind(KindMOS); bundle == nil {
+				return "", nil
+			}
+			section := bundle.SystemLevel(opts.SystemAttribute).Render(opts.Format, opts.Decimals)
+			if section == "" {
+				return "", nil
+			}
+			return bundle.subsetNote(opts.Format) + section, nil
+		},
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codecBundle returns a bundle where the metric orders the distortions of each codec in the opposite order of the
+// MOS, while the mean metric of each codec orders the codecs like their mean MOS.
+func codecBundle() *ReferenceBundle {
+	ref := &Reference{Name: "ref", Path: "ref.wav"}
+	for index, scores := range []struct {
+		codec  string
+		mos    float64
+		metric float64
+	}{
+		{"a", 1, 2}, {"a", 2, 1},
+		{"b", 3, 4}, {"b", 4, 3},
+		{"c", 5, 6}, {"c", 6, 5},
+	} {
+		ref.Distortions = append(ref.Distortions, &Distortion{
+			Name:       fmt.Sprintf("dist%v", index),
+			Path:       fmt.Sprintf("dist%v.wav", index),
+			Scores:     map[ScoreType]float64{MOS: scores.mos, "Metric": scores.metric},
+			Generation: &Generation{Parameters: map[string]string{"codec": scores.codec, "bitrate": "32k"}},
+		})
+	}
+	// Distortions without generation parameters belong to no system.
+	ref.Distortions = append(ref.Distortions, &Distortion{Name: "unknown", Path: "unknown.wav", Scores: map[ScoreType]float64{MOS: 6, "Metric": 1}})
+	return bundleOf(ref)
+}
+
+func TestSystemLevel(t *testing.T) {
+	bundle := codecBundle()
+	for _, tc := range []struct {
+		attribute string
+		want      []string
+	}{
+		{"", []string{"bitrate=32k,codec=a", "bitrate=32k,codec=b", "bitrate=32k,codec=c"}},
+		{"codec", []string{"codec=a", "codec=b", "codec=c"}},
+		{"bitrate", []string{"bitrate=32k"}},
+		{"missing", []string{}},
+	} {
+		systems := bundle.SystemLevel(tc.attribute)
+		names := []string{}
+		for _, system := range systems.Systems {
+			names = append(names, system.Name)
+		}
+		if !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("systems for %q = %v, want %v", tc.attribute, names, tc.want)
+		}
+	}
+
+	systems := bundle.SystemLevel("codec")
+	if got := systems.Systems[1]; got.Count != 2 || got.Means[MOS] != 3.5 || got.Means["Metric"] != 3.5 {
+		t.Errorf("system %v = %+v, want 2 distortions with mean MOS and Metric 3.5", got.Name, got)
+	}
+	if len(systems.Correlations) != 1 {
+		t.Fatalf("got %v correlations, want 1", len(systems.Correlations))
+	}
+	correlation := systems.Correlations[0]
+	if correlation.ScoreType != "Metric" || correlation.Systems != 3 || correlation.N != 6 {
+		t.Errorf("correlation = %+v, want Metric with 3 systems and 6 distortions", correlation)
+	}
+	if math.Abs(correlation.Spearman-1) > 1e-9 || math.Abs(correlation.Pearson-1) > 1e-9 {
+		t.Errorf("system correlations = %v, %v, want 1", correlation.Spearman, correlation.Pearson)
+	}
+	if correlation.ItemSpearman >= 0.9 {
+		t.Errorf("item correlation = %v, want below the system correlation", correlation.ItemSpearman)
+	}
+	if rendered := systems.Render(Format{Target: Text}, 2); !strings.Contains(rendered, "codec=b") || !strings.Contains(rendered, "System level MOS correlation") {
+		t.Errorf("Render() = %q, want the systems and their correlations", rendered)
+	}
+	if rendered := bundle.SystemLevel("bitrate").Render(Format{Target: Text}, 2); rendered != "" {
+		t.Errorf("Render() with a single system = %q, want it empty", rendered)
+	}
+}
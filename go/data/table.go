@@ -16,48 +16,514 @@ package data
 
 import (
 	"bytes"
+	"encoding/csv"
 	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Target is an output format for tables and reports.
+type Target string
+
+const (
+	// Text renders tables as aligned columns of plain text, and headings as Markdown.
+	Text Target = "text"
+	// Markdown renders tables as GitHub flavored Markdown tables, and headings as Markdown.
+	Markdown Target = "markdown"
+	// CSV renders tables as comma separated values, and headings and paragraphs as records with a single field.
+	CSV Target = "csv"
+	// HTML renders tables as HTML tables, and headings and paragraphs as HTML elements.
+	HTML Target = "html"
+	// LaTeX renders tables as LaTeX tabular environments, and headings as LaTeX sections.
+	LaTeX Target = "latex"
+)
+
+// Targets contains all output formats.
+var Targets = []Target{Text, Markdown, CSV, HTML, LaTeX}
+
+// Alignment is the horizontal alignment of table columns.
+type Alignment string
+
+const (
+	// AlignAuto aligns the first column, which labels the rows, and columns of text left, and columns of numbers right.
+	AlignAuto Alignment = "auto"
+	// AlignLeft aligns all columns left.
+	AlignLeft Alignment = "left"
+	// AlignRight aligns all columns right.
+	AlignRight Alignment = "right"
+)
+
+// Alignments contains all alignments.
+var Alignments = []Alignment{AlignAuto, AlignLeft, AlignRight}
+
+// Format defines how tables and reports are rendered.
+//
+// Tables start with a row of column headings, followed by a nil row, and the column selection, sorting, and
+// highlighting apply to the rows after it.
+type Format struct {
+	// Target is the output format, Text if empty.
+	Target Target
+	// Align is the alignment of table columns, AlignAuto if empty.
+	Align Alignment
+	// Columns, if set, restricts tables with any of these column headings to their first column and those columns,
+	// in the order of the table. Tables with none of them are rendered in full.
+	Columns []string
+	// SortBy, if set, sorts the rows of tables with a column with this heading by that column, numerically where
+	// the cells start with numbers.
+	SortBy string
+	// Descending makes SortBy sort in descending order.
+	Descending bool
+	// Highlight marks the max of each column of numbers in bold, and the min in italics, except in CSV.
+	Highlight bool
+}
+
+// Heading returns a heading of the given level (1 for the top level) in the format.
+func (f Format) Heading(level int, text string) string {
+	switch f.Target {
+	case LaTeX:
+		return fmt.Sprintf("\\%ssection*{%s}\n\n", strings.Repeat("sub", min(level-1, 2)), EscapeLaTeX(text))
+	case HTML:
+		return fmt.Sprintf("<h%d>%s</h%d>\n\n", level, html.EscapeString(text), level)
+	case CSV:
+		return csvRecord(text)
+	}
+	return fmt.Sprintf("%s %s\n\n", strings.Repeat("#", level), text)
+}
+
+// Paragraph returns a paragraph of text in the format.
+func (f Format) Paragraph(text string) string {
+	switch f.Target {
+	case LaTeX:
+		return fmt.Sprintf("%s\n\n", EscapeLaTeX(text))
+	case HTML:
+		return fmt.Sprintf("<p>%s</p>\n\n", html.EscapeString(text))
+	case CSV:
+		return csvRecord(text)
+	}
+	return fmt.Sprintf("%s\n\n", text)
+}
+
+// csvRecord returns the fields as a CSV record.
+func csvRecord(fields ...string) string {
+	out := &bytes.Buffer{}
+	w := csv.NewWriter(out)
+	w.Write(fields)
+	w.Flush()
+	return out.String()
+}
+
+var latexReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// EscapeLaTeX returns s with all characters that are special in LaTeX escaped.
+func EscapeLaTeX(s string) string {
+	return latexReplacer.Replace(s)
+}
+
 // Row is a row of table data.
 type Row []string
 
 // Table is table structured data that can render in straight columns in a terminal.
+//
+// Nil rows are rendered as horizontal lines where the format supports them.
 type Table []Row
 
-// String returns a string representation of the table with colSpacing blanks between columns.
+// String returns a string representation of the table as aligned columns of plain text.
 func (t Table) String() string {
-	maxCells := 0
+	return t.Render(Format{Target: Text})
+}
+
+// LaTeX returns the table as a LaTeX tabular environment.
+func (t Table) LaTeX() string {
+	return t.Render(Format{Target: LaTeX})
+}
+
+// Render returns a representation of the table in the format.
+func (t Table) Render(format Format) string {
+	l := format.arrange(t)
+	switch format.Target {
+	case Markdown:
+		return l.markdown()
+	case CSV:
+		return l.csv()
+	case HTML:
+		return l.html()
+	case LaTeX:
+		return l.latex()
+	}
+	return l.text()
+}
+
+// highlight marks a cell as the max or min of its column.
+type highlight int
+
+const (
+	noHighlight highlight = iota
+	highlightMax
+	highlightMin
+)
+
+// layout is a table arranged as defined by a format.
+type layout struct {
+	rows Table
+	// numeric is whether each column contains only numbers below the headings.
+	numeric []bool
+	// right is whether each column is right aligned.
+	right []bool
+	// highlights contains the highlight of each cell, or is nil if nothing is highlighted.
+	highlights [][]highlight
+	columns    int
+}
+
+// cellNumber returns the number a cell starts with, ignoring trailing "*" and "%" marks, and whether it starts
+// with a number.
+func cellNumber(cell string) (float64, bool) {
+	fields := strings.Fields(cell)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimRight(fields[0], "*%"), 64)
+	return f, err == nil
+}
+
+// hasHeadings returns whether the table starts with a row of column headings followed by a nil row.
+func (t Table) hasHeadings() bool {
+	return len(t) > 1 && t[0] != nil && t[1] == nil
+}
+
+// arrange returns the table with the columns selected, rows sorted, and cells highlighted as defined by the format.
+func (f Format) arrange(t Table) *layout {
+	columns := 0
 	for _, row := range t {
-		if len(row) > maxCells {
-			maxCells = len(row)
+		columns = max(columns, len(row))
+	}
+	// Rows are padded to the same number of cells, and never modified in place.
+	rows := make(Table, len(t))
+	for rowIndex, row := range t {
+		if row != nil {
+			rows[rowIndex] = make(Row, columns)
+			copy(rows[rowIndex], row)
 		}
 	}
-	maxCellWidths := make([]int, maxCells)
-	for _, row := range t {
-		for cellIndex, cell := range row {
-			if len(cell) > maxCellWidths[cellIndex] {
-				maxCellWidths[cellIndex] = len(cell)
+	body := 0
+	if t.hasHeadings() {
+		body = 2
+		if len(f.Columns) > 0 {
+			selected := []int{0}
+			for index := 1; index < columns; index++ {
+				for _, column := range f.Columns {
+					if rows[0][index] == column {
+						selected = append(selected, index)
+						break
+					}
+				}
+			}
+			if len(selected) > 1 {
+				for rowIndex, row := range rows {
+					if row == nil {
+						continue
+					}
+					selectedRow := Row{}
+					for _, index := range selected {
+						selectedRow = append(selectedRow, row[index])
+					}
+					rows[rowIndex] = selectedRow
+				}
+				columns = len(selected)
+			}
+		}
+		if f.SortBy != "" {
+			for index := 0; index < columns; index++ {
+				if rows[0][index] == f.SortBy {
+					// Runs of rows between horizontal lines are sorted separately.
+					for start := body; start < len(rows); {
+						end := start
+						for end < len(rows) && rows[end] != nil {
+							end++
+						}
+						f.sortRows(rows[start:end], index)
+						start = end + 1
+					}
+					break
+				}
 			}
 		}
 	}
-	out := &bytes.Buffer{}
-	for _, row := range t {
-		fmt.Fprint(out, "|")
-		for cellIndex, maxCellWidth := range maxCellWidths {
-			if row == nil {
-				for i := 0; i < maxCellWidth+1; i++ {
-					fmt.Fprint(out, "-")
+	result := &layout{
+		rows:    rows,
+		numeric: make([]bool, columns),
+		right:   make([]bool, columns),
+		columns: columns,
+	}
+	for index := 0; index < columns; index++ {
+		numbers := 0
+		result.numeric[index] = true
+		for _, row := range rows[body:] {
+			if row == nil || row[index] == "" {
+				continue
+			}
+			if _, ok := cellNumber(row[index]); !ok {
+				result.numeric[index] = false
+				break
+			}
+			numbers++
+		}
+		result.numeric[index] = result.numeric[index] && numbers > 0
+		switch f.Align {
+		case AlignLeft:
+		case AlignRight:
+			result.right[index] = true
+		default:
+			result.right[index] = index > 0 && result.numeric[index]
+		}
+	}
+	if f.Highlight && body > 0 {
+		result.highlights = make([][]highlight, len(rows))
+		for rowIndex := range rows {
+			result.highlights[rowIndex] = make([]highlight, columns)
+		}
+		for index := 1; index < columns; index++ {
+			if !result.numeric[index] {
+				continue
+			}
+			minValue, maxValue := math.Inf(1), math.Inf(-1)
+			for _, row := range rows[body:] {
+				if value, ok := cellNumber(row.cell(index)); ok {
+					minValue = min(minValue, value)
+					maxValue = max(maxValue, value)
 				}
-			} else {
-				fmt.Fprint(out, row[cellIndex])
-				for i := len(row[cellIndex]); i < maxCellWidth+1; i++ {
-					fmt.Fprint(out, " ")
+			}
+			if minValue == maxValue {
+				continue
+			}
+			for rowIndex, row := range rows[body:] {
+				switch value, ok := cellNumber(row.cell(index)); {
+				case !ok:
+				case value == maxValue:
+					result.highlights[body+rowIndex][index] = highlightMax
+				case value == minValue:
+					result.highlights[body+rowIndex][index] = highlightMin
 				}
 			}
+		}
+	}
+	return result
+}
+
+// cell returns the cell at the index, or an empty string if the row is a horizontal line.
+func (r Row) cell(index int) string {
+	if r == nil {
+		return ""
+	}
+	return r[index]
+}
+
+// sortRows sorts the rows by the column at the index, with numbers before other cells.
+func (f Format) sortRows(rows Table, index int) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i][index], rows[j][index]
+		if f.Descending {
+			a, b = b, a
+		}
+		numberA, okA := cellNumber(a)
+		numberB, okB := cellNumber(b)
+		switch {
+		case okA && okB:
+			return numberA < numberB
+		case okA != okB:
+			return okA != f.Descending
+		}
+		return a < b
+	})
+}
+
+// markup defines how a format escapes table cells and marks highlighted ones.
+type markup struct {
+	escape func(string) string
+	// bold and italic are the prefix and suffix of the max and min of a column.
+	bold   [2]string
+	italic [2]string
+}
+
+var (
+	textMarkup     = markup{escape: func(s string) string { return s }, bold: [2]string{"**", "**"}, italic: [2]string{"_", "_"}}
+	markdownMarkup = markup{escape: strings.NewReplacer(`|`, `\|`).Replace, bold: [2]string{"**", "**"}, italic: [2]string{"_", "_"}}
+	htmlMarkup     = markup{escape: html.EscapeString, bold: [2]string{"<b>", "</b>"}, italic: [2]string{"<i>", "</i>"}}
+	latexMarkup    = markup{escape: EscapeLaTeX, bold: [2]string{`\textbf{`, `}`}, italic: [2]string{`\textit{`, `}`}}
+)
+
+// cell returns the cell at the row and column indices, escaped and highlighted using the markup.
+func (l *layout) cell(rowIndex, index int, m markup) string {
+	cell := m.escape(l.rows[rowIndex][index])
+	if l.highlights == nil {
+		return cell
+	}
+	switch l.highlights[rowIndex][index] {
+	case highlightMax:
+		return m.bold[0] + cell + m.bold[1]
+	case highlightMin:
+		return m.italic[0] + cell + m.italic[1]
+	}
+	return cell
+}
+
+// text returns the table as aligned columns of plain text.
+func (l *layout) text() string {
+	cells := make([][]string, len(l.rows))
+	widths := make([]int, l.columns)
+	for rowIndex, row := range l.rows {
+		if row == nil {
+			continue
+		}
+		cells[rowIndex] = make([]string, l.columns)
+		for index := range row {
+			cells[rowIndex][index] = l.cell(rowIndex, index, textMarkup)
+			widths[index] = max(widths[index], len(cells[rowIndex][index]))
+		}
+	}
+	out := &bytes.Buffer{}
+	for _, row := range cells {
+		fmt.Fprint(out, "|")
+		for index, width := range widths {
+			switch {
+			case row == nil:
+				fmt.Fprint(out, strings.Repeat("-", width+1))
+			case l.right[index]:
+				fmt.Fprintf(out, "%*s ", width, row[index])
+			default:
+				fmt.Fprintf(out, "%-*s ", width, row[index])
+			}
 			fmt.Fprint(out, "|")
 		}
 		fmt.Fprint(out, "\n")
 	}
 	return out.String()
 }
+
+// markdown returns the table as a GitHub flavored Markdown table.
+//
+// Markdown tables have exactly one horizontal line, below the headings, so other nil rows are skipped, and tables
+// without headings get empty ones.
+func (l *layout) markdown() string {
+	out := &bytes.Buffer{}
+	writeRow := func(cells []string) {
+		fmt.Fprintf(out, "|%s|\n", strings.Join(cells, "|"))
+	}
+	body := 0
+	headings := make([]string, l.columns)
+	if l.rows.hasHeadings() {
+		for index := range headings {
+			headings[index] = l.cell(0, index, markdownMarkup)
+		}
+		body = 2
+	}
+	writeRow(headings)
+	lines := make([]string, l.columns)
+	for index := range lines {
+		lines[index] = ":---"
+		if l.right[index] {
+			lines[index] = "---:"
+		}
+	}
+	writeRow(lines)
+	for rowIndex := body; rowIndex < len(l.rows); rowIndex++ {
+		if l.rows[rowIndex] == nil {
+			continue
+		}
+		cells := make([]string, l.columns)
+		for index := range cells {
+			cells[index] = l.cell(rowIndex, index, markdownMarkup)
+		}
+		writeRow(cells)
+	}
+	return out.String()
+}
+
+// csv returns the table as comma separated values, without the horizontal lines.
+func (l *layout) csv() string {
+	out := &bytes.Buffer{}
+	w := csv.NewWriter(out)
+	for _, row := range l.rows {
+		if row != nil {
+			w.Write(row)
+		}
+	}
+	w.Flush()
+	return out.String()
+}
+
+// html returns the table as an HTML table, with horizontal lines starting new table bodies.
+func (l *layout) html() string {
+	out := &bytes.Buffer{}
+	fmt.Fprint(out, "<table>\n")
+	body := 0
+	if l.rows.hasHeadings() {
+		fmt.Fprint(out, "<thead>\n<tr>")
+		for index := range l.rows[0] {
+			fmt.Fprintf(out, "<th%s>%s</th>", l.htmlStyle(index), l.cell(0, index, htmlMarkup))
+		}
+		fmt.Fprint(out, "</tr>\n</thead>\n")
+		body = 2
+	}
+	fmt.Fprint(out, "<tbody>\n")
+	for rowIndex := body; rowIndex < len(l.rows); rowIndex++ {
+		row := l.rows[rowIndex]
+		if row == nil {
+			fmt.Fprint(out, "</tbody>\n<tbody>\n")
+			continue
+		}
+		fmt.Fprint(out, "<tr>")
+		for index := range row {
+			fmt.Fprintf(out, "<td%s>%s</td>", l.htmlStyle(index), l.cell(rowIndex, index, htmlMarkup))
+		}
+		fmt.Fprint(out, "</tr>\n")
+	}
+	fmt.Fprint(out, "</tbody>\n</table>\n")
+	return out.String()
+}
+
+func (l *layout) htmlStyle(index int) string {
+	if l.right[index] {
+		return ` style="text-align: right"`
+	}
+	return ""
+}
+
+// latex returns the table as a LaTeX tabular environment.
+func (l *layout) latex() string {
+	out := &bytes.Buffer{}
+	alignments := make([]string, l.columns)
+	for index := range alignments {
+		alignments[index] = "l"
+		if l.right[index] {
+			alignments[index] = "r"
+		}
+	}
+	fmt.Fprintf(out, "\\begin{tabular}{%s}\n\\hline\n", strings.Join(alignments, ""))
+	for rowIndex, row := range l.rows {
+		if row == nil {
+			fmt.Fprint(out, "\\hline\n")
+			continue
+		}
+		cells := make([]string, l.columns)
+		for index := range row {
+			cells[index] = l.cell(rowIndex, index, latexMarkup)
+		}
+		fmt.Fprintf(out, "%s \\\\\n", strings.Join(cells, " & "))
+	}
+	fmt.Fprint(out, "\\hline\n\\end{tabular}\n")
+	return out.String()
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	table := Table{Row{"Name", "Score", "Note"}, nil, Row{"b", "2", "x|y"}, Row{"a", "10", "a_b"}, Row{"c", "1.5*", "<c>"}}
+	original := Table{Row{"Name", "Score", "Note"}, nil, Row{"b", "2", "x|y"}, Row{"a", "10", "a_b"}, Row{"c", "1.5*", "<c>"}}
+	for _, tc := range []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: Format{},
+			want: `|Name |Score |Note |
+|-----|------|-----|
+|b    |    2 |x|y  |
+|a    |   10 |a_b  |
+|c    | 1.5* |<c>  |
+`,
+		},
+		{
+			format: Format{Target: Markdown},
+			want: `|Name|Score|Note|
+|:---|---:|:---|
+|b|2|x\|y|
+|a|10|a_b|
+|c|1.5*|<c>|
+`,
+		},
+		{
+			format: Format{Target: CSV, Highlight: true},
+			want: `Name,Score,Note
+b,2,x|y
+a,10,a_b
+c,1.5*,<c>
+`,
+		},
+		{
+			format: Format{Target: HTML, Highlight: true},
+			want: `<table>
+<thead>
+<tr><th>Name</th><th style="text-align: right">Score</th><th>Note</th></tr>
+</thead>
+<tbody>
+<tr><td>b</td><td style="text-align: right">2</td><td>x|y</td></tr>
+<tr><td>a</td><td style="text-align: right"><b>10</b></td><td>a_b</td></tr>
+<tr><td>c</td><td style="text-align: right"><i>1.5*</i></td><td>&lt;c&gt;</td></tr>
+</tbody>
+</table>
+`,
+		},
+		{
+			format: Format{Target: LaTeX},
+			want: `\begin{tabular}{lrl}
+\hline
+Name & Score & Note \\
+\hline
+b & 2 & x|y \\
+a & 10 & a\_b \\
+c & 1.5* & <c> \\
+\hline
+\end{tabular}
+`,
+		},
+		{
+			format: Format{Align: AlignLeft},
+			want: `|Name |Score |Note |
+|-----|------|-----|
+|b    |2     |x|y  |
+|a    |10    |a_b  |
+|c    |1.5*  |<c>  |
+`,
+		},
+		{
+			format: Format{Align: AlignRight, Columns: []string{"Note", "Unknown"}},
+			want: `|Name |Note |
+|-----|-----|
+|   b | x|y |
+|   a | a_b |
+|   c | <c> |
+`,
+		},
+		{
+			format: Format{Columns: []string{"Unknown"}, SortBy: "Score"},
+			want: `|Name |Score |Note |
+|-----|------|-----|
+|c    | 1.5* |<c>  |
+|b    |    2 |x|y  |
+|a    |   10 |a_b  |
+`,
+		},
+		{
+			format: Format{Target: Markdown, SortBy: "Name", Descending: true, Highlight: true},
+			want: `|Name|Score|Note|
+|:---|---:|:---|
+|c|_1.5*_|<c>|
+|b|2|x\|y|
+|a|**10**|a_b|
+`,
+		},
+	} {
+		if got := table.Render(tc.format); got != tc.want {
+			t.Errorf("Render(%+v) =\n%s\nwant\n%s", tc.format, got, tc.want)
+		}
+	}
+	if !reflect.DeepEqual(table, original) {
+		t.Errorf("rendering modified the table to %v", table)
+	}
+}
+
+func TestRenderSections(t *testing.T) {
+	// Rows between horizontal lines are sorted separately, and cells that aren't numbers sort after numbers and
+	// make the column align left.
+	table := Table{Row{"Name", "Score"}, nil, Row{"x", "n/a"}, Row{"y", "3"}, Row{"z", "1"}, nil, Row{"w", "2"}, Row{"v", "-1"}}
+	want := `|Name |Score |
+|-----|------|
+|z    |1     |
+|y    |3     |
+|x    |n/a   |
+|-----|------|
+|v    |-1    |
+|w    |2     |
+`
+	if got := table.Render(Format{SortBy: "Score"}); got != want {
+		t.Errorf("sorted by Score =\n%s\nwant\n%s", got, want)
+	}
+	// Tables without headings are rendered as is, and Markdown gives them empty headings.
+	plain := Table{Row{"a", "1"}, Row{"bb"}}
+	if got, want := plain.Render(Format{SortBy: "a", Highlight: true}), "|a  |1 |\n|bb |  |\n"; got != want {
+		t.Errorf("table without headings =\n%s\nwant\n%s", got, want)
+	}
+	if got, want := plain.Render(Format{Target: Markdown}), "|||\n|:---|---:|\n|a|1|\n|bb||\n"; got != want {
+		t.Errorf("Markdown table without headings =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHeadingAndParagraph(t *testing.T) {
+	for _, tc := range []struct {
+		target    Target
+		heading   string
+		paragraph string
+	}{
+		{Text, "## A & b_c\n\n", "x < y\n\n"},
+		{Markdown, "## A & b_c\n\n", "x < y\n\n"},
+		{CSV, "A & b_c\n", "x < y\n"},
+		{HTML, "<h2>A &amp; b_c</h2>\n\n", "<p>x &lt; y</p>\n\n"},
+		{LaTeX, "\\subsection*{A \\& b\\_c}\n\n", "x < y\n\n"},
+	} {
+		format := Format{Target: tc.target}
+		if got := format.Heading(2, "A & b_c"); got != tc.heading {
+			t.Errorf("%v heading = %q, want %q", tc.target, got, tc.heading)
+		}
+		if got := format.Paragraph("x < y"); got != tc.paragraph {
+			t.Errorf("%v paragraph = %q, want %q", tc.target, got, tc.paragraph)
+		}
+	}
+}
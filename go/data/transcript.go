@@ -0,0 +1,84 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// transcriptsMetadataKey is the METADATA key of the transcripts of the references of a study.
+const transcriptsMetadataKey = "transcripts"
+
+// TranscriptMeasurement returns the score of a distortion given the reference and the transcript of what's said in
+// the reference, e.g. the word error rate of speech recognized in the distortion.
+type TranscriptMeasurement func(transcript string, reference, distortion *audio.Audio) (float64, error)
+
+// bind returns a measurement using the transcript.
+func (t TranscriptMeasurement) bind(transcript string, dist *Distortion) Measurement {
+	return func(reference, distortion *audio.Audio) (float64, error) {
+		// The transcript is of the whole reference, so it doesn't match the segments.
+		if len(dist.Segments) > 0 {
+			return 0, fmt.Errorf("transcript measurements don't support segments")
+		}
+		return t(transcript, reference, distortion)
+	}
+}
+
+// Transcripts returns the transcripts of the references of the study by reference name.
+func (s *Study) Transcripts() (map[string]string, error) {
+	result := map[string]string{}
+	var value string
+	if err := s.db.QueryRow("SELECT VALUE FROM METADATA WHERE KEY = ?", transcriptsMetadataKey).Scan(&value); err == sql.ErrNoRows {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil, fmt.Errorf("invalid transcripts in %q: %v", s.dir, err)
+	}
+	return result, nil
+}
+
+// PutTranscripts stores the transcripts of references of the study by reference name, replacing earlier transcripts
+// of the same references. Transcripts of references not in the study are ignored, and the number of stored
+// transcripts is returned.
+func (s *Study) PutTranscripts(transcripts map[string]string) (int, error) {
+	existing, err := s.Transcripts()
+	if err != nil {
+		return 0, err
+	}
+	stored := 0
+	if err := s.ViewEachReference(func(ref *Reference) error {
+		if transcript, found := transcripts[ref.Name]; found {
+			existing[ref.Name] = transcript
+			stored++
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	b, err := json.Marshal(existing)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = s.db.Exec("INSERT INTO METADATA (KEY, VALUE) VALUES (?, ?) ON CONFLICT (KEY) DO UPDATE SET VALUE = excluded.VALUE", transcriptsMetadataKey, string(b)); err != nil {
+		return 0, err
+	}
+	return stored, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// The binaural rendering uses the spherical head model from C. P. Brown and R. O. Duda, "A structural model for
+// binaural sound synthesis", IEEE Transactions on Speech and Audio Processing, 1998, which approximates the
+// interaural time and level differences of HRTFs without the need for a measured HRTF set.
+const (
+	// headRadius is the radius of the spherical head in meters.
+	headRadius = 0.0875
+	// speedOfSound is the speed of sound in meters per second.
+	speedOfSound = 343.0
+	// minShadowAlpha is the min gain of the head shadow filter at high frequencies.
+	minShadowAlpha = 0.1
+	// minShadowAngle is the angle from the ear, in degrees, where the head shadow is strongest.
+	minShadowAngle = 150.0
+)
+
+// speakerAzimuths contains, for each supported layout, the azimuth in degrees of each channel, positive to the left,
+// in the default ffmpeg channel order. NaN means the channel is not spatialized, like the LFE channel.
+var speakerAzimuths = map[int][]float64{
+	// FL FR
+	2: {30, -30},
+	// FL FR FC LFE SL SR
+	6: {30, -30, 0, math.NaN(), 110, -110},
+	// FL FR FC LFE BL BR SL SR
+	8: {30, -30, 0, math.NaN(), 150, -150, 90, -90},
+}
+
+// ambisonicSpeakerAzimuths contains the azimuths in degrees of the virtual speakers first order ambisonics is decoded to before binaural rendering.
+var ambisonicSpeakerAzimuths = []float64{45, -45, 135, -135}
+
+// binauralize returns a stereo signal where each channel of a is rendered from a virtual speaker at the given azimuth.
+func binauralize(a *audio.Audio, azimuths []float64) *audio.Audio {
+	n := numFrames(a)
+	result := &audio.Audio{
+		Samples: [][]float32{make([]float32, n), make([]float32, n)},
+		Rate:    a.Rate,
+	}
+	for channelIndex, azimuth := range azimuths {
+		for earIndex, earAzimuth := range []float64{90, -90} {
+			out := result.Samples[earIndex]
+			if math.IsNaN(azimuth) {
+				for sampleIndex, sample := range a.Samples[channelIndex] {
+					out[sampleIndex] += sample * math.Sqrt2 / 2
+				}
+				continue
+			}
+			renderEar(a.Samples[channelIndex], out, azimuth-earAzimuth, a.Rate)
+		}
+	}
+	for _, channel := range result.Samples {
+		for _, sample := range channel {
+			if absSample := float32(math.Abs(float64(sample))); absSample > result.MaxAbsAmplitude {
+				result.MaxAbsAmplitude = absSample
+			}
+		}
+	}
+	return result
+}
+
+// renderEar adds the signal as heard by an ear at incidence degrees from the source to out, by delaying it
+// according to the Woodworth formula and filtering it with the head shadow filter.
+func renderEar(signal []float32, out []float32, incidence float64, rate float64) {
+	theta := math.Abs(math.Remainder(incidence, 360)) * math.Pi / 180
+	// The delay is offset by headRadius / speedOfSound to make it non negative.
+	delay := headRadius / speedOfSound
+	if theta < math.Pi/2 {
+		delay -= headRadius / speedOfSound * math.Cos(theta)
+	} else {
+		delay += headRadius / speedOfSound * (theta - math.Pi/2)
+	}
+	delaySamples := int(math.Round(delay * rate))
+	alpha := (1 + minShadowAlpha/2) + (1-minShadowAlpha/2)*math.Cos(theta*180/minShadowAngle)
+	// Bilinear transform of H(s) = (2 w0 + alpha s) / (2 w0 + s), with w0 = speedOfSound / headRadius.
+	w0, k := speedOfSound/headRadius, 2*rate
+	b0 := (2*w0 + alpha*k) / (2*w0 + k)
+	b1 := (2*w0 - alpha*k) / (2*w0 + k)
+	a1 := (2*w0 - k) / (2*w0 + k)
+	prevIn, prevOut := 0.0, 0.0
+	for sampleIndex := 0; sampleIndex+delaySamples < len(out) && sampleIndex < len(signal); sampleIndex++ {
+		in := float64(signal[sampleIndex])
+		filtered := b0*in + b1*prevIn - a1*prevOut
+		prevIn, prevOut = in, filtered
+		out[sampleIndex+delaySamples] += float32(filtered)
+	}
+}
+
+// binauralAmbisonic returns a stereo signal rendered from first order AmbiX audio decoded to virtual speakers.
+func binauralAmbisonic(a *audio.Audio) *audio.Audio {
+	n := numFrames(a)
+	speakers := &audio.Audio{
+		Samples: make([][]float32, len(ambisonicSpeakerAzimuths)),
+		Rate:    a.Rate,
+	}
+	for speakerIndex, azimuth := range ambisonicSpeakerAzimuths {
+		radians := azimuth * math.Pi / 180
+		// Virtual cardioid microphones pointing at the speakers, from the W, Y, and X channels.
+		w, y, x := float32(0.5), float32(0.5*math.Sin(radians)), float32(0.5*math.Cos(radians))
+		speaker := make([]float32, n)
+		for sampleIndex := range speaker {
+			speaker[sampleIndex] = w*a.Samples[0][sampleIndex] + y*a.Samples[1][sampleIndex] + x*a.Samples[3][sampleIndex]
+		}
+		speakers.Samples[speakerIndex] = speaker
+	}
+	return binauralize(speakers, ambisonicSpeakerAzimuths)
+}
+
+// applyBinaural implements ChannelsBinaural and ChannelsAmbisonicBinaural.
+func (p ChannelPolicy) applyBinaural(a *audio.Audio) (*audio.Audio, error) {
+	numChannels := len(a.Samples)
+	if numChannels == 1 {
+		return a, nil
+	}
+	if p == ChannelsAmbisonicBinaural {
+		if numChannels != 4 {
+			return nil, fmt.Errorf("the signal has %v channels, and channel policy %q only renders first order ambisonics signals with 4 channels", numChannels, p)
+		}
+		return binauralAmbisonic(a), nil
+	}
+	azimuths, found := speakerAzimuths[numChannels]
+	if !found {
+		return nil, fmt.Errorf("the signal has %v channels, and channel policy %q only renders stereo, 5.1, and 7.1 signals", numChannels, p)
+	}
+	return binauralize(a, azimuths), nil
+}
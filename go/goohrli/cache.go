@@ -0,0 +1,250 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// analysisMagic prefixes all serialized analyses.
+var analysisMagic = [4]byte{'Z', 'A', 'N', '1'}
+
+type analysisHeader struct {
+	Magic       [4]byte
+	NumSteps    uint32
+	NumChannels uint32
+}
+
+// Write writes a compact binary representation of the spectrogram of the analysis to w.
+func (a *Analysis) Write(w io.Writer) error {
+	spectrogram := a.Spectrogram()
+	header := analysisHeader{
+		Magic:    analysisMagic,
+		NumSteps: uint32(len(spectrogram)),
+	}
+	if len(spectrogram) > 0 {
+		header.NumChannels = uint32(len(spectrogram[0]))
+	}
+	bufWriter := bufio.NewWriter(w)
+	if err := binary.Write(bufWriter, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	for _, step := range spectrogram {
+		if err := binary.Write(bufWriter, binary.LittleEndian, step); err != nil {
+			return err
+		}
+	}
+	return bufWriter.Flush()
+}
+
+// ReadAnalysis reads an analysis written by Analysis.Write.
+func ReadAnalysis(r io.Reader) (*Analysis, error) {
+	bufReader := bufio.NewReader(r)
+	header := analysisHeader{}
+	if err := binary.Read(bufReader, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("while reading analysis header: %v", err)
+	}
+	if header.Magic != analysisMagic {
+		return nil, fmt.Errorf("not a serialized analysis: %q", header.Magic[:])
+	}
+	// The dimensions in the header are checked against the data that actually follows before allocating the
+	// spectrogram, so that corrupt or truncated analyses fail instead of allocating arbitrary amounts of memory.
+	numValues := uint64(header.NumSteps) * uint64(header.NumChannels)
+	if numValues > math.MaxInt64/4 {
+		return nil, fmt.Errorf("analysis dimensions %vx%v are too large", header.NumSteps, header.NumChannels)
+	}
+	size := int64(numValues * 4)
+	body, err := io.ReadAll(io.LimitReader(bufReader, size))
+	if err != nil {
+		return nil, fmt.Errorf("while reading analysis spectrogram: %v", err)
+	}
+	if int64(len(body)) != size {
+		return nil, fmt.Errorf("analysis dimensions %vx%v need %v bytes of spectrogram, but the input has %v", header.NumSteps, header.NumChannels, size, len(body))
+	}
+	spectrogram := make([][]float32, header.NumSteps)
+	for stepIndex := range spectrogram {
+		spectrogram[stepIndex] = make([]float32, header.NumChannels)
+		for channelIndex := range spectrogram[stepIndex] {
+			offset := 4 * (stepIndex*int(header.NumChannels) + channelIndex)
+			spectrogram[stepIndex][channelIndex] = math.Float32frombits(binary.LittleEndian.Uint32(body[offset:]))
+		}
+	}
+	return NewAnalysis(spectrogram)
+}
+
+// Save stores the analysis in a file.
+func (a *Analysis) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := a.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadAnalysis loads an analysis stored by Analysis.Save.
+func LoadAnalysis(path string) (*Analysis, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAnalysis(f)
+}
+
+// AnalysisCache stores analyses in a directory, keyed by the analyzed signal and the parameters used to analyze it.
+type AnalysisCache struct {
+	Dir string
+}
+
+func (c *AnalysisCache) path(g *Goohrli, signal []float32) (string, error) {
+	hash := sha256.New()
+	params, err := json.Marshal(g.Parameters())
+	if err != nil {
+		return "", err
+	}
+	hash.Write(params)
+	if len(signal) > 0 {
+		hash.Write(unsafe.Slice((*byte)(unsafe.Pointer(&signal[0])), len(signal)*4))
+	}
+	return filepath.Join(c.Dir, fmt.Sprintf("%s.analysis", hex.EncodeToString(hash.Sum(nil)))), nil
+}
+
+// Analyze returns the cached analysis of the signal if one exists, otherwise it analyzes the signal using g and caches the result.
+func (c *AnalysisCache) Analyze(g *Goohrli, signal []float32) (*Analysis, error) {
+	path, err := c.path(g, signal)
+	if err != nil {
+		return nil, err
+	}
+	if analysis, err := LoadAnalysis(path); err == nil {
+		return analysis, nil
+	} else if !os.IsNotExist(err) {
+		logging.Warningf("Ignoring unreadable cached analysis %q: %v", path, err)
+	}
+	analysis := g.Analyze(signal)
+	if err := writeCacheFile(c.Dir, path, "zimtohrli.go.goohrli.AnalysisCache.*.tmp", analysis.Write); err != nil {
+		return nil, err
+	}
+	return analysis, nil
+}
+
+// writeCacheFile atomically creates or replaces the file at path in dir with the content written by write, so that
+// concurrent readers never see partial files.
+func writeCacheFile(dir, path, pattern string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return err
+	}
+	if err := write(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+func (g *Goohrli) cachedAnalyze(signal []float32) *Analysis {
+	analysis, err := g.AnalysisCache.Analyze(g, signal)
+	if err != nil {
+		logging.Warningf("Unable to use analysis cache %q: %v", g.AnalysisCache.Dir, err)
+		return g.Analyze(signal)
+	}
+	return analysis
+}
+
+// ResultCache stores results of measurements in a directory, keyed by the measured signals and a description of
+// the metric and its parameters.
+//
+// The description must change whenever the metric would produce different results for the same signals, e.g. by
+// including the parameters and version of the metric, since stale results are otherwise returned.
+type ResultCache struct {
+	Dir string
+}
+
+func (c *ResultCache) path(metric string, a, b *audio.Audio) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q", metric)
+	for _, signal := range []*audio.Audio{a, b} {
+		binary.Write(hash, binary.LittleEndian, []float64{signal.Rate, float64(signal.MaxAbsAmplitude), float64(len(signal.Samples))})
+		for _, channel := range signal.Samples {
+			binary.Write(hash, binary.LittleEndian, uint64(len(channel)))
+			if len(channel) > 0 {
+				hash.Write(unsafe.Slice((*byte)(unsafe.Pointer(&channel[0])), len(channel)*4))
+			}
+		}
+	}
+	return filepath.Join(c.Dir, fmt.Sprintf("%s.result", hex.EncodeToString(hash.Sum(nil))))
+}
+
+// Measure returns the cached result of the metric for a and b if one exists, otherwise it measures them using
+// measure and caches the result.
+//
+// Non-finite results and errors are not cached, and failing to store a result is logged instead of returned.
+func (c *ResultCache) Measure(metric string, a, b *audio.Audio, measure func(a, b *audio.Audio) (float64, error)) (float64, error) {
+	// The path is computed before measuring, since measurements may modify the signals.
+	path := c.path(metric, a, b)
+	if content, err := os.ReadFile(path); err == nil {
+		result, err := strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+		if err == nil {
+			return result, nil
+		}
+		logging.Warningf("Ignoring unreadable cached result %q: %v", path, err)
+	} else if !os.IsNotExist(err) {
+		logging.Warningf("Ignoring unreadable cached result %q: %v", path, err)
+	}
+	result, err := measure(a, b)
+	if err != nil || math.IsNaN(result) || math.IsInf(result, 0) {
+		return result, err
+	}
+	if err := writeCacheFile(c.Dir, path, "zimtohrli.go.goohrli.ResultCache.*.tmp", func(w io.Writer) error {
+		_, err := io.WriteString(w, strconv.FormatFloat(result, 'g', -1, 64))
+		return err
+	}); err != nil {
+		logging.Warningf("Unable to use result cache %q: %v", c.Dir, err)
+	}
+	return result, nil
+}
+
+// Measurement returns measure wrapped to use the cache for the metric.
+func (c *ResultCache) Measurement(metric string, measure func(a, b *audio.Audio) (float64, error)) func(a, b *audio.Audio) (float64, error) {
+	return func(a, b *audio.Audio) (float64, error) {
+		return c.Measure(metric, a, b, measure)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// ChannelPolicy defines how signals with multiple channels are compared.
+type ChannelPolicy string
+
+const (
+	// ChannelsPerChannel compares each channel separately, and combines the distances of the channels with their root mean square. This is the default.
+	ChannelsPerChannel ChannelPolicy = "per_channel"
+	// ChannelsDownmix downmixes 5.1 and 7.1 signals, in the default ffmpeg channel order, to stereo according to ITU-R BS.775 before comparing them.
+	ChannelsDownmix ChannelPolicy = "downmix"
+	// ChannelsAmbisonic decodes first order ambisonics signals, in AmbiX (ACN channel order and SN3D normalization) format, to stereo using two virtual cardioid microphones pointing left and right before comparing them.
+	ChannelsAmbisonic ChannelPolicy = "ambisonic"
+	// ChannelsBinaural renders stereo, 5.1, and 7.1 signals, in the default ffmpeg channel order, from virtual speakers to the two ears of a spherical head model before comparing them.
+	ChannelsBinaural ChannelPolicy = "binaural"
+	// ChannelsAmbisonicBinaural decodes first order ambisonics signals, in AmbiX format, to four virtual speakers, and renders them like ChannelsBinaural before comparing them.
+	ChannelsAmbisonicBinaural ChannelPolicy = "ambisonic_binaural"
+	// ChannelsReject returns an error for signals with more than two channels.
+	ChannelsReject ChannelPolicy = "reject"
+)
+
+// ChannelPolicies contains all channel policies.
+var ChannelPolicies = []ChannelPolicy{ChannelsPerChannel, ChannelsDownmix, ChannelsAmbisonic, ChannelsBinaural, ChannelsAmbisonicBinaural, ChannelsReject}
+
+// downmixCoefficients contains, for 5.1 and 7.1 layouts, the contribution of each channel to the left and right channels of the stereo downmix.
+var downmixCoefficients = map[int][2][]float32{
+	// FL FR FC LFE SL SR
+	6: {
+		{1, 0, math.Sqrt2 / 2, 0, math.Sqrt2 / 2, 0},
+		{0, 1, math.Sqrt2 / 2, 0, 0, math.Sqrt2 / 2},
+	},
+	// FL FR FC LFE BL BR SL SR
+	8: {
+		{1, 0, math.Sqrt2 / 2, 0, math.Sqrt2 / 2, 0, math.Sqrt2 / 2, 0},
+		{0, 1, math.Sqrt2 / 2, 0, 0, math.Sqrt2 / 2, 0, math.Sqrt2 / 2},
+	},
+}
+
+// ambisonicCoefficients contains the contribution of each AmbiX channel (W Y Z X) to the left and right channels of the stereo decoding.
+var ambisonicCoefficients = [2][]float32{
+	{0.5, 0.5, 0, 0},
+	{0.5, -0.5, 0, 0},
+}
+
+// Apply returns the signal adjusted according to the policy.
+//
+// Mono signals, and signals that don't need adjustment, are returned unchanged. Stereo signals are only adjusted by
+// ChannelsBinaural.
+func (p ChannelPolicy) Apply(a *audio.Audio) (*audio.Audio, error) {
+	numChannels := len(a.Samples)
+	switch p {
+	case "", ChannelsPerChannel:
+		return a, nil
+	case ChannelsReject:
+		if numChannels > 2 {
+			return nil, fmt.Errorf("the signal has %v channels, and channel policy %q only accepts mono and stereo", numChannels, p)
+		}
+		return a, nil
+	case ChannelsDownmix:
+		if numChannels <= 2 {
+			return a, nil
+		}
+		coefficients, found := downmixCoefficients[numChannels]
+		if !found {
+			return nil, fmt.Errorf("the signal has %v channels, and channel policy %q only downmixes 5.1 and 7.1 signals", numChannels, p)
+		}
+		return mix(a, coefficients), nil
+	case ChannelsAmbisonic:
+		if numChannels <= 2 {
+			return a, nil
+		}
+		if numChannels != 4 {
+			return nil, fmt.Errorf("the signal has %v channels, and channel policy %q only decodes first order ambisonics signals with 4 channels", numChannels, p)
+		}
+		return mix(a, ambisonicCoefficients), nil
+	case ChannelsBinaural, ChannelsAmbisonicBinaural:
+		return p.applyBinaural(a)
+	}
+	return nil, fmt.Errorf("unknown channel policy %q, want one of %v", p, ChannelPolicies)
+}
+
+// mix returns a stereo signal where each channel is the sum of the channels of a weighted by the coefficients.
+func mix(a *audio.Audio, coefficients [2][]float32) *audio.Audio {
+	result := &audio.Audio{
+		Samples: make([][]float32, 2),
+		Rate:    a.Rate,
+	}
+	for outIndex, weights := range coefficients {
+		out := make([]float32, numFrames(a))
+		for inIndex, weight := range weights {
+			if weight == 0 {
+				continue
+			}
+			for sampleIndex, sample := range a.Samples[inIndex] {
+				out[sampleIndex] += weight * sample
+			}
+		}
+		for _, sample := range out {
+			if absSample := float32(math.Abs(float64(sample))); absSample > result.MaxAbsAmplitude {
+				result.MaxAbsAmplitude = absSample
+			}
+		}
+		result.Samples[outIndex] = out
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+const (
+	// minReliableDuration is the duration, in seconds, below which confidence decreases linearly with duration.
+	minReliableDuration = 1.0
+	// silentDBFS is the energy, in dB FS, at or below which a signal is considered silent, and confidence is 0.
+	silentDBFS = -70.0
+	// quietDBFS is the energy, in dB FS, below which confidence decreases linearly towards silentDBFS.
+	quietDBFS = -50.0
+	// clippedAmplitude is the absolute amplitude at or above which a sample is considered clipped.
+	clippedAmplitude = 0.999
+	// maxClippedFraction is the fraction of clipped samples at or above which confidence is 0.
+	maxClippedFraction = 0.01
+)
+
+// Reliability describes how much a comparison between two signals can be trusted.
+type Reliability struct {
+	// Confidence is between 0 (the comparison is meaningless) and 1 (nothing suggests the comparison is unreliable).
+	Confidence float64
+	// Reasons explain why Confidence is below 1.
+	Reasons []string
+}
+
+func clamp01(f float64) float64 {
+	return math.Max(0, math.Min(1, f))
+}
+
+// signalReliability returns the confidence factors from duration, energy, and saturation of a signal.
+func signalReliability(name string, a *audio.Audio) Reliability {
+	result := Reliability{Confidence: 1}
+	frames := numFrames(a)
+	if duration := float64(frames) / a.Rate; duration < minReliableDuration {
+		result.Confidence *= clamp01(duration / minReliableDuration)
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%s is only %.3fs long", name, duration))
+	}
+	if frames == 0 {
+		return result
+	}
+	if energyDBFS := a.EnergyDBFS(); energyDBFS < quietDBFS {
+		result.Confidence *= clamp01((energyDBFS - silentDBFS) / (quietDBFS - silentDBFS))
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%s has an energy of %.1f dB FS", name, energyDBFS))
+	}
+	if clipped := a.ClippedSamples(clippedAmplitude, 1); clipped > 0 {
+		clippedFraction := float64(clipped) / float64(a.NumSamples())
+		result.Confidence *= clamp01(1 - clippedFraction/maxClippedFraction)
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%s has %.2f%% clipped samples", name, 100*clippedFraction))
+	}
+	return result
+}
+
+// ComparisonReliability returns how much a comparison between the reference and the distortion can be trusted,
+// based on the duration, energy, and saturation of the signals.
+//
+// Should be called before the amplitudes of the signals are normalized.
+func ComparisonReliability(reference, distortion *audio.Audio) Reliability {
+	ref := signalReliability("reference", reference)
+	dist := signalReliability("distortion", distortion)
+	return Reliability{
+		Confidence: ref.Confidence * dist.Confidence,
+		Reasons:    append(ref.Reasons, dist.Reasons...),
+	}
+}
+
+// Confidence returns the confidence of ComparisonReliability, and has the signature of a measurement
+// to allow storing it as an auxiliary score.
+func Confidence(reference, distortion *audio.Audio) (float64, error) {
+	return ComparisonReliability(reference, distortion).Confidence, nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build zimtohrli_dlopen && !zimtohrli_purego
+
+// Implements the functions of goohrli.h by calling the functions of the same
+// names in a shared library loaded with dlopen.
+
+#include <dlfcn.h>
+#include <pthread.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "goohrli.h"
+
+static pthread_mutex_t library_lock = PTHREAD_MUTEX_INITIALIZER;
+static void* library = NULL;
+static char library_path[4096];
+static char library_error[4096];
+
+// Loads the library at path unless a library is already loaded, with
+// library_lock held. Returns 0 on success.
+static int LoadLocked(const char* path) {
+  if (library != NULL) {
+    if (strcmp(path, library_path) == 0) {
+      return 0;
+    }
+    snprintf(library_error, sizeof(library_error),
+             "a library is already loaded from %s", library_path);
+    return -1;
+  }
+  void* handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+  if (handle == NULL) {
+    snprintf(library_error, sizeof(library_error), "%s", dlerror());
+    return -1;
+  }
+  library = handle;
+  snprintf(library_path, sizeof(library_path), "%s", path);
+  return 0;
+}
+
+int LoadZimtohrliLibrary(const char* path) {
+  pthread_mutex_lock(&library_lock);
+  const int result = LoadLocked(path);
+  pthread_mutex_unlock(&library_lock);
+  return result;
+}
+
+const char* ZimtohrliLibraryError() { return library_error; }
+
+// Returns the function with the name in the library, and loads the library
+// from $ZIMTOHRLI_LIBRARY or libzimtohrli.so first if none is loaded. Exits
+// if that fails, since the functions of goohrli.h can't return errors.
+static void* Resolve(const char* name) {
+  pthread_mutex_lock(&library_lock);
+  if (library == NULL) {
+    const char* path = getenv("ZIMTOHRLI_LIBRARY");
+    if (path == NULL || path[0] == '\0') {
+      path = "libzimtohrli.so";
+    }
+    if (LoadLocked(path) != 0) {
+      fprintf(stderr,
+              "goohrli: loading %s: %s (set $ZIMTOHRLI_LIBRARY to the path of "
+              "libzimtohrli.so)\n",
+              path, library_error);
+      exit(1);
+    }
+  }
+  void* function = dlsym(library, name);
+  if (function == NULL) {
+    fprintf(stderr, "goohrli: %s\n", dlerror());
+    exit(1);
+  }
+  pthread_mutex_unlock(&library_lock);
+  return function;
+}
+
+// Declares the variable function as the function with the same name and type
+// as the enclosing function in the library, resolved on first use.
+#define ZIMTOHRLI_FUNCTION(name)                                   \
+  static __typeof__(&name) resolved = NULL;                        \
+  __typeof__(&name) function =                                     \
+      __atomic_load_n(&resolved, __ATOMIC_ACQUIRE);                \
+  if (function == NULL) {                                          \
+    function = (__typeof__(&name))Resolve(#name);                  \
+    __atomic_store_n(&resolved, function, __ATOMIC_RELEASE);       \
+  }
+
+int NumLoudnessAFParams() {
+  ZIMTOHRLI_FUNCTION(NumLoudnessAFParams);
+  return function();
+}
+
+int NumLoudnessLUParams() {
+  ZIMTOHRLI_FUNCTION(NumLoudnessLUParams);
+  return function();
+}
+
+int NumLoudnessTFParams() {
+  ZIMTOHRLI_FUNCTION(NumLoudnessTFParams);
+  return function();
+}
+
+ZimtohrliParameters DefaultZimtohrliParameters(float sample_rate) {
+  ZIMTOHRLI_FUNCTION(DefaultZimtohrliParameters);
+  return function(sample_rate);
+}
+
+Zimtohrli CreateZimtohrli(ZimtohrliParameters params) {
+  ZIMTOHRLI_FUNCTION(CreateZimtohrli);
+  return function(params);
+}
+
+void FreeZimtohrli(Zimtohrli z) {
+  ZIMTOHRLI_FUNCTION(FreeZimtohrli);
+  function(z);
+}
+
+Analysis Analyze(Zimtohrli zimtohrli, float* data, int size) {
+  ZIMTOHRLI_FUNCTION(Analyze);
+  return function(zimtohrli, data, size);
+}
+
+EnergyAndMaxAbsAmplitude Measure(const float* signal, int size) {
+  ZIMTOHRLI_FUNCTION(Measure);
+  return function(signal, size);
+}
+
+EnergyAndMaxAbsAmplitude NormalizeAmplitude(float max_abs_amplitude,
+                                            float* signal_data, int size) {
+  ZIMTOHRLI_FUNCTION(NormalizeAmplitude);
+  return function(max_abs_amplitude, signal_data, size);
+}
+
+float MOSFromZimtohrli(float zimtohrli_distance) {
+  ZIMTOHRLI_FUNCTION(MOSFromZimtohrli);
+  return function(zimtohrli_distance);
+}
+
+void FreeAnalysis(Analysis a) {
+  ZIMTOHRLI_FUNCTION(FreeAnalysis);
+  function(a);
+}
+
+int AnalysisNumSteps(Analysis a) {
+  ZIMTOHRLI_FUNCTION(AnalysisNumSteps);
+  return function(a);
+}
+
+int AnalysisNumChannels(Analysis a) {
+  ZIMTOHRLI_FUNCTION(AnalysisNumChannels);
+  return function(a);
+}
+
+void GetAnalysisSpectrogram(Analysis a, float* data) {
+  ZIMTOHRLI_FUNCTION(GetAnalysisSpectrogram);
+  function(a, data);
+}
+
+Analysis CreateAnalysis(const float* data, int num_steps, int num_channels) {
+  ZIMTOHRLI_FUNCTION(CreateAnalysis);
+  return function(data, num_steps, num_channels);
+}
+
+float AnalysisDistance(Zimtohrli zimtohrli, Analysis a, Analysis b) {
+  ZIMTOHRLI_FUNCTION(AnalysisDistance);
+  return function(zimtohrli, a, b);
+}
+
+void SetZimtohrliParameters(Zimtohrli zimtohrli,
+                            ZimtohrliParameters parameters) {
+  ZIMTOHRLI_FUNCTION(SetZimtohrliParameters);
+  function(zimtohrli, parameters);
+}
+
+ZimtohrliParameters GetZimtohrliParameters(Zimtohrli zimtohrli) {
+  ZIMTOHRLI_FUNCTION(GetZimtohrliParameters);
+  return function(zimtohrli);
+}
+
+ViSQOL CreateViSQOL() {
+  ZIMTOHRLI_FUNCTION(CreateViSQOL);
+  return function();
+}
+
+void FreeViSQOL(ViSQOL v) {
+  ZIMTOHRLI_FUNCTION(FreeViSQOL);
+  function(v);
+}
+
+MOSResult MOS(ViSQOL v, float sample_rate, const float* reference,
+              int reference_size, const float* distorted, int distorted_size) {
+  ZIMTOHRLI_FUNCTION(MOS);
+  return function(v, sample_rate, reference, reference_size, distorted,
+                  distorted_size);
+}
+
+const char* ZimtohrliCommit() {
+  ZIMTOHRLI_FUNCTION(ZimtohrliCommit);
+  return function();
+}
+
+const char* ZimtohrliBuildFlags() {
+  ZIMTOHRLI_FUNCTION(ZimtohrliBuildFlags);
+  return function();
+}
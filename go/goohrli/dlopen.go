@@ -0,0 +1,44 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build zimtohrli_dlopen && !zimtohrli_purego
+
+package goohrli
+
+/*
+#cgo LDFLAGS: -ldl
+#include <stdlib.h>
+
+int LoadZimtohrliLibrary(const char* path);
+const char* ZimtohrliLibraryError();
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// LoadLibrary loads the C++ library from the shared object at path, like a libzimtohrli.so built by CMake.
+//
+// It has to be called before any other function of the package. Otherwise the library is loaded when first used,
+// from $ZIMTOHRLI_LIBRARY, or libzimtohrli.so in the paths searched by dlopen if that isn't set, and the process
+// aborts if that fails.
+func LoadLibrary(path string) error {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	if C.LoadZimtohrliLibrary(cPath) != 0 {
+		return fmt.Errorf("loading %q: %s", path, C.GoString(C.ZimtohrliLibraryError()))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// Embedding returns the time pooled perceptual spectrogram of the analysis: the mean over time of each
+// frequency channel, followed by the standard deviation over time of each frequency channel.
+func (a *Analysis) Embedding() []float32 {
+	spectrogram := a.Spectrogram()
+	if len(spectrogram) == 0 {
+		return nil
+	}
+	numChannels := len(spectrogram[0])
+	sums := make([]float64, numChannels)
+	sumsOfSquares := make([]float64, numChannels)
+	for _, step := range spectrogram {
+		for channelIndex, value := range step {
+			sums[channelIndex] += float64(value)
+			sumsOfSquares[channelIndex] += float64(value) * float64(value)
+		}
+	}
+	result := make([]float32, 2*numChannels)
+	numSteps := float64(len(spectrogram))
+	for channelIndex := range sums {
+		mean := sums[channelIndex] / numSteps
+		result[channelIndex] = float32(mean)
+		result[numChannels+channelIndex] = float32(math.Sqrt(max(0, sumsOfSquares[channelIndex]/numSteps-mean*mean)))
+	}
+	return result
+}
+
+// Embedding returns the mean of the embeddings of the analyses of each audio channel, see Analysis.Embedding.
+//
+// The audio is analyzed at its own level, without normalization, since Zimtohrli models absolute loudness.
+func (g *Goohrli) Embedding(signal *audio.Audio) ([]float32, error) {
+	if params := g.Parameters(); params.SampleRate != signal.Rate {
+		return nil, fmt.Errorf("the signal doesn't have the expected sample rate %v: %v", params.SampleRate, signal.Rate)
+	}
+	if len(signal.Samples) == 0 {
+		return nil, fmt.Errorf("the signal doesn't have any channels")
+	}
+	var result []float32
+	for _, channel := range signal.Samples {
+		analysis := g.analyze(channel)
+		embedding := analysis.Embedding()
+		analysis.free()
+		if result == nil {
+			result = make([]float32, len(embedding))
+		}
+		for index, value := range embedding {
+			result[index] += value / float32(len(signal.Samples))
+		}
+	}
+	return result, nil
+}
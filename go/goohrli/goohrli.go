@@ -13,21 +13,19 @@
 // limitations under the License.
 
 // Package goohrli provides a Go wrapper around zimtohrli::Zimtohrli.
+//
+// By default the package links the C++ library statically from goohrli.a, built by CMake. Built with
+// -tags zimtohrli_dlopen, it instead loads the shared library libzimtohrli.so at runtime, see LoadLibrary. Built
+// with -tags zimtohrli_purego, it uses the slower pure-Go reference implementation in go/zimt and needs neither cgo
+// nor the C++ library, see PureGo.
 package goohrli
 
-/*
-#cgo LDFLAGS: ${SRCDIR}/goohrli.a -lz -lopus -lFLAC -lvorbis -lvorbisenc -logg -lasound -lm -lstdc++
-#cgo CFLAGS: -O3
-#include "goohrli.h"
-*/
-import "C"
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"reflect"
-	"runtime"
+	"sync"
 	"time"
 
 	"github.com/google/zimtohrli/go/audio"
@@ -41,42 +39,83 @@ type EnergyAndMaxAbsAmplitude struct {
 
 // Measure returns the energy in dB FS and maximum absolute amplitude of the signal.
 func Measure(signal []float32) EnergyAndMaxAbsAmplitude {
-	measurements := C.Measure((*C.float)(&signal[0]), C.int(len(signal)))
-	return EnergyAndMaxAbsAmplitude{
-		EnergyDBFS:      float32(measurements.EnergyDBFS),
-		MaxAbsAmplitude: float32(measurements.MaxAbsAmplitude),
-	}
+	return measure(signal)
 }
 
 // NormalizeAmplitude normalizes the amplitudes of the signal so that it has the provided max
 // amplitude, and returns the new energ in dB FS, and the new maximum absolute amplitude.
 func NormalizeAmplitude(maxAbsAmplitude float32, signal []float32) EnergyAndMaxAbsAmplitude {
-	measurements := C.NormalizeAmplitude(C.float(maxAbsAmplitude), (*C.float)(&signal[0]), C.int(len(signal)))
-	return EnergyAndMaxAbsAmplitude{
-		EnergyDBFS:      float32(measurements.EnergyDBFS),
-		MaxAbsAmplitude: float32(measurements.MaxAbsAmplitude),
-	}
+	return normalizeAmplitude(maxAbsAmplitude, signal)
 }
 
 // MOSFromZimtohrli returns an approximate mean opinion score for a given zimtohrli distance.
 func MOSFromZimtohrli(zimtohrliDistance float64) float64 {
-	return float64(C.MOSFromZimtohrli(C.float(zimtohrliDistance)))
+	return mosFromZimtohrli(zimtohrliDistance)
+}
+
+// MOSMapping contains the coefficients [a, b, c] of the sigmoid a / (b + exp(c * distance)) used to map Zimtohrli distances to mean opinion scores.
+type MOSMapping [3]float64
+
+// DefaultMOSMapping is the mapping used by MOSFromZimtohrli, optimized using `mos_mapping.ipynb`.
+var DefaultMOSMapping = MOSMapping{1.000e+00, -7.449e-09, 3.344e+00}
+
+func (m MOSMapping) sigmoid(x float64) float64 {
+	return m[0] / (m[1] + math.Exp(m[2]*x))
+}
+
+// MOS returns an approximate mean opinion score between 1 and 5 for a given Zimtohrli distance.
+func (m MOSMapping) MOS(zimtohrliDistance float64) float64 {
+	return 1.0 + 4.0*m.sigmoid(zimtohrliDistance)/m.sigmoid(0)
+}
+
+// Zimtohrli returns the Zimtohrli distance that maps to the mean opinion score, i.e. the inverse of MOS.
+//
+// Mean opinion scores are clamped to the range [1, 5] of MOS, so scores at or below 1 return +Inf, and scores at or
+// above 5 return 0.
+func (m MOSMapping) Zimtohrli(mos float64) float64 {
+	if mos >= 5 {
+		return 0
+	}
+	y := (mos - 1.0) / 4.0 * m.sigmoid(0)
+	if y <= 0 {
+		return math.Inf(1)
+	}
+	return max(0, math.Log(m[0]/y-m[1])/m[2])
+}
+
+// ZimtohrliFromMOS returns the Zimtohrli distance that MOSFromZimtohrli maps to the mean opinion score.
+func ZimtohrliFromMOS(mos float64) float64 {
+	return DefaultMOSMapping.Zimtohrli(mos)
 }
 
 // Goohrli is a Go wrapper around zimtohrli::Zimtohrli.
 type Goohrli struct {
-	zimtohrli C.Zimtohrli
+	// AnalysisCache, if set, is used to store and reuse analyses of signals.
+	AnalysisCache *AnalysisCache
+	// LengthPolicy defines how NormalizedAudioDistance and CompareMany handle signals of different lengths.
+	LengthPolicy LengthPolicy
+	// ChannelPolicy defines how NormalizedAudioDistance and CompareMany handle signals with more than two channels.
+	ChannelPolicy ChannelPolicy
+	// Symmetry defines in which directions NormalizedAudioDistance, CompareMany, and DistanceMatrix compute distances.
+	Symmetry Symmetry
+	// OnTiming, if set, is called after each successful CompareMany, including those of NormalizedAudioDistance,
+	// with the time spent in its stages. It may be called concurrently.
+	OnTiming func(Timing)
+
+	zimtohrli *zimtohrli
+	// rates contains the instances for other sample rates created by ForRate.
+	rates     map[float64]*Goohrli
+	ratesLock sync.Mutex
 }
 
 // New returns a new Goohrli for the given parameters.
+//
+// With the pure-Go reference implementation, the parameters may not allow creating a filterbank, which makes all
+// distances NaN and CompareMany return the error. ForRate returns such errors directly.
 func New(params Parameters) *Goohrli {
-	result := &Goohrli{
-		zimtohrli: C.CreateZimtohrli(cFromGoParameters(params)),
+	return &Goohrli{
+		zimtohrli: newZimtohrli(params),
 	}
-	runtime.SetFinalizer(result, func(g *Goohrli) {
-		C.FreeZimtohrli(g.zimtohrli)
-	})
-	return result
 }
 
 // Duration wraps a time.Duration to provide specialized JSON marshal/unmarshal methods.
@@ -115,6 +154,7 @@ type Parameters struct {
 	FrequencyResolution  float64
 	PerceptualSampleRate float64
 	ApplyMasking         bool
+	// FullScaleSineDB is the assumed playback level, in dB SPL, of a sine wave with amplitude 1.
 	FullScaleSineDB      float64
 	ApplyLoudness        bool
 	UnwarpWindow         Duration
@@ -177,110 +217,26 @@ func (p *Parameters) Update(b []byte) error {
 	return nil
 }
 
-func cFromGoParameters(params Parameters) C.ZimtohrliParameters {
-	var cParams C.ZimtohrliParameters
-	cParams.SampleRate = C.float(params.SampleRate)
-	cParams.FrequencyResolution = C.float(params.FrequencyResolution)
-	cParams.PerceptualSampleRate = C.float(params.PerceptualSampleRate)
-	if params.ApplyMasking {
-		cParams.ApplyMasking = 1
-	} else {
-		cParams.ApplyMasking = 0
-	}
-	cParams.FullScaleSineDB = C.float(params.FullScaleSineDB)
-	if params.ApplyLoudness {
-		cParams.ApplyLoudness = 1
-	} else {
-		cParams.ApplyLoudness = 0
-	}
-	cParams.UnwarpWindowSeconds = C.float(float64(params.UnwarpWindow.Duration) / float64(time.Second))
-	cParams.NSIMStepWindow = C.int(params.NSIMStepWindow)
-	cParams.NSIMChannelWindow = C.int(params.NSIMChannelWindow)
-	cParams.MaskingLowerZeroAt20 = C.float(params.MaskingLowerZeroAt20)
-	cParams.MaskingLowerZeroAt80 = C.float(params.MaskingLowerZeroAt80)
-	cParams.MaskingUpperZeroAt20 = C.float(params.MaskingUpperZeroAt20)
-	cParams.MaskingUpperZeroAt80 = C.float(params.MaskingUpperZeroAt80)
-	cParams.MaskingMaxMask = C.float(params.MaskingMaxMask)
-	cParams.FilterOrder = C.int(params.FilterOrder)
-	cParams.FilterPassBandRipple = C.float(params.FilterPassBandRipple)
-	cParams.FilterStopBandRipple = C.float(params.FilterStopBandRipple)
-	if int(C.NumLoudnessAFParams()) != len(params.LoudnessAFParams) {
-		log.Panicf("C++ API uses %v AF parameters for loudness, but Go API uses %v", C.NumLoudnessAFParams(), len(params.LoudnessAFParams))
-	}
-	for i, f := range params.LoudnessAFParams {
-		cParams.LoudnessAFParams[i] = C.float(f)
-	}
-	if int(C.NumLoudnessLUParams()) != len(params.LoudnessLUParams) {
-		log.Panicf("C++ API uses %v LU parameters for loudness, but Go API uses %v", C.NumLoudnessLUParams(), len(params.LoudnessLUParams))
-	}
-	for i, f := range params.LoudnessLUParams {
-		cParams.LoudnessLUParams[i] = C.float(f)
-	}
-	if int(C.NumLoudnessTFParams()) != len(params.LoudnessTFParams) {
-		log.Panicf("C++ API uses %v TF parameters for loudness, but Go API uses %v", C.NumLoudnessTFParams(), len(params.LoudnessTFParams))
-	}
-	for i, f := range params.LoudnessTFParams {
-		cParams.LoudnessTFParams[i] = C.float(f)
-	}
-	return cParams
-}
-
-func goFromCParameters(cParams C.ZimtohrliParameters) Parameters {
-	result := Parameters{
-		SampleRate:           float64(cParams.SampleRate),
-		FrequencyResolution:  float64(cParams.FrequencyResolution),
-		PerceptualSampleRate: float64(cParams.PerceptualSampleRate),
-		ApplyMasking:         cParams.ApplyMasking != 0,
-		FullScaleSineDB:      float64(cParams.FullScaleSineDB),
-		ApplyLoudness:        cParams.ApplyLoudness != 0,
-		UnwarpWindow:         Duration{time.Duration(float64(time.Second) * float64(cParams.UnwarpWindowSeconds))},
-		NSIMStepWindow:       int(cParams.NSIMStepWindow),
-		NSIMChannelWindow:    int(cParams.NSIMChannelWindow),
-		MaskingLowerZeroAt20: float64(cParams.MaskingLowerZeroAt20),
-		MaskingLowerZeroAt80: float64(cParams.MaskingLowerZeroAt80),
-		MaskingUpperZeroAt20: float64(cParams.MaskingUpperZeroAt20),
-		MaskingUpperZeroAt80: float64(cParams.MaskingUpperZeroAt80),
-		MaskingMaxMask:       float64(cParams.MaskingMaxMask),
-		FilterOrder:          int(cParams.FilterOrder),
-		FilterPassBandRipple: float64(cParams.FilterPassBandRipple),
-		FilterStopBandRipple: float64(cParams.FilterStopBandRipple),
-	}
-	if int(C.NumLoudnessAFParams()) != len(result.LoudnessAFParams) {
-		log.Panicf("C++ API uses %v AF parameters for loudness, but Go API uses %v", C.NumLoudnessAFParams(), len(result.LoudnessAFParams))
-	}
-	for i, cFloat := range cParams.LoudnessAFParams {
-		result.LoudnessAFParams[i] = float64(cFloat)
-	}
-	if int(C.NumLoudnessLUParams()) != len(result.LoudnessLUParams) {
-		log.Panicf("C++ API uses %v LU parameters for loudness, but Go API uses %v", C.NumLoudnessLUParams(), len(result.LoudnessLUParams))
-	}
-	for i, cFloat := range cParams.LoudnessLUParams {
-		result.LoudnessLUParams[i] = float64(cFloat)
-	}
-	if int(C.NumLoudnessTFParams()) != len(result.LoudnessTFParams) {
-		log.Panicf("C++ API uses %v TF parameters for loudness, but Go API uses %v", C.NumLoudnessTFParams(), len(result.LoudnessTFParams))
-	}
-	for i, cFloat := range cParams.LoudnessTFParams {
-		result.LoudnessTFParams[i] = float64(cFloat)
-	}
-	return result
-}
-
 // DefaultParameters returns the default Zimtohrli parameters.
 func DefaultParameters(sampleRate float64) Parameters {
-	return goFromCParameters(C.DefaultZimtohrliParameters(C.float(sampleRate)))
+	return defaultParameters(sampleRate)
 }
 
 // Parameters returns the parameters controlling the behavior of this instance.
 func (g *Goohrli) Parameters() Parameters {
-	return goFromCParameters(C.GetZimtohrliParameters(g.zimtohrli))
+	return g.zimtohrli.parameters()
 }
 
 // Set updates the parameters controlling the behavior of this instance.
 //
 // SampleRate, FrequencyResolution, and Filter*-parameters can't be updated and will be ignored in this method.
+//
+// The instances for other sample rates created by ForRate are dropped, to be recreated with the new parameters.
 func (g *Goohrli) Set(params Parameters) {
-	C.SetZimtohrliParameters(g.zimtohrli, cFromGoParameters(params))
+	g.zimtohrli.set(params)
+	g.ratesLock.Lock()
+	defer g.ratesLock.Unlock()
+	g.rates = nil
 }
 
 func (g *Goohrli) String() string {
@@ -288,87 +244,275 @@ func (g *Goohrli) String() string {
 }
 
 // NormalizedAudioDistance returns the distance between the audio files after normalizing their amplitudes for the same max amplitude.
+//
+// The audio files must have the same sample rate, but it doesn't have to be the sample rate of g, see ForRate.
 func (g *Goohrli) NormalizedAudioDistance(audioA, audioB *audio.Audio) (float64, error) {
-	sumOfSquares := 0.0
+	distances, err := g.CompareMany(audioA, []*audio.Audio{audioB})
+	if err != nil {
+		return 0, err
+	}
+	return distances[0], nil
+}
+
+// CompareMany returns the distances between the reference and each of the distortions after normalizing
+// the amplitudes of the distortions to the max amplitude of the reference.
+//
+// Channels are silent when all their samples are equal, since a DC offset is inaudible. Channels where both the
+// reference and the distortion are silent have distance 0, and distortion channels aren't normalized when the
+// reference channel is silent, since there is no level to normalize them to. Empty references and distortions
+// are errors.
+//
+// The reference is only analyzed once, which makes this faster than calling NormalizedAudioDistance for
+// each distortion. With a symmetric Symmetry each distortion is also compared to the reference, normalized to
+// the max amplitude of the distortion.
+//
+// The reference and distortions must have the same sample rate, and are measured by ForRate(reference.Rate).
+func (g *Goohrli) CompareMany(reference *audio.Audio, distortions []*audio.Audio) ([]float64, error) {
+	onTiming := g.OnTiming
+	timing := Timing{}
+	g, err := g.ForRate(reference.Rate)
+	if err != nil {
+		return nil, fmt.Errorf("the reference: %v", err)
+	}
+	if err := g.Symmetry.validate(); err != nil {
+		return nil, err
+	}
+	var backward []float64
+	if g.Symmetry.Symmetric() {
+		backward = make([]float64, len(distortions))
+		for distortionIndex, distortion := range distortions {
+			// The reference is normalized in place, so each comparison gets a copy.
+			referenceCopy := &audio.Audio{Samples: make([][]float32, len(reference.Samples)), Rate: reference.Rate, MaxAbsAmplitude: reference.MaxAbsAmplitude}
+			for channelIndex, channel := range reference.Samples {
+				referenceCopy.Samples[channelIndex] = append([]float32{}, channel...)
+			}
+			distances, err := g.compareMany(distortion, []*audio.Audio{referenceCopy}, &timing)
+			if err != nil {
+				return nil, fmt.Errorf("distortion %v as reference: %v", distortionIndex, err)
+			}
+			backward[distortionIndex] = distances[0]
+		}
+	}
+	result, err := g.compareMany(reference, distortions, &timing)
+	if err != nil {
+		return nil, err
+	}
+	if backward != nil {
+		for distortionIndex := range result {
+			result[distortionIndex] = g.Symmetry.Combine(result[distortionIndex], backward[distortionIndex])
+		}
+	}
+	if onTiming != nil {
+		onTiming(timing)
+	}
+	return result, nil
+}
+
+// compareMany implements CompareMany for SymmetryForward, and adds the time spent in its stages to timing.
+func (g *Goohrli) compareMany(reference *audio.Audio, distortions []*audio.Audio, timing *Timing) ([]float64, error) {
+	reference, err := g.ChannelPolicy.Apply(reference)
+	if err != nil {
+		return nil, fmt.Errorf("the reference: %v", err)
+	}
+	distortions = append([]*audio.Audio{}, distortions...)
+	for distortionIndex, distortion := range distortions {
+		if distortions[distortionIndex], err = g.ChannelPolicy.Apply(distortion); err != nil {
+			return nil, fmt.Errorf("distortion %v: %v", distortionIndex, err)
+		}
+	}
 	params := g.Parameters()
-	if params.SampleRate != audioA.Rate || params.SampleRate != audioB.Rate {
-		return 0, fmt.Errorf("one of the audio files doesn't have the expected sample rate %v: %v, %v", params.SampleRate, audioA.Rate, audioB.Rate)
+	if params.SampleRate != reference.Rate {
+		return nil, fmt.Errorf("the reference doesn't have the expected sample rate %v: %v", params.SampleRate, reference.Rate)
 	}
-	if len(audioA.Samples) != len(audioB.Samples) {
-		return 0, fmt.Errorf("the audio files don't have the same number of channels: %v, %v", len(audioA.Samples), len(audioB.Samples))
+	if len(reference.Samples) == 0 {
+		return nil, fmt.Errorf("the reference doesn't have any channels")
 	}
-	if len(audioA.Samples) == 0 {
-		return 0, fmt.Errorf("the audio files don't have any channels")
+	if len(reference.Samples[0]) == 0 {
+		return nil, fmt.Errorf("the reference is empty")
 	}
-	for channelIndex := range audioA.Samples {
-		measurement := Measure(audioA.Samples[channelIndex])
-		NormalizeAmplitude(measurement.MaxAbsAmplitude, audioB.Samples[channelIndex])
-		dist := float64(g.Distance(audioA.Samples[channelIndex], audioB.Samples[channelIndex]))
-		if math.IsNaN(dist) {
-			return 0, fmt.Errorf("%v.Distance(...) returned %v", g, dist)
+	for distortionIndex, distortion := range distortions {
+		if params.SampleRate != distortion.Rate {
+			return nil, fmt.Errorf("distortion %v doesn't have the expected sample rate %v: %v", distortionIndex, params.SampleRate, distortion.Rate)
+		}
+		if len(reference.Samples) != len(distortion.Samples) {
+			return nil, fmt.Errorf("the reference and distortion %v don't have the same number of channels: %v, %v", distortionIndex, len(reference.Samples), len(distortion.Samples))
+		}
+		if len(distortion.Samples[0]) == 0 {
+			return nil, fmt.Errorf("distortion %v is empty", distortionIndex)
 		}
-		sumOfSquares += dist * dist
 	}
-	result := math.Sqrt(sumOfSquares / float64(len(audioA.Samples)))
-	if math.IsNaN(result) {
-		return 0, fmt.Errorf("math.Sqrt(%v / %v) is %v", sumOfSquares, len(audioA.Samples), result)
+	referenceAnalyses := make([]*Analysis, len(reference.Samples))
+	maxAbsAmplitudes := make([]float32, len(reference.Samples))
+	for channelIndex, channel := range reference.Samples {
+		maxAbsAmplitudes[channelIndex] = Measure(channel).MaxAbsAmplitude
+		start := time.Now()
+		referenceAnalyses[channelIndex] = g.analyze(channel)
+		timing.Analyze += time.Since(start)
+		defer referenceAnalyses[channelIndex].free()
+	}
+	result := make([]float64, len(distortions))
+	for distortionIndex, distortion := range distortions {
+		adjustedReference, distortion, err := g.LengthPolicy.Apply(reference, distortion)
+		if err != nil {
+			return nil, fmt.Errorf("distortion %v: %v", distortionIndex, err)
+		}
+		sumOfSquares := 0.0
+		for channelIndex, channel := range distortion.Samples {
+			if isSilent(adjustedReference.Samples[channelIndex]) {
+				if isSilent(channel) {
+					// The distance of the analyses of two silent channels isn't meaningful.
+					continue
+				}
+			} else {
+				NormalizeAmplitude(maxAbsAmplitudes[channelIndex], channel)
+			}
+			start := time.Now()
+			referenceAnalysis := referenceAnalyses[channelIndex]
+			if adjustedReference != reference {
+				referenceAnalysis = g.analyze(adjustedReference.Samples[channelIndex])
+			}
+			analysis := g.analyze(channel)
+			timing.Analyze += time.Since(start)
+			start = time.Now()
+			dist, err := g.analysisDistance(referenceAnalysis, analysis)
+			timing.Compare += time.Since(start)
+			analysis.free()
+			if adjustedReference != reference {
+				referenceAnalysis.free()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("distortion %v channel %v: %v", distortionIndex, channelIndex, err)
+			}
+			sumOfSquares += float64(dist) * float64(dist)
+		}
+		result[distortionIndex] = math.Sqrt(sumOfSquares / float64(len(reference.Samples)))
+		if math.IsNaN(result[distortionIndex]) {
+			return nil, fmt.Errorf("math.Sqrt(%v / %v) is %v", sumOfSquares, len(reference.Samples), result[distortionIndex])
+		}
 	}
 	return result, nil
 }
 
+// isSilent returns whether all samples of the channel are equal, i.e. whether it contains at most an inaudible
+// DC offset.
+func isSilent(channel []float32) bool {
+	for _, sample := range channel {
+		if sample != channel[0] {
+			return false
+		}
+	}
+	return true
+}
+
 // Analysis is a Go wrapper around zimthrli::Analysis.
 type Analysis struct {
-	analysis C.Analysis
+	analysis *analysis
+}
+
+// free releases the analysis immediately instead of waiting for the finalizer.
+func (a *Analysis) free() {
+	a.analysis.free()
 }
 
-// Analyze returns an analysis of the signal.
+// Analyze returns an analysis of the signal, which must not be empty.
 func (g *Goohrli) Analyze(signal []float32) *Analysis {
-	result := &Analysis{
-		analysis: C.Analyze(g.zimtohrli, (*C.float)(&signal[0]), C.int(len(signal))),
+	threads.Acquire()
+	defer threads.Release()
+	return &Analysis{analysis: g.zimtohrli.analyze(signal)}
+}
+
+// NewAnalysis returns an analysis with the provided (num_steps, num_channels)-shaped spectrogram.
+func NewAnalysis(spectrogram [][]float32) (*Analysis, error) {
+	if len(spectrogram) == 0 || len(spectrogram[0]) == 0 {
+		return nil, fmt.Errorf("empty spectrogram")
+	}
+	numChannels := len(spectrogram[0])
+	data := make([]float32, 0, len(spectrogram)*numChannels)
+	for stepIndex, step := range spectrogram {
+		if len(step) != numChannels {
+			return nil, fmt.Errorf("step %v has %v channels, want %v", stepIndex, len(step), numChannels)
+		}
+		data = append(data, step...)
 	}
-	runtime.SetFinalizer(result, func(a *Analysis) {
-		C.FreeAnalysis(a.analysis)
-	})
-	return result
+	return &Analysis{analysis: newAnalysisFromSpectrogram(data, len(spectrogram), numChannels)}, nil
+}
+
+// Spectrogram returns a copy of the (num_steps, num_channels)-shaped perceptual spectrogram of the analysis.
+func (a *Analysis) Spectrogram() [][]float32 {
+	return a.analysis.spectrogram()
 }
 
-// AnalysisDistance returns the Zimtohrli distance between two analyses.
+// AnalysisDistance returns the Zimtohrli distance between two analyses, or NaN if they can't be compared.
 func (g *Goohrli) AnalysisDistance(analysisA *Analysis, analysisB *Analysis) float32 {
-	return float32(C.AnalysisDistance(g.zimtohrli, analysisA.analysis, analysisB.analysis))
+	result, err := g.analysisDistance(analysisA, analysisB)
+	if err != nil {
+		return float32(math.NaN())
+	}
+	return result
+}
+
+// analysisDistance returns the Zimtohrli distance between two analyses, or an error if they can't be compared.
+func (g *Goohrli) analysisDistance(analysisA *Analysis, analysisB *Analysis) (float32, error) {
+	threads.Acquire()
+	defer threads.Release()
+	result, err := g.zimtohrli.distance(analysisA.analysis, analysisB.analysis)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(float64(result)) {
+		return 0, fmt.Errorf("%v.AnalysisDistance(...) returned %v", g, result)
+	}
+	return result, nil
 }
 
-// Distance returns the Zimtohrli distance between two signals.
+func (g *Goohrli) analyze(signal []float32) *Analysis {
+	if g.AnalysisCache != nil {
+		return g.cachedAnalyze(signal)
+	}
+	return g.Analyze(signal)
+}
+
+// Distance returns the Zimtohrli distance between two signals, which must not be empty, or NaN if they can't be
+// compared.
 func (g *Goohrli) Distance(signalA []float32, signalB []float32) float64 {
-	analysisA := C.Analyze(g.zimtohrli, (*C.float)(&signalA[0]), C.int(len(signalA)))
-	defer C.FreeAnalysis(analysisA)
-	analysisB := C.Analyze(g.zimtohrli, (*C.float)(&signalB[0]), C.int(len(signalB)))
-	defer C.FreeAnalysis(analysisB)
-	return float64(C.AnalysisDistance(g.zimtohrli, analysisA, analysisB))
+	if g.AnalysisCache != nil {
+		return float64(g.AnalysisDistance(g.cachedAnalyze(signalA), g.cachedAnalyze(signalB)))
+	}
+	threads.Acquire()
+	defer threads.Release()
+	analysisA := g.zimtohrli.analyze(signalA)
+	defer analysisA.free()
+	analysisB := g.zimtohrli.analyze(signalB)
+	defer analysisB.free()
+	result, err := g.zimtohrli.distance(analysisA, analysisB)
+	if err != nil {
+		return math.NaN()
+	}
+	return float64(result)
 }
 
 // ViSQOL is a Go wrapper around zimtohrli::ViSQOL.
+//
+// ViSQOL isn't supported by the pure-Go reference implementation, where MOS always returns an error.
 type ViSQOL struct {
-	visqol C.ViSQOL
+	visqol *visqol
 }
 
 // NewViSQOL returns a new Gosqol.
 func NewViSQOL() *ViSQOL {
-	result := &ViSQOL{
-		visqol: C.CreateViSQOL(),
+	return &ViSQOL{
+		visqol: newVisqol(),
 	}
-	runtime.SetFinalizer(result, func(g *ViSQOL) {
-		C.FreeViSQOL(g.visqol)
-	})
-	return result
 }
 
 // MOS returns the ViSQOL mean opinion score of the degraded samples comapred to the reference samples.
 func (v *ViSQOL) MOS(sampleRate float64, reference []float32, degraded []float32) (float64, error) {
-	result := C.MOS(v.visqol, C.float(sampleRate), (*C.float)(&reference[0]), C.int(len(reference)), (*C.float)(&degraded[0]), C.int(len(degraded)))
-	if result.Status != 0 {
-		return 0, fmt.Errorf("calling ViSQOL returned status %v", result.Status)
+	if len(reference) == 0 || len(degraded) == 0 {
+		return 0, fmt.Errorf("ViSQOL can't compare empty signals, the reference has %v samples and the degraded signal %v", len(reference), len(degraded))
 	}
-	return float64(result.MOS), nil
+	threads.Acquire()
+	defer threads.Release()
+	return v.visqol.mos(sampleRate, reference, degraded)
 }
 
 // AudioMOS returns the ViSQOL mean opinion score of the degraded audio compared to the reference audio.
@@ -109,6 +109,24 @@ float MOSFromZimtohrli(float zimtohrli_distance);
 // Deletes a zimtohrli::Analysis.
 void FreeAnalysis(Analysis a);
 
+// Returns the number of time steps in the spectrogram of a zimtohrli::Analysis.
+int AnalysisNumSteps(Analysis a);
+
+// Returns the number of channels in the spectrogram of a zimtohrli::Analysis.
+int AnalysisNumChannels(Analysis a);
+
+// Copies the spectrogram of a zimtohrli::Analysis into data, which must have
+// room for AnalysisNumSteps(a) * AnalysisNumChannels(a) floats in row major
+// (step, channel) order.
+void GetAnalysisSpectrogram(Analysis a, float* data);
+
+// Returns a zimtohrli::Analysis with a spectrogram populated from data, which
+// must contain num_steps * num_channels floats in row major (step, channel)
+// order.
+//
+// Only the spectrogram is populated, which is all AnalysisDistance needs.
+Analysis CreateAnalysis(const float* data, int num_steps, int num_channels);
+
 // Returns the Zimtohrli distance between two analyses using the provided
 // zimtohrli::Zimtohrli.
 float AnalysisDistance(Zimtohrli zimtohrli, Analysis a, Analysis b);
@@ -142,6 +160,14 @@ typedef struct {
 MOSResult MOS(ViSQOL v, float sample_rate, const float* reference,
               int reference_size, const float* distorted, int distorted_size);
 
+// Returns the git description of the source the library was built from, or
+// "unknown" if it wasn't built from a git checkout.
+const char* ZimtohrliCommit();
+
+// Returns the build type, compiler, and compiler flags the library was built
+// with.
+const char* ZimtohrliBuildFlags();
+
 #ifdef __cplusplus
 }
 #endif
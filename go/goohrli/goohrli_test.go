@@ -15,12 +15,19 @@
 package goohrli
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
+	"math/rand"
+	"os"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/google/zimtohrli/go/audio"
 )
 
 func TestMeasureAndNormalize(t *testing.T) {
@@ -72,6 +79,26 @@ func TestMOSFromZimtohrli(t *testing.T) {
 	}
 }
 
+func TestZimtohrliFromMOS(t *testing.T) {
+	for _, distance := range []float64{0, 0.1, 0.5, 0.7, 1.0} {
+		mos := MOSFromZimtohrli(distance)
+		if goMOS := DefaultMOSMapping.MOS(distance); math.Abs(goMOS-mos) > 1e-4 {
+			t.Errorf("DefaultMOSMapping.MOS(%v) = %v, want %v", distance, goMOS, mos)
+		}
+		if got := ZimtohrliFromMOS(mos); math.Abs(got-distance) > 1e-3 {
+			t.Errorf("ZimtohrliFromMOS(%v) = %v, want %v", mos, got, distance)
+		}
+	}
+	if got := ZimtohrliFromMOS(1); !math.IsInf(got, 1) {
+		t.Errorf("ZimtohrliFromMOS(1) = %v, want +Inf", got)
+	}
+	for _, mos := range []float64{5, 5.5, 100} {
+		if got := ZimtohrliFromMOS(mos); got != 0 {
+			t.Errorf("ZimtohrliFromMOS(%v) = %v, want 0", mos, got)
+		}
+	}
+}
+
 func TestParams(t *testing.T) {
 	g := New(DefaultParameters(48000))
 
@@ -141,7 +168,453 @@ func TestGoohrli(t *testing.T) {
 	}
 }
 
+func sine(freq, sampleRate float64, numSamples int) []float32 {
+	result := make([]float32, numSamples)
+	for index := range result {
+		result[index] = float32(math.Sin(2 * math.Pi * freq * float64(index) / sampleRate))
+	}
+	return result
+}
+
+func TestAnalysisSerialization(t *testing.T) {
+	g := New(DefaultParameters(48000))
+	analysis := g.Analyze(sine(1000, 48000, 48000))
+	buf := &bytes.Buffer{}
+	if err := analysis.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	readAnalysis, err := ReadAnalysis(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(analysis.Spectrogram(), readAnalysis.Spectrogram()) {
+		t.Errorf("read spectrogram differs from written spectrogram")
+	}
+	if dist := g.AnalysisDistance(analysis, readAnalysis); dist != 0 {
+		t.Errorf("AnalysisDistance(analysis, readAnalysis) = %v, want 0", dist)
+	}
+	if _, err := ReadAnalysis(bytes.NewBufferString("not an analysis")); err == nil {
+		t.Errorf("ReadAnalysis of garbage returned no error")
+	}
+	buf.Reset()
+	if err := analysis.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAnalysis(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Errorf("ReadAnalysis of a truncated analysis returned no error")
+	}
+	for _, header := range []analysisHeader{
+		{Magic: analysisMagic, NumSteps: 1 << 20, NumChannels: 1 << 12},
+		{Magic: analysisMagic, NumSteps: math.MaxUint32, NumChannels: math.MaxUint32},
+	} {
+		corrupt := &bytes.Buffer{}
+		if err := binary.Write(corrupt, binary.LittleEndian, header); err != nil {
+			t.Fatal(err)
+		}
+		corrupt.Write(make([]byte, 64))
+		if _, err := ReadAnalysis(corrupt); err == nil {
+			t.Errorf("ReadAnalysis of a %vx%v analysis with 64 bytes of spectrogram returned no error", header.NumSteps, header.NumChannels)
+		}
+	}
+}
+
+func TestAnalysisCache(t *testing.T) {
+	dir := t.TempDir()
+	g := New(DefaultParameters(48000))
+	signalA := sine(1000, 48000, 48000)
+	signalB := sine(1100, 48000, 48000)
+	wantDistance := g.Distance(signalA, signalB)
+	g.AnalysisCache = &AnalysisCache{Dir: dir}
+	for i := 0; i < 2; i++ {
+		if distance := g.Distance(signalA, signalB); rdiff(distance, wantDistance) > 1e-6 {
+			t.Errorf("cached Distance = %v, want %v", distance, wantDistance)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %v cached analyses, want 2", len(entries))
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	cache := &ResultCache{Dir: t.TempDir()}
+	calls := 0
+	measure := func(a, b *audio.Audio) (float64, error) {
+		calls++
+		// Measurements may modify the signals, which must not change the cache key.
+		b.Samples[0][0] = 1
+		return float64(len(b.Samples[0])), nil
+	}
+	signal := func(length int) *audio.Audio {
+		return &audio.Audio{Samples: [][]float32{make([]float32, length)}, Rate: 48000}
+	}
+	for _, tc := range []struct {
+		metric    string
+		length    int
+		wantCalls int
+	}{
+		{"A", 10, 1},
+		{"A", 10, 1},
+		{"B", 10, 2},
+		{"A", 11, 3},
+		{"A", 11, 3},
+	} {
+		result, err := cache.Measure(tc.metric, signal(10), signal(tc.length), measure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != float64(tc.length) || calls != tc.wantCalls {
+			t.Errorf("Measure(%q, %v) = %v after %v measurements, want %v after %v measurements", tc.metric, tc.length, result, calls, tc.length, tc.wantCalls)
+		}
+	}
+
+	// Errors and non-finite results are not cached.
+	failures := 0
+	failing := cache.Measurement("C", func(a, b *audio.Audio) (float64, error) {
+		failures++
+		if failures == 1 {
+			return 0, fmt.Errorf("failed")
+		}
+		return math.NaN(), nil
+	})
+	for i := 0; i < 3; i++ {
+		failing(signal(10), signal(10))
+	}
+	if failures != 3 {
+		t.Errorf("failing measurement was called %v times, want 3", failures)
+	}
+}
+
+// forwardDistance returns the root mean square of the distances between the channels of a and b, after
+// normalizing a copy of each channel of b to the max amplitude of the same channel of a, computed independently of
+// CompareMany using Distance.
+func forwardDistance(g *Goohrli, a, b [][]float32) float64 {
+	sumOfSquares := 0.0
+	for channelIndex := range a {
+		channelA := a[channelIndex]
+		channelB := append([]float32{}, b[channelIndex]...)
+		silentA, silentB := true, true
+		for _, sample := range channelA {
+			silentA = silentA && sample == channelA[0]
+		}
+		for _, sample := range channelB {
+			silentB = silentB && sample == channelB[0]
+		}
+		if silentA && silentB {
+			continue
+		}
+		if !silentA {
+			NormalizeAmplitude(Measure(channelA).MaxAbsAmplitude, channelB)
+		}
+		distance := g.Distance(channelA, channelB)
+		sumOfSquares += distance * distance
+	}
+	return math.Sqrt(sumOfSquares / float64(len(a)))
+}
+
+func TestCompareMany(t *testing.T) {
+	reference := &audio.Audio{Samples: [][]float32{sine(5000, 48000, 24000), sine(300, 48000, 24000)}, Rate: 48000}
+	distortions := []*audio.Audio{}
+	for _, freq := range []float64{5000, 10000, 5010} {
+		distortions = append(distortions, &audio.Audio{Samples: [][]float32{sine(freq, 48000, 24000), sine(310, 48000, 24000)}, Rate: 48000})
+	}
+	// A shorter and quieter distortion, measured differently by the length policies.
+	shorter := &audio.Audio{Samples: [][]float32{sine(5020, 48000, 20000), sine(300, 48000, 20000)}, Rate: 48000}
+	for index := range shorter.Samples {
+		for sampleIndex := range shorter.Samples[index] {
+			shorter.Samples[index][sampleIndex] *= 0.25
+		}
+	}
+	distortions = append(distortions, shorter)
+	truncate := func(a, b [][]float32) ([][]float32, [][]float32) {
+		length := min(len(a[0]), len(b[0]))
+		resultA, resultB := [][]float32{}, [][]float32{}
+		for channelIndex := range a {
+			resultA = append(resultA, a[channelIndex][:length])
+			resultB = append(resultB, b[channelIndex][:length])
+		}
+		return resultA, resultB
+	}
+	for _, tc := range []struct {
+		symmetry     Symmetry
+		lengthPolicy LengthPolicy
+	}{
+		{symmetry: SymmetryForward, lengthPolicy: LengthWarp},
+		{symmetry: SymmetryMax, lengthPolicy: LengthWarp},
+		{symmetry: SymmetryForward, lengthPolicy: LengthTruncate},
+		{symmetry: SymmetryMean, lengthPolicy: LengthTruncate},
+	} {
+		g := New(DefaultParameters(48000))
+		g.Symmetry = tc.symmetry
+		g.LengthPolicy = tc.lengthPolicy
+		distances, err := g.CompareMany(reference, distortions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(distances) != len(distortions) {
+			t.Fatalf("%v/%v: got %v distances, want %v", tc.symmetry, tc.lengthPolicy, len(distances), len(distortions))
+		}
+		for index, distortion := range distortions {
+			a, b := reference.Samples, distortion.Samples
+			if tc.lengthPolicy == LengthTruncate {
+				a, b = truncate(a, b)
+			}
+			wantDistance := forwardDistance(g, a, b)
+			if tc.symmetry.Symmetric() {
+				wantDistance = tc.symmetry.Combine(wantDistance, forwardDistance(g, b, a))
+			}
+			if rdiff(distances[index], wantDistance) > 1e-4 {
+				t.Errorf("%v/%v: distance %v = %v, want %v", tc.symmetry, tc.lengthPolicy, index, distances[index], wantDistance)
+			}
+		}
+	}
+	// Channels that are silent in both signals don't count, but still divide the sum of squares.
+	g := New(DefaultParameters(48000))
+	silent := make([]float32, 24000)
+	withSilence := &audio.Audio{Samples: [][]float32{sine(5000, 48000, 24000), silent}, Rate: 48000}
+	distortion := &audio.Audio{Samples: [][]float32{sine(5010, 48000, 24000), silent}, Rate: 48000}
+	distances, err := g.CompareMany(withSilence, []*audio.Audio{distortion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDistance := forwardDistance(g, withSilence.Samples[:1], distortion.Samples[:1]) / math.Sqrt(2)
+	if rdiff(distances[0], wantDistance) > 1e-4 {
+		t.Errorf("distance with a silent channel = %v, want %v", distances[0], wantDistance)
+	}
+	if _, err := g.CompareMany(reference, []*audio.Audio{{Samples: [][]float32{sine(5000, 16000, 16000)}, Rate: 16000}}); err == nil {
+		t.Errorf("CompareMany with mismatched sample rate returned no error")
+	}
+}
+
+func TestOnTiming(t *testing.T) {
+	g := New(DefaultParameters(48000))
+	timings := []Timing{}
+	g.OnTiming = func(timing Timing) {
+		timings = append(timings, timing)
+	}
+	reference := &audio.Audio{Samples: [][]float32{sine(1000, 48000, 4800)}, Rate: 48000}
+	distortion := &audio.Audio{Samples: [][]float32{sine(1100, 48000, 4800)}, Rate: 48000}
+	if _, err := g.NormalizedAudioDistance(reference, distortion); err != nil {
+		t.Fatal(err)
+	}
+	if len(timings) != 1 || timings[0].Analyze <= 0 || timings[0].Compare <= 0 {
+		t.Errorf("got timings %+v, want one timing of both stages", timings)
+	}
+}
+
+func TestForRate(t *testing.T) {
+	g := New(DefaultParameters(48000))
+	if same, err := g.ForRate(48000); err != nil || same != g {
+		t.Errorf("ForRate of the own sample rate = %v, %v, want the same instance", same, err)
+	}
+	cd, err := g.ForRate(44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := cd.Parameters().SampleRate; rate != 44100 {
+		t.Errorf("ForRate(44100) has sample rate %v", rate)
+	}
+	if again, _ := g.ForRate(44100); again != cd {
+		t.Errorf("ForRate(44100) didn't reuse the instance")
+	}
+	if _, err := g.ForRate(16000); err == nil {
+		t.Errorf("ForRate(16000) returned no error")
+	}
+	reference := &audio.Audio{Samples: [][]float32{sine(1000, 44100, 44100)}, Rate: 44100}
+	distortion := &audio.Audio{Samples: [][]float32{sine(1010, 44100, 44100)}, Rate: 44100}
+	distance, err := g.NormalizedAudioDistance(reference, distortion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDistance, err := New(DefaultParameters(44100)).NormalizedAudioDistance(reference, distortion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(distance-wantDistance) > 1e-6 {
+		t.Errorf("distance at 44.1 kHz = %v, want %v", distance, wantDistance)
+	}
+	params := g.Parameters()
+	params.FullScaleSineDB = 90
+	g.Set(params)
+	updated, err := g.ForRate(44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == cd {
+		t.Errorf("ForRate(44100) reused the instance after Set")
+	}
+	if db := updated.Parameters().FullScaleSineDB; db != 90 {
+		t.Errorf("ForRate(44100) after Set has FullScaleSineDB %v, want 90", db)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	g := New(DefaultParameters(48000))
+	constant := func(value float32) *audio.Audio {
+		result := &audio.Audio{Samples: [][]float32{make([]float32, 48000)}, Rate: 48000}
+		for index := range result.Samples[0] {
+			result.Samples[0][index] = value
+		}
+		return result
+	}
+	tone := func() *audio.Audio {
+		return &audio.Audio{Samples: [][]float32{sine(1000, 48000, 48000)}, Rate: 48000}
+	}
+	for _, tc := range []struct {
+		name         string
+		reference    *audio.Audio
+		distortion   *audio.Audio
+		wantDistance float64
+		wantErr      bool
+	}{
+		{name: "both zero", reference: constant(0), distortion: constant(0), wantDistance: 0},
+		{name: "both DC", reference: constant(0.5), distortion: constant(0.2), wantDistance: 0},
+		{name: "silent reference", reference: constant(0), distortion: tone(), wantDistance: -1},
+		{name: "silent distortion", reference: tone(), distortion: constant(0), wantDistance: -1},
+		{name: "empty reference", reference: &audio.Audio{Samples: [][]float32{{}}, Rate: 48000}, distortion: tone(), wantErr: true},
+		{name: "empty distortion", reference: tone(), distortion: &audio.Audio{Samples: [][]float32{{}}, Rate: 48000}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			distance, err := g.NormalizedAudioDistance(tc.reference, tc.distortion)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got distance %v, want an error", distance)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantDistance < 0 {
+				if distance <= 0 || math.IsNaN(distance) {
+					t.Errorf("got distance %v, want a positive distance", distance)
+				}
+			} else if distance != tc.wantDistance {
+				t.Errorf("got distance %v, want %v", distance, tc.wantDistance)
+			}
+		})
+	}
+	g.Symmetry = SymmetryMax
+	if distance, err := g.NormalizedAudioDistance(tone(), constant(0)); err != nil || distance <= 0 {
+		t.Errorf("symmetric distance of silent distortion = %v, %v, want a positive distance", distance, err)
+	}
+	matrix, err := g.DistanceMatrix([]*audio.Audio{constant(0), constant(0), tone()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matrix[0][1] != 0 {
+		t.Errorf("distance between silent signals in the matrix = %v, want 0", matrix[0][1])
+	}
+}
+
+func TestLengthPolicy(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	reference := &audio.Audio{Samples: [][]float32{make([]float32, 1000)}, Rate: 1000}
+	for index := range reference.Samples[0] {
+		reference.Samples[0][index] = rng.Float32()*2 - 1
+	}
+	distortion := &audio.Audio{Samples: [][]float32{make([]float32, 1057)}, Rate: 1000}
+	copy(distortion.Samples[0][37:], reference.Samples[0])
+	for _, tc := range []struct {
+		policy      LengthPolicy
+		wantRefLen  int
+		wantDistLen int
+		wantErr     bool
+	}{
+		{policy: LengthWarp, wantRefLen: 1000, wantDistLen: 1057},
+		{policy: LengthError, wantErr: true},
+		{policy: LengthTruncate, wantRefLen: 1000, wantDistLen: 1000},
+		{policy: LengthZeroPad, wantRefLen: 1057, wantDistLen: 1057},
+		{policy: LengthAlign, wantRefLen: 1000, wantDistLen: 1000},
+		{policy: "bogus", wantErr: true},
+	} {
+		ref, dist, err := tc.policy.Apply(reference, distortion)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%v: got no error", tc.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ref.Samples[0]) != tc.wantRefLen || len(dist.Samples[0]) != tc.wantDistLen {
+			t.Errorf("%v: got lengths %v, %v, want %v, %v", tc.policy, len(ref.Samples[0]), len(dist.Samples[0]), tc.wantRefLen, tc.wantDistLen)
+		}
+		if tc.policy == LengthAlign && !reflect.DeepEqual(ref.Samples, dist.Samples) {
+			t.Errorf("%v: distortion not aligned with reference", tc.policy)
+		}
+	}
+}
+
+func TestEstimateDelay(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	reference := &audio.Audio{Samples: [][]float32{make([]float32, 1000)}, Rate: 1000}
+	for index := range reference.Samples[0] {
+		reference.Samples[0][index] = rng.Float32()*2 - 1
+	}
+	delayed := &audio.Audio{Samples: [][]float32{make([]float32, 1000)}, Rate: 1000}
+	copy(delayed.Samples[0][25:], reference.Samples[0])
+	if delay := EstimateDelay(reference, delayed); delay != 0.025 {
+		t.Errorf("delay of delayed signal = %v, want 0.025", delay)
+	}
+	if delay := EstimateDelay(delayed, reference); delay != -0.025 {
+		t.Errorf("delay of advanced signal = %v, want -0.025", delay)
+	}
+	if delay := EstimateDelay(reference, reference); delay != 0 {
+		t.Errorf("delay of identical signal = %v, want 0", delay)
+	}
+	// Delays are searched up to half the length of the shorter signal, however long the other signal is.
+	short := &audio.Audio{Samples: [][]float32{reference.Samples[0][:100]}, Rate: 1000}
+	long := &audio.Audio{Samples: [][]float32{make([]float32, 100000)}, Rate: 1000}
+	copy(long.Samples[0][40:], short.Samples[0])
+	if delay := EstimateDelay(short, long); delay != 0.04 {
+		t.Errorf("delay of short signal in long signal = %v, want 0.04", delay)
+	}
+	late := &audio.Audio{Samples: [][]float32{make([]float32, 100000)}, Rate: 1000}
+	copy(late.Samples[0][5000:], short.Samples[0])
+	if delay := EstimateDelay(short, late); math.Abs(delay) > 0.05 {
+		t.Errorf("delay of short signal late in long signal = %v, want at most 0.05", delay)
+	}
+}
+
+func TestComparisonReliability(t *testing.T) {
+	signal := func(seconds, amplitude float64, clipped int) *audio.Audio {
+		result := &audio.Audio{Samples: [][]float32{make([]float32, int(seconds*1000))}, Rate: 1000}
+		for index := range result.Samples[0] {
+			result.Samples[0][index] = float32(amplitude * math.Sin(float64(index)))
+		}
+		for index := 0; index < clipped; index++ {
+			result.Samples[0][index] = 1
+		}
+		return result
+	}
+	for _, tc := range []struct {
+		name           string
+		reference      *audio.Audio
+		distortion     *audio.Audio
+		wantConfidence float64
+		wantReasons    int
+	}{
+		{name: "good", reference: signal(2, 0.5, 0), distortion: signal(2, 0.5, 0), wantConfidence: 1},
+		{name: "short", reference: signal(0.5, 0.5, 0), distortion: signal(0.5, 0.5, 0), wantConfidence: 0.25, wantReasons: 2},
+		{name: "silent", reference: signal(2, 0.5, 0), distortion: signal(2, 0, 0), wantConfidence: 0, wantReasons: 1},
+		{name: "clipped", reference: signal(2, 0.5, 10), distortion: signal(2, 0.5, 0), wantConfidence: 0.5, wantReasons: 1},
+	} {
+		reliability := ComparisonReliability(tc.reference, tc.distortion)
+		if math.Abs(reliability.Confidence-tc.wantConfidence) > 1e-6 || len(reliability.Reasons) != tc.wantReasons {
+			t.Errorf("%v: got %+v, want confidence %v and %v reasons", tc.name, reliability, tc.wantConfidence, tc.wantReasons)
+		}
+	}
+}
+
 func TestViSQOL(t *testing.T) {
+	if PureGo {
+		t.Skip("ViSQOL isn't available in the pure-Go reference implementation")
+	}
 	sampleRate := 48000.0
 	g := NewViSQOL()
 	for _, tc := range []struct {
@@ -269,14 +742,6 @@ func checkNear(a, b any, rtol float64, t *testing.T) {
 	}
 }
 
-func TestParamConversion(t *testing.T) {
-	params := Parameters{}
-	populate(&params)
-	cParams := cFromGoParameters(params)
-	reconvertedParams := goFromCParameters(cParams)
-	checkNear(reconvertedParams, params, 1e-6, t)
-}
-
 func TestParamUpdate(t *testing.T) {
 	params := DefaultParameters(48000)
 	js, err := json.Marshal(params)
@@ -297,3 +762,294 @@ func TestParamUpdate(t *testing.T) {
 		}
 	}
 }
+
+func TestDistanceMatrix(t *testing.T) {
+	g := New(DefaultParameters(48000))
+	signals := []*audio.Audio{}
+	for _, freq := range []float64{5000, 10000, 5010} {
+		signals = append(signals, &audio.Audio{Samples: [][]float32{sine(freq, 48000, 48000)}, Rate: 48000})
+	}
+	matrix, err := g.DistanceMatrix(signals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matrix) != len(signals) {
+		t.Fatalf("got %v rows, want %v", len(matrix), len(signals))
+	}
+	for referenceIndex, row := range matrix {
+		if len(row) != len(signals) {
+			t.Fatalf("row %v has %v columns, want %v", referenceIndex, len(row), len(signals))
+		}
+		if row[referenceIndex] != 0 {
+			t.Errorf("distance %v,%v = %v, want 0", referenceIndex, referenceIndex, row[referenceIndex])
+		}
+		for distortionIndex := range row {
+			if referenceIndex == distortionIndex {
+				continue
+			}
+			wantDistance, err := g.NormalizedAudioDistance(signals[referenceIndex], signals[distortionIndex])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(row[distortionIndex]-wantDistance) > 1e-6 {
+				t.Errorf("distance %v,%v = %v, want %v", referenceIndex, distortionIndex, row[distortionIndex], wantDistance)
+			}
+		}
+	}
+	if matrix[0][2] >= matrix[0][1] {
+		t.Errorf("distance to the close frequency %v >= distance to the far frequency %v", matrix[0][2], matrix[0][1])
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	analysis, err := NewAnalysis([][]float32{{1, 2}, {3, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := analysis.Embedding(), []float32{2, 2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Embedding() = %v, want %v", got, want)
+	}
+
+	g := New(DefaultParameters(48000))
+	signal := &audio.Audio{Samples: [][]float32{sine(1000, 48000, 48000)}, Rate: 48000}
+	embedding, err := g.Embedding(signal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * len(g.Analyze(signal.Samples[0]).Spectrogram()[0]); len(embedding) != want {
+		t.Errorf("got %v embedding dimensions, want %v", len(embedding), want)
+	}
+	stereo := &audio.Audio{Samples: [][]float32{signal.Samples[0], signal.Samples[0]}, Rate: 48000}
+	stereoEmbedding, err := g.Embedding(stereo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := range embedding {
+		if math.Abs(float64(embedding[index]-stereoEmbedding[index])) > 1e-4 {
+			t.Errorf("stereo embedding[%v] = %v, want %v", index, stereoEmbedding[index], embedding[index])
+		}
+	}
+	if _, err := g.Embedding(&audio.Audio{Samples: [][]float32{sine(1000, 16000, 16000)}, Rate: 16000}); err == nil {
+		t.Errorf("Embedding with mismatched sample rate returned no error")
+	}
+}
+
+func TestChannelPolicy(t *testing.T) {
+	surround := &audio.Audio{Samples: [][]float32{{1}, {2}, {4}, {8}, {16}, {32}}, Rate: 48000}
+	ambisonic := &audio.Audio{Samples: [][]float32{{1}, {0.5}, {3}, {5}}, Rate: 48000}
+	stereo := &audio.Audio{Samples: [][]float32{{1}, {2}}, Rate: 48000}
+	half := float32(math.Sqrt2 / 2)
+	for _, tc := range []struct {
+		policy  ChannelPolicy
+		signal  *audio.Audio
+		want    [][]float32
+		wantErr bool
+	}{
+		{policy: ChannelsPerChannel, signal: surround, want: surround.Samples},
+		{policy: ChannelsReject, signal: stereo, want: stereo.Samples},
+		{policy: ChannelsReject, signal: surround, wantErr: true},
+		{policy: ChannelsDownmix, signal: stereo, want: stereo.Samples},
+		{policy: ChannelsDownmix, signal: surround, want: [][]float32{{1 + half*4 + half*16}, {2 + half*4 + half*32}}},
+		{policy: ChannelsDownmix, signal: ambisonic, wantErr: true},
+		{policy: ChannelsAmbisonic, signal: ambisonic, want: [][]float32{{0.75}, {0.25}}},
+		{policy: ChannelsAmbisonic, signal: surround, wantErr: true},
+		{policy: "bogus", signal: stereo, wantErr: true},
+	} {
+		got, err := tc.policy.Apply(tc.signal)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%v with %v channels: got no error", tc.policy, len(tc.signal.Samples))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Samples, tc.want) {
+			t.Errorf("%v with %v channels: got %v, want %v", tc.policy, len(tc.signal.Samples), got.Samples, tc.want)
+		}
+	}
+}
+
+func TestBinaural(t *testing.T) {
+	left := &audio.Audio{Samples: [][]float32{make([]float32, 100), make([]float32, 100)}, Rate: 48000}
+	left.Samples[0][0] = 1
+	binaural, err := ChannelsBinaural.Apply(left)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(binaural.Samples) != 2 {
+		t.Fatalf("got %v channels, want 2", len(binaural.Samples))
+	}
+	firstSample := func(channel []float32) int {
+		for index, sample := range channel {
+			if sample != 0 {
+				return index
+			}
+		}
+		return len(channel)
+	}
+	if leftStart, rightStart := firstSample(binaural.Samples[0]), firstSample(binaural.Samples[1]); leftStart >= rightStart {
+		t.Errorf("the left ear hears a left speaker at sample %v, not before the right ear at sample %v", leftStart, rightStart)
+	}
+	energy := func(channel []float32) float64 {
+		result := 0.0
+		for _, sample := range channel {
+			result += float64(sample) * float64(sample)
+		}
+		return result
+	}
+	if leftEnergy, rightEnergy := energy(binaural.Samples[0]), energy(binaural.Samples[1]); leftEnergy <= rightEnergy {
+		t.Errorf("the left ear hears a left speaker with energy %v, not more than the right ear with energy %v", leftEnergy, rightEnergy)
+	}
+	for _, numChannels := range []int{4, 6, 8} {
+		signal := &audio.Audio{Samples: make([][]float32, numChannels), Rate: 48000}
+		for channelIndex := range signal.Samples {
+			signal.Samples[channelIndex] = sine(1000, 48000, 480)
+		}
+		policy := ChannelsBinaural
+		if numChannels == 4 {
+			policy = ChannelsAmbisonicBinaural
+		}
+		if binaural, err := policy.Apply(signal); err != nil || len(binaural.Samples) != 2 {
+			t.Errorf("%v with %v channels: got %v, %v, want two channels", policy, numChannels, binaural, err)
+		}
+	}
+	if _, err := ChannelsBinaural.Apply(&audio.Audio{Samples: make([][]float32, 3), Rate: 48000}); err == nil {
+		t.Errorf("%v with 3 channels: got no error", ChannelsBinaural)
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	g := New(DefaultParameters(48000))
+	results := g.SelfCheck()
+	if len(results) != 3 {
+		t.Errorf("got %v results, want 3", len(results))
+	}
+	for _, result := range results {
+		if !result.Passed {
+			t.Error(result)
+		}
+	}
+	if !results.Passed() {
+		t.Errorf("results.Passed() = false, want true")
+	}
+}
+
+func TestSymmetry(t *testing.T) {
+	if got := SymmetryMax.Combine(1, 3); got != 3 {
+		t.Errorf("SymmetryMax.Combine(1, 3) = %v, want 3", got)
+	}
+	if got := SymmetryMean.Combine(1, 3); got != 2 {
+		t.Errorf("SymmetryMean.Combine(1, 3) = %v, want 2", got)
+	}
+	if got := SymmetryForward.Combine(1, 3); got != 1 {
+		t.Errorf("SymmetryForward.Combine(1, 3) = %v, want 1", got)
+	}
+	if SymmetryForward.Symmetric() || !SymmetryMax.Symmetric() || !SymmetryMean.Symmetric() {
+		t.Errorf("only SymmetryMax and SymmetryMean should be symmetric")
+	}
+	signalA := func() *audio.Audio {
+		return &audio.Audio{Samples: [][]float32{sine(1000, 48000, 48000)}, Rate: 48000}
+	}
+	signalB := func() *audio.Audio {
+		return &audio.Audio{Samples: [][]float32{sine(1100, 48000, 24000)}, Rate: 48000}
+	}
+	for _, symmetry := range []Symmetry{SymmetryMax, SymmetryMean} {
+		g := New(DefaultParameters(48000))
+		g.Symmetry = symmetry
+		ab, err := g.NormalizedAudioDistance(signalA(), signalB())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ba, err := g.NormalizedAudioDistance(signalB(), signalA())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(ab-ba) > 1e-6 {
+			t.Errorf("%v: distance from A to B is %v, and from B to A is %v", symmetry, ab, ba)
+		}
+		matrix, err := g.DistanceMatrix([]*audio.Audio{signalA(), signalB()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matrix[0][1] != matrix[1][0] {
+			t.Errorf("%v: DistanceMatrix returned %v, want it symmetric", symmetry, matrix)
+		}
+	}
+	g := New(DefaultParameters(48000))
+	g.Symmetry = "bogus"
+	if _, err := g.NormalizedAudioDistance(signalA(), signalB()); err == nil {
+		t.Errorf("NormalizedAudioDistance with unknown symmetry returned no error")
+	}
+}
+
+func TestBestPermutation(t *testing.T) {
+	for _, tc := range []struct {
+		matrix [][]float64
+		want   []int
+	}{
+		{
+			matrix: [][]float64{{1}},
+			want:   []int{0},
+		},
+		{
+			matrix: [][]float64{{1, 2}, {2, 1}},
+			want:   []int{0, 1},
+		},
+		{
+			matrix: [][]float64{{3, 1}, {1, 3}},
+			want:   []int{1, 0},
+		},
+		{
+			// Greedily assigning the closest column to row 0 would give a larger sum.
+			matrix: [][]float64{{1, 2, 9}, {1, 9, 9}, {9, 9, 1}},
+			want:   []int{1, 0, 2},
+		},
+		{
+			matrix: [][]float64{{1, 1}, {1, 1}},
+			want:   []int{0, 1},
+		},
+		{
+			matrix: [][]float64{{math.Inf(1), 1}, {1, math.Inf(1)}},
+			want:   []int{1, 0},
+		},
+		{
+			// Silent sources can be infinitely distant from all estimated sources.
+			matrix: [][]float64{{math.Inf(1), math.Inf(1)}, {math.Inf(1), math.Inf(1)}},
+			want:   []int{0, 1},
+		},
+	} {
+		if got := bestPermutation(tc.matrix); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("bestPermutation(%v) = %v, want %v", tc.matrix, got, tc.want)
+		}
+	}
+}
+
+func TestAssignSources(t *testing.T) {
+	g := New(DefaultParameters(48000))
+	references := &audio.Audio{Samples: [][]float32{sine(500, 48000, 48000), sine(5000, 48000, 48000)}, Rate: 48000}
+	estimates := &audio.Audio{Samples: [][]float32{sine(5010, 48000, 48000), sine(510, 48000, 48000)}, Rate: 48000}
+	assignment, err := g.AssignSources(references, estimates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 0}; !reflect.DeepEqual(assignment.Permutation, want) {
+		t.Errorf("Permutation = %v, want %v", assignment.Permutation, want)
+	}
+	for referenceIndex, estimateIndex := range assignment.Permutation {
+		want, err := g.NormalizedAudioDistance(&audio.Audio{Samples: [][]float32{references.Samples[referenceIndex]}, Rate: 48000}, &audio.Audio{Samples: [][]float32{estimates.Samples[estimateIndex]}, Rate: 48000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(assignment.Distances[referenceIndex]-want) > 1e-6 {
+			t.Errorf("distance of reference source %v = %v, want %v", referenceIndex, assignment.Distances[referenceIndex], want)
+		}
+	}
+	if mean := assignment.MeanDistance(); mean != 0.5*(assignment.Distances[0]+assignment.Distances[1]) {
+		t.Errorf("MeanDistance() = %v, want the mean of %v", mean, assignment.Distances)
+	}
+	if _, err := g.AssignSources(references, &audio.Audio{Samples: estimates.Samples[:1], Rate: 48000}); err == nil {
+		t.Errorf("AssignSources with different numbers of channels returned no error")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// LengthPolicy defines how signals of different lengths are compared.
+type LengthPolicy string
+
+const (
+	// LengthWarp compares the signals as they are, and lets the time warping of Zimtohrli handle the difference. This is the default.
+	LengthWarp LengthPolicy = "warp"
+	// LengthError returns an error if the signals have different lengths.
+	LengthError LengthPolicy = "error"
+	// LengthTruncate truncates the longer signal to the length of the shorter.
+	LengthTruncate LengthPolicy = "truncate"
+	// LengthZeroPad pads the shorter signal with zeros to the length of the longer.
+	LengthZeroPad LengthPolicy = "zero_pad"
+	// LengthAlign removes the delay of the distortion relative to the reference, and then truncates the longer signal to the length of the shorter.
+	LengthAlign LengthPolicy = "align"
+)
+
+// LengthPolicies contains all length policies.
+var LengthPolicies = []LengthPolicy{LengthWarp, LengthError, LengthTruncate, LengthZeroPad, LengthAlign}
+
+// maxAlignmentWindow is the max duration of audio, in seconds, correlated when estimating delays.
+const maxAlignmentWindow = 1.0
+
+// minAlignmentDelay is the min delay, in seconds, searched for when estimating delays.
+const minAlignmentDelay = 0.1
+
+// maxAlignmentDelayFraction is the max delay, as a fraction of the length of the shorter signal, searched for when
+// estimating delays. Longer delays would leave less than that much of the shorter signal overlapping the other, and
+// would make a long padding of one signal search arbitrarily many delays.
+const maxAlignmentDelayFraction = 0.5
+
+// Apply returns the reference and distortion adjusted according to the policy.
+//
+// Signals that don't need adjustment are returned unchanged, and adjusted signals are returned as copies.
+func (p LengthPolicy) Apply(reference, distortion *audio.Audio) (*audio.Audio, *audio.Audio, error) {
+	refLen, distLen := numFrames(reference), numFrames(distortion)
+	switch p {
+	case "", LengthWarp:
+		return reference, distortion, nil
+	case LengthError:
+		if refLen != distLen {
+			return nil, nil, fmt.Errorf("the reference has %v samples, and the distortion has %v samples", refLen, distLen)
+		}
+		return reference, distortion, nil
+	case LengthTruncate:
+		n := min(refLen, distLen)
+		return resize(reference, 0, n), resize(distortion, 0, n), nil
+	case LengthZeroPad:
+		n := max(refLen, distLen)
+		return resize(reference, 0, n), resize(distortion, 0, n), nil
+	case LengthAlign:
+		delay := alignmentDelay(reference, distortion)
+		refStart, distStart := 0, 0
+		if delay > 0 {
+			distStart = delay
+		} else {
+			refStart = -delay
+		}
+		n := min(refLen-refStart, distLen-distStart)
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("no overlap between the reference and the distortion delayed %v samples", delay)
+		}
+		return resize(reference, refStart, n), resize(distortion, distStart, n), nil
+	}
+	return nil, nil, fmt.Errorf("unknown length policy %q, want one of %v", p, LengthPolicies)
+}
+
+// alignmentDelay returns the delay, in frames, of distortion relative to reference, searching delays up to the
+// length difference of the signals, or minAlignmentDelay if that's longer, but at most maxAlignmentDelayFraction of
+// the shorter signal.
+func alignmentDelay(reference, distortion *audio.Audio) int {
+	refLen, distLen := numFrames(reference), numFrames(distortion)
+	maxDelay := max(abs(refLen-distLen), int(minAlignmentDelay*reference.Rate))
+	maxDelay = min(maxDelay, int(maxAlignmentDelayFraction*float64(min(refLen, distLen))))
+	return estimateDelay(reference, distortion, maxDelay, int(maxAlignmentWindow*reference.Rate))
+}
+
+// EstimateDelay returns the delay, in seconds, of distortion relative to reference, i.e. how much later the
+// distortion starts, as compensated by LengthAlign. It is estimated by maximizing the cross correlation of the
+// beginnings of the signals, and is negative if the distortion starts earlier than the reference.
+func EstimateDelay(reference, distortion *audio.Audio) float64 {
+	return float64(alignmentDelay(reference, distortion)) / reference.Rate
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func numFrames(a *audio.Audio) int {
+	if len(a.Samples) == 0 {
+		return 0
+	}
+	return len(a.Samples[0])
+}
+
+// resize returns a copy of the n frames of a starting at start, padded with zeros if a is too short,
+// or a itself if that would be identical.
+func resize(a *audio.Audio, start, n int) *audio.Audio {
+	if start == 0 && n == numFrames(a) {
+		return a
+	}
+	result := &audio.Audio{
+		Samples: make([][]float32, len(a.Samples)),
+		Rate:    a.Rate,
+	}
+	for channelIndex, channel := range a.Samples {
+		result.Samples[channelIndex] = make([]float32, n)
+		if start < len(channel) {
+			copy(result.Samples[channelIndex], channel[start:])
+		}
+		for _, sample := range result.Samples[channelIndex] {
+			if absSample := float32(math.Abs(float64(sample))); absSample > result.MaxAbsAmplitude {
+				result.MaxAbsAmplitude = absSample
+			}
+		}
+	}
+	return result
+}
+
+// mono returns the sum of the first n frames of all channels in a.
+func mono(a *audio.Audio, n int) []float64 {
+	result := make([]float64, min(n, numFrames(a)))
+	for _, channel := range a.Samples {
+		for sampleIndex := range result {
+			result[sampleIndex] += float64(channel[sampleIndex])
+		}
+	}
+	return result
+}
+
+// estimateDelay returns the delay, in frames, of distortion relative to reference that maximizes their
+// normalized cross correlation, searching delays up to maxDelay and correlating at most window frames.
+func estimateDelay(reference, distortion *audio.Audio, maxDelay, window int) int {
+	ref := mono(reference, window)
+	dist := mono(distortion, window+maxDelay)
+	bestDelay, bestCorrelation := 0, math.Inf(-1)
+	for delay := -maxDelay; delay <= maxDelay; delay++ {
+		dot, refEnergy, distEnergy := 0.0, 0.0, 0.0
+		for refIndex := max(0, -delay); refIndex < len(ref) && refIndex+delay < len(dist); refIndex++ {
+			refSample, distSample := ref[refIndex], dist[refIndex+delay]
+			dot += refSample * distSample
+			refEnergy += refSample * refSample
+			distEnergy += distSample * distSample
+		}
+		if refEnergy == 0 || distEnergy == 0 {
+			continue
+		}
+		if correlation := dot / math.Sqrt(refEnergy*distEnergy); correlation > bestCorrelation {
+			bestDelay, bestCorrelation = delay, correlation
+		}
+	}
+	return bestDelay
+}
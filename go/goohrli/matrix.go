@@ -0,0 +1,140 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// DistanceMatrix returns the distances between all pairs of signals, where element [i][j] is the distance
+// from signal i, as reference, to signal j.
+//
+// To analyze each signal only once, the channels of all signals are normalized to the max amplitude of the
+// same channel in the loudest signal, instead of normalizing each distortion to its reference like
+// CompareMany does. Signals adjusted by the length policy are analyzed again for each pair.
+//
+// Channels where both signals are silent have distance 0, see CompareMany.
+//
+// With a symmetric Symmetry, element [i][j] and element [j][i] are both the combination of the distances in
+// both directions.
+//
+// The signals must have the same sample rate, and are measured by ForRate of that rate. The signals are not
+// modified.
+func (g *Goohrli) DistanceMatrix(signals []*audio.Audio) ([][]float64, error) {
+	if err := g.Symmetry.validate(); err != nil {
+		return nil, err
+	}
+	if len(signals) == 0 {
+		return nil, nil
+	}
+	g, err := g.ForRate(signals[0].Rate)
+	if err != nil {
+		return nil, fmt.Errorf("signal 0: %v", err)
+	}
+	signals = append([]*audio.Audio{}, signals...)
+	for signalIndex, signal := range signals {
+		var err error
+		if signals[signalIndex], err = g.ChannelPolicy.Apply(signal); err != nil {
+			return nil, fmt.Errorf("signal %v: %v", signalIndex, err)
+		}
+	}
+	params := g.Parameters()
+	numChannels := len(signals[0].Samples)
+	if numChannels == 0 {
+		return nil, fmt.Errorf("signal 0 doesn't have any channels")
+	}
+	maxAbsAmplitudes := make([]float32, numChannels)
+	for signalIndex, signal := range signals {
+		if params.SampleRate != signal.Rate {
+			return nil, fmt.Errorf("signal %v doesn't have the expected sample rate %v: %v", signalIndex, params.SampleRate, signal.Rate)
+		}
+		if len(signal.Samples) != numChannels {
+			return nil, fmt.Errorf("signal 0 and signal %v don't have the same number of channels: %v, %v", signalIndex, numChannels, len(signal.Samples))
+		}
+		if len(signal.Samples[0]) == 0 {
+			return nil, fmt.Errorf("signal %v is empty", signalIndex)
+		}
+		for channelIndex, channel := range signal.Samples {
+			maxAbsAmplitudes[channelIndex] = max(maxAbsAmplitudes[channelIndex], Measure(channel).MaxAbsAmplitude)
+		}
+	}
+	normalized := make([]*audio.Audio, len(signals))
+	analyses := make([][]*Analysis, len(signals))
+	for signalIndex, signal := range signals {
+		normalized[signalIndex] = &audio.Audio{
+			Samples: make([][]float32, numChannels),
+			Rate:    signal.Rate,
+		}
+		analyses[signalIndex] = make([]*Analysis, numChannels)
+		for channelIndex, channel := range signal.Samples {
+			normalizedChannel := append([]float32{}, channel...)
+			normalized[signalIndex].Samples[channelIndex] = normalizedChannel
+			NormalizeAmplitude(maxAbsAmplitudes[channelIndex], normalizedChannel)
+			analyses[signalIndex][channelIndex] = g.analyze(normalizedChannel)
+			defer analyses[signalIndex][channelIndex].free()
+		}
+	}
+	result := make([][]float64, len(signals))
+	for referenceIndex := range signals {
+		result[referenceIndex] = make([]float64, len(signals))
+		for distortionIndex := range signals {
+			if referenceIndex == distortionIndex {
+				continue
+			}
+			reference, distortion, err := g.LengthPolicy.Apply(normalized[referenceIndex], normalized[distortionIndex])
+			if err != nil {
+				return nil, fmt.Errorf("signal %v and signal %v: %v", referenceIndex, distortionIndex, err)
+			}
+			sumOfSquares := 0.0
+			for channelIndex := 0; channelIndex < numChannels; channelIndex++ {
+				if isSilent(reference.Samples[channelIndex]) && isSilent(distortion.Samples[channelIndex]) {
+					continue
+				}
+				referenceAnalysis := analyses[referenceIndex][channelIndex]
+				if reference != normalized[referenceIndex] {
+					referenceAnalysis = g.analyze(reference.Samples[channelIndex])
+				}
+				distortionAnalysis := analyses[distortionIndex][channelIndex]
+				if distortion != normalized[distortionIndex] {
+					distortionAnalysis = g.analyze(distortion.Samples[channelIndex])
+				}
+				dist, err := g.analysisDistance(referenceAnalysis, distortionAnalysis)
+				if reference != normalized[referenceIndex] {
+					referenceAnalysis.free()
+				}
+				if distortion != normalized[distortionIndex] {
+					distortionAnalysis.free()
+				}
+				if err != nil {
+					return nil, fmt.Errorf("signal %v and signal %v channel %v: %v", referenceIndex, distortionIndex, channelIndex, err)
+				}
+				sumOfSquares += float64(dist) * float64(dist)
+			}
+			result[referenceIndex][distortionIndex] = math.Sqrt(sumOfSquares / float64(numChannels))
+		}
+	}
+	if g.Symmetry.Symmetric() {
+		for referenceIndex := range result {
+			for distortionIndex := referenceIndex + 1; distortionIndex < len(result); distortionIndex++ {
+				combined := g.Symmetry.Combine(result[referenceIndex][distortionIndex], result[distortionIndex][referenceIndex])
+				result[referenceIndex][distortionIndex], result[distortionIndex][referenceIndex] = combined, combined
+			}
+		}
+	}
+	return result, nil
+}
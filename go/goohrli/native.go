@@ -0,0 +1,253 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !zimtohrli_purego
+
+package goohrli
+
+/*
+#cgo CFLAGS: -O3
+#include "goohrli.h"
+*/
+import "C"
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+)
+
+// PureGo is whether the package uses the pure-Go reference implementation in go/zimt instead of the C++ library.
+const PureGo = false
+
+func measure(signal []float32) EnergyAndMaxAbsAmplitude {
+	measurements := C.Measure((*C.float)(&signal[0]), C.int(len(signal)))
+	return EnergyAndMaxAbsAmplitude{
+		EnergyDBFS:      float32(measurements.EnergyDBFS),
+		MaxAbsAmplitude: float32(measurements.MaxAbsAmplitude),
+	}
+}
+
+func normalizeAmplitude(maxAbsAmplitude float32, signal []float32) EnergyAndMaxAbsAmplitude {
+	measurements := C.NormalizeAmplitude(C.float(maxAbsAmplitude), (*C.float)(&signal[0]), C.int(len(signal)))
+	return EnergyAndMaxAbsAmplitude{
+		EnergyDBFS:      float32(measurements.EnergyDBFS),
+		MaxAbsAmplitude: float32(measurements.MaxAbsAmplitude),
+	}
+}
+
+func mosFromZimtohrli(zimtohrliDistance float64) float64 {
+	return float64(C.MOSFromZimtohrli(C.float(zimtohrliDistance)))
+}
+
+func libraryCommit() string {
+	return C.GoString(C.ZimtohrliCommit())
+}
+
+func libraryBuildFlags() string {
+	return C.GoString(C.ZimtohrliBuildFlags())
+}
+
+// zimtohrli wraps a zimtohrli::Zimtohrli.
+type zimtohrli struct {
+	zimtohrli C.Zimtohrli
+}
+
+func newZimtohrli(params Parameters) *zimtohrli {
+	result := &zimtohrli{
+		zimtohrli: C.CreateZimtohrli(cFromGoParameters(params)),
+	}
+	runtime.SetFinalizer(result, func(z *zimtohrli) {
+		C.FreeZimtohrli(z.zimtohrli)
+	})
+	return result
+}
+
+func cFromGoParameters(params Parameters) C.ZimtohrliParameters {
+	var cParams C.ZimtohrliParameters
+	cParams.SampleRate = C.float(params.SampleRate)
+	cParams.FrequencyResolution = C.float(params.FrequencyResolution)
+	cParams.PerceptualSampleRate = C.float(params.PerceptualSampleRate)
+	if params.ApplyMasking {
+		cParams.ApplyMasking = 1
+	} else {
+		cParams.ApplyMasking = 0
+	}
+	cParams.FullScaleSineDB = C.float(params.FullScaleSineDB)
+	if params.ApplyLoudness {
+		cParams.ApplyLoudness = 1
+	} else {
+		cParams.ApplyLoudness = 0
+	}
+	cParams.UnwarpWindowSeconds = C.float(float64(params.UnwarpWindow.Duration) / float64(time.Second))
+	cParams.NSIMStepWindow = C.int(params.NSIMStepWindow)
+	cParams.NSIMChannelWindow = C.int(params.NSIMChannelWindow)
+	cParams.MaskingLowerZeroAt20 = C.float(params.MaskingLowerZeroAt20)
+	cParams.MaskingLowerZeroAt80 = C.float(params.MaskingLowerZeroAt80)
+	cParams.MaskingUpperZeroAt20 = C.float(params.MaskingUpperZeroAt20)
+	cParams.MaskingUpperZeroAt80 = C.float(params.MaskingUpperZeroAt80)
+	cParams.MaskingMaxMask = C.float(params.MaskingMaxMask)
+	cParams.FilterOrder = C.int(params.FilterOrder)
+	cParams.FilterPassBandRipple = C.float(params.FilterPassBandRipple)
+	cParams.FilterStopBandRipple = C.float(params.FilterStopBandRipple)
+	if int(C.NumLoudnessAFParams()) != len(params.LoudnessAFParams) {
+		log.Panicf("C++ API uses %v AF parameters for loudness, but Go API uses %v", C.NumLoudnessAFParams(), len(params.LoudnessAFParams))
+	}
+	for i, f := range params.LoudnessAFParams {
+		cParams.LoudnessAFParams[i] = C.float(f)
+	}
+	if int(C.NumLoudnessLUParams()) != len(params.LoudnessLUParams) {
+		log.Panicf("C++ API uses %v LU parameters for loudness, but Go API uses %v", C.NumLoudnessLUParams(), len(params.LoudnessLUParams))
+	}
+	for i, f := range params.LoudnessLUParams {
+		cParams.LoudnessLUParams[i] = C.float(f)
+	}
+	if int(C.NumLoudnessTFParams()) != len(params.LoudnessTFParams) {
+		log.Panicf("C++ API uses %v TF parameters for loudness, but Go API uses %v", C.NumLoudnessTFParams(), len(params.LoudnessTFParams))
+	}
+	for i, f := range params.LoudnessTFParams {
+		cParams.LoudnessTFParams[i] = C.float(f)
+	}
+	return cParams
+}
+
+func goFromCParameters(cParams C.ZimtohrliParameters) Parameters {
+	result := Parameters{
+		SampleRate:           float64(cParams.SampleRate),
+		FrequencyResolution:  float64(cParams.FrequencyResolution),
+		PerceptualSampleRate: float64(cParams.PerceptualSampleRate),
+		ApplyMasking:         cParams.ApplyMasking != 0,
+		FullScaleSineDB:      float64(cParams.FullScaleSineDB),
+		ApplyLoudness:        cParams.ApplyLoudness != 0,
+		UnwarpWindow:         Duration{time.Duration(float64(time.Second) * float64(cParams.UnwarpWindowSeconds))},
+		NSIMStepWindow:       int(cParams.NSIMStepWindow),
+		NSIMChannelWindow:    int(cParams.NSIMChannelWindow),
+		MaskingLowerZeroAt20: float64(cParams.MaskingLowerZeroAt20),
+		MaskingLowerZeroAt80: float64(cParams.MaskingLowerZeroAt80),
+		MaskingUpperZeroAt20: float64(cParams.MaskingUpperZeroAt20),
+		MaskingUpperZeroAt80: float64(cParams.MaskingUpperZeroAt80),
+		MaskingMaxMask:       float64(cParams.MaskingMaxMask),
+		FilterOrder:          int(cParams.FilterOrder),
+		FilterPassBandRipple: float64(cParams.FilterPassBandRipple),
+		FilterStopBandRipple: float64(cParams.FilterStopBandRipple),
+	}
+	if int(C.NumLoudnessAFParams()) != len(result.LoudnessAFParams) {
+		log.Panicf("C++ API uses %v AF parameters for loudness, but Go API uses %v", C.NumLoudnessAFParams(), len(result.LoudnessAFParams))
+	}
+	for i, cFloat := range cParams.LoudnessAFParams {
+		result.LoudnessAFParams[i] = float64(cFloat)
+	}
+	if int(C.NumLoudnessLUParams()) != len(result.LoudnessLUParams) {
+		log.Panicf("C++ API uses %v LU parameters for loudness, but Go API uses %v", C.NumLoudnessLUParams(), len(result.LoudnessLUParams))
+	}
+	for i, cFloat := range cParams.LoudnessLUParams {
+		result.LoudnessLUParams[i] = float64(cFloat)
+	}
+	if int(C.NumLoudnessTFParams()) != len(result.LoudnessTFParams) {
+		log.Panicf("C++ API uses %v TF parameters for loudness, but Go API uses %v", C.NumLoudnessTFParams(), len(result.LoudnessTFParams))
+	}
+	for i, cFloat := range cParams.LoudnessTFParams {
+		result.LoudnessTFParams[i] = float64(cFloat)
+	}
+	return result
+}
+
+func defaultParameters(sampleRate float64) Parameters {
+	return goFromCParameters(C.DefaultZimtohrliParameters(C.float(sampleRate)))
+}
+
+// creationError returns nil, since the C++ library creates a filterbank for any parameters.
+func (z *zimtohrli) creationError() error {
+	return nil
+}
+
+func (z *zimtohrli) parameters() Parameters {
+	return goFromCParameters(C.GetZimtohrliParameters(z.zimtohrli))
+}
+
+func (z *zimtohrli) set(params Parameters) {
+	C.SetZimtohrliParameters(z.zimtohrli, cFromGoParameters(params))
+}
+
+func (z *zimtohrli) analyze(signal []float32) *analysis {
+	return newAnalysis(C.Analyze(z.zimtohrli, (*C.float)(&signal[0]), C.int(len(signal))))
+}
+
+// distance returns the distance between the analyses, which is NaN instead of an error if they can't be compared.
+func (z *zimtohrli) distance(analysisA, analysisB *analysis) (float32, error) {
+	return float32(C.AnalysisDistance(z.zimtohrli, analysisA.analysis, analysisB.analysis)), nil
+}
+
+// analysis wraps a zimtohrli::Analysis.
+type analysis struct {
+	analysis C.Analysis
+}
+
+func newAnalysis(cAnalysis C.Analysis) *analysis {
+	result := &analysis{
+		analysis: cAnalysis,
+	}
+	runtime.SetFinalizer(result, func(a *analysis) {
+		C.FreeAnalysis(a.analysis)
+	})
+	return result
+}
+
+func newAnalysisFromSpectrogram(data []float32, numSteps, numChannels int) *analysis {
+	return newAnalysis(C.CreateAnalysis((*C.float)(&data[0]), C.int(numSteps), C.int(numChannels)))
+}
+
+func (a *analysis) free() {
+	runtime.SetFinalizer(a, nil)
+	C.FreeAnalysis(a.analysis)
+	a.analysis = nil
+}
+
+func (a *analysis) spectrogram() [][]float32 {
+	numSteps := int(C.AnalysisNumSteps(a.analysis))
+	numChannels := int(C.AnalysisNumChannels(a.analysis))
+	result := make([][]float32, numSteps)
+	if numSteps == 0 || numChannels == 0 {
+		return result
+	}
+	data := make([]float32, numSteps*numChannels)
+	C.GetAnalysisSpectrogram(a.analysis, (*C.float)(&data[0]))
+	for stepIndex := range result {
+		result[stepIndex] = data[stepIndex*numChannels : (stepIndex+1)*numChannels]
+	}
+	return result
+}
+
+// visqol wraps a zimtohrli::ViSQOL.
+type visqol struct {
+	visqol C.ViSQOL
+}
+
+func newVisqol() *visqol {
+	result := &visqol{
+		visqol: C.CreateViSQOL(),
+	}
+	runtime.SetFinalizer(result, func(v *visqol) {
+		C.FreeViSQOL(v.visqol)
+	})
+	return result
+}
+
+func (v *visqol) mos(sampleRate float64, reference []float32, degraded []float32) (float64, error) {
+	result := C.MOS(v.visqol, C.float(sampleRate), (*C.float)(&reference[0]), C.int(len(reference)), (*C.float)(&degraded[0]), C.int(len(degraded)))
+	if result.Status != 0 {
+		return 0, fmt.Errorf("calling ViSQOL returned status %v", result.Status)
+	}
+	return float64(result.MOS), nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !zimtohrli_purego
+
+package goohrli
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/google/zimtohrli/go/zimt"
+)
+
+func TestParamConversion(t *testing.T) {
+	params := Parameters{}
+	populate(&params)
+	cParams := cFromGoParameters(params)
+	reconvertedParams := goFromCParameters(cParams)
+	checkNear(reconvertedParams, params, 1e-6, t)
+}
+
+// parityTestSignals returns one second sines and noise to compare the pure-Go and C++ implementations on.
+func parityTestSignals(sampleRate float64) map[string][]float32 {
+	rng := rand.New(rand.NewSource(0))
+	noise := func(amplitude float64) []float32 {
+		result := make([]float32, int(sampleRate))
+		for index := range result {
+			result[index] = float32(amplitude * (2*rng.Float64() - 1))
+		}
+		return result
+	}
+	return map[string][]float32{
+		"1kHz":        sine(1000, sampleRate, int(sampleRate)),
+		"1.1kHz":      sine(1100, sampleRate, int(sampleRate)),
+		"5kHz":        sine(5000, sampleRate, int(sampleRate)),
+		"noise":       noise(0.5),
+		"quiet noise": noise(0.1),
+	}
+}
+
+func TestPureGoSpectrogramParity(t *testing.T) {
+	sampleRate := 48000.0
+	g := New(DefaultParameters(sampleRate))
+	z, err := zimt.New(zimt.DefaultCam(), float32(sampleRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, signal := range parityTestSignals(sampleRate) {
+		analysis, err := z.Analyze(signal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := g.Analyze(signal).Spectrogram()
+		if len(analysis.Spectrogram) != len(want) {
+			t.Fatalf("%v: got %v steps, want %v", name, len(analysis.Spectrogram), len(want))
+		}
+		maxDelta := 0.0
+		for stepIndex := range want {
+			if len(analysis.Spectrogram[stepIndex]) != len(want[stepIndex]) {
+				t.Fatalf("%v: got %v channels, want %v", name, len(analysis.Spectrogram[stepIndex]), len(want[stepIndex]))
+			}
+			for channelIndex := range want[stepIndex] {
+				maxDelta = math.Max(maxDelta, math.Abs(float64(analysis.Spectrogram[stepIndex][channelIndex]-want[stepIndex][channelIndex])))
+			}
+		}
+		if maxDelta > 1e-2 {
+			t.Errorf("%v: the pure-Go spectrogram is up to %v Phons off from the C++ spectrogram", name, maxDelta)
+		}
+	}
+}
+
+func TestPureGoDistanceParity(t *testing.T) {
+	sampleRate := 48000.0
+	g := New(DefaultParameters(sampleRate))
+	z, err := zimt.New(zimt.DefaultCam(), float32(sampleRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signals := parityTestSignals(sampleRate)
+	spectrograms := map[string][][]float32{}
+	for name, signal := range signals {
+		analysis, err := z.Analyze(signal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spectrograms[name] = analysis.Spectrogram
+	}
+	for nameA, signalA := range signals {
+		for nameB, signalB := range signals {
+			got, err := z.Distance(spectrograms[nameA], spectrograms[nameB])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := g.Distance(signalA, signalB); math.Abs(float64(got)-want) > 1e-4 {
+				t.Errorf("%v vs %v: got pure-Go distance %v, want C++ distance %v", nameA, nameB, got, want)
+			}
+		}
+	}
+}
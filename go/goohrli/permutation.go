@@ -0,0 +1,132 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// MaxPermutedSources is the max number of sources AssignSources finds the best assignment for, since it tries all
+// permutations of the estimated sources.
+const MaxPermutedSources = 8
+
+// SourceAssignment is the assignment of the estimated sources in the channels of a source separation output to the
+// reference sources in the channels of the separated mixture.
+type SourceAssignment struct {
+	// Permutation contains, for each reference source, the channel of the estimated source assigned to it.
+	Permutation []int
+	// Distances contains, for each reference source, the distance to the estimated source assigned to it.
+	Distances []float64
+}
+
+// MeanDistance returns the mean distance between the reference sources and their assigned estimated sources.
+func (s *SourceAssignment) MeanDistance() float64 {
+	sum := 0.0
+	for _, distance := range s.Distances {
+		sum += distance
+	}
+	return sum / float64(len(s.Distances))
+}
+
+// AssignSources compares the reference sources, one per channel, with the estimated sources of a source
+// separation output, one per channel in unknown order, and returns the assignment of estimated sources to
+// reference sources that minimizes the total distance, i.e. permutation invariant scores.
+//
+// Each pair of sources is compared like NormalizedAudioDistance compares mono signals, so the channel policy
+// doesn't apply. The signals must have the same sample rate and number of channels, at most MaxPermutedSources.
+// The signals are not modified.
+func (g *Goohrli) AssignSources(references, estimates *audio.Audio) (*SourceAssignment, error) {
+	numSources := len(references.Samples)
+	if numSources == 0 {
+		return nil, fmt.Errorf("the references don't have any channels")
+	}
+	if len(estimates.Samples) != numSources {
+		return nil, fmt.Errorf("the references and the estimates don't have the same number of channels: %v, %v", numSources, len(estimates.Samples))
+	}
+	if numSources > MaxPermutedSources {
+		return nil, fmt.Errorf("%v sources is more than the max %v sources that can be permuted", numSources, MaxPermutedSources)
+	}
+	if references.Rate != estimates.Rate {
+		return nil, fmt.Errorf("the references and the estimates don't have the same sample rate: %v, %v", references.Rate, estimates.Rate)
+	}
+	distances := make([][]float64, numSources)
+	for referenceIndex, reference := range references.Samples {
+		// The estimates are normalized in place, so each reference gets copies.
+		candidates := make([]*audio.Audio, numSources)
+		for estimateIndex, estimate := range estimates.Samples {
+			candidates[estimateIndex] = &audio.Audio{Samples: [][]float32{append([]float32{}, estimate...)}, Rate: estimates.Rate}
+		}
+		var err error
+		if distances[referenceIndex], err = g.CompareMany(&audio.Audio{Samples: [][]float32{append([]float32{}, reference...)}, Rate: references.Rate}, candidates); err != nil {
+			return nil, fmt.Errorf("reference source %v: %v", referenceIndex, err)
+		}
+		for estimateIndex, distance := range distances[referenceIndex] {
+			if math.IsNaN(distance) {
+				return nil, fmt.Errorf("reference source %v and estimated source %v can't be compared", referenceIndex, estimateIndex)
+			}
+		}
+	}
+	permutation := bestPermutation(distances)
+	result := &SourceAssignment{
+		Permutation: permutation,
+		Distances:   make([]float64, numSources),
+	}
+	for referenceIndex, estimateIndex := range permutation {
+		result.Distances[referenceIndex] = distances[referenceIndex][estimateIndex]
+	}
+	return result, nil
+}
+
+// bestPermutation returns the permutation, where element i is the column assigned to row i, that minimizes the sum
+// of the assigned elements of the square matrix, which must not contain NaN. Ties are broken in favor of the
+// lexicographically smallest permutation, so that the identity is preferred for equal distances, and when all
+// permutations sum to +Inf.
+func bestPermutation(matrix [][]float64) []int {
+	size := len(matrix)
+	// The search starts from the identity, so it only replaces it with permutations of strictly smaller sums.
+	best, bestSum := make([]int, size), 0.0
+	for row := range best {
+		best[row] = row
+		bestSum += matrix[row][row]
+	}
+	current := make([]int, 0, size)
+	used := make([]bool, size)
+	var search func(sum float64)
+	search = func(sum float64) {
+		if sum >= bestSum {
+			return
+		}
+		row := len(current)
+		if row == size {
+			best, bestSum = append([]int{}, current...), sum
+			return
+		}
+		for column := 0; column < size; column++ {
+			if used[column] {
+				continue
+			}
+			used[column] = true
+			current = append(current, column)
+			search(sum + matrix[row][column])
+			current = current[:row]
+			used[column] = false
+		}
+	}
+	search(0)
+	return best
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build zimtohrli_purego
+
+package goohrli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/zimtohrli/go/zimt"
+)
+
+// PureGo is whether the package uses the pure-Go reference implementation in go/zimt instead of the C++ library.
+const PureGo = true
+
+// LoadLibrary returns an error, since the package is built with -tags zimtohrli_purego and doesn't use the C++
+// library.
+func LoadLibrary(path string) error {
+	return fmt.Errorf("goohrli uses the pure-Go reference implementation, build without -tags zimtohrli_purego to load %q", path)
+}
+
+func measure(signal []float32) EnergyAndMaxAbsAmplitude {
+	return EnergyAndMaxAbsAmplitude(zimt.Measure(signal))
+}
+
+func normalizeAmplitude(maxAbsAmplitude float32, signal []float32) EnergyAndMaxAbsAmplitude {
+	return EnergyAndMaxAbsAmplitude(zimt.NormalizeAmplitude(maxAbsAmplitude, signal))
+}
+
+func mosFromZimtohrli(zimtohrliDistance float64) float64 {
+	return float64(zimt.MOSFromZimtohrli(float32(zimtohrliDistance)))
+}
+
+func libraryCommit() string {
+	return "purego"
+}
+
+func libraryBuildFlags() string {
+	return "pure-Go reference implementation"
+}
+
+// zimtohrli wraps a zimt.Zimtohrli.
+//
+// If the filterbank for the parameters can't be created, zimtohrli is nil, params contains the parameters, and all
+// analyses fail with creationErr.
+type zimtohrli struct {
+	zimtohrli   *zimt.Zimtohrli
+	params      Parameters
+	creationErr error
+}
+
+// camFromParameters returns the Cam the C++ library would create the filterbank for the parameters from.
+func camFromParameters(params Parameters) zimt.Cam {
+	cam := zimt.DefaultCam()
+	cam.MinimumBandwidthHz = float32(params.FrequencyResolution)
+	cam.FilterOrder = params.FilterOrder
+	cam.FilterPassBandRipple = float32(params.FilterPassBandRipple)
+	cam.FilterStopBandRipple = float32(params.FilterStopBandRipple)
+	cam.HighThresholdHz = min(cam.HighThresholdHz, float32(params.SampleRate)*0.5)
+	return cam
+}
+
+func newZimtohrli(params Parameters) *zimtohrli {
+	z, err := zimt.New(camFromParameters(params), float32(params.SampleRate))
+	if err != nil {
+		return &zimtohrli{params: params, creationErr: fmt.Errorf("creating a filterbank for %+v: %v", params, err)}
+	}
+	result := &zimtohrli{zimtohrli: z}
+	result.set(params)
+	return result
+}
+
+func (z *zimtohrli) creationError() error {
+	return z.creationErr
+}
+
+// parametersFrom returns the parameters of z that don't depend on its filterbank.
+func parametersFrom(z *zimt.Zimtohrli) Parameters {
+	result := Parameters{
+		PerceptualSampleRate: float64(z.PerceptualSampleRate),
+		ApplyMasking:         z.ApplyMasking,
+		FullScaleSineDB:      float64(z.FullScaleSineDB),
+		ApplyLoudness:        z.ApplyLoudness,
+		UnwarpWindow:         Duration{time.Duration(float64(time.Second) * float64(z.UnwarpWindowSeconds))},
+		NSIMStepWindow:       z.NSIMStepWindow,
+		NSIMChannelWindow:    z.NSIMChannelWindow,
+		MaskingLowerZeroAt20: float64(z.Masking.LowerZeroAt20),
+		MaskingLowerZeroAt80: float64(z.Masking.LowerZeroAt80),
+		MaskingUpperZeroAt20: float64(z.Masking.UpperZeroAt20),
+		MaskingUpperZeroAt80: float64(z.Masking.UpperZeroAt80),
+		MaskingMaxMask:       float64(z.Masking.MaxMask),
+	}
+	for i, f := range z.Loudness.AFParams {
+		result.LoudnessAFParams[i] = float64(f)
+	}
+	for i, f := range z.Loudness.LUParams {
+		result.LoudnessLUParams[i] = float64(f)
+	}
+	for i, f := range z.Loudness.TFParams {
+		result.LoudnessTFParams[i] = float64(f)
+	}
+	return result
+}
+
+func defaultParameters(sampleRate float64) Parameters {
+	defaults := zimt.Default()
+	result := parametersFrom(&defaults)
+	cam := zimt.DefaultCam()
+	// The frequency resolution is the width of the first channel, like the C++ library reports it.
+	lowThresholdCam := cam.CamFromHz(cam.LowThresholdHz)
+	camDelta := cam.CamFromHz(cam.LowThresholdHz+cam.MinimumBandwidthHz) - lowThresholdCam
+	result.SampleRate = sampleRate
+	result.FrequencyResolution = float64(cam.HzFromCam(lowThresholdCam+camDelta) - cam.HzFromCam(lowThresholdCam))
+	result.FilterOrder = cam.FilterOrder
+	result.FilterPassBandRipple = float64(cam.FilterPassBandRipple)
+	result.FilterStopBandRipple = float64(cam.FilterStopBandRipple)
+	return result
+}
+
+func (z *zimtohrli) parameters() Parameters {
+	if z.zimtohrli == nil {
+		return z.params
+	}
+	filterbank := z.zimtohrli.CamFilterbank
+	result := parametersFrom(z.zimtohrli)
+	result.SampleRate = float64(filterbank.SampleRate)
+	result.FrequencyResolution = float64(filterbank.ThresholdsHz[2][0] - filterbank.ThresholdsHz[0][0])
+	result.FilterOrder = filterbank.FilterOrder
+	result.FilterPassBandRipple = float64(filterbank.FilterPassBandRipple)
+	result.FilterStopBandRipple = float64(filterbank.FilterStopBandRipple)
+	return result
+}
+
+func (z *zimtohrli) set(params Parameters) {
+	if z.zimtohrli == nil {
+		params.SampleRate = z.params.SampleRate
+		params.FrequencyResolution = z.params.FrequencyResolution
+		params.FilterOrder = z.params.FilterOrder
+		params.FilterPassBandRipple = z.params.FilterPassBandRipple
+		params.FilterStopBandRipple = z.params.FilterStopBandRipple
+		z.params = params
+		return
+	}
+	z.zimtohrli.PerceptualSampleRate = float32(params.PerceptualSampleRate)
+	z.zimtohrli.ApplyMasking = params.ApplyMasking
+	z.zimtohrli.FullScaleSineDB = float32(params.FullScaleSineDB)
+	z.zimtohrli.ApplyLoudness = params.ApplyLoudness
+	z.zimtohrli.NSIMStepWindow = params.NSIMStepWindow
+	z.zimtohrli.NSIMChannelWindow = params.NSIMChannelWindow
+	z.zimtohrli.UnwarpWindowSeconds = float32(float64(params.UnwarpWindow.Duration) / float64(time.Second))
+	z.zimtohrli.Masking = zimt.Masking{
+		LowerZeroAt20: float32(params.MaskingLowerZeroAt20),
+		LowerZeroAt80: float32(params.MaskingLowerZeroAt80),
+		UpperZeroAt20: float32(params.MaskingUpperZeroAt20),
+		UpperZeroAt80: float32(params.MaskingUpperZeroAt80),
+		MaxMask:       float32(params.MaskingMaxMask),
+	}
+	for i, f := range params.LoudnessAFParams {
+		z.zimtohrli.Loudness.AFParams[i] = float32(f)
+	}
+	for i, f := range params.LoudnessLUParams {
+		z.zimtohrli.Loudness.LUParams[i] = float32(f)
+	}
+	for i, f := range params.LoudnessTFParams {
+		z.zimtohrli.Loudness.TFParams[i] = float32(f)
+	}
+}
+
+// analyze returns the analysis of the signal, or an analysis containing the error if the signal can't be analyzed.
+func (z *zimtohrli) analyze(signal []float32) *analysis {
+	if z.creationErr != nil {
+		return &analysis{err: z.creationErr}
+	}
+	result, err := z.zimtohrli.Analyze(signal)
+	if err != nil {
+		return &analysis{err: err}
+	}
+	return &analysis{spectrogramData: result.Spectrogram}
+}
+
+func (z *zimtohrli) distance(analysisA, analysisB *analysis) (float32, error) {
+	for _, a := range []*analysis{analysisA, analysisB} {
+		if a.err != nil {
+			return 0, a.err
+		}
+	}
+	return z.zimtohrli.Distance(analysisA.spectrogramData, analysisB.spectrogramData)
+}
+
+// analysis contains the spectrogram of a zimt.Analysis, or the error that made the analysis fail.
+type analysis struct {
+	spectrogramData [][]float32
+	err             error
+}
+
+func newAnalysisFromSpectrogram(data []float32, numSteps, numChannels int) *analysis {
+	result := &analysis{spectrogramData: make([][]float32, numSteps)}
+	for stepIndex := range result.spectrogramData {
+		result.spectrogramData[stepIndex] = append([]float32{}, data[stepIndex*numChannels:(stepIndex+1)*numChannels]...)
+	}
+	return result
+}
+
+func (a *analysis) free() {
+	a.spectrogramData = nil
+}
+
+func (a *analysis) spectrogram() [][]float32 {
+	result := make([][]float32, len(a.spectrogramData))
+	for stepIndex, step := range a.spectrogramData {
+		result[stepIndex] = append([]float32{}, step...)
+	}
+	return result
+}
+
+// visqol is a placeholder, since ViSQOL is only available in the C++ library. ViSQOL isn't supported by the pure-Go
+// reference implementation, and all its scores are errors.
+type visqol struct{}
+
+func newVisqol() *visqol {
+	return &visqol{}
+}
+
+func (v *visqol) mos(sampleRate float64, reference []float32, degraded []float32) (float64, error) {
+	return 0, fmt.Errorf("ViSQOL isn't available in the pure-Go reference implementation, build without -tags zimtohrli_purego to use it")
+}
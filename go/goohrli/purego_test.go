@@ -0,0 +1,61 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build zimtohrli_purego
+
+package goohrli
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+func TestPureGoErrors(t *testing.T) {
+	signal := sine(1000, 48000, 4800)
+	params := DefaultParameters(48000)
+	params.FilterPassBandRipple = 0
+	broken := New(params)
+	if got := broken.Parameters(); got.FilterPassBandRipple != 0 || got.SampleRate != 48000 {
+		t.Errorf("Parameters() = %+v, want the parameters it was created with", got)
+	}
+	if distance := broken.Distance(signal, signal); !math.IsNaN(distance) {
+		t.Errorf("Distance with invalid filter parameters = %v, want NaN", distance)
+	}
+	signalAudio := &audio.Audio{Samples: [][]float32{signal}, Rate: 48000}
+	if _, err := broken.CompareMany(signalAudio, []*audio.Audio{signalAudio}); err == nil || !strings.Contains(err.Error(), "creating a filterbank") {
+		t.Errorf("CompareMany with invalid filter parameters returned %v, want a filterbank error", err)
+	}
+	if _, err := broken.ForRate(44100); err == nil {
+		t.Errorf("ForRate with invalid filter parameters returned no error")
+	}
+
+	g := New(DefaultParameters(48000))
+	empty := g.Analyze(nil)
+	if distance := g.AnalysisDistance(empty, g.Analyze(signal)); !math.IsNaN(float64(distance)) {
+		t.Errorf("AnalysisDistance to the analysis of an empty signal = %v, want NaN", distance)
+	}
+	narrow, err := NewAnalysis([][]float32{{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance := g.AnalysisDistance(narrow, g.Analyze(signal)); !math.IsNaN(float64(distance)) {
+		t.Errorf("AnalysisDistance between analyses with different channels = %v, want NaN", distance)
+	}
+	if _, err := NewViSQOL().MOS(48000, signal, signal); err == nil {
+		t.Errorf("ViSQOL returned no error")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import "fmt"
+
+// MinSampleRate is the lowest sample rate ForRate creates instances for.
+//
+// The filterbank covers frequencies up to 20 kHz, and creating it for audio at a lower Nyquist frequency aborts
+// the process, so audio at lower rates, e.g. 16 kHz speech, must be resampled.
+const MinSampleRate = 40000
+
+// ForRate returns a Goohrli with the same parameters and policies as g, but for audio at the sample rate.
+//
+// It returns g itself if g already has the sample rate. Instances for other rates are created when first needed,
+// and reused until the parameters of g are changed with Set, so that audio at different sample rates can be
+// measured without resampling and without recreating instances for each measurement.
+//
+// Returns an error if the sample rate is below MinSampleRate.
+func (g *Goohrli) ForRate(rate float64) (*Goohrli, error) {
+	params := g.Parameters()
+	if params.SampleRate == rate {
+		return g, nil
+	}
+	if rate < MinSampleRate {
+		return nil, fmt.Errorf("sample rate %v is below the min sample rate %v, resample to e.g. %v", rate, MinSampleRate, params.SampleRate)
+	}
+	g.ratesLock.Lock()
+	defer g.ratesLock.Unlock()
+	result, found := g.rates[rate]
+	if !found {
+		params.SampleRate = rate
+		result = New(params)
+		if err := result.zimtohrli.creationError(); err != nil {
+			return nil, err
+		}
+		if g.rates == nil {
+			g.rates = map[float64]*Goohrli{}
+		}
+		g.rates[rate] = result
+	}
+	// The policies are only assigned when they changed, to not race with concurrent measurements by result.
+	if result.AnalysisCache != g.AnalysisCache || result.LengthPolicy != g.LengthPolicy || result.ChannelPolicy != g.ChannelPolicy || result.Symmetry != g.Symmetry {
+		result.AnalysisCache = g.AnalysisCache
+		result.LengthPolicy = g.LengthPolicy
+		result.ChannelPolicy = g.ChannelPolicy
+		result.Symmetry = g.Symmetry
+	}
+	return result, nil
+}
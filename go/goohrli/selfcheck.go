@@ -0,0 +1,145 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+const (
+	// maxSelfDistance is the max distance SelfCheck accepts between a signal and itself.
+	maxSelfDistance = 1e-4
+	// maxAsymmetry is the max difference SelfCheck accepts between the distances in both directions, relative to the largest of them.
+	maxAsymmetry = 0.1
+)
+
+// selfCheckNoiseDB are the levels, in dB relative to the reference, of the noise SelfCheck adds to check that distances grow with it.
+var selfCheckNoiseDB = []float64{-50, -40, -30, -20, -10}
+
+// SelfCheckResult is the outcome of one of the invariants verified by SelfCheck.
+type SelfCheckResult struct {
+	Name    string
+	Passed  bool
+	Message string
+	// Distances are the distances the invariant was verified on.
+	Distances []float64
+}
+
+func (s SelfCheckResult) String() string {
+	status := "PASS"
+	if !s.Passed {
+		status = "FAIL"
+	}
+	return fmt.Sprintf("%s %s: %s", status, s.Name, s.Message)
+}
+
+// SelfCheckResults are the outcomes of all invariants verified by SelfCheck.
+type SelfCheckResults []SelfCheckResult
+
+// Passed returns whether all invariants hold.
+func (s SelfCheckResults) Passed() bool {
+	for _, result := range s {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// selfCheckSignal returns a second of deterministic test audio at the rate: a few tones plus noise at noiseDB
+// relative to the tones. A noiseDB of -Inf means no noise.
+func selfCheckSignal(rate float64, noiseDB float64) *audio.Audio {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float32, int(rate))
+	noiseAmplitude := 0.3 * math.Pow(10, noiseDB/20)
+	for index := range samples {
+		t := float64(index) / rate
+		sample := 0.1*math.Sin(2*math.Pi*440*t) + 0.1*math.Sin(2*math.Pi*1000*t) + 0.1*math.Sin(2*math.Pi*3000*t)
+		samples[index] = float32(sample + noiseAmplitude*(2*rng.Float64()-1))
+	}
+	return &audio.Audio{
+		Samples:         [][]float32{samples},
+		Rate:            rate,
+		MaxAbsAmplitude: Measure(samples).MaxAbsAmplitude,
+	}
+}
+
+// SelfCheck verifies invariants any working Zimtohrli setup must satisfy on synthetic signals:
+//
+//   - The distance between a signal and itself is zero.
+//   - The distance is symmetric, within tolerance.
+//   - The distance grows when more noise is added to a signal.
+//
+// Failures typically mean the binary is broken, e.g. by a bad CGo build, or misconfigured, e.g. by bad parameters.
+func (g *Goohrli) SelfCheck() SelfCheckResults {
+	rate := g.Parameters().SampleRate
+	reference := selfCheckSignal(rate, math.Inf(-1))
+	distance := func(a, b *audio.Audio) (float64, error) {
+		// NormalizedAudioDistance normalizes the amplitude of the distortion in place, so it gets a copy.
+		return g.NormalizedAudioDistance(a, &audio.Audio{Samples: [][]float32{append([]float32{}, b.Samples[0]...)}, Rate: b.Rate, MaxAbsAmplitude: b.MaxAbsAmplitude})
+	}
+	valid := func(d float64) bool {
+		return !math.IsNaN(d) && !math.IsInf(d, 0) && d >= 0
+	}
+	results := SelfCheckResults{}
+
+	self := SelfCheckResult{Name: "zero distance to self"}
+	if d, err := distance(reference, reference); err != nil {
+		self.Message = err.Error()
+	} else {
+		self.Distances = []float64{d}
+		self.Passed = valid(d) && d <= maxSelfDistance
+		self.Message = fmt.Sprintf("distance %v, want at most %v", d, maxSelfDistance)
+	}
+	results = append(results, self)
+
+	symmetry := SelfCheckResult{Name: "symmetry"}
+	noisy := selfCheckSignal(rate, -30)
+	if forward, err := distance(reference, noisy); err != nil {
+		symmetry.Message = err.Error()
+	} else if backward, err := distance(noisy, reference); err != nil {
+		symmetry.Message = err.Error()
+	} else {
+		symmetry.Distances = []float64{forward, backward}
+		asymmetry := math.Abs(forward-backward) / max(forward, backward)
+		symmetry.Passed = valid(forward) && valid(backward) && forward > 0 && asymmetry <= maxAsymmetry
+		symmetry.Message = fmt.Sprintf("distances %v and %v differ by %.1f%%, want at most %.1f%%", forward, backward, 100*asymmetry, 100*maxAsymmetry)
+	}
+	results = append(results, symmetry)
+
+	monotonicity := SelfCheckResult{Name: "monotonicity under added noise", Passed: true}
+	for _, noiseDB := range selfCheckNoiseDB {
+		d, err := distance(reference, selfCheckSignal(rate, noiseDB))
+		if err != nil {
+			monotonicity.Passed = false
+			monotonicity.Message = err.Error()
+			break
+		}
+		if !valid(d) || (len(monotonicity.Distances) > 0 && d <= monotonicity.Distances[len(monotonicity.Distances)-1]) {
+			monotonicity.Passed = false
+		}
+		monotonicity.Distances = append(monotonicity.Distances, d)
+	}
+	if monotonicity.Message == "" {
+		monotonicity.Message = fmt.Sprintf("distances %v for noise at %v dB, want them increasing", monotonicity.Distances, selfCheckNoiseDB)
+	}
+	results = append(results, monotonicity)
+
+	return results
+}
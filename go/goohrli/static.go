@@ -0,0 +1,29 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !zimtohrli_dlopen && !zimtohrli_purego
+
+package goohrli
+
+/*
+#cgo LDFLAGS: ${SRCDIR}/goohrli.a -lz -lopus -lFLAC -lvorbis -lvorbisenc -logg -lasound -lm -lstdc++
+*/
+import "C"
+import "fmt"
+
+// LoadLibrary returns an error, since the C++ library is linked statically unless the package is built with
+// -tags zimtohrli_dlopen.
+func LoadLibrary(path string) error {
+	return fmt.Errorf("goohrli is linked with goohrli.a, build with -tags zimtohrli_dlopen to load %q", path)
+}
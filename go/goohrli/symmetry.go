@@ -0,0 +1,63 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import "fmt"
+
+// Symmetry defines in which directions the distance between a reference and a distortion is computed.
+//
+// The Zimtohrli distance is not symmetric: the distortion is normalized to the amplitude of the reference, and
+// the time warping and length policies adjust the distortion to the reference, so swapping them can change the
+// distance.
+type Symmetry string
+
+const (
+	// SymmetryForward only computes the distance from the reference to the distortion, which treats energy added
+	// and removed by the distortion differently. This is the default.
+	SymmetryForward Symmetry = "forward"
+	// SymmetryMax computes the distance in both directions, and returns the max.
+	SymmetryMax Symmetry = "max"
+	// SymmetryMean computes the distance in both directions, and returns the mean.
+	SymmetryMean Symmetry = "mean"
+)
+
+// Symmetries contains all symmetries.
+var Symmetries = []Symmetry{SymmetryForward, SymmetryMax, SymmetryMean}
+
+// Symmetric returns whether distances computed with the symmetry are symmetric, i.e. independent of which signal is the reference.
+func (s Symmetry) Symmetric() bool {
+	return s == SymmetryMax || s == SymmetryMean
+}
+
+// validate returns an error if the symmetry is unknown.
+func (s Symmetry) validate() error {
+	switch s {
+	case "", SymmetryForward, SymmetryMax, SymmetryMean:
+		return nil
+	}
+	return fmt.Errorf("unknown symmetry %q, want one of %v", s, Symmetries)
+}
+
+// Combine returns the distance according to the symmetry, given the distances from the reference to the distortion
+// and from the distortion to the reference.
+func (s Symmetry) Combine(forward, backward float64) float64 {
+	switch s {
+	case SymmetryMax:
+		return max(forward, backward)
+	case SymmetryMean:
+		return 0.5 * (forward + backward)
+	}
+	return forward
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import "github.com/google/zimtohrli/go/worker"
+
+// The C++ library doesn't start threads of its own, so each analysis, distance, or ViSQOL computation occupies the
+// OS thread of the goroutine calling it. Limiting the number of concurrent computations lets callers run many
+// goroutines, e.g. to load and decode audio, without running more than that many CPU bound threads.
+var threads = worker.NewLimiterFromEnv("ZIMTOHRLI_THREADS")
+
+// MaxThreads returns the max number of C++ computations this package runs concurrently, or 0 if unlimited.
+func MaxThreads() int {
+	return threads.Max()
+}
+
+// SetMaxThreads limits the number of C++ computations, i.e. the number of threads computing analyses, distances,
+// and ViSQOL scores, this package runs concurrently, independently of how many goroutines use the package. Zero or
+// less means unlimited, i.e. one thread per calling goroutine.
+//
+// Defaults to $ZIMTOHRLI_THREADS, or unlimited if that isn't set.
+func SetMaxThreads(max int) {
+	threads.SetMax(max)
+}
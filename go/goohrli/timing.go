@@ -0,0 +1,38 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timing is the time spent in the stages of comparisons.
+type Timing struct {
+	// Analyze is the time spent analyzing signals, including waiting for a thread, see SetMaxThreads.
+	Analyze time.Duration
+	// Compare is the time spent computing distances between analyses.
+	Compare time.Duration
+}
+
+// Add adds the time spent in other to t.
+func (t *Timing) Add(other Timing) {
+	t.Analyze += other.Analyze
+	t.Compare += other.Compare
+}
+
+func (t Timing) String() string {
+	return fmt.Sprintf("analyze %v, compare %v", t.Analyze, t.Compare)
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goohrli
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// VersionInfo identifies the build of Zimtohrli that produces scores.
+type VersionInfo struct {
+	// Commit is the git description of the source the C++ library was built from, e.g. "v0.1-12-gabcdef0-dirty".
+	Commit string
+	// BuildFlags are the build type, compiler, and compiler flags the C++ library was built with.
+	BuildFlags string
+	// GoRevision is the VCS revision the Go binary was built from, if it was built in a checkout.
+	GoRevision string `json:",omitempty"`
+	// GoVersion is the Go version the binary was built with.
+	GoVersion string
+}
+
+// Version returns the version of the C++ library and the Go binary.
+//
+// Since the C++ library is prebuilt, its commit can differ from the Go revision.
+func Version() VersionInfo {
+	result := VersionInfo{
+		Commit:     libraryCommit(),
+		BuildFlags: libraryBuildFlags(),
+		GoVersion:  runtime.Version(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				result.GoRevision = setting.Value + result.GoRevision
+			case "vcs.modified":
+				if setting.Value == "true" {
+					result.GoRevision += "-dirty"
+				}
+			}
+		}
+	}
+	return result
+}
+
+// String returns a one line description of the version.
+func (v VersionInfo) String() string {
+	result := fmt.Sprintf("Zimtohrli %s (%s)", v.Commit, v.BuildFlags)
+	if v.GoRevision != "" {
+		result += fmt.Sprintf(", Go revision %s", v.GoRevision)
+	}
+	return result + fmt.Sprintf(", %s", v.GoVersion)
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging contains leveled logging, and flags to select the level of the messages the binaries log.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Level is the severity of a logged message.
+type Level int32
+
+const (
+	// Info is the level of messages about the progress of the work.
+	Info Level = iota
+	// Warning is the level of messages about unexpected conditions that don't stop the work.
+	Warning
+	// Error is the level of messages about failures, which are always logged, e.g. by log.Fatal.
+	Error
+)
+
+var levelNames = map[Level]string{
+	Info:    "info",
+	Warning: "warning",
+	Error:   "error",
+}
+
+func (l Level) String() string {
+	if name, found := levelNames[l]; found {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// Set sets the level to the level with the name, and implements flag.Value.
+func (l *Level) Set(name string) error {
+	for level, levelName := range levelNames {
+		if levelName == name {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, want one of info, warning, or error", name)
+}
+
+// minLevel is the level of the least severe messages logged.
+var minLevel atomic.Int32
+
+// SetLevel makes only messages of the level and more severe levels logged.
+func SetLevel(level Level) {
+	minLevel.Store(int32(level))
+}
+
+// Enabled returns whether messages of the level are logged.
+func Enabled(level Level) bool {
+	return int32(level) >= minLevel.Load()
+}
+
+// Infof logs a message of the Info level with the default logger.
+func Infof(format string, args ...any) {
+	if Enabled(Info) {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
+// Warningf logs a message of the Warning level, prefixed with "Warning: ", with the default logger.
+func Warningf(format string, args ...any) {
+	if Enabled(Warning) {
+		log.Output(2, "Warning: "+fmt.Sprintf(format, args...))
+	}
+}
+
+// Options defines which messages to log.
+type Options struct {
+	// Level is the level of the least severe messages logged.
+	Level Level
+	// Quiet, if set, logs only errors, like Level Error.
+	Quiet bool
+}
+
+// Flags returns options configured by the -log_level and -quiet flags, defined in the default flag set.
+func Flags() *Options {
+	result := &Options{}
+	flag.Var(&result.Level, "log_level", "Level of the least severe messages logged to stderr, one of info, warning, or error. Progress is shown at the info level. Results are printed to stdout at all levels.")
+	flag.BoolVar(&result.Quiet, "quiet", false, "Whether to log only errors, and not show progress, like -log_level error, so that only results and errors are printed.")
+	return result
+}
+
+// Apply makes the configured messages logged.
+func (o *Options) Apply() {
+	if o.Quiet {
+		SetLevel(Error)
+	} else {
+		SetLevel(o.Level)
+	}
+}
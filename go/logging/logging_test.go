@@ -0,0 +1,59 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(Info)
+	for _, tc := range []struct {
+		opts        Options
+		wantInfo    bool
+		wantWarning bool
+	}{
+		{opts: Options{Level: Info}, wantInfo: true, wantWarning: true},
+		{opts: Options{Level: Warning}, wantInfo: false, wantWarning: true},
+		{opts: Options{Level: Error}, wantInfo: false, wantWarning: false},
+		{opts: Options{Level: Info, Quiet: true}, wantInfo: false, wantWarning: false},
+	} {
+		buf.Reset()
+		tc.opts.Apply()
+		Infof("info %v", 1)
+		Warningf("warning %v", 2)
+		if got := strings.Contains(buf.String(), "info 1"); got != tc.wantInfo {
+			t.Errorf("%+v: logged info = %v, want %v", tc.opts, got, tc.wantInfo)
+		}
+		if got := strings.Contains(buf.String(), "Warning: warning 2"); got != tc.wantWarning {
+			t.Errorf("%+v: logged warning = %v, want %v", tc.opts, got, tc.wantWarning)
+		}
+	}
+
+	level := Info
+	if err := level.Set("warning"); err != nil || level != Warning {
+		t.Errorf("Set(warning) = %v, level %v, want %v", err, level, Warning)
+	}
+	if err := level.Set("debug"); err == nil {
+		t.Errorf("Set(debug) returned no error")
+	}
+}
@@ -0,0 +1,80 @@
+#!/usr/bin/env python3
+# Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Pipe metric computing the word error rate of Whisper transcriptions.
+
+Implements the pipe protocol of the Go package
+github.com/google/zimtohrli/go/pipe for metrics needing transcripts: the
+distortion is transcribed with Whisper, and the word error rate against the
+transcript of the reference is returned as the WER score type.
+
+Requires `pip install openai-whisper`. The model is selected with the
+WHISPER_MODEL environment variable, and defaults to "base.en".
+
+Usage: score -calculate 'studies/*' -calculate_pipe go/pipe/asr_wer.py
+           -transcript_file transcripts.json
+"""
+
+import json
+import os
+import re
+import sys
+
+import whisper
+
+
+def normalize(text: str) -> list[str]:
+    """Returns the lowercase words of the text without punctuation."""
+    return re.sub(r"[^\w\s']", " ", text.lower()).split()
+
+
+def word_error_rate(reference: str, hypothesis: str) -> float:
+    """Returns the word level Levenshtein distance divided by the number of reference words."""
+    ref_words, hyp_words = normalize(reference), normalize(hypothesis)
+    if not ref_words:
+        return 0.0 if not hyp_words else 1.0
+    previous = list(range(len(hyp_words) + 1))
+    for i, ref_word in enumerate(ref_words, 1):
+        current = [i]
+        for j, hyp_word in enumerate(hyp_words, 1):
+            current.append(
+                min(
+                    previous[j] + 1,
+                    current[j - 1] + 1,
+                    previous[j - 1] + (ref_word != hyp_word),
+                )
+            )
+        previous = current
+    return previous[-1] / len(ref_words)
+
+
+def main():
+    model = whisper.load_model(os.environ.get("WHISPER_MODEL", "base.en"))
+    print("READY:WER TRANSCRIPT", flush=True)
+    while True:
+        print("REF", flush=True)
+        ref_path = sys.stdin.readline()
+        if not ref_path:
+            return
+        print("DIST", flush=True)
+        dist_path = sys.stdin.readline().strip()
+        print("TRANSCRIPT", flush=True)
+        transcript = json.loads(sys.stdin.readline())
+        hypothesis = model.transcribe(dist_path, fp16=False)["text"]
+        print(f"SCORE={word_error_rate(transcript, hypothesis)}", flush=True)
+
+
+if __name__ == "__main__":
+    main()
@@ -13,11 +13,27 @@
 // limitations under the License.
 
 // Package pipe manages services communicating via pipes.
+//
+// A metric process prints "READY:<score type>" when it's ready, optionally followed by space separated capabilities:
+//
+//   - "TRANSCRIPT" if it needs the transcript of the reference.
+//   - "BETTER=higher" or "BETTER=lower", the direction of its scores.
+//   - "RANGE=<min>,<max>", the range of its valid scores.
+//
+// The direction and range form the profile of the metric, see data.MetricProfile, like in
+// "READY:PESQ BETTER=higher RANGE=0.999,4.999", and a range needs a direction. Wrappers of metrics with built-in
+// profiles, like PESQ and POLQA, may announce only their score type, but announced profiles must match the built-in
+// ones. Scores outside the range fail the measurement.
+//
+// For each measurement the process then prints "REF" and reads the path to a WAV file with the reference, prints
+// "DIST" and reads the path to a WAV file with the distortion, and, if it needs the transcript, prints "TRANSCRIPT"
+// and reads the transcript as a JSON string. Finally it prints "SCORE=<score>".
 package pipe
 
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -36,6 +52,11 @@ type MeterPool struct {
 	*resource.Pool[*Metric]
 
 	ScoreType data.ScoreType
+	// NeedsTranscript is whether the metric needs the transcript of the reference, and must be measured with
+	// MeasureTranscript.
+	NeedsTranscript bool
+	// Profile is the profile the metric announced, or nil if it didn't announce one.
+	Profile *data.MetricProfile
 }
 
 // NewMeterPool returns a new pool of pipe-communicating processes.
@@ -50,8 +71,17 @@ func NewMeterPool(path string) (*MeterPool, error) {
 		return nil, err
 	}
 	defer result.Pool.Return(metric)
-	result.ScoreType, err = metric.ScoreType()
-	return result, err
+	if result.ScoreType, err = metric.ScoreType(); err != nil {
+		return nil, err
+	}
+	result.NeedsTranscript = metric.needsTranscript
+	if metric.profile != nil {
+		if err := data.RegisterMetricProfile(*metric.profile); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		result.Profile = metric.profile
+	}
+	return result, nil
 }
 
 // Close closes all the processes in the pool.
@@ -73,13 +103,30 @@ func (m *MeterPool) Measure(ref, dist *audio.Audio) (float64, error) {
 	return result, nil
 }
 
+// MeasureTranscript returns the score of dist given ref and the transcript of ref using a metric in the pool, and
+// then returns it to the pool.
+func (m *MeterPool) MeasureTranscript(transcript string, ref, dist *audio.Audio) (float64, error) {
+	metric, err := m.Pool.Get()
+	if err != nil {
+		return 0, err
+	}
+	result, err := metric.MeasureTranscript(transcript, ref, dist)
+	if err != nil {
+		return 0, err
+	}
+	m.Pool.Return(metric)
+	return result, nil
+}
+
 // Metric wraps a pipe-communicating process.
 type Metric struct {
-	scoreType data.ScoreType
-	stdin     io.WriteCloser
-	stdout    *bufio.Reader
-	stderr    *bytes.Buffer
-	nextLine  string
+	scoreType       data.ScoreType
+	needsTranscript bool
+	profile         *data.MetricProfile
+	stdin           io.WriteCloser
+	stdout          *bufio.Reader
+	stderr          *bytes.Buffer
+	nextLine        string
 }
 
 // StartMetric starts a new pipe-communicating process.
@@ -116,11 +163,52 @@ func (m *Metric) awaitReady() error {
 	if err != nil {
 		return fmt.Errorf("waiting for READY: %v\n%s", err, m.stderr)
 	}
-	scoreType, found := strings.CutPrefix(strings.TrimSpace(m.nextLine), "READY:")
+	ready, found := strings.CutPrefix(strings.TrimSpace(m.nextLine), "READY:")
 	if !found {
 		return fmt.Errorf("%q doesn't have the prefix 'READY:'", m.nextLine)
 	}
-	m.scoreType = data.ScoreType(scoreType)
+	scoreType, capabilities, _ := strings.Cut(ready, " ")
+	profile := data.MetricProfile{ScoreType: data.ScoreType(scoreType)}
+	hasBetter, hasRange := false, false
+	for _, capability := range strings.Fields(capabilities) {
+		name, value, _ := strings.Cut(capability, "=")
+		switch name {
+		case "TRANSCRIPT":
+			m.needsTranscript = true
+		case "BETTER":
+			switch value {
+			case "higher":
+				profile.Better = 1
+			case "lower":
+				profile.Better = -1
+			default:
+				return fmt.Errorf("%q has invalid direction %q, want higher or lower", m.nextLine, value)
+			}
+			hasBetter = true
+		case "RANGE":
+			minString, maxString, found := strings.Cut(value, ",")
+			if !found {
+				return fmt.Errorf("%q has invalid range %q, want <min>,<max>", m.nextLine, value)
+			}
+			var err error
+			if profile.Min, err = strconv.ParseFloat(minString, 64); err != nil {
+				return fmt.Errorf("%q has invalid range %q: %v", m.nextLine, value, err)
+			}
+			if profile.Max, err = strconv.ParseFloat(maxString, 64); err != nil {
+				return fmt.Errorf("%q has invalid range %q: %v", m.nextLine, value, err)
+			}
+			hasRange = true
+		default:
+			return fmt.Errorf("%q has unknown capability %q", m.nextLine, capability)
+		}
+	}
+	if hasRange && !hasBetter {
+		return fmt.Errorf("%q announces a range without a direction, want BETTER with RANGE", m.nextLine)
+	}
+	if hasBetter {
+		m.profile = &profile
+	}
+	m.scoreType = profile.ScoreType
 	return nil
 }
 
@@ -142,11 +230,36 @@ func (m *Metric) await(msg string) error {
 	return nil
 }
 
+// NeedsTranscript waits for the process to emit it's score type and returns whether it needs the transcript of the
+// reference.
+func (m *Metric) NeedsTranscript() (bool, error) {
+	if err := m.awaitReady(); err != nil {
+		return false, err
+	}
+	return m.needsTranscript, nil
+}
+
 // Measure waits until the process has emitted it's score type (which signals that it's ready) and returns the score for the provided ref and dist.
 func (m *Metric) Measure(ref, dist *audio.Audio) (float64, error) {
 	if err := m.awaitReady(); err != nil {
 		return 0, err
 	}
+	if m.needsTranscript {
+		return 0, fmt.Errorf("%v metric needs the transcript of the reference", m.scoreType)
+	}
+	return m.measure("", ref, dist)
+}
+
+// MeasureTranscript waits until the process has emitted it's score type and returns the score for the provided ref
+// and dist, giving the process the transcript of ref if it needs it.
+func (m *Metric) MeasureTranscript(transcript string, ref, dist *audio.Audio) (float64, error) {
+	if err := m.awaitReady(); err != nil {
+		return 0, err
+	}
+	return m.measure(transcript, ref, dist)
+}
+
+func (m *Metric) measure(transcript string, ref, dist *audio.Audio) (float64, error) {
 	refPath, err := aio.DumpWAV(ref)
 	if err != nil {
 		return 0, fmt.Errorf("dumping referenc audio: %v", err)
@@ -169,6 +282,18 @@ func (m *Metric) Measure(ref, dist *audio.Audio) (float64, error) {
 	if _, err := fmt.Fprintln(m.stdin, distPath); err != nil {
 		return 0, fmt.Errorf("printing dist path: %v\n%s", err, m.stderr)
 	}
+	if m.needsTranscript {
+		if err := m.await("TRANSCRIPT"); err != nil {
+			return 0, err
+		}
+		b, err := json.Marshal(transcript)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := fmt.Fprintln(m.stdin, string(b)); err != nil {
+			return 0, fmt.Errorf("printing transcript: %v\n%s", err, m.stderr)
+		}
+	}
 	for ; err == nil && !strings.HasPrefix(m.nextLine, "SCORE="); m.nextLine, err = m.stdout.ReadString('\n') {
 	}
 	if err != nil {
@@ -0,0 +1,57 @@
+#!/usr/bin/env python3
+# Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Pipe metric computing wideband PESQ MOS-LQO.
+
+Implements the pipe protocol of the Go package
+github.com/google/zimtohrli/go/pipe, announcing the PESQ profile. The audio is
+mixed to mono and resampled to the 16 kHz PESQ operates at.
+
+Requires `pip install pesq soundfile scipy`.
+
+Usage: score -calculate 'studies/*' -calculate_pipe go/pipe/pesq_wrapper.py
+"""
+
+import sys
+
+import numpy as np
+import pesq
+import scipy.signal
+import soundfile
+
+PESQ_RATE = 16000
+
+
+def load(path: str) -> np.ndarray:
+    """Returns the audio in the file as mono samples at PESQ_RATE."""
+    samples, rate = soundfile.read(path, always_2d=True)
+    return scipy.signal.resample_poly(samples.mean(axis=1), PESQ_RATE, rate)
+
+
+def main():
+    print("READY:PESQ BETTER=higher RANGE=0.999,4.999", flush=True)
+    while True:
+        print("REF", flush=True)
+        ref_path = sys.stdin.readline()
+        if not ref_path:
+            return
+        print("DIST", flush=True)
+        dist_path = sys.stdin.readline()
+        score = pesq.pesq(PESQ_RATE, load(ref_path.strip()), load(dist_path.strip()), "wb")
+        print(f"SCORE={score}", flush=True)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,97 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package profile contains flags to write pprof profiles and execution traces of the binaries.
+package profile
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// Profile defines which profiles to write.
+type Profile struct {
+	// CPUProfile, if set, is the file to write a CPU profile to.
+	CPUProfile string
+	// MemProfile, if set, is the file to write a heap profile to when stopping.
+	MemProfile string
+	// Trace, if set, is the file to write an execution trace to.
+	Trace string
+}
+
+// Flags returns a profile configured by the -cpuprofile, -memprofile, and -trace flags, defined in the default flag set.
+func Flags() *Profile {
+	result := &Profile{}
+	flag.StringVar(&result.CPUProfile, "cpuprofile", "", "File to write a pprof CPU profile to.")
+	flag.StringVar(&result.MemProfile, "memprofile", "", "File to write a pprof heap profile to when done.")
+	flag.StringVar(&result.Trace, "trace", "", "File to write a runtime execution trace to.")
+	return result
+}
+
+// Start starts the configured profiles, and returns a function that stops them and writes them to their files.
+func (p *Profile) Start() (func() error, error) {
+	stops := []func() error{}
+	stop := func() error {
+		errs := []error{}
+		for index := len(stops) - 1; index >= 0; index-- {
+			errs = append(errs, stops[index]())
+		}
+		return errors.Join(errs...)
+	}
+	if p.CPUProfile != "" {
+		f, err := os.Create(p.CPUProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("starting CPU profile: %v", err)
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+	if p.Trace != "" {
+		f, err := os.Create(p.Trace)
+		if err != nil {
+			return nil, errors.Join(err, stop())
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			return nil, errors.Join(fmt.Errorf("starting trace: %v", err), stop())
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+	if p.MemProfile != "" {
+		stops = append(stops, func() error {
+			f, err := os.Create(p.MemProfile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			runtime.GC()
+			return pprof.WriteHeapProfile(f)
+		})
+	}
+	return stop, nil
+}
@@ -18,34 +18,13 @@ package progress
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"math"
 	"os"
 	"sync"
-	"syscall"
 	"time"
-	"unsafe"
-)
-
-type winsize struct {
-	Row    uint16
-	Col    uint16
-	Xpixel uint16
-	Ypixel uint16
-}
 
-func getTerminalWidth() (int, error) {
-	ws := &winsize{}
-	retCode, _, errno := syscall.Syscall(syscall.SYS_IOCTL,
-		uintptr(syscall.Stdin),
-		uintptr(syscall.TIOCGWINSZ),
-		uintptr(unsafe.Pointer(ws)))
-
-	if int(retCode) == -1 {
-		return 0, fmt.Errorf("Syscall returned %v", errno)
-	}
-	return int(ws.Col), nil
-}
+	"github.com/google/zimtohrli/go/logging"
+)
 
 // New returns a new progress bar.
 func New(name string) *Bar {
@@ -77,6 +56,9 @@ func (b *Bar) AddCompleted(num int) {
 
 // Finish prints the final actual time of completion and a newline.
 func (b *Bar) Finish() {
+	if !logging.Enabled(logging.Info) {
+		return
+	}
 	prefix := fmt.Sprintf("%s, %d/%d/%d ", b.name, b.completed, b.errors, b.total)
 	atc := time.Since(b.created)
 	speed := float64(b.completed) / float64(atc)
@@ -92,7 +74,7 @@ func (b *Bar) Finish() {
 func (b *Bar) filler(prefix, suffix string) string {
 	width, err := getTerminalWidth()
 	if err != nil {
-		log.Println(err)
+		logging.Warningf("%v", err)
 		return ""
 	}
 	numFiller := width - len(prefix) - len(suffix)
@@ -146,6 +128,9 @@ func (b *Bar) Update(total, completed, errors int) {
 	b.errors = errors
 	b.total = total
 	b.lastRender = now
+	if !logging.Enabled(logging.Info) {
+		return
+	}
 
 	os.Stderr.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
 	fmt.Fprintf(os.Stderr, "\r%s%s%s", prefix, b.filler(prefix, suffix), suffix)
@@ -0,0 +1,194 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// NewStages returns a new progress display of a pipeline with the named stages, in order.
+func NewStages(name string, stages ...string) *Stages {
+	result := &Stages{
+		name:    name,
+		created: time.Now(),
+		out:     os.Stderr,
+	}
+	for _, stage := range stages {
+		result.stage(stage)
+	}
+	return result
+}
+
+// Stages contains state for a progress display of a pipeline, e.g. decode, analyze, compare, and persist, with
+// independent counters for each stage.
+//
+// The stage with the most pending tasks, i.e. submitted but neither completed nor failed, is marked with a *,
+// since it's the bottleneck where tasks queue up.
+//
+// Nothing is printed unless messages of the logging.Info level are logged.
+//
+// All methods are safe to call concurrently, and on a nil *Stages, where they do nothing.
+type Stages struct {
+	name    string
+	created time.Time
+	out     io.Writer
+	stages  []*stage
+	// rendered is the length of the last rendered line, which shorter lines are padded to.
+	rendered int
+	lock     sync.Mutex
+}
+
+// stage contains the counters of a stage.
+type stage struct {
+	name      string
+	total     int
+	completed int
+	errors    int
+	// done is when the last task of the stage was completed or failed.
+	done time.Time
+}
+
+func (s *stage) pending() int {
+	return s.total - s.completed - s.errors
+}
+
+func (s *stage) String() string {
+	return fmt.Sprintf("%s %d/%d/%d", s.name, s.completed, s.errors, s.total)
+}
+
+// stage returns the stage with the name, which is appended if it doesn't exist.
+//
+// Must be called with the lock held.
+func (s *Stages) stage(name string) *stage {
+	for _, st := range s.stages {
+		if st.name == name {
+			return st
+		}
+	}
+	st := &stage{name: name}
+	s.stages = append(s.stages, st)
+	return st
+}
+
+// Update sets the number of submitted, completed, and failed tasks of the named stage, and renders the stages.
+func (s *Stages) Update(name string, total, completed, errors int) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.update(s.stage(name), total, completed, errors)
+}
+
+// update sets the counters of the stage and renders the stages.
+//
+// Must be called with the lock held.
+func (s *Stages) update(st *stage, total, completed, errors int) {
+	if completed+errors > st.completed+st.errors {
+		st.done = time.Now()
+	}
+	st.total, st.completed, st.errors = total, completed, errors
+	s.render()
+}
+
+// Add adds to the number of submitted, completed, and failed tasks of the named stage, and renders the stages.
+func (s *Stages) Add(name string, total, completed, errors int) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st := s.stage(name)
+	s.update(st, st.total+total, st.completed+completed, st.errors+errors)
+}
+
+// Bottleneck returns the name of the stage with the most pending tasks, or an empty string if no tasks are pending.
+func (s *Stages) Bottleneck() string {
+	if s == nil {
+		return ""
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.bottleneck()
+}
+
+func (s *Stages) bottleneck() string {
+	result, pending := "", 0
+	for _, st := range s.stages {
+		if st.pending() > pending {
+			result, pending = st.name, st.pending()
+		}
+	}
+	return result
+}
+
+// render prints the counters of the stages.
+//
+// Must be called with the lock held.
+func (s *Stages) render() {
+	bottleneck := s.bottleneck()
+	parts := []string{}
+	for _, st := range s.stages {
+		part := st.String()
+		if st.name == bottleneck {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	if f, ok := s.out.(*os.File); ok {
+		f.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	}
+	s.print(fmt.Sprintf("%s, %s %s", s.name, strings.Join(parts, " "), time.Since(s.created).Round(time.Second)))
+}
+
+// print replaces the last rendered line with the line.
+//
+// Must be called with the lock held.
+func (s *Stages) print(line string) {
+	if !logging.Enabled(logging.Info) {
+		return
+	}
+	padding := strings.Repeat(" ", max(0, s.rendered-len(line)))
+	s.rendered = len(line)
+	fmt.Fprintf(s.out, "\r%s%s", line, padding)
+}
+
+// Finish prints the final counters of the stages, with the time each stage completed its last task, and a newline.
+func (s *Stages) Finish() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	parts := []string{}
+	for _, st := range s.stages {
+		part := st.String()
+		if !st.done.IsZero() {
+			part += fmt.Sprintf(" done at %s", st.done.Sub(s.created).Round(time.Millisecond))
+		}
+		parts = append(parts, part)
+	}
+	s.print(fmt.Sprintf("%s, %s ATC: %s", s.name, strings.Join(parts, ", "), time.Since(s.created).Round(time.Millisecond)))
+	if logging.Enabled(logging.Info) {
+		fmt.Fprintln(s.out)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStages(t *testing.T) {
+	out := &bytes.Buffer{}
+	stages := NewStages("Testing", "decode", "compare")
+	stages.out = out
+	if got := stages.Bottleneck(); got != "" {
+		t.Errorf("Bottleneck() = %q without pending tasks, want none", got)
+	}
+	stages.Add("decode", 10, 0, 0)
+	stages.Add("decode", 0, 8, 1)
+	stages.Add("compare", 8, 2, 0)
+	if got := stages.Bottleneck(); got != "compare" {
+		t.Errorf("Bottleneck() = %q, want compare with 6 pending tasks", got)
+	}
+	stages.Update("persist", 2, 0, 0)
+	if want := "\rTesting, decode 8/1/10 compare 2/0/8* persist 0/0/2 "; !strings.HasPrefix(out.String()[strings.LastIndex(out.String(), "\r"):], want) {
+		t.Errorf("rendered %q, want the last line to start with %q", out.String(), want)
+	}
+	out.Reset()
+	stages.Finish()
+	if got := out.String(); !strings.HasPrefix(got, "\rTesting, decode 8/1/10 done at ") || !strings.HasSuffix(got, "\n") {
+		t.Errorf("Finish() printed %q, want the final counters", got)
+	}
+
+	var none *Stages
+	none.Add("decode", 1, 1, 0)
+	none.Finish()
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package score
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/zimtohrli/go/logging"
+)
+
+// Archive is a file downloaded by Fetch.
+type Archive struct {
+	URL string
+	// SHA256, if set, is the hex encoded SHA-256 checksum of the file. Files without a known checksum are instead
+	// verified against the checksum recorded when they were first downloaded.
+	SHA256 string
+}
+
+// Dataset is a public dataset Fetch can download and import.
+type Dataset struct {
+	Description string
+	// Terms is where the license and terms of use of the dataset are described.
+	Terms string
+	// Archives are downloaded and unpacked into the source directory of the dataset. ZIP files are unpacked,
+	// and other files are copied as is.
+	Archives []Archive
+	// Importer is the binary, from go/bin, that creates a study from the source directory.
+	Importer string
+	// ImporterArgs are the arguments to Importer, where {source} is replaced with the source directory and
+	// {dest} with the study directory.
+	ImporterArgs []string
+}
+
+// Datasets contains the datasets Fetch supports, by name.
+var Datasets = map[string]Dataset{
+	"perceptual_audio": {
+		Description: "Perceptual audio dataset of just noticeable differences by Manocha et al.",
+		Terms:       "https://github.com/pranaymanocha/PerceptualAudio/blob/master/dataset/README.md",
+		Archives: []Archive{
+			{URL: "http://percepaudio.cs.princeton.edu/icassp2020_perceptual/audio_perception.zip"},
+		},
+		Importer:     "perceptual_audio",
+		ImporterArgs: []string{"-source", "{source}", "-dest", "{dest}"},
+	},
+	"coresvnet": {
+		Description: "Public listening test of audio codecs at coresv.net.",
+		Terms:       "https://listening-test.coresv.net/results.htm",
+		Importer:    "coresvnet",
+		// coresvnet downloads the audio itself.
+		ImporterArgs: []string{"-dest", "{dest}"},
+	},
+}
+
+// DatasetNames returns the names of the supported datasets, ordered by name.
+func DatasetNames() []string {
+	result := []string{}
+	for name := range Datasets {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Fetch downloads the named dataset into a directory named after it inside dir, verifies the checksums of the
+// downloads, unpacks them, and runs the importer of the dataset, which has to be installed in $PATH.
+//
+// Downloads, unpacked files, and the study are kept, and aren't fetched again when Fetch is run again.
+//
+// Returns the directory of the created study.
+func Fetch(name string, dir string) (string, error) {
+	dataset, found := Datasets[name]
+	if !found {
+		return "", fmt.Errorf("unknown dataset %q, want one of %v", name, DatasetNames())
+	}
+	importer, err := exec.LookPath(dataset.Importer)
+	if err != nil {
+		return "", fmt.Errorf("%v, install it with 'go install github.com/google/zimtohrli/go/bin/%s'", err, dataset.Importer)
+	}
+	datasetDir := filepath.Join(dir, name)
+	downloadDir := filepath.Join(datasetDir, "downloads")
+	sourceDir := filepath.Join(datasetDir, "source")
+	studyDir := filepath.Join(datasetDir, "study")
+	if _, err := os.Stat(filepath.Join(studyDir, "db.sqlite3")); err == nil {
+		logging.Infof("%q already has a study, not fetching %v again", studyDir, name)
+		return studyDir, nil
+	}
+	logging.Infof("Fetching %v, see %v for its license and terms of use", name, dataset.Terms)
+	for _, archive := range dataset.Archives {
+		archivePath, err := download(archive, downloadDir)
+		if err != nil {
+			return "", err
+		}
+		if err := unpack(archivePath, sourceDir); err != nil {
+			return "", err
+		}
+	}
+	args := []string{}
+	for _, arg := range dataset.ImporterArgs {
+		args = append(args, strings.NewReplacer("{source}", sourceDir, "{dest}", studyDir).Replace(arg))
+	}
+	cmd := exec.Command(importer, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %v %v: %v", importer, args, err)
+	}
+	return studyDir, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 checksum of the file.
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// download downloads the archive into dir, unless it already has been, verifies its checksum, and returns its path.
+func download(archive Archive, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	archivePath := filepath.Join(dir, path.Base(archive.URL))
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		logging.Infof("Downloading %v", archive.URL)
+		res, err := http.Get(archive.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetching %q: status code error: %d %s", archive.URL, res.StatusCode, res.Status)
+		}
+		tmpFile, err := os.CreateTemp(dir, "zimtohrli.go.score.Fetch.*.tmp")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tmpFile.Name())
+		if _, err := io.Copy(tmpFile, res.Body); err != nil {
+			tmpFile.Close()
+			return "", err
+		}
+		if err := tmpFile.Close(); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmpFile.Name(), archivePath); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	checksum, err := fileSHA256(archivePath)
+	if err != nil {
+		return "", err
+	}
+	want := archive.SHA256
+	checksumPath := archivePath + ".sha256"
+	if want == "" {
+		b, err := os.ReadFile(checksumPath)
+		if os.IsNotExist(err) {
+			logging.Infof("%v has no known checksum, recording %v", archive.URL, checksum)
+			return archivePath, os.WriteFile(checksumPath, []byte(checksum), 0644)
+		} else if err != nil {
+			return "", err
+		}
+		want = strings.TrimSpace(string(b))
+	}
+	if checksum != want {
+		return "", fmt.Errorf("%q has SHA-256 checksum %v, want %v; remove it to download it again", archivePath, checksum, want)
+	}
+	return archivePath, nil
+}
+
+// unpack unpacks the ZIP file at archivePath into dir, or copies other files into it, unless it already has been.
+func unpack(archivePath string, dir string) error {
+	donePath := filepath.Join(dir, "."+filepath.Base(archivePath)+".unpacked")
+	if _, err := os.Stat(donePath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(archivePath)) != ".zip" {
+		if err := copyFile(archivePath, filepath.Join(dir, filepath.Base(archivePath))); err != nil {
+			return err
+		}
+		return os.WriteFile(donePath, nil, 0644)
+	}
+	logging.Infof("Unpacking %q", archivePath)
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, file := range r.File {
+		destPath := filepath.Join(dir, file.Name)
+		if !strings.HasPrefix(destPath, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("%q in %q is outside the unpack directory", file.Name, archivePath)
+		}
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := unpackFile(file, destPath); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(donePath, nil, 0644)
+}
+
+// unpackFile writes the content of the file in a ZIP file to destPath.
+func unpackFile(file *zip.File, destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	in, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
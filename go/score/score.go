@@ -0,0 +1,1036 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package score calculates, analyzes, and optimizes for scores in listening test datasets.
+//
+// It contains the logic behind the score binary, for use by tools that want to drive studies programmatically.
+package score
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/baseline"
+	"github.com/google/zimtohrli/go/data"
+	"github.com/google/zimtohrli/go/goohrli"
+	"github.com/google/zimtohrli/go/logging"
+	"github.com/google/zimtohrli/go/pipe"
+	"github.com/google/zimtohrli/go/progress"
+	"github.com/google/zimtohrli/go/stoi"
+	"github.com/google/zimtohrli/go/worker"
+)
+
+// SampleRate is the sample rate study audio is measured at.
+const SampleRate = 48000
+
+// ErrNoMeasurements is returned when a Calculator is asked to calculate without any metrics enabled.
+var ErrNoMeasurements = errors.New("no metrics to calculate")
+
+// Calculator calculates scores for the distortions in studies.
+type Calculator struct {
+	// Zimtohrli makes the calculator calculate Zimtohrli scores.
+	Zimtohrli bool
+	// ZimtohrliScoreType is the score type Zimtohrli scores are stored as, data.Zimtohrli if empty.
+	ZimtohrliScoreType data.ScoreType
+	// ZimtohrliParameters are the Zimtohrli parameters used, with the sample rate replaced by SampleRate.
+	ZimtohrliParameters goohrli.Parameters
+	// ZimtohrliMOS makes the calculator calculate Zimtohrli distances mapped to mean opinion scores by MOSMapping,
+	// stored as data.ZimtohrliMOS scores.
+	ZimtohrliMOS bool
+	// MOSMapping is the mapping of ZimtohrliMOS scores, goohrli.DefaultMOSMapping if zero.
+	MOSMapping goohrli.MOSMapping
+	// AnalysisCache, if set, is a directory where Zimtohrli analyses are cached.
+	AnalysisCache string
+	// ResultCache, if set, is a directory where scores are cached, keyed by the measured audio and a description of
+	// the measurement, so that scores of unchanged pairs aren't measured again, e.g. in other studies or after
+	// removing them with Force.
+	ResultCache string
+	// ViSQOL makes the calculator calculate ViSQOL scores.
+	ViSQOL bool
+	// PipeMetric, if set, is the path to a binary serving a metric via stdin/stdout pipe.
+	PipeMetric string
+	// STOI makes the calculator calculate STOI scores.
+	STOI bool
+	// ESTOI makes the calculator calculate ESTOI scores.
+	ESTOI bool
+	// SNR, SISDR, and SpectralDistance make the calculator calculate the scores of the baseline metrics.
+	SNR              bool
+	SISDR            bool
+	SpectralDistance bool
+	// Confidence makes the calculator store the confidence in each comparison as an auxiliary score.
+	Confidence bool
+
+	// Preprocessing is applied to references and distortions before measuring them.
+	Preprocessing audio.Preprocessing
+	// LengthPolicy defines how references and distortions of different lengths are measured.
+	LengthPolicy goohrli.LengthPolicy
+	// ChannelPolicy defines how references and distortions with more than two channels are measured.
+	ChannelPolicy goohrli.ChannelPolicy
+	// Symmetry defines in which directions Zimtohrli distances are computed.
+	Symmetry goohrli.Symmetry
+	// VoiceActivity, if set, makes the calculator measure only the parts of references and distortions where the
+	// reference has voice activity, and store the means of the scores of the parts weighted by duration, except for
+	// metrics needing transcripts.
+	VoiceActivity *audio.VoiceActivity
+	// LevelCheck, if set, makes the calculator log warnings about clipped or near silent references and distortions
+	// when loading them for measuring.
+	LevelCheck *audio.LevelCheck
+	// FailOnWarnings makes the calculator return an error for studies with level warnings, without measuring the
+	// references and distortions with warnings.
+	FailOnWarnings bool
+	// Cues, if set, contains the segments to measure of distortions, keyed by the path or name of the distortion,
+	// see data.Distortion.Segments. Scores of distortions whose segments change are recalculated.
+	Cues map[string]audio.Segments
+	// Transcripts, if set, contains transcripts of references keyed by reference name, stored in the studies before
+	// calculating, for metrics needing the transcripts of the references.
+	Transcripts map[string]string
+
+	// Force makes the calculator recalculate scores that already exist.
+	Force bool
+	// Workers is the number of concurrent workers.
+	Workers int
+	// MaxMemory, if positive, is the max resident memory in bytes, see data.CalculateOptions.MaxMemory.
+	MaxMemory uint64
+	// MaxDistortionsPerReference, if positive, is the max number of distortions of the same reference processed
+	// concurrently, see data.CalculateOptions.MaxDistortionsPerReference.
+	MaxDistortionsPerReference int
+	// MultiReferencePolicy defines how scores against alternative references are combined, see
+	// data.CalculateOptions.MultiReferencePolicy.
+	MultiReferencePolicy data.MultiReferencePolicy
+	// MetricWorkers, if set, contains the number of concurrent workers for measurements of some score types,
+	// which then run independently of the Workers workers.
+	MetricWorkers map[data.ScoreType]int
+	// FailFast makes the calculator panic immediately on any error.
+	FailFast bool
+	// StallTimeout, if positive, makes the calculator log loads and measurements running for longer than it, see
+	// worker.Pool.StallTimeout.
+	StallTimeout time.Duration
+	// Progress makes the calculator show the progress of the data.DecodeStage, data.MeasureStage, and
+	// data.PersistStage stages for each study.
+	Progress bool
+	// Log, if set, gets one JSON line written per completed or failed measurement.
+	Log io.Writer
+	// Verbose makes the calculator log the time spent in each measurement, and the time Zimtohrli measurements
+	// spent analyzing and comparing in each study. Set aio.OnDecode to also log the time spent decoding.
+	Verbose bool
+	// KeepHistory makes the calculator append each calculated score to the history of its distortion.
+	KeepHistory bool
+	// Run, if set, is the name stored with the calculated scores in the history of their distortions.
+	Run string
+
+	logLock sync.Mutex
+	// timing is the time spent in the stages of Zimtohrli measurements of the current study, if Verbose is set.
+	timing     goohrli.Timing
+	timingLock sync.Mutex
+}
+
+// LoadCues returns the cues in a JSON file with an object mapping distortion paths or names to arrays of segments,
+// like {"dist.wav": [{"Start": 0.5, "End": 2}]}, for Calculator.Cues.
+func LoadCues(path string) (map[string]audio.Segments, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]audio.Segments{}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("trying to parse cues in %q: %v", path, err)
+	}
+	for name, segments := range result {
+		if err := segments.Validate(); err != nil {
+			return nil, fmt.Errorf("cues for %q in %q: %v", name, path, err)
+		}
+	}
+	return result, nil
+}
+
+// LoadTranscripts returns the transcripts in a JSON file with an object mapping reference names to transcripts,
+// like {"ref1": "the birch canoe slid on the smooth planks"}, for Calculator.Transcripts.
+func LoadTranscripts(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]string{}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("trying to parse transcripts in %q: %v", path, err)
+	}
+	return result, nil
+}
+
+// applyCues sets the segments of the distortions in the bundle with cues, and removes their scores of the
+// measured score types if their segments changed.
+func (c *Calculator) applyCues(bundle *data.ReferenceBundle, measurements map[data.ScoreType]data.Measurement) {
+	applied, changed := 0, 0
+	for _, ref := range bundle.References {
+		for _, dist := range ref.Distortions {
+			segments, found := c.Cues[dist.Path]
+			if !found {
+				if segments, found = c.Cues[dist.Name]; !found {
+					continue
+				}
+			}
+			applied++
+			if reflect.DeepEqual(dist.Segments, segments) {
+				continue
+			}
+			dist.Segments = segments
+			for scoreType := range measurements {
+				delete(dist.Scores, scoreType)
+			}
+			changed++
+		}
+	}
+	logging.Infof("Cues found for %v distortions in %v, %v with changed segments", applied, bundle.Dir, changed)
+}
+
+// zimtohrliParameters returns the Zimtohrli parameters used, with the sample rate replaced by SampleRate.
+func (c *Calculator) zimtohrliParameters() goohrli.Parameters {
+	params := c.ZimtohrliParameters
+	if params.SampleRate == 0 {
+		params = goohrli.DefaultParameters(SampleRate)
+	}
+	params.SampleRate = SampleRate
+	return params
+}
+
+// mosMapping returns the mapping of ZimtohrliMOS scores.
+func (c *Calculator) mosMapping() goohrli.MOSMapping {
+	if c.MOSMapping == (goohrli.MOSMapping{}) {
+		return goohrli.DefaultMOSMapping
+	}
+	return c.MOSMapping
+}
+
+// historyParameters returns the parameters to store with scores in the distortion histories.
+func (c *Calculator) historyParameters() map[data.ScoreType]string {
+	result := map[data.ScoreType]string{}
+	if c.Zimtohrli {
+		if b, err := json.Marshal(c.zimtohrliParameters()); err == nil {
+			scoreType := c.ZimtohrliScoreType
+			if scoreType == "" {
+				scoreType = data.Zimtohrli
+			}
+			result[scoreType] = string(b)
+		}
+	}
+	if c.ZimtohrliMOS {
+		if b, err := json.Marshal(struct {
+			Parameters goohrli.Parameters
+			MOSMapping goohrli.MOSMapping
+		}{c.zimtohrliParameters(), c.mosMapping()}); err == nil {
+			result[data.ZimtohrliMOS] = string(b)
+		}
+	}
+	return result
+}
+
+// historyVersions returns the versions to store with scores in the distortion histories.
+func (c *Calculator) historyVersions() map[data.ScoreType]string {
+	result := map[data.ScoreType]string{}
+	if c.Zimtohrli {
+		scoreType := c.ZimtohrliScoreType
+		if scoreType == "" {
+			scoreType = data.Zimtohrli
+		}
+		result[scoreType] = goohrli.Version().String()
+	}
+	if c.ZimtohrliMOS {
+		result[data.ZimtohrliMOS] = goohrli.Version().String()
+	}
+	return result
+}
+
+// Measurements returns the measurements the calculator is configured for, the measurements needing the transcripts
+// of the references, and a function to release their resources.
+func (c *Calculator) Measurements() (map[data.ScoreType]data.Measurement, map[data.ScoreType]data.TranscriptMeasurement, func() error, error) {
+	measurements := map[data.ScoreType]data.Measurement{}
+	transcriptMeasurements := map[data.ScoreType]data.TranscriptMeasurement{}
+	closer := func() error { return nil }
+	if c.Zimtohrli || c.ZimtohrliMOS {
+		params := c.zimtohrliParameters()
+		if !reflect.DeepEqual(params, goohrli.DefaultParameters(params.SampleRate)) {
+			logging.Infof("Using %+v", params)
+		}
+		z := goohrli.New(params)
+		z.Symmetry = c.Symmetry
+		if c.AnalysisCache != "" {
+			z.AnalysisCache = &goohrli.AnalysisCache{Dir: c.AnalysisCache}
+		}
+		if c.Verbose {
+			z.OnTiming = func(timing goohrli.Timing) {
+				c.timingLock.Lock()
+				defer c.timingLock.Unlock()
+				c.timing.Add(timing)
+			}
+		}
+		if c.Zimtohrli {
+			scoreType := c.ZimtohrliScoreType
+			if scoreType == "" {
+				scoreType = data.Zimtohrli
+			}
+			measurements[scoreType] = z.NormalizedAudioDistance
+		}
+		if c.ZimtohrliMOS {
+			// The analyses of the audio are cached by AnalysisCache, so the distance is only computed twice when
+			// both score types are calculated without it.
+			mapping := c.mosMapping()
+			measurements[data.ZimtohrliMOS] = func(reference, distortion *audio.Audio) (float64, error) {
+				distance, err := z.NormalizedAudioDistance(reference, distortion)
+				if err != nil {
+					return 0, err
+				}
+				return mapping.MOS(distance), nil
+			}
+		}
+	}
+	if c.ViSQOL {
+		v := goohrli.NewViSQOL()
+		measurements[data.ViSQOL] = v.AudioMOS
+	}
+	if c.STOI {
+		measurements[data.STOI] = stoi.STOI
+	}
+	if c.ESTOI {
+		measurements[data.ESTOI] = stoi.ESTOI
+	}
+	if c.SNR {
+		measurements[data.SNR] = baseline.SNR
+	}
+	if c.SISDR {
+		measurements[data.SISDR] = baseline.SISDR
+	}
+	if c.SpectralDistance {
+		measurements[data.SpectralDistance] = baseline.SpectralDistance
+	}
+	if c.Confidence {
+		measurements[data.Confidence] = goohrli.Confidence
+	}
+	if c.PipeMetric != "" {
+		pool, err := pipe.NewMeterPool(c.PipeMetric)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		closer = pool.Close
+		if pool.NeedsTranscript {
+			transcriptMeasurements[pool.ScoreType] = pool.MeasureTranscript
+		} else {
+			measurements[pool.ScoreType] = pool.Measure
+		}
+	}
+	if len(measurements) == 0 && len(transcriptMeasurements) == 0 {
+		return nil, nil, nil, ErrNoMeasurements
+	}
+	if c.VoiceActivity != nil {
+		activity := *c.VoiceActivity
+		for scoreType, measurement := range measurements {
+			measurement := measurement
+			measurements[scoreType] = func(reference, distortion *audio.Audio) (float64, error) {
+				return activity.Measure(reference, distortion, measurement)
+			}
+		}
+	}
+	policy, channelPolicy := c.LengthPolicy, c.ChannelPolicy
+	if (policy != "" && policy != goohrli.LengthWarp) || (channelPolicy != "" && channelPolicy != goohrli.ChannelsPerChannel) || c.Preprocessing.Enabled() {
+		prepare := func(reference, distortion *audio.Audio) (*audio.Audio, *audio.Audio, error) {
+			reference, err := channelPolicy.Apply(reference)
+			if err != nil {
+				return nil, nil, fmt.Errorf("reference: %v", err)
+			}
+			if distortion, err = channelPolicy.Apply(distortion); err != nil {
+				return nil, nil, fmt.Errorf("distortion: %v", err)
+			}
+			return policy.Apply(c.Preprocessing.Apply(reference), c.Preprocessing.Apply(distortion))
+		}
+		for scoreType, measurement := range measurements {
+			measurement := measurement
+			measurements[scoreType] = func(reference, distortion *audio.Audio) (float64, error) {
+				reference, distortion, err := prepare(reference, distortion)
+				if err != nil {
+					return 0, err
+				}
+				return measurement(reference, distortion)
+			}
+		}
+		for scoreType, measurement := range transcriptMeasurements {
+			measurement := measurement
+			transcriptMeasurements[scoreType] = func(transcript string, reference, distortion *audio.Audio) (float64, error) {
+				reference, distortion, err := prepare(reference, distortion)
+				if err != nil {
+					return 0, err
+				}
+				return measurement(transcript, reference, distortion)
+			}
+		}
+	}
+	if c.ResultCache != "" {
+		cache := &goohrli.ResultCache{Dir: c.ResultCache}
+		for scoreType, measurement := range measurements {
+			metric, err := c.resultMetric(scoreType)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			measurements[scoreType] = cache.Measurement(metric, measurement)
+		}
+	}
+	return measurements, transcriptMeasurements, closer, nil
+}
+
+// resultMetric returns the description of the measurement of the score type used as key in the result cache.
+//
+// It contains everything affecting the score except the measured audio, including the processing applied by the
+// calculator before measuring, since the cache sees the audio before that processing.
+func (c *Calculator) resultMetric(scoreType data.ScoreType) (string, error) {
+	b, err := json.Marshal(struct {
+		ScoreType     data.ScoreType
+		Parameters    string
+		Version       string
+		PipeMetric    string
+		Preprocessing audio.Preprocessing
+		LengthPolicy  goohrli.LengthPolicy
+		ChannelPolicy goohrli.ChannelPolicy
+		Symmetry      goohrli.Symmetry
+		VoiceActivity *audio.VoiceActivity
+	}{
+		ScoreType:     scoreType,
+		Parameters:    c.historyParameters()[scoreType],
+		Version:       goohrli.Version().String(),
+		PipeMetric:    c.PipeMetric,
+		Preprocessing: c.Preprocessing,
+		LengthPolicy:  c.LengthPolicy,
+		ChannelPolicy: c.ChannelPolicy,
+		Symmetry:      c.Symmetry,
+		VoiceActivity: c.VoiceActivity,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Calculate calculates scores for all studies in the directories matching the glob.
+func (c *Calculator) Calculate(glob string) error {
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return err
+	}
+	defer studies.Close()
+	measurements, transcriptMeasurements, closer, err := c.Measurements()
+	if err != nil {
+		return err
+	}
+	defer closer()
+	for _, study := range studies {
+		if err := c.calculate(study, measurements, transcriptMeasurements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CalculateStudy calculates scores for a study.
+func (c *Calculator) CalculateStudy(study *data.Study) error {
+	measurements, transcriptMeasurements, closer, err := c.Measurements()
+	if err != nil {
+		return err
+	}
+	defer closer()
+	return c.calculate(study, measurements, transcriptMeasurements)
+}
+
+func (c *Calculator) calculate(study *data.Study, measurements map[data.ScoreType]data.Measurement, transcriptMeasurements map[data.ScoreType]data.TranscriptMeasurement) error {
+	if err := study.Lock(); err != nil {
+		return err
+	}
+	defer study.Unlock()
+	sortedTypes := sort.StringSlice{}
+	for scoreType := range measurements {
+		sortedTypes = append(sortedTypes, string(scoreType))
+	}
+	for scoreType := range transcriptMeasurements {
+		sortedTypes = append(sortedTypes, string(scoreType))
+	}
+	sort.Sort(sortedTypes)
+	if len(c.Transcripts) > 0 {
+		stored, err := study.PutTranscripts(c.Transcripts)
+		if err != nil {
+			return err
+		}
+		logging.Infof("Stored %v transcripts in %v", stored, study.Dir())
+	}
+	var transcripts map[string]string
+	if len(transcriptMeasurements) > 0 {
+		var err error
+		if transcripts, err = study.Transcripts(); err != nil {
+			return err
+		}
+		logging.Infof("Found transcripts of %v references in %v, distortions of other references get no scores from metrics needing transcripts", len(transcripts), study.Dir())
+	}
+	bundle, err := study.ToBundle()
+	if err != nil {
+		return err
+	}
+	if len(c.Cues) > 0 {
+		c.applyCues(bundle, measurements)
+	}
+	// The levels are checked when the audio is loaded for measuring, to avoid decoding it twice.
+	var checkAudio func(*data.Reference, *data.Distortion, *audio.Audio) error
+	if c.LevelCheck != nil {
+		check := *c.LevelCheck
+		checkAudio = func(ref *data.Reference, dist *data.Distortion, a *audio.Audio) error {
+			warnings := data.CheckLevels(check, ref, dist, a)
+			for _, warning := range warnings {
+				logging.Warningf("%v", warning)
+			}
+			if c.FailOnWarnings && len(warnings) > 0 {
+				return fmt.Errorf("%v has %v level warnings", warnings[0].Path, len(warnings))
+			}
+			return nil
+		}
+	}
+	logging.Infof("*** Calculating %+v (force=%v) for %v", sortedTypes, c.Force, bundle.Dir)
+	pool := &worker.Pool[any]{
+		Workers:      c.Workers,
+		FailFast:     c.FailFast,
+		StallTimeout: c.StallTimeout,
+	}
+	metricPools := map[data.ScoreType]*worker.Pool[any]{}
+	for scoreType, workers := range c.MetricWorkers {
+		_, found := measurements[scoreType]
+		if _, transcriptFound := transcriptMeasurements[scoreType]; found || transcriptFound {
+			metricPools[scoreType] = &worker.Pool[any]{
+				Workers:      workers,
+				FailFast:     c.FailFast,
+				StallTimeout: c.StallTimeout,
+			}
+		}
+	}
+	var stages *progress.Stages
+	if c.Progress {
+		stages = progress.NewStages("Calculating", data.DecodeStage, data.MeasureStage, data.PersistStage)
+	}
+	var report func(data.MeasurementEvent)
+	if c.Log != nil || c.Verbose {
+		report = func(event data.MeasurementEvent) {
+			if c.Verbose && event.ScoreType != "" {
+				log.Printf("%s/%s: %v took %v", event.Reference, event.Distortion, event.ScoreType, event.Duration.Duration)
+			}
+			if c.Log == nil {
+				return
+			}
+			b, err := json.Marshal(event)
+			if err != nil {
+				log.Panic(err)
+			}
+			c.logLock.Lock()
+			defer c.logLock.Unlock()
+			if _, err := fmt.Fprintln(c.Log, string(b)); err != nil {
+				logging.Warningf("Writing measurement log: %v", err)
+			}
+		}
+	}
+	quarantined := data.QuarantinedScores{}
+	quarantineLock := sync.Mutex{}
+	// The scores of successful measurements and the quarantined scores are stored even if some measurements
+	// failed, so that they aren't calculated again.
+	calculateErr := bundle.CalculateWithOptions(measurements, pool, data.CalculateOptions{
+		Force:                      c.Force,
+		Report:                     report,
+		Pools:                      metricPools,
+		KeepHistory:                c.KeepHistory,
+		Run:                        c.Run,
+		Parameters:                 c.historyParameters(),
+		Versions:                   c.historyVersions(),
+		MaxMemory:                  c.MaxMemory,
+		MaxDistortionsPerReference: c.MaxDistortionsPerReference,
+		MultiReferencePolicy:       c.MultiReferencePolicy,
+		TranscriptMeasurements:     transcriptMeasurements,
+		Transcripts:                transcripts,
+		Progress:                   stages,
+		CheckAudio:                 checkAudio,
+		Quarantine: func(score data.QuarantinedScore) {
+			quarantineLock.Lock()
+			defer quarantineLock.Unlock()
+			quarantined = append(quarantined, score)
+		},
+	})
+	calculatedTypes := data.ScoreTypes{}
+	for _, scoreType := range sortedTypes {
+		calculatedTypes = append(calculatedTypes, data.ScoreType(scoreType))
+	}
+	// Only the scores of the calculated types are stored, instead of rewriting all scores of the study.
+	stages.Add(data.PersistStage, len(bundle.References), 0, 0)
+	if err := study.PutScores(bundle.References, calculatedTypes); err != nil {
+		stages.Add(data.PersistStage, 0, 0, len(bundle.References))
+		return err
+	}
+	stages.Add(data.PersistStage, 0, len(bundle.References), 0)
+	if len(quarantined) > 0 {
+		if err := study.Quarantine(quarantined); err != nil {
+			return err
+		}
+		logging.Warningf("Quarantined %v non-finite scores in %v, use -quarantined to list them", len(quarantined), bundle.Dir)
+	}
+	stages.Finish()
+	if c.Verbose && c.Zimtohrli {
+		c.timingLock.Lock()
+		log.Printf("Zimtohrli measurements in %v spent %v", bundle.Dir, c.timing)
+		c.timing = goohrli.Timing{}
+		c.timingLock.Unlock()
+	}
+	return calculateErr
+}
+
+// Analyze returns the named analyses of the studies in the directories matching the glob.
+func Analyze(glob string, analyses []string, opts data.AnalysisOptions) (string, error) {
+	selected, err := data.GetAnalyses(analyses...)
+	if err != nil {
+		return "", err
+	}
+	bundles, err := data.OpenBundles(glob)
+	if err != nil {
+		return "", err
+	}
+	return bundles.Analyze(selected, opts)
+}
+
+// Report returns a report with the named analyses of the studies in the directories matching the glob.
+func Report(glob string, analyses []string, opts data.AnalysisOptions) (string, error) {
+	selected, err := data.GetAnalyses(analyses...)
+	if err != nil {
+		return "", err
+	}
+	bundles, err := data.OpenBundles(glob)
+	if err != nil {
+		return "", err
+	}
+	return bundles.Report(selected, opts)
+}
+
+// Evaluate validates the studies in the directories matching the glob, calculates their missing Zimtohrli scores
+// with the calculator, and returns a report with the named analyses.
+//
+// The calculator is modified to calculate Zimtohrli scores, without recalculating existing ones, and to check the
+// levels of the audio unless it already has a level check. Validation fails for empty studies and studies with
+// missing audio files, before anything is calculated.
+func Evaluate(glob string, c *Calculator, analyses []string, opts data.AnalysisOptions) (string, error) {
+	if _, err := data.GetAnalyses(analyses...); err != nil {
+		return "", err
+	}
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return "", err
+	}
+	if err := func() error {
+		defer studies.Close()
+		for _, study := range studies {
+			summary, err := study.Summary()
+			if err != nil {
+				return err
+			}
+			if summary.Distortions == 0 {
+				return fmt.Errorf("%q contains no distortions", study.Dir())
+			}
+			if len(summary.Audio.Missing) > 0 {
+				return fmt.Errorf("%q is missing %v audio files, e.g. %q", study.Dir(), len(summary.Audio.Missing), summary.Audio.Missing[0])
+			}
+		}
+		return nil
+	}(); err != nil {
+		return "", err
+	}
+	c.Zimtohrli = true
+	c.Force = false
+	if c.LevelCheck == nil {
+		c.LevelCheck = &audio.DefaultLevelCheck
+	}
+	if err := c.Calculate(glob); err != nil {
+		return "", err
+	}
+	return Report(glob, analyses, opts)
+}
+
+// StudyQuarantine is the quarantine list of a study.
+type StudyQuarantine struct {
+	Dir    string
+	Scores data.QuarantinedScores
+}
+
+// Quarantined returns the quarantine lists of the studies in the directories matching the glob.
+func Quarantined(glob string) ([]*StudyQuarantine, error) {
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return nil, err
+	}
+	defer studies.Close()
+	result := []*StudyQuarantine{}
+	for _, study := range studies {
+		scores, err := study.Quarantined()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &StudyQuarantine{
+			Dir:    study.Dir(),
+			Scores: scores,
+		})
+	}
+	return result, nil
+}
+
+// Orphans returns the audio files in the study in the directory that no reference or distortion refers to. If
+// archive is set, they are moved to the same paths relative to the archive directory, and if remove is set, they
+// are deleted.
+func Orphans(dir string, remove bool, archive string) (data.OrphanedFiles, error) {
+	if remove && archive != "" {
+		return nil, fmt.Errorf("orphaned audio files can't be both deleted and archived")
+	}
+	if archive != "" {
+		// Archived files inside the study directory would be found as orphans again.
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		absArchive, err := filepath.Abs(archive)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(absDir, absArchive); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("archive %q is inside the study directory %q", archive, dir)
+		}
+	}
+	study, err := data.OpenStudy(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer study.Close()
+	if remove || archive != "" {
+		// The lock keeps concurrent imports from adding references to the files while they are removed.
+		if err := study.Lock(); err != nil {
+			return nil, err
+		}
+	}
+	orphans, err := study.OrphanedAudio()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case remove:
+		err = study.RemoveAudio(orphans)
+	case archive != "":
+		err = study.ArchiveAudio(orphans, archive)
+	}
+	return orphans, err
+}
+
+// Clone copies the entire content of the study in the source directory to a new study in the destination
+// directory, with all audio transcoded to the format using the number of workers, and shows the progress.
+func Clone(source, destination string, format data.TranscodeFormat, workers int) error {
+	if _, err := os.Stat(filepath.Join(destination, "db.sqlite3")); err == nil {
+		return fmt.Errorf("%q already contains a study", destination)
+	}
+	sourceStudy, err := data.OpenStudy(source)
+	if err != nil {
+		return err
+	}
+	defer sourceStudy.Close()
+	destinationStudy, err := data.OpenStudy(destination)
+	if err != nil {
+		return err
+	}
+	defer destinationStudy.Close()
+	if err := destinationStudy.Lock(); err != nil {
+		return err
+	}
+	stages := progress.NewStages("Cloning", data.TranscodeStage, data.ParseStage, data.PersistStage)
+	if err := sourceStudy.CloneTranscoded(destinationStudy, format, &worker.Pool[any]{Workers: workers}, stages); err != nil {
+		return err
+	}
+	stages.Finish()
+	return nil
+}
+
+// MakePathsRelative rewrites the absolute audio paths in the study in the directory that are inside the directory to
+// be relative to it.
+func MakePathsRelative(dir string) (*data.RelativePaths, error) {
+	study, err := data.OpenStudy(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return nil, err
+	}
+	return study.MakePathsRelative()
+}
+
+// Summary returns a summary of the study in the directory, which must already contain a study.
+func Summary(dir string) (*data.Summary, error) {
+	if _, err := os.Stat(filepath.Join(dir, "db.sqlite3")); err != nil {
+		return nil, fmt.Errorf("%q contains no study: %v", dir, err)
+	}
+	study, err := data.OpenStudy(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer study.Close()
+	return study.Summary()
+}
+
+// Details returns the contents of the studies in the directories matching the glob as indented JSON.
+func Details(glob string) ([]byte, error) {
+	bundles, err := data.OpenBundles(glob)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(bundles, "", "  ")
+}
+
+// Snapshot stores a snapshot with the name of each study in the directories matching the glob.
+func Snapshot(glob string, name string) error {
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return err
+	}
+	defer studies.Close()
+	for _, study := range studies {
+		if err := study.Snapshot(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback restores the snapshot with the name of each study in the directories matching the glob.
+func Rollback(glob string, name string) error {
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return err
+	}
+	defer studies.Close()
+	for _, study := range studies {
+		if err := study.Lock(); err != nil {
+			return err
+		}
+		if err := study.Rollback(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadStage is the stage of the progress of exports counting the studies read.
+const ReadStage = "read"
+
+// Export writes the contents of the studies in the directories matching the glob as JSON lines with standardized
+// columns to w, showing the progress of the ReadStage and data.ExportStage stages.
+func Export(glob string, w io.Writer) error {
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return err
+	}
+	defer studies.Close()
+	stages := progress.NewStages("Exporting", ReadStage, data.ExportStage)
+	bundles := make(data.ReferenceBundles, len(studies))
+	pool := &worker.Pool[any]{
+		Workers: runtime.NumCPU(),
+		OnChange: func(submitted, completed, errors int) {
+			stages.Update(ReadStage, submitted, completed, errors)
+		},
+	}
+	for loopIndex := range studies {
+		index := loopIndex
+		pool.SubmitDescribed(fmt.Sprintf("reading %v", studies[index].Dir()), func(func(any)) error {
+			var err error
+			bundles[index], err = studies[index].ToBundle()
+			return err
+		})
+	}
+	if err := pool.Error(); err != nil {
+		return err
+	}
+	if err := bundles.ExportJSONL(w, stages); err != nil {
+		return err
+	}
+	stages.Finish()
+	return nil
+}
+
+// Dump writes the entire content of the study in the directory as JSON lines to w, showing the progress of the
+// data.DumpStage stage.
+func Dump(dir string, w io.Writer) error {
+	study, err := data.OpenStudy(dir)
+	if err != nil {
+		return err
+	}
+	defer study.Close()
+	stages := progress.NewStages("Dumping", data.DumpStage)
+	if err := study.Dump(w, stages); err != nil {
+		return err
+	}
+	stages.Finish()
+	return nil
+}
+
+// Restore replaces the entire content of the study in the directory, which is created if necessary, with a dump
+// read from r, showing the progress of the data.ParseStage and data.PersistStage stages.
+func Restore(dir string, r io.Reader) error {
+	study, err := data.OpenStudy(dir)
+	if err != nil {
+		return err
+	}
+	defer study.Close()
+	if err := study.Lock(); err != nil {
+		return err
+	}
+	stages := progress.NewStages("Restoring", data.ParseStage, data.PersistStage)
+	if err := study.Restore(r, stages); err != nil {
+		return err
+	}
+	stages.Finish()
+	return nil
+}
+
+// Optimize optimizes the Zimtohrli parameters for the studies in the directories matching the glob
+// using simulated annealing seeded with seed, and appends the optimization events as JSON lines to logFile if it's set.
+func Optimize(glob string, seed int64, startStep, numSteps float64, logFile string) error {
+	bundles, err := data.OpenBundles(glob)
+	if err != nil {
+		return err
+	}
+	optimizeLog := func(ev data.OptimizationEvent) {}
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		optimizeLog = func(ev data.OptimizationEvent) {
+			b, err := json.Marshal(ev)
+			if err != nil {
+				logging.Warningf("while marshalling %+v: %v", ev, err)
+				return
+			}
+			f.WriteString(string(b) + "\n")
+			f.Sync()
+		}
+	}
+	return bundles.Optimize(seed, startStep, numSteps, optimizeLog)
+}
+
+// FitEnsemble fits an ensemble of the inputs to the MOS scores of the studies in the directories matching the
+// glob, stores its predictions as the score type in the distortions of the studies with all the inputs, and
+// returns the ensemble.
+//
+// The stored scores are predictions for the same distortions the ensemble was fitted to, so the ensemble analysis
+// should be used to evaluate how well ensembles generalize.
+func FitEnsemble(glob string, combiner data.Combiner, inputs data.ScoreTypes, scoreType data.ScoreType) (*data.Ensemble, error) {
+	if slices.Contains(inputs, scoreType) {
+		return nil, fmt.Errorf("ensemble score type %q can't be one of the inputs %v", scoreType, inputs)
+	}
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return nil, err
+	}
+	defer studies.Close()
+	for _, study := range studies {
+		if err := study.Lock(); err != nil {
+			return nil, err
+		}
+	}
+	bundles, err := studies.ToBundles()
+	if err != nil {
+		return nil, err
+	}
+	ensemble, err := bundles.FitEnsemble(combiner, inputs)
+	if err != nil {
+		return nil, err
+	}
+	for index, bundle := range bundles {
+		if bundle.StoreEnsemble(ensemble, scoreType) == 0 {
+			continue
+		}
+		if err := studies[index].Put(bundle.References); err != nil {
+			return nil, err
+		}
+		if err := studies[index].PutEnsemble(scoreType, ensemble); err != nil {
+			return nil, err
+		}
+	}
+	return ensemble, nil
+}
+
+// StudyDuplicates contains the duplicates found in a study.
+type StudyDuplicates struct {
+	Dir        string
+	Duplicates data.Duplicates
+	// Merged is the number of distortions removed by merging duplicates.
+	Merged int
+}
+
+// Dedup returns the duplicated references and distortions in the studies in the directories matching the glob.
+//
+// If threshold is positive, distortions of the same reference with a Zimtohrli distance at most threshold,
+// using params, are considered duplicates as well. If merge is set, duplicated distortions are merged and the studies updated.
+func Dedup(glob string, params goohrli.Parameters, threshold float64, merge bool, workers int) ([]*StudyDuplicates, error) {
+	studies, err := data.OpenStudies(glob)
+	if err != nil {
+		return nil, err
+	}
+	defer studies.Close()
+	var z *goohrli.Goohrli
+	if threshold > 0 {
+		params.SampleRate = SampleRate
+		z = goohrli.New(params)
+	}
+	result := []*StudyDuplicates{}
+	for _, study := range studies {
+		if merge {
+			if err := study.Lock(); err != nil {
+				return nil, err
+			}
+		}
+		bundle, err := study.ToBundle()
+		if err != nil {
+			return nil, err
+		}
+		dups, err := bundle.FindDuplicates(z, threshold, &worker.Pool[any]{Workers: workers})
+		if err != nil {
+			return nil, err
+		}
+		studyDups := &StudyDuplicates{
+			Dir:        bundle.Dir,
+			Duplicates: dups,
+		}
+		if merge && len(dups) > 0 {
+			studyDups.Merged = bundle.MergeDuplicates(dups)
+			if err := study.Put(bundle.References); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, studyDups)
+	}
+	return result, nil
+}
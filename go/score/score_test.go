@@ -0,0 +1,124 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package score
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/zimtohrli/go/audio"
+	"github.com/google/zimtohrli/go/data"
+	"github.com/google/zimtohrli/go/goohrli"
+)
+
+// scoredStudy returns the directory of a new study with 2 references of 3 distortions each, with MOS and
+// Zimtohrli scores where higher Zimtohrli distances mean lower MOS.
+func scoredStudy(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	study, err := data.OpenStudy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer study.Close()
+	refs := []*data.Reference{}
+	for refIndex := 0; refIndex < 2; refIndex++ {
+		ref := &data.Reference{Name: fmt.Sprintf("ref%v", refIndex), Path: fmt.Sprintf("ref%v.wav", refIndex)}
+		for distIndex := 0; distIndex < 3; distIndex++ {
+			ref.Distortions = append(ref.Distortions, &data.Distortion{
+				Name:   fmt.Sprintf("ref%v_dist%v", refIndex, distIndex),
+				Path:   fmt.Sprintf("ref%v_dist%v.wav", refIndex, distIndex),
+				Scores: map[data.ScoreType]float64{data.MOS: float64(5 - distIndex - refIndex), data.Zimtohrli: 0.01 * float64(distIndex+refIndex+1)},
+			})
+		}
+		refs = append(refs, ref)
+	}
+	if err := study.Put(refs); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCalculateWithoutMetrics(t *testing.T) {
+	if err := (&Calculator{}).Calculate(scoredStudy(t)); !errors.Is(err, ErrNoMeasurements) {
+		t.Errorf("calculating without metrics returned %v, want %v", err, ErrNoMeasurements)
+	}
+}
+
+func TestZimtohrliMOSMeasurement(t *testing.T) {
+	mapping := goohrli.MOSMapping{1, 0.5, 5}
+	measurements, _, closer, err := (&Calculator{Zimtohrli: true, ZimtohrliMOS: true, MOSMapping: mapping}).Measurements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+	signal := func(frequency float64) *audio.Audio {
+		samples := make([]float32, SampleRate/4)
+		for index := range samples {
+			samples[index] = float32(0.5 * math.Sin(2*math.Pi*frequency*float64(index)/SampleRate))
+		}
+		return &audio.Audio{Samples: [][]float32{samples}, Rate: SampleRate}
+	}
+	distance, err := measurements[data.Zimtohrli](signal(1000), signal(1100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mos, err := measurements[data.ZimtohrliMOS](signal(1000), signal(1100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance <= 0 {
+		t.Errorf("%v score = %v, want a positive distance", data.Zimtohrli, distance)
+	}
+	if want := mapping.MOS(distance); math.Abs(mos-want) > 1e-9 {
+		t.Errorf("%v score = %v, want %v", data.ZimtohrliMOS, mos, want)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	dir := scoredStudy(t)
+	opts := data.AnalysisOptions{Decimals: 2}
+	analysis, err := Analyze(dir, []string{"correlation"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(analysis, string(data.Zimtohrli)) {
+		t.Errorf("correlation analysis = %q, want a row for %v", analysis, data.Zimtohrli)
+	}
+	if _, err := Analyze(dir, []string{"no such analysis"}, opts); err == nil {
+		t.Errorf("analyzing with an unknown analysis returned no error")
+	}
+}
+
+func TestDetails(t *testing.T) {
+	b, err := Details(scoredStudy(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundles := data.ReferenceBundles{}
+	if err := json.Unmarshal(b, &bundles); err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles) != 1 || len(bundles[0].References) != 2 || len(bundles[0].References[1].Distortions) != 3 {
+		t.Fatalf("details = %s, want 1 study with 2 references of 3 distortions", b)
+	}
+	if got := bundles[0].References[1].Distortions[2].Scores[data.MOS]; got != 2 {
+		t.Errorf("MOS of ref1_dist2 = %v, want 2", got)
+	}
+}
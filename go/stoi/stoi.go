@@ -0,0 +1,316 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stoi implements the short-time objective intelligibility measure (STOI) by Taal et al., "An Algorithm for
+// Intelligibility Prediction of Time-Frequency Weighted Noisy Speech", and its extended version (ESTOI) by Jensen
+// and Taal, "An Algorithm for Predicting the Intelligibility of Speech Masked by Modulated Noise Maskers".
+//
+// The constants and the processing follow the reference implementation, but the resampling to 10 kHz is done with
+// a windowed sinc filter, and ESTOI normalizes without adding noise, so scores differ slightly from it.
+package stoi
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+const (
+	// sampleRate is the rate the signals are analyzed at.
+	sampleRate = 10000
+	// frameLen is the length of the analysis frames, which overlap by half.
+	frameLen = 256
+	// fftSize is the length of the zero padded frames.
+	fftSize = 512
+	// numBands is the number of one-third octave bands.
+	numBands = 15
+	// minFreq is the center frequency of the lowest band.
+	minFreq = 150
+	// segmentLen is the number of frames in the short-time segments that are correlated, about 384 ms.
+	segmentLen = 30
+	// beta is the lower signal-to-distortion bound in dB of the clipping of STOI.
+	beta = -15
+	// dynRange is the range in dB below the loudest frame of the reference where frames are considered silent.
+	dynRange = 40
+	// zeroCrossings is the number of zero crossings of the sinc filter on each side used when resampling.
+	zeroCrossings = 16
+)
+
+// eps avoids divisions by zero, like the machine epsilon in the reference implementation.
+var eps = math.Nextafter(1, 2) - 1
+
+// STOI returns the STOI of the distortion given the reference, between 0 and 1 with higher meaning more
+// intelligible.
+//
+// Channels are mixed to mono, and the longer signal is truncated to the length of the shorter.
+func STOI(reference, distortion *audio.Audio) (float64, error) {
+	return measure(reference, distortion, false)
+}
+
+// ESTOI returns the ESTOI of the distortion given the reference, with higher meaning more intelligible. Unlike STOI,
+// it accounts for the correlation across frequency bands, and handles modulated maskers better.
+//
+// Channels are mixed to mono, and the longer signal is truncated to the length of the shorter.
+func ESTOI(reference, distortion *audio.Audio) (float64, error) {
+	return measure(reference, distortion, true)
+}
+
+func measure(reference, distortion *audio.Audio, extended bool) (float64, error) {
+	if reference.Rate != distortion.Rate {
+		return 0, fmt.Errorf("reference rate %v != distortion rate %v", reference.Rate, distortion.Rate)
+	}
+	x, y := mono(reference), mono(distortion)
+	if len(x) > len(y) {
+		x = x[:len(y)]
+	} else {
+		y = y[:len(x)]
+	}
+	x, y = resample(x, reference.Rate), resample(y, reference.Rate)
+	x, y = removeSilentFrames(x, y)
+	xBands, yBands := thirdOctaveBands(x), thirdOctaveBands(y)
+	frames := len(xBands[0])
+	if frames < segmentLen {
+		return 0, fmt.Errorf("%v frames of speech, STOI needs at least %v, i.e. about %.0f ms without silence", frames, segmentLen, float64((segmentLen+1)*frameLen/2)/sampleRate*1000)
+	}
+	segments := frames - segmentLen + 1
+	sum := 0.0
+	for end := segmentLen; end <= frames; end++ {
+		xSegment, ySegment := make([][]float64, numBands), make([][]float64, numBands)
+		for band := range xSegment {
+			xSegment[band] = append([]float64{}, xBands[band][end-segmentLen:end]...)
+			ySegment[band] = append([]float64{}, yBands[band][end-segmentLen:end]...)
+		}
+		if extended {
+			sum += extendedCorrelation(xSegment, ySegment)
+		} else {
+			sum += clippedCorrelation(xSegment, ySegment)
+		}
+	}
+	if extended {
+		return sum / float64(segments), nil
+	}
+	return sum / float64(segments*numBands), nil
+}
+
+// clippedCorrelation returns the sum over bands of the correlations between the reference and the distortion
+// envelopes, after scaling the distortion to the energy of the reference and clipping it.
+func clippedCorrelation(xSegment, ySegment [][]float64) float64 {
+	clip := 1 + math.Pow(10, -beta/20.0)
+	result := 0.0
+	for band := range xSegment {
+		x, y := xSegment[band], ySegment[band]
+		scale := norm(x) / (norm(y) + eps)
+		for index := range y {
+			y[index] = math.Min(y[index]*scale, x[index]*clip)
+		}
+		normalize(x)
+		normalize(y)
+		result += dot(x, y)
+	}
+	return result
+}
+
+// extendedCorrelation returns the mean over frames of the correlations between the reference and the distortion
+// spectra, after normalizing the envelope of each band and then the spectrum of each frame.
+func extendedCorrelation(xSegment, ySegment [][]float64) float64 {
+	for band := range xSegment {
+		normalize(xSegment[band])
+		normalize(ySegment[band])
+	}
+	result := 0.0
+	x, y := make([]float64, numBands), make([]float64, numBands)
+	for frame := 0; frame < segmentLen; frame++ {
+		for band := range xSegment {
+			x[band], y[band] = xSegment[band][frame], ySegment[band][frame]
+		}
+		normalize(x)
+		normalize(y)
+		result += dot(x, y)
+	}
+	return result / segmentLen
+}
+
+// mono returns the mean of the channels of the audio.
+func mono(a *audio.Audio) []float64 {
+	if len(a.Samples) == 0 {
+		return nil
+	}
+	result := make([]float64, len(a.Samples[0]))
+	for _, channel := range a.Samples {
+		for index, sample := range channel {
+			result[index] += float64(sample)
+		}
+	}
+	for index := range result {
+		result[index] /= float64(len(a.Samples))
+	}
+	return result
+}
+
+// resample returns the signal resampled from the rate to sampleRate, with a Blackman windowed sinc lowpass filter at
+// the lower Nyquist frequency.
+func resample(signal []float64, rate float64) []float64 {
+	if rate == sampleRate {
+		return signal
+	}
+	ratio := sampleRate / rate
+	cutoff := math.Min(1, ratio)
+	halfWidth := int(math.Ceil(zeroCrossings / cutoff))
+	result := make([]float64, int(float64(len(signal))*ratio))
+	for index := range result {
+		center := float64(index) / ratio
+		first := max(int(math.Floor(center))-halfWidth+1, 0)
+		last := min(int(math.Floor(center))+halfWidth, len(signal)-1)
+		sum := 0.0
+		for tap := first; tap <= last; tap++ {
+			offset := center - float64(tap)
+			position := offset / float64(halfWidth)
+			window := 0.42 + 0.5*math.Cos(math.Pi*position) + 0.08*math.Cos(2*math.Pi*position)
+			sum += signal[tap] * cutoff * sinc(cutoff*offset) * window
+		}
+		result[index] = sum
+	}
+	return result
+}
+
+// sinc returns the normalized sinc function sin(πx)/(πx).
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// hann returns a Hann window of the length without its zero endpoints.
+func hann(length int) []float64 {
+	result := make([]float64, length)
+	for index := range result {
+		result[index] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(index+1)/float64(length+1))
+	}
+	return result
+}
+
+// frameStarts returns the starts of the half overlapping frames of a signal of the length.
+func frameStarts(length int) []int {
+	result := []int{}
+	for start := 0; start < length-frameLen; start += frameLen / 2 {
+		result = append(result, start)
+	}
+	return result
+}
+
+// removeSilentFrames returns the signals without the frames where the reference is more than dynRange below its
+// loudest frame, reassembled from the remaining windowed frames with overlap-add.
+func removeSilentFrames(x, y []float64) ([]float64, []float64) {
+	window := hann(frameLen)
+	starts := frameStarts(len(x))
+	energies := make([]float64, len(starts))
+	maxEnergy := math.Inf(-1)
+	for frame, start := range starts {
+		sum := 0.0
+		for index, weight := range window {
+			sample := weight * x[start+index]
+			sum += sample * sample
+		}
+		energies[frame] = 20 * math.Log10(math.Sqrt(sum)+eps)
+		maxEnergy = math.Max(maxEnergy, energies[frame])
+	}
+	xResult, yResult := []float64{}, []float64{}
+	kept := 0
+	for frame, start := range starts {
+		if maxEnergy-dynRange-energies[frame] >= 0 {
+			continue
+		}
+		offset := kept * frameLen / 2
+		for len(xResult) < offset+frameLen {
+			xResult = append(xResult, 0)
+			yResult = append(yResult, 0)
+		}
+		for index, weight := range window {
+			xResult[offset+index] += weight * x[start+index]
+			yResult[offset+index] += weight * y[start+index]
+		}
+		kept++
+	}
+	return xResult, yResult
+}
+
+// thirdOctaveBands returns the envelopes, i.e. the magnitudes per frame, of the one-third octave bands of the
+// signal.
+func thirdOctaveBands(signal []float64) [][]float64 {
+	window := hann(frameLen)
+	starts := frameStarts(len(signal))
+	result := make([][]float64, numBands)
+	for band := range result {
+		result[band] = make([]float64, len(starts))
+	}
+	// binFreq is the frequency of each FFT bin, and the edges of each band are rounded to the nearest bin.
+	binFreq := float64(sampleRate) / fftSize
+	nearestBin := func(freq float64) int {
+		return min(int(math.Round(freq/binFreq)), fftSize/2)
+	}
+	spectrum := make([]complex128, fftSize)
+	for frame, start := range starts {
+		for index := range spectrum {
+			spectrum[index] = 0
+		}
+		for index, weight := range window {
+			spectrum[index] = complex(weight*signal[start+index], 0)
+		}
+		audio.FFT(spectrum)
+		for band := range result {
+			low := nearestBin(minFreq * math.Pow(2, float64(2*band-1)/6))
+			high := nearestBin(minFreq * math.Pow(2, float64(2*band+1)/6))
+			power := 0.0
+			for bin := low; bin < high; bin++ {
+				magnitude := cmplx.Abs(spectrum[bin])
+				power += magnitude * magnitude
+			}
+			result[band][frame] = math.Sqrt(power)
+		}
+	}
+	return result
+}
+
+// norm returns the Euclidean norm of the values.
+func norm(values []float64) float64 {
+	return math.Sqrt(dot(values, values))
+}
+
+// dot returns the dot product of the values.
+func dot(a, b []float64) float64 {
+	result := 0.0
+	for index := range a {
+		result += a[index] * b[index]
+	}
+	return result
+}
+
+// normalize subtracts the mean of the values, and divides them by their norm.
+func normalize(values []float64) {
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	for index := range values {
+		values[index] -= mean
+	}
+	scale := 1 / (norm(values) + eps)
+	for index := range values {
+		values[index] *= scale
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stoi
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/google/zimtohrli/go/audio"
+)
+
+// speechLike returns seconds of noise at the rate, amplitude modulated at syllable rate with pauses, so that it has
+// both silent frames and envelope fluctuations.
+func speechLike(rate float64, seconds float64, seed int64) *audio.Audio {
+	rng := rand.New(rand.NewSource(seed))
+	samples := make([]float32, int(rate*seconds))
+	for index := range samples {
+		t := float64(index) / rate
+		envelope := math.Max(0, math.Sin(2*math.Pi*4*t)) * math.Max(0, math.Sin(2*math.Pi*0.5*t+0.3))
+		samples[index] = float32(0.3 * envelope * rng.NormFloat64())
+	}
+	return &audio.Audio{Samples: [][]float32{samples}, Rate: rate}
+}
+
+// withNoise returns the audio with white noise at the signal-to-noise ratio in dB.
+func withNoise(a *audio.Audio, snr float64, seed int64) *audio.Audio {
+	rng := rand.New(rand.NewSource(seed))
+	power := 0.0
+	for _, sample := range a.Samples[0] {
+		power += float64(sample) * float64(sample)
+	}
+	amplitude := math.Sqrt(power / float64(len(a.Samples[0])) / math.Pow(10, snr/10))
+	samples := make([]float32, len(a.Samples[0]))
+	for index, sample := range a.Samples[0] {
+		samples[index] = sample + float32(amplitude*rng.NormFloat64())
+	}
+	return &audio.Audio{Samples: [][]float32{samples}, Rate: a.Rate}
+}
+
+func TestIdentical(t *testing.T) {
+	for _, rate := range []float64{10000, 16000, 48000} {
+		signal := speechLike(rate, 3, 1)
+		for name, measure := range map[string]func(reference, distortion *audio.Audio) (float64, error){"STOI": STOI, "ESTOI": ESTOI} {
+			score, err := measure(signal, signal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(score-1) > 1e-6 {
+				t.Errorf("%v of identical signals at %v Hz = %v, want 1", name, rate, score)
+			}
+		}
+	}
+}
+
+func TestNoise(t *testing.T) {
+	reference := speechLike(48000, 3, 1)
+	for name, measure := range map[string]func(reference, distortion *audio.Audio) (float64, error){"STOI": STOI, "ESTOI": ESTOI} {
+		previous := 1.0
+		for _, snr := range []float64{20, 5, -5, -15} {
+			score, err := measure(reference, withNoise(reference, snr, 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if score >= previous {
+				t.Errorf("%v at %v dB SNR = %v, want less than %v at the previous SNR", name, snr, score, previous)
+			}
+			previous = score
+		}
+	}
+}
+
+func TestTooShort(t *testing.T) {
+	signal := speechLike(48000, 0.2, 1)
+	if score, err := STOI(signal, signal); err == nil {
+		t.Errorf("STOI of 200 ms = %v, want error", score)
+	}
+}
+
+func TestResample(t *testing.T) {
+	for _, tc := range []struct {
+		rate float64
+		freq float64
+	}{
+		{rate: 48000, freq: 1000},
+		{rate: 44100, freq: 3000},
+		{rate: 8000, freq: 500},
+	} {
+		signal := make([]float64, int(tc.rate))
+		for index := range signal {
+			signal[index] = math.Sin(2 * math.Pi * tc.freq * float64(index) / tc.rate)
+		}
+		resampled := resample(signal, tc.rate)
+		if len(resampled) != sampleRate {
+			t.Errorf("resampling 1 s at %v Hz got %v samples, want %v", tc.rate, len(resampled), sampleRate)
+		}
+		// The edges lack half of the filter.
+		for index := 200; index < len(resampled)-200; index++ {
+			want := math.Sin(2 * math.Pi * tc.freq * float64(index) / sampleRate)
+			if math.Abs(resampled[index]-want) > 1e-2 {
+				t.Fatalf("resampling %v Hz sine from %v Hz, sample %v = %v, want %v", tc.freq, tc.rate, index, resampled[index], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worker
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Limiter limits the number of concurrent operations, like processes or CPU bound computations, independently of
+// how many goroutines run them.
+type Limiter struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	running int
+	max     int
+}
+
+// NewLimiter returns a limiter running at most max operations concurrently. Zero or less means unlimited.
+func NewLimiter(max int) *Limiter {
+	l := &Limiter{max: max}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// NewLimiterFromEnv returns a limiter running at most the number in the environment variable of operations
+// concurrently, or unlimited if it isn't set to a number.
+func NewLimiterFromEnv(name string) *Limiter {
+	max, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return NewLimiter(0)
+	}
+	return NewLimiter(max)
+}
+
+// Max returns the max number of concurrent operations, or 0 or less if unlimited.
+func (l *Limiter) Max() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.max
+}
+
+// SetMax changes the max number of concurrent operations. Zero or less means unlimited. Running operations are
+// never interrupted, but no new ones start until fewer than max are running.
+func (l *Limiter) SetMax(max int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.max = max
+	l.cond.Broadcast()
+}
+
+// Acquire waits until fewer than the max number of operations are running, and starts one.
+func (l *Limiter) Acquire() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for l.max > 0 && l.running >= l.max {
+		l.cond.Wait()
+	}
+	l.running++
+}
+
+// Release finishes an operation started by Acquire.
+func (l *Limiter) Release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.running--
+	l.cond.Signal()
+}
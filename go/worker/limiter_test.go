@@ -0,0 +1,92 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// maxConcurrent returns the max number of concurrent operations when running numOperations operations using the
+// limiter in separate goroutines.
+func maxConcurrent(limiter *Limiter, numOperations int) int {
+	lock := sync.Mutex{}
+	running, maxRunning := 0, 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < numOperations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Acquire()
+			defer limiter.Release()
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	return maxRunning
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(2)
+	if got := maxConcurrent(limiter, 10); got != 2 {
+		t.Errorf("got %v concurrent operations, want 2", got)
+	}
+	limiter.SetMax(0)
+	if got := maxConcurrent(limiter, 10); got != 10 {
+		t.Errorf("got %v concurrent operations when unlimited, want 10", got)
+	}
+
+	// Raising the max lets waiting operations start.
+	limiter.SetMax(1)
+	limiter.Acquire()
+	started := make(chan struct{})
+	go func() {
+		limiter.Acquire()
+		close(started)
+		limiter.Release()
+	}()
+	select {
+	case <-started:
+		t.Fatalf("operation started while the max was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	limiter.SetMax(2)
+	<-started
+	limiter.Release()
+	if got := limiter.Max(); got != 2 {
+		t.Errorf("Max() = %v, want 2", got)
+	}
+}
+
+func TestNewLimiterFromEnv(t *testing.T) {
+	t.Setenv("ZIMTOHRLI_TEST_LIMIT", "3")
+	if got := NewLimiterFromEnv("ZIMTOHRLI_TEST_LIMIT").Max(); got != 3 {
+		t.Errorf("Max() = %v, want 3", got)
+	}
+	t.Setenv("ZIMTOHRLI_TEST_LIMIT", "many")
+	if got := NewLimiterFromEnv("ZIMTOHRLI_TEST_LIMIT").Max(); got != 0 {
+		t.Errorf("Max() with an invalid limit = %v, want 0", got)
+	}
+}
@@ -19,8 +19,13 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/google/zimtohrli/go/logging"
 )
 
 // ChangeHandler is updated when the worker pool increases the number of submitted, completed, or error jobs.
@@ -29,42 +34,105 @@ type ChangeHandler func(submitted, completed, errors int)
 // ErrorHandler is updated when the worker pool encounters an error. The encountered error will be replaced with the return value of the handler.
 type ErrorHandler func(error) error
 
+// StallHandler is called when a job has been running for another multiple of the stall timeout of the worker pool.
+type StallHandler func(description string, running time.Duration)
+
+// Order is the order Collect returns results in.
+type Order int
+
+const (
+	// CompletionOrder orders results by when they were produced.
+	CompletionOrder Order = iota
+	// SubmissionOrder orders results by when the jobs producing them were submitted, and results of the same job
+	// by when they were produced.
+	SubmissionOrder
+)
+
 // Pool is a pool of workers.
 type Pool[T any] struct {
 	Workers  int
 	OnChange ChangeHandler
 	OnError  ErrorHandler
 	FailFast bool
+	// StallTimeout, if positive, is the duration after which running jobs are considered stalled, and again after
+	// each further StallTimeout they keep running.
+	StallTimeout time.Duration
+	// OnStall is called for stalled jobs. If nil, stalled jobs are logged.
+	OnStall StallHandler
 
 	startOnce sync.Once
 
-	jobs             chan func(func(T)) error
+	jobs             chan job[T]
 	jobsWaitGroup    sync.WaitGroup
-	results          chan T
+	results          chan result[T]
 	resultsWaitGroup sync.WaitGroup
 	errors           chan error
 	errorsWaitGroup  sync.WaitGroup
 
-	submittedJobs uint32
-	completedJobs uint32
-	errorJobs     uint32
+	submittedJobs  uint32
+	completedJobs  uint32
+	errorJobs      uint32
+	resultSequence uint64
+}
+
+// job is a submitted job, its index in submission order, and its description.
+type job[T any] struct {
+	index       int
+	description string
+	run         func(func(T)) error
+}
+
+// PanicError is returned for jobs that panicked.
+type PanicError struct {
+	// Description is the description of the job.
+	Description string
+	// Value is the value the job panicked with.
+	Value any
+	// Stack is the stack trace of the goroutine running the job when it panicked.
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked: %v\n%s", p.Description, p.Value, p.Stack)
+}
+
+// call runs the job, and returns a *PanicError if it panics.
+func (j job[T]) call(emit func(T)) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &PanicError{Description: j.description, Value: value, Stack: debug.Stack()}
+		}
+	}()
+	return j.run(emit)
+}
+
+// result is a produced result, the index of the job producing it, and its index in completion order.
+type result[T any] struct {
+	job      int
+	sequence uint64
+	value    T
 }
 
 func (p *Pool[T]) init() {
 	p.startOnce.Do(func() {
-		p.jobs = make(chan func(func(T)) error)
-		p.results = make(chan T)
+		p.jobs = make(chan job[T])
+		p.results = make(chan result[T])
 		p.errors = make(chan error)
 		for i := 0; i < p.Workers; i++ {
 			go func() {
-				for job := range p.jobs {
-					if err := job(func(t T) {
+				for j := range p.jobs {
+					index := j.index
+					stopWatchdog := p.watch(j.description)
+					err := j.call(func(t T) {
+						sequence := atomic.AddUint64(&p.resultSequence, 1)
 						p.resultsWaitGroup.Add(1)
 						go func() {
-							p.results <- t
+							p.results <- result[T]{job: index, sequence: sequence, value: t}
 							p.resultsWaitGroup.Done()
 						}()
-					}); err != nil {
+					})
+					stopWatchdog()
+					if err != nil {
 						if err = p.err(err); err != nil {
 							if p.FailFast {
 								log.Fatal(err)
@@ -87,6 +155,33 @@ func (p *Pool[T]) init() {
 	})
 }
 
+// watch reports the job with the description as stalled while it runs longer than the stall timeout, until the
+// returned function is called.
+func (p *Pool[T]) watch(description string) func() {
+	if p.StallTimeout <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(p.StallTimeout)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if p.OnStall != nil {
+					p.OnStall(description, now.Sub(start))
+				} else {
+					logging.Warningf("%s has been running for %v", description, now.Sub(start).Round(time.Second))
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
 func (p *Pool[T]) err(err error) error {
 	if p.OnError != nil {
 		return p.OnError(err)
@@ -100,20 +195,43 @@ func (p *Pool[T]) change() {
 	}
 }
 
-// Submit submits a job to the pool.
-func (p *Pool[T]) Submit(job func(func(T)) error) error {
+// Submit submits a job to the pool, which may produce any number of results by calling its argument.
+//
+// Jobs that panic fail with a *PanicError, while the other jobs keep running.
+func (p *Pool[T]) Submit(run func(func(T)) error) error {
+	return p.SubmitDescribed("", run)
+}
+
+// SubmitDescribed is like Submit, but with a description of the job, e.g. the files it processes, that errors
+// about the job contain. Empty descriptions are replaced with the index of the job.
+func (p *Pool[T]) SubmitDescribed(description string, run func(func(T)) error) error {
 	p.init()
 
 	p.jobsWaitGroup.Add(1)
-	atomic.AddUint32(&p.submittedJobs, 1)
+	index := int(atomic.AddUint32(&p.submittedJobs, 1)) - 1
 	p.change()
+	if description == "" {
+		description = fmt.Sprintf("job %v", index)
+	}
 
 	go func() {
-		p.jobs <- job
+		p.jobs <- job[T]{index: index, description: description, run: run}
 	}()
 	return nil
 }
 
+// SubmitResult submits a job producing a single result unless it fails.
+func (p *Pool[T]) SubmitResult(run func() (T, error)) error {
+	return p.Submit(func(emit func(T)) error {
+		result, err := run()
+		if err != nil {
+			return err
+		}
+		emit(result)
+		return nil
+	})
+}
+
 // Errors is a slice of errors.
 type Errors []error
 
@@ -150,13 +268,49 @@ func (p *Pool[T]) Error() error {
 
 // Results returns all results produced. The result channel will close once all results are processed.
 //
-// Must be called if any jobs might have produced results.
+// Must be called if any jobs might have produced results, unless Collect is used.
 //
 // Error() must be called before Results().
 func (p *Pool[T]) Results() <-chan T {
+	p.init()
+
+	go func() {
+		p.resultsWaitGroup.Wait()
+		close(p.results)
+	}()
+	values := make(chan T)
+	go func() {
+		for r := range p.results {
+			values <- r.value
+		}
+		close(values)
+	}()
+	return values
+}
+
+// Collect waits for all submitted jobs to finish, and returns all results produced in the order, along with the
+// error returned by Error.
+//
+// Must be called after all jobs are added, instead of Error and Results.
+func (p *Pool[T]) Collect(order Order) ([]T, error) {
+	err := p.Error()
 	go func() {
 		p.resultsWaitGroup.Wait()
 		close(p.results)
 	}()
-	return p.results
+	collected := []result[T]{}
+	for r := range p.results {
+		collected = append(collected, r)
+	}
+	sort.Slice(collected, func(i, j int) bool {
+		if order == SubmissionOrder && collected[i].job != collected[j].job {
+			return collected[i].job < collected[j].job
+		}
+		return collected[i].sequence < collected[j].sequence
+	})
+	values := make([]T, len(collected))
+	for index, r := range collected {
+		values[index] = r.value
+	}
+	return values, err
 }
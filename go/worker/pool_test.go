@@ -0,0 +1,153 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResults(t *testing.T) {
+	pool := &Pool[int]{Workers: 4}
+	for i := 0; i < 10; i++ {
+		value := i
+		pool.Submit(func(emit func(int)) error {
+			emit(value)
+			emit(value + 100)
+			return nil
+		})
+	}
+	if err := pool.Error(); err != nil {
+		t.Fatal(err)
+	}
+	got := []int{}
+	for value := range pool.Results() {
+		got = append(got, value)
+	}
+	sort.Ints(got)
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 100, 101, 102, 103, 104, 105, 106, 107, 108, 109}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Results = %v, want %v", got, want)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	// Later jobs finish first, and job 2 fails.
+	submit := func(pool *Pool[string]) {
+		for i := 0; i < 5; i++ {
+			index := i
+			pool.Submit(func(emit func(string)) error {
+				time.Sleep(time.Duration(5-index) * 20 * time.Millisecond)
+				if index == 2 {
+					return fmt.Errorf("job 2 failed")
+				}
+				emit(fmt.Sprintf("%va", index))
+				emit(fmt.Sprintf("%vb", index))
+				return nil
+			})
+		}
+	}
+	for _, tc := range []struct {
+		order Order
+		want  []string
+	}{
+		{SubmissionOrder, []string{"0a", "0b", "1a", "1b", "3a", "3b", "4a", "4b"}},
+		{CompletionOrder, []string{"4a", "4b", "3a", "3b", "1a", "1b", "0a", "0b"}},
+	} {
+		pool := &Pool[string]{Workers: 5}
+		submit(pool)
+		got, err := pool.Collect(tc.order)
+		if err == nil {
+			t.Errorf("Collect(%v) returned no error for the failed job", tc.order)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Collect(%v) = %v, want %v", tc.order, got, tc.want)
+		}
+	}
+
+	pool := &Pool[int]{Workers: 2}
+	for i := 0; i < 4; i++ {
+		value := i
+		pool.SubmitResult(func() (int, error) {
+			return value * value, nil
+		})
+	}
+	got, err := pool.Collect(SubmissionOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 4, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Collect of SubmitResult jobs = %v, want %v", got, want)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	pool := &Pool[int]{Workers: 2}
+	for i := 0; i < 4; i++ {
+		value := i
+		pool.SubmitDescribed(fmt.Sprintf("squaring %v", value), func(emit func(int)) error {
+			if value == 2 {
+				panic("two")
+			}
+			emit(value * value)
+			return nil
+		})
+	}
+	got, err := pool.Collect(SubmissionOrder)
+	if want := []int{0, 1, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Collect = %v, want the results of the jobs that didn't panic %v", got, want)
+	}
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("got error %v, want one error", err)
+	}
+	panicErr := &PanicError{}
+	if !errors.As(errs[0], &panicErr) || panicErr.Description != "squaring 2" || panicErr.Value != "two" || !strings.Contains(string(panicErr.Stack), "TestPanic") {
+		t.Errorf("got error %v, want a *PanicError for squaring 2 with the stack trace", errs[0])
+	}
+}
+
+func TestStall(t *testing.T) {
+	stalledLock := sync.Mutex{}
+	stalled := map[string]time.Duration{}
+	pool := &Pool[int]{
+		Workers:      2,
+		StallTimeout: 20 * time.Millisecond,
+		OnStall: func(description string, running time.Duration) {
+			stalledLock.Lock()
+			defer stalledLock.Unlock()
+			stalled[description] = running
+		},
+	}
+	pool.SubmitDescribed("fast", func(func(int)) error { return nil })
+	pool.SubmitDescribed("slow", func(func(int)) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	if err := pool.Error(); err != nil {
+		t.Fatal(err)
+	}
+	stalledLock.Lock()
+	defer stalledLock.Unlock()
+	if _, found := stalled["fast"]; found || stalled["slow"] < 40*time.Millisecond {
+		t.Errorf("stalled = %v, want the slow job reported repeatedly", stalled)
+	}
+}
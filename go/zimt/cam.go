@@ -0,0 +1,225 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zimt
+
+import (
+	"fmt"
+	"math"
+)
+
+// Cam contains the parameters of the Cam scale and of the filterbank created from it.
+type Cam struct {
+	// ErbsScale1, ErbsScale2, and ErbsOffset define the Cam scale as
+	// Cam = ErbsScale1 * log10(ErbsOffset + ErbsScale2 * Hz).
+	ErbsScale1 float32
+	ErbsScale2 float32
+	ErbsOffset float32
+	// LowThresholdHz and HighThresholdHz are the limits of the filterbank.
+	LowThresholdHz  float32
+	HighThresholdHz float32
+	// MinimumBandwidthHz is the bandwidth of the lowest channel, which decides the Cam width of all channels.
+	MinimumBandwidthHz float32
+	// FilterOrder, FilterPassBandRipple, and FilterStopBandRipple define the elliptic filter of each channel.
+	FilterOrder          int
+	FilterPassBandRipple float32
+	FilterStopBandRipple float32
+}
+
+// DefaultCam returns the default Cam parameters.
+func DefaultCam() Cam {
+	return Cam{
+		ErbsScale1:           21.4,
+		ErbsScale2:           0.00437,
+		ErbsOffset:           1.0,
+		LowThresholdHz:       20,
+		HighThresholdHz:      20000,
+		MinimumBandwidthHz:   5,
+		FilterOrder:          1,
+		FilterPassBandRipple: 3,
+		FilterStopBandRipple: 80,
+	}
+}
+
+// HzFromCam returns the frequency of the Cam value.
+func (c Cam) HzFromCam(cam float32) float32 {
+	return float32((math.Pow(10, float64(cam/c.ErbsScale1)) - float64(c.ErbsOffset)) / float64(c.ErbsScale2))
+}
+
+// CamFromHz returns the Cam value of the frequency.
+func (c Cam) CamFromHz(hz float32) float32 {
+	return c.ErbsScale1 * float32(math.Log10(float64(c.ErbsOffset+c.ErbsScale2*hz)))
+}
+
+// CamFilterbank is a filterbank with channels of equal width on the Cam scale.
+type CamFilterbank struct {
+	Filter *Filterbank
+	// ThresholdsHz contains the low threshold, center, and high threshold of each channel.
+	ThresholdsHz [3][]float32
+	// CamDelta is the Cam width of each channel.
+	CamDelta             float32
+	SampleRate           float32
+	FilterOrder          int
+	FilterPassBandRipple float32
+	FilterStopBandRipple float32
+}
+
+// CreateFilterbank returns a filterbank for signals with the sample rate.
+func (c Cam) CreateFilterbank(sampleRate float32) (*CamFilterbank, error) {
+	lowThresholdCam := c.CamFromHz(c.LowThresholdHz)
+	highThresholdCam := c.CamFromHz(c.HighThresholdHz)
+	camDelta := c.CamFromHz(c.LowThresholdHz+c.MinimumBandwidthHz) - lowThresholdCam
+	if !(camDelta > 0) {
+		return nil, fmt.Errorf("minimum bandwidth %v Hz gives a Cam width of %v", c.MinimumBandwidthHz, camDelta)
+	}
+	result := &CamFilterbank{
+		CamDelta:             camDelta,
+		SampleRate:           sampleRate,
+		FilterOrder:          c.FilterOrder,
+		FilterPassBandRipple: c.FilterPassBandRipple,
+		FilterStopBandRipple: c.FilterStopBandRipple,
+	}
+	filters := [][]BACoeffs{}
+	// The channels are stepped through in float32 to get the same channels as the C++ implementation.
+	for leftCam := lowThresholdCam; leftCam+camDelta < highThresholdCam; leftCam += camDelta {
+		leftHz := c.HzFromCam(leftCam)
+		rightHz := c.HzFromCam(leftCam + camDelta)
+		sections, err := DigitalSOSBandPass(c.FilterOrder, float64(c.FilterPassBandRipple), float64(c.FilterStopBandRipple), float64(leftHz), float64(rightHz), float64(sampleRate))
+		if err != nil {
+			return nil, err
+		}
+		if len(filters) > 0 && len(sections) != len(filters[0]) {
+			return nil, fmt.Errorf("channel %v has %v sections, want %v", len(filters), len(sections), len(filters[0]))
+		}
+		filters = append(filters, sections)
+		result.ThresholdsHz[0] = append(result.ThresholdsHz[0], leftHz)
+		result.ThresholdsHz[1] = append(result.ThresholdsHz[1], float32(float64(leftHz+rightHz)*0.5))
+		result.ThresholdsHz[2] = append(result.ThresholdsHz[2], rightHz)
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("no channels fit between %v Hz and %v Hz", c.LowThresholdHz, c.HighThresholdHz)
+	}
+	result.Filter = NewFilterbank(filters)
+	return result, nil
+}
+
+// Filterbank is a bank of filters, each a cascade of sections, that all filter the same signal.
+type Filterbank struct {
+	// b and a are the (num_sections, num_coeffs, num_filters)-shaped coefficients, with the a[0] coefficients
+	// inverted so that they can be multiplied instead of divided by.
+	b [][][]float32
+	a [][][]float32
+}
+
+// NewFilterbank returns a filterbank with the filters, which must all have the same number of sections with the
+// same number of coefficients.
+func NewFilterbank(filters [][]BACoeffs) *Filterbank {
+	numSections := len(filters[0])
+	result := &Filterbank{
+		b: make([][][]float32, numSections),
+		a: make([][][]float32, numSections),
+	}
+	for sectionIndex := range result.b {
+		result.b[sectionIndex] = newArray(len(filters[0][sectionIndex].B), len(filters))
+		result.a[sectionIndex] = newArray(len(filters[0][sectionIndex].A), len(filters))
+		for filterIndex, filter := range filters {
+			for coeffIndex, coeff := range filter[sectionIndex].B {
+				result.b[sectionIndex][coeffIndex][filterIndex] = float32(coeff)
+			}
+			for coeffIndex, coeff := range filter[sectionIndex].A {
+				result.a[sectionIndex][coeffIndex][filterIndex] = float32(coeff)
+			}
+		}
+		for filterIndex, coeff := range result.a[sectionIndex][0] {
+			result.a[sectionIndex][0][filterIndex] = 1 / coeff
+		}
+	}
+	return result
+}
+
+// Size returns the number of filters.
+func (f *Filterbank) Size() int {
+	return len(f.b[0][0])
+}
+
+// FilterbankState contains the history of the inputs and outputs of each section, to filter a signal in parts.
+type FilterbankState struct {
+	// x and y are the (num_sections, num_coeffs, num_filters)-shaped circular buffers of inputs and outputs.
+	x           [][][]float32
+	y           [][][]float32
+	sampleIndex int
+	// xRows and yRows are reused by Step to avoid allocations.
+	xRows [][]float32
+	yRows [][]float32
+}
+
+// NewState returns the state to filter a new signal with.
+func (f *Filterbank) NewState() *FilterbankState {
+	result := &FilterbankState{
+		x: make([][][]float32, len(f.b)),
+		y: make([][][]float32, len(f.a)),
+	}
+	for sectionIndex := range f.b {
+		result.x[sectionIndex] = newArray(len(f.b[sectionIndex]), f.Size())
+		result.y[sectionIndex] = newArray(len(f.a[sectionIndex]), f.Size())
+	}
+	return result
+}
+
+// Step filters the next sample of the signal, and populates the output with the value of each filter.
+func (f *Filterbank) Step(state *FilterbankState, sample float32, output []float32) {
+	for sectionIndex := range f.b {
+		b, a := f.b[sectionIndex], f.a[sectionIndex]
+		// The rows of the circular buffers with the values coeff_index steps back.
+		state.xRows = state.xRows[:0]
+		for coeffIndex, x := 0, state.x[sectionIndex]; coeffIndex < len(x); coeffIndex++ {
+			state.xRows = append(state.xRows, x[(state.sampleIndex-coeffIndex+len(x))%len(x)])
+		}
+		state.yRows = state.yRows[:0]
+		for coeffIndex, y := 0, state.y[sectionIndex]; coeffIndex < len(y); coeffIndex++ {
+			state.yRows = append(state.yRows, y[(state.sampleIndex-coeffIndex+len(y))%len(y)])
+		}
+		if sectionIndex == 0 {
+			for filterIndex := range state.xRows[0] {
+				state.xRows[0][filterIndex] = sample
+			}
+		} else {
+			// The input of the section is the output of the previous section.
+			copy(state.xRows[0], output)
+		}
+		for filterIndex := range output {
+			numerator := float32(0)
+			for coeffIndex := range b {
+				numerator = mulAdd(b[coeffIndex][filterIndex], state.xRows[coeffIndex][filterIndex], numerator)
+			}
+			denominator := float32(0)
+			for coeffIndex := 1; coeffIndex < len(a); coeffIndex++ {
+				denominator = mulAdd(a[coeffIndex][filterIndex], state.yRows[coeffIndex][filterIndex], denominator)
+			}
+			output[filterIndex] = a[0][filterIndex] * (numerator - denominator)
+		}
+		copy(state.yRows[0], output)
+	}
+	state.sampleIndex++
+}
+
+// Filter returns the (num_samples, num_filters)-shaped output of filtering the signal.
+func (f *Filterbank) Filter(signal []float32) [][]float32 {
+	state := f.NewState()
+	result := newArray(len(signal), f.Size())
+	for sampleIndex, sample := range signal {
+		f.Step(state, sample, result[sampleIndex])
+	}
+	return result
+}
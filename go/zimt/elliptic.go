@@ -0,0 +1,498 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zimt
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// ZPKCoeffs contains the zeros, poles, and gain of a filter.
+type ZPKCoeffs struct {
+	Zeros []complex128
+	Poles []complex128
+	Gain  float64
+}
+
+// BACoeffs contains the numerator (B) and denominator (A) coefficients of a filter.
+type BACoeffs struct {
+	B []float64
+	A []float64
+}
+
+const (
+	maxEllipticIntegralIter  = 100
+	ellipticIntegralEpsilon  = 1e-12
+	minimumJacobianM         = 1e-9
+	maximumJacobianM         = 0.9999999999
+	approximateMachineEps    = 2e-53
+	ellipticJacobianSteps    = 9
+	maxRealArcJac            = 1e-14
+	minimumPoleZero          = 2e-16
+	bestQualityEpsilon       = 1e-12
+	negativeConjugateEpsilon = -100 * approximateMachineEps
+)
+
+// EllipticIntegral1 returns the complete elliptic integral of the first kind of the parameter m, like
+// scipy.special.ellipk.
+//
+// See https://en.wikipedia.org/wiki/Carlson_symmetric_form#Numerical_evaluation.
+func EllipticIntegral1(m float64) float64 {
+	if m >= 1 {
+		return math.Inf(1)
+	}
+	x, y, z := 0.0, 1-m, 1.0
+	err := 1.0
+	for i := 0; i < maxEllipticIntegralIter && err > ellipticIntegralEpsilon; i++ {
+		lambda := math.Sqrt(x)*math.Sqrt(y) + math.Sqrt(y)*math.Sqrt(z) + math.Sqrt(z)*math.Sqrt(x)
+		x = (x + lambda) * 0.25
+		nextY := (y + lambda) * 0.25
+		err = math.Abs(nextY - y)
+		y = nextY
+		z = (z + lambda) * 0.25
+	}
+	return math.Pow(y, -0.5)
+}
+
+// EllipticJacobian returns the Jacobian elliptic functions sn, cn, and dn of u and the parameter m, like
+// scipy.special.ellipj.
+func EllipticJacobian(u, m float64) [3]float64 {
+	if m < 0 || m > 1 {
+		return [3]float64{math.NaN(), math.NaN(), math.NaN()}
+	}
+	if m < minimumJacobianM {
+		t := math.Sin(u)
+		b := math.Cos(u)
+		ai := 0.25 * m * (u - t*b)
+		return [3]float64{t - ai*b, b + ai*t, 1 - 0.5*m*t*t}
+	}
+	if m >= maximumJacobianM {
+		b := math.Cosh(u)
+		t := math.Tanh(u)
+		phi := 1 / b
+		twon := b * math.Sinh(u)
+		ai := 0.25 * (1 - m) * t * phi
+		return [3]float64{t + ai*(twon-u)/(b*b), phi - ai*(twon-u), phi + ai*(twon+u)}
+	}
+	var a, c [ellipticJacobianSteps]float64
+	// A. G. M. scale
+	a[0] = 1
+	b := math.Sqrt(1 - m)
+	c[0] = math.Sqrt(m)
+	twon := 1.0
+	i := 0
+	for ; math.Abs(c[i]/a[i]) > approximateMachineEps && i < ellipticJacobianSteps-1; i++ {
+		c[i+1] = (a[i] - b) / 2
+		t := math.Sqrt(a[i] * b)
+		a[i+1] = (a[i] + b) / 2
+		b = t
+		twon *= 2
+	}
+	// Backward recurrence.
+	phi := twon * a[i] * u
+	for {
+		t := c[i] * math.Sin(phi) / a[i]
+		b = phi
+		phi = (math.Asin(t) + phi) / 2
+		if i--; i == 0 {
+			break
+		}
+	}
+	t := math.Cos(phi)
+	return [3]float64{math.Sin(phi), t, t / math.Cos(phi-b)}
+}
+
+// ellipDegree solves "n * K(m) / K'(m) = K1(m1) / K1'(m1)" for m using nomes.
+func ellipDegree(n, m1 float64) float64 {
+	k1 := EllipticIntegral1(m1)
+	k1p := EllipticIntegral1(1 - m1)
+	q1 := math.Exp(-math.Pi * k1p / k1)
+	q := math.Pow(q1, 1/n)
+	num := 0.0
+	for el := 0.0; el < 8; el++ {
+		num += math.Pow(q, el*(el+1))
+	}
+	den := 1.0
+	for el := 1.0; el < 9; el++ {
+		den += 2 * math.Pow(q, el*el)
+	}
+	return 16 * q * math.Pow(num/den, 4)
+}
+
+// pow10m1 returns 10 ** x - 1 for x near 0.
+func pow10m1(x float64) float64 {
+	return math.Expm1(math.Ln10 * x)
+}
+
+func complement(kx float64) float64 {
+	return math.Sqrt((1 - kx) * (1 + kx))
+}
+
+func complexComplement(kx complex128) complex128 {
+	return cmplx.Sqrt((1 - kx) * (1 + kx))
+}
+
+// arcJacSn solves for z in w = sn(z, m).
+func arcJacSn(w complex128, m float64) complex128 {
+	k := math.Sqrt(m)
+	if k > 1 {
+		return cmplx.NaN()
+	}
+	if k == 1 {
+		return cmplx.Atanh(w)
+	}
+	ks := []float64{k}
+	for niter := 0; ks[len(ks)-1] != 0; niter++ {
+		if niter >= 10 {
+			return cmplx.NaN()
+		}
+		kp := complement(ks[len(ks)-1])
+		ks = append(ks, (1-kp)/(1+kp))
+	}
+	capK := math.Pi * 0.5
+	for _, kn := range ks[1:] {
+		capK *= 1 + kn
+	}
+	wn := w
+	for i := 1; i < len(ks); i++ {
+		wn = 2 * wn / (complex(1+ks[i], 0) * (1 + complexComplement(complex(ks[i-1], 0)*wn)))
+	}
+	return complex(capK, 0) * (complex(2/math.Pi, 0) * cmplx.Asin(wn))
+}
+
+// arcJacSc1 solves for z in w = sc(z, 1-m).
+func arcJacSc1(w, m float64) float64 {
+	z := arcJacSn(complex(0, w), m)
+	if math.Abs(real(z)) > maxRealArcJac {
+		return math.NaN()
+	}
+	return imag(z)
+}
+
+// AnalogPrototypeLowPass returns an analog elliptic low pass filter prototype, like scipy.signal.ellipap.
+func AnalogPrototypeLowPass(order int, passBandRipple, stopBandRipple float64) (ZPKCoeffs, error) {
+	result := ZPKCoeffs{}
+	if order == 0 {
+		result.Gain = math.Pow(10, passBandRipple*-0.05)
+		return result, nil
+	}
+	if order == 1 {
+		result.Poles = []complex128{complex(-math.Sqrt(1/pow10m1(0.1*passBandRipple)), 0)}
+		result.Gain = -real(result.Poles[0])
+		return result, nil
+	}
+	epsSq := pow10m1(0.1 * passBandRipple)
+	eps := math.Sqrt(epsSq)
+	ck1Sq := epsSq / pow10m1(0.1*stopBandRipple)
+	if ck1Sq == 0 {
+		return ZPKCoeffs{}, fmt.Errorf("cannot design a filter with pass band ripple %v and stop band ripple %v", passBandRipple, stopBandRipple)
+	}
+	val0 := EllipticIntegral1(ck1Sq)
+	m := ellipDegree(float64(order), ck1Sq)
+	capK := EllipticIntegral1(m)
+	j := []float64{}
+	for i := 1 - order%2; i < order; i += 2 {
+		j = append(j, float64(i))
+	}
+	s := make([]float64, len(j))
+	c := make([]float64, len(j))
+	d := make([]float64, len(j))
+	for i := range j {
+		jac := EllipticJacobian(j[i]*capK/float64(order), m)
+		s[i], c[i], d[i] = jac[0], jac[1], jac[2]
+		if math.Abs(jac[0]) > minimumPoleZero {
+			result.Zeros = append(result.Zeros, complex(0, 1/(math.Sqrt(m)*jac[0])))
+		}
+	}
+	for _, zero := range result.Zeros {
+		result.Zeros = append(result.Zeros, cmplx.Conj(zero))
+	}
+
+	r := arcJacSc1(1/eps, ck1Sq)
+	v0 := capK * r / (float64(order) * val0)
+	jac2 := EllipticJacobian(v0, 1-m)
+	sv, cv, dv := jac2[0], jac2[1], jac2[2]
+	for i := range j {
+		result.Poles = append(result.Poles, -complex(c[i]*d[i]*sv*cv, s[i]*dv)/complex(1-math.Pow(d[i]*sv, 2), 0))
+	}
+	numPoles := len(result.Poles)
+	if order%2 == 1 {
+		pSum := complex128(0)
+		for _, p := range result.Poles {
+			pSum += p * cmplx.Conj(p)
+		}
+		minimumP := math.Sqrt(real(pSum)) * minimumPoleZero
+		for i := 0; i < numPoles; i++ {
+			if math.Abs(imag(result.Poles[i])) > minimumP {
+				result.Poles = append(result.Poles, cmplx.Conj(result.Poles[i]))
+			}
+		}
+	} else {
+		for i := 0; i < numPoles; i++ {
+			result.Poles = append(result.Poles, cmplx.Conj(result.Poles[i]))
+		}
+	}
+
+	k := complex(1, 0)
+	for _, p := range result.Poles {
+		k *= -p
+	}
+	for _, z := range result.Zeros {
+		k /= -z
+	}
+	result.Gain = real(k)
+	if order%2 == 0 {
+		result.Gain /= math.Sqrt(1 + epsSq)
+	}
+	return result, nil
+}
+
+// AnalogBandPassFromLowPass transforms an analog low pass filter to a band pass filter with the center
+// frequency wo and the bandwidth bw, like scipy.signal.lp2bp_zpk.
+func AnalogBandPassFromLowPass(lowPass ZPKCoeffs, wo, bw float64) ZPKCoeffs {
+	degree := len(lowPass.Poles) - len(lowPass.Zeros)
+	transform := func(values []complex128) []complex128 {
+		result := make([]complex128, 2*len(values))
+		for i, value := range values {
+			lp := value * complex(bw*0.5, 0)
+			lpWoNorm := cmplx.Sqrt(lp*lp - complex(wo*wo, 0))
+			result[i] = lp + lpWoNorm
+			result[i+len(values)] = lp - lpWoNorm
+		}
+		return result
+	}
+	result := ZPKCoeffs{
+		Zeros: transform(lowPass.Zeros),
+		Poles: transform(lowPass.Poles),
+		Gain:  lowPass.Gain * math.Pow(bw, float64(degree)),
+	}
+	for i := 0; i < degree; i++ {
+		result.Zeros = append(result.Zeros, 0)
+	}
+	return result
+}
+
+// DigitalBandPassFromAnalog transforms an analog filter to a digital filter using the bilinear transform, like
+// scipy.signal.bilinear_zpk.
+func DigitalBandPassFromAnalog(analog ZPKCoeffs, sampleRate float64) ZPKCoeffs {
+	degree := len(analog.Poles) - len(analog.Zeros)
+	fs2 := complex(2*sampleRate, 0)
+	result := ZPKCoeffs{}
+	zeroProd := complex(1, 0)
+	for _, z := range analog.Zeros {
+		result.Zeros = append(result.Zeros, (fs2+z)/(fs2-z))
+		zeroProd *= fs2 - z
+	}
+	poleProd := complex(1, 0)
+	for _, p := range analog.Poles {
+		result.Poles = append(result.Poles, (fs2+p)/(fs2-p))
+		poleProd *= fs2 - p
+	}
+	for i := 0; i < degree; i++ {
+		result.Zeros = append(result.Zeros, -1)
+	}
+	result.Gain = analog.Gain * real(zeroProd/poleProd)
+	return result
+}
+
+// coeffsFromZeros returns the coefficients of the polynomial with the zeros, i.e. the sums of the products of
+// all combinations of the negated zeros.
+func coeffsFromZeros(zeros []complex128) []float64 {
+	// Expanding the product one zero at a time computes the same sums as iterating over the combinations.
+	coeffs := make([]complex128, len(zeros)+1)
+	coeffs[0] = 1
+	for zeroIndex, zero := range zeros {
+		for coeffIndex := zeroIndex + 1; coeffIndex > 0; coeffIndex-- {
+			coeffs[coeffIndex] += coeffs[coeffIndex-1] * -zero
+		}
+	}
+	result := make([]float64, len(coeffs))
+	for i, coeff := range coeffs {
+		result[i] = real(coeff)
+	}
+	return result
+}
+
+// BAFromZPK returns the numerator and denominator coefficients of the filter, like scipy.signal.zpk2tf.
+func BAFromZPK(zpk ZPKCoeffs) BACoeffs {
+	result := BACoeffs{
+		B: coeffsFromZeros(zpk.Zeros),
+		A: coeffsFromZeros(zpk.Poles),
+	}
+	for i := range result.B {
+		result.B[i] *= zpk.Gain
+	}
+	return result
+}
+
+// requirement restricts the values popBest picks from.
+type requirement int
+
+const (
+	realOrComplex requirement = iota
+	mustBeReal
+	mustBeComplex
+)
+
+// popBest removes and returns the value meeting the requirement with the best quality, picking the last of equally
+// good values to conform with how the tested version of scipy does it.
+func popBest(values *[]complex128, quality func(complex128) float64, req requirement) complex128 {
+	bestIndex := -1
+	bestQuality := 0.0
+	for i, value := range *values {
+		if req == realOrComplex || (req == mustBeReal && imag(value) == 0) || (req == mustBeComplex && imag(value) != 0) {
+			q := quality(value)
+			if bestIndex == -1 || q >= bestQuality-bestQualityEpsilon {
+				bestIndex = i
+				bestQuality = q
+			}
+		}
+	}
+	if bestIndex == -1 {
+		panic("no value meets the requirement")
+	}
+	result := (*values)[bestIndex]
+	*values = append((*values)[:bestIndex], (*values)[bestIndex+1:]...)
+	return result
+}
+
+func popClosest(values *[]complex128, term complex128, req requirement) complex128 {
+	return popBest(values, func(value complex128) float64 { return -cmplx.Abs(value - term) }, req)
+}
+
+func popWorst(values *[]complex128, req requirement) complex128 {
+	return popBest(values, cmplx.Abs, req)
+}
+
+func countReal(values []complex128) int {
+	result := 0
+	for _, value := range values {
+		if imag(value) == 0 {
+			result++
+		}
+	}
+	return result
+}
+
+// withoutNegativeConjugates returns the values without the negative conjugates, so that only one value of each
+// conjugate pair remains.
+func withoutNegativeConjugates(values []complex128) []complex128 {
+	result := []complex128{}
+	for _, value := range values {
+		if imag(value) >= negativeConjugateEpsilon {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// sosSectionsFromZPK returns the second order sections of the filter, like scipy.signal.zpk2sos.
+func sosSectionsFromZPK(zpk ZPKCoeffs) []BACoeffs {
+	// Ensure equal and even number of poles and zeros.
+	zeros := append([]complex128{}, zpk.Zeros...)
+	poles := append([]complex128{}, zpk.Poles...)
+	for len(zeros) < len(poles) {
+		zeros = append(zeros, 0)
+	}
+	for len(poles) < len(zeros) {
+		poles = append(poles, 0)
+	}
+	if len(zeros)%2 == 1 {
+		poles = append(poles, 0)
+		zeros = append(zeros, 0)
+	}
+	zeros = withoutNegativeConjugates(zeros)
+	poles = withoutNegativeConjugates(poles)
+
+	sections := []BACoeffs{}
+	for len(poles) > 0 {
+		pole1 := popWorst(&poles, realOrComplex)
+		switch {
+		case imag(pole1) == 0 && countReal(poles) == 0:
+			if len(zeros) > 0 {
+				zero1 := popClosest(&zeros, pole1, mustBeReal)
+				sections = append(sections, BAFromZPK(ZPKCoeffs{Zeros: []complex128{zero1}, Poles: []complex128{pole1}, Gain: 1}))
+			} else {
+				sections = append(sections, BAFromZPK(ZPKCoeffs{Poles: []complex128{pole1}, Gain: 1}))
+			}
+		case len(poles)+1 == len(zeros) && imag(pole1) != 0 && countReal(poles) == 1 && countReal(zeros) == 1:
+			zero1 := popClosest(&zeros, pole1, mustBeComplex)
+			sections = append(sections, BAFromZPK(ZPKCoeffs{
+				Zeros: []complex128{zero1, cmplx.Conj(zero1)},
+				Poles: []complex128{pole1, cmplx.Conj(pole1)},
+				Gain:  1,
+			}))
+		default:
+			pole2 := cmplx.Conj(pole1)
+			if imag(pole1) == 0 {
+				pole2 = popWorst(&poles, mustBeReal)
+			}
+			if len(zeros) == 0 {
+				sections = append(sections, BAFromZPK(ZPKCoeffs{Poles: []complex128{pole1, pole2}, Gain: 1}))
+				continue
+			}
+			zero1 := popClosest(&zeros, pole1, realOrComplex)
+			switch {
+			case imag(zero1) != 0:
+				sections = append(sections, BAFromZPK(ZPKCoeffs{
+					Zeros: []complex128{zero1, cmplx.Conj(zero1)},
+					Poles: []complex128{pole1, pole2},
+					Gain:  1,
+				}))
+			case len(zeros) > 0:
+				zero2 := popClosest(&zeros, pole1, mustBeReal)
+				sections = append(sections, BAFromZPK(ZPKCoeffs{Zeros: []complex128{zero1, zero2}, Poles: []complex128{pole1, pole2}, Gain: 1}))
+			default:
+				sections = append(sections, BAFromZPK(ZPKCoeffs{Zeros: []complex128{zero1}, Poles: []complex128{pole1, pole2}, Gain: 1}))
+			}
+		}
+	}
+	for i, j := 0, len(sections)-1; i < j; i, j = i+1, j-1 {
+		sections[i], sections[j] = sections[j], sections[i]
+	}
+	for i := range sections[0].B {
+		sections[0].B[i] *= zpk.Gain
+	}
+	return sections
+}
+
+// DigitalSOSBandPass returns the second order sections of a digital elliptic band pass filter, like
+// scipy.signal.ellip with btype='bandpass' and output='sos'.
+func DigitalSOSBandPass(order int, passBandRipple, stopBandRipple, lowThreshold, highThreshold, sampleRate float64) ([]BACoeffs, error) {
+	switch {
+	case order < 0:
+		return nil, fmt.Errorf("negative filter order %v", order)
+	case passBandRipple <= 0 || stopBandRipple <= 0:
+		return nil, fmt.Errorf("non-positive ripples %v and %v", passBandRipple, stopBandRipple)
+	case lowThreshold <= 0 || highThreshold <= lowThreshold:
+		return nil, fmt.Errorf("invalid pass band %v-%v Hz", lowThreshold, highThreshold)
+	case sampleRate < 2*highThreshold:
+		return nil, fmt.Errorf("pass band %v-%v Hz is above the Nyquist frequency of the sample rate %v", lowThreshold, highThreshold, sampleRate)
+	}
+	lowPass, err := AnalogPrototypeLowPass(order, passBandRipple, stopBandRipple)
+	if err != nil {
+		return nil, err
+	}
+	lowThresholdW := 2 * lowThreshold / sampleRate
+	highThresholdW := 2 * highThreshold / sampleRate
+	const sampleRateW = 2
+	lowThresholdWarped := 2 * sampleRateW * math.Tan(math.Pi*lowThresholdW/sampleRateW)
+	highThresholdWarped := 2 * sampleRateW * math.Tan(math.Pi*highThresholdW/sampleRateW)
+	bw := highThresholdWarped - lowThresholdWarped
+	wo := math.Sqrt(lowThresholdWarped * highThresholdWarped)
+	bandPass := AnalogBandPassFromLowPass(lowPass, wo, bw)
+	return sosSectionsFromZPK(DigitalBandPassFromAnalog(bandPass, sampleRateW)), nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zimt
+
+import "math"
+
+// Loudness contains the parameters to compute perceptual loudness according to ISO 226
+// (https://www.iso.org/standard/83117.html).
+//
+// The a_f, L_U, and T_f parameters of the ISO 226 conversion formula are computed for each frequency using the
+// parameterization described in `cpp/zimt/loudness_parameter_computation.ipynb`.
+type Loudness struct {
+	AFParams [10]float32
+	LUParams [16]float32
+	TFParams [13]float32
+}
+
+// DefaultLoudness returns the default loudness parameters.
+func DefaultLoudness() Loudness {
+	return Loudness{
+		AFParams: [10]float32{
+			9.64075296e-01, 8.76031085e-02, 1.04933605e+00, 7.21105886e+00,
+			1.02014870e+03, 1.58967888e-02, 5.06793363e+00, 1.33880326e+03,
+			1.39332233e-01, -3.86752752e+00,
+		},
+		LUParams: [16]float32{
+			1.04895312e+04, 1.16834373e+01, 4.63216422e+02, 1.07277873e+01,
+			9.33873976e-01, 5.79566363e-01, 1.06503907e+00, 1.93853475e+04,
+			4.63762437e-02, -4.36163544e+00, 1.50737510e+00, 7.94185866e-01,
+			8.70352919e-01, 2.12991220e+03, -2.62054739e-03, 2.63009217e-01,
+		},
+		TFParams: [13]float32{
+			-1.92510181e+02, -2.20827757e+01, 9.19748235e+01, 1.26594322e+01,
+			6.97360326e+00, 2.99022584e-02, 9.50394539e-01, -3.71694403e+01,
+			4.20769098e-02, 1.86764149e+00, 1.97954462e-07, -8.75703210e-03,
+			-3.64426652e+01,
+		},
+	}
+}
+
+// bump returns scale * p[0] * exp(width * p[1] * (hz - center * p[2])^2).
+func bump(hz, scale, width, center float64, p ...float32) float64 {
+	delta := hz - center*float64(p[2])
+	return scale * float64(p[0]) * math.Exp(width*float64(p[1])*delta*delta)
+}
+
+// logTerm returns p[1] * log(p[2] * (hz - p[3])).
+func logTerm(hz float64, p []float32) float64 {
+	return float64(p[1]) * math.Log((hz-float64(p[3]))*float64(p[2]))
+}
+
+// af returns the ISO 226 a_f parameter of the frequency.
+func (l Loudness) af(hz float64) float64 {
+	p := l.AFParams[:]
+	return float64(p[0]) - logTerm(hz, p) + bump(hz, 0.04, -0.0000001, 14000, p[4:7]...) - bump(hz, 0.03, -0.0000001, 5000, p[7:10]...)
+}
+
+// lu returns the ISO 226 L_U parameter of the frequency.
+func (l Loudness) lu(hz float64) float64 {
+	p := l.LUParams[:]
+	return float64(p[0]) + logTerm(hz, p) - bump(hz, 5, -0.00001, 1500, p[4:7]...) + bump(hz, 5, -0.000001, 3000, p[7:10]...) - bump(hz, 15, -0.0000001, 9000, p[10:13]...) - bump(hz, 5, -0.00000001, 12500, p[13:16]...)
+}
+
+// tf returns the ISO 226 T_f parameter of the frequency.
+func (l Loudness) tf(hz float64) float64 {
+	p := l.TFParams[:]
+	return float64(p[0]) + logTerm(hz, p) + bump(hz, 5, -0.00001, 1200, p[4:7]...) - bump(hz, 10, -0.0000001, 3300, p[7:10]...) + bump(hz, 20, -0.00000001, 12000, p[10:13]...)
+}
+
+// PhonsFromSPL returns the (num_steps, num_channels)-shaped intensity in Phons of the intensity in dB SPL.
+//
+// centersHz contains the center frequency of each channel.
+func (l Loudness) PhonsFromSPL(channelsDBSPL [][]float32, centersHz []float32) [][]float32 {
+	afs := make([]float32, len(centersHz))
+	lus := make([]float32, len(centersHz))
+	tfs := make([]float32, len(centersHz))
+	for channelIndex, hz := range centersHz {
+		afs[channelIndex] = float32(l.af(float64(hz)))
+		lus[channelIndex] = float32(l.lu(float64(hz)))
+		tfs[channelIndex] = float32(l.tf(float64(hz)))
+	}
+	result := make([][]float32, len(channelsDBSPL))
+	for stepIndex, step := range channelsDBSPL {
+		result[stepIndex] = make([]float32, len(step))
+		for channelIndex, db := range step {
+			af, lu := float64(afs[channelIndex]), float64(lus[channelIndex])
+			expf := func(x float32) float64 {
+				return math.Pow(0.4*math.Pow(10, (float64(x)+lu)*0.1-9), af)
+			}
+			bf := expf(db) - expf(tfs[channelIndex]) + 0.005135
+			result[stepIndex][channelIndex] = float32(40*math.Log10(bf) + 94)
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zimt
+
+import "math"
+
+// ToDb converts the linear energy values to dB in place, as
+// fullScaleSineDB + 10 * log10(energy + epsilon).
+func ToDb(energy [][]float32, fullScaleSineDB, epsilon float32) {
+	for _, step := range energy {
+		for channelIndex, value := range step {
+			step[channelIndex] = mulAdd(10, float32(math.Log10(float64(epsilon+value))), fullScaleSineDB)
+		}
+	}
+}
+
+// Masking contains the parameters of the auditory masking model.
+type Masking struct {
+	// LowerZeroAt20 is the negative distance in Cam at which a 20 dB masker will no longer mask any probe.
+	LowerZeroAt20 float32
+	// LowerZeroAt80 is the negative distance in Cam at which an 80 dB masker will no longer mask any probe.
+	LowerZeroAt80 float32
+	// UpperZeroAt20 is the positive distance in Cam at which a 20 dB masker will no longer mask any probe.
+	UpperZeroAt20 float32
+	// UpperZeroAt80 is the positive distance in Cam at which an 80 dB masker will no longer mask any probe.
+	UpperZeroAt80 float32
+	// MaxMask is the dB that a masker masks in the same band.
+	MaxMask float32
+}
+
+// DefaultMasking returns the default masking parameters.
+func DefaultMasking() Masking {
+	return Masking{
+		LowerZeroAt20: -4.1,
+		LowerZeroAt80: -6,
+		UpperZeroAt20: 3.1,
+		UpperZeroAt80: 9.6,
+		MaxMask:       17.7,
+	}
+}
+
+// fullMasking returns the dB that a masker at the level masks a probe the Cam distance above it.
+func (m Masking) fullMasking(maskerLevel, camDistance float32) float32 {
+	maskerLevelMinusMaxMask := maskerLevel - m.MaxMask
+	lowerZero := min(-0.1, m.LowerZeroAt20+maskerLevelMinusMaxMask*((m.LowerZeroAt80-m.LowerZeroAt20)/60))
+	lowerSlope := maskerLevelMinusMaxMask / -lowerZero
+	upperZero := max(0.1, m.UpperZeroAt20+maskerLevelMinusMaxMask*((m.UpperZeroAt80-m.UpperZeroAt20)/60))
+	upperSlope := maskerLevelMinusMaxMask / upperZero
+	return max(0, min(lowerSlope*(camDistance-lowerZero), upperSlope*(upperZero-camDistance)))
+}
+
+// CutFullyMasked returns the (num_steps, num_channels)-shaped dB energy after reducing the channels that are fully
+// masked by another channel by the masking.
+//
+// camDelta is the Cam width of each channel.
+func (m Masking) CutFullyMasked(energyDB [][]float32, camDelta float32) [][]float32 {
+	result := make([][]float32, len(energyDB))
+	for stepIndex, step := range energyDB {
+		result[stepIndex] = make([]float32, len(step))
+		for probeIndex, probeDB := range step {
+			// Starting at the smallest normal float32, like the C++ implementation starts at
+			// std::numeric_limits<float>::min().
+			maxMasked := float32(0x1p-126)
+			for maskerIndex, maskerDB := range step {
+				maxMasked = max(maxMasked, m.fullMasking(maskerDB, float32(probeIndex-maskerIndex)*camDelta))
+			}
+			if maxMasked > probeDB {
+				result[stepIndex][probeIndex] = probeDB - maxMasked
+			} else {
+				result[stepIndex][probeIndex] = probeDB
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zimt
+
+import (
+	"math"
+)
+
+// TimePair is a pair of time step indices where array A and array B are considered to match each other in time.
+type TimePair struct {
+	A int
+	B int
+}
+
+// windowMean returns the (num_steps, num_channels)-shaped array where each element is the mean of the zero-padded
+// stepWindow x channelWindow rectangle of preceding elements provided by the loader.
+//
+// The sums are computed from prefix sums in float32, like the C++ implementation.
+func windowMean(numSteps, numChannels, stepWindow, channelWindow int, loader func(stepIndex, channelIndex int) float32) [][]float32 {
+	// Prefix sums across the step axis.
+	prefix := newArray(numSteps, numChannels)
+	for stepIndex := range prefix {
+		for channelIndex := range prefix[stepIndex] {
+			prefix[stepIndex][channelIndex] = loader(stepIndex, channelIndex)
+			if stepIndex > 0 {
+				prefix[stepIndex][channelIndex] += prefix[stepIndex-1][channelIndex]
+			}
+		}
+	}
+	// Windowed sums across the step axis.
+	result := newArray(numSteps, numChannels)
+	for stepIndex := range result {
+		copy(result[stepIndex], prefix[stepIndex])
+		if stepIndex >= stepWindow {
+			for channelIndex := range result[stepIndex] {
+				result[stepIndex][channelIndex] -= prefix[stepIndex-stepWindow][channelIndex]
+			}
+		}
+	}
+	reciprocal := float32(1 / float64(stepWindow*channelWindow))
+	for stepIndex, step := range result {
+		// Prefix sums across the channel axis of the windowed sums across the step axis.
+		channelPrefix := prefix[stepIndex]
+		channelPrefix[0] = step[0]
+		for channelIndex := 1; channelIndex < numChannels; channelIndex++ {
+			channelPrefix[channelIndex] = channelPrefix[channelIndex-1] + step[channelIndex]
+		}
+		// Windowed sums across both axes, divided by the window size to make them means.
+		for channelIndex := range step {
+			step[channelIndex] = channelPrefix[channelIndex]
+			if channelIndex >= channelWindow {
+				step[channelIndex] -= channelPrefix[channelIndex-channelWindow]
+			}
+			step[channelIndex] *= reciprocal
+		}
+	}
+	return result
+}
+
+// WindowMean returns an array shaped like the (num_steps, num_channels)-shaped source, where each element is the
+// mean of the zero-padded stepWindow x channelWindow rectangle of preceding elements.
+func WindowMean(source [][]float32, stepWindow, channelWindow int) [][]float32 {
+	return windowMean(len(source), len(source[0]), stepWindow, channelWindow, func(stepIndex, channelIndex int) float32 {
+		return source[stepIndex][channelIndex]
+	})
+}
+
+// NSIM returns a slightly nonstandard version of the NSIM neural structural similarity metric between the
+// (num_steps, num_channels)-shaped arrays a and b.
+//
+// timePairs is the dynamic time warp between a and b.
+//
+// stepWindow and channelWindow are the number of time steps and channels over which to window the mean, standard
+// deviance, and covariance measures.
+//
+// See https://doi.org/10.1016/j.specom.2011.09.004 for details.
+func NSIM(a, b [][]float32, timePairs []TimePair, stepWindow, channelWindow int) float32 {
+	numChannels := len(a[0])
+	numSteps := len(timePairs)
+	valueA := func(stepIndex, channelIndex int) float32 { return a[timePairs[stepIndex].A][channelIndex] }
+	valueB := func(stepIndex, channelIndex int) float32 { return b[timePairs[stepIndex].B][channelIndex] }
+	meanA := windowMean(numSteps, numChannels, stepWindow, channelWindow, valueA)
+	meanB := windowMean(numSteps, numChannels, stepWindow, channelWindow, valueB)
+	// NB: This uses the mean computed for the window at the same position as the value, so that each value gets a
+	// different mean subtracted.
+	deltaA := func(stepIndex, channelIndex int) float32 {
+		return valueA(stepIndex, channelIndex) - meanA[stepIndex][channelIndex]
+	}
+	deltaB := func(stepIndex, channelIndex int) float32 {
+		return valueB(stepIndex, channelIndex) - meanB[stepIndex][channelIndex]
+	}
+	varA := windowMean(numSteps, numChannels, stepWindow, channelWindow, func(stepIndex, channelIndex int) float32 {
+		delta := deltaA(stepIndex, channelIndex)
+		return delta * delta
+	})
+	varB := windowMean(numSteps, numChannels, stepWindow, channelWindow, func(stepIndex, channelIndex int) float32 {
+		delta := deltaB(stepIndex, channelIndex)
+		return delta * delta
+	})
+	cov := windowMean(numSteps, numChannels, stepWindow, channelWindow, func(stepIndex, channelIndex int) float32 {
+		return deltaA(stepIndex, channelIndex) * deltaB(stepIndex, channelIndex)
+	})
+	const c1, c3 = float32(0.1), float32(0.1)
+	nsimSum := 0.0
+	for stepIndex := 0; stepIndex < numSteps; stepIndex++ {
+		for channelIndex := 0; channelIndex < numChannels; channelIndex++ {
+			ma, mb := meanA[stepIndex][channelIndex], meanB[stepIndex][channelIndex]
+			stdA := float32(math.Sqrt(float64(varA[stepIndex][channelIndex])))
+			stdB := float32(math.Sqrt(float64(varB[stepIndex][channelIndex])))
+			intensity := mulAdd(2, ma*mb, c1) / mulAdd(ma, ma, mulAdd(mb, mb, c1))
+			structure := (cov[stepIndex][channelIndex] + c3) / mulAdd(stdA, stdB, c3)
+			nsimSum += float64(intensity * structure)
+		}
+	}
+	return float32(nsimSum) / float32(numSteps*numChannels)
+}
+
+// deltaNorm returns the Euclidean distance between the vectors.
+func deltaNorm(a, b []float32) float32 {
+	sum := float32(0)
+	for index := range a {
+		delta := a[index] - b[index]
+		sum = mulAdd(delta, delta, sum)
+	}
+	return float32(math.Sqrt(float64(sum)))
+}
+
+// dtwSlice returns the dynamic time warp between the (num_steps, num_channels)-shaped arrays a and b.
+func dtwSlice(a, b [][]float32) []TimePair {
+	costs := newArray(len(a), len(b))
+	for aIndex := range costs {
+		for bIndex := range costs[aIndex] {
+			costs[aIndex][bIndex] = float32(math.Inf(1))
+		}
+	}
+	costs[0][0] = 0
+	for aIndex := 1; aIndex < len(a); aIndex++ {
+		for bIndex := 1; bIndex < len(b); bIndex++ {
+			costs[aIndex][bIndex] = deltaNorm(a[aIndex], b[bIndex]) + min(costs[aIndex-1][bIndex-1], costs[aIndex-1][bIndex], costs[aIndex][bIndex-1])
+		}
+	}
+	pos := TimePair{}
+	result := []TimePair{pos}
+	for pos.A+1 < len(a) && pos.B+1 < len(b) {
+		// Picks the first of the cheapest next positions, in the order diagonal, along a, along b.
+		next := TimePair{A: pos.A + 1, B: pos.B + 1}
+		for _, candidate := range []TimePair{{A: pos.A + 1, B: pos.B}, {A: pos.A, B: pos.B + 1}} {
+			if costs[candidate.A][candidate.B] < costs[next.A][next.B] {
+				next = candidate
+			}
+		}
+		pos = next
+		result = append(result, pos)
+	}
+	return result
+}
+
+// DTW returns the dynamic time warp between the (num_steps, num_channels)-shaped arrays a and b.
+func DTW(a, b [][]float32) []TimePair {
+	return dtwSlice(a, b)
+}
+
+// ChainDTW returns the dynamic time warp between the (num_steps, num_channels)-shaped arrays a and b, computed one
+// window of windowSize steps at a time to limit the memory and time used for long arrays.
+func ChainDTW(a, b [][]float32, windowSize int) []TimePair {
+	offset := TimePair{}
+	result := []TimePair{offset}
+	for offset.A+1 < len(a) && offset.B+1 < len(b) {
+		dtw := dtwSlice(a[offset.A:min(len(a), offset.A+windowSize)], b[offset.B:min(len(b), offset.B+windowSize)])
+		// If there is more than one entire window before reaching the end, then throw away the forward half of the
+		// DTW to allow a wider search outside what the last search ended at.
+		last := dtw[len(dtw)-1]
+		if len(a)-last.A-offset.A > windowSize && len(b)-last.B-offset.B > windowSize {
+			dtw = dtw[:len(dtw)/2]
+		}
+		// Don't add the start point of the window, it's already in the result.
+		for _, pair := range dtw[1:] {
+			result = append(result, TimePair{A: pair.A + offset.A, B: pair.B + offset.B})
+		}
+		offset = result[len(result)-1]
+	}
+	return result
+}
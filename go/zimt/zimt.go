@@ -0,0 +1,222 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zimt is a pure-Go reference implementation of the Zimtohrli front-end and distance in cpp/zimt.
+//
+// It follows the C++ implementation step by step, in float32 where it uses float, so that its distances match
+// those of the C++ library within a small tolerance. It doesn't use SIMD and is several times slower than the C++
+// library, and is meant for systems without a C++ toolchain, like CI systems running tests and small comparisons.
+// Build goohrli with -tags zimtohrli_purego to use it instead of the C++ library.
+package zimt
+
+import (
+	"fmt"
+	"math"
+)
+
+// newArray returns a (rows, columns)-shaped array backed by a single slice.
+func newArray(rows, columns int) [][]float32 {
+	data := make([]float32, rows*columns)
+	result := make([][]float32, rows)
+	for rowIndex := range result {
+		result[rowIndex] = data[rowIndex*columns : (rowIndex+1)*columns : (rowIndex+1)*columns]
+	}
+	return result
+}
+
+// mulAdd returns a * b + c rounded once, like the fused multiply-add the C++ implementation uses.
+func mulAdd(a, b, c float32) float32 {
+	return float32(math.FMA(float64(a), float64(b), float64(c)))
+}
+
+// Zimtohrli contains the parameters and filterbank used to analyze and compare signals.
+type Zimtohrli struct {
+	// CamFilterbank is the filterbank, which decides the sample rate of the signals.
+	CamFilterbank *CamFilterbank
+	// PerceptualSampleRate is the number of steps per second of the spectrograms.
+	PerceptualSampleRate float32
+	// NSIMStepWindow and NSIMChannelWindow are the window sizes of the NSIM computation.
+	NSIMStepWindow    int
+	NSIMChannelWindow int
+	// UnwarpWindowSeconds is the window of the dynamic time warp, or 0 to compare the spectrograms without time
+	// warping.
+	UnwarpWindowSeconds float32
+	// FullScaleSineDB is the assumed playback level, in dB SPL, of a sine wave with amplitude 1.
+	FullScaleSineDB float32
+	// Epsilon is added to the energy before converting it to dB.
+	Epsilon       float32
+	ApplyMasking  bool
+	ApplyLoudness bool
+	Masking       Masking
+	Loudness      Loudness
+}
+
+// Default returns a Zimtohrli with the default parameters, but without a filterbank.
+func Default() Zimtohrli {
+	return Zimtohrli{
+		PerceptualSampleRate: 100,
+		NSIMStepWindow:       16,
+		NSIMChannelWindow:    32,
+		UnwarpWindowSeconds:  2,
+		FullScaleSineDB:      78.3,
+		Epsilon:              1e-9,
+		ApplyMasking:         true,
+		ApplyLoudness:        true,
+		Masking:              DefaultMasking(),
+		Loudness:             DefaultLoudness(),
+	}
+}
+
+// New returns a Zimtohrli with the default parameters for signals with the sample rate, using a filterbank created
+// from the Cam parameters.
+func New(cam Cam, sampleRate float32) (*Zimtohrli, error) {
+	filterbank, err := cam.CreateFilterbank(sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	result := Default()
+	result.CamFilterbank = filterbank
+	return &result, nil
+}
+
+// Analysis contains the (num_steps, num_channels)-shaped results of analyzing a signal.
+type Analysis struct {
+	// EnergyChannelsDB is the energy of the channels, in dB SPL.
+	EnergyChannelsDB [][]float32
+	// PartialEnergyChannelsDB is the energy of the channels after applying masking, in dB SPL.
+	PartialEnergyChannelsDB [][]float32
+	// Spectrogram is the partial energy of the channels after converting to Phons.
+	Spectrogram [][]float32
+}
+
+// Analyze returns the analysis of the signal, which must not be empty.
+func (z *Zimtohrli) Analyze(signal []float32) (*Analysis, error) {
+	if len(signal) == 0 {
+		return nil, fmt.Errorf("empty signal")
+	}
+	numSteps := int(max(1, float32(math.Ceil(float64(float32(len(signal))*z.PerceptualSampleRate/z.CamFilterbank.SampleRate)))))
+	filter := z.CamFilterbank.Filter
+	// The energy is the mean square of each downscaling samples, and samples after the last whole step are ignored.
+	downscaling := len(signal) / numSteps
+	downscalingReciprocal := 1 / float32(downscaling)
+	energy := newArray(numSteps, filter.Size())
+	state := filter.NewState()
+	channels := make([]float32, filter.Size())
+	for sampleIndex, sample := range signal[:numSteps*downscaling] {
+		filter.Step(state, sample, channels)
+		step := energy[sampleIndex/downscaling]
+		for channelIndex, value := range channels {
+			step[channelIndex] = mulAdd(value, value, step[channelIndex])
+		}
+		if (sampleIndex+1)%downscaling == 0 {
+			for channelIndex := range step {
+				step[channelIndex] *= downscalingReciprocal
+			}
+		}
+	}
+	ToDb(energy, z.FullScaleSineDB, z.Epsilon)
+	result := &Analysis{
+		EnergyChannelsDB:        energy,
+		PartialEnergyChannelsDB: energy,
+		Spectrogram:             energy,
+	}
+	if z.ApplyMasking {
+		result.PartialEnergyChannelsDB = z.Masking.CutFullyMasked(energy, z.CamFilterbank.CamDelta)
+		result.Spectrogram = result.PartialEnergyChannelsDB
+	}
+	if z.ApplyLoudness {
+		result.Spectrogram = z.Loudness.PhonsFromSPL(result.PartialEnergyChannelsDB, z.CamFilterbank.ThresholdsHz[1])
+	}
+	return result, nil
+}
+
+// Distance returns the Zimtohrli distance between the (num_steps, num_channels)-shaped spectrograms.
+func (z *Zimtohrli) Distance(spectrogramA, spectrogramB [][]float32) (float32, error) {
+	if len(spectrogramA) == 0 || len(spectrogramB) == 0 {
+		return 0, fmt.Errorf("empty spectrogram")
+	}
+	if len(spectrogramA[0]) != len(spectrogramB[0]) {
+		return 0, fmt.Errorf("spectrograms with %v and %v channels", len(spectrogramA[0]), len(spectrogramB[0]))
+	}
+	var timePairs []TimePair
+	if z.UnwarpWindowSeconds != 0 {
+		timePairs = ChainDTW(spectrogramA, spectrogramB, int(z.UnwarpWindowSeconds*z.CamFilterbank.SampleRate))
+	} else {
+		if len(spectrogramA) != len(spectrogramB) {
+			return 0, fmt.Errorf("spectrograms with %v and %v steps can't be compared without time warping", len(spectrogramA), len(spectrogramB))
+		}
+		timePairs = make([]TimePair, len(spectrogramA))
+		for index := range timePairs {
+			timePairs[index] = TimePair{A: index, B: index}
+		}
+	}
+	// Since NSIM is a similarity measure, where 1.0 is "perfectly similar", it's subtracted from 1.0 to get a
+	// distance instead.
+	return 1 - NSIM(spectrogramA, spectrogramB, timePairs, min(len(spectrogramA), z.NSIMStepWindow), min(len(spectrogramA[0]), z.NSIMChannelWindow)), nil
+}
+
+// EnergyAndMaxAbsAmplitude contains the energy and maximum absolute amplitude of a signal.
+type EnergyAndMaxAbsAmplitude struct {
+	EnergyDBFS      float32
+	MaxAbsAmplitude float32
+}
+
+func energyDBFS(signal []float32) float32 {
+	energy := float32(0)
+	for _, sample := range signal {
+		energy += sample * sample
+	}
+	return float32(20 * math.Log10(float64(energy/float32(len(signal)))))
+}
+
+// Measure returns the energy in dB FS and the maximum absolute amplitude of the signal.
+func Measure(signal []float32) EnergyAndMaxAbsAmplitude {
+	result := EnergyAndMaxAbsAmplitude{EnergyDBFS: energyDBFS(signal)}
+	for _, sample := range signal {
+		result.MaxAbsAmplitude = max(result.MaxAbsAmplitude, float32(math.Abs(float64(sample))))
+	}
+	return result
+}
+
+// NormalizeAmplitude scales the signal in place to have the maximum absolute amplitude, and returns the new
+// energy in dB FS and maximum absolute amplitude.
+//
+// Signals where all samples are zero can't be scaled and are left as they are, where the C++ implementation fills
+// them with NaN.
+func NormalizeAmplitude(maxAbsAmplitude float32, signal []float32) EnergyAndMaxAbsAmplitude {
+	signalMaxAbsAmplitude := Measure(signal).MaxAbsAmplitude
+	if signalMaxAbsAmplitude == 0 {
+		return EnergyAndMaxAbsAmplitude{EnergyDBFS: energyDBFS(signal)}
+	}
+	scaling := maxAbsAmplitude / signalMaxAbsAmplitude
+	for index := range signal {
+		signal[index] *= scaling
+	}
+	return EnergyAndMaxAbsAmplitude{
+		EnergyDBFS:      energyDBFS(signal),
+		MaxAbsAmplitude: maxAbsAmplitude,
+	}
+}
+
+// mosParams are the parameters [a, b, c] of the sigmoid a / (b + exp(c * distance)) used by MOSFromZimtohrli.
+var mosParams = [3]float32{1.000e+00, -7.449e-09, 3.344e+00}
+
+func mosSigmoid(x float32) float32 {
+	return mosParams[0] / (mosParams[1] + float32(math.Exp(float64(mosParams[2]*x))))
+}
+
+// MOSFromZimtohrli returns an approximate mean opinion score for the Zimtohrli distance.
+func MOSFromZimtohrli(zimtohrliDistance float32) float32 {
+	return float32(1 + 4*float64(mosSigmoid(zimtohrliDistance))*float64(float32(1/float64(mosSigmoid(0)))))
+}
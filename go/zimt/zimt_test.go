@@ -0,0 +1,259 @@
+// Copyright 2024 The Zimtohrli Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zimt
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEllipticIntegral1(t *testing.T) {
+	// Golden values produced by scipy.special.ellipk.
+	for _, tc := range []struct {
+		m    float64
+		want float64
+	}{
+		{m: 0, want: 1.57079633},
+		{m: 0.25, want: 1.68575035},
+		{m: 0.5, want: 1.85407468},
+		{m: 0.99, want: 3.69563736},
+	} {
+		if got := EllipticIntegral1(tc.m); math.Abs(got-tc.want) > 1e-8 {
+			t.Errorf("EllipticIntegral1(%v) = %v, want %v", tc.m, got, tc.want)
+		}
+	}
+}
+
+func TestDigitalSOSBandPass(t *testing.T) {
+	// Golden values produced by scipy.signal.ellip(N=order, rp=passBandRipple, rs=stopBandRipple,
+	// Wn=[lowThreshold, highThreshold], btype='bandpass', analog=False, fs=sampleRate, output='sos').
+	for _, tc := range []struct {
+		order                                   int
+		passBandRipple, stopBandRipple          float64
+		lowThreshold, highThreshold, sampleRate float64
+		wantSections                            [][2][3]float64
+	}{
+		{
+			order:          3,
+			passBandRipple: 1,
+			stopBandRipple: 30,
+			lowThreshold:   20,
+			highThreshold:  21,
+			sampleRate:     48000,
+			wantSections: [][2][3]float64{
+				{{9.75081372e-06, 0.00000000e+00, -9.75081372e-06}, {1.00000000e+00, -1.99991956e+00, 9.99926757e-01}},
+				{{1.00000000e+00, -1.99999208e+00, 1.00000000e+00}, {1.00000000e+00, -1.99996493e+00, 9.99972482e-01}},
+				{{1.00000000e+00, -1.99999346e+00, 1.00000000e+00}, {1.00000000e+00, -1.99996692e+00, 9.99973776e-01}},
+			},
+		},
+		{
+			order:          4,
+			passBandRipple: 1,
+			stopBandRipple: 30,
+			lowThreshold:   20,
+			highThreshold:  21,
+			sampleRate:     48000,
+			wantSections: [][2][3]float64{
+				{{0.03162085, -0.06324145, 0.03162085}, {1, -1.99994199, 0.99994938}},
+				{{1, -1.99999368, 1}, {1, -1.99994368, 0.99995069}},
+				{{1, -1.99999233, 1}, {1, -1.99998132, 0.99998888}},
+				{{1, -1.99999325, 1}, {1, -1.99998256, 0.99998941}},
+			},
+		},
+		{
+			order:          2,
+			passBandRipple: 6,
+			stopBandRipple: 6,
+			lowThreshold:   1000,
+			highThreshold:  1500,
+			sampleRate:     4800,
+			wantSections: [][2][3]float64{
+				{{0.20665649, -0.15002595, 0.20665649}, {1, -0.41098111, 1}},
+				{{1, 0.95335468, 1}, {1, 0.66709753, 1}},
+			},
+		},
+		{
+			order:          3,
+			passBandRipple: 3,
+			stopBandRipple: 100,
+			lowThreshold:   10000,
+			highThreshold:  10100,
+			sampleRate:     24000,
+			wantSections: [][2][3]float64{
+				{{7.74075393e-06, 0.00000000e+00, -7.74075393e-06}, {1.00000000e+00, 1.73834064e+00, 9.92205921e-01}},
+				{{1.00000000e+00, 1.32825231e+00, 1.00000000e+00}, {1.00000000e+00, 1.72988548e+00, 9.96020922e-01}},
+				{{1.00000000e+00, 1.91028195e+00, 1.00000000e+00}, {1.00000000e+00, 1.75311014e+00, 9.96185302e-01}},
+			},
+		},
+	} {
+		sections, err := DigitalSOSBandPass(tc.order, tc.passBandRipple, tc.stopBandRipple, tc.lowThreshold, tc.highThreshold, tc.sampleRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sections) != len(tc.wantSections) {
+			t.Fatalf("DigitalSOSBandPass(%+v) returned %v sections, want %v", tc, len(sections), len(tc.wantSections))
+		}
+		for sectionIndex, section := range sections {
+			for coeffIndex := range tc.wantSections[sectionIndex][0] {
+				if got, want := section.B[coeffIndex], tc.wantSections[sectionIndex][0][coeffIndex]; math.Abs(got-want) > 1e-7 {
+					t.Errorf("DigitalSOSBandPass(%+v) section %v B[%v] = %v, want %v", tc, sectionIndex, coeffIndex, got, want)
+				}
+				if got, want := section.A[coeffIndex], tc.wantSections[sectionIndex][1][coeffIndex]; math.Abs(got-want) > 1e-7 {
+					t.Errorf("DigitalSOSBandPass(%+v) section %v A[%v] = %v, want %v", tc, sectionIndex, coeffIndex, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCam(t *testing.T) {
+	cam := DefaultCam()
+	for _, tc := range []struct {
+		cam, hz float32
+	}{
+		{cam: 1, hz: 25.995276471698844},
+		{cam: 10, hz: 442.29956714831576},
+		{cam: 20, hz: 1739.4974583218288},
+		{cam: 30, hz: 5543.983136917903},
+	} {
+		if got := cam.CamFromHz(tc.hz); math.Abs(float64(got-tc.cam)) > 1e-5 {
+			t.Errorf("CamFromHz(%v) = %v, want %v", tc.hz, got, tc.cam)
+		}
+		if got := cam.HzFromCam(tc.cam); math.Abs(float64(got-tc.hz)) > 1e-2 {
+			t.Errorf("HzFromCam(%v) = %v, want %v", tc.cam, got, tc.hz)
+		}
+	}
+	filterbank, err := cam.CreateFilterbank(48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impulse := make([]float32, 4800)
+	impulse[0] = 1
+	filtered := filterbank.Filter.Filter(impulse)
+	for channelIndex := 0; channelIndex < filterbank.Filter.Size(); channelIndex++ {
+		energy := 0.0
+		for _, step := range filtered {
+			energy += float64(step[channelIndex] * step[channelIndex])
+		}
+		if !(energy > 0) {
+			t.Errorf("channel %v has energy %v after filtering an impulse", channelIndex, energy)
+		}
+	}
+}
+
+func TestWindowMean(t *testing.T) {
+	source := [][]float32{
+		{0, 1, 2, 3, 4},
+		{5, 6, 7, 8, 9},
+		{10, 11, 12, 13, 14},
+		{15, 16, 17, 18, 19},
+		{20, 21, 22, 23, 24},
+	}
+	want := [][]float32{
+		{0, 1.0 / 9, 3.0 / 9, 6.0 / 9, 1},
+		{5.0 / 9, 12.0 / 9, 21.0 / 9, 3, 33.0 / 9},
+		{15.0 / 9, 33.0 / 9, 6, 7, 8},
+		{30.0 / 9, 7, 11, 12, 13},
+		{5, 93.0 / 9, 16, 17, 18},
+	}
+	got := WindowMean(source, 3, 3)
+	for stepIndex := range want {
+		for channelIndex := range want[stepIndex] {
+			if math.Abs(float64(got[stepIndex][channelIndex]-want[stepIndex][channelIndex])) > 1e-5 {
+				t.Errorf("WindowMean(...)[%v][%v] = %v, want %v", stepIndex, channelIndex, got[stepIndex][channelIndex], want[stepIndex][channelIndex])
+			}
+		}
+	}
+}
+
+func TestNSIM(t *testing.T) {
+	a := [][]float32{
+		{0, 1, 2, 3, 4},
+		{5, 6, 7, 8, 9},
+		{10, 11, 12, 13, 14},
+		{15, 16, 17, 18, 19},
+		{20, 21, 22, 23, 24},
+	}
+	b := [][]float32{
+		{5, 6, 7, 8, 9},
+		{10, 11, 12, 13, 14},
+		{15, 16, 17, 18, 19},
+		{20, 21, 22, 23, 24},
+		{25, 26, 27, 28, 29},
+	}
+	timePairs := []TimePair{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}}
+	if got := NSIM(a, b, timePairs, 3, 3); math.Abs(float64(got)-0.745816) > 1e-5 {
+		t.Errorf("NSIM(a, b, ...) = %v, want %v", got, 0.745816)
+	}
+	if got := NSIM(a, a, timePairs, 3, 3); math.Abs(float64(got)-1) > 1e-5 {
+		t.Errorf("NSIM(a, a, ...) = %v, want %v", got, 1)
+	}
+}
+
+func TestDTW(t *testing.T) {
+	a := [][]float32{{0}, {1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}}
+	b := [][]float32{{0}, {1}, {2}, {3}, {3}, {4}, {5}, {6}, {7.5}, {9}}
+	want := []TimePair{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {3, 4}, {4, 5}, {5, 6}, {6, 7}, {7, 8}, {8, 8}, {9, 9}}
+	if got := DTW(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("DTW(a, b) = %v, want %v", got, want)
+	}
+	if got := ChainDTW(a, b, 6); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChainDTW(a, b, 6) = %v, want %v", got, want)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	z, err := New(DefaultCam(), 48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sine := func(hz, amplitude float64) []float32 {
+		result := make([]float32, 4800)
+		for index := range result {
+			result[index] = float32(amplitude * math.Sin(2*math.Pi*hz*float64(index)/48000))
+		}
+		return result
+	}
+	analyze := func(signal []float32) [][]float32 {
+		analysis, err := z.Analyze(signal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return analysis.Spectrogram
+	}
+	reference := analyze(sine(1000, 0.5))
+	if got, want := len(reference), 10; got != want {
+		t.Errorf("got %v steps in the spectrogram of 0.1s, want %v", got, want)
+	}
+	if got, want := len(reference[0]), z.CamFilterbank.Filter.Size(); got != want {
+		t.Errorf("got %v channels in the spectrogram, want %v", got, want)
+	}
+	distance := func(spectrogram [][]float32) float32 {
+		result, err := z.Distance(reference, spectrogram)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if got := distance(reference); math.Abs(float64(got)) > 1e-6 {
+		t.Errorf("distance to itself is %v, want 0", got)
+	}
+	near := distance(analyze(sine(1000, 0.4)))
+	far := distance(analyze(sine(3000, 0.5)))
+	if !(near > 0 && near < far) {
+		t.Errorf("got distance %v to a quieter sine and %v to a sine of another frequency, want 0 < quieter < other", near, far)
+	}
+}